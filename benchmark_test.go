@@ -192,3 +192,43 @@ func BenchmarkFlowFastHashLong(b *testing.B) {
 		e.FastHash()
 	}
 }
+
+// BenchmarkPacketDecoder, BenchmarkPacketDecoderNewPacket and
+// BenchmarkPacketDecoderDecodingLayerParser decode the same packet, so
+// PacketDecoder can be compared against NewPacket and DecodingLayerParser in
+// one run.
+func BenchmarkPacketDecoder(b *testing.B) {
+	var tl testDecoderLayer
+	var payload Payload
+	dec := NewPacketDecoder(layerTypeTestDecoder, &tl, &payload)
+	data := []byte{0, 1, 2, 3, 4}
+	for i := 0; i < b.N; i++ {
+		dec.DecodeInto(data, CaptureInfo{})
+	}
+}
+func BenchmarkPacketDecoderNoCopy(b *testing.B) {
+	var tl testDecoderLayer
+	var payload Payload
+	dec := NewPacketDecoder(layerTypeTestDecoder, &tl, &payload)
+	dec.NoCopy = true
+	data := []byte{0, 1, 2, 3, 4}
+	for i := 0; i < b.N; i++ {
+		dec.DecodeInto(data, CaptureInfo{})
+	}
+}
+func BenchmarkPacketDecoderNewPacket(b *testing.B) {
+	data := []byte{0, 1, 2, 3, 4}
+	for i := 0; i < b.N; i++ {
+		NewPacket(data, layerTypeTestDecoder, Default)
+	}
+}
+func BenchmarkPacketDecoderDecodingLayerParser(b *testing.B) {
+	var tl testDecoderLayer
+	var payload Payload
+	parser := NewDecodingLayerParser(layerTypeTestDecoder, &tl, &payload)
+	data := []byte{0, 1, 2, 3, 4}
+	decoded := []LayerType{}
+	for i := 0; i < b.N; i++ {
+		parser.DecodeLayers(data, &decoded)
+	}
+}
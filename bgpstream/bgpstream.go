@@ -0,0 +1,157 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package bgpstream decodes the BGP messages of the byte streams of BGP
+// sessions, where messages may span many segments, or share them.
+//
+// A StreamFactory decodes the TCP streams reassembled by the reassembly
+// package, typically of the connections to port 179:
+//
+//  factory := bgpstream.NewStreamFactory(func(m *bgpstream.Message) {
+//    if m.BGP != nil && m.BGP.Type == layers.BGPTypeNotification {
+//      log.Println(m.Network, m.BGP.Content)
+//    }
+//  })
+//  assembler := reassembly.NewAssembler(reassembly.NewStreamPool(factory))
+//
+// The OPEN messages of a session are tracked, to tell whether its AS paths
+// carry 4 byte AS numbers and whether messages may exceed 4096 bytes.
+package bgpstream
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/internal/framedstream"
+	"github.com/google/gopacket/layers"
+)
+
+// Maximum lengths of messages, without and with the extended message
+// capability of RFC 8654.
+const (
+	maxMessageLength         = 4096
+	maxExtendedMessageLength = 65535
+)
+
+// Message is a BGP message decoded from a stream.
+type Message struct {
+	// Network and Transport are the flows of the message's direction, if
+	// known.
+	Network, Transport gopacket.Flow
+	// ToServer is whether the message was sent by the speaker which opened
+	// the connection.
+	ToServer bool
+	// Timestamp is the time the message's first byte was received.
+	Timestamp time.Time
+	// BGP is the decoded message, or nil if it couldn't be decoded, and Err
+	// the reason why.
+	BGP *layers.BGP
+	Err error
+	// AS4 is whether both speakers advertised the 4-octet AS number
+	// capability, AS numbers of AS_PATH attributes then being 4 bytes long:
+	// it's the as4 argument of BGPUpdate.ASPath.
+	AS4 bool
+}
+
+// Conn decodes the BGP messages of the two directions of a connection,
+// calling a handler with each.  Conns are not safe for concurrent use.
+type Conn struct {
+	handler func(*Message)
+	frames  *framedstream.Conn
+	// opens are the OPEN messages sent by the speaker which opened the
+	// connection and by the other one, if any.
+	opens [2]*layers.BGPOpen
+}
+
+// NewConn returns a Conn calling handler with its messages.
+func NewConn(handler func(*Message)) *Conn {
+	c := &Conn{handler: handler}
+	c.frames = framedstream.NewConn(layers.BGPHeaderLength, c.messageLength, c.decode)
+	return c
+}
+
+// Write decodes the data received at ts from the speaker which opened the
+// connection, if toServer, or from the other one, calling the handler with
+// the messages it completes.
+func (c *Conn) Write(toServer bool, data []byte, ts time.Time) {
+	c.frames.Write(toServer, data, ts)
+}
+
+// Lost tells c that data of a direction was lost.  Since the boundaries of
+// the following messages are unknown, the direction isn't decoded anymore.
+func (c *Conn) Lost(toServer bool) {
+	c.frames.Lost(toServer)
+}
+
+// both returns whether both speakers advertised a capability.
+func (c *Conn) both(code layers.BGPCapabilityCode) bool {
+	for _, open := range c.opens {
+		if open == nil {
+			return false
+		}
+		if _, ok := open.Capability(code); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// messageLength returns the length of the message with the given header,
+// or an error if it's invalid, the stream then being unable to
+// resynchronize.
+func (c *Conn) messageLength(toServer bool, header []byte) (int, error) {
+	n := int(binary.BigEndian.Uint16(header[16:]))
+	max := maxMessageLength
+	if c.both(layers.BGPCapabilityExtendedMessage) {
+		max = maxExtendedMessageLength
+	}
+	if !validMarker(header) || n < layers.BGPHeaderLength || n > max {
+		return 0, fmt.Errorf("invalid BGP message header %x", header)
+	}
+	return n, nil
+}
+
+// decode decodes the message of a frame, and calls the handler with it.
+func (c *Conn) decode(f *framedstream.Frame) {
+	m := &Message{
+		Network:   f.Network,
+		Transport: f.Transport,
+		ToServer:  f.ToServer,
+		Timestamp: f.Timestamp,
+		Err:       f.Err,
+	}
+	if f.Err == nil {
+		bgp := &layers.BGP{}
+		if err := bgp.DecodeFromBytes(f.Data, gopacket.NilDecodeFeedback); err != nil {
+			m.Err = err
+		} else {
+			m.BGP = bgp
+			if open, ok := bgp.Content.(layers.BGPOpen); ok {
+				c.opens[dirIndex(f.ToServer)] = &open
+			}
+		}
+		m.AS4 = c.both(layers.BGPCapabilityFourOctetAS)
+	}
+	c.handler(m)
+}
+
+func dirIndex(toServer bool) int {
+	if toServer {
+		return 0
+	}
+	return 1
+}
+
+func validMarker(header []byte) bool {
+	for _, b := range header[:16] {
+		if b != 0xff {
+			return false
+		}
+	}
+	return true
+}
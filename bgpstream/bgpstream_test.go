@@ -0,0 +1,153 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package bgpstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+// message returns a BGP message of type t with body.
+func message(t layers.BGPType, body ...byte) []byte {
+	msg := append(bytes.Repeat([]byte{0xff}, 16), 0, 0, byte(t))
+	msg = append(msg, body...)
+	binary.BigEndian.PutUint16(msg[16:], uint16(len(msg)))
+	return msg
+}
+
+// open returns an OPEN message of AS 4200000000, advertising the 4-octet
+// AS number capability if as4.
+func open(as4 bool) []byte {
+	body := []byte{4, 0x5b, 0xa0, 0, 90, 192, 0, 2, 1, 0}
+	if as4 {
+		body[9] = 8
+		body = append(body, 2, 6, 65, 4, 0xfa, 0x56, 0xea, 0x00)
+	}
+	return message(layers.BGPTypeOpen, body...)
+}
+
+func concat(parts ...[]byte) (data []byte) {
+	for _, p := range parts {
+		data = append(data, p...)
+	}
+	return
+}
+
+func TestConn(t *testing.T) {
+	var msgs []*Message
+	c := NewConn(func(m *Message) { msgs = append(msgs, m) })
+	start := time.Unix(1000, 0)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	keepalive := message(layers.BGPTypeKeepalive)
+	update := message(layers.BGPTypeUpdate, 0, 0, 0, 0, 24, 10, 0, 0)
+	// The OPEN split in its header, followed by a keepalive in the same
+	// write.
+	c.Write(true, open(true)[:10], at(0))
+	c.Write(true, concat(open(true)[10:], keepalive), at(1))
+	c.Write(false, concat(open(true), keepalive, update[:20]), at(2))
+	c.Write(false, update[20:], at(3))
+
+	want := []struct {
+		toServer bool
+		typ      layers.BGPType
+		ts       time.Time
+		as4      bool
+	}{
+		{true, layers.BGPTypeOpen, at(0), false},
+		{true, layers.BGPTypeKeepalive, at(1), false},
+		{false, layers.BGPTypeOpen, at(2), true},
+		{false, layers.BGPTypeKeepalive, at(2), true},
+		{false, layers.BGPTypeUpdate, at(2), true},
+	}
+	if len(msgs) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(msgs), len(want))
+	}
+	for i, w := range want {
+		m := msgs[i]
+		if m.BGP == nil || m.BGP.Type != w.typ || m.ToServer != w.toServer || !m.Timestamp.Equal(w.ts) || m.AS4 != w.as4 {
+			t.Errorf("message %d: got %+v", i, m)
+		}
+	}
+
+	// Nothing is decoded after an invalid header.
+	msgs = nil
+	c.Write(true, []byte("GET / HTTP/1.1\r\n\r\n"), at(4))
+	c.Write(true, keepalive, at(5))
+	if len(msgs) != 1 || msgs[0].Err == nil {
+		t.Errorf("got %d messages after invalid header", len(msgs))
+	}
+}
+
+type testContext gopacket.CaptureInfo
+
+func (c *testContext) GetCaptureInfo() gopacket.CaptureInfo {
+	return gopacket.CaptureInfo(*c)
+}
+
+func TestStreamFactory(t *testing.T) {
+	var msgs []*Message
+	assembler := reassembly.NewAssembler(reassembly.NewStreamPool(NewStreamFactory(func(m *Message) {
+		msgs = append(msgs, m)
+	})))
+
+	client, server := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}
+	start := time.Unix(1000, 0)
+	seq := map[bool]uint32{true: 100, false: 500}
+	send := func(toServer bool, flags string, payload []byte) {
+		ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: client, DstIP: server}
+		tcp := &layers.TCP{SrcPort: 40000, DstPort: 179, Seq: seq[toServer], Ack: seq[!toServer], Window: 1024, ACK: flags != "S"}
+		if !toServer {
+			ip.SrcIP, ip.DstIP = server, client
+			tcp.SrcPort, tcp.DstPort = 179, 40000
+		}
+		tcp.SYN = flags == "S" || flags == "SA"
+		tcp.PSH = len(payload) > 0
+		tcp.SetNetworkLayerForChecksum(ip)
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		if err := gopacket.SerializeLayers(buf, opts, ip, tcp, gopacket.Payload(payload)); err != nil {
+			t.Fatal(err)
+		}
+		p := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+		seq[toServer] += uint32(len(payload))
+		if tcp.SYN {
+			seq[toServer]++
+		}
+		start = start.Add(time.Millisecond)
+		ci := testContext{Timestamp: start}
+		assembler.AssembleWithContext(p.NetworkLayer().NetworkFlow(), p.TransportLayer().(*layers.TCP), &ci)
+	}
+
+	o := open(false)
+	send(true, "S", nil)
+	send(false, "SA", nil)
+	send(true, "A", o[:25])
+	send(true, "A", o[25:])
+	send(false, "A", concat(open(false), message(layers.BGPTypeNotification, 6, 2)))
+	assembler.FlushAll()
+
+	if len(msgs) != 3 {
+		t.Fatalf("got %d messages, want 3", len(msgs))
+	}
+	wantNet := gopacket.NewFlow(layers.EndpointIPv4, client.To4(), server.To4())
+	if m := msgs[0]; !m.ToServer || m.Network != wantNet || m.BGP == nil || !m.Timestamp.Equal(time.Unix(1000, 0).Add(3*time.Millisecond)) {
+		t.Errorf("got open %+v", m)
+	}
+	if m := msgs[2]; m.ToServer || m.Network != wantNet.Reverse() || m.BGP == nil || m.AS4 {
+		t.Errorf("got notification %+v", m)
+	} else if n, ok := m.BGP.Content.(layers.BGPNotification); !ok || n.ErrorCode != layers.BGPErrorCease {
+		t.Errorf("got notification %+v", m.BGP.Content)
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package bgpstream
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/internal/framedstream"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+// StreamFactory is a reassembly.StreamFactory decoding the BGP messages of
+// TCP streams.  Each message's timestamp is the one of the packet carrying
+// its first byte.  Decoding of a direction stops at the first gap in its
+// data, or at the first invalid message header, as when streams picked up
+// midstream don't start with a message.
+type StreamFactory struct {
+	handler func(*Message)
+}
+
+// NewStreamFactory returns a StreamFactory calling handler with the
+// messages of the streams it creates.  handler is called from the
+// goroutines assembling streams.
+func NewStreamFactory(handler func(*Message)) *StreamFactory {
+	return &StreamFactory{handler: handler}
+}
+
+// New creates a Stream, implementing reassembly.StreamFactory.
+func (f *StreamFactory) New(netFlow, tcpFlow gopacket.Flow, tcp *layers.TCP, ac reassembly.AssemblerContext) reassembly.Stream {
+	return framedstream.NewStream(NewConn(f.handler).frames, netFlow, tcpFlow)
+}
@@ -0,0 +1,299 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package decap strips tunnel encapsulations from packets, turning captures
+// of mirrored or tunneled traffic into captures of the inner packets.
+//
+// It handles GRE, ERSPAN Type II and III, VXLAN, Geneve, GTPv1-U, MPLS,
+// EtherIP, IP-in-IP and PPPoE, nested to any depth, as far as the layers
+// package decodes them.  Inner packets are given a single link type, so they
+// can be written to a pcap file or fed to the reassembly package, and
+// information about the stripped tunnels is added to their
+// CaptureInfo.AncillaryData:
+//
+//  src := decap.NewSource(handle, handle.LinkType(), decap.Options{})
+//  for {
+//    data, ci, err := src.ReadPacketData()
+//    ...
+//    for _, t := range decap.Tunnels(ci) {
+//      fmt.Println(t.Type, t.ID, t.Outer)
+//    }
+//  }
+//
+// Rewrite converts a whole pcap file.
+package decap
+
+import (
+	"io"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// Tunnel describes an encapsulation stripped from a packet.
+type Tunnel struct {
+	// Type is the layer type of the encapsulation, such as
+	// layers.LayerTypeVXLAN.  IP-in-IP tunnels have the type of the outer
+	// IP layer.
+	Type gopacket.LayerType
+	// ID identifies the tunnel: it's the VXLAN or Geneve VNI, GTP TEID,
+	// GRE key, ERSPAN session ID, MPLS label or PPPoE session ID, and zero
+	// for encapsulations without one.
+	ID uint32
+	// Outer is the network flow the encapsulation was carried in, between
+	// the tunnel endpoints.  It's empty if there's none, as with MPLS over
+	// Ethernet.
+	Outer gopacket.Flow
+}
+
+// Tunnels returns the tunnels stripped from a packet, outermost first, from
+// its AncillaryData.
+func Tunnels(ci gopacket.CaptureInfo) (tunnels []Tunnel) {
+	for _, a := range ci.AncillaryData {
+		if t, ok := a.(Tunnel); ok {
+			tunnels = append(tunnels, t)
+		}
+	}
+	return
+}
+
+// Options configures a Decapsulator.
+type Options struct {
+	// LinkType is the link type of inner packets, either
+	// layers.LinkTypeEthernet (the default) or layers.LinkTypeRaw.  With
+	// Ethernet, inner IP packets are given an Ethernet header with zero
+	// MAC addresses.  With Raw, the Ethernet header and VLAN tags of inner
+	// frames are removed, and frames not carrying IP are dropped.
+	LinkType layers.LinkType
+	// DropUntunneled drops packets without any encapsulation, instead of
+	// converting them to the inner link type.
+	DropUntunneled bool
+}
+
+// Decapsulator strips encapsulations from packets.  Create one with
+// NewDecapsulator.  It's safe for concurrent use.
+type Decapsulator struct {
+	decoder gopacket.Decoder
+	opts    Options
+}
+
+// NewDecapsulator creates a Decapsulator for packets decoded with the given
+// decoder, which is usually their link type.
+func NewDecapsulator(decoder gopacket.Decoder, opts Options) *Decapsulator {
+	if opts.LinkType != layers.LinkTypeRaw {
+		opts.LinkType = layers.LinkTypeEthernet
+	}
+	return &Decapsulator{decoder: decoder, opts: opts}
+}
+
+// LinkType returns the link type of the packets returned by the
+// Decapsulator.
+func (d *Decapsulator) LinkType() layers.LinkType {
+	return d.opts.LinkType
+}
+
+// Decapsulate decodes a packet and returns its inner packet, with a new
+// CaptureInfo.  ok is false if the packet should be dropped.  The returned
+// data doesn't share memory with the given data.
+func (d *Decapsulator) Decapsulate(data []byte, ci gopacket.CaptureInfo) (inner []byte, innerCI gopacket.CaptureInfo, ok bool) {
+	p := gopacket.NewPacket(data, d.decoder, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+	return d.decapsulate(p, ci)
+}
+
+// DecapsulatePacket is like Decapsulate, for an already decoded packet.
+func (d *Decapsulator) DecapsulatePacket(p gopacket.Packet) (inner []byte, innerCI gopacket.CaptureInfo, ok bool) {
+	return d.decapsulate(p, p.Metadata().CaptureInfo)
+}
+
+// tunnel returns the Tunnel a layer is, if any.  next is the type of the
+// following layer, and outer the last network flow before the layer.
+func tunnel(l gopacket.Layer, next gopacket.LayerType, outer gopacket.Flow) (Tunnel, bool) {
+	t := Tunnel{Type: l.LayerType(), Outer: outer}
+	switch l := l.(type) {
+	case *layers.GRE:
+		if l.KeyPresent {
+			t.ID = l.Key
+		}
+	case *layers.ERSPANII:
+		t.ID = uint32(l.SessionID)
+	case *layers.ERSPANIII:
+		t.ID = uint32(l.SessionID)
+	case *layers.VXLAN:
+		t.ID = l.VNI
+	case *layers.Geneve:
+		t.ID = l.VNI
+	case *layers.GTPv1U:
+		t.ID = l.TEID
+	case *layers.MPLS:
+		t.ID = l.Label
+	case *layers.PPPoE:
+		t.ID = uint32(l.SessionId)
+	case *layers.EtherIP:
+	case *layers.IPv4, *layers.IPv6:
+		if next != layers.LayerTypeIPv4 && next != layers.LayerTypeIPv6 {
+			return t, false
+		}
+	default:
+		return t, false
+	}
+	return t, true
+}
+
+func isInner(t gopacket.LayerType) bool {
+	return t == layers.LayerTypeEthernet || t == layers.LayerTypeIPv4 || t == layers.LayerTypeIPv6
+}
+
+// innermost finds the innermost packet: the index of its first layer, its
+// offset in the data, and the tunnels around it.
+func innermost(ls []gopacket.Layer) (index, offset int, tunnels []Tunnel) {
+	index = -1
+	var pending []Tunnel
+	var outer gopacket.Flow
+	pos := 0
+	for i, l := range ls {
+		pos += len(l.LayerContents())
+		if n, ok := l.(gopacket.NetworkLayer); ok {
+			outer = n.NetworkFlow()
+		}
+		if i+1 == len(ls) {
+			break
+		}
+		t, ok := tunnel(l, ls[i+1].LayerType(), outer)
+		if !ok {
+			continue
+		}
+		pending = append(pending, t)
+		j, end := i+1, pos
+		// PPPoE and GRE may carry PPP, which is part of the encapsulation.
+		if ls[j].LayerType() == layers.LayerTypePPP && j+1 < len(ls) {
+			end += len(ls[j].LayerContents())
+			j++
+		}
+		// Tunnels are only stripped if the inner packet was decoded, so
+		// MPLS stacks are stripped at once.
+		if isInner(ls[j].LayerType()) {
+			index, offset = j, end
+			tunnels = append(tunnels, pending...)
+			pending = pending[:0]
+		}
+	}
+	if index < 0 {
+		// Not tunneled: find the start of the packet anyway, to convert
+		// its link type.
+		pos = 0
+		for i, l := range ls {
+			if isInner(l.LayerType()) {
+				return i, pos, nil
+			}
+			pos += len(l.LayerContents())
+		}
+	}
+	return
+}
+
+func (d *Decapsulator) decapsulate(p gopacket.Packet, ci gopacket.CaptureInfo) ([]byte, gopacket.CaptureInfo, bool) {
+	ls := p.Layers()
+	index, offset, tunnels := innermost(ls)
+	if index < 0 || (len(tunnels) == 0 && d.opts.DropUntunneled) {
+		return nil, ci, false
+	}
+	var header []byte
+	switch first := ls[index].LayerType(); {
+	case d.opts.LinkType == layers.LinkTypeRaw && first == layers.LayerTypeEthernet:
+		// Skip the Ethernet header and any VLAN tags.
+		for index < len(ls) && (ls[index].LayerType() == layers.LayerTypeEthernet || ls[index].LayerType() == layers.LayerTypeDot1Q) {
+			offset += len(ls[index].LayerContents())
+			index++
+		}
+		if index == len(ls) || (ls[index].LayerType() != layers.LayerTypeIPv4 && ls[index].LayerType() != layers.LayerTypeIPv6) {
+			return nil, ci, false
+		}
+	case d.opts.LinkType == layers.LinkTypeEthernet && first != layers.LayerTypeEthernet:
+		header = make([]byte, 14)
+		t := layers.EthernetTypeIPv4
+		if first == layers.LayerTypeIPv6 {
+			t = layers.EthernetTypeIPv6
+		}
+		header[12], header[13] = byte(t>>8), byte(t)
+	}
+
+	data := p.Data()
+	l := ls[index]
+	end := offset + len(l.LayerContents()) + len(l.LayerPayload())
+	if end > len(data) {
+		end = len(data)
+	}
+	inner := make([]byte, 0, len(header)+end-offset)
+	inner = append(append(inner, header...), data[offset:end]...)
+
+	length := ci.Length
+	if length < len(data) {
+		length = len(data)
+	}
+	// The outer headers and trailers are gone, and the new header added.
+	length += len(header) - offset - (len(data) - end)
+	ci.CaptureLength, ci.Length = len(inner), length
+	ancillary := make([]interface{}, 0, len(ci.AncillaryData)+len(tunnels))
+	ancillary = append(ancillary, ci.AncillaryData...)
+	for _, t := range tunnels {
+		ancillary = append(ancillary, t)
+	}
+	ci.AncillaryData = ancillary
+	return inner, ci, true
+}
+
+// Source is a gopacket.PacketDataSource returning the inner packets of
+// another one.  Create one with NewSource.
+type Source struct {
+	*Decapsulator
+	src gopacket.PacketDataSource
+}
+
+// NewSource creates a Source stripping encapsulations from the packets of
+// src, decoded with the given decoder.
+func NewSource(src gopacket.PacketDataSource, decoder gopacket.Decoder, opts Options) *Source {
+	return &Source{Decapsulator: NewDecapsulator(decoder, opts), src: src}
+}
+
+// ReadPacketData returns the next inner packet.
+func (s *Source) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	for {
+		data, ci, err := s.src.ReadPacketData()
+		if err != nil {
+			return nil, ci, err
+		}
+		if inner, ci, ok := s.Decapsulate(data, ci); ok {
+			return inner, ci, nil
+		}
+	}
+}
+
+// Rewrite reads a pcap file, and writes the inner packets of its packets as a
+// pcap file of the inner link type.
+func Rewrite(w io.Writer, r io.Reader, opts Options) error {
+	pr, err := pcapgo.NewReader(r)
+	if err != nil {
+		return err
+	}
+	src := NewSource(pr, pr.LinkType(), opts)
+	pw := pcapgo.NewWriterNanos(w)
+	if err := pw.WriteFileHeader(pr.Snaplen(), src.LinkType()); err != nil {
+		return err
+	}
+	for {
+		data, ci, err := src.ReadPacketData()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := pw.WritePacket(ci, data); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package decap
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+var (
+	outerSrc = net.IP{10, 0, 0, 1}
+	outerDst = net.IP{10, 0, 0, 2}
+)
+
+func serialize(t *testing.T, ls ...gopacket.SerializableLayer) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ls...); err != nil {
+		t.Fatal(err)
+	}
+	return append([]byte(nil), buf.Bytes()...)
+}
+
+func outerEthernet(t layers.EthernetType) *layers.Ethernet {
+	return &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+		EthernetType: t,
+	}
+}
+
+func outerIPv4(p layers.IPProtocol) *layers.IPv4 {
+	return &layers.IPv4{Version: 4, TTL: 64, Protocol: p, SrcIP: outerSrc, DstIP: outerDst}
+}
+
+func outerUDP(port layers.UDPPort, ip *layers.IPv4) *layers.UDP {
+	udp := &layers.UDP{SrcPort: 50000, DstPort: port}
+	udp.SetNetworkLayerForChecksum(ip)
+	return udp
+}
+
+// innerIPv4 returns the layers of an inner IPv4 packet, and its bytes.
+func innerIPv4(t *testing.T) ([]gopacket.SerializableLayer, []byte) {
+	ip := &layers.IPv4{Version: 4, TTL: 32, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{192, 168, 0, 1}, DstIP: net.IP{192, 168, 0, 2}}
+	udp := &layers.UDP{SrcPort: 1234, DstPort: 5678}
+	udp.SetNetworkLayerForChecksum(ip)
+	ls := []gopacket.SerializableLayer{ip, udp, gopacket.Payload("inner payload")}
+	return ls, serialize(t, ls...)
+}
+
+// innerEthernet returns the layers of an inner Ethernet frame, and its bytes.
+func innerEthernet(t *testing.T) ([]gopacket.SerializableLayer, []byte) {
+	ls, _ := innerIPv4(t)
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 0xa},
+		DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 0xb},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ls = append([]gopacket.SerializableLayer{eth}, ls...)
+	return ls, serialize(t, ls...)
+}
+
+// ethernetIPv4Header is the header given to inner IPv4 packets.
+var ethernetIPv4Header = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x08, 0x00}
+
+func ipFlow() gopacket.Flow {
+	return gopacket.NewFlow(layers.EndpointIPv4, outerSrc, outerDst)
+}
+
+func TestDecapsulate(t *testing.T) {
+	ethLayers, ethBytes := innerEthernet(t)
+	ipLayers, ipBytes := innerIPv4(t)
+	withEthernet := append(append([]byte(nil), ethernetIPv4Header...), ipBytes...)
+	concat := func(outer []gopacket.SerializableLayer, inner []gopacket.SerializableLayer) []gopacket.SerializableLayer {
+		return append(append([]gopacket.SerializableLayer(nil), outer...), inner...)
+	}
+
+	ip := outerIPv4(layers.IPProtocolUDP)
+	vxlan := concat([]gopacket.SerializableLayer{
+		outerEthernet(layers.EthernetTypeIPv4), ip, outerUDP(4789, ip),
+		&layers.VXLAN{ValidIDFlag: true, VNI: 42},
+	}, ethLayers)
+
+	ip = outerIPv4(layers.IPProtocolUDP)
+	gtp := concat([]gopacket.SerializableLayer{
+		outerEthernet(layers.EthernetTypeIPv4), ip, outerUDP(2152, ip),
+		&layers.GTPv1U{Version: 1, ProtocolType: 1, MessageType: 255, MessageLength: uint16(len(ipBytes)), TEID: 0x1234},
+	}, ipLayers)
+
+	ip = outerIPv4(layers.IPProtocolUDP)
+	geneve := concat([]gopacket.SerializableLayer{
+		outerEthernet(layers.EthernetTypeIPv4), ip, outerUDP(6081, ip),
+		gopacket.Payload{0, 0, 0x65, 0x58, 0, 0, 7, 0},
+	}, ethLayers)
+
+	ip = outerIPv4(layers.IPProtocolGRE)
+	erspan := concat([]gopacket.SerializableLayer{
+		outerEthernet(layers.EthernetTypeIPv4), ip,
+		&layers.GRE{SeqPresent: true, Seq: 1, Protocol: layers.EthernetTypeERSPAN},
+		&layers.ERSPANII{Version: layers.ERSPANIIVersion, SessionID: 100},
+	}, ethLayers)
+
+	ip = outerIPv4(layers.IPProtocolGRE)
+	greKey := concat([]gopacket.SerializableLayer{
+		outerEthernet(layers.EthernetTypeIPv4), ip,
+		&layers.GRE{KeyPresent: true, Key: 77, Protocol: layers.EthernetTypeIPv4},
+	}, ipLayers)
+
+	mpls := concat([]gopacket.SerializableLayer{
+		outerEthernet(layers.EthernetTypeMPLSUnicast),
+		&layers.MPLS{Label: 16, TTL: 64},
+		&layers.MPLS{Label: 17, TTL: 64, StackBottom: true},
+	}, ipLayers)
+
+	ipip := concat([]gopacket.SerializableLayer{
+		outerEthernet(layers.EthernetTypeIPv4), outerIPv4(layers.IPProtocolIPv4),
+	}, ipLayers)
+
+	etherip := concat([]gopacket.SerializableLayer{
+		outerEthernet(layers.EthernetTypeIPv4), outerIPv4(layers.IPProtocolEtherIP),
+		gopacket.Payload{0x30, 0x00},
+	}, ethLayers)
+
+	pppoe := concat([]gopacket.SerializableLayer{
+		outerEthernet(layers.EthernetTypePPPoESession),
+		&layers.PPPoE{Version: 1, Type: 1, Code: layers.PPPoECodeSession, SessionId: 9},
+		&layers.PPP{PPPType: layers.PPPTypeIPv4},
+	}, ipLayers)
+
+	untunneled := concat([]gopacket.SerializableLayer{outerEthernet(layers.EthernetTypeIPv4)}, ipLayers)
+
+	for _, c := range []struct {
+		name    string
+		outer   []gopacket.SerializableLayer
+		inner   []byte
+		tunnels []Tunnel
+	}{
+		{"VXLAN", vxlan, ethBytes, []Tunnel{{layers.LayerTypeVXLAN, 42, ipFlow()}}},
+		{"GTP", gtp, withEthernet, []Tunnel{{layers.LayerTypeGTPv1U, 0x1234, ipFlow()}}},
+		{"Geneve", geneve, ethBytes, []Tunnel{{layers.LayerTypeGeneve, 7, ipFlow()}}},
+		{"ERSPAN", erspan, ethBytes, []Tunnel{{layers.LayerTypeGRE, 0, ipFlow()}, {layers.LayerTypeERSPANII, 100, ipFlow()}}},
+		{"GRE", greKey, withEthernet, []Tunnel{{layers.LayerTypeGRE, 77, ipFlow()}}},
+		{"MPLS", mpls, withEthernet, []Tunnel{{layers.LayerTypeMPLS, 16, gopacket.Flow{}}, {layers.LayerTypeMPLS, 17, gopacket.Flow{}}}},
+		{"IPIP", ipip, withEthernet, []Tunnel{{layers.LayerTypeIPv4, 0, ipFlow()}}},
+		{"EtherIP", etherip, ethBytes, []Tunnel{{layers.LayerTypeEtherIP, 0, ipFlow()}}},
+		{"PPPoE", pppoe, withEthernet, []Tunnel{{layers.LayerTypePPPoE, 9, gopacket.Flow{}}}},
+		// Untunneled Ethernet frames are unchanged.
+		{"untunneled", untunneled, serialize(t, untunneled...), nil},
+	} {
+		data := serialize(t, c.outer...)
+		ci := gopacket.CaptureInfo{
+			Timestamp:     time.Unix(1000, 0),
+			CaptureLength: len(data),
+			Length:        len(data) + 10,
+			AncillaryData: []interface{}{"orig"},
+		}
+		d := NewDecapsulator(layers.LinkTypeEthernet, Options{})
+		inner, innerCI, ok := d.Decapsulate(data, ci)
+		if !ok {
+			t.Errorf("%s: not decapsulated", c.name)
+			continue
+		}
+		if !bytes.Equal(inner, c.inner) {
+			t.Errorf("%s: got\n%x\nwant\n%x", c.name, inner, c.inner)
+		}
+		if innerCI.CaptureLength != len(inner) || innerCI.Length != len(inner)+10 || !innerCI.Timestamp.Equal(ci.Timestamp) {
+			t.Errorf("%s: got capture info %+v", c.name, innerCI)
+		}
+		if innerCI.AncillaryData[0] != "orig" {
+			t.Errorf("%s: lost ancillary data %v", c.name, innerCI.AncillaryData)
+		}
+		if got := Tunnels(innerCI); !reflect.DeepEqual(got, c.tunnels) {
+			t.Errorf("%s: got tunnels %v, want %v", c.name, got, c.tunnels)
+		}
+		if p := gopacket.NewPacket(inner, layers.LinkTypeEthernet, gopacket.Default); p.ErrorLayer() != nil {
+			t.Errorf("%s: inner packet doesn't decode: %v", c.name, p)
+		}
+
+		// Raw output strips Ethernet headers instead.
+		d = NewDecapsulator(layers.LinkTypeEthernet, Options{LinkType: layers.LinkTypeRaw, DropUntunneled: true})
+		inner, innerCI, ok = d.Decapsulate(data, ci)
+		if ok != (c.tunnels != nil) {
+			t.Errorf("%s: raw: got ok %v", c.name, ok)
+		} else if ok && (!bytes.Equal(inner, ipBytes) || innerCI.CaptureLength != len(ipBytes)) {
+			t.Errorf("%s: raw: got %x, capture info %+v", c.name, inner, innerCI)
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	ethLayers, ethBytes := innerEthernet(t)
+	ip := outerIPv4(layers.IPProtocolUDP)
+	vxlan := serialize(t, append([]gopacket.SerializableLayer{
+		outerEthernet(layers.EthernetTypeIPv4), ip, outerUDP(4789, ip),
+		&layers.VXLAN{ValidIDFlag: true, VNI: 42},
+	}, ethLayers...)...)
+
+	var in, out bytes.Buffer
+	w := pcapgo.NewWriter(&in)
+	w.WriteFileHeader(65536, layers.LinkTypeEthernet)
+	for i := 0; i < 3; i++ {
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(1000, int64(i)*1000), CaptureLength: len(vxlan), Length: len(vxlan)}
+		if err := w.WritePacket(ci, vxlan); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Rewrite(&out, &in, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := pcapgo.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.LinkType() != layers.LinkTypeEthernet {
+		t.Errorf("got link type %v", r.LinkType())
+	}
+	var n int
+	for {
+		data, ci, err := r.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, ethBytes) || !ci.Timestamp.Equal(time.Unix(1000, int64(n)*1000)) {
+			t.Errorf("packet %d: got %x at %v", n, data, ci.Timestamp)
+		}
+		n++
+	}
+	if n != 3 {
+		t.Errorf("got %d packets", n)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package dedup removes duplicate packets, such as the copies of a frame
+// delivered by a SPAN port mirroring both the ingress and egress of a switch.
+//
+// Like editcap's -D and -w options, packets are compared against those seen
+// in the last few packets or within a time window.  Unlike editcap, packets
+// are decoded first, so fields which typically differ between copies (VLAN
+// tags, TTLs, MAC addresses) can be ignored:
+//
+//  src := dedup.NewSource(handle, layers.LayerTypeEthernet, dedup.Options{
+//    Window: 10 * time.Millisecond,
+//    Ignore: dedup.VLAN | dedup.TTL,
+//  })
+//  packets := gopacket.NewPacketSource(src, layers.LayerTypeEthernet)
+package dedup
+
+import (
+	"container/list"
+	"crypto/md5"
+	"hash"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Field is a set of fields ignored when comparing packets.
+type Field uint8
+
+const (
+	// VLAN ignores 802.1Q and 802.1ad tags.
+	VLAN Field = 1 << iota
+	// TTL ignores the IPv4 TTL and IPv6 hop limit.  The IPv4 checksum is
+	// ignored with it, as it covers the TTL.
+	TTL
+	// IPChecksum ignores the IPv4 header checksum.
+	IPChecksum
+	// MACs ignores the Ethernet source and destination addresses.
+	MACs
+)
+
+// DefaultCount is the number of packets a packet is compared against when
+// neither Options.Count nor Options.Window are set, as in editcap.
+const DefaultCount = 5
+
+// Options configures a Filter.
+type Options struct {
+	// Count is the number of previous unique packets a packet is compared
+	// against.
+	Count int
+	// Window is how long after a packet duplicates of it are removed.  If
+	// both Count and Window are set, packets are forgotten when either
+	// limit is reached.
+	Window time.Duration
+	// Ignore is the set of fields ignored when comparing packets.
+	Ignore Field
+}
+
+// Stats counts the packets a Filter has seen.
+type Stats struct {
+	Packets, Duplicates int
+}
+
+type digest [md5.Size]byte
+
+type entry struct {
+	digest digest
+	ts     time.Time
+}
+
+// Filter finds duplicate packets.  Create one with NewFilter.  It's not safe
+// for concurrent use.
+type Filter struct {
+	decoder gopacket.Decoder
+	opts    Options
+	hash    hash.Hash
+	sum     digest
+	buf     []byte
+	// seen maps digests of recent unique packets to their element in
+	// order, which is oldest first.
+	seen  map[digest]*list.Element
+	order *list.List
+	stats Stats
+}
+
+// NewFilter creates a Filter decoding packets with the given decoder, which
+// is usually a link type.
+func NewFilter(decoder gopacket.Decoder, opts Options) *Filter {
+	if opts.Count <= 0 && opts.Window <= 0 {
+		opts.Count = DefaultCount
+	}
+	return &Filter{
+		decoder: decoder,
+		opts:    opts,
+		hash:    md5.New(),
+		seen:    make(map[digest]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Duplicate decodes a packet, and returns whether it duplicates a recent one.
+func (f *Filter) Duplicate(data []byte, ci gopacket.CaptureInfo) bool {
+	p := gopacket.NewPacket(data, f.decoder, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+	return f.duplicate(p, ci.Timestamp)
+}
+
+// DuplicatePacket returns whether an already decoded packet duplicates a
+// recent one.
+func (f *Filter) DuplicatePacket(p gopacket.Packet) bool {
+	return f.duplicate(p, p.Metadata().Timestamp)
+}
+
+func (f *Filter) duplicate(p gopacket.Packet, ts time.Time) bool {
+	f.stats.Packets++
+	f.expire(ts)
+	d := f.digest(p)
+	if _, ok := f.seen[d]; ok {
+		f.stats.Duplicates++
+		return true
+	}
+	f.seen[d] = f.order.PushBack(entry{d, ts})
+	if f.opts.Count > 0 && f.order.Len() > f.opts.Count {
+		f.remove(f.order.Front())
+	}
+	return false
+}
+
+// expire forgets packets older than the window.
+func (f *Filter) expire(now time.Time) {
+	if f.opts.Window <= 0 {
+		return
+	}
+	cutoff := now.Add(-f.opts.Window)
+	for e := f.order.Front(); e != nil && e.Value.(entry).ts.Before(cutoff); e = f.order.Front() {
+		f.remove(e)
+	}
+}
+
+func (f *Filter) remove(e *list.Element) {
+	delete(f.seen, f.order.Remove(e).(entry).digest)
+}
+
+// digest hashes a packet's bytes, leaving out the ignored fields.
+func (f *Filter) digest(p gopacket.Packet) digest {
+	f.hash.Reset()
+	ls := p.Layers()
+	for _, l := range ls {
+		contents := l.LayerContents()
+		switch l.LayerType() {
+		case layers.LayerTypeEthernet:
+			if len(contents) >= 14 {
+				contents = f.scratch(contents)
+				if f.opts.Ignore&MACs != 0 {
+					zero(contents[:12])
+				}
+				if f.opts.Ignore&VLAN != 0 {
+					// The EtherType is that of the first VLAN tag.
+					zero(contents[12:14])
+				}
+			}
+		case layers.LayerTypeDot1Q:
+			if f.opts.Ignore&VLAN != 0 {
+				continue
+			}
+		case layers.LayerTypeIPv4:
+			if len(contents) >= 20 && f.opts.Ignore&(TTL|IPChecksum) != 0 {
+				contents = f.scratch(contents)
+				if f.opts.Ignore&TTL != 0 {
+					contents[8] = 0
+				}
+				zero(contents[10:12])
+			}
+		case layers.LayerTypeIPv6:
+			if len(contents) >= 40 && f.opts.Ignore&TTL != 0 {
+				contents = f.scratch(contents)
+				contents[7] = 0
+			}
+		}
+		f.hash.Write(contents)
+	}
+	if len(ls) > 0 {
+		f.hash.Write(ls[len(ls)-1].LayerPayload())
+	}
+	f.hash.Sum(f.sum[:0])
+	return f.sum
+}
+
+// scratch copies b to a reusable buffer, so ignored fields can be cleared.
+func (f *Filter) scratch(b []byte) []byte {
+	f.buf = append(f.buf[:0], b...)
+	return f.buf
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// Stats returns the number of packets and duplicates seen.
+func (f *Filter) Stats() Stats {
+	return f.stats
+}
+
+// Source is a gopacket.PacketDataSource returning the packets of another one,
+// except duplicates.  Create one with NewSource.  It's not safe for concurrent
+// use.
+type Source struct {
+	*Filter
+	src gopacket.PacketDataSource
+}
+
+// NewSource creates a Source removing duplicates from src, whose packets are
+// decoded with the given decoder.
+func NewSource(src gopacket.PacketDataSource, decoder gopacket.Decoder, opts Options) *Source {
+	return &Source{Filter: NewFilter(decoder, opts), src: src}
+}
+
+// ReadPacketData returns the next packet which isn't a duplicate.
+func (s *Source) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	for {
+		data, ci, err := s.src.ReadPacketData()
+		if err != nil || !s.Duplicate(data, ci) {
+			return data, ci, err
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dedup
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+var t0 = time.Unix(1000, 0)
+
+type variant struct {
+	vlan    uint16
+	ttl     uint8
+	srcMAC  byte
+	payload string
+}
+
+func (v variant) serialize(t *testing.T) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, v.srcMAC},
+		DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 0xb},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      v.ttl,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.IP{10, 0, 0, 1},
+		DstIP:    net.IP{10, 0, 0, 2},
+	}
+	udp := &layers.UDP{SrcPort: 1000, DstPort: 2000}
+	udp.SetNetworkLayerForChecksum(ip)
+	ls := []gopacket.SerializableLayer{eth, ip, udp, gopacket.Payload(v.payload)}
+	if v.vlan != 0 {
+		eth.EthernetType = layers.EthernetTypeDot1Q
+		dot1q := &layers.Dot1Q{VLANIdentifier: v.vlan, Type: layers.EthernetTypeIPv4}
+		ls = append([]gopacket.SerializableLayer{eth, dot1q}, ls[1:]...)
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ls...); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+var base = variant{ttl: 64, srcMAC: 0xa, payload: "hello"}
+
+func TestIgnore(t *testing.T) {
+	tagged, lowTTL, otherMAC, otherPayload := base, base, base, base
+	tagged.vlan = 100
+	lowTTL.ttl = 63
+	otherMAC.srcMAC = 0xc
+	otherPayload.payload = "world"
+	for _, c := range []struct {
+		ignore Field
+		v      variant
+		dup    bool
+	}{
+		{0, base, true},
+		{0, tagged, false},
+		{0, lowTTL, false},
+		{0, otherMAC, false},
+		{VLAN, tagged, true},
+		{IPChecksum, lowTTL, false},
+		{TTL, lowTTL, true},
+		{TTL, tagged, false},
+		{MACs, otherMAC, true},
+		{VLAN | TTL | MACs, otherPayload, false},
+	} {
+		f := NewFilter(layers.LinkTypeEthernet, Options{Ignore: c.ignore})
+		if f.Duplicate(base.serialize(t), gopacket.CaptureInfo{Timestamp: t0}) {
+			t.Fatal("first packet is a duplicate")
+		}
+		if dup := f.Duplicate(c.v.serialize(t), gopacket.CaptureInfo{Timestamp: t0}); dup != c.dup {
+			t.Errorf("ignoring %b, %+v: got duplicate %v, want %v", c.ignore, c.v, dup, c.dup)
+		}
+		// The comparison is symmetric.
+		f = NewFilter(layers.LinkTypeEthernet, Options{Ignore: c.ignore})
+		f.Duplicate(c.v.serialize(t), gopacket.CaptureInfo{Timestamp: t0})
+		if dup := f.Duplicate(base.serialize(t), gopacket.CaptureInfo{Timestamp: t0}); dup != c.dup {
+			t.Errorf("ignoring %b, %+v reversed: got duplicate %v, want %v", c.ignore, c.v, dup, c.dup)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	payloads := []string{"a", "b", "c", "a", "d", "e", "a"}
+	for _, c := range []struct {
+		opts Options
+		dups []bool
+	}{
+		// Packets are 1ms apart.
+		{Options{Count: 2}, []bool{false, false, false, false, false, false, false}},
+		{Options{Count: 3}, []bool{false, false, false, true, false, false, false}},
+		{Options{Window: 3 * time.Millisecond}, []bool{false, false, false, true, false, false, false}},
+		{Options{Window: 10 * time.Millisecond}, []bool{false, false, false, true, false, false, true}},
+		{Options{Window: 10 * time.Millisecond, Count: 4}, []bool{false, false, false, true, false, false, false}},
+	} {
+		f := NewFilter(layers.LinkTypeEthernet, c.opts)
+		for i, payload := range payloads {
+			v := base
+			v.payload = payload
+			ci := gopacket.CaptureInfo{Timestamp: t0.Add(time.Duration(i) * time.Millisecond)}
+			if dup := f.Duplicate(v.serialize(t), ci); dup != c.dups[i] {
+				t.Errorf("%+v: packet %d: got duplicate %v", c.opts, i, dup)
+			}
+		}
+	}
+}
+
+type sliceSource [][]byte
+
+func (s *sliceSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if len(*s) == 0 {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+	data := (*s)[0]
+	*s = (*s)[1:]
+	return data, gopacket.CaptureInfo{Timestamp: t0, CaptureLength: len(data), Length: len(data)}, nil
+}
+
+func TestSource(t *testing.T) {
+	tagged := base
+	tagged.vlan = 10
+	other := base
+	other.payload = "other"
+	in := sliceSource{base.serialize(t), tagged.serialize(t), other.serialize(t), tagged.serialize(t)}
+	src := NewSource(&in, layers.LinkTypeEthernet, Options{Ignore: VLAN})
+	var n int
+	for {
+		_, _, err := src.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if n != 2 {
+		t.Errorf("got %d packets, want 2", n)
+	}
+	if s := src.Stats(); s.Packets != 4 || s.Duplicates != 2 {
+		t.Errorf("got stats %+v", s)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package dnsstream decodes the DNS messages carried by byte streams, such
+// as DNS over TCP, where each message is prefixed by its 2 byte length (RFC
+// 1035, section 4.2.2) and may span many segments, or share them.
+//
+// A StreamFactory decodes the TCP streams reassembled by the reassembly
+// package, typically of the connections to port 53:
+//
+//  factory := dnsstream.NewStreamFactory(func(m *dnsstream.Message) {
+//    if m.Query != nil {
+//      log.Println(m.Network, m.DNS.Questions, m.Latency)
+//    }
+//  }, dnsstream.Options{})
+//  assembler := reassembly.NewAssembler(reassembly.NewStreamPool(factory))
+//
+// Other plaintext streams with the same framing, such as DNS over TLS once
+// decrypted, are decoded by writing their data to a Conn.
+//
+// Responses are paired with the query of the same ID sent the other way on
+// the same connection, giving the latency between them.
+package dnsstream
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/internal/framedstream"
+	"github.com/google/gopacket/layers"
+)
+
+// DefaultMaxPending is the default Options.MaxPending.
+const DefaultMaxPending = 256
+
+// Options holds the options of Conns and StreamFactories.
+type Options struct {
+	// MaxPending is the maximum number of queries of a connection waiting
+	// for their response.  Queries beyond it aren't paired.  If zero,
+	// DefaultMaxPending is used.
+	MaxPending int
+}
+
+// Message is a DNS message decoded from a stream.
+type Message struct {
+	// Network and Transport are the flows of the message's direction, if
+	// known.
+	Network, Transport gopacket.Flow
+	// ToServer is whether the message was sent by the client.
+	ToServer bool
+	// Timestamp is the time the message's first byte was received.
+	Timestamp time.Time
+	// DNS is the decoded message, or nil if it couldn't be decoded, and Err
+	// the reason why.
+	DNS *layers.DNS
+	Err error
+	// Query is the query a response answers, if any, and Latency the time
+	// between their timestamps.
+	Query   *Message
+	Latency time.Duration
+}
+
+type pendingKey struct {
+	toServer bool
+	id       uint16
+}
+
+// Conn decodes the DNS messages of the two directions of a connection,
+// calling a handler with each.  Conns are not safe for concurrent use.
+type Conn struct {
+	handler func(*Message)
+	opts    Options
+	frames  *framedstream.Conn
+	pending map[pendingKey]*Message
+}
+
+// NewConn returns a Conn calling handler with its messages.
+func NewConn(handler func(*Message), opts Options) *Conn {
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = DefaultMaxPending
+	}
+	c := &Conn{handler: handler, opts: opts, pending: make(map[pendingKey]*Message)}
+	c.frames = framedstream.NewConn(2, messageLength, c.decode)
+	return c
+}
+
+// messageLength returns the length of a message and of its length prefix.
+func messageLength(toServer bool, header []byte) (int, error) {
+	return 2 + int(binary.BigEndian.Uint16(header)), nil
+}
+
+// Write decodes the data received at ts from the client, if toServer, or
+// from the server, calling the handler with the messages it completes.
+func (c *Conn) Write(toServer bool, data []byte, ts time.Time) {
+	c.frames.Write(toServer, data, ts)
+}
+
+// Lost tells c that data of a direction was lost.  Since the boundaries of
+// the following messages are unknown, the direction isn't decoded anymore.
+func (c *Conn) Lost(toServer bool) {
+	c.frames.Lost(toServer)
+}
+
+// decode decodes the message of a frame, and calls the handler with it.
+func (c *Conn) decode(f *framedstream.Frame) {
+	m := &Message{
+		Network:   f.Network,
+		Transport: f.Transport,
+		ToServer:  f.ToServer,
+		Timestamp: f.Timestamp,
+	}
+	dns := &layers.DNS{}
+	if err := dns.DecodeFromBytes(f.Data[2:], gopacket.NilDecodeFeedback); err != nil {
+		m.Err = err
+	} else {
+		m.DNS = dns
+		c.pair(m)
+	}
+	c.handler(m)
+}
+
+// pair tracks queries, and pairs responses with them.
+func (c *Conn) pair(m *Message) {
+	if !m.DNS.QR {
+		if len(c.pending) < c.opts.MaxPending {
+			c.pending[pendingKey{m.ToServer, m.DNS.ID}] = m
+		}
+		return
+	}
+	key := pendingKey{!m.ToServer, m.DNS.ID}
+	if q, ok := c.pending[key]; ok {
+		delete(c.pending, key)
+		m.Query = q
+		m.Latency = m.Timestamp.Sub(q.Timestamp)
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dnsstream
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+// framed returns the framed serialization of a DNS message.
+func framed(t *testing.T, id uint16, response bool, name string) []byte {
+	dns := &layers.DNS{
+		ID: id,
+		QR: response,
+		Questions: []layers.DNSQuestion{
+			{Name: []byte(name), Type: layers.DNSTypeA, Class: layers.DNSClassIN},
+		},
+	}
+	if response {
+		dns.Answers = []layers.DNSResourceRecord{
+			{Name: []byte(name), Type: layers.DNSTypeA, Class: layers.DNSClassIN, IP: net.IP{192, 0, 2, 1}},
+		}
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, dns); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 2+len(buf.Bytes()))
+	binary.BigEndian.PutUint16(data, uint16(len(buf.Bytes())))
+	copy(data[2:], buf.Bytes())
+	return data
+}
+
+func concat(parts ...[]byte) (data []byte) {
+	for _, p := range parts {
+		data = append(data, p...)
+	}
+	return
+}
+
+func TestConn(t *testing.T) {
+	var msgs []*Message
+	c := NewConn(func(m *Message) { msgs = append(msgs, m) }, Options{})
+	start := time.Unix(1000, 0)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	q1, q2 := framed(t, 1, false, "a.example"), framed(t, 2, false, "b.example")
+	r1, r2 := framed(t, 1, true, "a.example"), framed(t, 2, true, "b.example")
+	// Two pipelined queries sharing a write, the second one split.
+	c.Write(true, concat(q1, q2[:5]), at(0))
+	c.Write(true, q2[5:], at(1))
+	// Responses out of order, the length of the first one split.
+	c.Write(false, r2[:1], at(10))
+	c.Write(false, concat(r2[1:], r1[:len(r1)-1]), at(20))
+	c.Write(false, r1[len(r1)-1:], at(30))
+	// A message that doesn't decode.
+	c.Write(false, []byte{0, 3, 1, 2, 3}, at(40))
+
+	want := []struct {
+		toServer bool
+		id       uint16
+		ts       time.Time
+		latency  time.Duration
+	}{
+		{true, 1, at(0), 0},
+		{true, 2, at(0), 0},
+		{false, 2, at(10), 10 * time.Millisecond},
+		{false, 1, at(20), 20 * time.Millisecond},
+		{false, 0, at(40), 0},
+	}
+	if len(msgs) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(msgs), len(want))
+	}
+	for i, w := range want {
+		m := msgs[i]
+		if m.ToServer != w.toServer || !m.Timestamp.Equal(w.ts) || m.Latency != w.latency {
+			t.Errorf("message %d: got %+v", i, m)
+		}
+		if i == 4 {
+			if m.DNS != nil || m.Err == nil {
+				t.Errorf("message %d: got DNS %v, error %v", i, m.DNS, m.Err)
+			}
+			continue
+		}
+		if m.DNS == nil || m.DNS.ID != w.id {
+			t.Errorf("message %d: got DNS %v, error %v", i, m.DNS, m.Err)
+		}
+		if w.latency != 0 && (m.Query == nil || m.Query.DNS.ID != w.id) {
+			t.Errorf("message %d: got query %v", i, m.Query)
+		}
+	}
+
+	// Nothing is decoded after a loss.
+	msgs = nil
+	c.Write(true, q1[:3], at(50))
+	c.Lost(true)
+	c.Write(true, q1, at(60))
+	if len(msgs) != 0 {
+		t.Errorf("got %d messages after loss", len(msgs))
+	}
+}
+
+type testContext gopacket.CaptureInfo
+
+func (c *testContext) GetCaptureInfo() gopacket.CaptureInfo {
+	return gopacket.CaptureInfo(*c)
+}
+
+func TestStreamFactory(t *testing.T) {
+	var msgs []*Message
+	assembler := reassembly.NewAssembler(reassembly.NewStreamPool(NewStreamFactory(func(m *Message) {
+		msgs = append(msgs, m)
+	}, Options{})))
+
+	client, server := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}
+	start := time.Unix(1000, 0)
+	seq := map[bool]uint32{true: 100, false: 500}
+	send := func(toServer bool, flags string, payload []byte) {
+		ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: client, DstIP: server}
+		tcp := &layers.TCP{SrcPort: 40000, DstPort: 53, Seq: seq[toServer], Ack: seq[!toServer], Window: 1024, ACK: flags != "S"}
+		if !toServer {
+			ip.SrcIP, ip.DstIP = server, client
+			tcp.SrcPort, tcp.DstPort = 53, 40000
+		}
+		tcp.SYN = flags == "S" || flags == "SA"
+		tcp.PSH = len(payload) > 0
+		tcp.SetNetworkLayerForChecksum(ip)
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		if err := gopacket.SerializeLayers(buf, opts, ip, tcp, gopacket.Payload(payload)); err != nil {
+			t.Fatal(err)
+		}
+		p := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+		seq[toServer] += uint32(len(payload))
+		if tcp.SYN {
+			seq[toServer]++
+		}
+		start = start.Add(time.Millisecond)
+		ci := testContext{Timestamp: start}
+		assembler.AssembleWithContext(p.NetworkLayer().NetworkFlow(), p.TransportLayer().(*layers.TCP), &ci)
+	}
+
+	q, r := framed(t, 7, false, "example.com"), framed(t, 7, true, "example.com")
+	send(true, "S", nil)
+	send(false, "SA", nil)
+	send(true, "A", q[:10])
+	send(true, "A", q[10:])
+	send(false, "A", r)
+	assembler.FlushAll()
+
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages, want 2", len(msgs))
+	}
+	wantNet := gopacket.NewFlow(layers.EndpointIPv4, client.To4(), server.To4())
+	if m := msgs[0]; !m.ToServer || m.Network != wantNet || m.DNS == nil || m.DNS.QR || !m.Timestamp.Equal(time.Unix(1000, 0).Add(3*time.Millisecond)) {
+		t.Errorf("got query %+v", m)
+	}
+	if m := msgs[1]; m.ToServer || m.Network != wantNet.Reverse() || m.Query != msgs[0] || m.Latency != 2*time.Millisecond {
+		t.Errorf("got response %+v", m)
+	}
+}
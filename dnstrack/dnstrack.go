@@ -0,0 +1,368 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package dnstrack matches DNS queries with their responses, by client,
+// server, ID and question, and reports each resulting transaction: answered
+// ones with their round trip time, queries left unanswered for too long,
+// responses whose question doesn't match the query's (a sign of spoofing),
+// and responses to no query.
+//
+// Timeouts are measured against packet timestamps, so a pcap file is
+// processed the same way as live traffic:
+//
+//  tracker := dnstrack.NewTracker(dnstrack.Options{
+//    Timeout: 2 * time.Second,
+//    Report: func(t *dnstrack.Transaction) {
+//      log.Println(t)
+//    },
+//  })
+//  for packet := range source.Packets() {
+//    tracker.Add(packet)
+//  }
+//  tracker.Flush()
+//  log.Printf("%.2f%% NXDOMAIN", 100*tracker.Stats().NXDomainRate())
+//
+// Messages decoded otherwise, for example from TCP streams by the dnsstream
+// package, are added with AddDNS.
+//
+// A Tracker is not safe for concurrent use.
+package dnstrack
+
+import (
+	"container/list"
+	"fmt"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// DefaultTimeout is the default Options.Timeout.
+const DefaultTimeout = 5 * time.Second
+
+// Status is the outcome of a Transaction.
+type Status uint8
+
+const (
+	// StatusAnswered means a response with the query's question was seen.
+	StatusAnswered Status = iota
+	// StatusUnanswered means no response was seen within the timeout.
+	StatusUnanswered
+	// StatusMismatched means a response with the query's ID, but another
+	// question, was seen.  The query still waits for its response.
+	StatusMismatched
+	// StatusUnsolicited means a response to no pending query was seen.
+	StatusUnsolicited
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusAnswered:
+		return "Answered"
+	case StatusUnanswered:
+		return "Unanswered"
+	case StatusMismatched:
+		return "Mismatched"
+	case StatusUnsolicited:
+		return "Unsolicited"
+	}
+	return "Unknown"
+}
+
+// Transaction is a query and its response, as reported by a Tracker.
+type Transaction struct {
+	Status Status
+	// Key is the five-tuple of the query, from the client to the server.
+	Key gopacket.FiveTuple
+	ID  uint16
+	// Question is the first question of the query, or of the response of
+	// unsolicited transactions.
+	Question layers.DNSQuestion
+	// QueryTime is the time of the first query, and Retransmissions the
+	// number of identical queries seen after it while waiting.
+	QueryTime       time.Time
+	Retransmissions int
+	// ResponseTime is the time of the response, and RTT the time elapsed
+	// since QueryTime, for transactions with a response.
+	ResponseTime time.Time
+	RTT          time.Duration
+	// Query and Response are the messages, nil for unsolicited and
+	// unanswered transactions respectively.
+	Query, Response *layers.DNS
+}
+
+// ResponseCode returns the response code of the response, or NoErr if there
+// is none.
+func (t *Transaction) ResponseCode() layers.DNSResponseCode {
+	if t.Response == nil {
+		return layers.DNSResponseCodeNoErr
+	}
+	return t.Response.ResponseCode
+}
+
+// String returns a one line summary of the transaction, for logging.
+func (t *Transaction) String() string {
+	s := fmt.Sprintf("%v %v id=%d %s %v %v", t.Status, t.Key, t.ID, questionName(t.Question.Name), t.Question.Class, t.Question.Type)
+	if t.Response != nil {
+		s += fmt.Sprintf(" rcode=%v answers=%d", t.Response.ResponseCode, len(t.Response.Answers))
+	}
+	if t.Query != nil && t.Response != nil {
+		s += fmt.Sprintf(" rtt=%v", t.RTT)
+	}
+	if t.Retransmissions > 0 {
+		s += fmt.Sprintf(" retransmissions=%d", t.Retransmissions)
+	}
+	if t.Status == StatusMismatched && len(t.Response.Questions) > 0 {
+		q := t.Response.Questions[0]
+		s += fmt.Sprintf(" response=%s %v %v", questionName(q.Name), q.Class, q.Type)
+	}
+	return s
+}
+
+func questionName(name []byte) string {
+	return string(name) + "."
+}
+
+// Stats counts what a Tracker saw.
+type Stats struct {
+	// Queries and Responses count the messages added, but not
+	// retransmissions, which are counted apart.
+	Queries, Responses, Retransmissions uint64
+	// Answered, Unanswered, Mismatched and Unsolicited count the reported
+	// transactions of each status.
+	Answered, Unanswered, Mismatched, Unsolicited uint64
+	// NXDomain counts the answered transactions with a NXDOMAIN response.
+	NXDomain uint64
+}
+
+// NXDomainRate returns the proportion of answered transactions whose
+// response is NXDOMAIN.
+func (s Stats) NXDomainRate() float64 {
+	if s.Answered == 0 {
+		return 0
+	}
+	return float64(s.NXDomain) / float64(s.Answered)
+}
+
+// Options configures a Tracker.
+type Options struct {
+	// Timeout is the time after which a query without response is reported
+	// unanswered.  If zero, DefaultTimeout is used.
+	Timeout time.Duration
+	// MaxPending bounds the number of queries waiting for their response.
+	// When it's reached, the oldest one is reported unanswered to make room
+	// for a new one.  If zero, there's no bound.
+	MaxPending int
+	// Report is called with every transaction.
+	Report func(*Transaction)
+}
+
+// idKey identifies the queries a response may answer.
+type idKey struct {
+	key gopacket.FiveTuple
+	id  uint16
+}
+
+// pendingKey identifies a query.
+type pendingKey struct {
+	idKey
+	name        string
+	typ         layers.DNSType
+	class       layers.DNSClass
+	hasQuestion bool
+}
+
+// Tracker matches queries and responses.  Create one with NewTracker.
+type Tracker struct {
+	opts    Options
+	pending map[pendingKey]*list.Element
+	// ids holds the pending queries of each idKey.
+	ids map[idKey][]*list.Element
+	// byTime orders pending transactions by QueryTime.
+	byTime *list.List
+	stats  Stats
+}
+
+// NewTracker creates a new Tracker.
+func NewTracker(opts Options) *Tracker {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	return &Tracker{
+		opts:    opts,
+		pending: make(map[pendingKey]*list.Element),
+		ids:     make(map[idKey][]*list.Element),
+		byTime:  list.New(),
+	}
+}
+
+// Stats returns the counters of the tracker.
+func (t *Tracker) Stats() Stats {
+	return t.stats
+}
+
+// Len returns the number of queries waiting for their response.
+func (t *Tracker) Len() int {
+	return t.byTime.Len()
+}
+
+// Add adds the DNS message of a packet, if any, using its metadata's
+// timestamp.
+func (t *Tracker) Add(p gopacket.Packet) {
+	dns, ok := p.Layer(layers.LayerTypeDNS).(*layers.DNS)
+	if !ok {
+		return
+	}
+	key, ok := gopacket.FiveTupleFromPacket(p)
+	if !ok {
+		return
+	}
+	t.AddDNS(key, p.Metadata().Timestamp, dns)
+}
+
+// AddDNS adds a DNS message, sent at ts with the five-tuple key.  Queries
+// whose timeout expired by ts are reported first.  The tracker keeps a
+// reference to dns.
+func (t *Tracker) AddDNS(key gopacket.FiveTuple, ts time.Time, dns *layers.DNS) {
+	t.Expire(ts)
+	if !dns.QR {
+		t.addQuery(key, ts, dns)
+		return
+	}
+	t.stats.Responses++
+	k := makePendingKey(key.Reverse(), dns)
+	if e, ok := t.pending[k]; ok {
+		tr := t.remove(k, e)
+		tr.Status = StatusAnswered
+		tr.Response = dns
+		tr.ResponseTime = ts
+		tr.RTT = ts.Sub(tr.QueryTime)
+		t.report(tr)
+		return
+	}
+	if ids := t.ids[k.idKey]; len(ids) > 0 {
+		// Report the query it was meant to answer, which still waits.
+		query := ids[0].Value.(*Transaction)
+		t.report(&Transaction{
+			Status:          StatusMismatched,
+			Key:             k.key,
+			ID:              k.id,
+			Question:        query.Question,
+			QueryTime:       query.QueryTime,
+			Retransmissions: query.Retransmissions,
+			ResponseTime:    ts,
+			RTT:             ts.Sub(query.QueryTime),
+			Query:           query.Query,
+			Response:        dns,
+		})
+		return
+	}
+	tr := &Transaction{
+		Status:       StatusUnsolicited,
+		Key:          k.key,
+		ID:           k.id,
+		ResponseTime: ts,
+		Response:     dns,
+	}
+	if len(dns.Questions) > 0 {
+		tr.Question = dns.Questions[0]
+	}
+	t.report(tr)
+}
+
+func makePendingKey(key gopacket.FiveTuple, dns *layers.DNS) pendingKey {
+	k := pendingKey{idKey: idKey{key, dns.ID}}
+	if len(dns.Questions) > 0 {
+		q := &dns.Questions[0]
+		k.name, k.typ, k.class, k.hasQuestion = string(q.Name), q.Type, q.Class, true
+	}
+	return k
+}
+
+func (t *Tracker) addQuery(key gopacket.FiveTuple, ts time.Time, dns *layers.DNS) {
+	k := makePendingKey(key, dns)
+	if e, ok := t.pending[k]; ok {
+		e.Value.(*Transaction).Retransmissions++
+		t.stats.Retransmissions++
+		return
+	}
+	t.stats.Queries++
+	if t.opts.MaxPending > 0 && t.byTime.Len() >= t.opts.MaxPending {
+		t.expire(t.byTime.Front())
+	}
+	tr := &Transaction{Key: key, ID: dns.ID, QueryTime: ts, Query: dns}
+	if k.hasQuestion {
+		tr.Question = dns.Questions[0]
+	}
+	e := t.byTime.PushBack(tr)
+	t.pending[k] = e
+	t.ids[k.idKey] = append(t.ids[k.idKey], e)
+}
+
+// remove removes a pending transaction.
+func (t *Tracker) remove(k pendingKey, e *list.Element) *Transaction {
+	t.byTime.Remove(e)
+	delete(t.pending, k)
+	ids := t.ids[k.idKey]
+	for i := range ids {
+		if ids[i] == e {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(t.ids, k.idKey)
+	} else {
+		t.ids[k.idKey] = ids
+	}
+	return e.Value.(*Transaction)
+}
+
+// expire reports a pending transaction unanswered.
+func (t *Tracker) expire(e *list.Element) {
+	tr := e.Value.(*Transaction)
+	t.remove(makePendingKey(tr.Key, tr.Query), e)
+	tr.Status = StatusUnanswered
+	t.report(tr)
+}
+
+func (t *Tracker) report(tr *Transaction) {
+	switch tr.Status {
+	case StatusAnswered:
+		t.stats.Answered++
+		if tr.Response.ResponseCode == layers.DNSResponseCodeNXDomain {
+			t.stats.NXDomain++
+		}
+	case StatusUnanswered:
+		t.stats.Unanswered++
+	case StatusMismatched:
+		t.stats.Mismatched++
+	case StatusUnsolicited:
+		t.stats.Unsolicited++
+	}
+	if t.opts.Report != nil {
+		t.opts.Report(tr)
+	}
+}
+
+// Expire reports the queries whose timeout expired by now unanswered.  It's
+// called by Add and AddDNS, and should be called when no message was added
+// for a while.
+func (t *Tracker) Expire(now time.Time) {
+	for e := t.byTime.Front(); e != nil; e = t.byTime.Front() {
+		if now.Sub(e.Value.(*Transaction).QueryTime) < t.opts.Timeout {
+			return
+		}
+		t.expire(e)
+	}
+}
+
+// Flush reports all the pending queries unanswered.
+func (t *Tracker) Flush() {
+	for e := t.byTime.Front(); e != nil; e = t.byTime.Front() {
+		t.expire(e)
+	}
+}
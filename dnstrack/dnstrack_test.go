@@ -0,0 +1,125 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dnstrack
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+var (
+	client = net.IP{10, 0, 0, 1}
+	server = net.IP{10, 0, 0, 2}
+	start  = time.Unix(1000, 0)
+)
+
+// packet returns a UDP packet sent at start+ms carrying a DNS message.
+func packet(t *testing.T, ms int, sport uint16, id uint16, response bool, name string, rcode layers.DNSResponseCode) gopacket.Packet {
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: client, DstIP: server}
+	udp := &layers.UDP{SrcPort: layers.UDPPort(sport), DstPort: 53}
+	if response {
+		ip.SrcIP, ip.DstIP = server, client
+		udp.SrcPort, udp.DstPort = 53, layers.UDPPort(sport)
+	}
+	udp.SetNetworkLayerForChecksum(ip)
+	dns := &layers.DNS{
+		ID:           id,
+		QR:           response,
+		ResponseCode: rcode,
+		Questions:    []layers.DNSQuestion{{Name: []byte(name), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip, udp, dns); err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+	p.Metadata().Timestamp = start.Add(time.Duration(ms) * time.Millisecond)
+	return p
+}
+
+func TestTracker(t *testing.T) {
+	var got []*Transaction
+	tracker := NewTracker(Options{
+		Timeout: time.Second,
+		Report:  func(tr *Transaction) { got = append(got, tr) },
+	})
+	noErr, nxDomain := layers.DNSResponseCodeNoErr, layers.DNSResponseCodeNXDomain
+	for _, p := range []gopacket.Packet{
+		packet(t, 0, 1000, 1, false, "a.example", noErr),
+		packet(t, 5, 1001, 2, false, "b.example", noErr),
+		packet(t, 10, 1002, 3, false, "c.example", noErr),
+		// A spoofed response, then the genuine one.
+		packet(t, 12, 1000, 1, true, "evil.example", noErr),
+		packet(t, 20, 1000, 1, true, "a.example", noErr),
+		packet(t, 25, 1001, 2, true, "b.example", nxDomain),
+		// A response on another port than the query.
+		packet(t, 30, 1003, 3, true, "c.example", noErr),
+		packet(t, 500, 1002, 3, false, "c.example", noErr),
+		// Expires the query of c.example.
+		packet(t, 1010, 1004, 4, false, "d.example", noErr),
+	} {
+		tracker.Add(p)
+	}
+	tracker.Flush()
+
+	want := []string{
+		"Mismatched 17:10.0.0.1:1000->10.0.0.2:53 id=1 a.example. IN A rcode=No Error answers=0 rtt=12ms response=evil.example. IN A",
+		"Answered 17:10.0.0.1:1000->10.0.0.2:53 id=1 a.example. IN A rcode=No Error answers=0 rtt=20ms",
+		"Answered 17:10.0.0.1:1001->10.0.0.2:53 id=2 b.example. IN A rcode=Non-Existent Domain answers=0 rtt=20ms",
+		"Unsolicited 17:10.0.0.1:1003->10.0.0.2:53 id=3 c.example. IN A rcode=No Error answers=0",
+		"Unanswered 17:10.0.0.1:1002->10.0.0.2:53 id=3 c.example. IN A retransmissions=1",
+		"Unanswered 17:10.0.0.1:1004->10.0.0.2:53 id=4 d.example. IN A",
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d transactions, want %d", len(got), len(want))
+	}
+	for i := 0; i < len(got) && i < len(want); i++ {
+		if s := got[i].String(); s != want[i] {
+			t.Errorf("transaction %d: got %q, want %q", i, s, want[i])
+		}
+	}
+	if tr := got[1]; tr.Query == nil || tr.Response == nil || !tr.QueryTime.Equal(start) || !tr.ResponseTime.Equal(start.Add(20*time.Millisecond)) {
+		t.Errorf("got %+v", tr)
+	}
+
+	wantStats := Stats{
+		Queries: 4, Responses: 4, Retransmissions: 1,
+		Answered: 2, Unanswered: 2, Mismatched: 1, Unsolicited: 1,
+		NXDomain: 1,
+	}
+	if s := tracker.Stats(); s != wantStats {
+		t.Errorf("got stats %+v, want %+v", s, wantStats)
+	}
+	if r := tracker.Stats().NXDomainRate(); r != 0.5 {
+		t.Errorf("got NXDOMAIN rate %v", r)
+	}
+	if tracker.Len() != 0 {
+		t.Errorf("%d queries still pending", tracker.Len())
+	}
+}
+
+func TestTrackerMaxPending(t *testing.T) {
+	var got []*Transaction
+	tracker := NewTracker(Options{
+		MaxPending: 2,
+		Report:     func(tr *Transaction) { got = append(got, tr) },
+	})
+	for i := 0; i < 3; i++ {
+		tracker.Add(packet(t, i, 1000, uint16(i), false, "example.com", layers.DNSResponseCodeNoErr))
+	}
+	if len(got) != 1 || got[0].Status != StatusUnanswered || got[0].ID != 0 {
+		t.Errorf("got %v", got)
+	}
+	if tracker.Len() != 2 {
+		t.Errorf("got %d pending queries, want 2", tracker.Len())
+	}
+}
@@ -232,6 +232,16 @@ hash as B->A.  An example usage could be:
 This allows us to split up a packet stream while still making sure that each
 stream sees all packets for a flow (and its bidirectional opposite).
 
+To key connections rather than host pairs, FiveTuple combines the network flow,
+the IP protocol and the transport flow (ports, or ICMP query identifiers) of a
+packet.  Its Canonical method orders both directions of a connection the same
+way, and its FastHash is symmetric too:
+
+ if ft, ok := gopacket.FiveTupleFromPacket(packet); ok {
+   key, reversed := ft.Canonical()
+   conns[key].add(packet, reversed)
+ }
+
 
 Implementing Your Own Decoder
 
@@ -382,6 +392,41 @@ subpackage to further examine usage examples and performance measurements.
 You may also choose to implement your own DecodingLayerContainer if you want to
 make use of your own internal packet decoding logic.
 
+DecodingLayerParser containers normally hold a single DecodingLayer per layer
+type, so a tunneled packet (VXLAN, Geneve, GRE, IP-in-IP...) with an inner
+Ethernet or IP header would overwrite its outer header.  DecodingLayerStack
+instead holds several DecodingLayers per layer type, used outermost first:
+
+ dls := &gopacket.DecodingLayerStack{}
+ dlp := gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet)
+ dlp.SetDecodingLayerContainer(dls)
+ dlp.AddDecodingLayer(&outerEth)
+ dlp.AddDecodingLayer(&outerIP)
+ dlp.AddDecodingLayer(&udp)
+ dlp.AddDecodingLayer(&vxlan)
+ dlp.AddDecodingLayer(&innerEth)
+ dlp.AddDecodingLayer(&innerIP)
+ // ... after DecodeLayers, dls.Depth(layers.LayerTypeIPv4) tells whether
+ // innerIP was filled in.
+
+Reusable Packets With PacketDecoder
+
+PacketDecoder sits between NewPacket and DecodingLayerParser.  Like
+DecodingLayerParser, it decodes into a fixed set of preallocated
+DecodingLayers, but it returns a full Packet (with Layer, LayerClass,
+NetworkLayer, ErrorLayer, and friends) that is reused on every call:
+
+ dec := gopacket.NewPacketDecoder(layers.LayerTypeEthernet, &eth, &ip4, &tcp, &payload)
+ for packetData := range somehowGetPacketData() {
+   packet := dec.DecodeInto(packetData, gopacket.CaptureInfo{})
+   if err := packet.ErrorLayer(); err != nil {
+     fmt.Println("Error decoding some part of the packet:", err)
+   }
+ }
+
+Layer types without a DecodingLayer fall back to their normal (allocating)
+decoders.  The returned packet is only valid until the next DecodeInto call.
+
 Creating Packet Data
 
 As well as offering the ability to decode packet data, gopacket will allow you
@@ -0,0 +1,143 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package gopacket
+
+import (
+	"fmt"
+)
+
+// IPProtocolLayer is implemented by layers which carry the IP protocol number
+// of the header that follows them, such as IPv4, IPv6 and IPv6 extension
+// headers.
+type IPProtocolLayer interface {
+	Layer
+	// NextIPProtocol returns the IP protocol number of the next header.
+	NextIPProtocol() uint8
+}
+
+// FiveTupleLayer is implemented by layers which identify a conversation
+// between two network endpoints, such as TCP and UDP (by port) or ICMP queries
+// (by identifier).
+type FiveTupleLayer interface {
+	Layer
+	// FiveTupleProtocol returns the IP protocol number of this layer.
+	FiveTupleProtocol() uint8
+	// FiveTupleFlow returns the flow identifying the conversation, or false
+	// if this layer has nothing to identify it by (for example, ICMP error
+	// messages).
+	FiveTupleFlow() (Flow, bool)
+}
+
+// FiveTuple is a connection key made of the network flow, the IP protocol
+// number, and the transport flow (ports, ICMP identifiers, etc.) of a packet.
+// Protocols without such identifiers leave Transport as the zero Flow.
+//
+// FiveTuples are usable as map keys.  Since a FiveTuple is directional, use
+// Canonical to key both directions of a conversation identically.
+type FiveTuple struct {
+	// Protocol is the IP protocol number.
+	Protocol uint8
+	// Network is the flow between the network endpoints.
+	Network Flow
+	// Transport is the flow between the transport endpoints, or the zero Flow
+	// if the protocol doesn't have any.
+	Transport Flow
+}
+
+// NewFiveTuple creates a new FiveTuple.
+func NewFiveTuple(protocol uint8, network, transport Flow) FiveTuple {
+	return FiveTuple{Protocol: protocol, Network: network, Transport: transport}
+}
+
+// FiveTupleFromPacket creates a FiveTuple from the layers of a packet.  See
+// FiveTupleFromLayers.
+func FiveTupleFromPacket(p Packet) (FiveTuple, bool) {
+	return FiveTupleFromLayers(p.Layers()...)
+}
+
+// FiveTupleFromLayers creates a FiveTuple from a set of decoded layers, as
+// returned by Packet.Layers.  The network flow comes from the first
+// NetworkLayer.  The protocol comes from the last IPProtocolLayer (so IPv6
+// extension headers are skipped over), or from the first FiveTupleLayer
+// following the network layer, which also provides the transport flow.
+// Layers after a second NetworkLayer, such as those of a tunneled packet, are
+// ignored.
+//
+// It returns false if there's no NetworkLayer.
+func FiveTupleFromLayers(layers ...Layer) (t FiveTuple, ok bool) {
+	for _, l := range layers {
+		if n, isNet := l.(NetworkLayer); isNet {
+			if ok {
+				break
+			}
+			t.Network = n.NetworkFlow()
+			ok = true
+		}
+		if !ok {
+			continue
+		}
+		if f, isFive := l.(FiveTupleLayer); isFive {
+			t.Protocol = f.FiveTupleProtocol()
+			if flow, hasFlow := f.FiveTupleFlow(); hasFlow {
+				t.Transport = flow
+			}
+			break
+		}
+		if p, isProto := l.(IPProtocolLayer); isProto {
+			t.Protocol = p.NextIPProtocol()
+		}
+	}
+	return
+}
+
+// Reverse returns the FiveTuple of the opposite direction.
+func (t FiveTuple) Reverse() FiveTuple {
+	return FiveTuple{Protocol: t.Protocol, Network: t.Network.Reverse(), Transport: t.Transport.Reverse()}
+}
+
+// Canonical returns the FiveTuple with its endpoints in a canonical order, so
+// that both directions of a conversation yield the same value, along with
+// whether t had to be reversed to get there.  Endpoints are ordered by their
+// network endpoints first, then by their transport endpoints.
+func (t FiveTuple) Canonical() (FiveTuple, bool) {
+	nsrc, ndst := t.Network.Endpoints()
+	if ndst.LessThan(nsrc) {
+		return t.Reverse(), true
+	}
+	if nsrc == ndst {
+		if tsrc, tdst := t.Transport.Endpoints(); tdst.LessThan(tsrc) {
+			return t.Reverse(), true
+		}
+	}
+	return t, false
+}
+
+// FastHash provides a quick hashing function for a FiveTuple, useful for
+// splitting up conversations by modulos or other load-balancing techniques.
+// Like Flow.FastHash, it's symmetric: a FiveTuple and its Reverse have the
+// same hash.
+//
+// The output of FastHash is not guaranteed to remain the same through future
+// code revisions, so should not be used to key values in persistent storage.
+func (t FiveTuple) FastHash() (h uint64) {
+	h = t.Network.FastHash()
+	h ^= t.Transport.FastHash()
+	h ^= uint64(t.Protocol)
+	h *= fnvPrime
+	return
+}
+
+// String returns a human-readable representation of this FiveTuple, in the
+// form "Protocol:NetworkSrc:TransportSrc->NetworkDst:TransportDst".
+func (t FiveTuple) String() string {
+	nsrc, ndst := t.Network.Endpoints()
+	if t.Transport == (Flow{}) {
+		return fmt.Sprintf("%d:%v->%v", t.Protocol, nsrc, ndst)
+	}
+	tsrc, tdst := t.Transport.Endpoints()
+	return fmt.Sprintf("%d:%v:%v->%v:%v", t.Protocol, nsrc, tsrc, ndst, tdst)
+}
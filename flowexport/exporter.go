@@ -0,0 +1,582 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package flowexport aggregates packets into flows and exports them as
+// NetFlow v5, NetFlow v9 or IPFIX records, like a probe such as nfprobe or
+// softflowd would.  Export packets can be sent to a collector over UDP, or
+// written to any io.Writer.
+//
+// Flows are tracked with a flowtable.Table, and each direction of a flow is
+// exported as its own record, as NetFlow flows are unidirectional.  Only IP
+// flows are exported (and only IPv4 ones with NetFlow v5).  Byte counts are
+// those of the IP packets, as NetFlow expects.
+//
+// A typical usage looks like:
+//
+//  exp, err := flowexport.NewUDPExporter("collector:2055", flowexport.Options{
+//    Version:       flowexport.IPFIX,
+//    IdleTimeout:   15 * time.Second,
+//    ActiveTimeout: 30 * time.Minute,
+//  })
+//  if err != nil {
+//    ...
+//  }
+//  defer exp.Close()
+//  for packet := range source.Packets() {
+//    if err := exp.Add(packet); err != nil {
+//      ...
+//    }
+//  }
+//
+// Timeouts are measured against packet timestamps, so with live captures you
+// should call Expire periodically, since expired flows are otherwise only
+// exported when the next packet arrives.
+//
+// An Exporter is not safe for concurrent use.
+package flowexport
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/flowtable"
+	"github.com/google/gopacket/layers"
+)
+
+// Version is the export protocol version.
+type Version uint16
+
+const (
+	// NetFlowV5 exports fixed-format NetFlow v5 records.  It only supports
+	// IPv4 flows.
+	NetFlowV5 Version = 5
+	// NetFlowV9 exports template-based NetFlow v9 records (RFC 3954).
+	NetFlowV9 Version = 9
+	// IPFIX exports IPFIX records (RFC 7011).
+	IPFIX Version = 10
+)
+
+func (v Version) String() string {
+	switch v {
+	case NetFlowV5:
+		return "NetFlowV5"
+	case NetFlowV9:
+		return "NetFlowV9"
+	case IPFIX:
+		return "IPFIX"
+	}
+	return fmt.Sprintf("Version(%d)", uint16(v))
+}
+
+// Template IDs of the records exported with NetFlow v9 and IPFIX.
+const (
+	TemplateIDIPv4 uint16 = 256
+	TemplateIDIPv6 uint16 = 257
+)
+
+// Information element IDs used in templates.  NetFlow v9 field types and IPFIX
+// information elements share the same numbers for these.
+const (
+	ieOctetDeltaCount          = 1
+	iePacketDeltaCount         = 2
+	ieProtocolIdentifier       = 4
+	ieIPClassOfService         = 5
+	ieTCPControlBits           = 6
+	ieSourceTransportPort      = 7
+	ieSourceIPv4Address        = 8
+	ieDestinationTransportPort = 11
+	ieDestinationIPv4Address   = 12
+	ieFlowEndSysUpTime         = 21
+	ieFlowStartSysUpTime       = 22
+	ieSourceIPv6Address        = 27
+	ieDestinationIPv6Address   = 28
+	ieIPVersion                = 60
+	ieFlowStartMilliseconds    = 152
+	ieFlowEndMilliseconds      = 153
+)
+
+type templateField struct {
+	id, length uint16
+}
+
+// Options configures an Exporter.
+type Options struct {
+	// Version is the export protocol version.  It defaults to NetFlowV9.
+	Version Version
+	// IdleTimeout, ActiveTimeout and MaxFlows configure the flow table, see
+	// flowtable.Options.  IdleTimeout defaults to 15 seconds and
+	// ActiveTimeout to 30 minutes.
+	IdleTimeout   time.Duration
+	ActiveTimeout time.Duration
+	MaxFlows      int
+	// ObservationDomainID is the NetFlow v9 source ID or IPFIX observation
+	// domain ID.
+	ObservationDomainID uint32
+	// EngineType, EngineID and SamplingInterval fill in the corresponding
+	// NetFlow v5 header fields.
+	EngineType, EngineID uint8
+	SamplingInterval     uint16
+	// BootTime is the time the system uptime fields are relative to.  It
+	// defaults to the timestamp of the first packet.
+	BootTime time.Time
+	// TemplateRefresh is the number of export packets after which templates
+	// are sent again, for collectors which started listening after the
+	// first export packet.  It defaults to 20.
+	TemplateRefresh int
+	// MaxPacketSize is the maximum size of an export packet.  It defaults to
+	// 1400 bytes.
+	MaxPacketSize int
+}
+
+// Exporter aggregates packets into flows and exports them.  Create one with
+// NewExporter or NewUDPExporter.
+type Exporter struct {
+	w      io.Writer
+	closer io.Closer
+	opts   Options
+	table  *flowtable.Table
+
+	pending []record
+	now     time.Time
+	// packets counts export packets sent, records data records sent.
+	packets, records uint32
+	err              error
+}
+
+type record struct {
+	// ipv6 is set for flows between IPv6 endpoints, whose addresses may
+	// be IPv4-mapped.
+	ipv6             bool
+	src, dst         net.IP
+	srcPort, dstPort uint16
+	protocol         uint8
+	tos, tcpFlags    uint8
+	packets, bytes   uint64
+	first, last      time.Time
+}
+
+// flowInfo is kept in the UserData of flows.
+type flowInfo struct {
+	tos [2]uint8
+}
+
+// NewExporter creates an Exporter writing each export packet to w with a
+// single Write call, so w can be a connected UDP socket.
+func NewExporter(w io.Writer, opts Options) *Exporter {
+	if opts.Version == 0 {
+		opts.Version = NetFlowV9
+	}
+	if opts.IdleTimeout == 0 {
+		opts.IdleTimeout = 15 * time.Second
+	}
+	if opts.ActiveTimeout == 0 {
+		opts.ActiveTimeout = 30 * time.Minute
+	}
+	if opts.TemplateRefresh <= 0 {
+		opts.TemplateRefresh = 20
+	}
+	if opts.MaxPacketSize <= 0 {
+		opts.MaxPacketSize = 1400
+	}
+	e := &Exporter{w: w, opts: opts}
+	e.table = flowtable.NewTable(flowtable.Options{
+		IdleTimeout:   opts.IdleTimeout,
+		ActiveTimeout: opts.ActiveTimeout,
+		MaxFlows:      opts.MaxFlows,
+		Export:        e.export,
+	})
+	return e
+}
+
+// NewUDPExporter creates an Exporter sending export packets to the collector
+// at the given UDP address.  Close closes the socket.
+func NewUDPExporter(addr string, opts Options) (*Exporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	e := NewExporter(conn, opts)
+	e.closer = conn
+	return e, nil
+}
+
+// Add accounts for a packet in its flow, and sends the records of flows which
+// expired by the packet's timestamp.  It returns the first error encountered
+// writing an export packet.
+func (e *Exporter) Add(p gopacket.Packet) error {
+	k, ok := flowtable.KeyFromPacket(p)
+	if !ok || !k.IsIP() {
+		return e.err
+	}
+	var length int
+	var tos uint8
+	switch ip := p.NetworkLayer().(type) {
+	case *layers.IPv4:
+		length, tos = int(ip.Length), ip.TOS
+	case *layers.IPv6:
+		length, tos = len(ip.Contents)+len(ip.Payload), ip.TrafficClass
+	}
+	var flags uint8
+	if tcp, ok := p.TransportLayer().(*layers.TCP); ok {
+		flags = flowtable.TCPFlags(tcp)
+	}
+	ts := p.Metadata().Timestamp
+	e.setNow(ts)
+	f, dir := e.table.AddKey(k, ts, length, flags)
+	info, ok := f.UserData.(*flowInfo)
+	if !ok {
+		info = &flowInfo{}
+		f.UserData = info
+	}
+	if f.Counters[dir].Packets == 1 {
+		info.tos[dir] = tos
+	}
+	return e.send()
+}
+
+// Expire sends the records of flows whose timeouts expired by now.
+func (e *Exporter) Expire(now time.Time) error {
+	e.setNow(now)
+	e.table.Expire(now)
+	return e.send()
+}
+
+// Flush sends the records of all flows, regardless of their timeouts.
+func (e *Exporter) Flush() error {
+	e.table.Flush()
+	return e.send()
+}
+
+// Close flushes the exporter, and closes the socket of an Exporter created
+// with NewUDPExporter.
+func (e *Exporter) Close() error {
+	err := e.Flush()
+	if e.closer != nil {
+		if cerr := e.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (e *Exporter) setNow(t time.Time) {
+	if e.opts.BootTime.IsZero() {
+		e.opts.BootTime = t
+	}
+	if t.After(e.now) {
+		e.now = t
+	}
+}
+
+// export is the flow table's export callback, turning each direction of a
+// flow into a record.
+func (e *Exporter) export(f *flowtable.Flow, _ flowtable.Reason) {
+	info, _ := f.UserData.(*flowInfo)
+	if info == nil {
+		info = &flowInfo{}
+	}
+	k := f.Key
+	for dir := flowtable.DirectionForward; dir <= flowtable.DirectionReverse; dir++ {
+		c := &f.Counters[dir]
+		if c.Packets == 0 {
+			continue
+		}
+		nsrc, ndst := k.Network.Endpoints()
+		tsrc, tdst := k.Transport.Endpoints()
+		if dir == flowtable.DirectionReverse {
+			nsrc, ndst, tsrc, tdst = ndst, nsrc, tdst, tsrc
+		}
+		ipv6 := nsrc.EndpointType() == layers.EndpointIPv6
+		if ipv6 && e.opts.Version == NetFlowV5 {
+			continue
+		}
+		e.pending = append(e.pending, record{
+			ipv6:     ipv6,
+			src:      net.IP(nsrc.Raw()),
+			dst:      net.IP(ndst.Raw()),
+			srcPort:  port(tsrc),
+			dstPort:  port(tdst),
+			protocol: k.Protocol,
+			tos:      info.tos[dir],
+			tcpFlags: c.TCPFlags,
+			packets:  c.Packets,
+			bytes:    c.Bytes,
+			first:    c.First,
+			last:     c.Last,
+		})
+	}
+}
+
+// port returns the port number of a transport endpoint, or 0 for other
+// endpoints such as ICMP queries.
+func port(e gopacket.Endpoint) uint16 {
+	switch e.EndpointType() {
+	case layers.EndpointTCPPort, layers.EndpointUDPPort, layers.EndpointSCTPPort, layers.EndpointUDPLitePort:
+		return binary.BigEndian.Uint16(e.Raw())
+	}
+	return 0
+}
+
+// uptime returns the system uptime at t, in milliseconds.
+func (e *Exporter) uptime(t time.Time) uint32 {
+	if t.Before(e.opts.BootTime) {
+		return 0
+	}
+	return uint32(t.Sub(e.opts.BootTime) / time.Millisecond)
+}
+
+// send writes export packets for all pending records.  Once a write failed,
+// records are dropped and the error is returned by every later call.
+func (e *Exporter) send() error {
+	recs := e.pending
+	for len(recs) > 0 && e.err == nil {
+		var msg []byte
+		var n int
+		switch e.opts.Version {
+		case NetFlowV5:
+			msg, n = e.encodeV5(recs)
+		case NetFlowV9, IPFIX:
+			msg, n = e.encodeTemplated(recs)
+		default:
+			e.err = fmt.Errorf("unsupported export version %v", e.opts.Version)
+			break
+		}
+		if n == 0 {
+			if e.err == nil {
+				e.err = errors.New("MaxPacketSize too small for a single record")
+			}
+			break
+		}
+		if _, err := e.w.Write(msg); err != nil {
+			e.err = err
+		}
+		recs = recs[n:]
+		e.packets++
+		e.records += uint32(n)
+	}
+	e.pending = e.pending[:0]
+	return e.err
+}
+
+const (
+	v5HeaderLen     = 24
+	v5RecordLen     = 48
+	v5MaxRecords    = 30
+	v9HeaderLen     = 20
+	ipfixHeaderLen  = 16
+	setHeaderLen    = 4
+	v9TemplateSetID = 0
+	ipfixTemplateID = 2
+)
+
+// encodeV5 encodes as many records as fit in a NetFlow v5 packet, returning
+// the packet and the number of records encoded.
+func (e *Exporter) encodeV5(recs []record) ([]byte, int) {
+	n := (e.opts.MaxPacketSize - v5HeaderLen) / v5RecordLen
+	if n > v5MaxRecords {
+		n = v5MaxRecords
+	}
+	if n > len(recs) {
+		n = len(recs)
+	}
+	if n <= 0 {
+		return nil, 0
+	}
+	b := make([]byte, v5HeaderLen+n*v5RecordLen)
+	binary.BigEndian.PutUint16(b[0:], uint16(NetFlowV5))
+	binary.BigEndian.PutUint16(b[2:], uint16(n))
+	binary.BigEndian.PutUint32(b[4:], e.uptime(e.now))
+	binary.BigEndian.PutUint32(b[8:], uint32(e.now.Unix()))
+	binary.BigEndian.PutUint32(b[12:], uint32(e.now.Nanosecond()))
+	binary.BigEndian.PutUint32(b[16:], e.records)
+	b[20] = e.opts.EngineType
+	b[21] = e.opts.EngineID
+	binary.BigEndian.PutUint16(b[22:], e.opts.SamplingInterval&0x3fff)
+	for i, r := range recs[:n] {
+		d := b[v5HeaderLen+i*v5RecordLen:]
+		copy(d[0:4], r.src)
+		copy(d[4:8], r.dst)
+		binary.BigEndian.PutUint32(d[16:], clamp32(r.packets))
+		binary.BigEndian.PutUint32(d[20:], clamp32(r.bytes))
+		binary.BigEndian.PutUint32(d[24:], e.uptime(r.first))
+		binary.BigEndian.PutUint32(d[28:], e.uptime(r.last))
+		binary.BigEndian.PutUint16(d[32:], r.srcPort)
+		binary.BigEndian.PutUint16(d[34:], r.dstPort)
+		d[37] = r.tcpFlags
+		d[38] = r.protocol
+		d[39] = r.tos
+	}
+	return b, n
+}
+
+func clamp32(v uint64) uint32 {
+	if v > 0xffffffff {
+		return 0xffffffff
+	}
+	return uint32(v)
+}
+
+// template returns the fields of the IPv4 or IPv6 template.
+func (e *Exporter) template(ipv6 bool) []templateField {
+	src, dst, addrLen := uint16(ieSourceIPv4Address), uint16(ieDestinationIPv4Address), uint16(4)
+	if ipv6 {
+		src, dst, addrLen = ieSourceIPv6Address, ieDestinationIPv6Address, 16
+	}
+	fields := []templateField{
+		{src, addrLen},
+		{dst, addrLen},
+		{ieSourceTransportPort, 2},
+		{ieDestinationTransportPort, 2},
+		{ieProtocolIdentifier, 1},
+		{ieIPClassOfService, 1},
+		{ieTCPControlBits, 1},
+		{ieIPVersion, 1},
+		{iePacketDeltaCount, 8},
+		{ieOctetDeltaCount, 8},
+	}
+	if e.opts.Version == IPFIX {
+		return append(fields, templateField{ieFlowStartMilliseconds, 8}, templateField{ieFlowEndMilliseconds, 8})
+	}
+	return append(fields, templateField{ieFlowStartSysUpTime, 4}, templateField{ieFlowEndSysUpTime, 4})
+}
+
+func templateRecordLen(fields []templateField) (n int) {
+	for _, f := range fields {
+		n += int(f.length)
+	}
+	return
+}
+
+// encodeTemplated encodes as many records as fit in a NetFlow v9 or IPFIX
+// packet, preceded by the templates if they're due, returning the packet and
+// the number of records encoded.
+func (e *Exporter) encodeTemplated(recs []record) ([]byte, int) {
+	ipfix := e.opts.Version == IPFIX
+	headerLen := v9HeaderLen
+	if ipfix {
+		headerLen = ipfixHeaderLen
+	}
+	b := make([]byte, headerLen, e.opts.MaxPacketSize)
+	count := 0 // NetFlow v9 counts template and data records
+
+	templates := [2][]templateField{e.template(false), e.template(true)}
+	if e.packets%uint32(e.opts.TemplateRefresh) == 0 {
+		setID := uint16(v9TemplateSetID)
+		if ipfix {
+			setID = ipfixTemplateID
+		}
+		start := len(b)
+		b = appendUint16(b, setID, 0)
+		for i, fields := range templates {
+			b = appendUint16(b, TemplateIDIPv4+uint16(i), uint16(len(fields)))
+			for _, f := range fields {
+				b = appendUint16(b, f.id, f.length)
+			}
+			count++
+		}
+		binary.BigEndian.PutUint16(b[start+2:], uint16(len(b)-start))
+	}
+
+	// fits returns whether a data set starting at start and ending at end
+	// fits in the packet, NetFlow v9 data sets being padded to 4 bytes.
+	fits := func(start, end int) bool {
+		if !ipfix {
+			end = start + (end-start+3)&^3
+		}
+		return end <= e.opts.MaxPacketSize
+	}
+	n := 0
+	for n < len(recs) {
+		// Consecutive records of the same IP version share a data set.
+		ipv6 := recs[n].ipv6
+		recLen := templateRecordLen(templates[boolIndex(ipv6)])
+		start := len(b)
+		if !fits(start, start+setHeaderLen+recLen) {
+			break
+		}
+		b = appendUint16(b, TemplateIDIPv4+uint16(boolIndex(ipv6)), 0)
+		for n < len(recs) && recs[n].ipv6 == ipv6 && fits(start, len(b)+recLen) {
+			b = e.appendRecord(b, &recs[n])
+			n++
+			count++
+		}
+		for !ipfix && (len(b)-start)%4 != 0 {
+			b = append(b, 0)
+		}
+		binary.BigEndian.PutUint16(b[start+2:], uint16(len(b)-start))
+	}
+	if n == 0 {
+		return nil, 0
+	}
+
+	if ipfix {
+		binary.BigEndian.PutUint16(b[0:], uint16(IPFIX))
+		binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+		binary.BigEndian.PutUint32(b[4:], uint32(e.now.Unix()))
+		binary.BigEndian.PutUint32(b[8:], e.records)
+		binary.BigEndian.PutUint32(b[12:], e.opts.ObservationDomainID)
+	} else {
+		binary.BigEndian.PutUint16(b[0:], uint16(NetFlowV9))
+		binary.BigEndian.PutUint16(b[2:], uint16(count))
+		binary.BigEndian.PutUint32(b[4:], e.uptime(e.now))
+		binary.BigEndian.PutUint32(b[8:], uint32(e.now.Unix()))
+		binary.BigEndian.PutUint32(b[12:], e.packets)
+		binary.BigEndian.PutUint32(b[16:], e.opts.ObservationDomainID)
+	}
+	return b, n
+}
+
+// appendRecord appends a data record following the template of e.template.
+func (e *Exporter) appendRecord(b []byte, r *record) []byte {
+	version := byte(4)
+	if r.ipv6 {
+		b = append(b, r.src.To16()...)
+		b = append(b, r.dst.To16()...)
+		version = 6
+	} else {
+		b = append(b, r.src.To4()...)
+		b = append(b, r.dst.To4()...)
+	}
+	b = appendUint16(b, r.srcPort, r.dstPort)
+	b = append(b, r.protocol, r.tos, r.tcpFlags, version)
+	b = appendUint64(b, r.packets)
+	b = appendUint64(b, r.bytes)
+	if e.opts.Version == IPFIX {
+		b = appendUint64(b, uint64(r.first.UnixNano()/int64(time.Millisecond)))
+		b = appendUint64(b, uint64(r.last.UnixNano()/int64(time.Millisecond)))
+	} else {
+		b = appendUint32(b, e.uptime(r.first))
+		b = appendUint32(b, e.uptime(r.last))
+	}
+	return b
+}
+
+func boolIndex(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func appendUint16(b []byte, vs ...uint16) []byte {
+	for _, v := range vs {
+		b = append(b, byte(v>>8), byte(v))
+	}
+	return b
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
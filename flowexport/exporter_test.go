@@ -0,0 +1,261 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package flowexport
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+var t0 = time.Unix(1500000000, 0)
+
+func udpPacket(t *testing.T, ts time.Time, src, dst net.IP, sport, dport layers.UDPPort) gopacket.Packet {
+	var ip gopacket.SerializableLayer
+	eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{0, 0, 0, 0, 0, 1}, DstMAC: net.HardwareAddr{0, 0, 0, 0, 0, 2}}
+	udp := &layers.UDP{SrcPort: sport, DstPort: dport}
+	if src.To4() != nil && dst.To4() != nil {
+		ip4 := &layers.IPv4{Version: 4, TTL: 64, TOS: 0x10, Protocol: layers.IPProtocolUDP, SrcIP: src, DstIP: dst}
+		eth.EthernetType, ip = layers.EthernetTypeIPv4, ip4
+		udp.SetNetworkLayerForChecksum(ip4)
+	} else {
+		ip6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP, SrcIP: src, DstIP: dst}
+		eth.EthernetType, ip = layers.EthernetTypeIPv6, ip6
+		udp.SetNetworkLayerForChecksum(ip6)
+	}
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		eth, ip, udp, gopacket.Payload("0123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+	p.Metadata().Timestamp = ts
+	return p
+}
+
+var (
+	ipA  = net.IP{10, 0, 0, 1}
+	ipB  = net.IP{10, 0, 0, 2}
+	ip6A = net.ParseIP("2001:db8::1")
+	ip6B = net.ParseIP("2001:db8::2")
+)
+
+// addConversation adds a request and a reply between a and b.
+func addConversation(t *testing.T, e *Exporter, ts time.Time, a, b net.IP) {
+	if err := e.Add(udpPacket(t, ts, a, b, 5000, 53)); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Add(udpPacket(t, ts.Add(time.Second), b, a, 53, 5000)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// listen starts a UDP listener, returning it and a function receiving the
+// next datagram.
+func listen(t *testing.T) (net.PacketConn, func() []byte) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, func() []byte {
+		buf := make([]byte, 65536)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf[:n]
+	}
+}
+
+func TestExportNetFlowV5(t *testing.T) {
+	conn, recv := listen(t)
+	defer conn.Close()
+	e, err := NewUDPExporter(conn.LocalAddr().String(), Options{Version: NetFlowV5, EngineID: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addConversation(t, e, t0, ipA, ipB)
+	// IPv6 flows can't be exported with NetFlow v5.
+	addConversation(t, e, t0, ip6A, ip6B)
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b := recv()
+	if len(b) != v5HeaderLen+2*v5RecordLen {
+		t.Fatalf("unexpected export packet length %d", len(b))
+	}
+	if v, n := binary.BigEndian.Uint16(b), binary.BigEndian.Uint16(b[2:]); v != 5 || n != 2 || b[21] != 7 {
+		t.Errorf("unexpected header %x", b[:v5HeaderLen])
+	}
+	r := b[v5HeaderLen:]
+	if !net.IP(r[0:4]).Equal(ipA) || !net.IP(r[4:8]).Equal(ipB) {
+		t.Errorf("unexpected addresses %v -> %v", net.IP(r[0:4]), net.IP(r[4:8]))
+	}
+	if pkts, octets := binary.BigEndian.Uint32(r[16:]), binary.BigEndian.Uint32(r[20:]); pkts != 1 || octets != 20+8+10 {
+		t.Errorf("unexpected counters %d packets, %d bytes", pkts, octets)
+	}
+	if sport, dport := binary.BigEndian.Uint16(r[32:]), binary.BigEndian.Uint16(r[34:]); sport != 5000 || dport != 53 {
+		t.Errorf("unexpected ports %d -> %d", sport, dport)
+	}
+	if r[38] != uint8(layers.IPProtocolUDP) || r[39] != 0x10 {
+		t.Errorf("unexpected protocol %d or tos %#x", r[38], r[39])
+	}
+	r = r[v5RecordLen:]
+	if first := binary.BigEndian.Uint32(r[24:]); !net.IP(r[0:4]).Equal(ipB) || first != 1000 {
+		t.Errorf("unexpected reverse record from %v at %d", net.IP(r[0:4]), first)
+	}
+}
+
+// sets splits the sets of a NetFlow v9 or IPFIX packet by set ID.
+func sets(t *testing.T, b []byte) map[uint16][]byte {
+	s := map[uint16][]byte{}
+	for len(b) > 0 {
+		id, l := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		if l < setHeaderLen || l > len(b) {
+			t.Fatalf("invalid set length %d", l)
+		}
+		s[id] = append(s[id], b[setHeaderLen:l]...)
+		b = b[l:]
+	}
+	return s
+}
+
+func TestExportNetFlowV9(t *testing.T) {
+	conn, recv := listen(t)
+	defer conn.Close()
+	e, err := NewUDPExporter(conn.LocalAddr().String(), Options{Version: NetFlowV9, ObservationDomainID: 42, TemplateRefresh: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	addConversation(t, e, t0, ipA, ipB)
+	addConversation(t, e, t0, ip6A, ip6B)
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	b := recv()
+	if v, n, id := binary.BigEndian.Uint16(b), binary.BigEndian.Uint16(b[2:]), binary.BigEndian.Uint32(b[16:]); v != 9 || n != 2+4 || id != 42 {
+		t.Fatalf("unexpected header %x", b[:v9HeaderLen])
+	}
+	s := sets(t, b[v9HeaderLen:])
+	if tmpl := s[v9TemplateSetID]; len(tmpl) != 2*4+4*(len(e.template(false))+len(e.template(true))) {
+		t.Errorf("unexpected template set %x", tmpl)
+	}
+	v4, v6 := s[TemplateIDIPv4], s[TemplateIDIPv6]
+	if l := templateRecordLen(e.template(false)); len(v4) < 2*l || !net.IP(v4[:4]).Equal(ipA) {
+		t.Errorf("unexpected IPv4 data set %x", v4)
+	}
+	if l := templateRecordLen(e.template(true)); len(v6) != 2*l || !net.IP(v6[:16]).Equal(ip6A) || v6[39] != 6 {
+		t.Errorf("unexpected IPv6 data set %x", v6)
+	}
+
+	// Templates are only sent every other packet.
+	addConversation(t, e, t0.Add(time.Minute), ipA, ipB)
+	e.Flush()
+	if s := sets(t, recv()[v9HeaderLen:]); s[v9TemplateSetID] != nil {
+		t.Error("unexpected templates in second packet")
+	}
+	addConversation(t, e, t0.Add(2*time.Minute), ipA, ipB)
+	e.Flush()
+	if s := sets(t, recv()[v9HeaderLen:]); s[v9TemplateSetID] == nil {
+		t.Error("expected templates in third packet")
+	}
+}
+
+// writes records every Write call.
+type writes [][]byte
+
+func (w *writes) Write(b []byte) (int, error) {
+	*w = append(*w, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func TestExportIPFIX(t *testing.T) {
+	var w writes
+	e := NewExporter(&w, Options{Version: IPFIX, IdleTimeout: 10 * time.Second, MaxPacketSize: 200})
+	for i := 0; i < 5; i++ {
+		addConversation(t, e, t0, ipA, net.IP{10, 0, 1, byte(i)})
+	}
+	if len(w) != 0 {
+		t.Fatal("unexpected export before timeout")
+	}
+	// A packet after the idle timeout exports the first flows.
+	if err := e.Expire(t0.Add(20 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if len(w) < 2 {
+		t.Fatalf("expected records to be split into several packets, got %d", len(w))
+	}
+	recLen := templateRecordLen(e.template(false))
+	records := 0
+	for i, b := range w {
+		if len(b) > 200 || binary.BigEndian.Uint16(b) != 10 || int(binary.BigEndian.Uint16(b[2:])) != len(b) {
+			t.Fatalf("packet %d: invalid header %x", i, b[:ipfixHeaderLen])
+		}
+		if seq := binary.BigEndian.Uint32(b[8:]); int(seq) != records {
+			t.Errorf("packet %d: got sequence number %d, want %d", i, seq, records)
+		}
+		s := sets(t, b[ipfixHeaderLen:])
+		if (i == 0) != (s[ipfixTemplateID] != nil) {
+			t.Errorf("packet %d: unexpected template set", i)
+		}
+		data := s[TemplateIDIPv4]
+		if len(data)%recLen != 0 {
+			t.Fatalf("packet %d: invalid data set length %d", i, len(data))
+		}
+		records += len(data) / recLen
+		if i == 0 {
+			start := binary.BigEndian.Uint64(data[recLen-16:])
+			if start != uint64(t0.Unix())*1000 {
+				t.Errorf("unexpected flow start %d", start)
+			}
+		}
+	}
+	if records != 10 {
+		t.Errorf("expected 10 records, got %d", records)
+	}
+}
+
+func TestExportIPv4MappedIPv6(t *testing.T) {
+	var w writes
+	e := NewExporter(&w, Options{Version: NetFlowV9, MaxPacketSize: 300})
+	recLen := templateRecordLen(e.template(true))
+	mapped := net.ParseIP("::ffff:10.0.0.3")
+	addConversation(t, e, t0, mapped, ip6B)
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	records := 0
+	for i, b := range w {
+		if len(b) > 300 {
+			t.Errorf("packet %d: got %d bytes", i, len(b))
+		}
+		s := sets(t, b[v9HeaderLen:])
+		if s[TemplateIDIPv4] != nil {
+			t.Errorf("packet %d: IPv6 flow exported as IPv4", i)
+		}
+		data := s[TemplateIDIPv6]
+		if len(data)%4 != 0 || len(data) < recLen {
+			t.Fatalf("packet %d: invalid data set length %d", i, len(data))
+		}
+		records += len(data) / recLen
+		src, dst := net.IP(data[:16]), net.IP(data[16:32])
+		if !(src.Equal(mapped) && dst.Equal(ip6B) || src.Equal(ip6B) && dst.Equal(mapped)) || data[39] != 6 {
+			t.Errorf("packet %d: unexpected record %x", i, data[:recLen])
+		}
+	}
+	if records != 2 {
+		t.Errorf("expected 2 records, got %d", records)
+	}
+}
@@ -0,0 +1,432 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package flowtable implements a flow (conversation) table, which keeps
+// per-direction packet and byte counters for every flow seen in a packet
+// stream and exports flows once they time out.
+//
+// Unlike the reassembly package, it's not limited to TCP: IP packets are keyed
+// by their gopacket.FiveTuple (so UDP, ICMP queries and any other IP protocol
+// are tracked too), and non-IP packets by their link flow and the type of the
+// layer following the link layer (ARP, LLDP...).
+//
+// Timeouts are measured against packet timestamps, so a pcap file is processed
+// the same way as live traffic.  A typical usage looks like:
+//
+//  table := flowtable.NewTable(flowtable.Options{
+//    IdleTimeout:   15 * time.Second,
+//    ActiveTimeout: 30 * time.Minute,
+//    MaxFlows:      100000,
+//    Export: func(f *flowtable.Flow, r flowtable.Reason) {
+//      fmt.Println(f, r)
+//    },
+//  })
+//  for packet := range source.Packets() {
+//    table.Add(packet)
+//  }
+//  table.Flush()
+//
+// Fragmented IP packets should be defragmented first (see ip4defrag), since
+// only the first fragment carries the transport ports.
+//
+// A Table is not safe for concurrent use.
+package flowtable
+
+import (
+	"container/list"
+	"fmt"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Key identifies a flow.  IP packets are keyed by their FiveTuple, other
+// packets by their Link flow and the LinkPayload type following the link
+// layer.  Keys are directional: use Canonical to compare keys regardless of
+// direction.
+type Key struct {
+	gopacket.FiveTuple
+	// Link is the link flow of non-IP packets.  It's the zero Flow for IP
+	// packets.
+	Link gopacket.Flow
+	// LinkPayload is the type of the layer following the link layer of
+	// non-IP packets, or zero for IP packets.
+	LinkPayload gopacket.LayerType
+}
+
+// KeyFromPacket returns the Key of a packet, or false if it has neither a
+// network layer nor a link layer.
+func KeyFromPacket(p gopacket.Packet) (Key, bool) {
+	return KeyFromLayers(p.Layers()...)
+}
+
+// KeyFromLayers returns the Key of a set of decoded layers, as returned by
+// gopacket.Packet.Layers, or false if there's neither a network layer nor a
+// link layer.
+func KeyFromLayers(ls ...gopacket.Layer) (k Key, ok bool) {
+	if k.FiveTuple, ok = gopacket.FiveTupleFromLayers(ls...); ok {
+		return
+	}
+	for i, l := range ls {
+		if link, isLink := l.(gopacket.LinkLayer); isLink {
+			k.Link = link.LinkFlow()
+			k.LinkPayload = gopacket.LayerTypeZero
+			if i+1 < len(ls) {
+				k.LinkPayload = ls[i+1].LayerType()
+			}
+			return k, true
+		}
+	}
+	return k, false
+}
+
+// IsIP returns true if the key is that of an IP flow.
+func (k Key) IsIP() bool {
+	return k.Network != (gopacket.Flow{})
+}
+
+// Reverse returns the Key of the opposite direction.
+func (k Key) Reverse() Key {
+	return Key{FiveTuple: k.FiveTuple.Reverse(), Link: k.Link.Reverse(), LinkPayload: k.LinkPayload}
+}
+
+// Canonical returns the key with its endpoints in a canonical order, so that
+// both directions of a flow yield the same value, along with whether k had to
+// be reversed to get there.
+func (k Key) Canonical() (Key, bool) {
+	if k.IsIP() {
+		ft, reversed := k.FiveTuple.Canonical()
+		return Key{FiveTuple: ft}, reversed
+	}
+	if src, dst := k.Link.Endpoints(); dst.LessThan(src) {
+		return k.Reverse(), true
+	}
+	return k, false
+}
+
+func (k Key) String() string {
+	if k.IsIP() {
+		return k.FiveTuple.String()
+	}
+	return fmt.Sprintf("%v:%v", k.LinkPayload, k.Link)
+}
+
+// Direction is the direction of a packet within its flow.
+type Direction uint8
+
+const (
+	// DirectionForward is the direction of the first packet of the flow.
+	DirectionForward Direction = iota
+	// DirectionReverse is the opposite direction.
+	DirectionReverse
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionForward:
+		return "forward"
+	case DirectionReverse:
+		return "reverse"
+	}
+	return "unknown"
+}
+
+// Reason is the reason a flow was exported.
+type Reason uint8
+
+const (
+	// ReasonIdleTimeout means no packet was seen for Options.IdleTimeout.
+	ReasonIdleTimeout Reason = iota
+	// ReasonActiveTimeout means the flow lasted longer than
+	// Options.ActiveTimeout.  Further packets start a new flow.
+	ReasonActiveTimeout
+	// ReasonTCPClose means a TCP RST, or a FIN in both directions, was seen
+	// with Options.ExpireOnTCPClose set.
+	ReasonTCPClose
+	// ReasonEvicted means the flow was the least recently seen one when the
+	// table reached Options.MaxFlows.
+	ReasonEvicted
+	// ReasonFlushed means the flow was exported by Table.Flush.
+	ReasonFlushed
+)
+
+func (r Reason) String() string {
+	switch r {
+	case ReasonIdleTimeout:
+		return "IdleTimeout"
+	case ReasonActiveTimeout:
+		return "ActiveTimeout"
+	case ReasonTCPClose:
+		return "TCPClose"
+	case ReasonEvicted:
+		return "Evicted"
+	case ReasonFlushed:
+		return "Flushed"
+	}
+	return "Unknown"
+}
+
+// TCP flags, as accumulated in Counters.TCPFlags.  They use the same bit
+// positions as in the TCP header (and in NetFlow/IPFIX records).
+const (
+	TCPFlagFIN uint8 = 1 << iota
+	TCPFlagSYN
+	TCPFlagRST
+	TCPFlagPSH
+	TCPFlagACK
+	TCPFlagURG
+	TCPFlagECE
+	TCPFlagCWR
+)
+
+// TCPFlags returns the flags of a TCP header, as a TCPFlag* bitmask.
+func TCPFlags(t *layers.TCP) (f uint8) {
+	for i, set := range [8]bool{t.FIN, t.SYN, t.RST, t.PSH, t.ACK, t.URG, t.ECE, t.CWR} {
+		if set {
+			f |= 1 << uint(i)
+		}
+	}
+	return
+}
+
+// Counters are the statistics of one direction of a flow.
+type Counters struct {
+	// Packets and Bytes count the packets seen and their length.
+	Packets, Bytes uint64
+	// First and Last are the timestamps of the first and last packets seen.
+	First, Last time.Time
+	// TCPFlags is the union of the TCP flags seen, as a TCPFlag* bitmask.
+	TCPFlags uint8
+}
+
+func (c *Counters) add(ts time.Time, length int, tcpFlags uint8) {
+	if c.Packets == 0 {
+		c.First = ts
+	}
+	c.Packets++
+	c.Bytes += uint64(length)
+	c.Last = ts
+	c.TCPFlags |= tcpFlags
+}
+
+// Flow is an entry of a Table.
+type Flow struct {
+	// Key is the key of the first packet of the flow, so its source is the
+	// flow's initiator (as far as the table could tell).
+	Key Key
+	// Counters holds the statistics of each direction, indexed by Direction.
+	Counters [2]Counters
+	// Start and End are the timestamps of the first and last packets of the
+	// flow, in either direction.
+	Start, End time.Time
+	// UserData is free for use by the table's user, for example to keep
+	// track of additional per-flow state.
+	UserData interface{}
+
+	reversed  bool // whether Key isn't canonical
+	lastElem  *list.Element
+	startElem *list.Element
+}
+
+// Total returns the combined statistics of both directions.
+func (f *Flow) Total() Counters {
+	a, b := f.Counters[DirectionForward], f.Counters[DirectionReverse]
+	return Counters{
+		Packets:  a.Packets + b.Packets,
+		Bytes:    a.Bytes + b.Bytes,
+		First:    f.Start,
+		Last:     f.End,
+		TCPFlags: a.TCPFlags | b.TCPFlags,
+	}
+}
+
+// Duration returns the time elapsed between the first and last packets.
+func (f *Flow) Duration() time.Duration {
+	return f.End.Sub(f.Start)
+}
+
+func (f *Flow) String() string {
+	fw, rv := &f.Counters[DirectionForward], &f.Counters[DirectionReverse]
+	return fmt.Sprintf("%v: %d/%d packets, %d/%d bytes, %v", f.Key, fw.Packets, rv.Packets, fw.Bytes, rv.Bytes, f.Duration())
+}
+
+// tcpClosed returns true if an RST or a FIN in both directions was seen.
+func (f *Flow) tcpClosed() bool {
+	fw, rv := f.Counters[DirectionForward].TCPFlags, f.Counters[DirectionReverse].TCPFlags
+	return (fw|rv)&TCPFlagRST != 0 || fw&rv&TCPFlagFIN != 0
+}
+
+// Options configures a Table.  Zero values disable the corresponding
+// feature.
+type Options struct {
+	// IdleTimeout is the time after which a flow without new packets is
+	// exported.
+	IdleTimeout time.Duration
+	// ActiveTimeout is the time after which a flow still seeing packets is
+	// exported.  Its next packet starts a new flow, as is usual with NetFlow
+	// and IPFIX exporters.
+	ActiveTimeout time.Duration
+	// MaxFlows bounds the number of flows in the table.  When it's reached,
+	// the least recently seen flow is evicted to make room for a new one.
+	MaxFlows int
+	// ExpireOnTCPClose exports TCP flows as soon as an RST, or a FIN in both
+	// directions, is seen.
+	ExpireOnTCPClose bool
+	// Export is called with every flow leaving the table, and why.  The table
+	// doesn't use the flow anymore once Export is called.
+	Export func(*Flow, Reason)
+}
+
+// Table is a flow table.  Create one with NewTable.
+type Table struct {
+	opts  Options
+	flows map[Key]*Flow
+	// byLast orders flows by the time of their last packet, and byStart by
+	// the time of their first packet.
+	byLast, byStart *list.List
+	now             time.Time
+}
+
+// NewTable creates a new Table.
+func NewTable(opts Options) *Table {
+	return &Table{
+		opts:    opts,
+		flows:   make(map[Key]*Flow),
+		byLast:  list.New(),
+		byStart: list.New(),
+	}
+}
+
+// Len returns the number of flows currently in the table.
+func (t *Table) Len() int {
+	return len(t.flows)
+}
+
+// Get returns the flow with the given key, in either direction, or nil.
+func (t *Table) Get(k Key) *Flow {
+	c, _ := k.Canonical()
+	return t.flows[c]
+}
+
+// Flows returns the flows currently in the table, least recently seen first.
+func (t *Table) Flows() []*Flow {
+	flows := make([]*Flow, 0, len(t.flows))
+	for e := t.byLast.Front(); e != nil; e = e.Next() {
+		flows = append(flows, e.Value.(*Flow))
+	}
+	return flows
+}
+
+// Add accounts for a packet in its flow, creating the flow if needed, and
+// returns the flow and the packet's direction within it.  The packet's length
+// is its CaptureInfo.Length, or the length of its data if that's not set.  It
+// returns nil if the packet can't be keyed (see KeyFromPacket).
+//
+// Flows whose timeouts expired by the packet's timestamp are exported first.
+// The returned flow may have been exported already, if the packet closed a TCP
+// connection.
+func (t *Table) Add(p gopacket.Packet) (*Flow, Direction) {
+	k, ok := KeyFromPacket(p)
+	if !ok {
+		return nil, DirectionForward
+	}
+	md := p.Metadata()
+	length := md.Length
+	if length == 0 {
+		length = len(p.Data())
+	}
+	var flags uint8
+	if tcp, ok := p.TransportLayer().(*layers.TCP); ok {
+		flags = TCPFlags(tcp)
+	}
+	return t.AddKey(k, md.Timestamp, length, flags)
+}
+
+// AddKey is like Add, but takes the key, timestamp, length and TCP flags
+// (as a TCPFlag* bitmask) of the packet directly.  It's useful with
+// DecodingLayerParser, along with KeyFromLayers.
+func (t *Table) AddKey(k Key, ts time.Time, length int, tcpFlags uint8) (*Flow, Direction) {
+	if ts.After(t.now) {
+		t.now = ts
+	}
+	t.Expire(t.now)
+
+	c, reversed := k.Canonical()
+	f := t.flows[c]
+	if f == nil {
+		if t.opts.MaxFlows > 0 && len(t.flows) >= t.opts.MaxFlows {
+			t.export(t.byLast.Front().Value.(*Flow), ReasonEvicted)
+		}
+		f = &Flow{Key: k, Start: ts, reversed: reversed}
+		t.flows[c] = f
+		f.startElem = t.byStart.PushBack(f)
+		f.lastElem = t.byLast.PushBack(f)
+	} else {
+		t.byLast.MoveToBack(f.lastElem)
+	}
+	dir := DirectionForward
+	if reversed != f.reversed {
+		dir = DirectionReverse
+	}
+	f.Counters[dir].add(ts, length, tcpFlags)
+	if ts.After(f.End) {
+		f.End = ts
+	}
+	if t.opts.ExpireOnTCPClose && tcpFlags != 0 && f.tcpClosed() {
+		t.export(f, ReasonTCPClose)
+	}
+	return f, dir
+}
+
+// Expire exports flows whose idle or active timeout expired by now, returning
+// the number of flows exported.  Add calls it with packet timestamps; call it
+// periodically when packets may stop arriving, such as with live captures.
+func (t *Table) Expire(now time.Time) (n int) {
+	if t.opts.IdleTimeout > 0 {
+		limit := now.Add(-t.opts.IdleTimeout)
+		for e := t.byLast.Front(); e != nil; e = t.byLast.Front() {
+			f := e.Value.(*Flow)
+			if !f.End.Before(limit) {
+				break
+			}
+			t.export(f, ReasonIdleTimeout)
+			n++
+		}
+	}
+	if t.opts.ActiveTimeout > 0 {
+		limit := now.Add(-t.opts.ActiveTimeout)
+		for e := t.byStart.Front(); e != nil; e = t.byStart.Front() {
+			f := e.Value.(*Flow)
+			if !f.Start.Before(limit) {
+				break
+			}
+			t.export(f, ReasonActiveTimeout)
+			n++
+		}
+	}
+	return
+}
+
+// Flush exports all flows, returning the number of flows exported.
+func (t *Table) Flush() (n int) {
+	for e := t.byStart.Front(); e != nil; e = t.byStart.Front() {
+		t.export(e.Value.(*Flow), ReasonFlushed)
+		n++
+	}
+	return
+}
+
+func (t *Table) export(f *Flow, r Reason) {
+	c, _ := f.Key.Canonical()
+	delete(t.flows, c)
+	t.byLast.Remove(f.lastElem)
+	t.byStart.Remove(f.startElem)
+	f.lastElem, f.startElem = nil, nil
+	if t.opts.Export != nil {
+		t.opts.Export(f, r)
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package flowtable
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+var (
+	macA = net.HardwareAddr{0, 0, 0, 0, 0, 1}
+	macB = net.HardwareAddr{0, 0, 0, 0, 0, 2}
+	ipA  = net.IP{10, 0, 0, 1}
+	ipB  = net.IP{10, 0, 0, 2}
+	t0   = time.Unix(1000, 0)
+)
+
+func newPacket(t *testing.T, ts time.Time, ls ...gopacket.SerializableLayer) gopacket.Packet {
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, ls...); err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+	p.Metadata().Timestamp = ts
+	p.Metadata().Length = len(buf.Bytes())
+	return p
+}
+
+func ipPacket(t *testing.T, ts time.Time, fromA bool, l4 ...gopacket.SerializableLayer) gopacket.Packet {
+	eth := &layers.Ethernet{SrcMAC: macA, DstMAC: macB, EthernetType: layers.EthernetTypeIPv4}
+	ip := &layers.IPv4{Version: 4, TTL: 64, SrcIP: ipA, DstIP: ipB}
+	if !fromA {
+		eth.SrcMAC, eth.DstMAC = macB, macA
+		ip.SrcIP, ip.DstIP = ipB, ipA
+	}
+	switch l := l4[0].(type) {
+	case *layers.TCP:
+		ip.Protocol = layers.IPProtocolTCP
+		l.SetNetworkLayerForChecksum(ip)
+	case *layers.UDP:
+		ip.Protocol = layers.IPProtocolUDP
+	case *layers.ICMPv4:
+		ip.Protocol = layers.IPProtocolICMPv4
+	}
+	return newPacket(t, ts, append([]gopacket.SerializableLayer{eth, ip}, l4...)...)
+}
+
+func tcpPacket(t *testing.T, ts time.Time, fromA bool, tcp layers.TCP) gopacket.Packet {
+	tcp.SrcPort, tcp.DstPort = 40000, 80
+	if !fromA {
+		tcp.SrcPort, tcp.DstPort = 80, 40000
+	}
+	return ipPacket(t, ts, fromA, &tcp)
+}
+
+func udpPacket(t *testing.T, ts time.Time, fromA bool) gopacket.Packet {
+	udp := &layers.UDP{SrcPort: 5000, DstPort: 53}
+	if !fromA {
+		udp.SrcPort, udp.DstPort = 53, 5000
+	}
+	return ipPacket(t, ts, fromA, udp, gopacket.Payload("query"))
+}
+
+type exported struct {
+	flows   []*Flow
+	reasons []Reason
+}
+
+func (e *exported) export(f *Flow, r Reason) {
+	e.flows = append(e.flows, f)
+	e.reasons = append(e.reasons, r)
+}
+
+func TestTableTCPCounters(t *testing.T) {
+	var e exported
+	table := NewTable(Options{ExpireOnTCPClose: true, Export: e.export})
+	steps := []struct {
+		fromA bool
+		tcp   layers.TCP
+		dir   Direction
+	}{
+		{true, layers.TCP{SYN: true}, DirectionForward},
+		{false, layers.TCP{SYN: true, ACK: true}, DirectionReverse},
+		{true, layers.TCP{ACK: true}, DirectionForward},
+		{true, layers.TCP{FIN: true, ACK: true}, DirectionForward},
+		{false, layers.TCP{FIN: true, ACK: true}, DirectionReverse},
+	}
+	var flow *Flow
+	for i, s := range steps {
+		f, dir := table.Add(tcpPacket(t, t0.Add(time.Duration(i)*time.Second), s.fromA, s.tcp))
+		if flow == nil {
+			flow = f
+		} else if f != flow {
+			t.Fatalf("packet %d: got a different flow", i)
+		}
+		if dir != s.dir {
+			t.Errorf("packet %d: got direction %v, want %v", i, dir, s.dir)
+		}
+	}
+	if len(e.flows) != 1 || e.flows[0] != flow || e.reasons[0] != ReasonTCPClose {
+		t.Fatalf("expected flow to be exported on close, got %v %v", e.flows, e.reasons)
+	}
+	if table.Len() != 0 {
+		t.Errorf("expected empty table, got %d flows", table.Len())
+	}
+	fw, rv := flow.Counters[DirectionForward], flow.Counters[DirectionReverse]
+	if fw.Packets != 3 || rv.Packets != 2 {
+		t.Errorf("got %d/%d packets, want 3/2", fw.Packets, rv.Packets)
+	}
+	if fw.TCPFlags != TCPFlagSYN|TCPFlagACK|TCPFlagFIN || rv.TCPFlags != TCPFlagSYN|TCPFlagACK|TCPFlagFIN {
+		t.Errorf("unexpected TCP flags %#x/%#x", fw.TCPFlags, rv.TCPFlags)
+	}
+	if !rv.First.Equal(t0.Add(time.Second)) || !rv.Last.Equal(t0.Add(4*time.Second)) {
+		t.Errorf("unexpected reverse timestamps %v-%v", rv.First, rv.Last)
+	}
+	if flow.Duration() != 4*time.Second || flow.Total().Packets != 5 {
+		t.Errorf("unexpected totals %v", flow)
+	}
+	if flow.Key.Transport.Dst() != layers.NewTCPPortEndpoint(80) {
+		t.Errorf("expected key to follow the initiator, got %v", flow.Key)
+	}
+}
+
+func TestTableIdleTimeout(t *testing.T) {
+	var e exported
+	table := NewTable(Options{IdleTimeout: 10 * time.Second, Export: e.export})
+	table.Add(udpPacket(t, t0, true))
+	table.Add(udpPacket(t, t0.Add(5*time.Second), false))
+	if len(e.flows) != 0 {
+		t.Fatal("expected no flow to expire yet")
+	}
+	// A new flow far enough in the future expires the first one.
+	table.Add(ipPacket(t, t0.Add(16*time.Second), true, &layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0), Id: 1}))
+	if len(e.flows) != 1 || e.reasons[0] != ReasonIdleTimeout || e.flows[0].Total().Packets != 2 {
+		t.Fatalf("expected the UDP flow to expire, got %v %v", e.flows, e.reasons)
+	}
+	if n := table.Expire(t0.Add(30 * time.Second)); n != 1 || table.Len() != 0 {
+		t.Errorf("expected the ICMP flow to expire, got %d (%d left)", n, table.Len())
+	}
+}
+
+func TestTableActiveTimeout(t *testing.T) {
+	var e exported
+	table := NewTable(Options{ActiveTimeout: 60 * time.Second, Export: e.export})
+	var flows []*Flow
+	for i := 0; i < 9; i++ {
+		f, _ := table.Add(udpPacket(t, t0.Add(time.Duration(i)*20*time.Second), i%2 == 0))
+		if len(flows) == 0 || flows[len(flows)-1] != f {
+			flows = append(flows, f)
+		}
+	}
+	// Packets every 20 seconds: a flow lasting more than 60 seconds expires
+	// at its fifth packet, which starts a new flow.
+	if len(flows) != 3 || len(e.flows) != 2 || e.reasons[0] != ReasonActiveTimeout {
+		t.Fatalf("expected 3 flows with 2 exported, got %d and %v", len(flows), e.reasons)
+	}
+	if p := e.flows[0].Total().Packets; p != 4 {
+		t.Errorf("expected 4 packets in first flow, got %d", p)
+	}
+	if table.Flush() != 1 || e.reasons[2] != ReasonFlushed {
+		t.Errorf("expected Flush to export the last flow, got %v", e.reasons)
+	}
+}
+
+func TestTableEviction(t *testing.T) {
+	var e exported
+	table := NewTable(Options{MaxFlows: 2, Export: e.export})
+	first, _ := table.Add(udpPacket(t, t0, true))
+	second, _ := table.Add(tcpPacket(t, t0.Add(time.Second), true, layers.TCP{SYN: true}))
+	table.Add(udpPacket(t, t0.Add(2*time.Second), false))
+	table.Add(ipPacket(t, t0.Add(3*time.Second), true, &layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0), Id: 1}))
+	if len(e.flows) != 1 || e.flows[0] != second || e.reasons[0] != ReasonEvicted {
+		t.Fatalf("expected least recently seen flow to be evicted, got %v %v", e.flows, e.reasons)
+	}
+	if table.Len() != 2 || table.Flows()[0] != first {
+		t.Errorf("unexpected flows left %v", table.Flows())
+	}
+}
+
+func TestTableNonIP(t *testing.T) {
+	table := NewTable(Options{})
+	arp := func(fromA bool, op uint16) gopacket.Packet {
+		eth := &layers.Ethernet{SrcMAC: macA, DstMAC: macB, EthernetType: layers.EthernetTypeARP}
+		a := &layers.ARP{AddrType: layers.LinkTypeEthernet, Protocol: layers.EthernetTypeIPv4, HwAddressSize: 6, ProtAddressSize: 4, Operation: op,
+			SourceHwAddress: macA, SourceProtAddress: ipA, DstHwAddress: macB, DstProtAddress: ipB}
+		if !fromA {
+			eth.SrcMAC, eth.DstMAC = macB, macA
+			a.SourceHwAddress, a.DstHwAddress = macB, macA
+			a.SourceProtAddress, a.DstProtAddress = ipB, ipA
+		}
+		return newPacket(t, t0, eth, a)
+	}
+	f1, d1 := table.Add(arp(true, layers.ARPRequest))
+	f2, d2 := table.Add(arp(false, layers.ARPReply))
+	if f1 == nil || f1 != f2 || d1 == d2 {
+		t.Fatalf("expected ARP request and reply in one flow, got %v (%v) and %v (%v)", f1, d1, f2, d2)
+	}
+	if f1.Key.IsIP() || f1.Key.LinkPayload != layers.LayerTypeARP {
+		t.Errorf("unexpected key %v", f1.Key)
+	}
+	if table.Get(f1.Key.Reverse()) != f1 {
+		t.Error("expected Get to find the flow in the reverse direction")
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package impair emulates an impaired network link, in the spirit of Linux's
+// netem: packets passing through it can be lost, duplicated, reordered,
+// delayed, corrupted or truncated.
+//
+// Impairments can be applied to a gopacket.PacketDataSource with NewSource, or
+// to a PacketWriter (such as a pcapgo.Writer) with NewWriter, for example to
+// generate test captures:
+//
+//  w := impair.NewWriter(pcapWriter, impair.Options{
+//    Seed:   1,
+//    Loss:   0.01,
+//    Delay:  10 * time.Millisecond,
+//    Jitter: 5 * time.Millisecond,
+//  })
+//  for _, p := range packets {
+//    w.WritePacket(p.Metadata().CaptureInfo, p.Data())
+//  }
+//  w.Flush()
+//
+// Packets leave the link ordered by their new timestamps, so delay and jitter
+// reorder packets the same way they would on the wire.  Randomness comes from
+// Options.Seed, so the same input and options always give the same output.
+package impair
+
+import (
+	"container/heap"
+	"math/rand"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// GilbertElliott configures bursty loss with the Gilbert-Elliott model: a
+// two-state Markov chain, where each state has its own loss probability.
+type GilbertElliott struct {
+	// P is the probability of going from the good to the bad state, and R
+	// from the bad to the good state, evaluated for each packet.
+	P, R float64
+	// LossGood and LossBad are the loss probabilities in each state.  The
+	// simple Gilbert model has LossGood 0 and LossBad 1.
+	LossGood, LossBad float64
+}
+
+// Options configures the impairments of a link.  Probabilities range from 0
+// (never) to 1 (always).
+type Options struct {
+	// Seed seeds the random number generator.
+	Seed int64
+	// Loss is the probability a packet is lost, independently of other
+	// packets.
+	Loss float64
+	// GilbertElliott, if set, adds bursty loss.
+	GilbertElliott *GilbertElliott
+	// Duplicate is the probability a packet is sent twice.  Duplicates are
+	// delayed independently.
+	Duplicate float64
+	// Delay delays every packet, with Jitter of random variation either way.
+	// Packets are never delayed by less than zero.
+	Delay, Jitter time.Duration
+	// Reorder is the probability a packet is sent without delay, jumping
+	// ahead of delayed packets sent before it.  It has no effect without
+	// Delay or Jitter.
+	Reorder float64
+	// Corrupt is the probability a packet gets a random bit flipped.
+	Corrupt float64
+	// Truncate is the probability a packet is cut short at a random
+	// length.
+	Truncate float64
+	// SnapLen, if positive, truncates all packets to at most that many
+	// bytes.
+	SnapLen int
+}
+
+// Stats counts what a link did to packets.
+type Stats struct {
+	Packets, Lost, Duplicated, Reordered, Corrupted, Truncated int
+}
+
+type packet struct {
+	data []byte
+	ci   gopacket.CaptureInfo
+	// seq keeps packets sent at the same time in order.
+	seq int
+}
+
+type packetHeap []packet
+
+func (h packetHeap) Len() int { return len(h) }
+func (h packetHeap) Less(i, j int) bool {
+	if ti, tj := h[i].ci.Timestamp, h[j].ci.Timestamp; !ti.Equal(tj) {
+		return ti.Before(tj)
+	}
+	return h[i].seq < h[j].seq
+}
+func (h packetHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *packetHeap) Push(x interface{}) { *h = append(*h, x.(packet)) }
+func (h *packetHeap) Pop() interface{} {
+	old := *h
+	p := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return p
+}
+
+// link applies impairments, queuing packets until they're due.
+type link struct {
+	opts  Options
+	rand  *rand.Rand
+	bad   bool
+	seq   int
+	queue packetHeap
+	stats Stats
+}
+
+func newLink(opts Options) link {
+	return link{opts: opts, rand: rand.New(rand.NewSource(opts.Seed))}
+}
+
+func (l *link) chance(p float64) bool {
+	return p > 0 && l.rand.Float64() < p
+}
+
+// lost decides whether the next packet is lost.
+func (l *link) lost() bool {
+	if l.chance(l.opts.Loss) {
+		return true
+	}
+	if ge := l.opts.GilbertElliott; ge != nil {
+		if l.bad {
+			l.bad = !l.chance(ge.R)
+		} else {
+			l.bad = l.chance(ge.P)
+		}
+		if l.bad {
+			return l.chance(ge.LossBad)
+		}
+		return l.chance(ge.LossGood)
+	}
+	return false
+}
+
+// add impairs a packet read or written at ci.Timestamp, queuing whatever is
+// left of it.  The data is copied.
+func (l *link) add(data []byte, ci gopacket.CaptureInfo) {
+	l.stats.Packets++
+	if l.lost() {
+		l.stats.Lost++
+		return
+	}
+	copies := 1
+	if l.chance(l.opts.Duplicate) {
+		l.stats.Duplicated++
+		copies = 2
+	}
+	for i := 0; i < copies; i++ {
+		l.queueCopy(data, ci)
+	}
+}
+
+func (l *link) queueCopy(data []byte, ci gopacket.CaptureInfo) {
+	data = append([]byte(nil), data...)
+	if l.opts.SnapLen > 0 && len(data) > l.opts.SnapLen {
+		data = data[:l.opts.SnapLen]
+	}
+	if len(data) > 0 && l.chance(l.opts.Truncate) {
+		l.stats.Truncated++
+		data = data[:l.rand.Intn(len(data))]
+	}
+	if len(data) > 0 && l.chance(l.opts.Corrupt) {
+		l.stats.Corrupted++
+		bit := l.rand.Intn(len(data) * 8)
+		data[bit/8] ^= 1 << uint(bit%8)
+	}
+	// Length is the packet's length on the wire, which truncation doesn't
+	// change, as with a snap length.
+	if ci.Length < ci.CaptureLength {
+		ci.Length = ci.CaptureLength
+	}
+	ci.CaptureLength = len(data)
+	if l.chance(l.opts.Reorder) {
+		l.stats.Reordered++
+	} else {
+		delay := l.opts.Delay
+		if l.opts.Jitter > 0 {
+			delay += time.Duration(l.rand.Int63n(int64(2*l.opts.Jitter)+1)) - l.opts.Jitter
+		}
+		if delay > 0 {
+			ci.Timestamp = ci.Timestamp.Add(delay)
+		}
+	}
+	heap.Push(&l.queue, packet{data, ci, l.seq})
+	l.seq++
+}
+
+// due returns the next queued packet if it's due by now.
+func (l *link) due(now time.Time) (packet, bool) {
+	if len(l.queue) == 0 || l.queue[0].ci.Timestamp.After(now) {
+		return packet{}, false
+	}
+	return heap.Pop(&l.queue).(packet), true
+}
+
+// Source is a gopacket.PacketDataSource impairing the packets of another one.
+// The underlying source must return packets in timestamp order.  Create one
+// with NewSource.  It's not safe for concurrent use.
+type Source struct {
+	src  gopacket.PacketDataSource
+	link link
+	// now is the timestamp of the last packet read, and err the error
+	// returned by the underlying source, once it's done.
+	now time.Time
+	err error
+}
+
+// NewSource creates a Source impairing the packets of src.
+func NewSource(src gopacket.PacketDataSource, opts Options) *Source {
+	return &Source{src: src, link: newLink(opts)}
+}
+
+// ReadPacketData returns the next packet leaving the link.  Packets are
+// returned once a later packet has been read from the underlying source, or
+// once it's done, so none can overtake them.  The underlying source's error
+// (usually io.EOF) is returned once all packets have been returned.
+func (s *Source) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	for {
+		if p, ok := s.link.due(s.now); ok {
+			return p.data, p.ci, nil
+		}
+		if s.err != nil {
+			if len(s.link.queue) > 0 {
+				p := heap.Pop(&s.link.queue).(packet)
+				return p.data, p.ci, nil
+			}
+			return nil, gopacket.CaptureInfo{}, s.err
+		}
+		data, ci, err := s.src.ReadPacketData()
+		if err != nil {
+			s.err = err
+			continue
+		}
+		s.now = ci.Timestamp
+		s.link.add(data, ci)
+	}
+}
+
+// Stats returns what the link did to the packets read so far.
+func (s *Source) Stats() Stats {
+	return s.link.stats
+}
+
+// PacketWriter writes packets, such as pcapgo.Writer and pcapgo.NgWriter.
+type PacketWriter interface {
+	WritePacket(ci gopacket.CaptureInfo, data []byte) error
+}
+
+// Writer is a PacketWriter impairing the packets written to another one.
+// Packets must be written in timestamp order, and Flush must be called after
+// the last one.  Create one with NewWriter.  It's not safe for concurrent
+// use.
+type Writer struct {
+	w    PacketWriter
+	link link
+}
+
+// NewWriter creates a Writer impairing the packets written to w.
+func NewWriter(w PacketWriter, opts Options) *Writer {
+	return &Writer{w: w, link: newLink(opts)}
+}
+
+// WritePacket impairs a packet, then writes any packets which have left the
+// link by its timestamp.  The data is copied, so it may be reused once
+// WritePacket returns.
+func (w *Writer) WritePacket(ci gopacket.CaptureInfo, data []byte) error {
+	w.link.add(data, ci)
+	return w.writeDue(ci.Timestamp)
+}
+
+// Flush writes all packets still on the link.
+func (w *Writer) Flush() error {
+	for len(w.link.queue) > 0 {
+		p := heap.Pop(&w.link.queue).(packet)
+		if err := w.w.WritePacket(p.ci, p.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Writer) writeDue(now time.Time) error {
+	for {
+		p, ok := w.link.due(now)
+		if !ok {
+			return nil
+		}
+		if err := w.w.WritePacket(p.ci, p.data); err != nil {
+			return err
+		}
+	}
+}
+
+// Stats returns what the link did to the packets written so far.
+func (w *Writer) Stats() Stats {
+	return w.link.stats
+}
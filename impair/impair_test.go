@@ -0,0 +1,214 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package impair
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+var t0 = time.Unix(1000, 0)
+
+type testPacket struct {
+	data []byte
+	ci   gopacket.CaptureInfo
+}
+
+// testPackets returns n packets of 100 bytes, 1ms apart, whose first byte is
+// their index.
+func testPackets(n int) (pkts []testPacket) {
+	for i := 0; i < n; i++ {
+		data := make([]byte, 100)
+		data[0] = byte(i)
+		pkts = append(pkts, testPacket{data, gopacket.CaptureInfo{
+			Timestamp:     t0.Add(time.Duration(i) * time.Millisecond),
+			CaptureLength: 100,
+			Length:        100,
+		}})
+	}
+	return
+}
+
+type sliceSource []testPacket
+
+func (s *sliceSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if len(*s) == 0 {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+	p := (*s)[0]
+	*s = (*s)[1:]
+	return p.data, p.ci, nil
+}
+
+type sliceWriter []testPacket
+
+func (w *sliceWriter) WritePacket(ci gopacket.CaptureInfo, data []byte) error {
+	*w = append(*w, testPacket{data, ci})
+	return nil
+}
+
+func readAll(t *testing.T, s *Source) (pkts []testPacket) {
+	for {
+		data, ci, err := s.ReadPacketData()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkts = append(pkts, testPacket{data, ci})
+	}
+}
+
+func checkOrdered(t *testing.T, pkts []testPacket) {
+	for i := 1; i < len(pkts); i++ {
+		if pkts[i].ci.Timestamp.Before(pkts[i-1].ci.Timestamp) {
+			t.Errorf("packet %d at %v before previous at %v", i, pkts[i].ci.Timestamp, pkts[i-1].ci.Timestamp)
+		}
+	}
+}
+
+func TestNoImpairment(t *testing.T) {
+	in := testPackets(10)
+	src := sliceSource(testPackets(10))
+	if out := readAll(t, NewSource(&src, Options{})); !reflect.DeepEqual(in, out) {
+		t.Errorf("got %v, want %v", out, in)
+	}
+}
+
+func TestSourceAndWriterAgree(t *testing.T) {
+	opts := Options{
+		Seed:      42,
+		Loss:      0.1,
+		Duplicate: 0.1,
+		Delay:     5 * time.Millisecond,
+		Jitter:    5 * time.Millisecond,
+		Reorder:   0.1,
+		Corrupt:   0.1,
+		Truncate:  0.1,
+	}
+	src := sliceSource(testPackets(1000))
+	s := NewSource(&src, opts)
+	read := readAll(t, s)
+
+	var written sliceWriter
+	w := NewWriter(&written, opts)
+	for _, p := range testPackets(1000) {
+		if err := w.WritePacket(p.ci, p.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, []testPacket(written)) {
+		t.Error("source and writer disagree")
+	}
+	if s.Stats() != w.Stats() {
+		t.Errorf("stats disagree: %+v vs %+v", s.Stats(), w.Stats())
+	}
+
+	// The same seed gives the same results.
+	src = sliceSource(testPackets(1000))
+	if again := readAll(t, NewSource(&src, opts)); !reflect.DeepEqual(read, again) {
+		t.Error("results not reproducible")
+	}
+
+	stats := s.Stats()
+	t.Logf("%+v", stats)
+	if stats.Packets != 1000 || len(read) != stats.Packets-stats.Lost+stats.Duplicated {
+		t.Errorf("got %d packets, stats %+v", len(read), stats)
+	}
+	for name, n := range map[string]int{"lost": stats.Lost, "duplicated": stats.Duplicated, "reordered": stats.Reordered, "corrupted": stats.Corrupted, "truncated": stats.Truncated} {
+		if n < 50 || n > 150 {
+			t.Errorf("%d %s packets, expected about 100", n, name)
+		}
+	}
+	checkOrdered(t, read)
+	for i, p := range read {
+		if p.ci.CaptureLength != len(p.data) || p.ci.Length != 100 {
+			t.Errorf("packet %d: %d bytes, capture info %+v", i, len(p.data), p.ci)
+		}
+	}
+}
+
+func TestGilbertElliott(t *testing.T) {
+	src := sliceSource(testPackets(10000))
+	s := NewSource(&src, Options{Seed: 1, GilbertElliott: &GilbertElliott{P: 0.01, R: 0.1, LossBad: 1}})
+	read := readAll(t, s)
+	// The bad state lasts 10 packets on average, every 100 packets.
+	if lost := s.Stats().Lost; lost < 500 || lost > 1300 {
+		t.Errorf("lost %d packets, expected about 900", lost)
+	}
+	// Losses come in bursts.
+	gaps := 0
+	for i := 1; i < len(read); i++ {
+		if read[i].data[0] != read[i-1].data[0]+1 {
+			gaps++
+		}
+	}
+	if avg := float64(s.Stats().Lost) / float64(gaps); avg < 5 {
+		t.Errorf("%d losses in %d bursts, expected bursts of about 10", s.Stats().Lost, gaps)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	src := sliceSource(testPackets(10))
+	read := readAll(t, NewSource(&src, Options{Delay: 10 * time.Millisecond}))
+	for i, p := range read {
+		if want := t0.Add(time.Duration(10+i) * time.Millisecond); !p.ci.Timestamp.Equal(want) || p.data[0] != byte(i) {
+			t.Errorf("packet %d (%d) at %v, want %v", i, p.data[0], p.ci.Timestamp, want)
+		}
+	}
+}
+
+func TestReorder(t *testing.T) {
+	src := sliceSource(testPackets(10))
+	read := readAll(t, NewSource(&src, Options{Seed: 3, Delay: 5 * time.Millisecond, Reorder: 0.3}))
+	if len(read) != 10 {
+		t.Fatalf("got %d packets", len(read))
+	}
+	checkOrdered(t, read)
+	reordered := false
+	for i, p := range read {
+		if p.data[0] != byte(i) {
+			reordered = true
+		}
+	}
+	if !reordered {
+		t.Error("no packets reordered")
+	}
+}
+
+func TestCorruptAndTruncate(t *testing.T) {
+	in := testPackets(1)[0]
+	src := sliceSource{in}
+	read := readAll(t, NewSource(&src, Options{Corrupt: 1}))
+	diff := 0
+	for i := range in.data {
+		for b := read[0].data[i] ^ in.data[i]; b != 0; b &= b - 1 {
+			diff++
+		}
+	}
+	if diff != 1 {
+		t.Errorf("%d bits corrupted, want 1", diff)
+	}
+
+	src = sliceSource{in}
+	read = readAll(t, NewSource(&src, Options{SnapLen: 60}))
+	if len(read[0].data) != 60 || read[0].ci.CaptureLength != 60 || read[0].ci.Length != 100 {
+		t.Errorf("got %d bytes, capture info %+v", len(read[0].data), read[0].ci)
+	}
+	if !bytes.Equal(read[0].data, in.data[:60]) {
+		t.Error("truncated data differs")
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package framedstream splits the byte streams of connections into the
+// messages of protocols whose message headers hold their length, such as
+// DNS over TCP and BGP, where messages may span many segments, or share
+// them.
+package framedstream
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// Frame is a message split from a stream.
+type Frame struct {
+	// Network and Transport are the flows of the frame's direction, if
+	// known.
+	Network, Transport gopacket.Flow
+	// ToServer is whether the frame was sent by the endpoint which opened
+	// the connection.
+	ToServer bool
+	// Timestamp is the time the frame's first byte was received.
+	Timestamp time.Time
+	// Data is the message, header included, or nil if its header is
+	// invalid, and Err the reason why.  Data is a copy, which handlers may
+	// keep.
+	Data []byte
+	Err  error
+}
+
+// LengthFunc returns the length of the message starting with header, header
+// included, or an error if header is invalid.  The direction of the stream
+// then isn't decoded anymore, since the following message boundaries are
+// unknown.
+type LengthFunc func(toServer bool, header []byte) (int, error)
+
+// half is one direction of a Conn.
+type half struct {
+	buf []byte
+	// ts is the time buf's first byte was received.
+	ts   time.Time
+	lost bool
+}
+
+// Conn splits the two directions of a connection into messages, calling a
+// handler with each.  Conns are not safe for concurrent use.
+type Conn struct {
+	headerLength int
+	length       LengthFunc
+	handler      func(*Frame)
+	halves       [2]half
+}
+
+// NewConn returns a Conn calling length with the first headerLength bytes
+// of each message, and handler with each message.
+func NewConn(headerLength int, length LengthFunc, handler func(*Frame)) *Conn {
+	return &Conn{headerLength: headerLength, length: length, handler: handler}
+}
+
+func (c *Conn) half(toServer bool) *half {
+	if toServer {
+		return &c.halves[0]
+	}
+	return &c.halves[1]
+}
+
+// Write splits the data received at ts from the endpoint which opened the
+// connection, if toServer, or from the other one, calling the handler with
+// the messages it completes.
+func (c *Conn) Write(toServer bool, data []byte, ts time.Time) {
+	c.write(toServer, data, func(int) time.Time { return ts }, gopacket.Flow{}, gopacket.Flow{})
+}
+
+// Lost tells c that data of a direction was lost.  Since the boundaries of
+// the following messages are unknown, the direction isn't decoded anymore.
+func (c *Conn) Lost(toServer bool) {
+	h := c.half(toServer)
+	h.buf, h.lost = nil, true
+}
+
+// write splits data, whose byte at offset i was received at ts(i), into
+// frames of the given flows.
+func (c *Conn) write(toServer bool, data []byte, ts func(int) time.Time, network, transport gopacket.Flow) {
+	h := c.half(toServer)
+	if h.lost || len(data) == 0 {
+		return
+	}
+	if len(h.buf) == 0 {
+		h.ts = ts(0)
+	}
+	// off is the offset of the next message in buf, and start its offset in
+	// data, which is negative while it begins with bytes of previous writes.
+	buf := append(h.buf, data...)
+	off, start := 0, -len(h.buf)
+	for len(buf)-off >= c.headerLength {
+		f := &Frame{Network: network, Transport: transport, ToServer: toServer, Timestamp: h.ts}
+		n, err := c.length(toServer, buf[off:off+c.headerLength])
+		if err == nil && n < c.headerLength {
+			err = fmt.Errorf("message length %d shorter than its header", n)
+		}
+		if err != nil {
+			f.Err = err
+			c.handler(f)
+			c.Lost(toServer)
+			return
+		}
+		if len(buf)-off < n {
+			break
+		}
+		// Handlers may keep the data, and buf is reused.
+		f.Data = append([]byte(nil), buf[off:off+n]...)
+		c.handler(f)
+		off += n
+		start += n
+		if off < len(buf) {
+			h.ts = ts(start)
+		}
+	}
+	h.buf = buf[:copy(buf, buf[off:])]
+}
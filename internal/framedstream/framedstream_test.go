@@ -0,0 +1,62 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package framedstream
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// testLength frames messages whose first byte is their length, rejecting
+// zero lengths.
+func testLength(toServer bool, header []byte) (int, error) {
+	if header[0] == 0 {
+		return 0, errors.New("zero length")
+	}
+	return int(header[0]), nil
+}
+
+func TestConn(t *testing.T) {
+	var frames []*Frame
+	c := NewConn(1, testLength, func(f *Frame) { frames = append(frames, f) })
+	start := time.Unix(1000, 0)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	c.Write(true, []byte{3, 'a'}, at(0))
+	c.Write(true, []byte{'b', 2, 'c', 4}, at(1))
+	c.Write(false, []byte{2, 'x'}, at(2))
+	c.Write(true, []byte{'d', 'e', 'f'}, at(3))
+
+	want := []struct {
+		toServer bool
+		data     string
+		ts       time.Time
+	}{
+		{true, "\x03ab", at(0)},
+		{true, "\x02c", at(1)},
+		{false, "\x02x", at(2)},
+		{true, "\x04def", at(1)},
+	}
+	if len(frames) != len(want) {
+		t.Fatalf("got %d frames, want %d", len(frames), len(want))
+	}
+	for i, w := range want {
+		f := frames[i]
+		if f.ToServer != w.toServer || string(f.Data) != w.data || !f.Timestamp.Equal(w.ts) || f.Err != nil {
+			t.Errorf("frame %d: got %+v", i, f)
+		}
+	}
+
+	// Nothing is split after an invalid header.
+	frames = nil
+	c.Write(true, []byte{0, 2, 'a'}, at(4))
+	c.Write(true, []byte{2, 'a'}, at(5))
+	if len(frames) != 1 || frames[0].Err == nil || frames[0].Data != nil {
+		t.Errorf("got %d frames after invalid header", len(frames))
+	}
+}
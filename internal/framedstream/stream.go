@@ -0,0 +1,57 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package framedstream
+
+import (
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+// NewStream returns a reassembly.Stream writing the data of a TCP stream
+// of the given flows to conn.  Each frame's timestamp is the one of the
+// packet carrying its first byte, and splitting a direction stops at the
+// first gap in its data.  Streams picked up midstream are assumed to start
+// with a message.
+func NewStream(conn *Conn, netFlow, tcpFlow gopacket.Flow) reassembly.Stream {
+	return &stream{conn: conn, network: netFlow, transport: tcpFlow}
+}
+
+type stream struct {
+	conn               *Conn
+	network, transport gopacket.Flow
+}
+
+func (s *stream) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir reassembly.TCPFlowDirection, nextSeq reassembly.Sequence, start *bool, ac reassembly.AssemblerContext) bool {
+	return true
+}
+
+func (s *stream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.AssemblerContext) {
+	dir, _, _, skip := sg.Info()
+	toServer := dir == reassembly.TCPDirClientToServer
+	if skip > 0 {
+		s.conn.Lost(toServer)
+		return
+	}
+	length, _ := sg.Lengths()
+	if length == 0 {
+		return
+	}
+	network, transport := s.network, s.transport
+	if !toServer {
+		network, transport = network.Reverse(), transport.Reverse()
+	}
+	s.conn.write(toServer, sg.Fetch(length), func(offset int) time.Time {
+		return sg.CaptureInfo(offset).Timestamp
+	}, network, transport)
+}
+
+func (s *stream) ReassemblyComplete(ac reassembly.AssemblerContext) bool {
+	return true
+}
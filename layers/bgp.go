@@ -0,0 +1,528 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/google/gopacket"
+)
+
+// BGPType is the type of a BGP message.
+type BGPType uint8
+
+// Potential values for BGP.Type.
+const (
+	BGPTypeOpen         BGPType = 1
+	BGPTypeUpdate       BGPType = 2
+	BGPTypeNotification BGPType = 3
+	BGPTypeKeepalive    BGPType = 4
+	BGPTypeRouteRefresh BGPType = 5 // RFC 2918
+)
+
+func (t BGPType) String() string {
+	switch t {
+	case BGPTypeOpen:
+		return "Open"
+	case BGPTypeUpdate:
+		return "Update"
+	case BGPTypeNotification:
+		return "Notification"
+	case BGPTypeKeepalive:
+		return "Keepalive"
+	case BGPTypeRouteRefresh:
+		return "Route Refresh"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(t))
+	}
+}
+
+// BGPHeaderLength is the length of the header of BGP messages.
+const BGPHeaderLength = 19
+
+// BGP is a BGP-4 message, see RFC 4271.  A TCP segment may carry several
+// messages, each decoded as a BGP layer, and a message may span several
+// segments: the bgpstream package decodes the messages of reassembled
+// streams.
+type BGP struct {
+	BaseLayer
+	Length uint16
+	Type   BGPType
+	// Content is a BGPOpen, BGPUpdate, BGPNotification or BGPRouteRefresh,
+	// depending on Type, and nil for keepalives and unknown types.
+	Content interface{}
+}
+
+// LayerType returns LayerTypeBGP.
+func (b *BGP) LayerType() gopacket.LayerType { return LayerTypeBGP }
+
+// CanDecode returns the set of layer types that this DecodingLayer can decode.
+func (b *BGP) CanDecode() gopacket.LayerClass { return LayerTypeBGP }
+
+// NextLayerType returns LayerTypeBGP if the payload holds another complete
+// message, and gopacket.LayerTypePayload otherwise.
+func (b *BGP) NextLayerType() gopacket.LayerType {
+	payload := b.BaseLayer.Payload
+	if len(payload) == 0 {
+		return gopacket.LayerTypeZero
+	}
+	if n, ok := bgpMessageLength(payload); ok && n <= len(payload) {
+		return LayerTypeBGP
+	}
+	return gopacket.LayerTypePayload
+}
+
+// Payload returns nil: the message is decoded in Content, and the following
+// messages of the packet are decoded as further BGP layers.
+func (b *BGP) Payload() []byte {
+	return nil
+}
+
+// bgpMessageLength returns the length of the message data starts with, and
+// false if its header is incomplete or invalid.
+func bgpMessageLength(data []byte) (int, bool) {
+	if len(data) < BGPHeaderLength {
+		return 0, false
+	}
+	for _, b := range data[:16] {
+		if b != 0xff {
+			return 0, false
+		}
+	}
+	n := int(binary.BigEndian.Uint16(data[16:18]))
+	return n, n >= BGPHeaderLength
+}
+
+// DecodeFromBytes decodes the first message of data.
+func (b *BGP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < BGPHeaderLength {
+		df.SetTruncated()
+		return errors.New("BGP message too short")
+	}
+	n, ok := bgpMessageLength(data)
+	if !ok {
+		return errors.New("invalid BGP message header")
+	}
+	if n > len(data) {
+		df.SetTruncated()
+		return fmt.Errorf("BGP message length %d exceeds %d bytes", n, len(data))
+	}
+	*b = BGP{
+		BaseLayer: BaseLayer{Contents: data[:n], Payload: data[n:]},
+		Length:    uint16(n),
+		Type:      BGPType(data[18]),
+	}
+	body := data[BGPHeaderLength:n]
+	var err error
+	switch b.Type {
+	case BGPTypeOpen:
+		b.Content, err = decodeBGPOpen(body)
+	case BGPTypeUpdate:
+		b.Content, err = decodeBGPUpdate(body)
+	case BGPTypeNotification:
+		if len(body) < 2 {
+			return errors.New("BGP notification too short")
+		}
+		b.Content = BGPNotification{ErrorCode: BGPErrorCode(body[0]), ErrorSubcode: body[1], Data: body[2:]}
+	case BGPTypeKeepalive:
+		if len(body) != 0 {
+			return errors.New("BGP keepalive with data")
+		}
+	case BGPTypeRouteRefresh:
+		if len(body) != 4 {
+			return errors.New("invalid BGP route refresh length")
+		}
+		b.Content = BGPRouteRefresh{
+			AFI:     BGPAFI(binary.BigEndian.Uint16(body)),
+			Subtype: body[2],
+			SAFI:    BGPSAFI(body[3]),
+		}
+	}
+	return err
+}
+
+func decodeBGP(data []byte, p gopacket.PacketBuilder) error {
+	b := &BGP{}
+	if err := b.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(b)
+	// The first of the packet's messages is its application layer.
+	p.SetApplicationLayer(b)
+	// Going through the DecodingLayer interface keeps LayerTypeBGP, which
+	// NextLayerType returns, out of decodeBGP's initialization dependencies.
+	var d gopacket.DecodingLayer = b
+	next := d.NextLayerType()
+	if next == gopacket.LayerTypeZero {
+		return nil
+	}
+	return p.NextDecoder(next)
+}
+
+// BGPAFI is an address family identifier.
+type BGPAFI uint16
+
+// BGPAFI known values.
+const (
+	BGPAFIIPv4  BGPAFI = 1
+	BGPAFIIPv6  BGPAFI = 2
+	BGPAFIL2VPN BGPAFI = 25
+)
+
+func (a BGPAFI) String() string {
+	switch a {
+	case BGPAFIIPv4:
+		return "IPv4"
+	case BGPAFIIPv6:
+		return "IPv6"
+	case BGPAFIL2VPN:
+		return "L2VPN"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint16(a))
+	}
+}
+
+// BGPSAFI is a subsequent address family identifier.
+type BGPSAFI uint8
+
+// BGPSAFI known values.
+const (
+	BGPSAFIUnicast        BGPSAFI = 1
+	BGPSAFIMulticast      BGPSAFI = 2
+	BGPSAFILabeledUnicast BGPSAFI = 4   // RFC 8277
+	BGPSAFIEVPN           BGPSAFI = 70  // RFC 7432
+	BGPSAFIMPLSVPN        BGPSAFI = 128 // RFC 4364
+)
+
+func (s BGPSAFI) String() string {
+	switch s {
+	case BGPSAFIUnicast:
+		return "Unicast"
+	case BGPSAFIMulticast:
+		return "Multicast"
+	case BGPSAFILabeledUnicast:
+		return "Labeled Unicast"
+	case BGPSAFIEVPN:
+		return "EVPN"
+	case BGPSAFIMPLSVPN:
+		return "MPLS VPN"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(s))
+	}
+}
+
+// BGPOpen is the content of an OPEN message.
+type BGPOpen struct {
+	Version uint8
+	// MyAS is the AS of the sender, or 23456 (AS_TRANS) if it doesn't fit
+	// in 2 bytes: see AS.
+	MyAS          uint16
+	HoldTime      uint16
+	BGPIdentifier net.IP
+	// Capabilities are the capabilities of all the capabilities optional
+	// parameters, and Parameters the other optional parameters.
+	Capabilities []BGPCapability
+	Parameters   []BGPParameter
+}
+
+// BGPParameter is an optional parameter of an OPEN message.
+type BGPParameter struct {
+	Type uint8
+	Data []byte
+}
+
+// bgpParameterCapabilities is the type of the optional parameters holding
+// capabilities, see RFC 5492.
+const bgpParameterCapabilities = 2
+
+// AS returns the AS of the sender of an OPEN message, given by its 4-octet
+// AS number capability if any.
+func (o BGPOpen) AS() uint32 {
+	for _, c := range o.Capabilities {
+		if as, ok := c.FourOctetAS(); ok {
+			return as
+		}
+	}
+	return uint32(o.MyAS)
+}
+
+// Capability returns the first capability of code, if any.
+func (o BGPOpen) Capability(code BGPCapabilityCode) (BGPCapability, bool) {
+	for _, c := range o.Capabilities {
+		if c.Code == code {
+			return c, true
+		}
+	}
+	return BGPCapability{}, false
+}
+
+func decodeBGPOpen(data []byte) (BGPOpen, error) {
+	if len(data) < 10 {
+		return BGPOpen{}, errors.New("BGP open too short")
+	}
+	o := BGPOpen{
+		Version:       data[0],
+		MyAS:          binary.BigEndian.Uint16(data[1:3]),
+		HoldTime:      binary.BigEndian.Uint16(data[3:5]),
+		BGPIdentifier: net.IP(data[5:9]),
+	}
+	params, lengthSize := data[10:], 1
+	if data[9] == 255 && len(params) >= 3 && params[0] == 255 {
+		// Extended optional parameters length, see RFC 9072.
+		params, lengthSize = params[3:], 2
+		if n := int(binary.BigEndian.Uint16(data[11:13])); n != len(params) {
+			return BGPOpen{}, fmt.Errorf("invalid BGP open parameters length %d", n)
+		}
+	} else if int(data[9]) != len(params) {
+		return BGPOpen{}, fmt.Errorf("invalid BGP open parameters length %d", data[9])
+	}
+	for len(params) > 0 {
+		if len(params) < 1+lengthSize {
+			return BGPOpen{}, errors.New("BGP open parameter too short")
+		}
+		n := int(params[1])
+		if lengthSize == 2 {
+			n = int(binary.BigEndian.Uint16(params[1:]))
+		}
+		if len(params) < 1+lengthSize+n {
+			return BGPOpen{}, errors.New("BGP open parameter too short")
+		}
+		p := BGPParameter{Type: params[0], Data: params[1+lengthSize : 1+lengthSize+n]}
+		params = params[1+lengthSize+n:]
+		if p.Type != bgpParameterCapabilities {
+			o.Parameters = append(o.Parameters, p)
+			continue
+		}
+		for d := p.Data; len(d) > 0; {
+			if len(d) < 2 || len(d) < 2+int(d[1]) {
+				return BGPOpen{}, errors.New("BGP capability too short")
+			}
+			o.Capabilities = append(o.Capabilities, BGPCapability{Code: BGPCapabilityCode(d[0]), Data: d[2 : 2+int(d[1])]})
+			d = d[2+int(d[1]):]
+		}
+	}
+	return o, nil
+}
+
+// BGPCapabilityCode is the code of a capability.
+type BGPCapabilityCode uint8
+
+// BGPCapabilityCode known values.
+const (
+	BGPCapabilityMultiprotocol        BGPCapabilityCode = 1  // RFC 4760
+	BGPCapabilityRouteRefresh         BGPCapabilityCode = 2  // RFC 2918
+	BGPCapabilityExtendedNextHop      BGPCapabilityCode = 5  // RFC 8950
+	BGPCapabilityExtendedMessage      BGPCapabilityCode = 6  // RFC 8654
+	BGPCapabilityGracefulRestart      BGPCapabilityCode = 64 // RFC 4724
+	BGPCapabilityFourOctetAS          BGPCapabilityCode = 65 // RFC 6793
+	BGPCapabilityAddPath              BGPCapabilityCode = 69 // RFC 7911
+	BGPCapabilityEnhancedRouteRefresh BGPCapabilityCode = 70 // RFC 7313
+	BGPCapabilityFQDN                 BGPCapabilityCode = 73
+)
+
+func (c BGPCapabilityCode) String() string {
+	switch c {
+	case BGPCapabilityMultiprotocol:
+		return "Multiprotocol"
+	case BGPCapabilityRouteRefresh:
+		return "Route Refresh"
+	case BGPCapabilityExtendedNextHop:
+		return "Extended Next Hop"
+	case BGPCapabilityExtendedMessage:
+		return "Extended Message"
+	case BGPCapabilityGracefulRestart:
+		return "Graceful Restart"
+	case BGPCapabilityFourOctetAS:
+		return "Four-octet AS"
+	case BGPCapabilityAddPath:
+		return "ADD-PATH"
+	case BGPCapabilityEnhancedRouteRefresh:
+		return "Enhanced Route Refresh"
+	case BGPCapabilityFQDN:
+		return "FQDN"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(c))
+	}
+}
+
+// BGPCapability is a capability advertised in an OPEN message.
+type BGPCapability struct {
+	Code BGPCapabilityCode
+	Data []byte
+}
+
+// Multiprotocol returns the address family of a multiprotocol capability.
+func (c BGPCapability) Multiprotocol() (BGPAFI, BGPSAFI, bool) {
+	if c.Code != BGPCapabilityMultiprotocol || len(c.Data) != 4 {
+		return 0, 0, false
+	}
+	return BGPAFI(binary.BigEndian.Uint16(c.Data)), BGPSAFI(c.Data[3]), true
+}
+
+// FourOctetAS returns the AS of a 4-octet AS number capability.
+func (c BGPCapability) FourOctetAS() (uint32, bool) {
+	if c.Code != BGPCapabilityFourOctetAS || len(c.Data) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(c.Data), true
+}
+
+// BGPErrorCode is the error code of a NOTIFICATION message.
+type BGPErrorCode uint8
+
+// BGPErrorCode known values.
+const (
+	BGPErrorMessageHeader BGPErrorCode = 1
+	BGPErrorOpenMessage   BGPErrorCode = 2
+	BGPErrorUpdateMessage BGPErrorCode = 3
+	BGPErrorHoldTimer     BGPErrorCode = 4
+	BGPErrorFSM           BGPErrorCode = 5
+	BGPErrorCease         BGPErrorCode = 6
+	BGPErrorRouteRefresh  BGPErrorCode = 7 // RFC 7313
+)
+
+func (c BGPErrorCode) String() string {
+	switch c {
+	case BGPErrorMessageHeader:
+		return "Message Header Error"
+	case BGPErrorOpenMessage:
+		return "OPEN Message Error"
+	case BGPErrorUpdateMessage:
+		return "UPDATE Message Error"
+	case BGPErrorHoldTimer:
+		return "Hold Timer Expired"
+	case BGPErrorFSM:
+		return "Finite State Machine Error"
+	case BGPErrorCease:
+		return "Cease"
+	case BGPErrorRouteRefresh:
+		return "ROUTE-REFRESH Message Error"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(c))
+	}
+}
+
+// BGPNotification is the content of a NOTIFICATION message.
+type BGPNotification struct {
+	ErrorCode    BGPErrorCode
+	ErrorSubcode uint8
+	Data         []byte
+}
+
+// BGPRouteRefresh is the content of a ROUTE-REFRESH message.  Subtype is
+// zero, or marks the beginning (1) and end (2) of an enhanced route
+// refresh, see RFC 7313.
+type BGPRouteRefresh struct {
+	AFI     BGPAFI
+	Subtype uint8
+	SAFI    BGPSAFI
+}
+
+// BGPUpdate is the content of an UPDATE message.  WithdrawnRoutes and NLRI
+// are IPv4 unicast prefixes, other address families being carried by the
+// MP_REACH_NLRI and MP_UNREACH_NLRI attributes.
+type BGPUpdate struct {
+	WithdrawnRoutes []BGPPrefix
+	PathAttributes  []BGPPathAttribute
+	NLRI            []BGPPrefix
+}
+
+func decodeBGPUpdate(data []byte) (BGPUpdate, error) {
+	var u BGPUpdate
+	if len(data) < 2 {
+		return u, errors.New("BGP update too short")
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	if len(data) < 4+n {
+		return u, errors.New("invalid BGP update withdrawn routes length")
+	}
+	withdrawn := data[2 : 2+n]
+	data = data[2+n:]
+	n = int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+n {
+		return u, errors.New("invalid BGP update path attributes length")
+	}
+	attrs, nlri := data[2:2+n], data[2+n:]
+	var err error
+	if u.WithdrawnRoutes, err = decodeBGPPrefixes(withdrawn, BGPAFIIPv4, BGPSAFIUnicast); err != nil {
+		return u, err
+	}
+	if u.PathAttributes, err = decodeBGPPathAttributes(attrs); err != nil {
+		return u, err
+	}
+	u.NLRI, err = decodeBGPPrefixes(nlri, BGPAFIIPv4, BGPSAFIUnicast)
+	return u, err
+}
+
+// Attribute returns the first path attribute of type t, if any.
+func (u BGPUpdate) Attribute(t BGPAttributeType) (BGPPathAttribute, bool) {
+	for _, a := range u.PathAttributes {
+		if a.Type == t {
+			return a, true
+		}
+	}
+	return BGPPathAttribute{}, false
+}
+
+// ASPath returns the AS path of an update, reconstructed from its AS_PATH
+// and AS4_PATH attributes as in section 4.2.3 of RFC 6793.  as4 tells
+// whether AS_PATH holds 4 byte AS numbers, which is the case when both
+// speakers advertised the 4-octet AS number capability.
+func (u BGPUpdate) ASPath(as4 bool) ([]BGPASPathSegment, bool) {
+	attr, ok := u.Attribute(BGPAttributeASPath)
+	if !ok {
+		return nil, false
+	}
+	path, ok := attr.ASPath(as4)
+	if !ok || as4 {
+		return path, ok
+	}
+	attr, ok = u.Attribute(BGPAttributeAS4Path)
+	if !ok {
+		return path, true
+	}
+	path4, ok := attr.ASPath(true)
+	n, n4 := bgpASPathLength(path), bgpASPathLength(path4)
+	if !ok || n < n4 {
+		return path, true
+	}
+	// The leading ASes of AS_PATH, added by old speakers, are kept.
+	var merged []BGPASPathSegment
+	for _, s := range path {
+		if n == n4 {
+			break
+		}
+		switch s.Type {
+		case BGPASSequence:
+			if len(s.ASNs) > n-n4 {
+				s.ASNs = s.ASNs[:n-n4]
+			}
+			n -= len(s.ASNs)
+		case BGPASSet:
+			n--
+		}
+		merged = append(merged, s)
+	}
+	return append(merged, path4...), true
+}
+
+// bgpASPathLength returns the length of an AS path for route selection, an
+// AS_SET counting as one AS and confederation segments not counting.
+func bgpASPathLength(path []BGPASPathSegment) (n int) {
+	for _, s := range path {
+		switch s.Type {
+		case BGPASSequence:
+			n += len(s.ASNs)
+		case BGPASSet:
+			n++
+		}
+	}
+	return
+}
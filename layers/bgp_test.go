@@ -0,0 +1,221 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+// testBGPMessage returns a BGP message made of a header and parts.
+func testBGPMessage(t BGPType, parts ...[]byte) []byte {
+	msg := append(bytes.Repeat([]byte{0xff}, 16), 0, 0, byte(t))
+	for _, p := range parts {
+		msg = append(msg, p...)
+	}
+	binary.BigEndian.PutUint16(msg[16:], uint16(len(msg)))
+	return msg
+}
+
+// testBGPAttribute returns a path attribute.
+func testBGPAttribute(flags BGPAttributeFlags, t BGPAttributeType, data ...byte) []byte {
+	return append([]byte{byte(flags), byte(t), byte(len(data))}, data...)
+}
+
+func TestBGPOpen(t *testing.T) {
+	data := testBGPMessage(BGPTypeOpen, []byte{
+		4, 0x5b, 0xa0, 0, 90, 192, 0, 2, 1, // AS_TRANS, hold time and identifier
+		18,                      // optional parameters length
+		2, 16, 1, 4, 0, 2, 0, 1, // IPv6 unicast
+		65, 4, 0xfa, 0x56, 0xea, 0x00, // AS 4200000000
+		2, 0, // route refresh
+		6, 0, // extended message
+	})
+	p := gopacket.NewPacket(data, LayerTypeBGP, testDecodeOptions)
+	checkLayers(p, []gopacket.LayerType{LayerTypeBGP}, t)
+	b := p.Layer(LayerTypeBGP).(*BGP)
+	open, ok := b.Content.(BGPOpen)
+	if b.Type != BGPTypeOpen || int(b.Length) != len(data) || !ok {
+		t.Fatalf("got %+v", b)
+	}
+	if open.Version != 4 || open.MyAS != 23456 || open.HoldTime != 90 || !open.BGPIdentifier.Equal(net.IP{192, 0, 2, 1}) {
+		t.Errorf("got %+v", open)
+	}
+	if len(open.Capabilities) != 4 || open.AS() != 4200000000 {
+		t.Errorf("got capabilities %+v, AS %d", open.Capabilities, open.AS())
+	}
+	if afi, safi, ok := open.Capabilities[0].Multiprotocol(); !ok || afi != BGPAFIIPv6 || safi != BGPSAFIUnicast {
+		t.Errorf("got multiprotocol %v %v %v", afi, safi, ok)
+	}
+	if _, ok := open.Capability(BGPCapabilityExtendedMessage); !ok {
+		t.Error("no extended message capability")
+	}
+}
+
+func TestBGPUpdate(t *testing.T) {
+	const optTrans = BGPAttributeFlagOptional | BGPAttributeFlagTransitive
+	data := testBGPMessage(BGPTypeUpdate,
+		[]byte{0, 4, 24, 198, 51, 100}, // withdrawn 198.51.100.0/24
+		[]byte{0, 0},                   // path attributes length, set below
+		testBGPAttribute(BGPAttributeFlagTransitive, BGPAttributeOrigin, 0),
+		// 65001 AS_TRANS AS_TRANS, then {65010 65011}
+		testBGPAttribute(BGPAttributeFlagTransitive, BGPAttributeASPath, 2, 3, 0xfd, 0xe9, 0x5b, 0xa0, 0x5b, 0xa0, 1, 2, 0xfd, 0xf2, 0xfd, 0xf3),
+		// 4200000000 4200000001, then {65010 65011}
+		testBGPAttribute(optTrans, BGPAttributeAS4Path, 2, 2, 0xfa, 0x56, 0xea, 0, 0xfa, 0x56, 0xea, 1, 1, 2, 0, 0, 0xfd, 0xf2, 0, 0, 0xfd, 0xf3),
+		testBGPAttribute(BGPAttributeFlagTransitive, BGPAttributeNextHop, 192, 0, 2, 254),
+		testBGPAttribute(BGPAttributeFlagOptional, BGPAttributeMED, 0, 0, 0, 100),
+		testBGPAttribute(optTrans, BGPAttributeCommunities, 0xfd, 0xe9, 0, 10, 0xff, 0xff, 0xff, 0x01),
+		testBGPAttribute(optTrans, BGPAttributeLargeCommunities, 0xfa, 0x56, 0xea, 0, 0, 0, 0, 1, 0, 0, 0, 2),
+		testBGPAttribute(optTrans, BGPAttributeExtendedCommunities, 0, 2, 0xfd, 0xe9, 0, 0, 0, 7),
+		[]byte{21, 203, 0, 112}, // 203.0.112.0/21
+	)
+	binary.BigEndian.PutUint16(data[25:], uint16(len(data)-25-2-4))
+	// Two messages in a segment.
+	data = append(data, testBGPMessage(BGPTypeKeepalive)...)
+	p := gopacket.NewPacket(data, LayerTypeBGP, testDecodeOptions)
+	checkLayers(p, []gopacket.LayerType{LayerTypeBGP, LayerTypeBGP}, t)
+	u, ok := p.Layers()[0].(*BGP).Content.(BGPUpdate)
+	if !ok {
+		t.Fatalf("got %+v", p.Layers()[0])
+	}
+	if want := []BGPPrefix{{IP: net.IP{198, 51, 100, 0}, Length: 24}}; !reflect.DeepEqual(u.WithdrawnRoutes, want) {
+		t.Errorf("got withdrawn routes %v", u.WithdrawnRoutes)
+	}
+	if want := []BGPPrefix{{IP: net.IP{203, 0, 112, 0}, Length: 21}}; !reflect.DeepEqual(u.NLRI, want) {
+		t.Errorf("got NLRI %v", u.NLRI)
+	}
+	if len(u.PathAttributes) != 8 {
+		t.Fatalf("got %d path attributes", len(u.PathAttributes))
+	}
+	if o, ok := u.PathAttributes[0].Origin(); !ok || o != BGPOriginIGP {
+		t.Errorf("got origin %v %v", o, ok)
+	}
+	path, ok := u.ASPath(false)
+	want := []BGPASPathSegment{
+		{Type: BGPASSequence, ASNs: []uint32{65001}},
+		{Type: BGPASSequence, ASNs: []uint32{4200000000, 4200000001}},
+		{Type: BGPASSet, ASNs: []uint32{65010, 65011}},
+	}
+	if !ok || !reflect.DeepEqual(path, want) {
+		t.Errorf("got AS path %+v, %v", path, ok)
+	}
+	if nh, ok := u.PathAttributes[3].NextHop(); !ok || !nh.Equal(net.IP{192, 0, 2, 254}) {
+		t.Errorf("got next hop %v", nh)
+	}
+	if med, ok := u.PathAttributes[4].MED(); !ok || med != 100 {
+		t.Errorf("got MED %d", med)
+	}
+	if _, ok := u.PathAttributes[4].LocalPref(); ok {
+		t.Error("MED decoded as LOCAL_PREF")
+	}
+	if cs, ok := u.PathAttributes[5].Communities(); !ok || len(cs) != 2 || cs[0].String() != "65001:10" || cs[1] != BGPCommunityNoExport {
+		t.Errorf("got communities %v", cs)
+	}
+	if cs, ok := u.PathAttributes[6].LargeCommunities(); !ok || len(cs) != 1 || cs[0].String() != "4200000000:1:2" {
+		t.Errorf("got large communities %v", cs)
+	}
+	if cs, ok := u.PathAttributes[7].ExtendedCommunities(); !ok || len(cs) != 1 || cs[0][1] != 2 {
+		t.Errorf("got extended communities %v", cs)
+	}
+}
+
+func TestBGPMultiprotocol(t *testing.T) {
+	v6 := []byte(net.ParseIP("2001:db8::1"))
+	ll := []byte(net.ParseIP("fe80::1"))
+	var reach6 []byte
+	reach6 = append(reach6, 0, 2, 1, 32)
+	reach6 = append(append(append(reach6, v6...), ll...), 0)
+	reach6 = append(reach6, 48, 0x20, 0x01, 0x0d, 0xb8, 0x00, 0x01)
+	attr := BGPPathAttribute{Type: BGPAttributeMPReachNLRI, Data: reach6}
+	r, ok := attr.MPReach()
+	if !ok || r.AFI != BGPAFIIPv6 || len(r.NextHops) != 2 || !r.NextHops[1].Equal(net.ParseIP("fe80::1")) {
+		t.Fatalf("got %+v, %v", r, ok)
+	}
+	if len(r.Prefixes) != 1 || r.Prefixes[0].String() != "2001:db8:1::/48" {
+		t.Errorf("got prefixes %v", r.Prefixes)
+	}
+
+	// A VPNv4 route with label 100 and RD 65001:7.
+	vpn := []byte{0, 1, 128, 12, 0, 0, 0, 0, 0, 0, 0, 0, 192, 0, 2, 9, 0,
+		24 + 64 + 24, 0x00, 0x06, 0x41, 0, 0, 0xfd, 0xe9, 0, 0, 0, 7, 10, 1, 2}
+	attr = BGPPathAttribute{Type: BGPAttributeMPReachNLRI, Data: vpn}
+	if r, ok := attr.MPReach(); !ok || !r.NextHops[0].Equal(net.IP{192, 0, 2, 9}) || len(r.Prefixes) != 1 ||
+		!reflect.DeepEqual(r.Prefixes[0].Labels, []uint32{100}) || r.Prefixes[0].String() != "65001:7:10.1.2.0/24" {
+		t.Errorf("got VPN routes %+v, %v", r, ok)
+	}
+	attr = BGPPathAttribute{Type: BGPAttributeMPUnreachNLRI, Data: []byte{0, 1, 128,
+		24 + 64 + 24, 0x80, 0, 0, 0, 0, 0xfd, 0xe9, 0, 0, 0, 7, 10, 1, 2}}
+	if u, ok := attr.MPUnreach(); !ok || len(u.Prefixes) != 1 || u.Prefixes[0].String() != "65001:7:10.1.2.0/24" {
+		t.Errorf("got VPN withdrawals %+v, %v", u, ok)
+	}
+
+	rd := []byte{0, 1, 192, 0, 2, 1, 0, 5}
+	esi := make([]byte, 10)
+	var evpn []byte
+	evpn = append(evpn, 0, 25, 70, 4, 192, 0, 2, 1, 0)
+	// A MAC/IP advertisement with an IPv4 address and VNI 10010.
+	macIP := append(append(append([]byte{}, rd...), esi...), 0, 0, 0, 0, 48, 0, 0x11, 0x22, 0x33, 0x44, 0x55, 32, 10, 0, 0, 5, 0, 0x27, 0x1a)
+	evpn = append(append(evpn, 2, byte(len(macIP))), macIP...)
+	// An IPv4 prefix route.
+	prefix := append(append(append([]byte{}, rd...), esi...), 0, 0, 0, 0, 24, 10, 0, 1, 0, 0, 0, 0, 0, 0, 0x27, 0x1a)
+	evpn = append(append(evpn, 5, byte(len(prefix))), prefix...)
+	attr = BGPPathAttribute{Type: BGPAttributeMPReachNLRI, Data: evpn}
+	r, ok = attr.MPReach()
+	if !ok || len(r.EVPNRoutes) != 2 {
+		t.Fatalf("got EVPN routes %+v, %v", r, ok)
+	}
+	if m := r.EVPNRoutes[0]; m.Type != BGPEVPNMACIPAdvertisement || m.RD.String() != "192.0.2.1:5" ||
+		m.MAC.String() != "00:11:22:33:44:55" || !m.IP.Equal(net.IP{10, 0, 0, 5}) || !reflect.DeepEqual(m.Labels, []uint32{10010}) {
+		t.Errorf("got MAC/IP route %+v", m)
+	}
+	if p := r.EVPNRoutes[1]; p.Type != BGPEVPNIPPrefix || p.PrefixLength != 24 || !p.IP.Equal(net.IP{10, 0, 1, 0}) || !p.GatewayIP.Equal(net.IPv4zero) {
+		t.Errorf("got IP prefix route %+v", p)
+	}
+}
+
+func TestBGPOtherMessages(t *testing.T) {
+	for _, tc := range []struct {
+		data []byte
+		typ  BGPType
+		want interface{}
+	}{
+		{testBGPMessage(BGPTypeKeepalive), BGPTypeKeepalive, nil},
+		{testBGPMessage(BGPTypeNotification, []byte{6, 2, 'b', 'y', 'e'}), BGPTypeNotification,
+			BGPNotification{ErrorCode: BGPErrorCease, ErrorSubcode: 2, Data: []byte("bye")}},
+		{testBGPMessage(BGPTypeRouteRefresh, []byte{0, 2, 1, 1}), BGPTypeRouteRefresh,
+			BGPRouteRefresh{AFI: BGPAFIIPv6, Subtype: 1, SAFI: BGPSAFIUnicast}},
+	} {
+		b := &BGP{}
+		if err := b.DecodeFromBytes(tc.data, gopacket.NilDecodeFeedback); err != nil {
+			t.Errorf("%x: %v", tc.data, err)
+			continue
+		}
+		if b.Type != tc.typ || !reflect.DeepEqual(b.Content, tc.want) {
+			t.Errorf("%x: got %+v", tc.data, b)
+		}
+	}
+	bad := testBGPMessage(BGPTypeKeepalive)
+	bad[0] = 0
+	if err := (&BGP{}).DecodeFromBytes(bad, gopacket.NilDecodeFeedback); err == nil {
+		t.Error("decoded message with invalid marker")
+	}
+}
+
+func TestBGPApplicationLayer(t *testing.T) {
+	data := append(testBGPMessage(BGPTypeKeepalive), testBGPMessage(BGPTypeNotification, []byte{6, 2})...)
+	p := gopacket.NewPacket(data, LayerTypeBGP, testDecodeOptions)
+	checkLayers(p, []gopacket.LayerType{LayerTypeBGP, LayerTypeBGP}, t)
+	// The first message is the application layer.
+	if app, ok := p.ApplicationLayer().(*BGP); !ok || app.Type != BGPTypeKeepalive {
+		t.Errorf("got application layer %v", p.ApplicationLayer())
+	}
+}
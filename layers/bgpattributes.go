@@ -0,0 +1,646 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// BGPAttributeType is the type of a path attribute.
+type BGPAttributeType uint8
+
+// BGPAttributeType known values.
+const (
+	BGPAttributeOrigin              BGPAttributeType = 1
+	BGPAttributeASPath              BGPAttributeType = 2
+	BGPAttributeNextHop             BGPAttributeType = 3
+	BGPAttributeMED                 BGPAttributeType = 4
+	BGPAttributeLocalPref           BGPAttributeType = 5
+	BGPAttributeAtomicAggregate     BGPAttributeType = 6
+	BGPAttributeAggregator          BGPAttributeType = 7
+	BGPAttributeCommunities         BGPAttributeType = 8  // RFC 1997
+	BGPAttributeOriginatorID        BGPAttributeType = 9  // RFC 4456
+	BGPAttributeClusterList         BGPAttributeType = 10 // RFC 4456
+	BGPAttributeMPReachNLRI         BGPAttributeType = 14 // RFC 4760
+	BGPAttributeMPUnreachNLRI       BGPAttributeType = 15 // RFC 4760
+	BGPAttributeExtendedCommunities BGPAttributeType = 16 // RFC 4360
+	BGPAttributeAS4Path             BGPAttributeType = 17 // RFC 6793
+	BGPAttributeAS4Aggregator       BGPAttributeType = 18 // RFC 6793
+	BGPAttributeLargeCommunities    BGPAttributeType = 32 // RFC 8092
+)
+
+func (t BGPAttributeType) String() string {
+	switch t {
+	case BGPAttributeOrigin:
+		return "ORIGIN"
+	case BGPAttributeASPath:
+		return "AS_PATH"
+	case BGPAttributeNextHop:
+		return "NEXT_HOP"
+	case BGPAttributeMED:
+		return "MULTI_EXIT_DISC"
+	case BGPAttributeLocalPref:
+		return "LOCAL_PREF"
+	case BGPAttributeAtomicAggregate:
+		return "ATOMIC_AGGREGATE"
+	case BGPAttributeAggregator:
+		return "AGGREGATOR"
+	case BGPAttributeCommunities:
+		return "COMMUNITIES"
+	case BGPAttributeOriginatorID:
+		return "ORIGINATOR_ID"
+	case BGPAttributeClusterList:
+		return "CLUSTER_LIST"
+	case BGPAttributeMPReachNLRI:
+		return "MP_REACH_NLRI"
+	case BGPAttributeMPUnreachNLRI:
+		return "MP_UNREACH_NLRI"
+	case BGPAttributeExtendedCommunities:
+		return "EXTENDED_COMMUNITIES"
+	case BGPAttributeAS4Path:
+		return "AS4_PATH"
+	case BGPAttributeAS4Aggregator:
+		return "AS4_AGGREGATOR"
+	case BGPAttributeLargeCommunities:
+		return "LARGE_COMMUNITY"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(t))
+	}
+}
+
+// BGPAttributeFlags are the flags of a path attribute.
+type BGPAttributeFlags uint8
+
+// BGPAttributeFlags values.
+const (
+	BGPAttributeFlagOptional       BGPAttributeFlags = 0x80
+	BGPAttributeFlagTransitive     BGPAttributeFlags = 0x40
+	BGPAttributeFlagPartial        BGPAttributeFlags = 0x20
+	BGPAttributeFlagExtendedLength BGPAttributeFlags = 0x10
+)
+
+// BGPPathAttribute is a path attribute of an UPDATE message.  Its typed
+// accessors return false if the attribute isn't of their type or if its
+// data is invalid for it.  The values they return point into the
+// attribute's data.
+type BGPPathAttribute struct {
+	Flags BGPAttributeFlags
+	Type  BGPAttributeType
+	Data  []byte
+}
+
+func decodeBGPPathAttributes(data []byte) (attrs []BGPPathAttribute, err error) {
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return nil, errors.New("BGP path attribute too short")
+		}
+		a := BGPPathAttribute{Flags: BGPAttributeFlags(data[0]), Type: BGPAttributeType(data[1])}
+		n, off := int(data[2]), 3
+		if a.Flags&BGPAttributeFlagExtendedLength != 0 {
+			if len(data) < 4 {
+				return nil, errors.New("BGP path attribute too short")
+			}
+			n, off = int(binary.BigEndian.Uint16(data[2:])), 4
+		}
+		if len(data) < off+n {
+			return nil, fmt.Errorf("BGP path attribute %v too short", a.Type)
+		}
+		a.Data = data[off : off+n]
+		attrs = append(attrs, a)
+		data = data[off+n:]
+	}
+	return attrs, nil
+}
+
+func (a BGPPathAttribute) uint32Value(t BGPAttributeType) (uint32, bool) {
+	if a.Type != t || len(a.Data) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(a.Data), true
+}
+
+// BGPOrigin is the value of an ORIGIN attribute.
+type BGPOrigin uint8
+
+// BGPOrigin values.
+const (
+	BGPOriginIGP        BGPOrigin = 0
+	BGPOriginEGP        BGPOrigin = 1
+	BGPOriginIncomplete BGPOrigin = 2
+)
+
+func (o BGPOrigin) String() string {
+	switch o {
+	case BGPOriginIGP:
+		return "IGP"
+	case BGPOriginEGP:
+		return "EGP"
+	case BGPOriginIncomplete:
+		return "Incomplete"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(o))
+	}
+}
+
+// Origin returns the value of an ORIGIN attribute.
+func (a BGPPathAttribute) Origin() (BGPOrigin, bool) {
+	if a.Type != BGPAttributeOrigin || len(a.Data) != 1 {
+		return 0, false
+	}
+	return BGPOrigin(a.Data[0]), true
+}
+
+// BGPASPathSegmentType is the type of a segment of an AS path.
+type BGPASPathSegmentType uint8
+
+// BGPASPathSegmentType values.
+const (
+	BGPASSet            BGPASPathSegmentType = 1
+	BGPASSequence       BGPASPathSegmentType = 2
+	BGPASConfedSequence BGPASPathSegmentType = 3 // RFC 5065
+	BGPASConfedSet      BGPASPathSegmentType = 4 // RFC 5065
+)
+
+// BGPASPathSegment is a segment of an AS path.
+type BGPASPathSegment struct {
+	Type BGPASPathSegmentType
+	ASNs []uint32
+}
+
+// ASPath returns the segments of an AS_PATH or AS4_PATH attribute.  as4
+// tells whether the AS numbers of an AS_PATH are 4 bytes long, those of
+// AS4_PATH always being so.
+func (a BGPPathAttribute) ASPath(as4 bool) ([]BGPASPathSegment, bool) {
+	switch a.Type {
+	case BGPAttributeASPath:
+	case BGPAttributeAS4Path:
+		as4 = true
+	default:
+		return nil, false
+	}
+	size := 2
+	if as4 {
+		size = 4
+	}
+	var path []BGPASPathSegment
+	for d := a.Data; len(d) > 0; {
+		if len(d) < 2 || len(d) < 2+size*int(d[1]) {
+			return nil, false
+		}
+		s := BGPASPathSegment{Type: BGPASPathSegmentType(d[0]), ASNs: make([]uint32, d[1])}
+		for i := range s.ASNs {
+			if as4 {
+				s.ASNs[i] = binary.BigEndian.Uint32(d[2+4*i:])
+			} else {
+				s.ASNs[i] = uint32(binary.BigEndian.Uint16(d[2+2*i:]))
+			}
+		}
+		path = append(path, s)
+		d = d[2+size*len(s.ASNs):]
+	}
+	return path, true
+}
+
+// NextHop returns the address of a NEXT_HOP attribute.
+func (a BGPPathAttribute) NextHop() (net.IP, bool) {
+	if a.Type != BGPAttributeNextHop || len(a.Data) != 4 {
+		return nil, false
+	}
+	return net.IP(a.Data), true
+}
+
+// MED returns the value of a MULTI_EXIT_DISC attribute.
+func (a BGPPathAttribute) MED() (uint32, bool) {
+	return a.uint32Value(BGPAttributeMED)
+}
+
+// LocalPref returns the value of a LOCAL_PREF attribute.
+func (a BGPPathAttribute) LocalPref() (uint32, bool) {
+	return a.uint32Value(BGPAttributeLocalPref)
+}
+
+// Aggregator returns the AS and address of an AGGREGATOR or AS4_AGGREGATOR
+// attribute.  as4 tells whether the AS of an AGGREGATOR is 4 bytes long,
+// the one of AS4_AGGREGATOR always being so.
+func (a BGPPathAttribute) Aggregator(as4 bool) (uint32, net.IP, bool) {
+	switch a.Type {
+	case BGPAttributeAggregator:
+	case BGPAttributeAS4Aggregator:
+		as4 = true
+	default:
+		return 0, nil, false
+	}
+	if as4 && len(a.Data) == 8 {
+		return binary.BigEndian.Uint32(a.Data), net.IP(a.Data[4:]), true
+	}
+	if !as4 && len(a.Data) == 6 {
+		return uint32(binary.BigEndian.Uint16(a.Data)), net.IP(a.Data[2:]), true
+	}
+	return 0, nil, false
+}
+
+// BGPCommunity is a community of a COMMUNITIES attribute.
+type BGPCommunity uint32
+
+// Well-known communities.
+const (
+	BGPCommunityNoExport          BGPCommunity = 0xffffff01
+	BGPCommunityNoAdvertise       BGPCommunity = 0xffffff02
+	BGPCommunityNoExportSubconfed BGPCommunity = 0xffffff03
+)
+
+// String returns the community as "AS:value".
+func (c BGPCommunity) String() string {
+	return fmt.Sprintf("%d:%d", uint32(c)>>16, uint32(c)&0xffff)
+}
+
+// Communities returns the communities of a COMMUNITIES attribute.
+func (a BGPPathAttribute) Communities() ([]BGPCommunity, bool) {
+	if a.Type != BGPAttributeCommunities || len(a.Data)%4 != 0 {
+		return nil, false
+	}
+	var cs []BGPCommunity
+	for d := a.Data; len(d) > 0; d = d[4:] {
+		cs = append(cs, BGPCommunity(binary.BigEndian.Uint32(d)))
+	}
+	return cs, true
+}
+
+// BGPExtendedCommunity is a community of an EXTENDED_COMMUNITIES attribute.
+// Its first byte is its type, and the second its subtype for most types.
+type BGPExtendedCommunity [8]byte
+
+// ExtendedCommunities returns the communities of an EXTENDED_COMMUNITIES
+// attribute.
+func (a BGPPathAttribute) ExtendedCommunities() ([]BGPExtendedCommunity, bool) {
+	if a.Type != BGPAttributeExtendedCommunities || len(a.Data)%8 != 0 {
+		return nil, false
+	}
+	var cs []BGPExtendedCommunity
+	for d := a.Data; len(d) > 0; d = d[8:] {
+		var c BGPExtendedCommunity
+		copy(c[:], d)
+		cs = append(cs, c)
+	}
+	return cs, true
+}
+
+// BGPLargeCommunity is a community of a LARGE_COMMUNITY attribute.
+type BGPLargeCommunity struct {
+	GlobalAdministrator uint32
+	LocalData1          uint32
+	LocalData2          uint32
+}
+
+// String returns the community as "global:local1:local2".
+func (c BGPLargeCommunity) String() string {
+	return fmt.Sprintf("%d:%d:%d", c.GlobalAdministrator, c.LocalData1, c.LocalData2)
+}
+
+// LargeCommunities returns the communities of a LARGE_COMMUNITY attribute.
+func (a BGPPathAttribute) LargeCommunities() ([]BGPLargeCommunity, bool) {
+	if a.Type != BGPAttributeLargeCommunities || len(a.Data)%12 != 0 {
+		return nil, false
+	}
+	var cs []BGPLargeCommunity
+	for d := a.Data; len(d) > 0; d = d[12:] {
+		cs = append(cs, BGPLargeCommunity{
+			GlobalAdministrator: binary.BigEndian.Uint32(d),
+			LocalData1:          binary.BigEndian.Uint32(d[4:]),
+			LocalData2:          binary.BigEndian.Uint32(d[8:]),
+		})
+	}
+	return cs, true
+}
+
+// OriginatorID returns the router ID of an ORIGINATOR_ID attribute.
+func (a BGPPathAttribute) OriginatorID() (net.IP, bool) {
+	if a.Type != BGPAttributeOriginatorID || len(a.Data) != 4 {
+		return nil, false
+	}
+	return net.IP(a.Data), true
+}
+
+// ClusterList returns the cluster IDs of a CLUSTER_LIST attribute.
+func (a BGPPathAttribute) ClusterList() ([]net.IP, bool) {
+	if a.Type != BGPAttributeClusterList || len(a.Data)%4 != 0 {
+		return nil, false
+	}
+	var ids []net.IP
+	for d := a.Data; len(d) > 0; d = d[4:] {
+		ids = append(ids, net.IP(d[:4]))
+	}
+	return ids, true
+}
+
+// BGPMPReach is the content of an MP_REACH_NLRI attribute.  Routes of the
+// EVPN address family are in EVPNRoutes, and the ones of other families in
+// Prefixes.
+type BGPMPReach struct {
+	AFI  BGPAFI
+	SAFI BGPSAFI
+	// NextHops are the next hop and, for IPv6, its link-local address.  The
+	// route distinguisher of VPN next hops is left out.
+	NextHops   []net.IP
+	Prefixes   []BGPPrefix
+	EVPNRoutes []BGPEVPNRoute
+}
+
+// MPReach returns the content of an MP_REACH_NLRI attribute.
+func (a BGPPathAttribute) MPReach() (BGPMPReach, bool) {
+	d := a.Data
+	if a.Type != BGPAttributeMPReachNLRI || len(d) < 5 || len(d) < 5+int(d[3]) {
+		return BGPMPReach{}, false
+	}
+	r := BGPMPReach{AFI: BGPAFI(binary.BigEndian.Uint16(d)), SAFI: BGPSAFI(d[2])}
+	nh := d[4 : 4+d[3]]
+	if r.SAFI == BGPSAFIMPLSVPN {
+		if len(nh) < 8 {
+			return BGPMPReach{}, false
+		}
+		nh = nh[8:]
+	}
+	switch len(nh) {
+	case 4, 16:
+		r.NextHops = []net.IP{net.IP(nh)}
+	case 32:
+		r.NextHops = []net.IP{net.IP(nh[:16]), net.IP(nh[16:])}
+	case 0:
+	default:
+		return BGPMPReach{}, false
+	}
+	// A reserved byte follows the next hop.
+	var err error
+	r.Prefixes, r.EVPNRoutes, err = decodeBGPNLRI(d[5+int(d[3]):], r.AFI, r.SAFI)
+	return r, err == nil
+}
+
+// BGPMPUnreach is the content of an MP_UNREACH_NLRI attribute.
+type BGPMPUnreach struct {
+	AFI        BGPAFI
+	SAFI       BGPSAFI
+	Prefixes   []BGPPrefix
+	EVPNRoutes []BGPEVPNRoute
+}
+
+// MPUnreach returns the content of an MP_UNREACH_NLRI attribute.
+func (a BGPPathAttribute) MPUnreach() (BGPMPUnreach, bool) {
+	if a.Type != BGPAttributeMPUnreachNLRI || len(a.Data) < 3 {
+		return BGPMPUnreach{}, false
+	}
+	r := BGPMPUnreach{AFI: BGPAFI(binary.BigEndian.Uint16(a.Data)), SAFI: BGPSAFI(a.Data[2])}
+	var err error
+	r.Prefixes, r.EVPNRoutes, err = decodeBGPNLRI(a.Data[3:], r.AFI, r.SAFI)
+	return r, err == nil
+}
+
+func decodeBGPNLRI(data []byte, afi BGPAFI, safi BGPSAFI) ([]BGPPrefix, []BGPEVPNRoute, error) {
+	if afi == BGPAFIL2VPN && safi == BGPSAFIEVPN {
+		routes, err := decodeBGPEVPNRoutes(data)
+		return nil, routes, err
+	}
+	prefixes, err := decodeBGPPrefixes(data, afi, safi)
+	return prefixes, nil, err
+}
+
+// BGPRouteDistinguisher is the route distinguisher of VPN routes, see RFC
+// 4364.
+type BGPRouteDistinguisher [8]byte
+
+// String returns the route distinguisher as "administrator:value".
+func (rd BGPRouteDistinguisher) String() string {
+	switch binary.BigEndian.Uint16(rd[:]) {
+	case 0:
+		return fmt.Sprintf("%d:%d", binary.BigEndian.Uint16(rd[2:]), binary.BigEndian.Uint32(rd[4:]))
+	case 1:
+		return fmt.Sprintf("%v:%d", net.IP(rd[2:6]), binary.BigEndian.Uint16(rd[6:]))
+	case 2:
+		return fmt.Sprintf("%d:%d", binary.BigEndian.Uint32(rd[2:]), binary.BigEndian.Uint16(rd[6:]))
+	default:
+		return fmt.Sprintf("%x", rd[:])
+	}
+}
+
+// BGPPrefix is an IP prefix of an UPDATE message.  Labels and RD are set
+// for labeled and VPN routes.  Prefixes of the ADD-PATH extension aren't
+// supported.
+type BGPPrefix struct {
+	Labels []uint32
+	RD     BGPRouteDistinguisher
+	IP     net.IP
+	Length uint8
+}
+
+// String returns the prefix in CIDR notation, preceded by its route
+// distinguisher for VPN routes.
+func (p BGPPrefix) String() string {
+	s := fmt.Sprintf("%v/%d", p.IP, p.Length)
+	if p.RD != (BGPRouteDistinguisher{}) {
+		s = p.RD.String() + ":" + s
+	}
+	return s
+}
+
+func decodeBGPPrefixes(data []byte, afi BGPAFI, safi BGPSAFI) (prefixes []BGPPrefix, err error) {
+	size := 4
+	switch afi {
+	case BGPAFIIPv4:
+	case BGPAFIIPv6:
+		size = 16
+	default:
+		return nil, fmt.Errorf("unsupported BGP address family %v", afi)
+	}
+	for len(data) > 0 {
+		var p BGPPrefix
+		bits := int(data[0])
+		d := data[1:]
+		if safi == BGPSAFILabeledUnicast || safi == BGPSAFIMPLSVPN {
+			// Labels are read up to the one with the bottom of stack bit,
+			// or the compatibility value of withdrawals, see RFC 8277.
+			for {
+				if len(d) < 3 || bits < 24 {
+					return nil, errors.New("BGP labeled prefix too short")
+				}
+				v := uint32(d[0])<<16 | uint32(d[1])<<8 | uint32(d[2])
+				p.Labels = append(p.Labels, v>>4)
+				d, bits = d[3:], bits-24
+				if v&1 != 0 || v == 0x800000 || v == 0 {
+					break
+				}
+			}
+		}
+		if safi == BGPSAFIMPLSVPN {
+			if len(d) < 8 || bits < 64 {
+				return nil, errors.New("BGP VPN prefix too short")
+			}
+			copy(p.RD[:], d)
+			d, bits = d[8:], bits-64
+		}
+		n := (bits + 7) / 8
+		if bits > 8*size || len(d) < n {
+			return nil, fmt.Errorf("invalid BGP prefix length %d", bits)
+		}
+		p.IP = make(net.IP, size)
+		copy(p.IP, d[:n])
+		p.Length = uint8(bits)
+		prefixes = append(prefixes, p)
+		data = d[n:]
+	}
+	return prefixes, nil
+}
+
+// BGPEVPNRouteType is the type of an EVPN route.
+type BGPEVPNRouteType uint8
+
+// BGPEVPNRouteType known values.
+const (
+	BGPEVPNEthernetAutoDiscovery BGPEVPNRouteType = 1
+	BGPEVPNMACIPAdvertisement    BGPEVPNRouteType = 2
+	BGPEVPNInclusiveMulticast    BGPEVPNRouteType = 3
+	BGPEVPNEthernetSegment       BGPEVPNRouteType = 4
+	BGPEVPNIPPrefix              BGPEVPNRouteType = 5 // RFC 9136
+)
+
+func (t BGPEVPNRouteType) String() string {
+	switch t {
+	case BGPEVPNEthernetAutoDiscovery:
+		return "Ethernet Auto-Discovery"
+	case BGPEVPNMACIPAdvertisement:
+		return "MAC/IP Advertisement"
+	case BGPEVPNInclusiveMulticast:
+		return "Inclusive Multicast Ethernet Tag"
+	case BGPEVPNEthernetSegment:
+		return "Ethernet Segment"
+	case BGPEVPNIPPrefix:
+		return "IP Prefix"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(t))
+	}
+}
+
+// BGPEVPNRoute is a route of the EVPN address family, see RFC 7432.  The
+// fields set depend on its type, Data holding the whole route following
+// its type and length.
+type BGPEVPNRoute struct {
+	Type        BGPEVPNRouteType
+	RD          BGPRouteDistinguisher
+	ESI         [10]byte
+	EthernetTag uint32
+	MAC         net.HardwareAddr
+	// IP is the IP address of MAC/IP advertisement routes, the originating
+	// router's address of inclusive multicast and Ethernet segment routes,
+	// and the prefix of IP prefix routes, whose length is PrefixLength.
+	IP           net.IP
+	PrefixLength uint8
+	GatewayIP    net.IP
+	// Labels are the label fields, the MPLS label being their 20 most
+	// significant bits, or the VNI being the whole field with VXLAN.
+	Labels []uint32
+	Data   []byte
+}
+
+func decodeBGPEVPNRoutes(data []byte) (routes []BGPEVPNRoute, err error) {
+	for len(data) > 0 {
+		if len(data) < 2 || len(data) < 2+int(data[1]) {
+			return nil, errors.New("BGP EVPN route too short")
+		}
+		r := BGPEVPNRoute{Type: BGPEVPNRouteType(data[0]), Data: data[2 : 2+int(data[1])]}
+		data = data[2+int(data[1]):]
+		if !r.decode() {
+			return nil, fmt.Errorf("invalid BGP EVPN %v route", r.Type)
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+// decode sets the fields of known route types from Data.
+func (r *BGPEVPNRoute) decode() bool {
+	d := r.Data
+	// ip reads an address preceded by its length in bits.
+	ip := func() (net.IP, bool) {
+		if len(d) < 1 || len(d) < 1+int(d[0])/8 {
+			return nil, false
+		}
+		n := int(d[0]) / 8
+		if n != 0 && n != 4 && n != 16 {
+			return nil, false
+		}
+		var addr net.IP
+		if n > 0 {
+			addr = net.IP(d[1 : 1+n])
+		}
+		d = d[1+n:]
+		return addr, true
+	}
+	labels := func() {
+		for ; len(d) >= 3; d = d[3:] {
+			r.Labels = append(r.Labels, uint32(d[0])<<16|uint32(d[1])<<8|uint32(d[2]))
+		}
+	}
+	switch r.Type {
+	case BGPEVPNEthernetAutoDiscovery:
+		if len(d) != 25 {
+			return false
+		}
+	case BGPEVPNMACIPAdvertisement:
+		if len(d) < 33 || d[22] != 48 {
+			return false
+		}
+	case BGPEVPNInclusiveMulticast:
+		if len(d) < 13 {
+			return false
+		}
+		copy(r.RD[:], d)
+		r.EthernetTag = binary.BigEndian.Uint32(d[8:])
+		d = d[12:]
+		var ok bool
+		r.IP, ok = ip()
+		return ok && len(d) == 0
+	case BGPEVPNEthernetSegment:
+		if len(d) < 19 {
+			return false
+		}
+		copy(r.RD[:], d)
+		copy(r.ESI[:], d[8:])
+		d = d[18:]
+		var ok bool
+		r.IP, ok = ip()
+		return ok && len(d) == 0
+	case BGPEVPNIPPrefix:
+		if len(d) != 34 && len(d) != 58 {
+			return false
+		}
+	default:
+		return true
+	}
+	// The remaining types start with a route distinguisher, an Ethernet
+	// segment identifier and an Ethernet tag.
+	copy(r.RD[:], d)
+	copy(r.ESI[:], d[8:])
+	r.EthernetTag = binary.BigEndian.Uint32(d[18:])
+	d = d[22:]
+	switch r.Type {
+	case BGPEVPNMACIPAdvertisement:
+		r.MAC = net.HardwareAddr(d[1:7])
+		d = d[7:]
+		var ok bool
+		if r.IP, ok = ip(); !ok || (len(d) != 3 && len(d) != 6) {
+			return false
+		}
+	case BGPEVPNIPPrefix:
+		size := (len(d) - 4) / 2
+		r.PrefixLength = d[0]
+		r.IP, r.GatewayIP = net.IP(d[1:1+size]), net.IP(d[1+size:1+2*size])
+		d = d[1+2*size:]
+	}
+	labels()
+	return true
+}
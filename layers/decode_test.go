@@ -268,6 +268,27 @@ func BenchmarkDecodingLayerSparse(b *testing.B) {
 	benchmarkDecodingLayerContainer(b, gopacket.DecodingLayerSparse(nil))
 }
 
+func TestPacketDecoderMatchesNewPacket(t *testing.T) {
+	dec := gopacket.NewPacketDecoder(LayerTypeEthernet, &Ethernet{}, &IPv4{}, &TCP{}, &gopacket.Payload{})
+	want := gopacket.NewPacket(testSimpleTCPPacket, LinkTypeEthernet, gopacket.Default)
+	got := dec.DecodeInto(testSimpleTCPPacket, gopacket.CaptureInfo{})
+	checkLayers(got, []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeTCP, gopacket.LayerTypePayload}, t)
+	if got.NetworkLayer().NetworkFlow() != want.NetworkLayer().NetworkFlow() {
+		t.Errorf("network flow mismatch: got %v, want %v", got.NetworkLayer().NetworkFlow(), want.NetworkLayer().NetworkFlow())
+	}
+	if got.TransportLayer().TransportFlow() != want.TransportLayer().TransportFlow() {
+		t.Errorf("transport flow mismatch: got %v, want %v", got.TransportLayer().TransportFlow(), want.TransportLayer().TransportFlow())
+	}
+	if got.LinkLayer() == nil || got.ApplicationLayer() == nil || got.ErrorLayer() != nil {
+		t.Errorf("unexpected packet %v", got)
+	}
+	if allocs := testing.AllocsPerRun(100, func() {
+		dec.DecodeInto(testSimpleTCPPacket, gopacket.CaptureInfo{})
+	}); allocs != 0 {
+		t.Errorf("expected no allocations, got %v", allocs)
+	}
+}
+
 func BenchmarkAlloc(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		_ = &TCP{}
@@ -7,11 +7,14 @@
 package layers
 
 import (
+	"encoding/base32"
+	"encoding/base64"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/google/gopacket"
 )
@@ -52,26 +55,41 @@ type DNSType uint16
 
 // DNSType known values.
 const (
-	DNSTypeA     DNSType = 1   // a host address
-	DNSTypeNS    DNSType = 2   // an authoritative name server
-	DNSTypeMD    DNSType = 3   // a mail destination (Obsolete - use MX)
-	DNSTypeMF    DNSType = 4   // a mail forwarder (Obsolete - use MX)
-	DNSTypeCNAME DNSType = 5   // the canonical name for an alias
-	DNSTypeSOA   DNSType = 6   // marks the start of a zone of authority
-	DNSTypeMB    DNSType = 7   // a mailbox domain name (EXPERIMENTAL)
-	DNSTypeMG    DNSType = 8   // a mail group member (EXPERIMENTAL)
-	DNSTypeMR    DNSType = 9   // a mail rename domain name (EXPERIMENTAL)
-	DNSTypeNULL  DNSType = 10  // a null RR (EXPERIMENTAL)
-	DNSTypeWKS   DNSType = 11  // a well known service description
-	DNSTypePTR   DNSType = 12  // a domain name pointer
-	DNSTypeHINFO DNSType = 13  // host information
-	DNSTypeMINFO DNSType = 14  // mailbox or mail list information
-	DNSTypeMX    DNSType = 15  // mail exchange
-	DNSTypeTXT   DNSType = 16  // text strings
-	DNSTypeAAAA  DNSType = 28  // a IPv6 host address [RFC3596]
-	DNSTypeSRV   DNSType = 33  // server discovery [RFC2782] [RFC6195]
-	DNSTypeOPT   DNSType = 41  // OPT Pseudo-RR [RFC6891]
-	DNSTypeURI   DNSType = 256 // URI RR [RFC7553]
+	DNSTypeA          DNSType = 1   // a host address
+	DNSTypeNS         DNSType = 2   // an authoritative name server
+	DNSTypeMD         DNSType = 3   // a mail destination (Obsolete - use MX)
+	DNSTypeMF         DNSType = 4   // a mail forwarder (Obsolete - use MX)
+	DNSTypeCNAME      DNSType = 5   // the canonical name for an alias
+	DNSTypeSOA        DNSType = 6   // marks the start of a zone of authority
+	DNSTypeMB         DNSType = 7   // a mailbox domain name (EXPERIMENTAL)
+	DNSTypeMG         DNSType = 8   // a mail group member (EXPERIMENTAL)
+	DNSTypeMR         DNSType = 9   // a mail rename domain name (EXPERIMENTAL)
+	DNSTypeNULL       DNSType = 10  // a null RR (EXPERIMENTAL)
+	DNSTypeWKS        DNSType = 11  // a well known service description
+	DNSTypePTR        DNSType = 12  // a domain name pointer
+	DNSTypeHINFO      DNSType = 13  // host information
+	DNSTypeMINFO      DNSType = 14  // mailbox or mail list information
+	DNSTypeMX         DNSType = 15  // mail exchange
+	DNSTypeTXT        DNSType = 16  // text strings
+	DNSTypeAAAA       DNSType = 28  // a IPv6 host address [RFC3596]
+	DNSTypeSRV        DNSType = 33  // server discovery [RFC2782] [RFC6195]
+	DNSTypeNAPTR      DNSType = 35  // naming authority pointer [RFC3403]
+	DNSTypeDNAME      DNSType = 39  // delegation name [RFC6672]
+	DNSTypeOPT        DNSType = 41  // OPT Pseudo-RR [RFC6891]
+	DNSTypeDS         DNSType = 43  // delegation signer [RFC4034]
+	DNSTypeSSHFP      DNSType = 44  // SSH key fingerprint [RFC4255]
+	DNSTypeRRSIG      DNSType = 46  // resource record signature [RFC4034]
+	DNSTypeNSEC       DNSType = 47  // next secure [RFC4034]
+	DNSTypeDNSKEY     DNSType = 48  // DNS public key [RFC4034]
+	DNSTypeNSEC3      DNSType = 50  // hashed next secure [RFC5155]
+	DNSTypeNSEC3PARAM DNSType = 51  // NSEC3 parameters [RFC5155]
+	DNSTypeTLSA       DNSType = 52  // TLS certificate association [RFC6698]
+	DNSTypeCDS        DNSType = 59  // child DS [RFC7344]
+	DNSTypeCDNSKEY    DNSType = 60  // child DNSKEY [RFC7344]
+	DNSTypeSVCB       DNSType = 64  // general purpose service binding [RFC9460]
+	DNSTypeHTTPS      DNSType = 65  // HTTPS service binding [RFC9460]
+	DNSTypeURI        DNSType = 256 // URI RR [RFC7553]
+	DNSTypeCAA        DNSType = 257 // certification authority authorization [RFC8659]
 )
 
 func (dt DNSType) String() string {
@@ -114,10 +132,40 @@ func (dt DNSType) String() string {
 		return "AAAA"
 	case DNSTypeSRV:
 		return "SRV"
+	case DNSTypeNAPTR:
+		return "NAPTR"
+	case DNSTypeDNAME:
+		return "DNAME"
 	case DNSTypeOPT:
 		return "OPT"
+	case DNSTypeDS:
+		return "DS"
+	case DNSTypeSSHFP:
+		return "SSHFP"
+	case DNSTypeRRSIG:
+		return "RRSIG"
+	case DNSTypeNSEC:
+		return "NSEC"
+	case DNSTypeDNSKEY:
+		return "DNSKEY"
+	case DNSTypeNSEC3:
+		return "NSEC3"
+	case DNSTypeNSEC3PARAM:
+		return "NSEC3PARAM"
+	case DNSTypeTLSA:
+		return "TLSA"
+	case DNSTypeCDS:
+		return "CDS"
+	case DNSTypeCDNSKEY:
+		return "CDNSKEY"
+	case DNSTypeSVCB:
+		return "SVCB"
+	case DNSTypeHTTPS:
+		return "HTTPS"
 	case DNSTypeURI:
 		return "URI"
+	case DNSTypeCAA:
+		return "CAA"
 	}
 }
 
@@ -441,8 +489,36 @@ func recSize(rr *DNSResourceRecord) int {
 		return 4 + len(rr.URI.Target)
 	case DNSTypeOPT:
 		l := len(rr.OPT) * 4
-		for _, opt := range rr.OPT {
-			l += len(opt.Data)
+		for i := range rr.OPT {
+			l += rr.OPT[i].dataSize()
+		}
+		return l
+	case DNSTypeDNAME:
+		return nameSize(rr.DNAME)
+	case DNSTypeRRSIG:
+		return 18 + nameSize(rr.RRSIG.SignerName) + len(rr.RRSIG.Signature)
+	case DNSTypeDNSKEY, DNSTypeCDNSKEY:
+		return 4 + len(rr.DNSKEY.PublicKey)
+	case DNSTypeDS, DNSTypeCDS:
+		return 4 + len(rr.DS.Digest)
+	case DNSTypeNSEC:
+		return nameSize(rr.NSEC.NextDomain) + typeBitmapSize(rr.NSEC.Types)
+	case DNSTypeNSEC3:
+		return 6 + len(rr.NSEC3.Salt) + len(rr.NSEC3.NextHashedOwner) + typeBitmapSize(rr.NSEC3.Types)
+	case DNSTypeNSEC3PARAM:
+		return 5 + len(rr.NSEC3.Salt)
+	case DNSTypeTLSA:
+		return 3 + len(rr.TLSA.Data)
+	case DNSTypeSSHFP:
+		return 2 + len(rr.SSHFP.Fingerprint)
+	case DNSTypeCAA:
+		return 2 + len(rr.CAA.Tag) + len(rr.CAA.Value)
+	case DNSTypeNAPTR:
+		return 7 + len(rr.NAPTR.Flags) + len(rr.NAPTR.Service) + len(rr.NAPTR.Regexp) + nameSize(rr.NAPTR.Replacement)
+	case DNSTypeSVCB, DNSTypeHTTPS:
+		l := 2 + nameSize(rr.SVCB.Target)
+		for _, p := range rr.SVCB.Params {
+			l += 4 + len(p.Value)
 		}
 		return l
 	}
@@ -450,6 +526,14 @@ func recSize(rr *DNSResourceRecord) int {
 	return 0
 }
 
+// nameSize returns the size of an uncompressed encoded name.
+func nameSize(name []byte) int {
+	if len(name) == 0 {
+		return 1
+	}
+	return len(name) + 2
+}
+
 func computeSize(recs []DNSResourceRecord) int {
 	sz := 0
 	for _, rr := range recs {
@@ -468,22 +552,27 @@ func computeSize(recs []DNSResourceRecord) int {
 
 // SerializeTo writes the serialized form of this layer into the
 // SerializationBuffer, implementing gopacket.SerializableLayer.
+//
+// Names are compressed as described in RFC 1035, section 4.1.4: the owner
+// names of questions and records, and the names in the data of NS, CNAME,
+// PTR, SOA and MX records, are replaced by a pointer to the first occurrence
+// of their longest suffix already written.  Names are compared byte by byte,
+// so their case is kept.
 func (d *DNS) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	// The size of the uncompressed message bounds the compressed one, which
+	// we only know once it's written.
 	dsz := 0
 	for _, q := range d.Questions {
-		dsz += len(q.Name) + 6
+		dsz += nameSize(q.Name) + 4
 	}
 	dsz += computeSize(d.Answers)
 	dsz += computeSize(d.Authorities)
 	dsz += computeSize(d.Additionals)
+	data := make([]byte, 12+dsz)
 
-	bytes, err := b.PrependBytes(12 + dsz)
-	if err != nil {
-		return err
-	}
-	binary.BigEndian.PutUint16(bytes, d.ID)
-	bytes[2] = byte((b2i(d.QR) << 7) | (int(d.OpCode) << 3) | (b2i(d.AA) << 2) | (b2i(d.TC) << 1) | b2i(d.RD))
-	bytes[3] = byte((b2i(d.RA) << 7) | (int(d.Z) << 4) | int(d.ResponseCode))
+	binary.BigEndian.PutUint16(data, d.ID)
+	data[2] = byte((b2i(d.QR) << 7) | (int(d.OpCode) << 3) | (b2i(d.AA) << 2) | (b2i(d.TC) << 1) | b2i(d.RD))
+	data[3] = byte((b2i(d.RA) << 7) | (int(d.Z) << 4) | int(d.ResponseCode))
 
 	if opts.FixLengths {
 		d.QDCount = uint16(len(d.Questions))
@@ -491,46 +580,69 @@ func (d *DNS) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOpt
 		d.NSCount = uint16(len(d.Authorities))
 		d.ARCount = uint16(len(d.Additionals))
 	}
-	binary.BigEndian.PutUint16(bytes[4:], d.QDCount)
-	binary.BigEndian.PutUint16(bytes[6:], d.ANCount)
-	binary.BigEndian.PutUint16(bytes[8:], d.NSCount)
-	binary.BigEndian.PutUint16(bytes[10:], d.ARCount)
+	binary.BigEndian.PutUint16(data[4:], d.QDCount)
+	binary.BigEndian.PutUint16(data[6:], d.ANCount)
+	binary.BigEndian.PutUint16(data[8:], d.NSCount)
+	binary.BigEndian.PutUint16(data[10:], d.ARCount)
 
+	c := dnsNameCompression{}
 	off := 12
 	for _, qd := range d.Questions {
-		n := qd.encode(bytes, off)
+		n := qd.encode(data, off, c)
 		off += n
 	}
 
-	for i := range d.Answers {
-		// done this way so we can modify DNSResourceRecord to fix
-		// lengths if requested
-		qa := &d.Answers[i]
-		n, err := qa.encode(bytes, off, opts)
-		if err != nil {
-			return err
+	for _, records := range [][]DNSResourceRecord{d.Answers, d.Authorities, d.Additionals} {
+		for i := range records {
+			// done this way so we can modify DNSResourceRecord to fix
+			// lengths if requested
+			rr := &records[i]
+			n, err := rr.encode(data, off, opts, c)
+			if err != nil {
+				return err
+			}
+			off += n
 		}
-		off += n
 	}
 
-	for i := range d.Authorities {
-		qa := &d.Authorities[i]
-		n, err := qa.encode(bytes, off, opts)
-		if err != nil {
-			return err
-		}
-		off += n
+	bytes, err := b.PrependBytes(off)
+	if err != nil {
+		return err
 	}
-	for i := range d.Additionals {
-		qa := &d.Additionals[i]
-		n, err := qa.encode(bytes, off, opts)
-		if err != nil {
-			return err
+	copy(bytes, data)
+	return nil
+}
+
+// dnsNameCompression maps the names, and name suffixes, written to a message
+// to their offsets.
+type dnsNameCompression map[string]int
+
+// encodeName encodes name at offset of data, replacing its longest suffix
+// already written by a pointer, and returns the offset following it.
+func (c dnsNameCompression) encodeName(name []byte, data []byte, offset int) int {
+	if len(name) == 0 {
+		return encodeName(name, data, offset)
+	}
+	for i := 0; i < len(name); {
+		if ptr, ok := c[string(name[i:])]; ok {
+			binary.BigEndian.PutUint16(data[offset:], 0xc000|uint16(ptr))
+			return offset + 2
 		}
-		off += n
+		// Pointers have 14 bits.
+		if offset < 0x4000 {
+			c[string(name[i:])] = offset
+		}
+		l := 0
+		for i+l < len(name) && name[i+l] != '.' {
+			l++
+		}
+		data[offset] = byte(l)
+		copy(data[offset+1:], name[i:i+l])
+		offset += 1 + l
+		i += l + 1
 	}
-
-	return nil
+	data[offset] = 0x00 // terminal
+	return offset + 1
 }
 
 const maxRecursionLevel = 255
@@ -631,6 +743,9 @@ type DNSQuestion struct {
 	Name  []byte
 	Type  DNSType
 	Class DNSClass
+	// UnicastResponse is the top bit of the class of mDNS questions, see
+	// RFC 6762, section 5.4.  It's only decoded by MDNS, but always encoded.
+	UnicastResponse bool
 }
 
 func (q *DNSQuestion) decode(data []byte, offset int, df gopacket.DecodeFeedback, buffer *[]byte) (int, error) {
@@ -646,11 +761,11 @@ func (q *DNSQuestion) decode(data []byte, offset int, df gopacket.DecodeFeedback
 	return endq + 4, nil
 }
 
-func (q *DNSQuestion) encode(data []byte, offset int) int {
-	noff := encodeName(q.Name, data, offset)
+func (q *DNSQuestion) encode(data []byte, offset int, c dnsNameCompression) int {
+	noff := c.encodeName(q.Name, data, offset)
 	nSz := noff - offset
 	binary.BigEndian.PutUint16(data[noff:], uint16(q.Type))
-	binary.BigEndian.PutUint16(data[noff+2:], uint16(q.Class))
+	binary.BigEndian.PutUint16(data[noff+2:], uint16(q.Class)|uint16(b2i(q.UnicastResponse))<<15)
 	return nSz + 4
 }
 
@@ -683,20 +798,33 @@ type DNSResourceRecord struct {
 	Type  DNSType
 	Class DNSClass
 	TTL   uint32
+	// CacheFlush is the top bit of the class of mDNS records, see RFC 6762,
+	// section 10.2.  It's only decoded by MDNS, but always encoded.
+	CacheFlush bool
 
 	// RDATA Raw Values
 	DataLength uint16
 	Data       []byte
 
 	// RDATA Decoded Values
-	IP             net.IP
-	NS, CNAME, PTR []byte
-	TXTs           [][]byte
-	SOA            DNSSOA
-	SRV            DNSSRV
-	MX             DNSMX
-	OPT            []DNSOPT // See RFC 6891, section 6.1.2
-	URI            DNSURI
+	IP                    net.IP
+	NS, CNAME, PTR, DNAME []byte
+	TXTs                  [][]byte
+	SOA                   DNSSOA
+	SRV                   DNSSRV
+	MX                    DNSMX
+	OPT                   []DNSOPT // See RFC 6891, section 6.1.2
+	URI                   DNSURI
+	RRSIG                 DNSRRSIG
+	DNSKEY                DNSDNSKEY // Also used by CDNSKEY records
+	DS                    DNSDS     // Also used by CDS records
+	NSEC                  DNSNSEC
+	NSEC3                 DNSNSEC3 // Also used by NSEC3PARAM records
+	TLSA                  DNSTLSA
+	SSHFP                 DNSSSHFP
+	CAA                   DNSCAA
+	NAPTR                 DNSNAPTR
+	SVCB                  DNSSVCB // Also used by HTTPS records
 
 	// Undecoded TXT for backward compatibility
 	TXT []byte
@@ -753,13 +881,15 @@ func encodeName(name []byte, data []byte, offset int) int {
 	return offset + len(name) + 2
 }
 
-func (rr *DNSResourceRecord) encode(data []byte, offset int, opts gopacket.SerializeOptions) (int, error) {
+func (rr *DNSResourceRecord) encode(data []byte, offset int, opts gopacket.SerializeOptions, c dnsNameCompression) (int, error) {
 
-	noff := encodeName(rr.Name, data, offset)
+	noff := c.encodeName(rr.Name, data, offset)
 	nSz := noff - offset
+	// dEnd is the end of compressed data, whose size recSize doesn't know.
+	dEnd := 0
 
 	binary.BigEndian.PutUint16(data[noff:], uint16(rr.Type))
-	binary.BigEndian.PutUint16(data[noff+2:], uint16(rr.Class))
+	binary.BigEndian.PutUint16(data[noff+2:], uint16(rr.Class)|uint16(b2i(rr.CacheFlush))<<15)
 	binary.BigEndian.PutUint32(data[noff+4:], uint32(rr.TTL))
 
 	switch rr.Type {
@@ -768,22 +898,23 @@ func (rr *DNSResourceRecord) encode(data []byte, offset int, opts gopacket.Seria
 	case DNSTypeAAAA:
 		copy(data[noff+10:], rr.IP)
 	case DNSTypeNS:
-		encodeName(rr.NS, data, noff+10)
+		dEnd = c.encodeName(rr.NS, data, noff+10)
 	case DNSTypeCNAME:
-		encodeName(rr.CNAME, data, noff+10)
+		dEnd = c.encodeName(rr.CNAME, data, noff+10)
 	case DNSTypePTR:
-		encodeName(rr.PTR, data, noff+10)
+		dEnd = c.encodeName(rr.PTR, data, noff+10)
 	case DNSTypeSOA:
-		noff2 := encodeName(rr.SOA.MName, data, noff+10)
-		noff2 = encodeName(rr.SOA.RName, data, noff2)
+		noff2 := c.encodeName(rr.SOA.MName, data, noff+10)
+		noff2 = c.encodeName(rr.SOA.RName, data, noff2)
 		binary.BigEndian.PutUint32(data[noff2:], rr.SOA.Serial)
 		binary.BigEndian.PutUint32(data[noff2+4:], rr.SOA.Refresh)
 		binary.BigEndian.PutUint32(data[noff2+8:], rr.SOA.Retry)
 		binary.BigEndian.PutUint32(data[noff2+12:], rr.SOA.Expire)
 		binary.BigEndian.PutUint32(data[noff2+16:], rr.SOA.Minimum)
+		dEnd = noff2 + 20
 	case DNSTypeMX:
 		binary.BigEndian.PutUint16(data[noff+10:], rr.MX.Preference)
-		encodeName(rr.MX.Name, data, noff+12)
+		dEnd = c.encodeName(rr.MX.Name, data, noff+12)
 	case DNSTypeTXT:
 		noff2 := noff + 10
 		for _, txt := range rr.TXTs {
@@ -802,11 +933,91 @@ func (rr *DNSResourceRecord) encode(data []byte, offset int, opts gopacket.Seria
 		copy(data[noff+14:], rr.URI.Target)
 	case DNSTypeOPT:
 		noff2 := noff + 10
-		for _, opt := range rr.OPT {
+		for i := range rr.OPT {
+			opt := &rr.OPT[i]
+			n := opt.dataSize()
+			if n > 65535 {
+				return 0, fmt.Errorf("DNSOPT %v option too long", opt.Code)
+			}
 			binary.BigEndian.PutUint16(data[noff2:], uint16(opt.Code))
-			binary.BigEndian.PutUint16(data[noff2+2:], uint16(len(opt.Data)))
-			copy(data[noff2+4:], opt.Data)
-			noff2 += 4 + len(opt.Data)
+			binary.BigEndian.PutUint16(data[noff2+2:], uint16(n))
+			opt.encodeData(data[noff2+4:])
+			noff2 += 4 + n
+		}
+	case DNSTypeDNAME:
+		encodeName(rr.DNAME, data, noff+10)
+	case DNSTypeRRSIG:
+		binary.BigEndian.PutUint16(data[noff+10:], uint16(rr.RRSIG.TypeCovered))
+		data[noff+12] = uint8(rr.RRSIG.Algorithm)
+		data[noff+13] = rr.RRSIG.Labels
+		binary.BigEndian.PutUint32(data[noff+14:], rr.RRSIG.OriginalTTL)
+		binary.BigEndian.PutUint32(data[noff+18:], rr.RRSIG.Expiration)
+		binary.BigEndian.PutUint32(data[noff+22:], rr.RRSIG.Inception)
+		binary.BigEndian.PutUint16(data[noff+26:], rr.RRSIG.KeyTag)
+		noff2 := encodeName(rr.RRSIG.SignerName, data, noff+28)
+		copy(data[noff2:], rr.RRSIG.Signature)
+	case DNSTypeDNSKEY, DNSTypeCDNSKEY:
+		binary.BigEndian.PutUint16(data[noff+10:], rr.DNSKEY.Flags)
+		data[noff+12] = rr.DNSKEY.Protocol
+		data[noff+13] = uint8(rr.DNSKEY.Algorithm)
+		copy(data[noff+14:], rr.DNSKEY.PublicKey)
+	case DNSTypeDS, DNSTypeCDS:
+		binary.BigEndian.PutUint16(data[noff+10:], rr.DS.KeyTag)
+		data[noff+12] = uint8(rr.DS.Algorithm)
+		data[noff+13] = rr.DS.DigestType
+		copy(data[noff+14:], rr.DS.Digest)
+	case DNSTypeNSEC:
+		noff2 := encodeName(rr.NSEC.NextDomain, data, noff+10)
+		encodeTypeBitmap(rr.NSEC.Types, data[noff2:])
+	case DNSTypeNSEC3, DNSTypeNSEC3PARAM:
+		if len(rr.NSEC3.Salt) > 255 || len(rr.NSEC3.NextHashedOwner) > 255 {
+			return 0, errors.New("NSEC3 salt or hash too long")
+		}
+		data[noff+10] = rr.NSEC3.HashAlgorithm
+		data[noff+11] = rr.NSEC3.Flags
+		binary.BigEndian.PutUint16(data[noff+12:], rr.NSEC3.Iterations)
+		noff2 := encodeCharacterString(rr.NSEC3.Salt, data, noff+14)
+		if rr.Type == DNSTypeNSEC3 {
+			noff2 = encodeCharacterString(rr.NSEC3.NextHashedOwner, data, noff2)
+			encodeTypeBitmap(rr.NSEC3.Types, data[noff2:])
+		}
+	case DNSTypeTLSA:
+		data[noff+10] = rr.TLSA.Usage
+		data[noff+11] = rr.TLSA.Selector
+		data[noff+12] = rr.TLSA.MatchingType
+		copy(data[noff+13:], rr.TLSA.Data)
+	case DNSTypeSSHFP:
+		data[noff+10] = rr.SSHFP.Algorithm
+		data[noff+11] = rr.SSHFP.Type
+		copy(data[noff+12:], rr.SSHFP.Fingerprint)
+	case DNSTypeCAA:
+		if len(rr.CAA.Tag) > 255 {
+			return 0, errors.New("CAA tag too long")
+		}
+		data[noff+10] = rr.CAA.Flags
+		noff2 := encodeCharacterString(rr.CAA.Tag, data, noff+11)
+		copy(data[noff2:], rr.CAA.Value)
+	case DNSTypeNAPTR:
+		if len(rr.NAPTR.Flags) > 255 || len(rr.NAPTR.Service) > 255 || len(rr.NAPTR.Regexp) > 255 {
+			return 0, errors.New("NAPTR character string too long")
+		}
+		binary.BigEndian.PutUint16(data[noff+10:], rr.NAPTR.Order)
+		binary.BigEndian.PutUint16(data[noff+12:], rr.NAPTR.Preference)
+		noff2 := encodeCharacterString(rr.NAPTR.Flags, data, noff+14)
+		noff2 = encodeCharacterString(rr.NAPTR.Service, data, noff2)
+		noff2 = encodeCharacterString(rr.NAPTR.Regexp, data, noff2)
+		encodeName(rr.NAPTR.Replacement, data, noff2)
+	case DNSTypeSVCB, DNSTypeHTTPS:
+		binary.BigEndian.PutUint16(data[noff+10:], rr.SVCB.Priority)
+		noff2 := encodeName(rr.SVCB.Target, data, noff+12)
+		for _, p := range rr.SVCB.Params {
+			if len(p.Value) > 65535 {
+				return 0, errors.New("SVCB parameter too long")
+			}
+			binary.BigEndian.PutUint16(data[noff2:], uint16(p.Key))
+			binary.BigEndian.PutUint16(data[noff2+2:], uint16(len(p.Value)))
+			copy(data[noff2+4:], p.Value)
+			noff2 += 4 + len(p.Value)
 		}
 	default:
 		return 0, fmt.Errorf("serializing resource record of type %v not supported", rr.Type)
@@ -814,6 +1025,9 @@ func (rr *DNSResourceRecord) encode(data []byte, offset int, opts gopacket.Seria
 
 	// DataLength
 	dSz := recSize(rr)
+	if dEnd != 0 {
+		dSz = dEnd - (noff + 10)
+	}
 	binary.BigEndian.PutUint16(data[noff+8:], uint16(dSz))
 
 	if opts.FixLengths {
@@ -835,6 +1049,43 @@ func (rr *DNSResourceRecord) String() string {
 	if rr.Type == DNSTypeURI {
 		return fmt.Sprintf("URI %d %d %s", rr.URI.Priority, rr.URI.Weight, string(rr.URI.Target))
 	}
+	switch rr.Type {
+	case DNSTypeDNAME:
+		return "DNAME " + string(rr.DNAME)
+	case DNSTypeRRSIG:
+		return fmt.Sprintf("RRSIG %v %d %d %d %s %s %d %s %s", rr.RRSIG.TypeCovered, rr.RRSIG.Algorithm,
+			rr.RRSIG.Labels, rr.RRSIG.OriginalTTL, dnssecTime(rr.RRSIG.Expiration), dnssecTime(rr.RRSIG.Inception),
+			rr.RRSIG.KeyTag, presentationName(rr.RRSIG.SignerName), base64.StdEncoding.EncodeToString(rr.RRSIG.Signature))
+	case DNSTypeDNSKEY, DNSTypeCDNSKEY:
+		return fmt.Sprintf("%v %d %d %d %s", rr.Type, rr.DNSKEY.Flags, rr.DNSKEY.Protocol, rr.DNSKEY.Algorithm,
+			base64.StdEncoding.EncodeToString(rr.DNSKEY.PublicKey))
+	case DNSTypeDS, DNSTypeCDS:
+		return fmt.Sprintf("%v %d %d %d %X", rr.Type, rr.DS.KeyTag, rr.DS.Algorithm, rr.DS.DigestType, rr.DS.Digest)
+	case DNSTypeNSEC:
+		return fmt.Sprintf("NSEC %s%s", presentationName(rr.NSEC.NextDomain), typeList(rr.NSEC.Types))
+	case DNSTypeNSEC3:
+		return fmt.Sprintf("NSEC3 %d %d %d %s %s%s", rr.NSEC3.HashAlgorithm, rr.NSEC3.Flags, rr.NSEC3.Iterations,
+			salt(rr.NSEC3.Salt), base32.HexEncoding.WithPadding(base32.NoPadding).EncodeToString(rr.NSEC3.NextHashedOwner),
+			typeList(rr.NSEC3.Types))
+	case DNSTypeNSEC3PARAM:
+		return fmt.Sprintf("NSEC3PARAM %d %d %d %s", rr.NSEC3.HashAlgorithm, rr.NSEC3.Flags, rr.NSEC3.Iterations, salt(rr.NSEC3.Salt))
+	case DNSTypeTLSA:
+		return fmt.Sprintf("TLSA %d %d %d %X", rr.TLSA.Usage, rr.TLSA.Selector, rr.TLSA.MatchingType, rr.TLSA.Data)
+	case DNSTypeSSHFP:
+		return fmt.Sprintf("SSHFP %d %d %X", rr.SSHFP.Algorithm, rr.SSHFP.Type, rr.SSHFP.Fingerprint)
+	case DNSTypeCAA:
+		return fmt.Sprintf("CAA %d %s %q", rr.CAA.Flags, rr.CAA.Tag, rr.CAA.Value)
+	case DNSTypeNAPTR:
+		return fmt.Sprintf("NAPTR %d %d %q %q %q %s", rr.NAPTR.Order, rr.NAPTR.Preference, rr.NAPTR.Flags,
+			rr.NAPTR.Service, rr.NAPTR.Regexp, presentationName(rr.NAPTR.Replacement))
+	case DNSTypeSVCB, DNSTypeHTTPS:
+		params := make([]string, 0, len(rr.SVCB.Params)+2)
+		params = append(params, fmt.Sprint(rr.SVCB.Priority), presentationName(rr.SVCB.Target))
+		for _, p := range rr.SVCB.Params {
+			params = append(params, p.String())
+		}
+		return rr.Type.String() + " " + strings.Join(params, " ")
+	}
 	if rr.Class == DNSClassIN {
 		switch rr.Type {
 		case DNSTypeA, DNSTypeAAAA:
@@ -853,6 +1104,37 @@ func (rr *DNSResourceRecord) String() string {
 	return fmt.Sprintf("<%v, %v>", rr.Class, rr.Type)
 }
 
+// presentationName returns a decoded name as written in zone files.
+func presentationName(name []byte) string {
+	return string(name) + "."
+}
+
+// dnssecTime formats RRSIG times as YYYYMMDDHHmmSS, see RFC 4034, section
+// 3.2.
+func dnssecTime(t uint32) string {
+	return time.Unix(int64(t), 0).UTC().Format("20060102150405")
+}
+
+func salt(s []byte) string {
+	if len(s) == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%X", s)
+}
+
+func typeList(types []DNSType) string {
+	var b strings.Builder
+	for _, t := range types {
+		b.WriteByte(' ')
+		if t.String() == "Unknown" {
+			fmt.Fprintf(&b, "TYPE%d", uint16(t))
+		} else {
+			b.WriteString(t.String())
+		}
+	}
+	return b.String()
+}
+
 func decodeCharacterStrings(data []byte) ([][]byte, error) {
 	strings := make([][]byte, 0, 1)
 	end := len(data)
@@ -889,6 +1171,7 @@ func decodeOPTs(data []byte, offset int) ([]DNSOPT, error) {
 			return allOPT, fmt.Errorf("Malformed DNSOPT record. The length (%d) field implies a packet larger than the one received", l)
 		}
 		opt.Data = data[i+4 : i+4+int(l)]
+		opt.decodeData()
 		allOPT = append(allOPT, opt)
 		i += int(l) + 4
 	}
@@ -980,10 +1263,217 @@ func (rr *DNSResourceRecord) decodeRData(data []byte, offset int, buffer *[]byte
 			return err
 		}
 		rr.OPT = allOPT
+	case DNSTypeDNAME:
+		name, _, err := decodeName(data, offset, buffer, 1)
+		if err != nil {
+			return err
+		}
+		rr.DNAME = name
+	case DNSTypeRRSIG:
+		if len(rr.Data) < 19 {
+			return errors.New("RRSIG too small")
+		}
+		rr.RRSIG.TypeCovered = DNSType(binary.BigEndian.Uint16(data[offset:]))
+		rr.RRSIG.Algorithm = DNSSECAlgorithm(data[offset+2])
+		rr.RRSIG.Labels = data[offset+3]
+		rr.RRSIG.OriginalTTL = binary.BigEndian.Uint32(data[offset+4:])
+		rr.RRSIG.Expiration = binary.BigEndian.Uint32(data[offset+8:])
+		rr.RRSIG.Inception = binary.BigEndian.Uint32(data[offset+12:])
+		rr.RRSIG.KeyTag = binary.BigEndian.Uint16(data[offset+16:])
+		name, endq, err := decodeName(data, offset+18, buffer, 1)
+		if err != nil {
+			return err
+		}
+		rr.RRSIG.SignerName = name
+		rr.RRSIG.Signature = data[endq:]
+	case DNSTypeDNSKEY, DNSTypeCDNSKEY:
+		if len(rr.Data) < 4 {
+			return errors.New("DNSKEY too small")
+		}
+		rr.DNSKEY.Flags = binary.BigEndian.Uint16(rr.Data)
+		rr.DNSKEY.Protocol = rr.Data[2]
+		rr.DNSKEY.Algorithm = DNSSECAlgorithm(rr.Data[3])
+		rr.DNSKEY.PublicKey = rr.Data[4:]
+	case DNSTypeDS, DNSTypeCDS:
+		if len(rr.Data) < 4 {
+			return errors.New("DS too small")
+		}
+		rr.DS.KeyTag = binary.BigEndian.Uint16(rr.Data)
+		rr.DS.Algorithm = DNSSECAlgorithm(rr.Data[2])
+		rr.DS.DigestType = rr.Data[3]
+		rr.DS.Digest = rr.Data[4:]
+	case DNSTypeNSEC:
+		name, endq, err := decodeName(data, offset, buffer, 1)
+		if err != nil {
+			return err
+		}
+		rr.NSEC.NextDomain = name
+		if rr.NSEC.Types, err = decodeTypeBitmap(data[endq:]); err != nil {
+			return err
+		}
+	case DNSTypeNSEC3, DNSTypeNSEC3PARAM:
+		if len(rr.Data) < 5 {
+			return errors.New("NSEC3 too small")
+		}
+		rr.NSEC3.HashAlgorithm = rr.Data[0]
+		rr.NSEC3.Flags = rr.Data[1]
+		rr.NSEC3.Iterations = binary.BigEndian.Uint16(rr.Data[2:])
+		salt, rest, err := decodeCharacterString(rr.Data[4:])
+		if err != nil {
+			return err
+		}
+		rr.NSEC3.Salt = salt
+		if rr.Type == DNSTypeNSEC3 {
+			if rr.NSEC3.NextHashedOwner, rest, err = decodeCharacterString(rest); err != nil {
+				return err
+			}
+			if rr.NSEC3.Types, err = decodeTypeBitmap(rest); err != nil {
+				return err
+			}
+		}
+	case DNSTypeTLSA:
+		if len(rr.Data) < 3 {
+			return errors.New("TLSA too small")
+		}
+		rr.TLSA.Usage = rr.Data[0]
+		rr.TLSA.Selector = rr.Data[1]
+		rr.TLSA.MatchingType = rr.Data[2]
+		rr.TLSA.Data = rr.Data[3:]
+	case DNSTypeSSHFP:
+		if len(rr.Data) < 2 {
+			return errors.New("SSHFP too small")
+		}
+		rr.SSHFP.Algorithm = rr.Data[0]
+		rr.SSHFP.Type = rr.Data[1]
+		rr.SSHFP.Fingerprint = rr.Data[2:]
+	case DNSTypeCAA:
+		if len(rr.Data) < 2 {
+			return errors.New("CAA too small")
+		}
+		rr.CAA.Flags = rr.Data[0]
+		tag, value, err := decodeCharacterString(rr.Data[1:])
+		if err != nil {
+			return err
+		}
+		rr.CAA.Tag, rr.CAA.Value = tag, value
+	case DNSTypeNAPTR:
+		if len(rr.Data) < 4 {
+			return errors.New("NAPTR too small")
+		}
+		rr.NAPTR.Order = binary.BigEndian.Uint16(rr.Data)
+		rr.NAPTR.Preference = binary.BigEndian.Uint16(rr.Data[2:])
+		rest := rr.Data[4:]
+		var err error
+		for _, s := range []*[]byte{&rr.NAPTR.Flags, &rr.NAPTR.Service, &rr.NAPTR.Regexp} {
+			if *s, rest, err = decodeCharacterString(rest); err != nil {
+				return err
+			}
+		}
+		name, _, err := decodeName(data, len(data)-len(rest), buffer, 1)
+		if err != nil {
+			return err
+		}
+		rr.NAPTR.Replacement = name
+	case DNSTypeSVCB, DNSTypeHTTPS:
+		if len(rr.Data) < 3 {
+			return errors.New("SVCB too small")
+		}
+		rr.SVCB.Priority = binary.BigEndian.Uint16(rr.Data)
+		name, endq, err := decodeName(data, offset+2, buffer, 1)
+		if err != nil {
+			return err
+		}
+		rr.SVCB.Target = name
+		rr.SVCB.Params = nil
+		for rest := data[endq:]; len(rest) > 0; {
+			if len(rest) < 4 {
+				return errors.New("SVCB parameter too small")
+			}
+			l := int(binary.BigEndian.Uint16(rest[2:]))
+			if len(rest) < 4+l {
+				return errors.New("SVCB parameter length exceeds data")
+			}
+			rr.SVCB.Params = append(rr.SVCB.Params, DNSSVCBParam{
+				Key:   DNSSVCBParamKey(binary.BigEndian.Uint16(rest)),
+				Value: rest[4 : 4+l],
+			})
+			rest = rest[4+l:]
+		}
 	}
 	return nil
 }
 
+// decodeCharacterString decodes a single <character-string>, returning it
+// and the data after it.
+func decodeCharacterString(data []byte) ([]byte, []byte, error) {
+	if len(data) == 0 || len(data) < 1+int(data[0]) {
+		return nil, nil, errCharStringMissData
+	}
+	end := 1 + int(data[0])
+	return data[1:end], data[end:], nil
+}
+
+func encodeCharacterString(s []byte, data []byte, offset int) int {
+	data[offset] = byte(len(s))
+	copy(data[offset+1:], s)
+	return offset + 1 + len(s)
+}
+
+// decodeTypeBitmap decodes the type bit maps of NSEC and NSEC3 records, see
+// RFC 4034, section 4.1.2.
+func decodeTypeBitmap(data []byte) ([]DNSType, error) {
+	var types []DNSType
+	for len(data) > 0 {
+		if len(data) < 2 || data[1] == 0 || data[1] > 32 || len(data) < 2+int(data[1]) {
+			return nil, errors.New("invalid type bit map")
+		}
+		window := int(data[0]) << 8
+		for i, b := range data[2 : 2+data[1]] {
+			for bit := 0; bit < 8; bit++ {
+				if b&(0x80>>uint(bit)) != 0 {
+					types = append(types, DNSType(window|i*8+bit))
+				}
+			}
+		}
+		data = data[2+data[1]:]
+	}
+	return types, nil
+}
+
+// typeBitmap returns the windows of a type bit map, indexed by window
+// number, with the number of bytes used in each.
+func typeBitmap(types []DNSType) (windows [256][32]byte, lengths [256]int) {
+	for _, t := range types {
+		w, b := t>>8, int(t&0xff)
+		windows[w][b/8] |= 0x80 >> uint(b%8)
+		if b/8+1 > lengths[w] {
+			lengths[w] = b/8 + 1
+		}
+	}
+	return
+}
+
+func typeBitmapSize(types []DNSType) (size int) {
+	_, lengths := typeBitmap(types)
+	for _, l := range lengths {
+		if l > 0 {
+			size += 2 + l
+		}
+	}
+	return
+}
+
+func encodeTypeBitmap(types []DNSType, data []byte) {
+	windows, lengths := typeBitmap(types)
+	for w, l := range lengths {
+		if l > 0 {
+			data[0], data[1] = byte(w), byte(l)
+			copy(data[2:], windows[w][:l])
+			data = data[2+l:]
+		}
+	}
+}
+
 // DNSSOA is a Start of Authority record.  Each domain requires a SOA record at
 // the cutover where a domain is delegated from its parent.
 type DNSSOA struct {
@@ -1011,6 +1501,315 @@ type DNSURI struct {
 	Target           []byte
 }
 
+// DNSSECAlgorithm is the algorithm of a DNSSEC key or signature, see RFC 8624.
+type DNSSECAlgorithm uint8
+
+// DNSSECAlgorithm known values.  See IANA
+const (
+	DNSSECAlgorithmRSAMD5           DNSSECAlgorithm = 1
+	DNSSECAlgorithmDSA              DNSSECAlgorithm = 3
+	DNSSECAlgorithmRSASHA1          DNSSECAlgorithm = 5
+	DNSSECAlgorithmDSANSEC3SHA1     DNSSECAlgorithm = 6
+	DNSSECAlgorithmRSASHA1NSEC3SHA1 DNSSECAlgorithm = 7
+	DNSSECAlgorithmRSASHA256        DNSSECAlgorithm = 8
+	DNSSECAlgorithmRSASHA512        DNSSECAlgorithm = 10
+	DNSSECAlgorithmECCGOST          DNSSECAlgorithm = 12
+	DNSSECAlgorithmECDSAP256SHA256  DNSSECAlgorithm = 13
+	DNSSECAlgorithmECDSAP384SHA384  DNSSECAlgorithm = 14
+	DNSSECAlgorithmED25519          DNSSECAlgorithm = 15
+	DNSSECAlgorithmED448            DNSSECAlgorithm = 16
+)
+
+func (a DNSSECAlgorithm) String() string {
+	switch a {
+	default:
+		return "Unknown"
+	case DNSSECAlgorithmRSAMD5:
+		return "RSAMD5"
+	case DNSSECAlgorithmDSA:
+		return "DSA"
+	case DNSSECAlgorithmRSASHA1:
+		return "RSASHA1"
+	case DNSSECAlgorithmDSANSEC3SHA1:
+		return "DSA-NSEC3-SHA1"
+	case DNSSECAlgorithmRSASHA1NSEC3SHA1:
+		return "RSASHA1-NSEC3-SHA1"
+	case DNSSECAlgorithmRSASHA256:
+		return "RSASHA256"
+	case DNSSECAlgorithmRSASHA512:
+		return "RSASHA512"
+	case DNSSECAlgorithmECCGOST:
+		return "ECC-GOST"
+	case DNSSECAlgorithmECDSAP256SHA256:
+		return "ECDSAP256SHA256"
+	case DNSSECAlgorithmECDSAP384SHA384:
+		return "ECDSAP384SHA384"
+	case DNSSECAlgorithmED25519:
+		return "ED25519"
+	case DNSSECAlgorithmED448:
+		return "ED448"
+	}
+}
+
+// DNSRRSIG is a resource record signature, see RFC 4034, section 3.
+// Expiration and Inception are in seconds since 1 January 1970 UTC, modulo
+// 2**32.
+type DNSRRSIG struct {
+	TypeCovered           DNSType
+	Algorithm             DNSSECAlgorithm
+	Labels                uint8
+	OriginalTTL           uint32
+	Expiration, Inception uint32
+	KeyTag                uint16
+	SignerName            []byte
+	Signature             []byte
+}
+
+// DNSKEY flags, see RFC 4034 and RFC 5011.
+const (
+	DNSKEYFlagZone   = 0x0100
+	DNSKEYFlagRevoke = 0x0080
+	DNSKEYFlagSEP    = 0x0001
+)
+
+// DNSDNSKEY is a DNS public key, see RFC 4034, section 2.
+type DNSDNSKEY struct {
+	Flags     uint16
+	Protocol  uint8
+	Algorithm DNSSECAlgorithm
+	PublicKey []byte
+}
+
+// KeyTag returns the key tag of the key, used by RRSIG and DS records to
+// refer to it, see RFC 4034, appendix B.
+func (k *DNSDNSKEY) KeyTag() uint16 {
+	rdata := append([]byte{byte(k.Flags >> 8), byte(k.Flags), k.Protocol, byte(k.Algorithm)}, k.PublicKey...)
+	if k.Algorithm == DNSSECAlgorithmRSAMD5 {
+		// The tag of these keys is the most significant 16 of the least
+		// significant 24 bits of the modulus.
+		if len(rdata) < 4 {
+			return 0
+		}
+		return binary.BigEndian.Uint16(rdata[len(rdata)-3:])
+	}
+	var ac uint32
+	for i, b := range rdata {
+		if i&1 == 0 {
+			ac += uint32(b) << 8
+		} else {
+			ac += uint32(b)
+		}
+	}
+	ac += ac >> 16 & 0xffff
+	return uint16(ac)
+}
+
+// DNSDS is a delegation signer record, see RFC 4034, section 5.  DigestType
+// is 1 for SHA-1, 2 for SHA-256 and 4 for SHA-384.
+type DNSDS struct {
+	KeyTag     uint16
+	Algorithm  DNSSECAlgorithm
+	DigestType uint8
+	Digest     []byte
+}
+
+// DNSNSEC is a next secure record, see RFC 4034, section 4.
+type DNSNSEC struct {
+	NextDomain []byte
+	Types      []DNSType
+}
+
+// DNSNSEC3 is a hashed next secure record, see RFC 5155, section 3.
+// NSEC3PARAM records only have the HashAlgorithm, Flags, Iterations and
+// Salt fields.
+type DNSNSEC3 struct {
+	HashAlgorithm   uint8
+	Flags           uint8
+	Iterations      uint16
+	Salt            []byte
+	NextHashedOwner []byte
+	Types           []DNSType
+}
+
+// DNSTLSA is a TLS certificate association, see RFC 6698, section 2.
+type DNSTLSA struct {
+	Usage, Selector, MatchingType uint8
+	Data                          []byte
+}
+
+// DNSSSHFP is an SSH public key fingerprint, see RFC 4255, section 3.
+type DNSSSHFP struct {
+	Algorithm, Type uint8
+	Fingerprint     []byte
+}
+
+// DNSCAA is a certification authority authorization, see RFC 8659, section
+// 4.
+type DNSCAA struct {
+	Flags      uint8
+	Tag, Value []byte
+}
+
+// DNSNAPTR is a naming authority pointer, see RFC 3403, section 4.
+type DNSNAPTR struct {
+	Order, Preference      uint16
+	Flags, Service, Regexp []byte
+	Replacement            []byte
+}
+
+// DNSSVCBParamKey is the key of a service parameter of SVCB and HTTPS
+// records, see RFC 9460, section 14.3.
+type DNSSVCBParamKey uint16
+
+// DNSSVCBParamKey known values.  See IANA
+const (
+	DNSSVCBParamKeyMandatory     DNSSVCBParamKey = 0
+	DNSSVCBParamKeyALPN          DNSSVCBParamKey = 1
+	DNSSVCBParamKeyNoDefaultALPN DNSSVCBParamKey = 2
+	DNSSVCBParamKeyPort          DNSSVCBParamKey = 3
+	DNSSVCBParamKeyIPv4Hint      DNSSVCBParamKey = 4
+	DNSSVCBParamKeyECH           DNSSVCBParamKey = 5
+	DNSSVCBParamKeyIPv6Hint      DNSSVCBParamKey = 6
+	DNSSVCBParamKeyDoHPath       DNSSVCBParamKey = 7
+	DNSSVCBParamKeyOHTTP         DNSSVCBParamKey = 8
+)
+
+func (k DNSSVCBParamKey) String() string {
+	switch k {
+	default:
+		return fmt.Sprintf("key%d", uint16(k))
+	case DNSSVCBParamKeyMandatory:
+		return "mandatory"
+	case DNSSVCBParamKeyALPN:
+		return "alpn"
+	case DNSSVCBParamKeyNoDefaultALPN:
+		return "no-default-alpn"
+	case DNSSVCBParamKeyPort:
+		return "port"
+	case DNSSVCBParamKeyIPv4Hint:
+		return "ipv4hint"
+	case DNSSVCBParamKeyECH:
+		return "ech"
+	case DNSSVCBParamKeyIPv6Hint:
+		return "ipv6hint"
+	case DNSSVCBParamKeyDoHPath:
+		return "dohpath"
+	case DNSSVCBParamKeyOHTTP:
+		return "ohttp"
+	}
+}
+
+// DNSSVCBParam is a service parameter of SVCB and HTTPS records, with its
+// value in wire format.
+type DNSSVCBParam struct {
+	Key   DNSSVCBParamKey
+	Value []byte
+}
+
+func (p DNSSVCBParam) String() string {
+	var value string
+	switch p.Key {
+	case DNSSVCBParamKeyMandatory:
+		keys := make([]string, 0, len(p.Value)/2)
+		for i := 0; i+2 <= len(p.Value); i += 2 {
+			keys = append(keys, DNSSVCBParamKey(binary.BigEndian.Uint16(p.Value[i:])).String())
+		}
+		value = strings.Join(keys, ",")
+	case DNSSVCBParamKeyALPN:
+		value = strings.Join(decodeALPN(p.Value), ",")
+	case DNSSVCBParamKeyNoDefaultALPN, DNSSVCBParamKeyOHTTP:
+		return p.Key.String()
+	case DNSSVCBParamKeyPort:
+		if len(p.Value) == 2 {
+			value = fmt.Sprint(binary.BigEndian.Uint16(p.Value))
+		}
+	case DNSSVCBParamKeyIPv4Hint, DNSSVCBParamKeyIPv6Hint:
+		ips := make([]string, 0, 1)
+		for _, ip := range decodeIPHints(p.Key, p.Value) {
+			ips = append(ips, ip.String())
+		}
+		value = strings.Join(ips, ",")
+	case DNSSVCBParamKeyECH:
+		value = base64.StdEncoding.EncodeToString(p.Value)
+	case DNSSVCBParamKeyDoHPath:
+		value = string(p.Value)
+	default:
+		value = fmt.Sprintf("%x", p.Value)
+	}
+	return p.Key.String() + "=" + value
+}
+
+func decodeALPN(data []byte) (ids []string) {
+	for len(data) > 0 {
+		id, rest, err := decodeCharacterString(data)
+		if err != nil {
+			break
+		}
+		ids = append(ids, string(id))
+		data = rest
+	}
+	return
+}
+
+func decodeIPHints(key DNSSVCBParamKey, data []byte) (ips []net.IP) {
+	size := 4
+	if key == DNSSVCBParamKeyIPv6Hint {
+		size = 16
+	}
+	for ; len(data) >= size; data = data[size:] {
+		ips = append(ips, net.IP(data[:size]))
+	}
+	return
+}
+
+// DNSSVCB is a service binding, used by SVCB and HTTPS records, see RFC 9460,
+// section 2.  Records with a Priority of zero are aliases to their Target,
+// and have no Params.
+type DNSSVCB struct {
+	Priority uint16
+	Target   []byte
+	Params   []DNSSVCBParam
+}
+
+// Param returns the value of a parameter, and whether it's present.
+func (s *DNSSVCB) Param(key DNSSVCBParamKey) ([]byte, bool) {
+	for _, p := range s.Params {
+		if p.Key == key {
+			return p.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ALPN returns the protocol identifiers of the alpn parameter, such as "h2"
+// and "h3".
+func (s *DNSSVCB) ALPN() []string {
+	v, _ := s.Param(DNSSVCBParamKeyALPN)
+	return decodeALPN(v)
+}
+
+// Port returns the value of the port parameter, and whether it's present.
+func (s *DNSSVCB) Port() (uint16, bool) {
+	v, ok := s.Param(DNSSVCBParamKeyPort)
+	if !ok || len(v) != 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(v), true
+}
+
+// IPHints returns the addresses of the ipv4hint and ipv6hint parameters.
+func (s *DNSSVCB) IPHints() []net.IP {
+	v4, _ := s.Param(DNSSVCBParamKeyIPv4Hint)
+	v6, _ := s.Param(DNSSVCBParamKeyIPv6Hint)
+	return append(decodeIPHints(DNSSVCBParamKeyIPv4Hint, v4), decodeIPHints(DNSSVCBParamKeyIPv6Hint, v6)...)
+}
+
+// ECH returns the ECHConfigList of the ech parameter, or nil.
+func (s *DNSSVCB) ECH() []byte {
+	v, _ := s.Param(DNSSVCBParamKeyECH)
+	return v
+}
+
 // DNSOptionCode represents the code of a DNS Option, see RFC6891, section 6.1.2
 type DNSOptionCode uint16
 
@@ -1040,6 +1839,8 @@ func (doc DNSOptionCode) String() string {
 		return "CodeChain"
 	case DNSOptionCodeEDNSKeyTag:
 		return "CodeEDNSKeyTag"
+	case DNSOptionCodeExtendedDNSError:
+		return "ExtendedDNSError"
 	case DNSOptionCodeEDNSClientTag:
 		return "EDNSClientTag"
 	case DNSOptionCodeEDNSServerTag:
@@ -1062,21 +1863,282 @@ const (
 	DNSOptionCodePadding          DNSOptionCode = 12
 	DNSOptionCodeChain            DNSOptionCode = 13
 	DNSOptionCodeEDNSKeyTag       DNSOptionCode = 14
+	DNSOptionCodeExtendedDNSError DNSOptionCode = 15
 	DNSOptionCodeEDNSClientTag    DNSOptionCode = 16
 	DNSOptionCodeEDNSServerTag    DNSOptionCode = 17
 	DNSOptionCodeDeviceID         DNSOptionCode = 26946
 )
 
 // DNSOPT is a DNS Option, see RFC6891, section 6.1.2
+//
+// The options with a typed value below are decoded into it, Data keeping
+// their bytes, and it is encoded instead of Data when serializing them,
+// unless it is unset and Data isn't empty.  Options which don't fit their
+// typed format are only decoded into Data.  The NSID of NSID options, and
+// the zero bytes of Padding options, are their Data.
 type DNSOPT struct {
 	Code DNSOptionCode
 	Data []byte
+
+	ClientSubnet  DNSClientSubnet  // EDNSClientSubnet options
+	Cookie        DNSCookie        // Cookie options
+	KeepAlive     DNSKeepAlive     // EDNSKeepAlive options
+	ExtendedError DNSExtendedError // ExtendedDNSError options
 }
 
 func (opt DNSOPT) String() string {
+	if opt.rawData() {
+		return fmt.Sprintf("%s=%x", opt.Code, opt.Data)
+	}
+	switch opt.Code {
+	case DNSOptionCodeEDNSClientSubnet:
+		return fmt.Sprintf("%s=%s", opt.Code, opt.ClientSubnet)
+	case DNSOptionCodeCookie:
+		return fmt.Sprintf("%s=%x%x", opt.Code, opt.Cookie.Client, opt.Cookie.Server)
+	case DNSOptionCodeEDNSKeepAlive:
+		if !opt.KeepAlive.HasTimeout {
+			return opt.Code.String()
+		}
+		return fmt.Sprintf("%s=%v", opt.Code, opt.KeepAlive.Duration())
+	case DNSOptionCodeExtendedDNSError:
+		return fmt.Sprintf("%s=%s", opt.Code, opt.ExtendedError)
+	case DNSOptionCodePadding:
+		return fmt.Sprintf("%s=%d", opt.Code, len(opt.Data))
+	}
 	return fmt.Sprintf("%s=%x", opt.Code, opt.Data)
 }
 
+// rawData returns whether Data is encoded instead of the typed value of an
+// option having one, which is when the latter is unset and Data isn't
+// empty.
+func (opt *DNSOPT) rawData() bool {
+	if len(opt.Data) == 0 {
+		return false
+	}
+	switch opt.Code {
+	case DNSOptionCodeEDNSClientSubnet:
+		s := &opt.ClientSubnet
+		return s.Family == 0 && s.SourcePrefixLength == 0 && s.ScopePrefixLength == 0 && s.Address == nil
+	case DNSOptionCodeCookie:
+		return opt.Cookie.Client == nil && opt.Cookie.Server == nil
+	case DNSOptionCodeEDNSKeepAlive:
+		return !opt.KeepAlive.HasTimeout && opt.KeepAlive.Timeout == 0
+	case DNSOptionCodeExtendedDNSError:
+		return opt.ExtendedError.InfoCode == 0 && opt.ExtendedError.ExtraText == nil
+	}
+	return false
+}
+
+// decodeData decodes the typed value of the option from Data, leaving it
+// unset if Data doesn't fit its format.
+func (opt *DNSOPT) decodeData() {
+	data := opt.Data
+	switch opt.Code {
+	case DNSOptionCodeEDNSClientSubnet:
+		if len(data) < 4 {
+			return
+		}
+		s := DNSClientSubnet{
+			Family:             binary.BigEndian.Uint16(data),
+			SourcePrefixLength: data[2],
+			ScopePrefixLength:  data[3],
+		}
+		addr := data[4:]
+		switch s.Family {
+		case 1:
+			if len(addr) > 4 {
+				return
+			}
+			s.Address = make(net.IP, 4)
+			copy(s.Address, addr)
+		case 2:
+			if len(addr) > 16 {
+				return
+			}
+			s.Address = make(net.IP, 16)
+			copy(s.Address, addr)
+		default:
+			s.Address = addr
+		}
+		opt.ClientSubnet = s
+	case DNSOptionCodeCookie:
+		if len(data) >= 8 {
+			opt.Cookie = DNSCookie{Client: data[:8], Server: data[8:]}
+		}
+	case DNSOptionCodeEDNSKeepAlive:
+		if len(data) == 2 {
+			opt.KeepAlive = DNSKeepAlive{Timeout: binary.BigEndian.Uint16(data), HasTimeout: true}
+		}
+	case DNSOptionCodeExtendedDNSError:
+		if len(data) >= 2 {
+			opt.ExtendedError = DNSExtendedError{
+				InfoCode:  DNSExtendedErrorCode(binary.BigEndian.Uint16(data)),
+				ExtraText: data[2:],
+			}
+		}
+	}
+}
+
+// dataSize returns the length of the encoded option data.
+func (opt *DNSOPT) dataSize() int {
+	if opt.rawData() {
+		return len(opt.Data)
+	}
+	switch opt.Code {
+	case DNSOptionCodeEDNSClientSubnet:
+		return 4 + opt.ClientSubnet.addressSize()
+	case DNSOptionCodeCookie:
+		return len(opt.Cookie.Client) + len(opt.Cookie.Server)
+	case DNSOptionCodeEDNSKeepAlive:
+		if opt.KeepAlive.HasTimeout || opt.KeepAlive.Timeout != 0 {
+			return 2
+		}
+		return 0
+	case DNSOptionCodeExtendedDNSError:
+		return 2 + len(opt.ExtendedError.ExtraText)
+	}
+	return len(opt.Data)
+}
+
+// encodeData encodes the option data at the start of data.
+func (opt *DNSOPT) encodeData(data []byte) {
+	if opt.rawData() {
+		copy(data, opt.Data)
+		return
+	}
+	switch opt.Code {
+	case DNSOptionCodeEDNSClientSubnet:
+		s := &opt.ClientSubnet
+		binary.BigEndian.PutUint16(data, s.Family)
+		data[2] = s.SourcePrefixLength
+		data[3] = s.ScopePrefixLength
+		addr := s.Address
+		switch s.Family {
+		case 1:
+			addr = addr.To4()
+		case 2:
+			addr = addr.To16()
+		}
+		n := s.addressSize()
+		for i := copy(data[4:4+n], addr); i < n; i++ {
+			data[4+i] = 0
+		}
+	case DNSOptionCodeCookie:
+		n := copy(data, opt.Cookie.Client)
+		copy(data[n:], opt.Cookie.Server)
+	case DNSOptionCodeEDNSKeepAlive:
+		if opt.dataSize() == 2 {
+			binary.BigEndian.PutUint16(data, opt.KeepAlive.Timeout)
+		}
+	case DNSOptionCodeExtendedDNSError:
+		binary.BigEndian.PutUint16(data, uint16(opt.ExtendedError.InfoCode))
+		copy(data[2:], opt.ExtendedError.ExtraText)
+	default:
+		copy(data, opt.Data)
+	}
+}
+
+// DNSClientSubnet is the value of an EDNS Client Subnet option, see RFC 7871.
+type DNSClientSubnet struct {
+	Family             uint16 // 1 for IPv4 and 2 for IPv6, see IANA address family numbers
+	SourcePrefixLength uint8
+	ScopePrefixLength  uint8
+	// Address is padded with zeros to the length of the family's addresses
+	// when decoding, and truncated to SourcePrefixLength, rounded up to
+	// bytes, when encoding.  Its bits beyond SourcePrefixLength should be
+	// zero.
+	Address net.IP
+}
+
+func (s DNSClientSubnet) addressSize() int {
+	return (int(s.SourcePrefixLength) + 7) / 8
+}
+
+func (s DNSClientSubnet) String() string {
+	return fmt.Sprintf("%v/%d/%d", s.Address, s.SourcePrefixLength, s.ScopePrefixLength)
+}
+
+// DNSCookie is the value of a DNS Cookie option, see RFC 7873.  Queries
+// carry an 8 byte client cookie, and responses and later queries also an 8
+// to 32 byte server cookie.
+type DNSCookie struct {
+	Client, Server []byte
+}
+
+// DNSKeepAlive is the value of an edns-tcp-keepalive option, see RFC 7828.
+// Queries carry no timeout.
+type DNSKeepAlive struct {
+	Timeout    uint16 // In units of 100 milliseconds
+	HasTimeout bool   // Also encoded if Timeout isn't zero
+}
+
+// Duration returns the timeout as a time.Duration.
+func (k DNSKeepAlive) Duration() time.Duration {
+	return time.Duration(k.Timeout) * 100 * time.Millisecond
+}
+
+// DNSExtendedErrorCode is the INFO-CODE of an Extended DNS Error option.
+type DNSExtendedErrorCode uint16
+
+// DNSExtendedErrorCode known values, see RFC 8914, section 4.
+const (
+	DNSExtendedErrorCodeOther                      DNSExtendedErrorCode = 0
+	DNSExtendedErrorCodeUnsupportedDNSKEYAlgorithm DNSExtendedErrorCode = 1
+	DNSExtendedErrorCodeUnsupportedDSDigestType    DNSExtendedErrorCode = 2
+	DNSExtendedErrorCodeStaleAnswer                DNSExtendedErrorCode = 3
+	DNSExtendedErrorCodeForgedAnswer               DNSExtendedErrorCode = 4
+	DNSExtendedErrorCodeDNSSECIndeterminate        DNSExtendedErrorCode = 5
+	DNSExtendedErrorCodeDNSSECBogus                DNSExtendedErrorCode = 6
+	DNSExtendedErrorCodeSignatureExpired           DNSExtendedErrorCode = 7
+	DNSExtendedErrorCodeSignatureNotYetValid       DNSExtendedErrorCode = 8
+	DNSExtendedErrorCodeDNSKEYMissing              DNSExtendedErrorCode = 9
+	DNSExtendedErrorCodeRRSIGsMissing              DNSExtendedErrorCode = 10
+	DNSExtendedErrorCodeNoZoneKeyBitSet            DNSExtendedErrorCode = 11
+	DNSExtendedErrorCodeNSECMissing                DNSExtendedErrorCode = 12
+	DNSExtendedErrorCodeCachedError                DNSExtendedErrorCode = 13
+	DNSExtendedErrorCodeNotReady                   DNSExtendedErrorCode = 14
+	DNSExtendedErrorCodeBlocked                    DNSExtendedErrorCode = 15
+	DNSExtendedErrorCodeCensored                   DNSExtendedErrorCode = 16
+	DNSExtendedErrorCodeFiltered                   DNSExtendedErrorCode = 17
+	DNSExtendedErrorCodeProhibited                 DNSExtendedErrorCode = 18
+	DNSExtendedErrorCodeStaleNXDOMAINAnswer        DNSExtendedErrorCode = 19
+	DNSExtendedErrorCodeNotAuthoritative           DNSExtendedErrorCode = 20
+	DNSExtendedErrorCodeNotSupported               DNSExtendedErrorCode = 21
+	DNSExtendedErrorCodeNoReachableAuthority       DNSExtendedErrorCode = 22
+	DNSExtendedErrorCodeNetworkError               DNSExtendedErrorCode = 23
+	DNSExtendedErrorCodeInvalidData                DNSExtendedErrorCode = 24
+)
+
+var dnsExtendedErrorCodeNames = [...]string{
+	"Other", "Unsupported DNSKEY Algorithm", "Unsupported DS Digest Type", "Stale Answer",
+	"Forged Answer", "DNSSEC Indeterminate", "DNSSEC Bogus", "Signature Expired",
+	"Signature Not Yet Valid", "DNSKEY Missing", "RRSIGs Missing", "No Zone Key Bit Set",
+	"NSEC Missing", "Cached Error", "Not Ready", "Blocked", "Censored", "Filtered",
+	"Prohibited", "Stale NXDOMAIN Answer", "Not Authoritative", "Not Supported",
+	"No Reachable Authority", "Network Error", "Invalid Data",
+}
+
+func (c DNSExtendedErrorCode) String() string {
+	if int(c) < len(dnsExtendedErrorCodeNames) {
+		return dnsExtendedErrorCodeNames[c]
+	}
+	return "Unknown"
+}
+
+// DNSExtendedError is the value of an Extended DNS Error option, see RFC
+// 8914.
+type DNSExtendedError struct {
+	InfoCode  DNSExtendedErrorCode
+	ExtraText []byte // UTF-8
+}
+
+func (e DNSExtendedError) String() string {
+	if len(e.ExtraText) == 0 {
+		return fmt.Sprintf("%d (%v)", e.InfoCode, e.InfoCode)
+	}
+	return fmt.Sprintf("%d (%v) %q", e.InfoCode, e.InfoCode, e.ExtraText)
+}
+
 var (
 	errMaxRecursion = errors.New("max DNS recursion level hit")
 
@@ -8,9 +8,13 @@ package layers
 
 import (
 	"bytes"
+	"encoding/base64"
+	"fmt"
 	"net"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/gopacket"
 )
@@ -1150,7 +1154,315 @@ func TestDNSPacketWriteAnswer(t *testing.T) {
 		t.Fatalf("unexpected second answer name %q, want %q", got, want)
 	}
 	t.Log(gopacket.LayerString(dns2))
-	if want, got := 86, len(buf.Bytes()); want != got {
+	// The second name is a pointer to the first.
+	if want, got := 71, len(buf.Bytes()); want != got {
 		t.Fatalf("Encoded size, want %d got %d", want, got)
 	}
 }
+
+func TestDNSEncodeDNSSECAndModernTypes(t *testing.T) {
+	name := []byte("example.com")
+	rrs := []DNSResourceRecord{
+		{Type: DNSTypeDNAME, DNAME: []byte("example.net")},
+		{Type: DNSTypeRRSIG, RRSIG: DNSRRSIG{
+			TypeCovered: DNSTypeA, Algorithm: DNSSECAlgorithmECDSAP256SHA256, Labels: 2, OriginalTTL: 3600,
+			Expiration: 1262304000, Inception: 1259625600, KeyTag: 12345, SignerName: name, Signature: []byte{1, 2, 3, 4},
+		}},
+		{Type: DNSTypeDNSKEY, DNSKEY: DNSDNSKEY{Flags: DNSKEYFlagZone | DNSKEYFlagSEP, Protocol: 3, Algorithm: DNSSECAlgorithmED25519, PublicKey: []byte{5, 6, 7}}},
+		{Type: DNSTypeCDNSKEY, DNSKEY: DNSDNSKEY{Flags: DNSKEYFlagZone, Protocol: 3, Algorithm: DNSSECAlgorithmRSASHA256, PublicKey: []byte{8}}},
+		{Type: DNSTypeDS, DS: DNSDS{KeyTag: 60485, Algorithm: DNSSECAlgorithmRSASHA1, DigestType: 1, Digest: []byte{0x2b, 0xb1, 0x83}}},
+		{Type: DNSTypeNSEC, NSEC: DNSNSEC{NextDomain: []byte("host.example.com"), Types: []DNSType{DNSTypeA, DNSTypeMX, DNSTypeRRSIG, DNSTypeNSEC, 1234}}},
+		{Type: DNSTypeNSEC3, NSEC3: DNSNSEC3{HashAlgorithm: 1, Flags: 1, Iterations: 12, Salt: []byte{0xaa, 0xbb}, NextHashedOwner: []byte{0x01, 0x02, 0x03, 0x04, 0x05}, Types: []DNSType{DNSTypeNS, DNSTypeDS, DNSTypeRRSIG}}},
+		{Type: DNSTypeNSEC3PARAM, NSEC3: DNSNSEC3{HashAlgorithm: 1, Iterations: 0, Salt: []byte{}}},
+		{Type: DNSTypeTLSA, TLSA: DNSTLSA{Usage: 3, Selector: 1, MatchingType: 1, Data: []byte{0xde, 0xad}}},
+		{Type: DNSTypeSSHFP, SSHFP: DNSSSHFP{Algorithm: 4, Type: 2, Fingerprint: []byte{0xbe, 0xef}}},
+		{Type: DNSTypeCAA, CAA: DNSCAA{Flags: 128, Tag: []byte("issue"), Value: []byte("letsencrypt.org")}},
+		{Type: DNSTypeNAPTR, NAPTR: DNSNAPTR{Order: 100, Preference: 10, Flags: []byte("S"), Service: []byte("SIP+D2U"), Regexp: []byte{}, Replacement: []byte("_sip._udp.example.com")}},
+		{Type: DNSTypeHTTPS, SVCB: DNSSVCB{Priority: 1, Params: []DNSSVCBParam{
+			{Key: DNSSVCBParamKeyALPN, Value: []byte("\x02h2\x02h3")},
+			{Key: DNSSVCBParamKeyPort, Value: []byte{0x01, 0xbb}},
+			{Key: DNSSVCBParamKeyIPv4Hint, Value: []byte{192, 0, 2, 1, 192, 0, 2, 2}},
+			{Key: DNSSVCBParamKeyECH, Value: []byte{0xfe, 0x0d}},
+			{Key: DNSSVCBParamKeyIPv6Hint, Value: net.ParseIP("2001:db8::1")},
+		}}},
+		{Type: DNSTypeSVCB, SVCB: DNSSVCB{Priority: 0, Target: []byte("svc.example.net")}},
+	}
+	want := []string{
+		"DNAME example.net",
+		"RRSIG A 13 2 3600 20100101000000 20091201000000 12345 example.com. AQIDBA==",
+		"DNSKEY 257 3 15 BQYH",
+		"CDNSKEY 256 3 8 CA==",
+		"DS 60485 5 1 2BB183",
+		"NSEC host.example.com. A MX RRSIG NSEC TYPE1234",
+		"NSEC3 1 1 12 AABB 04106105 NS DS RRSIG",
+		"NSEC3PARAM 1 0 0 -",
+		"TLSA 3 1 1 DEAD",
+		"SSHFP 4 2 BEEF",
+		`CAA 128 issue "letsencrypt.org"`,
+		`NAPTR 100 10 "S" "SIP+D2U" "" _sip._udp.example.com.`,
+		"HTTPS 1 . alpn=h2,h3 port=443 ipv4hint=192.0.2.1,192.0.2.2 ech=/g0= ipv6hint=2001:db8::1",
+		"SVCB 0 svc.example.net.",
+	}
+	for i := range rrs {
+		rrs[i].Name, rrs[i].Class, rrs[i].TTL = name, DNSClassIN, 300
+	}
+	dns := &DNS{ID: 1, QR: true, Answers: rrs}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, dns); err != nil {
+		t.Fatal(err)
+	}
+	dns2 := &DNS{}
+	if err := dns2.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatalf("could not decode: %v", err)
+	}
+	testDNSEqual(t, dns, dns2)
+	for i, rr := range dns2.Answers {
+		if got := rr.String(); got != want[i] {
+			t.Errorf("answer %d: got %q, want %q", i, got, want[i])
+		}
+		exp := rrs[i]
+		for _, f := range [][2]interface{}{
+			{exp.DNAME, rr.DNAME}, {exp.RRSIG, rr.RRSIG}, {exp.DNSKEY, rr.DNSKEY}, {exp.DS, rr.DS},
+			{exp.NSEC, rr.NSEC}, {exp.NSEC3, rr.NSEC3}, {exp.TLSA, rr.TLSA}, {exp.SSHFP, rr.SSHFP},
+			{exp.CAA, rr.CAA}, {exp.NAPTR, rr.NAPTR}, {exp.SVCB, rr.SVCB},
+		} {
+			if fmt.Sprintf("%+v", f[0]) != fmt.Sprintf("%+v", f[1]) {
+				t.Errorf("answer %d: got %+v, want %+v", i, f[1], f[0])
+			}
+		}
+	}
+
+	https := &dns2.Answers[12].SVCB
+	port, ok := https.Port()
+	if !reflect.DeepEqual(https.ALPN(), []string{"h2", "h3"}) || port != 443 || !ok || !bytes.Equal(https.ECH(), []byte{0xfe, 0x0d}) {
+		t.Errorf("got alpn %v, port %v %v, ech %x", https.ALPN(), port, ok, https.ECH())
+	}
+	if hints := https.IPHints(); len(hints) != 3 || !hints[1].Equal(net.IP{192, 0, 2, 2}) || !hints[2].Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("got hints %v", hints)
+	}
+}
+
+// testDNSSVCBPort is RFC 9460's test vector for "1 foo.example.com. port=53".
+var testDNSSVCBPort = []byte{
+	0x00, 0x01, 0x03, 0x66, 0x6f, 0x6f, 0x07, 0x65, 0x78, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x03, 0x63,
+	0x6f, 0x6d, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00, 0x35,
+}
+
+func TestParseDNSTypeSVCB(t *testing.T) {
+	data := append([]byte{0, 1, 0x81, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0x40, 0, 1, 0, 0, 0, 0, 0, byte(len(testDNSSVCBPort))}, testDNSSVCBPort...)
+	dns := &DNS{}
+	if err := dns.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	rr := dns.Answers[0]
+	if port, _ := rr.SVCB.Port(); rr.Type != DNSTypeSVCB || rr.SVCB.Priority != 1 || string(rr.SVCB.Target) != "foo.example.com" || port != 53 {
+		t.Errorf("got %+v", rr.SVCB)
+	}
+
+	// And back.
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, dns); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("got\n%x\nwant\n%x", buf.Bytes(), data)
+	}
+
+	// Truncated parameters fail to decode.
+	data[len(data)-3] = 3
+	if err := dns.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err == nil {
+		t.Error("expected error decoding truncated SVCB parameter")
+	}
+}
+
+func TestDNSKEYKeyTag(t *testing.T) {
+	// The key from RFC 4034, section 5.4.
+	key, err := base64.StdEncoding.DecodeString("AQOeiiR0GOMYkDshWoSKz9XzfwJr1AYtsmx3TGkJaNXVbfi/2pHm822aJ5iI9BMzNXxeYCmZDRD99WYwYqUSdjMmmAphXdvxegXd/M5+X7OrzKBaMbCVdFLUUh6DhweJBjEVv5f2wwjM9XzcnOf+EPbtG9DMBmADjFDc2w/rljwvFw==")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := DNSDNSKEY{Flags: 256, Protocol: 3, Algorithm: DNSSECAlgorithmRSASHA1, PublicKey: key}
+	if tag := k.KeyTag(); tag != 60485 {
+		t.Errorf("got key tag %d, want 60485", tag)
+	}
+}
+
+func TestDNSEncodeCompressedNames(t *testing.T) {
+	// A captured response, whose names are compressed, serializes to the
+	// same bytes.
+	p := gopacket.NewPacket(testParseDNSTypeURI, LinkTypeNull, testDecodeOptions)
+	dns := p.Layer(LayerTypeDNS).(*DNS)
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, dns); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), dns.Contents) {
+		t.Errorf("got\n%x\nwant\n%x", buf.Bytes(), dns.Contents)
+	}
+
+	dns = &DNS{
+		ID: 1, QR: true, AA: true,
+		Questions: []DNSQuestion{{Name: []byte("example.com"), Type: DNSTypeMX, Class: DNSClassIN}},
+		Answers: []DNSResourceRecord{
+			{Name: []byte("example.com"), Type: DNSTypeMX, Class: DNSClassIN, MX: DNSMX{Preference: 10, Name: []byte("mail.example.com")}},
+			{Name: []byte("Example.com"), Type: DNSTypeMX, Class: DNSClassIN, MX: DNSMX{Preference: 20, Name: []byte("mx.other.org")}},
+		},
+		Authorities: []DNSResourceRecord{
+			{Name: []byte("example.com"), Type: DNSTypeSOA, Class: DNSClassIN, SOA: DNSSOA{MName: []byte("ns.example.com"), RName: []byte("hostmaster.other.org")}},
+			{Name: []byte("example.com"), Type: DNSTypeNS, Class: DNSClassIN, NS: []byte("ns.example.com")},
+		},
+		Additionals: []DNSResourceRecord{
+			{Name: []byte("mail.example.com"), Type: DNSTypeA, Class: DNSClassIN, IP: net.IP{192, 0, 2, 1}},
+			{Name: []byte("mail.example.com"), Type: DNSTypeSRV, Class: DNSClassIN, SRV: DNSSRV{Name: []byte("mail.example.com")}},
+		},
+	}
+	buf = gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, dns); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	for _, c := range []struct {
+		offset int
+		want   string
+	}{
+		{29, "\xc0\x0c"},                        // Answer owner
+		{43, "\x04mail\xc0\x0c"},                // MX name
+		{50, "\x07Example\xc0\x14"},             // Case is kept
+		{98, "\x02ns\xc0\x0c"},                  // SOA MName
+		{103, "\x0ahostmaster\xc0\x4b"},         // SOA RName
+		{148, "\xc0\x62"},                       // NS name
+		{150, "\xc0\x2b"},                       // A owner
+		{184, "\x04mail\x07example\x03com\x00"}, // Not in SRV data
+	} {
+		if end := c.offset + len(c.want); end > len(data) || string(data[c.offset:end]) != c.want {
+			t.Errorf("at %d: got %x, want %x", c.offset, data[c.offset:], c.want)
+		}
+	}
+	dns2 := &DNS{}
+	if err := dns2.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	testDNSEqual(t, dns, dns2)
+}
+
+func TestDNSEDNSOptions(t *testing.T) {
+	opt := DNSResourceRecord{
+		Type:  DNSTypeOPT,
+		Class: 1232,
+		OPT: []DNSOPT{
+			{Code: DNSOptionCodeEDNSClientSubnet, ClientSubnet: DNSClientSubnet{Family: 1, SourcePrefixLength: 20, Address: net.IP{192, 0, 240, 0}}},
+			{Code: DNSOptionCodeEDNSClientSubnet, ClientSubnet: DNSClientSubnet{Family: 2, SourcePrefixLength: 56, ScopePrefixLength: 48, Address: net.ParseIP("2001:db8:1:200::")}},
+			{Code: DNSOptionCodeCookie, Cookie: DNSCookie{Client: []byte("01234567"), Server: []byte("server-cookie")}},
+			{Code: DNSOptionCodeEDNSKeepAlive},
+			{Code: DNSOptionCodeEDNSKeepAlive, KeepAlive: DNSKeepAlive{Timeout: 0, HasTimeout: true}},
+			{Code: DNSOptionCodeEDNSKeepAlive, KeepAlive: DNSKeepAlive{Timeout: 300}},
+			{Code: DNSOptionCodeExtendedDNSError, ExtendedError: DNSExtendedError{InfoCode: DNSExtendedErrorCodeBlocked, ExtraText: []byte("policy")}},
+			{Code: DNSOptionCodeNSID, Data: []byte("ns1")},
+			{Code: DNSOptionCodePadding, Data: make([]byte, 5)},
+		},
+	}
+	wantData := []byte{
+		0x00, 0x08, 0x00, 0x07, 0x00, 0x01, 20, 0, 192, 0, 240,
+		0x00, 0x08, 0x00, 0x0b, 0x00, 0x02, 56, 48, 0x20, 0x01, 0x0d, 0xb8, 0x00, 0x01, 0x02,
+		0x00, 0x0a, 0x00, 0x15, '0', '1', '2', '3', '4', '5', '6', '7', 's', 'e', 'r', 'v', 'e', 'r', '-', 'c', 'o', 'o', 'k', 'i', 'e',
+		0x00, 0x0b, 0x00, 0x00,
+		0x00, 0x0b, 0x00, 0x02, 0x00, 0x00,
+		0x00, 0x0b, 0x00, 0x02, 0x01, 0x2c,
+		0x00, 0x0f, 0x00, 0x08, 0x00, 0x0f, 'p', 'o', 'l', 'i', 'c', 'y',
+		0x00, 0x03, 0x00, 0x03, 'n', 's', '1',
+		0x00, 0x0c, 0x00, 0x05, 0, 0, 0, 0, 0,
+	}
+	wantString := []string{
+		"EDNSClientSubnet=192.0.240.0/20/0",
+		"EDNSClientSubnet=2001:db8:1:200::/56/48",
+		"Cookie=30313233343536377365727665722d636f6f6b6965",
+		"EDNSKeepAlive",
+		"EDNSKeepAlive=0s",
+		"EDNSKeepAlive=30s",
+		`ExtendedDNSError=15 (Blocked) "policy"`,
+		"NSID=6e7331",
+		"CodePadding=5",
+	}
+	dns := &DNS{ID: 1, Additionals: []DNSResourceRecord{opt}}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, dns); err != nil {
+		t.Fatal(err)
+	}
+	dns2 := &DNS{}
+	if err := dns2.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	got := dns2.Additionals[0]
+	if !bytes.Equal(got.Data, wantData) {
+		t.Errorf("got data\n%x\nwant\n%x", got.Data, wantData)
+	}
+	if len(got.OPT) != len(wantString) {
+		t.Fatalf("got %d options, want %d", len(got.OPT), len(wantString))
+	}
+	for i, o := range got.OPT {
+		if o.String() != wantString[i] {
+			t.Errorf("option %d: got %q, want %q", i, o.String(), wantString[i])
+		}
+	}
+	if s := got.OPT[1].ClientSubnet; s.Family != 2 || !s.Address.Equal(net.ParseIP("2001:db8:1:200::")) || len(s.Address) != 16 {
+		t.Errorf("got client subnet %+v", s)
+	}
+	if k := got.OPT[5].KeepAlive; !k.HasTimeout || k.Duration() != 30*time.Second {
+		t.Errorf("got keepalive %+v", k)
+	}
+
+	// Options not fitting their typed format only decode into Data.
+	for _, code := range []DNSOptionCode{DNSOptionCodeEDNSClientSubnet, DNSOptionCodeCookie, DNSOptionCodeEDNSKeepAlive, DNSOptionCodeExtendedDNSError} {
+		opts, err := decodeOPTs([]byte{byte(code >> 8), byte(code), 0, 1, 7}, 0)
+		if err != nil {
+			t.Errorf("%v: %v", code, err)
+		} else if o := opts[0]; !bytes.Equal(o.Data, []byte{7}) || !o.rawData() || o.String() != code.String()+"=07" {
+			t.Errorf("%v: got %+v", code, o)
+		}
+	}
+}
+
+func TestDNSEDNSOptionsData(t *testing.T) {
+	// Options of the typed codes set through Data are encoded as is, even
+	// if they don't fit the typed format, and the message still decodes.
+	opts := []DNSOPT{
+		{Code: DNSOptionCodeEDNSClientSubnet, Data: []byte{0, 1, 24, 0, 192, 0, 2}},
+		{Code: DNSOptionCodeCookie, Data: []byte("01234567")},
+		{Code: DNSOptionCodeCookie, Data: []byte("0123456")},
+		{Code: DNSOptionCodeEDNSKeepAlive, Data: []byte{0, 100}},
+		{Code: DNSOptionCodeExtendedDNSError, Data: []byte{0, 15, 'x'}},
+	}
+	dns := &DNS{ID: 1, Additionals: []DNSResourceRecord{{Type: DNSTypeOPT, Class: 1232, OPT: opts}}}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, dns); err != nil {
+		t.Fatal(err)
+	}
+	dns2 := &DNS{}
+	if err := dns2.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	got := dns2.Additionals[0].OPT
+	if len(got) != len(opts) {
+		t.Fatalf("got %d options, want %d", len(got), len(opts))
+	}
+	for i, o := range got {
+		if o.Code != opts[i].Code || !bytes.Equal(o.Data, opts[i].Data) {
+			t.Errorf("option %d: got %v, want %v", i, o, opts[i])
+		}
+	}
+	if s := got[0].ClientSubnet; !s.Address.Equal(net.IP{192, 0, 2, 0}) || s.SourcePrefixLength != 24 {
+		t.Errorf("got client subnet %+v", s)
+	}
+	if c := got[1].Cookie; string(c.Client) != "01234567" || len(c.Server) != 0 {
+		t.Errorf("got cookie %+v", c)
+	}
+	if !got[2].rawData() {
+		t.Errorf("got cookie %+v from 7 bytes", got[2].Cookie)
+	}
+	if k := got[3].KeepAlive; !k.HasTimeout || k.Timeout != 100 {
+		t.Errorf("got keepalive %+v", k)
+	}
+	if e := got[4].ExtendedError; e.InfoCode != DNSExtendedErrorCodeBlocked || string(e.ExtraText) != "x" {
+		t.Errorf("got extended error %+v", e)
+	}
+}
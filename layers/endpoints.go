@@ -45,6 +45,12 @@ var (
 	EndpointPPP = gopacket.RegisterEndpointType(9, gopacket.EndpointTypeMetadata{Name: "PPP", Formatter: func([]byte) string {
 		return "point"
 	}})
+	// EndpointICMPQuery identifies an ICMP query/response exchange (echo,
+	// timestamp...) by the request's type and the identifier.  Both
+	// endpoints of an ICMP query flow are the same.
+	EndpointICMPQuery = gopacket.RegisterEndpointType(10, gopacket.EndpointTypeMetadata{Name: "ICMPQuery", Formatter: func(b []byte) string {
+		return strconv.Itoa(int(b[0])) + "/" + strconv.Itoa(int(binary.BigEndian.Uint16(b[1:])))
+	}})
 )
 
 // NewIPEndpoint creates a new IP (v4 or v6) endpoint from a net.IP address.
@@ -71,6 +77,13 @@ func newPortEndpoint(t gopacket.EndpointType, p uint16) gopacket.Endpoint {
 	return gopacket.NewEndpoint(t, []byte{byte(p >> 8), byte(p)})
 }
 
+// newICMPQueryFlow returns the flow of an ICMP query exchange, given the type
+// of its request message and its identifier.
+func newICMPQueryFlow(requestType uint8, id uint16) gopacket.Flow {
+	raw := []byte{requestType, byte(id >> 8), byte(id)}
+	return gopacket.NewFlow(EndpointICMPQuery, raw, raw)
+}
+
 // NewTCPPortEndpoint returns an endpoint based on a TCP port.
 func NewTCPPortEndpoint(p TCPPort) gopacket.Endpoint {
 	return newPortEndpoint(EndpointTCPPort, uint16(p))
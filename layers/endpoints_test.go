@@ -35,3 +35,83 @@ func TestNewIPEndpoint(t *testing.T) {
 		}
 	}
 }
+
+func TestFiveTupleTCP(t *testing.T) {
+	p := gopacket.NewPacket(testSimpleTCPPacket, LinkTypeEthernet, gopacket.Default)
+	ft, ok := gopacket.FiveTupleFromPacket(p)
+	if !ok {
+		t.Fatal("expected five-tuple")
+	}
+	want := gopacket.NewFiveTuple(uint8(IPProtocolTCP), p.NetworkLayer().NetworkFlow(), p.TransportLayer().TransportFlow())
+	if ft != want {
+		t.Errorf("got %v, want %v", ft, want)
+	}
+	if got := ft.String(); got != "6:172.17.81.73:50679->173.222.254.225:80" {
+		t.Errorf("unexpected string %q", got)
+	}
+
+	rev := ft.Reverse()
+	if rev == ft || rev.Reverse() != ft {
+		t.Error("Reverse is broken")
+	}
+	if rev.FastHash() != ft.FastHash() {
+		t.Error("FastHash isn't symmetric")
+	}
+	c1, r1 := ft.Canonical()
+	c2, r2 := rev.Canonical()
+	if c1 != c2 || r1 == r2 {
+		t.Errorf("canonical mismatch: %v (%v) vs %v (%v)", c1, r1, c2, r2)
+	}
+	m := map[gopacket.FiveTuple]int{c1: 1}
+	if m[c2] != 1 {
+		t.Error("canonical tuples don't key the same map entry")
+	}
+}
+
+func TestFiveTupleICMPQuery(t *testing.T) {
+	a, b := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}
+	req, ok := gopacket.FiveTupleFromLayers(
+		&IPv4{SrcIP: a, DstIP: b, Protocol: IPProtocolICMPv4},
+		&ICMPv4{TypeCode: CreateICMPv4TypeCode(ICMPv4TypeEchoRequest, 0), Id: 42})
+	if !ok {
+		t.Fatal("expected five-tuple")
+	}
+	rep, _ := gopacket.FiveTupleFromLayers(
+		&IPv4{SrcIP: b, DstIP: a, Protocol: IPProtocolICMPv4},
+		&ICMPv4{TypeCode: CreateICMPv4TypeCode(ICMPv4TypeEchoReply, 0), Id: 42})
+	if req.Protocol != uint8(IPProtocolICMPv4) || req.Transport.EndpointType() != EndpointICMPQuery {
+		t.Errorf("unexpected five-tuple %v", req)
+	}
+	if req.Reverse() != rep {
+		t.Errorf("expected reply %v to reverse request %v", rep, req)
+	}
+	other, _ := gopacket.FiveTupleFromLayers(
+		&IPv4{SrcIP: a, DstIP: b, Protocol: IPProtocolICMPv4},
+		&ICMPv4{TypeCode: CreateICMPv4TypeCode(ICMPv4TypeEchoRequest, 0), Id: 43})
+	if other == req {
+		t.Error("expected different identifiers to give different five-tuples")
+	}
+}
+
+func TestFiveTupleNoTransport(t *testing.T) {
+	// ICMP errors have no identifier.
+	p := gopacket.NewPacket(testICMP, LinkTypeEthernet, gopacket.Default)
+	ft, ok := gopacket.FiveTupleFromPacket(p)
+	if !ok || ft.Protocol != uint8(IPProtocolICMPv4) || ft.Transport != (gopacket.Flow{}) {
+		t.Errorf("unexpected five-tuple %v", ft)
+	}
+
+	// The tunneled packet isn't looked at.
+	p = gopacket.NewPacket(testPacketGRE, LinkTypeEthernet, gopacket.Default)
+	ft, ok = gopacket.FiveTupleFromPacket(p)
+	if !ok || ft.Protocol != uint8(IPProtocolGRE) || ft.Transport != (gopacket.Flow{}) {
+		t.Errorf("unexpected five-tuple %v", ft)
+	}
+	if want := p.NetworkLayer().NetworkFlow(); ft.Network != want {
+		t.Errorf("got network flow %v, want %v", ft.Network, want)
+	}
+
+	if _, ok := gopacket.FiveTupleFromLayers(&Ethernet{}); ok {
+		t.Error("expected no five-tuple without a network layer")
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2019 Google, Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/google/gopacket"
+)
+
+const (
+	// ERSPANIIIVersion is the value of the version field of ERSPAN Type III
+	// headers.
+	ERSPANIIIVersion = 0x2
+	// ERSPANIIIFrameTypeEthernet and ERSPANIIIFrameTypeIP are the values of
+	// the frame type field for mirrored Ethernet frames and IP packets.
+	ERSPANIIIFrameTypeEthernet = 0x0
+	ERSPANIIIFrameTypeIP       = 0x2
+)
+
+// ERSPANIII contains all of the fields found in an ERSPAN Type III header,
+// and its optional platform specific subheader.
+// https://tools.ietf.org/html/draft-foschiano-erspan-03
+type ERSPANIII struct {
+	BaseLayer
+	IsTruncated, PDUFrame, Direction, HasPlatformSubHeader bool
+	Version, CoS, BSO, FrameType, HardwareID, Granularity  uint8
+	VLANIdentifier, SessionID, SecurityGroupTag            uint16
+	Timestamp                                              uint32
+	// PlatformID and PlatformInfo make up the platform specific subheader,
+	// present if HasPlatformSubHeader (the 'O' bit) is set.
+	PlatformID   uint8
+	PlatformInfo uint64
+}
+
+// LayerType returns LayerTypeERSPANIII.
+func (erspan3 *ERSPANIII) LayerType() gopacket.LayerType { return LayerTypeERSPANIII }
+
+// DecodeFromBytes decodes the given bytes into this layer.
+func (erspan3 *ERSPANIII) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	length := 12
+	if len(data) < length {
+		df.SetTruncated()
+		return errors.New("ERSPAN Type III header too short")
+	}
+	erspan3.Version = data[0] >> 4
+	erspan3.VLANIdentifier = binary.BigEndian.Uint16(data[:2]) & 0x0FFF
+	erspan3.CoS = data[2] >> 5
+	erspan3.BSO = data[2] & 0x18 >> 3
+	erspan3.IsTruncated = data[2]&0x4 != 0
+	erspan3.SessionID = binary.BigEndian.Uint16(data[2:4]) & 0x03FF
+	erspan3.Timestamp = binary.BigEndian.Uint32(data[4:8])
+	erspan3.SecurityGroupTag = binary.BigEndian.Uint16(data[8:10])
+	erspan3.PDUFrame = data[10]&0x80 != 0
+	erspan3.FrameType = data[10] & 0x7C >> 2
+	erspan3.HardwareID = (data[10]&0x3)<<4 | data[11]>>4
+	erspan3.Direction = data[11]&0x8 != 0
+	erspan3.Granularity = data[11] & 0x6 >> 1
+	erspan3.HasPlatformSubHeader = data[11]&0x1 != 0
+	erspan3.PlatformID, erspan3.PlatformInfo = 0, 0
+	if erspan3.HasPlatformSubHeader {
+		length += 8
+		if len(data) < length {
+			df.SetTruncated()
+			return errors.New("ERSPAN Type III platform specific subheader too short")
+		}
+		subheader := binary.BigEndian.Uint64(data[12:20])
+		erspan3.PlatformID = uint8(subheader >> 58)
+		erspan3.PlatformInfo = subheader & 0x03FFFFFFFFFFFFFF
+	}
+	erspan3.Contents = data[:length]
+	erspan3.Payload = data[length:]
+	return nil
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+// See the docs for gopacket.SerializableLayer for more info.
+func (erspan3 *ERSPANIII) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	length := 12
+	if erspan3.HasPlatformSubHeader {
+		length += 8
+	}
+	bytes, err := b.PrependBytes(length)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint16(bytes, uint16(erspan3.Version&0xF)<<12|erspan3.VLANIdentifier&0x0FFF)
+	twoByteInt := uint16(erspan3.CoS&0x7)<<13 | uint16(erspan3.BSO&0x3)<<11 | erspan3.SessionID&0x03FF
+	if erspan3.IsTruncated {
+		twoByteInt |= 0x400
+	}
+	binary.BigEndian.PutUint16(bytes[2:], twoByteInt)
+	binary.BigEndian.PutUint32(bytes[4:], erspan3.Timestamp)
+	binary.BigEndian.PutUint16(bytes[8:], erspan3.SecurityGroupTag)
+	bytes[10] = (erspan3.FrameType&0x1F)<<2 | (erspan3.HardwareID&0x3F)>>4
+	if erspan3.PDUFrame {
+		bytes[10] |= 0x80
+	}
+	bytes[11] = (erspan3.HardwareID&0xF)<<4 | (erspan3.Granularity&0x3)<<1
+	if erspan3.Direction {
+		bytes[11] |= 0x8
+	}
+	if erspan3.HasPlatformSubHeader {
+		bytes[11] |= 0x1
+		binary.BigEndian.PutUint64(bytes[12:], uint64(erspan3.PlatformID&0x3F)<<58|erspan3.PlatformInfo&0x03FFFFFFFFFFFFFF)
+	}
+	return nil
+}
+
+// CanDecode returns the set of layer types that this DecodingLayer can decode.
+func (erspan3 *ERSPANIII) CanDecode() gopacket.LayerClass {
+	return LayerTypeERSPANIII
+}
+
+// NextLayerType returns the layer type contained by this DecodingLayer.
+func (erspan3 *ERSPANIII) NextLayerType() gopacket.LayerType {
+	switch erspan3.FrameType {
+	case ERSPANIIIFrameTypeEthernet:
+		return LayerTypeEthernet
+	case ERSPANIIIFrameTypeIP:
+		if len(erspan3.Payload) > 0 {
+			switch erspan3.Payload[0] >> 4 {
+			case 4:
+				return LayerTypeIPv4
+			case 6:
+				return LayerTypeIPv6
+			}
+		}
+	}
+	return gopacket.LayerTypePayload
+}
+
+func decodeERSPANIII(data []byte, p gopacket.PacketBuilder) error {
+	erspan3 := &ERSPANIII{}
+	return decodingLayerDecoder(erspan3, data, p)
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 Google, Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+package layers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+func TestERSPANIIIDecodeAndEncode(t *testing.T) {
+	erspan := &ERSPANIII{
+		Version:              ERSPANIIIVersion,
+		VLANIdentifier:       0x2aa,
+		CoS:                  0x4,
+		BSO:                  0x2,
+		IsTruncated:          true,
+		SessionID:            0x2aa,
+		Timestamp:            0x01020304,
+		SecurityGroupTag:     0xbeef,
+		PDUFrame:             true,
+		FrameType:            ERSPANIIIFrameTypeEthernet,
+		HardwareID:           0x2a,
+		Direction:            true,
+		Granularity:          0x3,
+		HasPlatformSubHeader: true,
+		PlatformID:           0x3,
+		PlatformInfo:         0x123456789,
+	}
+	expectedBytes := []byte{
+		0x22, 0xaa, 0x96, 0xaa, 0x01, 0x02, 0x03, 0x04, 0xbe, 0xef, 0x82, 0xaf,
+		0x0c, 0x00, 0x00, 0x01, 0x23, 0x45, 0x67, 0x89,
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	if err := erspan.SerializeTo(buf, gopacket.SerializeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(buf.Bytes(), expectedBytes) {
+		t.Fatalf("Got %x, expected %x\n", buf.Bytes(), expectedBytes)
+	}
+
+	erspan3 := &ERSPANIII{}
+	if err := erspan3.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	erspan.BaseLayer = BaseLayer{Contents: expectedBytes, Payload: []byte{}}
+	if !reflect.DeepEqual(erspan, erspan3) {
+		t.Fatalf("Got %+v, expected %+v\n", erspan3, erspan)
+	}
+	if erspan3.NextLayerType() != LayerTypeEthernet {
+		t.Errorf("Got next layer %v", erspan3.NextLayerType())
+	}
+	if err := erspan3.DecodeFromBytes(expectedBytes[:16], gopacket.NilDecodeFeedback); err == nil {
+		t.Error("Expected error decoding truncated subheader")
+	}
+}
+
+// Ethernet, IPv4, GRE, ERSPAN III (IP frame type), IPv4, ICMP echo request.
+var testPacketERSPANIIIIP = []byte{
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00,
+	0x45, 0x00, 0x00, 0x44, 0x00, 0x00, 0x00, 0x00, 0x40, 0x2f, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x01, 0x0a, 0x00, 0x00, 0x02,
+	0x10, 0x00, 0x22, 0xeb, 0x00, 0x00, 0x00, 0x01,
+	0x20, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x08, 0x00,
+	0x45, 0x00, 0x00, 0x1c, 0x00, 0x00, 0x00, 0x00, 0x40, 0x01, 0x00, 0x00, 0xc0, 0xa8, 0x00, 0x01, 0xc0, 0xa8, 0x00, 0x02,
+	0x08, 0x00, 0xf7, 0xff, 0x00, 0x00, 0x00, 0x00,
+}
+
+func TestPacketERSPANIII(t *testing.T) {
+	p := gopacket.NewPacket(testPacketERSPANIIIIP, LinkTypeEthernet, gopacket.Default)
+	if p.ErrorLayer() != nil {
+		t.Error("Failed to decode packet:", p.ErrorLayer().Error())
+	}
+	checkLayers(p, []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeGRE, LayerTypeERSPANIII, LayerTypeIPv4, LayerTypeICMPv4}, t)
+	if erspan, ok := p.Layer(LayerTypeERSPANIII).(*ERSPANIII); !ok || erspan.SessionID != 5 || erspan.FrameType != ERSPANIIIFrameTypeIP {
+		t.Errorf("Got ERSPAN III layer %+v", p.Layer(LayerTypeERSPANIII))
+	}
+}
@@ -50,6 +50,11 @@ type GeneveOption struct {
 // LayerType returns LayerTypeGeneve
 func (gn *Geneve) LayerType() gopacket.LayerType { return LayerTypeGeneve }
 
+// CanDecode returns the layer type this DecodingLayer can decode
+func (gn *Geneve) CanDecode() gopacket.LayerClass {
+	return LayerTypeGeneve
+}
+
 func decodeGeneveOption(data []byte, gn *Geneve, df gopacket.DecodeFeedback) (*GeneveOption, uint8, error) {
 	if len(data) < 3 {
 		df.SetTruncated()
@@ -89,6 +94,7 @@ func (gn *Geneve) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error
 	copy(buf[1:], data[4:7])
 	gn.VNI = binary.BigEndian.Uint32(buf[:])
 
+	gn.Options = gn.Options[:0]
 	offset, length := uint8(8), int32(gn.OptionsLength)
 	if len(data) < int(length+7) {
 		df.SetTruncated()
@@ -35,6 +35,15 @@ type GRERouting struct {
 // LayerType returns gopacket.LayerTypeGRE.
 func (g *GRE) LayerType() gopacket.LayerType { return LayerTypeGRE }
 
+// Validate implements gopacket.ValidatingLayer, verifying the checksum if
+// present.
+func (g *GRE) Validate() (v gopacket.LayerValidation) {
+	if g.ChecksumPresent {
+		setInternetChecksum(&v, g.Contents, g.Payload, 0, g.Checksum)
+	}
+	return
+}
+
 // DecodeFromBytes decodes the given bytes into this layer.
 func (g *GRE) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
 	g.ChecksumPresent = data[0]&0x80 != 0
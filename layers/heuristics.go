@@ -0,0 +1,277 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/google/gopacket"
+)
+
+// PayloadHeuristic is a cheap, port-independent check which decides whether
+// a TCP or UDP payload looks like a given application protocol, similar to
+// Wireshark's heuristic dissectors.
+type PayloadHeuristic struct {
+	// Name uniquely identifies the heuristic within its registry.
+	Name string
+	// LayerType is the layer type used to decode payloads that match.
+	LayerType gopacket.LayerType
+	// Priority orders heuristics within a registry; higher priorities are
+	// tried first.  Heuristics with equal priority are tried in registration
+	// order.
+	Priority int
+	// Match returns true if the payload looks like LayerType.  It's called for
+	// every payload whose ports don't already identify the protocol, so it
+	// should be fast, must not modify the payload, and should err on the side
+	// of returning false.
+	Match func(payload []byte) bool
+}
+
+type heuristicEntry struct {
+	PayloadHeuristic
+	disabled bool
+}
+
+// HeuristicRegistry is an ordered set of PayloadHeuristics, consulted by a
+// transport layer when port-based dispatch (see TCPPort.LayerType and
+// UDPPort.LayerType) fails or is ambiguous:
+//
+//  * If neither port maps to a known layer type, the first enabled heuristic
+//    matching the payload picks the next layer type.
+//  * If the source and destination ports map to two different layer types,
+//    the source port's type is only preferred if the destination port's type
+//    has no matching heuristic but the source port's type has one.
+//
+// Like RegisterTCPPortLayerType and RegisterUDPPortLayerType, registries
+// should be modified at initialization time, since they're not safe to modify
+// concurrently with decoding.
+//
+// UDPHeuristics and TCPHeuristics are empty by default, port-based dispatch
+// then working as without them: heuristics are opt-in.  Once registered, the
+// ones of this package decode DNS, SIP and RTP on unknown UDP ports (such as
+// DNS on 5353 and 5355, or RTP on the dynamic ports negotiated by SIP), and
+// TLS hellos on unknown TCP ports (such as 8443):
+//
+//  layers.UDPHeuristics.Register(layers.DNSHeuristic)
+//  layers.UDPHeuristics.Register(layers.SIPHeuristic)
+//  layers.UDPHeuristics.Register(layers.RTPHeuristic)
+//  layers.TCPHeuristics.Register(layers.TLSHeuristic)
+type HeuristicRegistry struct {
+	entries []heuristicEntry
+}
+
+// UDPHeuristics is the HeuristicRegistry consulted by UDP.NextLayerType.
+var UDPHeuristics = &HeuristicRegistry{}
+
+// TCPHeuristics is the HeuristicRegistry consulted by TCP.NextLayerType.  Note
+// that TCP payloads are only decoded past the TCP layer with
+// gopacket.DecodeStreamsAsDatagrams.
+var TCPHeuristics = &HeuristicRegistry{}
+
+// Register adds a heuristic to the registry, enabled.  If a heuristic with the
+// same name already exists, it is replaced.
+func (r *HeuristicRegistry) Register(h PayloadHeuristic) {
+	r.Unregister(h.Name)
+	r.entries = append(r.entries, heuristicEntry{PayloadHeuristic: h})
+	sort.SliceStable(r.entries, func(i, j int) bool {
+		return r.entries[i].Priority > r.entries[j].Priority
+	})
+}
+
+// Unregister removes the named heuristic from the registry, returning false if
+// it didn't exist.
+func (r *HeuristicRegistry) Unregister(name string) bool {
+	for i := range r.entries {
+		if r.entries[i].Name == name {
+			r.entries = append(r.entries[:i], r.entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// SetEnabled enables or disables the named heuristic, returning false if it
+// doesn't exist.
+func (r *HeuristicRegistry) SetEnabled(name string, enabled bool) bool {
+	for i := range r.entries {
+		if r.entries[i].Name == name {
+			r.entries[i].disabled = !enabled
+			return true
+		}
+	}
+	return false
+}
+
+// Heuristics returns all registered heuristics, in the order they're tried.
+func (r *HeuristicRegistry) Heuristics() []PayloadHeuristic {
+	hs := make([]PayloadHeuristic, len(r.entries))
+	for i := range r.entries {
+		hs[i] = r.entries[i].PayloadHeuristic
+	}
+	return hs
+}
+
+// LayerType returns the layer type of the first enabled heuristic matching
+// the payload, or gopacket.LayerTypePayload if none matches.
+func (r *HeuristicRegistry) LayerType(payload []byte) gopacket.LayerType {
+	if len(payload) == 0 {
+		return gopacket.LayerTypePayload
+	}
+	for i := range r.entries {
+		if e := &r.entries[i]; !e.disabled && e.Match(payload) {
+			return e.LayerType
+		}
+	}
+	return gopacket.LayerTypePayload
+}
+
+// matches returns true if any enabled heuristic for the given layer type
+// matches the payload.
+func (r *HeuristicRegistry) matches(lt gopacket.LayerType, payload []byte) bool {
+	for i := range r.entries {
+		if e := &r.entries[i]; !e.disabled && e.LayerType == lt && e.Match(payload) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextLayerType picks the next layer type given the layer types mapped from
+// the destination and source ports.
+func (r *HeuristicRegistry) nextLayerType(dst, src gopacket.LayerType, payload []byte) gopacket.LayerType {
+	switch {
+	case dst == gopacket.LayerTypePayload && src == gopacket.LayerTypePayload:
+		return r.LayerType(payload)
+	case dst == gopacket.LayerTypePayload:
+		return src
+	case src == gopacket.LayerTypePayload || src == dst:
+		return dst
+	}
+	if !r.matches(dst, payload) && r.matches(src, payload) {
+		return src
+	}
+	return dst
+}
+
+// Heuristics for protocols with layers in this package, which may be
+// registered in UDPHeuristics (DNS, SIP and RTP) or TCPHeuristics (TLS).  RTP
+// headers have the fewest fixed bits, so RTPHeuristic is tried last.
+var (
+	DNSHeuristic = PayloadHeuristic{Name: "dns", LayerType: LayerTypeDNS, Priority: 10, Match: looksLikeDNS}
+	SIPHeuristic = PayloadHeuristic{Name: "sip", LayerType: LayerTypeSIP, Priority: 20, Match: looksLikeSIP}
+	RTPHeuristic = PayloadHeuristic{Name: "rtp", LayerType: LayerTypeRTP, Priority: 0, Match: looksLikeRTP}
+	TLSHeuristic = PayloadHeuristic{Name: "tls", LayerType: LayerTypeTLS, Priority: 20, Match: looksLikeTLS}
+)
+
+// looksLikeDNS checks for a plausible DNS header followed by a well-formed
+// first question (or first answer, for responses without questions).
+func looksLikeDNS(data []byte) bool {
+	if len(data) < 12 {
+		return false
+	}
+	flags := binary.BigEndian.Uint16(data[2:4])
+	opcode := DNSOpCode(flags>>11) & 0xf
+	if opcode > DNSOpCodeUpdate || opcode == 3 || flags&0x40 != 0 {
+		return false
+	}
+	qd := int(binary.BigEndian.Uint16(data[4:6]))
+	an := int(binary.BigEndian.Uint16(data[6:8]))
+	ns := int(binary.BigEndian.Uint16(data[8:10]))
+	ar := int(binary.BigEndian.Uint16(data[10:12]))
+	if qd > 16 || qd+an+ns+ar == 0 {
+		return false
+	}
+	// Each question takes at least 5 bytes, each resource record 11.
+	if 12+5*qd+11*(an+ns+ar) > len(data) {
+		return false
+	}
+	if qd == 0 && flags&0x8000 == 0 {
+		return false
+	}
+	// Walk the first name, which can't be compressed since nothing precedes
+	// it.
+	i := 12
+	for {
+		if i >= len(data) {
+			return false
+		}
+		l := int(data[i])
+		if l == 0 {
+			i++
+			break
+		}
+		if l > 63 {
+			return false
+		}
+		i += l + 1
+	}
+	if i+4 > len(data) {
+		return false
+	}
+	switch DNSClass(binary.BigEndian.Uint16(data[i+2:i+4]) & 0x7fff) {
+	case DNSClassIN, DNSClassCS, DNSClassCH, DNSClassHS, DNSClassAny:
+		return true
+	}
+	return false
+}
+
+// looksLikeRTP checks for a valid RTP version 2 header with media, whose
+// payload type is a static audio or video one (0 to 34) or a dynamic one (96
+// to 127).  This excludes the payload types RTCP packets would have.
+func looksLikeRTP(data []byte) bool {
+	var rtp RTP
+	if rtp.DecodeFromBytes(data, gopacket.NilDecodeFeedback) != nil || len(rtp.Payload) == 0 {
+		return false
+	}
+	return rtp.PayloadType <= 34 || rtp.PayloadType >= 96
+}
+
+var sipMethodPrefixes = [][]byte{
+	[]byte("INVITE "), []byte("ACK "), []byte("BYE "), []byte("CANCEL "),
+	[]byte("OPTIONS "), []byte("REGISTER "), []byte("PRACK "),
+	[]byte("SUBSCRIBE "), []byte("NOTIFY "), []byte("PUBLISH "), []byte("INFO "),
+	[]byte("REFER "), []byte("MESSAGE "), []byte("UPDATE "),
+}
+
+// looksLikeSIP checks for a SIP status line, or a SIP request line with a
+// sip: or sips: request URI.
+func looksLikeSIP(data []byte) bool {
+	if bytes.HasPrefix(data, []byte("SIP/2.0 ")) {
+		return true
+	}
+	for _, m := range sipMethodPrefixes {
+		if bytes.HasPrefix(data, m) {
+			uri := data[len(m):]
+			return bytes.HasPrefix(uri, []byte("sip:")) || bytes.HasPrefix(uri, []byte("sips:"))
+		}
+	}
+	return false
+}
+
+// looksLikeTLS checks for a handshake record header followed by the start
+// of a ClientHello or ServerHello.  Other records are rejected, since
+// segments in the middle of a stream may happen to start like them.
+func looksLikeTLS(data []byte) bool {
+	if len(data) < 11 || TLSType(data[0]) != TLSHandshake || data[1] != 3 || data[2] > 4 {
+		return false
+	}
+	l := binary.BigEndian.Uint16(data[3:5])
+	if l < 4 || l > 16384 {
+		return false
+	}
+	switch data[5] {
+	case 1, 2: // ClientHello, ServerHello
+	default:
+		return false
+	}
+	// The hello holds at least its version and random, and may span
+	// records.
+	n := int(data[6])<<16 | int(data[7])<<8 | int(data[8])
+	return n >= 34 && n <= 1<<16 && data[9] == 3
+}
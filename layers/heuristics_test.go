@@ -0,0 +1,121 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+// withPorts returns a copy of an Ethernet/IPv4 (no options) packet with its
+// transport ports rewritten.
+func withPorts(data []byte, src, dst uint16) []byte {
+	out := append([]byte(nil), data...)
+	binary.BigEndian.PutUint16(out[34:36], src)
+	binary.BigEndian.PutUint16(out[36:38], dst)
+	return out
+}
+
+func TestHeuristicsOptIn(t *testing.T) {
+	// Without registered heuristics, payloads on unknown ports stay
+	// payloads.
+	p := gopacket.NewPacket(withPorts(testUDPPacketDNS, 40000, 40001), LinkTypeEthernet, gopacket.Default)
+	checkLayers(p, []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeUDP, gopacket.LayerTypePayload}, t)
+	p = gopacket.NewPacket(withPorts(testClientHello, 12046, 8443), LinkTypeEthernet, testTLSDecodeOptions)
+	checkLayers(p, []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeTCP, gopacket.LayerTypePayload}, t)
+}
+
+func TestHeuristicDNSUnknownPorts(t *testing.T) {
+	UDPHeuristics.Register(DNSHeuristic)
+	defer UDPHeuristics.Unregister(DNSHeuristic.Name)
+	for _, ports := range [][2]uint16{{8053, 8053}, {8054, 40000}, {40000, 40001}} {
+		p := gopacket.NewPacket(withPorts(testUDPPacketDNS, ports[0], ports[1]), LinkTypeEthernet, gopacket.Default)
+		if p.ErrorLayer() != nil {
+			t.Error("Failed to decode packet:", p.ErrorLayer().Error())
+		}
+		checkLayers(p, []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeUDP, LayerTypeDNS}, t)
+	}
+
+	if !UDPHeuristics.SetEnabled("dns", false) {
+		t.Fatal("dns heuristic not registered")
+	}
+	defer UDPHeuristics.SetEnabled("dns", true)
+	p := gopacket.NewPacket(withPorts(testUDPPacketDNS, 40000, 40001), LinkTypeEthernet, gopacket.Default)
+	checkLayers(p, []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeUDP, gopacket.LayerTypePayload}, t)
+}
+
+func TestHeuristicTLSUnknownPort(t *testing.T) {
+	TCPHeuristics.Register(TLSHeuristic)
+	defer TCPHeuristics.Unregister(TLSHeuristic.Name)
+	p := gopacket.NewPacket(withPorts(testClientHello, 12046, 8443), LinkTypeEthernet, testTLSDecodeOptions)
+	if p.ErrorLayer() != nil {
+		t.Error("Failed to decode packet:", p.ErrorLayer().Error())
+	}
+	checkLayers(p, []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeTCP, LayerTypeTLS}, t)
+}
+
+func TestHeuristicAmbiguousPorts(t *testing.T) {
+	UDPHeuristics.Register(DNSHeuristic)
+	UDPHeuristics.Register(SIPHeuristic)
+	defer UDPHeuristics.Unregister(DNSHeuristic.Name)
+	defer UDPHeuristics.Unregister(SIPHeuristic.Name)
+	// Destination port says SIP, source port says DNS: the DNS heuristic
+	// breaks the tie since the payload doesn't look like SIP.
+	p := gopacket.NewPacket(withPorts(testUDPPacketDNS, 53, 5060), LinkTypeEthernet, gopacket.Default)
+	checkLayers(p, []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeUDP, LayerTypeDNS}, t)
+}
+
+func TestHeuristicRegistryPriority(t *testing.T) {
+	r := &HeuristicRegistry{}
+	r.Register(PayloadHeuristic{Name: "low", LayerType: LayerTypeDNS, Priority: 1, Match: func([]byte) bool { return true }})
+	r.Register(PayloadHeuristic{Name: "high", LayerType: LayerTypeSIP, Priority: 2, Match: func(b []byte) bool { return bytes.HasPrefix(b, []byte("x")) }})
+	if got := r.LayerType([]byte("xyz")); got != LayerTypeSIP {
+		t.Errorf("expected higher priority heuristic to win, got %v", got)
+	}
+	if got := r.LayerType([]byte("abc")); got != LayerTypeDNS {
+		t.Errorf("expected lower priority heuristic as fallback, got %v", got)
+	}
+	r.SetEnabled("low", false)
+	if got := r.LayerType([]byte("abc")); got != gopacket.LayerTypePayload {
+		t.Errorf("expected disabled heuristic to be skipped, got %v", got)
+	}
+	if !r.Unregister("high") || len(r.Heuristics()) != 1 {
+		t.Errorf("expected one heuristic after unregistering, got %v", r.Heuristics())
+	}
+}
+
+func TestHeuristicDNSRejectsGarbage(t *testing.T) {
+	for _, data := range [][]byte{
+		testMalformed,
+		[]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"),
+		make([]byte, 64),
+	} {
+		if looksLikeDNS(data) {
+			t.Errorf("expected %q not to look like DNS", data)
+		}
+	}
+}
+
+func TestHeuristicTLSRejectsMidStream(t *testing.T) {
+	// Records other than the first of a handshake, such as application
+	// data or the rest of a ClientHello, aren't taken for TLS.
+	for _, data := range [][]byte{
+		{0x17, 0x03, 0x03, 0x00, 0x20, 0x01, 0x00, 0x00, 0x40, 0x03, 0x03},
+		{0x16, 0x03, 0x03, 0x00, 0x20, 0x0b, 0x00, 0x00, 0x40, 0x03, 0x03},
+		{0x16, 0x03, 0x01, 0x00, 0x20, 0x01, 0x00, 0x00, 0x10, 0x03, 0x03},
+	} {
+		if looksLikeTLS(data) {
+			t.Errorf("expected %x not to look like TLS", data)
+		}
+	}
+	if !looksLikeTLS(testClientHello[54:]) {
+		t.Error("expected ClientHello to look like TLS")
+	}
+}
@@ -212,11 +212,49 @@ type ICMPv4 struct {
 	Checksum uint16
 	Id       uint16
 	Seq      uint16
+
+	// Quoted is the datagram quoted by error messages, see QuotedPacket,
+	// and Extension the extension structure of multipart error messages,
+	// see RFC 4884.  They are nil for other messages.
+	Quoted    []byte
+	Extension *ICMPExtension
+}
+
+// QuotedPacket decodes the datagram quoted by an error message from its IP
+// header, returning nil for other messages.  The datagram is copied and
+// decoded on each call, rather than with the message.
+func (i *ICMPv4) QuotedPacket() gopacket.Packet {
+	return decodeICMPQuoted(i.Quoted, LayerTypeIPv4)
 }
 
 // LayerType returns LayerTypeICMPv4.
 func (i *ICMPv4) LayerType() gopacket.LayerType { return LayerTypeICMPv4 }
 
+// Validate implements gopacket.ValidatingLayer, verifying the checksum.
+func (i *ICMPv4) Validate() (v gopacket.LayerValidation) {
+	setInternetChecksum(&v, i.Contents, i.Payload, 0, i.Checksum)
+	return
+}
+
+// FiveTupleProtocol implements gopacket.FiveTupleLayer.
+func (i *ICMPv4) FiveTupleProtocol() uint8 { return uint8(IPProtocolICMPv4) }
+
+// FiveTupleFlow implements gopacket.FiveTupleLayer.  Query messages (echo,
+// timestamp, information and address mask requests and replies) return a flow
+// of EndpointICMPQuery endpoints made of the request type and Id, so that a
+// request and its reply share the same flow.  Other messages have no flow.
+func (i *ICMPv4) FiveTupleFlow() (gopacket.Flow, bool) {
+	switch t := i.TypeCode.Type(); t {
+	case ICMPv4TypeEchoRequest, ICMPv4TypeTimestampRequest, ICMPv4TypeInfoRequest, ICMPv4TypeAddressMaskRequest:
+		return newICMPQueryFlow(t, i.Id), true
+	case ICMPv4TypeEchoReply:
+		return newICMPQueryFlow(ICMPv4TypeEchoRequest, i.Id), true
+	case ICMPv4TypeTimestampReply, ICMPv4TypeInfoReply, ICMPv4TypeAddressMaskReply:
+		return newICMPQueryFlow(t-1, i.Id), true
+	}
+	return gopacket.Flow{}, false
+}
+
 // DecodeFromBytes decodes the given bytes into this layer.
 func (i *ICMPv4) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
 	if len(data) < 8 {
@@ -228,6 +266,14 @@ func (i *ICMPv4) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error
 	i.Id = binary.BigEndian.Uint16(data[4:6])
 	i.Seq = binary.BigEndian.Uint16(data[6:8])
 	i.BaseLayer = BaseLayer{data[:8], data[8:]}
+	i.Quoted, i.Extension = nil, nil
+	switch i.TypeCode.Type() {
+	case ICMPv4TypeDestinationUnreachable, ICMPv4TypeTimeExceeded, ICMPv4TypeParameterProblem:
+		// The length of the quoted datagram is in 32-bit words.
+		i.Quoted, i.Extension = decodeICMPErrorPayload(i.Payload, 4*int(data[5]))
+	case ICMPv4TypeSourceQuench, ICMPv4TypeRedirect:
+		i.Quoted, i.Extension = decodeICMPErrorPayload(i.Payload, -1)
+	}
 	return nil
 }
 
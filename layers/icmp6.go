@@ -180,11 +180,48 @@ type ICMPv6 struct {
 	// instead (e.g. ICMPv6TypeRouterSolicitation).
 	TypeBytes []byte
 	tcpipchecksum
+
+	// Quoted is the datagram quoted by error messages, see QuotedPacket,
+	// and Extension the extension structure of multipart error messages,
+	// see RFC 4884.  They are nil for other messages.
+	Quoted    []byte
+	Extension *ICMPExtension
+}
+
+// QuotedPacket decodes the datagram quoted by an error message from its IP
+// header, returning nil for other messages.  The datagram is copied and
+// decoded on each call, rather than with the message.
+func (i *ICMPv6) QuotedPacket() gopacket.Packet {
+	return decodeICMPQuoted(i.Quoted, LayerTypeIPv6)
 }
 
 // LayerType returns LayerTypeICMPv6.
 func (i *ICMPv6) LayerType() gopacket.LayerType { return LayerTypeICMPv6 }
 
+// Validate implements gopacket.ValidatingLayer, verifying the checksum.
+// SetNetworkLayerForChecksum must have been called for it to be verified.
+func (i *ICMPv6) Validate() (v gopacket.LayerValidation) {
+	i.setChecksum(&v, i.Contents, i.Payload, len(i.Contents)+len(i.Payload), IPProtocolICMPv6, i.Checksum)
+	return
+}
+
+// FiveTupleProtocol implements gopacket.FiveTupleLayer.
+func (i *ICMPv6) FiveTupleProtocol() uint8 { return uint8(IPProtocolICMPv6) }
+
+// FiveTupleFlow implements gopacket.FiveTupleLayer.  Echo requests and replies
+// return a flow of EndpointICMPQuery endpoints made of the request type and
+// identifier, so that a request and its reply share the same flow.  Other
+// messages have no flow.
+func (i *ICMPv6) FiveTupleFlow() (gopacket.Flow, bool) {
+	switch i.TypeCode.Type() {
+	case ICMPv6TypeEchoRequest, ICMPv6TypeEchoReply:
+		if len(i.Payload) >= 2 {
+			return newICMPQueryFlow(ICMPv6TypeEchoRequest, binary.BigEndian.Uint16(i.Payload)), true
+		}
+	}
+	return gopacket.Flow{}, false
+}
+
 // DecodeFromBytes decodes the given bytes into this layer.
 func (i *ICMPv6) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
 	if len(data) < 4 {
@@ -194,6 +231,19 @@ func (i *ICMPv6) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error
 	i.TypeCode = CreateICMPv6TypeCode(data[0], data[1])
 	i.Checksum = binary.BigEndian.Uint16(data[2:4])
 	i.BaseLayer = BaseLayer{data[:4], data[4:]}
+	i.Quoted, i.Extension = nil, nil
+	if len(data) < 8 {
+		return nil
+	}
+	// The quoted datagram follows the 4 bytes of the message body which
+	// hold the MTU, pointer or length of the quoted datagram.
+	switch i.TypeCode.Type() {
+	case ICMPv6TypeDestinationUnreachable, ICMPv6TypeTimeExceeded:
+		// The length of the quoted datagram is in 64-bit words.
+		i.Quoted, i.Extension = decodeICMPErrorPayload(data[8:], 8*int(data[4]))
+	case ICMPv6TypePacketTooBig, ICMPv6TypeParameterProblem:
+		i.Quoted, i.Extension = decodeICMPErrorPayload(data[8:], -1)
+	}
 	return nil
 }
 
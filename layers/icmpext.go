@@ -0,0 +1,242 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/google/gopacket"
+)
+
+// ICMPExtensionClass is the class of an ICMP extension object.
+type ICMPExtensionClass uint8
+
+// ICMPExtensionClass known values.
+const (
+	ICMPExtensionClassMPLSLabelStack          ICMPExtensionClass = 1 // RFC 4950
+	ICMPExtensionClassInterfaceInformation    ICMPExtensionClass = 2 // RFC 5837
+	ICMPExtensionClassInterfaceIdentification ICMPExtensionClass = 3 // RFC 8335
+)
+
+func (c ICMPExtensionClass) String() string {
+	switch c {
+	case ICMPExtensionClassMPLSLabelStack:
+		return "MPLS Label Stack"
+	case ICMPExtensionClassInterfaceInformation:
+		return "Interface Information"
+	case ICMPExtensionClassInterfaceIdentification:
+		return "Interface Identification"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(c))
+	}
+}
+
+// ICMPExtension is the extension structure of multipart ICMP error
+// messages, following the quoted datagram, see RFC 4884.
+type ICMPExtension struct {
+	Version  uint8
+	Checksum uint16
+	Objects  []ICMPExtensionObject
+}
+
+// ICMPExtensionObject is an object of an ICMP extension structure.
+type ICMPExtensionObject struct {
+	Class ICMPExtensionClass
+	CType uint8
+	Data  []byte
+}
+
+// icmpExtensionOffset is the length of the quoted datagram of multipart
+// messages sent with a zero length field, as before RFC 4884.
+const icmpExtensionOffset = 128
+
+func decodeICMPExtension(data []byte) (*ICMPExtension, error) {
+	if len(data) < 4 {
+		return nil, errors.New("ICMP extension structure too short")
+	}
+	e := &ICMPExtension{
+		Version:  data[0] >> 4,
+		Checksum: binary.BigEndian.Uint16(data[2:4]),
+	}
+	if e.Version != 2 {
+		return nil, fmt.Errorf("unsupported ICMP extension version %d", e.Version)
+	}
+	for d := data[4:]; len(d) > 0; {
+		if len(d) < 4 {
+			return nil, errors.New("ICMP extension object header too short")
+		}
+		length := int(binary.BigEndian.Uint16(d))
+		if length < 4 || length > len(d) {
+			return nil, fmt.Errorf("invalid ICMP extension object length %d", length)
+		}
+		e.Objects = append(e.Objects, ICMPExtensionObject{
+			Class: ICMPExtensionClass(d[2]),
+			CType: d[3],
+			Data:  d[4:length],
+		})
+		d = d[length:]
+	}
+	return e, nil
+}
+
+// decodeICMPErrorPayload splits the payload of an ICMP error message into
+// the quoted datagram and the extension structure.  length is the length of
+// the quoted datagram announced by the message, in bytes, or -1 if the
+// message has no length field.
+func decodeICMPErrorPayload(payload []byte, length int) ([]byte, *ICMPExtension) {
+	var ext *ICMPExtension
+	switch {
+	case length > 0 && length < len(payload):
+		// An invalid extension structure is ignored, leaving the
+		// original datagram alone.
+		ext, _ = decodeICMPExtension(payload[length:])
+		payload = payload[:length]
+	case length == 0 && len(payload) > icmpExtensionOffset:
+		// Senders compliant with RFC 4950 but not RFC 4884 don't set the
+		// length: the extension structure is then recognized by its
+		// version and checksum, see section 5.5 of RFC 4884.
+		if rest := payload[icmpExtensionOffset:]; len(rest) >= 4 && rest[0]>>4 == 2 && tcpipChecksum(rest, 0) == 0 {
+			if ext, _ = decodeICMPExtension(rest); ext != nil {
+				payload = payload[:icmpExtensionOffset]
+			}
+		}
+	}
+	return payload, ext
+}
+
+// decodeICMPQuoted decodes a quoted datagram starting with a first layer,
+// returning nil if there is none.
+func decodeICMPQuoted(quoted []byte, first gopacket.LayerType) gopacket.Packet {
+	if len(quoted) == 0 {
+		return nil
+	}
+	return gopacket.NewPacket(quoted, first, gopacket.Default)
+}
+
+// ICMPMPLSLabelStackEntry is an entry of an MPLS label stack object.
+type ICMPMPLSLabelStackEntry struct {
+	Label        uint32
+	TrafficClass uint8
+	StackBottom  bool
+	TTL          uint8
+}
+
+// MPLSLabelStack returns the entries of an MPLS label stack object, the
+// stack of the packet which triggered the message, as received.
+func (o ICMPExtensionObject) MPLSLabelStack() ([]ICMPMPLSLabelStackEntry, bool) {
+	if o.Class != ICMPExtensionClassMPLSLabelStack || o.CType != 1 || len(o.Data)%4 != 0 {
+		return nil, false
+	}
+	var stack []ICMPMPLSLabelStackEntry
+	for d := o.Data; len(d) > 0; d = d[4:] {
+		v := binary.BigEndian.Uint32(d)
+		stack = append(stack, ICMPMPLSLabelStackEntry{
+			Label:        v >> 12,
+			TrafficClass: uint8(v>>9) & 0x7,
+			StackBottom:  v&0x100 != 0,
+			TTL:          uint8(v),
+		})
+	}
+	return stack, true
+}
+
+// ICMPInterfaceRole tells which interface an interface information object
+// describes.
+type ICMPInterfaceRole uint8
+
+// ICMPInterfaceRole known values.
+const (
+	ICMPInterfaceRoleIncoming ICMPInterfaceRole = 0 // Interface the packet arrived on
+	ICMPInterfaceRoleSubIP    ICMPInterfaceRole = 1 // Sub-IP component of the incoming interface
+	ICMPInterfaceRoleOutgoing ICMPInterfaceRole = 2 // Interface the packet would have left on
+	ICMPInterfaceRoleNextHop  ICMPInterfaceRole = 3 // IP next hop the packet would have been sent to
+)
+
+func (r ICMPInterfaceRole) String() string {
+	switch r {
+	case ICMPInterfaceRoleIncoming:
+		return "Incoming"
+	case ICMPInterfaceRoleSubIP:
+		return "SubIP"
+	case ICMPInterfaceRoleOutgoing:
+		return "Outgoing"
+	default:
+		return "NextHop"
+	}
+}
+
+// ICMPInterfaceInformation is the content of an interface information
+// object.  The fields present are given by the Has* booleans and by IP and
+// Name not being empty.
+type ICMPInterfaceInformation struct {
+	Role       ICMPInterfaceRole
+	HasIfIndex bool
+	IfIndex    uint32
+	IP         net.IP
+	Name       string
+	HasMTU     bool
+	MTU        uint32
+}
+
+// InterfaceInformation returns the content of an interface information
+// object.  The IP points into the object's data.
+func (o ICMPExtensionObject) InterfaceInformation() (ICMPInterfaceInformation, bool) {
+	if o.Class != ICMPExtensionClassInterfaceInformation {
+		return ICMPInterfaceInformation{}, false
+	}
+	info := ICMPInterfaceInformation{
+		Role:       ICMPInterfaceRole(o.CType >> 6),
+		HasIfIndex: o.CType&0x08 != 0,
+		HasMTU:     o.CType&0x01 != 0,
+	}
+	d := o.Data
+	if info.HasIfIndex {
+		if len(d) < 4 {
+			return ICMPInterfaceInformation{}, false
+		}
+		info.IfIndex, d = binary.BigEndian.Uint32(d), d[4:]
+	}
+	if o.CType&0x04 != 0 {
+		// The address sub-object starts with its address family.
+		if len(d) < 4 {
+			return ICMPInterfaceInformation{}, false
+		}
+		size := 0
+		switch binary.BigEndian.Uint16(d) {
+		case 1:
+			size = 4
+		case 2:
+			size = 16
+		}
+		if size == 0 || len(d) < 4+size {
+			return ICMPInterfaceInformation{}, false
+		}
+		info.IP, d = net.IP(d[4:4+size]), d[4+size:]
+	}
+	if o.CType&0x02 != 0 {
+		// The name sub-object's length includes the length byte and
+		// padding.
+		if len(d) < 1 || d[0] < 1 || int(d[0]) > len(d) {
+			return ICMPInterfaceInformation{}, false
+		}
+		name := d[1:d[0]]
+		for len(name) > 0 && name[len(name)-1] == 0 {
+			name = name[:len(name)-1]
+		}
+		info.Name, d = string(name), d[d[0]:]
+	}
+	if info.HasMTU {
+		if len(d) < 4 {
+			return ICMPInterfaceInformation{}, false
+		}
+		info.MTU = binary.BigEndian.Uint32(d)
+	}
+	return info, true
+}
@@ -0,0 +1,130 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+// testICMPQuoted returns a UDP datagram padded to 128 bytes, as quoted by
+// multipart ICMP messages.
+func testICMPQuoted(t *testing.T, ip gopacket.SerializableLayer) []byte {
+	udp := &UDP{SrcPort: 33434, DstPort: 33435}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, ip, udp, gopacket.Payload("probe")); err != nil {
+		t.Fatal(err)
+	}
+	return append(buf.Bytes(), make([]byte, 128-len(buf.Bytes()))...)
+}
+
+// testICMPExtension returns an extension structure holding an object.
+func testICMPExtension(class ICMPExtensionClass, ctype uint8, data []byte) []byte {
+	ext := []byte{0x20, 0, 0, 0, 0, byte(4 + len(data)), byte(class), ctype}
+	ext = append(ext, data...)
+	binary.BigEndian.PutUint16(ext[2:], tcpipChecksum(ext, 0))
+	return ext
+}
+
+func TestICMPv4TimeExceededMPLS(t *testing.T) {
+	quoted := testICMPQuoted(t, &IPv4{
+		Version: 4, IHL: 5, TTL: 1, Protocol: IPProtocolUDP,
+		SrcIP: net.IP{192, 0, 2, 1}, DstIP: net.IP{198, 51, 100, 1},
+	})
+	// Label 16004 with the bottom of stack bit and a TTL of 1.
+	ext := testICMPExtension(ICMPExtensionClassMPLSLabelStack, 1, []byte{0x03, 0xe8, 0x41, 0x01})
+	for _, length := range []uint8{32, 0} {
+		data := append([]byte{ICMPv4TypeTimeExceeded, 0, 0, 0, 0, length, 0, 0}, quoted...)
+		p := gopacket.NewPacket(append(data, ext...), LayerTypeICMPv4, testDecodeOptions)
+		checkLayers(p, []gopacket.LayerType{LayerTypeICMPv4, gopacket.LayerTypePayload}, t)
+		icmp := p.Layer(LayerTypeICMPv4).(*ICMPv4)
+		if !bytes.Equal(icmp.Quoted, quoted) || icmp.Extension == nil {
+			t.Fatalf("length %d: got quoted datagram %x, extension %v", length, icmp.Quoted, icmp.Extension)
+		}
+		q := icmp.QuotedPacket()
+		if udp, ok := q.Layer(LayerTypeUDP).(*UDP); !ok || udp.DstPort != 33435 {
+			t.Errorf("length %d: got quoted packet %v", length, q)
+		}
+		if len(icmp.Extension.Objects) != 1 {
+			t.Fatalf("length %d: got %d extension objects", length, len(icmp.Extension.Objects))
+		}
+		stack, ok := icmp.Extension.Objects[0].MPLSLabelStack()
+		want := []ICMPMPLSLabelStackEntry{{Label: 16004, StackBottom: true, TTL: 1}}
+		if !ok || !reflect.DeepEqual(stack, want) {
+			t.Errorf("length %d: got label stack %+v, %v", length, stack, ok)
+		}
+	}
+
+	// Without a length nor a valid extension, everything is quoted.
+	data := append([]byte{ICMPv4TypeDestinationUnreachable, ICMPv4CodePort, 0, 0, 0, 0, 0, 0}, quoted[:40]...)
+	p := gopacket.NewPacket(data, LayerTypeICMPv4, testDecodeOptions)
+	if icmp := p.Layer(LayerTypeICMPv4).(*ICMPv4); icmp.QuotedPacket() == nil || icmp.Extension != nil ||
+		icmp.QuotedPacket().Layer(LayerTypeUDP) == nil {
+		t.Errorf("got quoted datagram %x, extension %v", icmp.Quoted, icmp.Extension)
+	}
+	p = gopacket.NewPacket([]byte{ICMPv4TypeEchoRequest, 0, 0, 0, 0, 1, 0, 1}, LayerTypeICMPv4, testDecodeOptions)
+	if icmp := p.Layer(LayerTypeICMPv4).(*ICMPv4); icmp.Quoted != nil || icmp.QuotedPacket() != nil {
+		t.Errorf("echo request has a quoted packet")
+	}
+}
+
+func TestICMPv6TimeExceededInterfaceInformation(t *testing.T) {
+	ip6 := &IPv6{Version: 6, NextHeader: IPProtocolUDP, HopLimit: 1, SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("2001:db8::2")}
+	quoted := testICMPQuoted(t, ip6)
+	object := []byte{0, 0, 0, 3, 0, 2, 0, 0}
+	object = append(object, net.ParseIP("2001:db8::fe")...)
+	object = append(object, 8, 'e', 't', 'h', '0', 0, 0, 0)
+	object = append(object, 0, 0, 0x05, 0xdc)
+	ctype := uint8(ICMPInterfaceRoleOutgoing)<<6 | 0x0f
+	ext := testICMPExtension(ICMPExtensionClassInterfaceInformation, ctype, object)
+
+	data := append([]byte{ICMPv6TypeTimeExceeded, 0, 0, 0, 16, 0, 0, 0}, quoted...)
+	p := gopacket.NewPacket(append(data, ext...), LayerTypeICMPv6, testDecodeOptions)
+	icmp := p.Layer(LayerTypeICMPv6).(*ICMPv6)
+	if q := icmp.QuotedPacket(); q == nil || q.Layer(LayerTypeUDP) == nil {
+		t.Fatalf("got quoted packet %v", q)
+	}
+	if icmp.Extension == nil || len(icmp.Extension.Objects) != 1 {
+		t.Fatalf("got extension %+v", icmp.Extension)
+	}
+	info, ok := icmp.Extension.Objects[0].InterfaceInformation()
+	want := ICMPInterfaceInformation{
+		Role:       ICMPInterfaceRoleOutgoing,
+		HasIfIndex: true, IfIndex: 3,
+		IP:     net.ParseIP("2001:db8::fe"),
+		Name:   "eth0",
+		HasMTU: true, MTU: 1500,
+	}
+	if !ok || !reflect.DeepEqual(info, want) {
+		t.Errorf("got interface information %+v, %v, want %+v", info, ok, want)
+	}
+	if _, ok := icmp.Extension.Objects[0].MPLSLabelStack(); ok {
+		t.Error("interface information decoded as a label stack")
+	}
+}
+
+func TestICMPErrorDecodeNoAllocs(t *testing.T) {
+	quoted := testICMPQuoted(t, &IPv4{
+		Version: 4, IHL: 5, TTL: 1, Protocol: IPProtocolUDP,
+		SrcIP: net.IP{192, 0, 2, 1}, DstIP: net.IP{198, 51, 100, 1},
+	})
+	data := append([]byte{ICMPv4TypeDestinationUnreachable, ICMPv4CodePort, 0, 0, 0, 0, 0, 0}, quoted...)
+	// The quoted datagram is only decoded by QuotedPacket.
+	var icmp ICMPv4
+	if n := testing.AllocsPerRun(10, func() {
+		if err := icmp.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+			t.Fatal(err)
+		}
+	}); n != 0 {
+		t.Errorf("got %v allocations decoding an ICMP error", n)
+	}
+}
@@ -260,6 +260,18 @@ func igmpTimeDecode(t uint8) time.Duration {
 func (i *IGMP) LayerType() gopacket.LayerType      { return LayerTypeIGMP }
 func (i *IGMPv1or2) LayerType() gopacket.LayerType { return LayerTypeIGMP }
 
+// Validate implements gopacket.ValidatingLayer, verifying the checksum.
+func (i *IGMP) Validate() (v gopacket.LayerValidation) {
+	setInternetChecksum(&v, i.Contents, nil, 0, i.Checksum)
+	return
+}
+
+// Validate implements gopacket.ValidatingLayer, verifying the checksum.
+func (i *IGMPv1or2) Validate() (v gopacket.LayerValidation) {
+	setInternetChecksum(&v, i.Contents, nil, 0, i.Checksum)
+	return
+}
+
 func (i *IGMPv1or2) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
 	if len(data) < 8 {
 		return errors.New("IGMP Packet too small")
@@ -269,6 +281,7 @@ func (i *IGMPv1or2) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) err
 	i.MaxResponseTime = igmpTimeDecode(data[1])
 	i.Checksum = binary.BigEndian.Uint16(data[2:4])
 	i.GroupAddress = net.IP(data[4:8])
+	i.BaseLayer = BaseLayer{Contents: data}
 
 	return nil
 }
@@ -289,6 +302,7 @@ func (i *IGMP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
 
 	// common IGMP header values between versions 1..3 of IGMP specification..
 	i.Type = IGMPType(data[0])
+	i.BaseLayer = BaseLayer{Contents: data}
 
 	switch i.Type {
 	case IGMPMembershipQuery:
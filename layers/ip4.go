@@ -60,10 +60,21 @@ type IPv4 struct {
 
 // LayerType returns LayerTypeIPv4
 func (i *IPv4) LayerType() gopacket.LayerType { return LayerTypeIPv4 }
+
+// Validate implements gopacket.ValidatingLayer, verifying the header checksum
+// and the total length.
+func (i *IPv4) Validate() (v gopacket.LayerValidation) {
+	setInternetChecksum(&v, i.Contents, nil, 0, i.Checksum)
+	v.SetLength(int(i.Length), len(i.Contents)+len(i.Payload))
+	return
+}
 func (i *IPv4) NetworkFlow() gopacket.Flow {
 	return gopacket.NewFlow(EndpointIPv4, i.SrcIP, i.DstIP)
 }
 
+// NextIPProtocol implements gopacket.IPProtocolLayer, returning Protocol.
+func (i *IPv4) NextIPProtocol() uint8 { return uint8(i.Protocol) }
+
 type IPv4Option struct {
 	OptionType   uint8
 	OptionLength uint8
@@ -73,7 +73,7 @@ func TestIPOptResetDuringDecoding(t *testing.T) {
 		Protocol: IPProtocolTCP,
 	}
 
-	ipBytes, err := serialize(ipWithoutOptions)
+	ipBytes, err := serializeIPv4(ipWithoutOptions)
 
 	if err != nil {
 		t.Fatalf("Failed to serialize ip layer: %v", err)
@@ -91,7 +91,7 @@ func TestIPOptResetDuringDecoding(t *testing.T) {
 
 }
 
-func serialize(ip *IPv4) ([]byte, error) {
+func serializeIPv4(ip *IPv4) ([]byte, error) {
 	buffer := gopacket.NewSerializeBuffer()
 	err := ip.SerializeTo(buffer, gopacket.SerializeOptions{
 		FixLengths:       true,
@@ -222,3 +222,60 @@ func TestIPv4Options(t *testing.T) {
 		}
 	}
 }
+
+func TestIPv4TypedOptions(t *testing.T) {
+	cipso := NewIPv4OptionCIPSO(IPv4CIPSO{DOI: 3, Tags: []IPv4CIPSOTag{
+		{Type: IPv4CIPSOTagRestrictedBitmap, Level: 2, Categories: []uint16{0, 5, 9}},
+	}})
+	if got, want := append([]byte{cipso.OptionType, cipso.OptionLength}, cipso.OptionData...), []byte{0x86, 0x0c, 0, 0, 0, 3, 1, 6, 0, 2, 0x84, 0x40}; !bytes.Equal(got, want) {
+		t.Errorf("CIPSO option: got %x, want %x", got, want)
+	}
+	ip := &IPv4{
+		Version: 4, TTL: 64, Protocol: IPProtocolUDP,
+		SrcIP: net.IP{192, 0, 2, 1}, DstIP: net.IP{192, 0, 2, 2},
+		Options: []IPv4Option{
+			NewIPv4OptionRoute(IPv4OptionRecordRoute, IPv4Route{Addresses: []net.IP{net.IPv4zero, net.IPv4zero}}),
+			NewIPv4OptionRouterAlert(0),
+			NewIPv4OptionTimestamp(IPv4Timestamp{Pointer: 13, Flag: IPv4TimestampWithAddress, Entries: []IPv4TimestampEntry{
+				{Address: net.IP{192, 0, 2, 9}, Timestamp: 1000},
+			}}),
+			cipso,
+		},
+	}
+	udp := &UDP{SrcPort: 1000, DstPort: 2000}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, ip, udp); err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), LayerTypeIPv4, testDecodeOptions)
+	checkLayers(p, []gopacket.LayerType{LayerTypeIPv4, LayerTypeUDP}, t)
+	opts := p.Layer(LayerTypeIPv4).(*IPv4).Options
+
+	if r, ok := opts[0].Route(); !ok || r.Pointer != 4 || len(r.Addresses) != 2 || !r.Addresses[1].Equal(net.IPv4zero) {
+		t.Errorf("record route: got %+v, %v", r, ok)
+	}
+	if v, ok := opts[1].RouterAlert(); !ok || v != 0 {
+		t.Errorf("router alert: got %d, %v", v, ok)
+	}
+	if _, ok := opts[1].Route(); ok {
+		t.Error("router alert decoded as a route")
+	}
+	ts, ok := opts[2].Timestamp()
+	if !ok || ts.Pointer != 13 || ts.Flag != IPv4TimestampWithAddress || len(ts.Entries) != 1 ||
+		!ts.Entries[0].Address.Equal(net.IP{192, 0, 2, 9}) || ts.Entries[0].Timestamp != 1000 {
+		t.Errorf("timestamp: got %+v, %v", ts, ok)
+	}
+	c, ok := opts[3].CIPSO()
+	if !ok || c.DOI != 3 || len(c.Tags) != 1 || c.Tags[0].Level != 2 || !reflect.DeepEqual(c.Tags[0].Categories, []uint16{0, 5, 9}) {
+		t.Errorf("CIPSO: got %+v, %v", c, ok)
+	}
+
+	ranged := NewIPv4OptionCIPSO(IPv4CIPSO{DOI: 1, Tags: []IPv4CIPSOTag{
+		{Type: IPv4CIPSOTagRanged, Level: 7, Ranges: []IPv4CIPSORange{{Low: 100, High: 200}, {Low: 1, High: 10}}},
+		{Type: IPv4CIPSOTagEnumerated, Level: 1, Categories: []uint16{3, 300}},
+	}})
+	if c, ok := ranged.CIPSO(); !ok || !reflect.DeepEqual(c.Tags[0].Ranges, []IPv4CIPSORange{{Low: 100, High: 200}, {Low: 1, High: 10}}) ||
+		!reflect.DeepEqual(c.Tags[1].Categories, []uint16{3, 300}) {
+		t.Errorf("CIPSO: got %+v, %v", c, ok)
+	}
+}
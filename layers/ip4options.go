@@ -0,0 +1,319 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// IPv4Option types.
+const (
+	IPv4OptionEndOfList         uint8 = 0
+	IPv4OptionNOP               uint8 = 1
+	IPv4OptionRecordRoute       uint8 = 7   // RR, RFC 791
+	IPv4OptionTimestamp         uint8 = 68  // TS, RFC 791
+	IPv4OptionLooseSourceRoute  uint8 = 131 // LSRR, RFC 791
+	IPv4OptionCIPSO             uint8 = 134 // Commercial IP Security Option
+	IPv4OptionStrictSourceRoute uint8 = 137 // SSRR, RFC 791
+	IPv4OptionRouterAlert       uint8 = 148 // RFC 2113
+)
+
+// Typed accessors and constructors of IPv4 options.  The accessors return
+// false if the option isn't of their type or if its data is invalid for
+// it.  The constructors return options ready to be serialized, with their
+// OptionLength set.
+
+func newIPv4Option(optionType uint8, data []byte) IPv4Option {
+	return IPv4Option{OptionType: optionType, OptionLength: uint8(len(data) + 2), OptionData: data}
+}
+
+// IPv4Route is the content of the record route, loose source route and
+// strict source route options.
+type IPv4Route struct {
+	// Pointer is the offset, from the start of the option and starting at
+	// 1, of the next address to record or route to.  The first one is 4.
+	Pointer uint8
+	// Addresses are all the addresses of the option, including the slots of
+	// a record route option not filled yet.
+	Addresses []net.IP
+}
+
+// Route returns the content of a record route or source route option.  The
+// addresses point into the option's data.
+func (i IPv4Option) Route() (IPv4Route, bool) {
+	switch i.OptionType {
+	case IPv4OptionRecordRoute, IPv4OptionLooseSourceRoute, IPv4OptionStrictSourceRoute:
+	default:
+		return IPv4Route{}, false
+	}
+	if len(i.OptionData) < 1 || (len(i.OptionData)-1)%4 != 0 {
+		return IPv4Route{}, false
+	}
+	r := IPv4Route{Pointer: i.OptionData[0]}
+	for d := i.OptionData[1:]; len(d) > 0; d = d[4:] {
+		r.Addresses = append(r.Addresses, net.IP(d[:4]))
+	}
+	return r, true
+}
+
+// NewIPv4OptionRoute returns a record route, loose source route or strict
+// source route option, depending on optionType.  A zero Pointer is set to
+// 4, the first address.
+func NewIPv4OptionRoute(optionType uint8, r IPv4Route) IPv4Option {
+	data := make([]byte, 1+4*len(r.Addresses))
+	data[0] = r.Pointer
+	if data[0] == 0 {
+		data[0] = 4
+	}
+	for i, ip := range r.Addresses {
+		copy(data[1+4*i:], ip.To4())
+	}
+	return newIPv4Option(optionType, data)
+}
+
+// IPv4TimestampFlag tells what a timestamp option records.
+type IPv4TimestampFlag uint8
+
+// IPv4TimestampFlag known values.
+const (
+	IPv4TimestampOnly         IPv4TimestampFlag = 0 // Timestamps
+	IPv4TimestampWithAddress  IPv4TimestampFlag = 1 // Addresses and timestamps
+	IPv4TimestampPrespecified IPv4TimestampFlag = 3 // Timestamps of given addresses
+)
+
+// IPv4TimestampEntry is an entry of a timestamp option.  Timestamp is in
+// milliseconds since midnight UT, and Address is nil for IPv4TimestampOnly.
+type IPv4TimestampEntry struct {
+	Address   net.IP
+	Timestamp uint32
+}
+
+// IPv4Timestamp is the content of a timestamp option.
+type IPv4Timestamp struct {
+	// Pointer is the offset, from the start of the option and starting at
+	// 1, of the next entry to fill.  The first one is 5.
+	Pointer uint8
+	// Overflow is the number of hops which couldn't register a timestamp
+	// for lack of space.
+	Overflow uint8
+	Flag     IPv4TimestampFlag
+	// Entries are all the entries of the option, including the ones not
+	// filled yet.
+	Entries []IPv4TimestampEntry
+}
+
+// Timestamp returns the content of a timestamp option.  The addresses
+// point into the option's data.
+func (i IPv4Option) Timestamp() (IPv4Timestamp, bool) {
+	if i.OptionType != IPv4OptionTimestamp || len(i.OptionData) < 2 {
+		return IPv4Timestamp{}, false
+	}
+	ts := IPv4Timestamp{
+		Pointer:  i.OptionData[0],
+		Overflow: i.OptionData[1] >> 4,
+		Flag:     IPv4TimestampFlag(i.OptionData[1] & 0x0f),
+	}
+	size := 8
+	if ts.Flag == IPv4TimestampOnly {
+		size = 4
+	}
+	d := i.OptionData[2:]
+	if len(d)%size != 0 {
+		return IPv4Timestamp{}, false
+	}
+	for ; len(d) > 0; d = d[size:] {
+		var e IPv4TimestampEntry
+		if size == 8 {
+			e.Address = net.IP(d[:4])
+		}
+		e.Timestamp = binary.BigEndian.Uint32(d[size-4:])
+		ts.Entries = append(ts.Entries, e)
+	}
+	return ts, true
+}
+
+// NewIPv4OptionTimestamp returns a timestamp option.  A zero Pointer is set
+// to 5, the first entry.
+func NewIPv4OptionTimestamp(ts IPv4Timestamp) IPv4Option {
+	size := 8
+	if ts.Flag == IPv4TimestampOnly {
+		size = 4
+	}
+	data := make([]byte, 2+size*len(ts.Entries))
+	data[0] = ts.Pointer
+	if data[0] == 0 {
+		data[0] = 5
+	}
+	data[1] = ts.Overflow<<4 | uint8(ts.Flag)&0x0f
+	for i, e := range ts.Entries {
+		d := data[2+size*i:]
+		if size == 8 {
+			copy(d, e.Address.To4())
+		}
+		binary.BigEndian.PutUint32(d[size-4:], e.Timestamp)
+	}
+	return newIPv4Option(IPv4OptionTimestamp, data)
+}
+
+// RouterAlert returns the value of a router alert option, 0 meaning the
+// router shall examine the packet.
+func (i IPv4Option) RouterAlert() (uint16, bool) {
+	if i.OptionType != IPv4OptionRouterAlert || len(i.OptionData) != 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(i.OptionData), true
+}
+
+// NewIPv4OptionRouterAlert returns a router alert option.
+func NewIPv4OptionRouterAlert(value uint16) IPv4Option {
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, value)
+	return newIPv4Option(IPv4OptionRouterAlert, data)
+}
+
+// IPv4CIPSOTagType is the type of a CIPSO tag.
+type IPv4CIPSOTagType uint8
+
+// IPv4CIPSOTagType known values.
+const (
+	IPv4CIPSOTagRestrictedBitmap IPv4CIPSOTagType = 1
+	IPv4CIPSOTagEnumerated       IPv4CIPSOTagType = 2
+	IPv4CIPSOTagRanged           IPv4CIPSOTagType = 5
+	IPv4CIPSOTagPermissiveBitmap IPv4CIPSOTagType = 6
+	IPv4CIPSOTagFreeForm         IPv4CIPSOTagType = 7
+)
+
+// IPv4CIPSORange is a range of categories of a ranged CIPSO tag.
+type IPv4CIPSORange struct {
+	Low, High uint16
+}
+
+// IPv4CIPSOTag is a tag of a CIPSO option.
+type IPv4CIPSOTag struct {
+	Type IPv4CIPSOTagType
+	// Level is the sensitivity level of the tags of types 1, 2, 5 and 6.
+	Level uint8
+	// Categories are the categories of the tags of types 1, 2 and 6, and
+	// Ranges the ones of the tags of type 5.
+	Categories []uint16
+	Ranges     []IPv4CIPSORange
+	// Data is the data of the tags of other types, following their type
+	// and length.
+	Data []byte
+}
+
+// IPv4CIPSO is the content of a Commercial IP Security Option, see the
+// CIPSO IETF draft and FIPS 188.
+type IPv4CIPSO struct {
+	// DOI is the domain of interpretation of the tags.
+	DOI  uint32
+	Tags []IPv4CIPSOTag
+}
+
+// CIPSO returns the content of a CIPSO option.
+func (i IPv4Option) CIPSO() (IPv4CIPSO, bool) {
+	if i.OptionType != IPv4OptionCIPSO || len(i.OptionData) < 4 {
+		return IPv4CIPSO{}, false
+	}
+	c := IPv4CIPSO{DOI: binary.BigEndian.Uint32(i.OptionData)}
+	for d := i.OptionData[4:]; len(d) > 0; {
+		if len(d) < 2 || d[1] < 2 || int(d[1]) > len(d) {
+			return IPv4CIPSO{}, false
+		}
+		tag := IPv4CIPSOTag{Type: IPv4CIPSOTagType(d[0])}
+		data := d[2:d[1]]
+		d = d[d[1]:]
+		switch tag.Type {
+		case IPv4CIPSOTagRestrictedBitmap, IPv4CIPSOTagPermissiveBitmap:
+			if len(data) < 2 {
+				return IPv4CIPSO{}, false
+			}
+			tag.Level, tag.Categories = data[1], bitmapToList(data[2:])
+		case IPv4CIPSOTagEnumerated:
+			if len(data) < 2 || len(data)%2 != 0 {
+				return IPv4CIPSO{}, false
+			}
+			tag.Level = data[1]
+			for c := data[2:]; len(c) > 0; c = c[2:] {
+				tag.Categories = append(tag.Categories, binary.BigEndian.Uint16(c))
+			}
+		case IPv4CIPSOTagRanged:
+			if len(data) < 2 || (len(data)-2)%4 != 0 {
+				return IPv4CIPSO{}, false
+			}
+			tag.Level = data[1]
+			// The ranges are sent from the highest, high end first.
+			for r := data[2:]; len(r) > 0; r = r[4:] {
+				tag.Ranges = append(tag.Ranges, IPv4CIPSORange{High: binary.BigEndian.Uint16(r), Low: binary.BigEndian.Uint16(r[2:])})
+			}
+		default:
+			tag.Data = data
+		}
+		c.Tags = append(c.Tags, tag)
+	}
+	return c, true
+}
+
+// NewIPv4OptionCIPSO returns a CIPSO option.
+func NewIPv4OptionCIPSO(c IPv4CIPSO) IPv4Option {
+	data := make([]byte, 4, 40)
+	binary.BigEndian.PutUint32(data, c.DOI)
+	for _, tag := range c.Tags {
+		start := len(data)
+		data = append(data, byte(tag.Type), 0)
+		switch tag.Type {
+		case IPv4CIPSOTagRestrictedBitmap, IPv4CIPSOTagPermissiveBitmap:
+			data = append(data, 0, tag.Level)
+			data = append(data, listToBitmap(tag.Categories, 1)...)
+		case IPv4CIPSOTagEnumerated:
+			data = append(data, 0, tag.Level)
+			for _, c := range tag.Categories {
+				data = appendUint16(data, c)
+			}
+		case IPv4CIPSOTagRanged:
+			data = append(data, 0, tag.Level)
+			for _, r := range tag.Ranges {
+				data = appendUint16(appendUint16(data, r.High), r.Low)
+			}
+		default:
+			data = append(data, tag.Data...)
+		}
+		data[start+1] = byte(len(data) - start)
+	}
+	return newIPv4Option(IPv4OptionCIPSO, data)
+}
+
+// bitmapToList returns the positions of the bits set in a bitmap, the
+// first one being the most significant bit of the first byte.
+func bitmapToList(bitmap []byte) (list []uint16) {
+	for i, b := range bitmap {
+		for bit := 0; bit < 8; bit++ {
+			if b&(0x80>>uint(bit)) != 0 {
+				list = append(list, uint16(i*8+bit))
+			}
+		}
+	}
+	return
+}
+
+// listToBitmap returns the bitmap with the bits of list set, its length
+// rounded up to a multiple of unit bytes.
+func listToBitmap(list []uint16, unit int) []byte {
+	size := 0
+	for _, n := range list {
+		if int(n)/8+1 > size {
+			size = int(n)/8 + 1
+		}
+	}
+	size = (size + unit - 1) / unit * unit
+	bitmap := make([]byte, size)
+	for _, n := range list {
+		bitmap[n/8] |= 0x80 >> (n % 8)
+	}
+	return bitmap
+}
@@ -45,11 +45,23 @@ type IPv6 struct {
 // LayerType returns LayerTypeIPv6
 func (ipv6 *IPv6) LayerType() gopacket.LayerType { return LayerTypeIPv6 }
 
+// Validate implements gopacket.ValidatingLayer, verifying the payload length.
+// IPv6 has no header checksum, and jumbograms aren't verified.
+func (ipv6 *IPv6) Validate() (v gopacket.LayerValidation) {
+	if ipv6.Length != 0 {
+		v.SetLength(int(ipv6.Length), len(ipv6.Payload))
+	}
+	return
+}
+
 // NetworkFlow returns this new Flow (EndpointIPv6, SrcIP, DstIP)
 func (ipv6 *IPv6) NetworkFlow() gopacket.Flow {
 	return gopacket.NewFlow(EndpointIPv6, ipv6.SrcIP, ipv6.DstIP)
 }
 
+// NextIPProtocol implements gopacket.IPProtocolLayer, returning NextHeader.
+func (ipv6 *IPv6) NextIPProtocol() uint8 { return uint8(ipv6.NextHeader) }
+
 // Search for Jumbo Payload TLV in IPv6HopByHop and return (length, true) if found
 func getIPv6HopByHopJumboLength(hopopts *IPv6HopByHop) (uint32, bool, error) {
 	var tlv *IPv6HopByHopOption
@@ -431,6 +443,9 @@ func decodeIPv6ExtensionBase(data []byte, df gopacket.DecodeFeedback) (i ipv6Ext
 	return
 }
 
+// NextIPProtocol implements gopacket.IPProtocolLayer, returning NextHeader.
+func (i *ipv6ExtensionBase) NextIPProtocol() uint8 { return uint8(i.NextHeader) }
+
 // IPv6ExtensionSkipper is a DecodingLayer which decodes and ignores v6
 // extensions.  You can use it with a DecodingLayerParser to handle IPv6 stacks
 // which may or may not have extensions.
@@ -460,6 +475,9 @@ func (i *IPv6ExtensionSkipper) NextLayerType() gopacket.LayerType {
 	return i.NextHeader.LayerType()
 }
 
+// NextIPProtocol implements gopacket.IPProtocolLayer, returning NextHeader.
+func (i *IPv6ExtensionSkipper) NextIPProtocol() uint8 { return uint8(i.NextHeader) }
+
 // IPv6HopByHopOption is a TLV option present in an IPv6 hop-by-hop extension.
 type IPv6HopByHopOption ipv6HeaderTLVOption
 
@@ -547,6 +565,12 @@ func (o *IPv6HopByHopOption) SetJumboLength(len uint32) {
 	o.OptionAlignment = [2]uint8{4, 2}
 }
 
+// IPv6Routing types.
+const (
+	IPv6RoutingTypeSourceRoute    = 0 // Deprecated by RFC 5095
+	IPv6RoutingTypeSegmentRouting = 4 // SRH, RFC 8754
+)
+
 // IPv6Routing is the IPv6 routing extension.
 type IPv6Routing struct {
 	ipv6ExtensionBase
@@ -558,37 +582,144 @@ type IPv6Routing struct {
 	// SourceRoutingIPs is the set of IPv6 addresses requested for source routing,
 	// set only if RoutingType == 0.
 	SourceRoutingIPs []net.IP
+
+	// LastEntry, Flags, Tag, Segments and TLVs are the fields of Segment
+	// Routing Headers, set only if RoutingType == 4, where Reserved holds
+	// LastEntry, Flags and Tag.  Segments are in reverse order of the path:
+	// Segments[0] is the last segment, and Segments[SegmentsLeft] the
+	// active one.
+	LastEntry uint8
+	Flags     uint8
+	Tag       uint16
+	Segments  []net.IP
+	TLVs      []IPv6SRHTLV
 }
 
 // LayerType returns LayerTypeIPv6Routing.
 func (i *IPv6Routing) LayerType() gopacket.LayerType { return LayerTypeIPv6Routing }
 
-func decodeIPv6Routing(data []byte, p gopacket.PacketBuilder) error {
-	base, err := decodeIPv6ExtensionBase(data, p)
+// CanDecode implementation according to gopacket.DecodingLayer
+func (i *IPv6Routing) CanDecode() gopacket.LayerClass {
+	return LayerTypeIPv6Routing
+}
+
+// NextLayerType implementation according to gopacket.DecodingLayer
+func (i *IPv6Routing) NextLayerType() gopacket.LayerType {
+	return i.NextHeader.LayerType()
+}
+
+// DecodeFromBytes implementation according to gopacket.DecodingLayer
+func (i *IPv6Routing) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	base, err := decodeIPv6ExtensionBase(data, df)
 	if err != nil {
 		return err
 	}
-	i := &IPv6Routing{
+	*i = IPv6Routing{
 		ipv6ExtensionBase: base,
 		RoutingType:       data[2],
 		SegmentsLeft:      data[3],
 		Reserved:          data[4:8],
+		SourceRoutingIPs:  i.SourceRoutingIPs[:0],
+		Segments:          i.Segments[:0],
+		TLVs:              i.TLVs[:0],
 	}
 	switch i.RoutingType {
-	case 0: // Source routing
+	case IPv6RoutingTypeSourceRoute:
 		if (i.ActualLength-8)%16 != 0 {
 			return fmt.Errorf("Invalid IPv6 source routing, length of type 0 packet %d", i.ActualLength)
 		}
 		for d := i.Contents[8:]; len(d) >= 16; d = d[16:] {
 			i.SourceRoutingIPs = append(i.SourceRoutingIPs, net.IP(d[:16]))
 		}
+	case IPv6RoutingTypeSegmentRouting:
+		i.LastEntry = data[4]
+		i.Flags = data[5]
+		i.Tag = binary.BigEndian.Uint16(data[6:8])
+		end := 8 + 16*(int(i.LastEntry)+1)
+		if end > i.ActualLength {
+			return fmt.Errorf("Invalid IPv6 segment routing header, %d segments exceed length %d", int(i.LastEntry)+1, i.ActualLength)
+		}
+		for d := i.Contents[8:end]; len(d) > 0; d = d[16:] {
+			i.Segments = append(i.Segments, net.IP(d[:16]))
+		}
+		if i.TLVs, err = decodeIPv6SRHTLVs(i.TLVs, i.Contents[end:]); err != nil {
+			return err
+		}
 	default:
 		return fmt.Errorf("Unknown IPv6 routing header type %d", i.RoutingType)
 	}
+	return nil
+}
+
+func decodeIPv6Routing(data []byte, p gopacket.PacketBuilder) error {
+	i := &IPv6Routing{}
+	if err := i.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
 	p.AddLayer(i)
 	return p.NextDecoder(i.NextHeader)
 }
 
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.  Headers of
+// type 0 and 4 can be serialized.  With FixLengths, LastEntry is set from
+// the segments, and the TLVs of segment routing headers are padded to a
+// multiple of 8 bytes.
+func (i *IPv6Routing) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	var addresses []net.IP
+	var tlvs, padding int
+	switch i.RoutingType {
+	case IPv6RoutingTypeSourceRoute:
+		addresses = i.SourceRoutingIPs
+	case IPv6RoutingTypeSegmentRouting:
+		addresses = i.Segments
+		for _, t := range i.TLVs {
+			tlvs += t.size()
+		}
+		if opts.FixLengths {
+			padding = (8 - tlvs%8) % 8
+			i.LastEntry = uint8(len(i.Segments) - 1)
+		}
+	default:
+		return fmt.Errorf("Can't serialize IPv6 routing header type %d", i.RoutingType)
+	}
+	length := 8 + 16*len(addresses) + tlvs + padding
+	if length%8 != 0 {
+		return errors.New("IPv6Routing actual length must be multiple of 8")
+	}
+	bytes, err := b.PrependBytes(length)
+	if err != nil {
+		return err
+	}
+	if opts.FixLengths {
+		i.HeaderLength = uint8(length/8 - 1)
+	}
+	bytes[0] = uint8(i.NextHeader)
+	bytes[1] = i.HeaderLength
+	bytes[2] = i.RoutingType
+	bytes[3] = i.SegmentsLeft
+	if i.RoutingType == IPv6RoutingTypeSegmentRouting {
+		bytes[4] = i.LastEntry
+		bytes[5] = i.Flags
+		binary.BigEndian.PutUint16(bytes[6:], i.Tag)
+	} else {
+		copy(bytes[4:8], lotsOfZeros[:4])
+		copy(bytes[4:8], i.Reserved)
+	}
+	for n, ip := range addresses {
+		if err := checkIPv6Address(ip); err != nil {
+			return err
+		}
+		copy(bytes[8+16*n:], ip.To16())
+	}
+	off := 8 + 16*len(addresses)
+	for _, t := range i.TLVs {
+		off += t.encode(bytes[off:])
+	}
+	serializeIPv6SRHPadding(bytes[off:], padding)
+	return nil
+}
+
 // IPv6Fragment is the IPv6 fragment header, used for packet
 // fragmentation/defragmentation.
 type IPv6Fragment struct {
@@ -606,6 +737,9 @@ type IPv6Fragment struct {
 // LayerType returns LayerTypeIPv6Fragment.
 func (i *IPv6Fragment) LayerType() gopacket.LayerType { return LayerTypeIPv6Fragment }
 
+// NextIPProtocol implements gopacket.IPProtocolLayer, returning NextHeader.
+func (i *IPv6Fragment) NextIPProtocol() uint8 { return uint8(i.NextHeader) }
+
 func decodeIPv6Fragment(data []byte, p gopacket.PacketBuilder) error {
 	if len(data) < 8 {
 		p.SetTruncated()
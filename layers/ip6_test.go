@@ -428,3 +428,108 @@ func TestIPv6JumbogramDecode(t *testing.T) {
 		t.Error("No Payload layer type found in packet")
 	}
 }
+
+func TestIPv6TypedOptionsAndSRH(t *testing.T) {
+	hmac := bytes.Repeat([]byte{0xab}, 32)
+	ip6 := &IPv6{
+		Version: 6, NextHeader: IPProtocolIPv6HopByHop, HopLimit: 64,
+		SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("2001:db8::3"),
+		HopByHop: &IPv6HopByHop{},
+	}
+	ip6.HopByHop.NextHeader = IPProtocolIPv6Routing
+	ip6.HopByHop.Options = []*IPv6HopByHopOption{
+		NewIPv6HopByHopOptionRouterAlert(0),
+		NewIPv6HopByHopOptionIOAM(IPv6IOAM{Type: IPv6IOAMPreallocatedTrace, NamespaceID: 7, NodeLen: 1, RemainingLen: 2, TraceType: 0x800000, Data: make([]byte, 8)}),
+		NewIPv6HopByHopOptionCALIPSO(IPv6CALIPSO{DOI: 5, Level: 3, Compartments: []uint16{1, 40}}),
+	}
+	segments := []net.IP{net.ParseIP("2001:db8::3"), net.ParseIP("2001:db8::2"), net.ParseIP("2001:db8::10")}
+	routing := &IPv6Routing{
+		RoutingType:  IPv6RoutingTypeSegmentRouting,
+		SegmentsLeft: 2,
+		Tag:          0x1234,
+		Segments:     segments,
+		TLVs:         []IPv6SRHTLV{NewIPv6SRHTLVHMAC(IPv6SRHHMAC{DestinationCheck: true, KeyID: 9, HMAC: hmac})},
+	}
+	routing.NextHeader = IPProtocolUDP
+	udp := &UDP{SrcPort: 1000, DstPort: 2000}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, ip6, routing, udp); err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), LayerTypeIPv6, testDecodeOptions)
+	checkLayers(p, []gopacket.LayerType{LayerTypeIPv6, LayerTypeIPv6HopByHop, LayerTypeIPv6Routing, LayerTypeUDP}, t)
+
+	var alert, ioam, calipso bool
+	for _, o := range p.Layer(LayerTypeIPv6HopByHop).(*IPv6HopByHop).Options {
+		if v, ok := o.RouterAlert(); ok {
+			alert = v == 0
+		}
+		if v, ok := o.IOAM(); ok {
+			ioam = v.Type == IPv6IOAMPreallocatedTrace && v.NamespaceID == 7 && v.NodeLen == 1 &&
+				v.RemainingLen == 2 && v.TraceType == 0x800000 && len(v.Data) == 8
+			if o.OptionType != IPv6OptionIOAMChanging {
+				t.Errorf("IOAM trace option type %#x", o.OptionType)
+			}
+		}
+		if v, ok := o.CALIPSO(); ok {
+			option := append([]byte{o.OptionType, o.OptionLength}, o.OptionData...)
+			option[8], option[9] = 0, 0
+			calipso = v.DOI == 5 && v.Level == 3 && reflect.DeepEqual(v.Compartments, []uint16{1, 40}) &&
+				v.Checksum == ^crc16CCITT(0xffff, option)
+		}
+	}
+	if !alert || !ioam || !calipso {
+		t.Errorf("hop-by-hop options: router alert %v, IOAM %v, CALIPSO %v", alert, ioam, calipso)
+	}
+
+	got := p.Layer(LayerTypeIPv6Routing).(*IPv6Routing)
+	if got.LastEntry != 2 || got.SegmentsLeft != 2 || got.Tag != 0x1234 || len(got.Segments) != 3 || !got.Segments[2].Equal(segments[2]) {
+		t.Errorf("segment routing header: got %+v", got)
+	}
+	if len(got.TLVs) != 1 {
+		t.Fatalf("got %d TLVs, want 1", len(got.TLVs))
+	}
+	if h, ok := got.TLVs[0].HMAC(); !ok || !h.DestinationCheck || h.KeyID != 9 || !bytes.Equal(h.HMAC, hmac) {
+		t.Errorf("HMAC TLV: got %+v, %v", h, ok)
+	}
+}
+
+func TestIPv6RoutingDecodingLayer(t *testing.T) {
+	ip6 := &IPv6{
+		Version: 6, NextHeader: IPProtocolIPv6Routing, HopLimit: 64,
+		SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("2001:db8::3"),
+	}
+	routing := &IPv6Routing{
+		RoutingType:  IPv6RoutingTypeSegmentRouting,
+		SegmentsLeft: 1,
+		Segments:     []net.IP{net.ParseIP("2001:db8::3"), net.ParseIP("2001:db8::2")},
+	}
+	routing.NextHeader = IPProtocolUDP
+	udp := &UDP{SrcPort: 1000, DstPort: 2000}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, ip6, routing, udp); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		gotIP6     IPv6
+		gotRouting IPv6Routing
+		gotUDP     UDP
+		payload    gopacket.Payload
+	)
+	parser := gopacket.NewDecodingLayerParser(LayerTypeIPv6, &gotIP6, &gotRouting, &gotUDP, &payload)
+	decoded := []gopacket.LayerType{}
+	if err := parser.DecodeLayers(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	want := []gopacket.LayerType{LayerTypeIPv6, LayerTypeIPv6Routing, LayerTypeUDP}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("decoded %v, want %v", decoded, want)
+	}
+	if gotRouting.SegmentsLeft != 1 || len(gotRouting.Segments) != 2 || !gotRouting.Segments[1].Equal(routing.Segments[1]) {
+		t.Errorf("segment routing header: got %+v", gotRouting)
+	}
+	if gotUDP.DstPort != 2000 {
+		t.Errorf("UDP destination port %d, want 2000", gotUDP.DstPort)
+	}
+}
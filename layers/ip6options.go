@@ -0,0 +1,341 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// IPv6 hop-by-hop and destination option types.
+const (
+	// IPv6HopByHopOptionRouterAlert code as defined in RFC 2711
+	IPv6HopByHopOptionRouterAlert = 0x05
+	// IPv6HopByHopOptionCALIPSO code as defined in RFC 5570
+	IPv6HopByHopOptionCALIPSO = 0x07
+	// IPv6OptionIOAM and IPv6OptionIOAMChanging codes as defined in RFC
+	// 9486, for hop-by-hop and destination options, the latter for IOAM
+	// data which may change en route, such as pre-allocated traces.
+	IPv6OptionIOAM         = 0x11
+	IPv6OptionIOAMChanging = 0x31
+)
+
+func newIPv6HopByHopOption(optionType uint8, data []byte, alignment [2]uint8) *IPv6HopByHopOption {
+	return &IPv6HopByHopOption{
+		OptionType:      optionType,
+		OptionLength:    uint8(len(data)),
+		ActualLength:    len(data) + 2,
+		OptionData:      data,
+		OptionAlignment: alignment,
+	}
+}
+
+// RouterAlert returns the value of a router alert option, 0 meaning the
+// packet contains a MLD message, 1 a RSVP one and 2 an active networks one.
+func (o *IPv6HopByHopOption) RouterAlert() (uint16, bool) {
+	if o.OptionType != IPv6HopByHopOptionRouterAlert || len(o.OptionData) != 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(o.OptionData), true
+}
+
+// NewIPv6HopByHopOptionRouterAlert returns a router alert option.
+func NewIPv6HopByHopOptionRouterAlert(value uint16) *IPv6HopByHopOption {
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, value)
+	return newIPv6HopByHopOption(IPv6HopByHopOptionRouterAlert, data, [2]uint8{2, 0})
+}
+
+// IPv6CALIPSO is the content of a Common Architecture Label IPv6 Security
+// Option, see RFC 5570.
+type IPv6CALIPSO struct {
+	// DOI is the domain of interpretation of the label.
+	DOI uint32
+	// Level is the sensitivity level.
+	Level uint8
+	// Compartments are the numbers of the bits set in the compartment
+	// bitmap.
+	Compartments []uint16
+	// Checksum is the CRC-16 of the option, computed when it's created.
+	Checksum uint16
+}
+
+// CALIPSO returns the content of a CALIPSO option.
+func (o *IPv6HopByHopOption) CALIPSO() (IPv6CALIPSO, bool) {
+	d := o.OptionData
+	if o.OptionType != IPv6HopByHopOptionCALIPSO || len(d) < 8 || len(d) != 8+4*int(d[4]) {
+		return IPv6CALIPSO{}, false
+	}
+	return IPv6CALIPSO{
+		DOI:          binary.BigEndian.Uint32(d),
+		Level:        d[5],
+		Checksum:     binary.LittleEndian.Uint16(d[6:]),
+		Compartments: bitmapToList(d[8:]),
+	}, true
+}
+
+// NewIPv6HopByHopOptionCALIPSO returns a CALIPSO option, computing its
+// checksum.
+func NewIPv6HopByHopOptionCALIPSO(c IPv6CALIPSO) *IPv6HopByHopOption {
+	bitmap := listToBitmap(c.Compartments, 4)
+	// The checksum covers the option type and length.
+	option := make([]byte, 10, 10+len(bitmap))
+	option[0] = IPv6HopByHopOptionCALIPSO
+	option[1] = uint8(8 + len(bitmap))
+	binary.BigEndian.PutUint32(option[2:], c.DOI)
+	option[6] = uint8(len(bitmap) / 4)
+	option[7] = c.Level
+	option = append(option, bitmap...)
+	// The checksum is sent least significant byte first, as by Linux.
+	binary.LittleEndian.PutUint16(option[8:], ^crc16CCITT(0xffff, option))
+	return newIPv6HopByHopOption(IPv6HopByHopOptionCALIPSO, option[2:], [2]uint8{4, 2})
+}
+
+// crc16CCITT updates crc with data, using the CCITT polynomial in reversed
+// bit order, as the FCS-16 of RFC 1662.
+func crc16CCITT(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0x8408
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// IPv6IOAMType is the type of IOAM data, see RFC 9197.
+type IPv6IOAMType uint8
+
+// IPv6IOAMType known values.
+const (
+	IPv6IOAMPreallocatedTrace IPv6IOAMType = 0
+	IPv6IOAMIncrementalTrace  IPv6IOAMType = 1
+	IPv6IOAMProofOfTransit    IPv6IOAMType = 2
+	IPv6IOAMEdgeToEdge        IPv6IOAMType = 3
+	IPv6IOAMDirectExport      IPv6IOAMType = 4 // RFC 9326
+)
+
+func (t IPv6IOAMType) String() string {
+	switch t {
+	case IPv6IOAMPreallocatedTrace:
+		return "PreallocatedTrace"
+	case IPv6IOAMIncrementalTrace:
+		return "IncrementalTrace"
+	case IPv6IOAMProofOfTransit:
+		return "ProofOfTransit"
+	case IPv6IOAMEdgeToEdge:
+		return "EdgeToEdge"
+	case IPv6IOAMDirectExport:
+		return "DirectExport"
+	}
+	return "Unknown"
+}
+
+// IPv6IOAM is the content of an IOAM option, carrying In-situ Operations,
+// Administration, and Maintenance data, see RFC 9197 and 9486.
+type IPv6IOAM struct {
+	Type IPv6IOAMType
+	// NamespaceID is the namespace of the data of traces, proof of transit
+	// and edge-to-edge options.
+	NamespaceID uint16
+
+	// NodeLen, Flags, RemainingLen and TraceType are the header of traces.
+	// NodeLen and RemainingLen are in units of 4 bytes.  Flags are also
+	// the flags of proof of transit options.
+	NodeLen      uint8
+	Flags        uint8
+	RemainingLen uint8
+	TraceType    uint32
+	// POTType is the type of proof of transit options.
+	POTType uint8
+	// E2EType is the bitmap of the data of edge-to-edge options.
+	E2EType uint16
+
+	// Data follows the header: it's the node data list of traces, and
+	// everything following the type for other types than the above.
+	Data []byte
+}
+
+func decodeIPv6IOAM(optionType uint8, d []byte) (IPv6IOAM, bool) {
+	if optionType != IPv6OptionIOAM && optionType != IPv6OptionIOAMChanging || len(d) < 2 {
+		return IPv6IOAM{}, false
+	}
+	o := IPv6IOAM{Type: IPv6IOAMType(d[1])}
+	d = d[2:]
+	switch o.Type {
+	case IPv6IOAMPreallocatedTrace, IPv6IOAMIncrementalTrace:
+		if len(d) < 8 {
+			return IPv6IOAM{}, false
+		}
+		o.NamespaceID = binary.BigEndian.Uint16(d)
+		o.NodeLen = d[2] >> 3
+		o.Flags = (d[2]&0x07)<<1 | d[3]>>7
+		o.RemainingLen = d[3] & 0x7f
+		o.TraceType = binary.BigEndian.Uint32(d[4:]) >> 8
+		o.Data = d[8:]
+	case IPv6IOAMProofOfTransit:
+		if len(d) < 4 {
+			return IPv6IOAM{}, false
+		}
+		o.NamespaceID = binary.BigEndian.Uint16(d)
+		o.POTType, o.Flags = d[2], d[3]
+		o.Data = d[4:]
+	case IPv6IOAMEdgeToEdge:
+		if len(d) < 4 {
+			return IPv6IOAM{}, false
+		}
+		o.NamespaceID = binary.BigEndian.Uint16(d)
+		o.E2EType = binary.BigEndian.Uint16(d[2:])
+		o.Data = d[4:]
+	default:
+		o.Data = d
+	}
+	return o, true
+}
+
+func (o IPv6IOAM) encode() []byte {
+	data := []byte{0, byte(o.Type)}
+	switch o.Type {
+	case IPv6IOAMPreallocatedTrace, IPv6IOAMIncrementalTrace:
+		data = appendUint16(data, o.NamespaceID)
+		data = append(data, o.NodeLen<<3|o.Flags>>1&0x07, o.Flags<<7|o.RemainingLen&0x7f)
+		data = appendUint32(data, o.TraceType<<8)
+	case IPv6IOAMProofOfTransit:
+		data = appendUint16(data, o.NamespaceID)
+		data = append(data, o.POTType, o.Flags)
+	case IPv6IOAMEdgeToEdge:
+		data = appendUint16(data, o.NamespaceID)
+		data = appendUint16(data, o.E2EType)
+	}
+	return append(data, o.Data...)
+}
+
+// IOAM returns the content of an IOAM option.  Data points into the
+// option's data.
+func (o *IPv6HopByHopOption) IOAM() (IPv6IOAM, bool) {
+	return decodeIPv6IOAM(o.OptionType, o.OptionData)
+}
+
+// IOAM returns the content of an IOAM option.  Data points into the
+// option's data.
+func (o *IPv6DestinationOption) IOAM() (IPv6IOAM, bool) {
+	return decodeIPv6IOAM(o.OptionType, o.OptionData)
+}
+
+// NewIPv6HopByHopOptionIOAM returns an IOAM hop-by-hop option, of type
+// IPv6OptionIOAMChanging for traces and IPv6OptionIOAM otherwise.
+func NewIPv6HopByHopOptionIOAM(o IPv6IOAM) *IPv6HopByHopOption {
+	optionType := uint8(IPv6OptionIOAM)
+	if o.Type == IPv6IOAMPreallocatedTrace || o.Type == IPv6IOAMIncrementalTrace {
+		optionType = IPv6OptionIOAMChanging
+	}
+	return newIPv6HopByHopOption(optionType, o.encode(), [2]uint8{4, 0})
+}
+
+// NewIPv6DestinationOptionIOAM returns an IOAM destination option.
+func NewIPv6DestinationOptionIOAM(o IPv6IOAM) *IPv6DestinationOption {
+	return (*IPv6DestinationOption)(newIPv6HopByHopOption(IPv6OptionIOAM, o.encode(), [2]uint8{4, 0}))
+}
+
+// IPv6SRHTLVType is the type of a TLV of a segment routing header.
+type IPv6SRHTLVType uint8
+
+// IPv6SRHTLVType known values.
+const (
+	IPv6SRHTLVPad1 IPv6SRHTLVType = 0
+	IPv6SRHTLVPadN IPv6SRHTLVType = 4
+	IPv6SRHTLVHMAC IPv6SRHTLVType = 5
+)
+
+// IPv6SRHTLV is a TLV of a segment routing header, see RFC 8754.  Pad1 TLVs
+// have no length nor value.
+type IPv6SRHTLV struct {
+	Type  IPv6SRHTLVType
+	Value []byte
+}
+
+func decodeIPv6SRHTLVs(tlvs []IPv6SRHTLV, data []byte) ([]IPv6SRHTLV, error) {
+	for len(data) > 0 {
+		t := IPv6SRHTLV{Type: IPv6SRHTLVType(data[0])}
+		if t.Type == IPv6SRHTLVPad1 {
+			tlvs = append(tlvs, t)
+			data = data[1:]
+			continue
+		}
+		if len(data) < 2 || len(data) < 2+int(data[1]) {
+			return tlvs, errors.New("Invalid IPv6 segment routing header TLV length")
+		}
+		t.Value = data[2 : 2+int(data[1])]
+		tlvs = append(tlvs, t)
+		data = data[2+int(data[1]):]
+	}
+	return tlvs, nil
+}
+
+func (t IPv6SRHTLV) size() int {
+	if t.Type == IPv6SRHTLVPad1 {
+		return 1
+	}
+	return 2 + len(t.Value)
+}
+
+func (t IPv6SRHTLV) encode(data []byte) int {
+	data[0] = byte(t.Type)
+	if t.Type == IPv6SRHTLVPad1 {
+		return 1
+	}
+	data[1] = uint8(len(t.Value))
+	copy(data[2:], t.Value)
+	return 2 + len(t.Value)
+}
+
+// serializeIPv6SRHPadding writes padLength bytes of Pad1 or PadN TLVs.
+func serializeIPv6SRHPadding(data []byte, padLength int) {
+	switch {
+	case padLength <= 0:
+	case padLength == 1:
+		data[0] = byte(IPv6SRHTLVPad1)
+	default:
+		IPv6SRHTLV{Type: IPv6SRHTLVPadN, Value: lotsOfZeros[:padLength-2]}.encode(data)
+	}
+}
+
+// IPv6SRHHMAC is the content of a HMAC TLV.
+type IPv6SRHHMAC struct {
+	// DestinationCheck is the D flag, set when the destination address
+	// must be checked against the segments.
+	DestinationCheck bool
+	KeyID            uint32
+	HMAC             []byte
+}
+
+// HMAC returns the content of a HMAC TLV.  The HMAC points into the TLV's
+// value.
+func (t IPv6SRHTLV) HMAC() (IPv6SRHHMAC, bool) {
+	if t.Type != IPv6SRHTLVHMAC || len(t.Value) < 6 {
+		return IPv6SRHHMAC{}, false
+	}
+	return IPv6SRHHMAC{
+		DestinationCheck: t.Value[0]&0x80 != 0,
+		KeyID:            binary.BigEndian.Uint32(t.Value[2:]),
+		HMAC:             t.Value[6:],
+	}, true
+}
+
+// NewIPv6SRHTLVHMAC returns a HMAC TLV.
+func NewIPv6SRHTLVHMAC(h IPv6SRHHMAC) IPv6SRHTLV {
+	value := make([]byte, 6, 6+len(h.HMAC))
+	if h.DestinationCheck {
+		value[0] = 0x80
+	}
+	binary.BigEndian.PutUint32(value[2:], h.KeyID)
+	return IPv6SRHTLV{Type: IPv6SRHTLVHMAC, Value: append(value, h.HMAC...)}
+}
@@ -0,0 +1,436 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// IPFIX is an IPFIX message (RFC 7011).  See netflow.go for the templates,
+// data sets and records it shares with NetFlow v9.
+type IPFIX struct {
+	BaseLayer
+	Version             uint16
+	Length              uint16
+	ExportTimeSecs      uint32
+	SequenceNumber      uint32
+	ObservationDomainID uint32
+	// Templates holds the templates and options templates of the message,
+	// including withdrawals.
+	Templates []*NetFlowTemplate
+	// DataSets holds the data sets of the message.  Those whose template is
+	// defined in the message are decoded; see DecodeWithTemplates for the
+	// others.
+	DataSets []NetFlowDataSet
+}
+
+const (
+	ipfixHeaderLen          = 16
+	ipfixTemplateSetID      = 2
+	ipfixOptionsTemplateSet = 3
+)
+
+// LayerType returns LayerTypeIPFIX.
+func (i *IPFIX) LayerType() gopacket.LayerType { return LayerTypeIPFIX }
+
+// CanDecode returns LayerTypeIPFIX.
+func (i *IPFIX) CanDecode() gopacket.LayerClass { return LayerTypeIPFIX }
+
+// NextLayerType returns gopacket.LayerTypeZero.
+func (i *IPFIX) NextLayerType() gopacket.LayerType { return gopacket.LayerTypeZero }
+
+// Payload returns nil.
+func (i *IPFIX) Payload() []byte { return nil }
+
+// ExportTime returns the time the message was exported.
+func (i *IPFIX) ExportTime() time.Time {
+	return time.Unix(int64(i.ExportTimeSecs), 0).UTC()
+}
+
+// DecodeWithTemplates adds the templates of the message to the cache, and
+// decodes the data sets whose templates weren't in the message with the
+// cached ones.  The exporter is the network endpoint the message was received
+// from.  It returns the number of data sets whose template is still unknown.
+func (i *IPFIX) DecodeWithTemplates(c *NetFlowTemplateCache, exporter gopacket.Endpoint) (int, error) {
+	k := NetFlowTemplateKey{Exporter: exporter, Version: 10, ObservationDomainID: i.ObservationDomainID}
+	return netFlowDecodeWithTemplates(c, k, i.Templates, i.DataSets)
+}
+
+// DecodeFromBytes decodes the given bytes into this layer.
+func (i *IPFIX) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < ipfixHeaderLen {
+		df.SetTruncated()
+		return errors.New("IPFIX message too short")
+	}
+	i.Version = binary.BigEndian.Uint16(data[0:2])
+	if i.Version != 10 {
+		return fmt.Errorf("invalid IPFIX version %d", i.Version)
+	}
+	i.Length = binary.BigEndian.Uint16(data[2:4])
+	i.ExportTimeSecs = binary.BigEndian.Uint32(data[4:8])
+	i.SequenceNumber = binary.BigEndian.Uint32(data[8:12])
+	i.ObservationDomainID = binary.BigEndian.Uint32(data[12:16])
+	if int(i.Length) < ipfixHeaderLen {
+		return fmt.Errorf("invalid IPFIX message length %d", i.Length)
+	}
+	if int(i.Length) > len(data) {
+		df.SetTruncated()
+		return fmt.Errorf("IPFIX message length %d exceeds %d bytes available", i.Length, len(data))
+	}
+	i.Templates = i.Templates[:0]
+	i.DataSets = i.DataSets[:0]
+
+	for sets := data[ipfixHeaderLen:i.Length]; len(sets) > 0; {
+		if len(sets) < 4 {
+			return errors.New("IPFIX set header truncated")
+		}
+		id, length := binary.BigEndian.Uint16(sets[0:2]), int(binary.BigEndian.Uint16(sets[2:4]))
+		if length < 4 || length > len(sets) {
+			return fmt.Errorf("invalid IPFIX set length %d", length)
+		}
+		body := sets[4:length]
+		sets = sets[length:]
+		var err error
+		switch {
+		case id == ipfixTemplateSetID:
+			err = i.decodeTemplates(body, false)
+		case id == ipfixOptionsTemplateSet:
+			err = i.decodeTemplates(body, true)
+		case id >= 256:
+			i.DataSets = append(i.DataSets, NetFlowDataSet{TemplateID: id, Data: body})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	i.BaseLayer = BaseLayer{Contents: data[:i.Length], Payload: data[i.Length:]}
+	return netFlowDecodeLocal(i.Templates, i.DataSets, true)
+}
+
+func (i *IPFIX) decodeTemplates(data []byte, options bool) error {
+	for len(data) >= 4 {
+		t := &NetFlowTemplate{ID: binary.BigEndian.Uint16(data[0:2]), Options: options}
+		count := int(binary.BigEndian.Uint16(data[2:4]))
+		if t.ID < 256 {
+			// Padding.
+			break
+		}
+		data = data[4:]
+		if count == 0 {
+			// Template withdrawal.
+			i.Templates = append(i.Templates, t)
+			continue
+		}
+		if options {
+			if len(data) < 2 {
+				return fmt.Errorf("IPFIX options template %d truncated", t.ID)
+			}
+			t.ScopeFieldCount = int(binary.BigEndian.Uint16(data[0:2]))
+			data = data[2:]
+			if t.ScopeFieldCount == 0 || t.ScopeFieldCount > count {
+				return fmt.Errorf("invalid IPFIX options template %d scope field count %d", t.ID, t.ScopeFieldCount)
+			}
+		}
+		// Field specifiers take at least 4 bytes each.
+		if len(data) < count*4 {
+			return fmt.Errorf("IPFIX template %d truncated", t.ID)
+		}
+		t.Fields = make([]NetFlowField, count)
+		for j := range t.Fields {
+			if len(data) < 4 {
+				return fmt.Errorf("IPFIX template %d truncated", t.ID)
+			}
+			f := &t.Fields[j]
+			f.ID, f.Length = binary.BigEndian.Uint16(data[0:2]), binary.BigEndian.Uint16(data[2:4])
+			if f.Length == 0 {
+				return fmt.Errorf("IPFIX template %d has a zero-length field", t.ID)
+			}
+			data = data[4:]
+			if f.ID&0x8000 != 0 {
+				if len(data) < 4 {
+					return fmt.Errorf("IPFIX template %d truncated", t.ID)
+				}
+				f.ID &^= 0x8000
+				f.EnterpriseNumber = binary.BigEndian.Uint32(data[0:4])
+				data = data[4:]
+			}
+		}
+		i.Templates = append(i.Templates, t)
+	}
+	return nil
+}
+
+func decodeIPFIX(data []byte, p gopacket.PacketBuilder) error {
+	if d := netFlowVersionDecoder(data, 10); d != nil {
+		return d(data, p)
+	}
+	i := &IPFIX{}
+	if err := i.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(i)
+	p.SetApplicationLayer(i)
+	if c := NetFlowTemplates; c != nil {
+		if exporter, ok := netFlowExporter(p); ok {
+			if _, err := i.DecodeWithTemplates(c, exporter); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// IPFIXDataType is the abstract data type of an IPFIX information element
+// (RFC 7012).
+type IPFIXDataType uint8
+
+// IPFIX abstract data types.  Structured data types are treated as
+// IPFIXTypeOctetArray.
+const (
+	IPFIXTypeOctetArray IPFIXDataType = iota
+	IPFIXTypeUnsigned
+	IPFIXTypeSigned
+	IPFIXTypeFloat
+	IPFIXTypeBoolean
+	IPFIXTypeMACAddress
+	IPFIXTypeString
+	IPFIXTypeDateTimeSeconds
+	IPFIXTypeDateTimeMilliseconds
+	IPFIXTypeDateTimeMicroseconds
+	IPFIXTypeDateTimeNanoseconds
+	IPFIXTypeIPv4Address
+	IPFIXTypeIPv6Address
+)
+
+// IPFIXInformationElement describes an IANA IPFIX information element.
+type IPFIXInformationElement struct {
+	Name string
+	Type IPFIXDataType
+}
+
+// IPFIXInformationElementByID returns the IANA information element with the
+// given ID, or false if it's not known.
+func IPFIXInformationElementByID(id uint16) (IPFIXInformationElement, bool) {
+	ie, ok := ipfixInformationElements[id]
+	return ie, ok
+}
+
+// ipfixInformationElements holds the commonly used information elements of the
+// IANA IPFIX registry.  NetFlow v9 field types share the same IDs.
+var ipfixInformationElements = map[uint16]IPFIXInformationElement{
+	1:   {"octetDeltaCount", IPFIXTypeUnsigned},
+	2:   {"packetDeltaCount", IPFIXTypeUnsigned},
+	3:   {"deltaFlowCount", IPFIXTypeUnsigned},
+	4:   {"protocolIdentifier", IPFIXTypeUnsigned},
+	5:   {"ipClassOfService", IPFIXTypeUnsigned},
+	6:   {"tcpControlBits", IPFIXTypeUnsigned},
+	7:   {"sourceTransportPort", IPFIXTypeUnsigned},
+	8:   {"sourceIPv4Address", IPFIXTypeIPv4Address},
+	9:   {"sourceIPv4PrefixLength", IPFIXTypeUnsigned},
+	10:  {"ingressInterface", IPFIXTypeUnsigned},
+	11:  {"destinationTransportPort", IPFIXTypeUnsigned},
+	12:  {"destinationIPv4Address", IPFIXTypeIPv4Address},
+	13:  {"destinationIPv4PrefixLength", IPFIXTypeUnsigned},
+	14:  {"egressInterface", IPFIXTypeUnsigned},
+	15:  {"ipNextHopIPv4Address", IPFIXTypeIPv4Address},
+	16:  {"bgpSourceAsNumber", IPFIXTypeUnsigned},
+	17:  {"bgpDestinationAsNumber", IPFIXTypeUnsigned},
+	18:  {"bgpNextHopIPv4Address", IPFIXTypeIPv4Address},
+	19:  {"postMCastPacketDeltaCount", IPFIXTypeUnsigned},
+	20:  {"postMCastOctetDeltaCount", IPFIXTypeUnsigned},
+	21:  {"flowEndSysUpTime", IPFIXTypeUnsigned},
+	22:  {"flowStartSysUpTime", IPFIXTypeUnsigned},
+	23:  {"postOctetDeltaCount", IPFIXTypeUnsigned},
+	24:  {"postPacketDeltaCount", IPFIXTypeUnsigned},
+	25:  {"minimumIpTotalLength", IPFIXTypeUnsigned},
+	26:  {"maximumIpTotalLength", IPFIXTypeUnsigned},
+	27:  {"sourceIPv6Address", IPFIXTypeIPv6Address},
+	28:  {"destinationIPv6Address", IPFIXTypeIPv6Address},
+	29:  {"sourceIPv6PrefixLength", IPFIXTypeUnsigned},
+	30:  {"destinationIPv6PrefixLength", IPFIXTypeUnsigned},
+	31:  {"flowLabelIPv6", IPFIXTypeUnsigned},
+	32:  {"icmpTypeCodeIPv4", IPFIXTypeUnsigned},
+	33:  {"igmpType", IPFIXTypeUnsigned},
+	34:  {"samplingInterval", IPFIXTypeUnsigned},
+	35:  {"samplingAlgorithm", IPFIXTypeUnsigned},
+	36:  {"flowActiveTimeout", IPFIXTypeUnsigned},
+	37:  {"flowIdleTimeout", IPFIXTypeUnsigned},
+	38:  {"engineType", IPFIXTypeUnsigned},
+	39:  {"engineId", IPFIXTypeUnsigned},
+	40:  {"exportedOctetTotalCount", IPFIXTypeUnsigned},
+	41:  {"exportedMessageTotalCount", IPFIXTypeUnsigned},
+	42:  {"exportedFlowRecordTotalCount", IPFIXTypeUnsigned},
+	43:  {"ipv4RouterSc", IPFIXTypeIPv4Address},
+	44:  {"sourceIPv4Prefix", IPFIXTypeIPv4Address},
+	45:  {"destinationIPv4Prefix", IPFIXTypeIPv4Address},
+	46:  {"mplsTopLabelType", IPFIXTypeUnsigned},
+	47:  {"mplsTopLabelIPv4Address", IPFIXTypeIPv4Address},
+	48:  {"samplerId", IPFIXTypeUnsigned},
+	49:  {"samplerMode", IPFIXTypeUnsigned},
+	50:  {"samplerRandomInterval", IPFIXTypeUnsigned},
+	51:  {"classId", IPFIXTypeUnsigned},
+	52:  {"minimumTTL", IPFIXTypeUnsigned},
+	53:  {"maximumTTL", IPFIXTypeUnsigned},
+	54:  {"fragmentIdentification", IPFIXTypeUnsigned},
+	55:  {"postIpClassOfService", IPFIXTypeUnsigned},
+	56:  {"sourceMacAddress", IPFIXTypeMACAddress},
+	57:  {"postDestinationMacAddress", IPFIXTypeMACAddress},
+	58:  {"vlanId", IPFIXTypeUnsigned},
+	59:  {"postVlanId", IPFIXTypeUnsigned},
+	60:  {"ipVersion", IPFIXTypeUnsigned},
+	61:  {"flowDirection", IPFIXTypeUnsigned},
+	62:  {"ipNextHopIPv6Address", IPFIXTypeIPv6Address},
+	63:  {"bgpNextHopIPv6Address", IPFIXTypeIPv6Address},
+	64:  {"ipv6ExtensionHeaders", IPFIXTypeUnsigned},
+	70:  {"mplsTopLabelStackSection", IPFIXTypeOctetArray},
+	71:  {"mplsLabelStackSection2", IPFIXTypeOctetArray},
+	72:  {"mplsLabelStackSection3", IPFIXTypeOctetArray},
+	73:  {"mplsLabelStackSection4", IPFIXTypeOctetArray},
+	74:  {"mplsLabelStackSection5", IPFIXTypeOctetArray},
+	75:  {"mplsLabelStackSection6", IPFIXTypeOctetArray},
+	76:  {"mplsLabelStackSection7", IPFIXTypeOctetArray},
+	77:  {"mplsLabelStackSection8", IPFIXTypeOctetArray},
+	78:  {"mplsLabelStackSection9", IPFIXTypeOctetArray},
+	79:  {"mplsLabelStackSection10", IPFIXTypeOctetArray},
+	80:  {"destinationMacAddress", IPFIXTypeMACAddress},
+	81:  {"postSourceMacAddress", IPFIXTypeMACAddress},
+	82:  {"interfaceName", IPFIXTypeString},
+	83:  {"interfaceDescription", IPFIXTypeString},
+	84:  {"samplerName", IPFIXTypeString},
+	85:  {"octetTotalCount", IPFIXTypeUnsigned},
+	86:  {"packetTotalCount", IPFIXTypeUnsigned},
+	87:  {"flagsAndSamplerId", IPFIXTypeUnsigned},
+	88:  {"fragmentOffset", IPFIXTypeUnsigned},
+	89:  {"forwardingStatus", IPFIXTypeUnsigned},
+	90:  {"mplsVpnRouteDistinguisher", IPFIXTypeOctetArray},
+	91:  {"mplsTopLabelPrefixLength", IPFIXTypeUnsigned},
+	92:  {"srcTrafficIndex", IPFIXTypeUnsigned},
+	93:  {"dstTrafficIndex", IPFIXTypeUnsigned},
+	94:  {"applicationDescription", IPFIXTypeString},
+	95:  {"applicationId", IPFIXTypeOctetArray},
+	96:  {"applicationName", IPFIXTypeString},
+	98:  {"postIpDiffServCodePoint", IPFIXTypeUnsigned},
+	99:  {"multicastReplicationFactor", IPFIXTypeUnsigned},
+	100: {"className", IPFIXTypeString},
+	101: {"classificationEngineId", IPFIXTypeUnsigned},
+	102: {"layer2packetSectionOffset", IPFIXTypeUnsigned},
+	103: {"layer2packetSectionSize", IPFIXTypeUnsigned},
+	104: {"layer2packetSectionData", IPFIXTypeOctetArray},
+	128: {"bgpNextAdjacentAsNumber", IPFIXTypeUnsigned},
+	129: {"bgpPrevAdjacentAsNumber", IPFIXTypeUnsigned},
+	130: {"exporterIPv4Address", IPFIXTypeIPv4Address},
+	131: {"exporterIPv6Address", IPFIXTypeIPv6Address},
+	132: {"droppedOctetDeltaCount", IPFIXTypeUnsigned},
+	133: {"droppedPacketDeltaCount", IPFIXTypeUnsigned},
+	134: {"droppedOctetTotalCount", IPFIXTypeUnsigned},
+	135: {"droppedPacketTotalCount", IPFIXTypeUnsigned},
+	136: {"flowEndReason", IPFIXTypeUnsigned},
+	137: {"commonPropertiesId", IPFIXTypeUnsigned},
+	138: {"observationPointId", IPFIXTypeUnsigned},
+	139: {"icmpTypeCodeIPv6", IPFIXTypeUnsigned},
+	140: {"mplsTopLabelIPv6Address", IPFIXTypeIPv6Address},
+	141: {"lineCardId", IPFIXTypeUnsigned},
+	142: {"portId", IPFIXTypeUnsigned},
+	143: {"meteringProcessId", IPFIXTypeUnsigned},
+	144: {"exportingProcessId", IPFIXTypeUnsigned},
+	145: {"templateId", IPFIXTypeUnsigned},
+	146: {"wlanChannelId", IPFIXTypeUnsigned},
+	147: {"wlanSSID", IPFIXTypeString},
+	148: {"flowId", IPFIXTypeUnsigned},
+	149: {"observationDomainId", IPFIXTypeUnsigned},
+	150: {"flowStartSeconds", IPFIXTypeDateTimeSeconds},
+	151: {"flowEndSeconds", IPFIXTypeDateTimeSeconds},
+	152: {"flowStartMilliseconds", IPFIXTypeDateTimeMilliseconds},
+	153: {"flowEndMilliseconds", IPFIXTypeDateTimeMilliseconds},
+	154: {"flowStartMicroseconds", IPFIXTypeDateTimeMicroseconds},
+	155: {"flowEndMicroseconds", IPFIXTypeDateTimeMicroseconds},
+	156: {"flowStartNanoseconds", IPFIXTypeDateTimeNanoseconds},
+	157: {"flowEndNanoseconds", IPFIXTypeDateTimeNanoseconds},
+	158: {"flowStartDeltaMicroseconds", IPFIXTypeUnsigned},
+	159: {"flowEndDeltaMicroseconds", IPFIXTypeUnsigned},
+	160: {"systemInitTimeMilliseconds", IPFIXTypeDateTimeMilliseconds},
+	161: {"flowDurationMilliseconds", IPFIXTypeUnsigned},
+	162: {"flowDurationMicroseconds", IPFIXTypeUnsigned},
+	163: {"observedFlowTotalCount", IPFIXTypeUnsigned},
+	164: {"ignoredPacketTotalCount", IPFIXTypeUnsigned},
+	165: {"ignoredOctetTotalCount", IPFIXTypeUnsigned},
+	166: {"notSentFlowTotalCount", IPFIXTypeUnsigned},
+	167: {"notSentPacketTotalCount", IPFIXTypeUnsigned},
+	168: {"notSentOctetTotalCount", IPFIXTypeUnsigned},
+	176: {"icmpTypeIPv4", IPFIXTypeUnsigned},
+	177: {"icmpCodeIPv4", IPFIXTypeUnsigned},
+	178: {"icmpTypeIPv6", IPFIXTypeUnsigned},
+	179: {"icmpCodeIPv6", IPFIXTypeUnsigned},
+	180: {"udpSourcePort", IPFIXTypeUnsigned},
+	181: {"udpDestinationPort", IPFIXTypeUnsigned},
+	182: {"tcpSourcePort", IPFIXTypeUnsigned},
+	183: {"tcpDestinationPort", IPFIXTypeUnsigned},
+	184: {"tcpSequenceNumber", IPFIXTypeUnsigned},
+	185: {"tcpAcknowledgementNumber", IPFIXTypeUnsigned},
+	186: {"tcpWindowSize", IPFIXTypeUnsigned},
+	187: {"tcpUrgentPointer", IPFIXTypeUnsigned},
+	188: {"tcpHeaderLength", IPFIXTypeUnsigned},
+	189: {"ipHeaderLength", IPFIXTypeUnsigned},
+	190: {"totalLengthIPv4", IPFIXTypeUnsigned},
+	191: {"payloadLengthIPv6", IPFIXTypeUnsigned},
+	192: {"ipTTL", IPFIXTypeUnsigned},
+	193: {"nextHeaderIPv6", IPFIXTypeUnsigned},
+	194: {"mplsPayloadLength", IPFIXTypeUnsigned},
+	195: {"ipDiffServCodePoint", IPFIXTypeUnsigned},
+	196: {"ipPrecedence", IPFIXTypeUnsigned},
+	197: {"fragmentFlags", IPFIXTypeUnsigned},
+	198: {"octetDeltaSumOfSquares", IPFIXTypeUnsigned},
+	199: {"octetTotalSumOfSquares", IPFIXTypeUnsigned},
+	200: {"mplsTopLabelTTL", IPFIXTypeUnsigned},
+	201: {"mplsLabelStackLength", IPFIXTypeUnsigned},
+	202: {"mplsLabelStackDepth", IPFIXTypeUnsigned},
+	203: {"mplsTopLabelExp", IPFIXTypeUnsigned},
+	204: {"ipPayloadLength", IPFIXTypeUnsigned},
+	205: {"udpMessageLength", IPFIXTypeUnsigned},
+	206: {"isMulticast", IPFIXTypeUnsigned},
+	207: {"ipv4IHL", IPFIXTypeUnsigned},
+	208: {"ipv4Options", IPFIXTypeUnsigned},
+	209: {"tcpOptions", IPFIXTypeUnsigned},
+	210: {"paddingOctets", IPFIXTypeOctetArray},
+	211: {"collectorIPv4Address", IPFIXTypeIPv4Address},
+	212: {"collectorIPv6Address", IPFIXTypeIPv6Address},
+	213: {"exportInterface", IPFIXTypeUnsigned},
+	214: {"exportProtocolVersion", IPFIXTypeUnsigned},
+	215: {"exportTransportProtocol", IPFIXTypeUnsigned},
+	216: {"collectorTransportPort", IPFIXTypeUnsigned},
+	217: {"exporterTransportPort", IPFIXTypeUnsigned},
+	218: {"tcpSynTotalCount", IPFIXTypeUnsigned},
+	219: {"tcpFinTotalCount", IPFIXTypeUnsigned},
+	220: {"tcpRstTotalCount", IPFIXTypeUnsigned},
+	221: {"tcpPshTotalCount", IPFIXTypeUnsigned},
+	222: {"tcpAckTotalCount", IPFIXTypeUnsigned},
+	223: {"tcpUrgTotalCount", IPFIXTypeUnsigned},
+	224: {"ipTotalLength", IPFIXTypeUnsigned},
+	225: {"postNATSourceIPv4Address", IPFIXTypeIPv4Address},
+	226: {"postNATDestinationIPv4Address", IPFIXTypeIPv4Address},
+	227: {"postNAPTSourceTransportPort", IPFIXTypeUnsigned},
+	228: {"postNAPTDestinationTransportPort", IPFIXTypeUnsigned},
+	229: {"natOriginatingAddressRealm", IPFIXTypeUnsigned},
+	230: {"natEvent", IPFIXTypeUnsigned},
+	231: {"initiatorOctets", IPFIXTypeUnsigned},
+	232: {"responderOctets", IPFIXTypeUnsigned},
+	233: {"firewallEvent", IPFIXTypeUnsigned},
+	234: {"ingressVRFID", IPFIXTypeUnsigned},
+	235: {"egressVRFID", IPFIXTypeUnsigned},
+	236: {"VRFname", IPFIXTypeString},
+	256: {"ethernetType", IPFIXTypeUnsigned},
+	281: {"postNATSourceIPv6Address", IPFIXTypeIPv6Address},
+	282: {"postNATDestinationIPv6Address", IPFIXTypeIPv6Address},
+	298: {"initiatorPackets", IPFIXTypeUnsigned},
+	299: {"responderPackets", IPFIXTypeUnsigned},
+	323: {"observationTimeMilliseconds", IPFIXTypeDateTimeMilliseconds},
+}
@@ -148,6 +148,16 @@ var (
 	LayerTypeASFPresencePong              = gopacket.RegisterLayerType(144, gopacket.LayerTypeMetadata{Name: "ASFPresencePong", Decoder: gopacket.DecodeFunc(decodeASFPresencePong)})
 	LayerTypeERSPANII                     = gopacket.RegisterLayerType(145, gopacket.LayerTypeMetadata{Name: "ERSPAN Type II", Decoder: gopacket.DecodeFunc(decodeERSPANII)})
 	LayerTypeRADIUS                       = gopacket.RegisterLayerType(146, gopacket.LayerTypeMetadata{Name: "RADIUS", Decoder: gopacket.DecodeFunc(decodeRADIUS)})
+	LayerTypeNetFlowV5                    = gopacket.RegisterLayerType(147, gopacket.LayerTypeMetadata{Name: "NetFlowV5", Decoder: gopacket.DecodeFunc(decodeNetFlowV5)})
+	LayerTypeNetFlowV9                    = gopacket.RegisterLayerType(148, gopacket.LayerTypeMetadata{Name: "NetFlowV9", Decoder: gopacket.DecodeFunc(decodeNetFlowV9)})
+	LayerTypeIPFIX                        = gopacket.RegisterLayerType(149, gopacket.LayerTypeMetadata{Name: "IPFIX", Decoder: gopacket.DecodeFunc(decodeIPFIX)})
+	LayerTypeERSPANIII                    = gopacket.RegisterLayerType(150, gopacket.LayerTypeMetadata{Name: "ERSPAN Type III", Decoder: gopacket.DecodeFunc(decodeERSPANIII)})
+	LayerTypeMDNS                         = gopacket.RegisterLayerType(151, gopacket.LayerTypeMetadata{Name: "MDNS", Decoder: gopacket.DecodeFunc(decodeMDNS)})
+	LayerTypeLLMNR                        = gopacket.RegisterLayerType(152, gopacket.LayerTypeMetadata{Name: "LLMNR", Decoder: gopacket.DecodeFunc(decodeLLMNR)})
+	LayerTypeNBNS                         = gopacket.RegisterLayerType(153, gopacket.LayerTypeMetadata{Name: "NBNS", Decoder: gopacket.DecodeFunc(decodeNBNS)})
+	LayerTypeNBDS                         = gopacket.RegisterLayerType(154, gopacket.LayerTypeMetadata{Name: "NBDS", Decoder: gopacket.DecodeFunc(decodeNBDS)})
+	LayerTypeBGP                          = gopacket.RegisterLayerType(155, gopacket.LayerTypeMetadata{Name: "BGP", Decoder: gopacket.DecodeFunc(decodeBGP)})
+	LayerTypeRTP                          = gopacket.RegisterLayerType(156, gopacket.LayerTypeMetadata{Name: "RTP", Decoder: gopacket.DecodeFunc(decodeRTP)})
 )
 
 var (
@@ -0,0 +1,105 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"github.com/google/gopacket"
+)
+
+// MDNS is a Multicast DNS message, see RFC 6762.  mDNS uses the DNS message
+// format, except that the top bit of the class of questions asks for a
+// unicast response, and that of records tells to flush the cache: they're
+// decoded into the UnicastResponse and CacheFlush fields of the questions
+// and records, instead of being part of their class.  OPT records, whose
+// class is the UDP payload size, are left alone.
+type MDNS struct {
+	DNS
+}
+
+// LayerType returns LayerTypeMDNS.
+func (m *MDNS) LayerType() gopacket.LayerType { return LayerTypeMDNS }
+
+// CanDecode implements gopacket.DecodingLayer.
+func (m *MDNS) CanDecode() gopacket.LayerClass { return LayerTypeMDNS }
+
+// DecodeFromBytes decodes the slice into the MDNS struct.
+func (m *MDNS) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if err := m.DNS.DecodeFromBytes(data, df); err != nil {
+		return err
+	}
+	for i := range m.Questions {
+		q := &m.Questions[i]
+		q.UnicastResponse = q.Class&0x8000 != 0
+		q.Class &= 0x7fff
+	}
+	for _, records := range [][]DNSResourceRecord{m.Answers, m.Authorities, m.Additionals} {
+		for i := range records {
+			if rr := &records[i]; rr.Type != DNSTypeOPT {
+				rr.CacheFlush = rr.Class&0x8000 != 0
+				rr.Class &= 0x7fff
+			}
+		}
+	}
+	return nil
+}
+
+func decodeMDNS(data []byte, p gopacket.PacketBuilder) error {
+	m := &MDNS{}
+	if err := m.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(m)
+	p.SetApplicationLayer(m)
+	return nil
+}
+
+// LLMNR is a Link-Local Multicast Name Resolution message, see RFC 4795.
+// LLMNR uses the DNS message format, except for some flags of its header:
+// the Conflict and Tentative flags take the places of DNS's AA and RD flags,
+// which are always false.
+type LLMNR struct {
+	DNS
+	Conflict  bool // C, the responder's name isn't unique
+	Tentative bool // T, the responder's name isn't confirmed yet
+}
+
+// LayerType returns LayerTypeLLMNR.
+func (l *LLMNR) LayerType() gopacket.LayerType { return LayerTypeLLMNR }
+
+// CanDecode implements gopacket.DecodingLayer.
+func (l *LLMNR) CanDecode() gopacket.LayerClass { return LayerTypeLLMNR }
+
+// DecodeFromBytes decodes the slice into the LLMNR struct.
+func (l *LLMNR) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if err := l.DNS.DecodeFromBytes(data, df); err != nil {
+		return err
+	}
+	l.Conflict, l.Tentative = l.AA, l.RD
+	l.AA, l.RD = false, false
+	return nil
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+func (l *LLMNR) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	if err := l.DNS.SerializeTo(b, opts); err != nil {
+		return err
+	}
+	bytes := b.Bytes()
+	bytes[2] = bytes[2]&^0x05 | byte(b2i(l.Conflict)<<2) | byte(b2i(l.Tentative))
+	return nil
+}
+
+func decodeLLMNR(data []byte, p gopacket.PacketBuilder) error {
+	l := &LLMNR{}
+	if err := l.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(l)
+	p.SetApplicationLayer(l)
+	return nil
+}
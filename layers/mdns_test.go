@@ -0,0 +1,103 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+// testMDNSResponse is an mDNS response to a QU question, with a cache flush
+// A record and an OPT record advertising a 1440 bytes payload.
+var testMDNSResponse = []byte{
+	0x00, 0x00, 0x84, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01,
+	// host.local IN A, QU
+	0x04, 'h', 'o', 's', 't', 0x05, 'l', 'o', 'c', 'a', 'l', 0x00,
+	0x00, 0x01, 0x80, 0x01,
+	// host.local IN A 192.168.1.10, cache flush
+	0xc0, 0x0c, 0x00, 0x01, 0x80, 0x01, 0x00, 0x00, 0x00, 0x78,
+	0x00, 0x04, 0xc0, 0xa8, 0x01, 0x0a,
+	// OPT
+	0x00, 0x00, 0x29, 0x05, 0xa0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+}
+
+func TestMDNS(t *testing.T) {
+	p := gopacket.NewPacket(testMDNSResponse, LayerTypeMDNS, testDecodeOptions)
+	if p.ErrorLayer() != nil {
+		t.Fatal("Failed to decode packet:", p.ErrorLayer().Error())
+	}
+	checkLayers(p, []gopacket.LayerType{LayerTypeMDNS}, t)
+	m := p.Layer(LayerTypeMDNS).(*MDNS)
+	if q := m.Questions[0]; !q.UnicastResponse || q.Class != DNSClassIN {
+		t.Errorf("got question %+v", q)
+	}
+	if rr := m.Answers[0]; !rr.CacheFlush || rr.Class != DNSClassIN || !rr.IP.Equal(net.IP{192, 168, 1, 10}) {
+		t.Errorf("got answer %+v", rr)
+	}
+	if rr := m.Additionals[0]; rr.Type != DNSTypeOPT || rr.CacheFlush || rr.Class != 1440 {
+		t.Errorf("got additional %+v", rr)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, m); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), testMDNSResponse) {
+		t.Errorf("got %x, want %x", buf.Bytes(), testMDNSResponse)
+	}
+}
+
+func TestMDNSPort(t *testing.T) {
+	ip := &IPv4{Version: 4, TTL: 255, Protocol: IPProtocolUDP, SrcIP: net.IP{192, 168, 1, 10}, DstIP: net.IP{224, 0, 0, 251}}
+	udp := &UDP{SrcPort: 5353, DstPort: 5353}
+	udp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip, udp, gopacket.Payload(testMDNSResponse)); err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), LayerTypeIPv4, testDecodeOptions)
+	checkLayers(p, []gopacket.LayerType{LayerTypeIPv4, LayerTypeUDP, LayerTypeMDNS}, t)
+	if _, ok := p.ApplicationLayer().(*MDNS); !ok {
+		t.Errorf("got application layer %v", p.ApplicationLayer())
+	}
+}
+
+// testLLMNRResponse is a LLMNR response with the C and T flags.
+var testLLMNRResponse = []byte{
+	0x12, 0x34, 0x85, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+	// host IN A
+	0x04, 'h', 'o', 's', 't', 0x00, 0x00, 0x01, 0x00, 0x01,
+	// host IN A 192.168.1.10
+	0xc0, 0x0c, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x1e,
+	0x00, 0x04, 0xc0, 0xa8, 0x01, 0x0a,
+}
+
+func TestLLMNR(t *testing.T) {
+	p := gopacket.NewPacket(testLLMNRResponse, LayerTypeLLMNR, testDecodeOptions)
+	if p.ErrorLayer() != nil {
+		t.Fatal("Failed to decode packet:", p.ErrorLayer().Error())
+	}
+	l := p.Layer(LayerTypeLLMNR).(*LLMNR)
+	if !l.QR || !l.Conflict || !l.Tentative || l.AA || l.RD || l.ID != 0x1234 {
+		t.Errorf("got %+v", l)
+	}
+	if len(l.Answers) != 1 || !l.Answers[0].IP.Equal(net.IP{192, 168, 1, 10}) {
+		t.Errorf("got answers %+v", l.Answers)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, l); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), testLLMNRResponse) {
+		t.Errorf("got %x, want %x", buf.Bytes(), testLLMNRResponse)
+	}
+}
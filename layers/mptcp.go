@@ -0,0 +1,565 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// MPTCPSubtype is the subtype of a Multipath TCP option, see RFC 8684.
+type MPTCPSubtype uint8
+
+// MPTCPSubtype known values.
+const (
+	MPTCPSubtypeCapable      MPTCPSubtype = 0x0 // MP_CAPABLE
+	MPTCPSubtypeJoin         MPTCPSubtype = 0x1 // MP_JOIN
+	MPTCPSubtypeDSS          MPTCPSubtype = 0x2 // DSS, data sequence signal
+	MPTCPSubtypeAddAddr      MPTCPSubtype = 0x3 // ADD_ADDR
+	MPTCPSubtypeRemoveAddr   MPTCPSubtype = 0x4 // REMOVE_ADDR
+	MPTCPSubtypePrio         MPTCPSubtype = 0x5 // MP_PRIO
+	MPTCPSubtypeFail         MPTCPSubtype = 0x6 // MP_FAIL
+	MPTCPSubtypeFastClose    MPTCPSubtype = 0x7 // MP_FASTCLOSE
+	MPTCPSubtypeTCPRST       MPTCPSubtype = 0x8 // MP_TCPRST
+	MPTCPSubtypeExperimental MPTCPSubtype = 0xf // MP_EXPERIMENTAL
+)
+
+func (s MPTCPSubtype) String() string {
+	switch s {
+	case MPTCPSubtypeCapable:
+		return "MP_CAPABLE"
+	case MPTCPSubtypeJoin:
+		return "MP_JOIN"
+	case MPTCPSubtypeDSS:
+		return "DSS"
+	case MPTCPSubtypeAddAddr:
+		return "ADD_ADDR"
+	case MPTCPSubtypeRemoveAddr:
+		return "REMOVE_ADDR"
+	case MPTCPSubtypePrio:
+		return "MP_PRIO"
+	case MPTCPSubtypeFail:
+		return "MP_FAIL"
+	case MPTCPSubtypeFastClose:
+		return "MP_FASTCLOSE"
+	case MPTCPSubtypeTCPRST:
+		return "MP_TCPRST"
+	case MPTCPSubtypeExperimental:
+		return "MP_EXPERIMENTAL"
+	}
+	return fmt.Sprintf("Unknown(%d)", s)
+}
+
+// MPTCPOption is the content of a MPTCP option, one of *MPTCPCapable,
+// *MPTCPJoin, *MPTCPDSS, *MPTCPAddAddr, *MPTCPRemoveAddr, *MPTCPPrio,
+// *MPTCPFail, *MPTCPFastClose, *MPTCPTCPRST or, for other subtypes,
+// *MPTCPUnknown.
+type MPTCPOption interface {
+	Subtype() MPTCPSubtype
+	// decode decodes the data of the option, following its kind and
+	// length, and encode returns it.
+	decode(data []byte) error
+	encode() []byte
+}
+
+// MPTCP decodes the content of a MPTCP option.  Byte slices of the result
+// point into the option's data.
+func (t TCPOption) MPTCP() (MPTCPOption, error) {
+	if t.OptionType != TCPOptionKindMPTCP {
+		return nil, fmt.Errorf("%v option isn't a MPTCP option", t.OptionType)
+	}
+	if len(t.OptionData) < 1 {
+		return nil, errors.New("MPTCP option without subtype")
+	}
+	var o MPTCPOption
+	switch MPTCPSubtype(t.OptionData[0] >> 4) {
+	case MPTCPSubtypeCapable:
+		o = &MPTCPCapable{}
+	case MPTCPSubtypeJoin:
+		o = &MPTCPJoin{}
+	case MPTCPSubtypeDSS:
+		o = &MPTCPDSS{}
+	case MPTCPSubtypeAddAddr:
+		o = &MPTCPAddAddr{}
+	case MPTCPSubtypeRemoveAddr:
+		o = &MPTCPRemoveAddr{}
+	case MPTCPSubtypePrio:
+		o = &MPTCPPrio{}
+	case MPTCPSubtypeFail:
+		o = &MPTCPFail{}
+	case MPTCPSubtypeFastClose:
+		o = &MPTCPFastClose{}
+	case MPTCPSubtypeTCPRST:
+		o = &MPTCPTCPRST{}
+	default:
+		o = &MPTCPUnknown{}
+	}
+	if err := o.decode(t.OptionData); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// NewTCPOptionMPTCP returns a MPTCP option.
+func NewTCPOptionMPTCP(o MPTCPOption) TCPOption {
+	return newTCPOption(TCPOptionKindMPTCP, o.encode())
+}
+
+func errMPTCPLength(s MPTCPSubtype, data []byte) error {
+	return fmt.Errorf("invalid %v option length %d", s, len(data)+2)
+}
+
+// MPTCPToken returns the token and the initial data sequence number derived
+// from a key, with the hash of the MPTCP version: SHA-1 for version 0 (RFC
+// 6824) and SHA-256 for version 1.
+func MPTCPToken(version uint8, key uint64) (token uint32, idsn uint64) {
+	var k [8]byte
+	binary.BigEndian.PutUint64(k[:], key)
+	var h []byte
+	if version == 0 {
+		sum := sha1.Sum(k[:])
+		h = sum[:]
+	} else {
+		sum := sha256.Sum256(k[:])
+		h = sum[:]
+	}
+	return binary.BigEndian.Uint32(h), binary.BigEndian.Uint64(h[len(h)-8:])
+}
+
+// MPTCPCapable flags.
+const (
+	MPTCPCapableFlagChecksum        uint8 = 0x80 // A, checksums are required
+	MPTCPCapableFlagExtensibility   uint8 = 0x40 // B
+	MPTCPCapableFlagNoSourceAddress uint8 = 0x20 // C, don't join the source address
+	MPTCPCapableFlagHMACSHA256      uint8 = 0x01 // H, the HMAC algorithm
+)
+
+// MPTCPCapable is a MP_CAPABLE option, negotiating MPTCP in the handshake
+// of the first subflow.  Its fields depend on the packet carrying it: with
+// version 1, the SYN has no key, the SYN/ACK the sender's, and the third
+// ACK both, followed by a data-level length and checksum if it carries
+// data.  With version 0, the SYN has the sender's key.
+type MPTCPCapable struct {
+	Version uint8
+	Flags   uint8 // MPTCPCapableFlag*
+
+	SenderKey, ReceiverKey uint64
+	DataLength, Checksum   uint16
+	// HasSenderKey, HasReceiverKey, HasDataLength and HasChecksum tell
+	// which fields are present.  Each requires the previous ones.
+	HasSenderKey, HasReceiverKey, HasDataLength, HasChecksum bool
+}
+
+// Subtype returns MPTCPSubtypeCapable.
+func (c *MPTCPCapable) Subtype() MPTCPSubtype { return MPTCPSubtypeCapable }
+
+func (c *MPTCPCapable) decode(data []byte) error {
+	switch len(data) {
+	case 2, 10, 18, 20, 22:
+	default:
+		return errMPTCPLength(MPTCPSubtypeCapable, data)
+	}
+	c.Version, c.Flags = data[0]&0x0f, data[1]
+	c.HasSenderKey = len(data) >= 10
+	if c.HasSenderKey {
+		c.SenderKey = binary.BigEndian.Uint64(data[2:])
+	}
+	c.HasReceiverKey = len(data) >= 18
+	if c.HasReceiverKey {
+		c.ReceiverKey = binary.BigEndian.Uint64(data[10:])
+	}
+	c.HasDataLength = len(data) >= 20
+	if c.HasDataLength {
+		c.DataLength = binary.BigEndian.Uint16(data[18:])
+	}
+	c.HasChecksum = len(data) == 22
+	if c.HasChecksum {
+		c.Checksum = binary.BigEndian.Uint16(data[20:])
+	}
+	return nil
+}
+
+func (c *MPTCPCapable) encode() []byte {
+	data := make([]byte, 2, 22)
+	data[0] = byte(MPTCPSubtypeCapable)<<4 | c.Version&0x0f
+	data[1] = c.Flags
+	if c.HasSenderKey {
+		data = appendUint64(data, c.SenderKey)
+	}
+	if c.HasReceiverKey {
+		data = appendUint64(data, c.ReceiverKey)
+	}
+	if c.HasDataLength {
+		data = appendUint16(data, c.DataLength)
+	}
+	if c.HasChecksum {
+		data = appendUint16(data, c.Checksum)
+	}
+	return data
+}
+
+// MPTCPJoin is a MP_JOIN option, adding a subflow to a connection.  Its
+// form depends on the packet carrying it: the SYN has the token of the
+// receiver and a random number, the SYN/ACK a random number and a 8 bytes
+// truncated HMAC, and the third ACK a 20 bytes HMAC.  The form is chosen
+// from the length of HMAC when encoding.
+type MPTCPJoin struct {
+	Backup        bool
+	AddressID     uint8
+	ReceiverToken uint32
+	SenderRandom  uint32
+	HMAC          []byte
+}
+
+// Subtype returns MPTCPSubtypeJoin.
+func (j *MPTCPJoin) Subtype() MPTCPSubtype { return MPTCPSubtypeJoin }
+
+func (j *MPTCPJoin) decode(data []byte) error {
+	*j = MPTCPJoin{}
+	switch len(data) {
+	case 10:
+		j.ReceiverToken = binary.BigEndian.Uint32(data[2:])
+		j.SenderRandom = binary.BigEndian.Uint32(data[6:])
+	case 14:
+		j.HMAC = data[2:10]
+		j.SenderRandom = binary.BigEndian.Uint32(data[10:])
+	case 22:
+		j.HMAC = data[2:22]
+		return nil
+	default:
+		return errMPTCPLength(MPTCPSubtypeJoin, data)
+	}
+	j.Backup = data[0]&0x01 != 0
+	j.AddressID = data[1]
+	return nil
+}
+
+func (j *MPTCPJoin) encode() []byte {
+	data := make([]byte, 2, 22)
+	data[0] = byte(MPTCPSubtypeJoin) << 4
+	switch len(j.HMAC) {
+	case 0:
+		data = appendUint32(data, j.ReceiverToken)
+		data = appendUint32(data, j.SenderRandom)
+	case 8:
+		data = append(data, j.HMAC...)
+		data = appendUint32(data, j.SenderRandom)
+	default:
+		return append(data, j.HMAC...)
+	}
+	data[0] |= byte(b2i(j.Backup))
+	data[1] = j.AddressID
+	return data
+}
+
+// MPTCPDSS is a DSS option, acknowledging connection-level data and mapping
+// the data of the subflow to the connection-level data sequence.
+type MPTCPDSS struct {
+	// DataFIN is set if the mapping ends with the end of the connection's
+	// data.
+	DataFIN bool
+
+	// DataAck is the data acknowledgment, present if HasDataAck, and on 8
+	// bytes instead of 4 if DataAck64.
+	HasDataAck, DataAck64 bool
+	DataAck               uint64
+
+	// DataSeq, SubflowSeq and DataLength are the mapping, present if
+	// HasMapping: the DataLength bytes following the subflow sequence
+	// number SubflowSeq, which is relative to the subflow's initial
+	// sequence number, start at the data sequence number DataSeq, on 8
+	// bytes instead of 4 if DataSeq64.  Checksum is the mapping's
+	// checksum, present if HasChecksum.
+	HasMapping, DataSeq64, HasChecksum bool
+	DataSeq                            uint64
+	SubflowSeq                         uint32
+	DataLength                         uint16
+	Checksum                           uint16
+}
+
+// Subtype returns MPTCPSubtypeDSS.
+func (d *MPTCPDSS) Subtype() MPTCPSubtype { return MPTCPSubtypeDSS }
+
+func (d *MPTCPDSS) decode(data []byte) error {
+	if len(data) < 2 {
+		return errMPTCPLength(MPTCPSubtypeDSS, data)
+	}
+	*d = MPTCPDSS{
+		DataFIN:    data[1]&0x10 != 0,
+		DataSeq64:  data[1]&0x08 != 0,
+		HasMapping: data[1]&0x04 != 0,
+		DataAck64:  data[1]&0x02 != 0,
+		HasDataAck: data[1]&0x01 != 0,
+	}
+	rest := data[2:]
+	if d.HasDataAck {
+		var ok bool
+		if d.DataAck, rest, ok = readSequence(rest, d.DataAck64); !ok {
+			return errMPTCPLength(MPTCPSubtypeDSS, data)
+		}
+	}
+	if d.HasMapping {
+		var ok bool
+		if d.DataSeq, rest, ok = readSequence(rest, d.DataSeq64); !ok || len(rest) != 6 && len(rest) != 8 {
+			return errMPTCPLength(MPTCPSubtypeDSS, data)
+		}
+		d.SubflowSeq = binary.BigEndian.Uint32(rest)
+		d.DataLength = binary.BigEndian.Uint16(rest[4:])
+		d.HasChecksum = len(rest) == 8
+		if d.HasChecksum {
+			d.Checksum = binary.BigEndian.Uint16(rest[6:])
+		}
+		rest = nil
+	}
+	if len(rest) != 0 {
+		return errMPTCPLength(MPTCPSubtypeDSS, data)
+	}
+	return nil
+}
+
+// readSequence reads a 4 or 8 bytes sequence number from data.
+func readSequence(data []byte, wide bool) (uint64, []byte, bool) {
+	if wide {
+		if len(data) < 8 {
+			return 0, nil, false
+		}
+		return binary.BigEndian.Uint64(data), data[8:], true
+	}
+	if len(data) < 4 {
+		return 0, nil, false
+	}
+	return uint64(binary.BigEndian.Uint32(data)), data[4:], true
+}
+
+func (d *MPTCPDSS) encode() []byte {
+	data := make([]byte, 2, 28)
+	data[0] = byte(MPTCPSubtypeDSS) << 4
+	data[1] = byte(b2i(d.DataFIN)<<4 | b2i(d.DataSeq64)<<3 | b2i(d.HasMapping)<<2 | b2i(d.DataAck64)<<1 | b2i(d.HasDataAck))
+	if d.HasDataAck {
+		data = appendSequence(data, d.DataAck, d.DataAck64)
+	}
+	if d.HasMapping {
+		data = appendSequence(data, d.DataSeq, d.DataSeq64)
+		data = appendUint32(data, d.SubflowSeq)
+		data = appendUint16(data, d.DataLength)
+		if d.HasChecksum {
+			data = appendUint16(data, d.Checksum)
+		}
+	}
+	return data
+}
+
+func appendSequence(data []byte, seq uint64, wide bool) []byte {
+	if wide {
+		return appendUint64(data, seq)
+	}
+	return appendUint32(data, uint32(seq))
+}
+
+// MPTCPAddAddr is an ADD_ADDR option, advertising an address of the sender.
+type MPTCPAddAddr struct {
+	// Echo is set when acknowledging the reception of an ADD_ADDR.
+	Echo      bool
+	AddressID uint8
+	IP        net.IP
+	// Port is the port of the address, 0 if absent.
+	Port uint16
+	// HMAC is the 8 bytes truncated HMAC of the address, absent from
+	// echoes.
+	HMAC []byte
+}
+
+// Subtype returns MPTCPSubtypeAddAddr.
+func (a *MPTCPAddAddr) Subtype() MPTCPSubtype { return MPTCPSubtypeAddAddr }
+
+func (a *MPTCPAddAddr) decode(data []byte) error {
+	ipLength := 4
+	switch len(data) - 2 {
+	case 4, 6, 12, 14:
+	case 16, 18, 24, 26:
+		ipLength = 16
+	default:
+		return errMPTCPLength(MPTCPSubtypeAddAddr, data)
+	}
+	*a = MPTCPAddAddr{
+		Echo:      data[0]&0x01 != 0,
+		AddressID: data[1],
+		IP:        net.IP(data[2 : 2+ipLength]),
+	}
+	rest := data[2+ipLength:]
+	if len(rest)%8 == 2 {
+		a.Port = binary.BigEndian.Uint16(rest)
+		rest = rest[2:]
+	}
+	if len(rest) == 8 {
+		a.HMAC = rest
+	}
+	return nil
+}
+
+func (a *MPTCPAddAddr) encode() []byte {
+	data := make([]byte, 2, 30)
+	data[0] = byte(MPTCPSubtypeAddAddr)<<4 | byte(b2i(a.Echo))
+	data[1] = a.AddressID
+	if ip := a.IP.To4(); ip != nil {
+		data = append(data, ip...)
+	} else {
+		data = append(data, a.IP.To16()...)
+	}
+	if a.Port != 0 {
+		data = appendUint16(data, a.Port)
+	}
+	return append(data, a.HMAC...)
+}
+
+// MPTCPRemoveAddr is a REMOVE_ADDR option, withdrawing addresses.
+type MPTCPRemoveAddr struct {
+	AddressIDs []uint8
+}
+
+// Subtype returns MPTCPSubtypeRemoveAddr.
+func (r *MPTCPRemoveAddr) Subtype() MPTCPSubtype { return MPTCPSubtypeRemoveAddr }
+
+func (r *MPTCPRemoveAddr) decode(data []byte) error {
+	if len(data) < 2 {
+		return errMPTCPLength(MPTCPSubtypeRemoveAddr, data)
+	}
+	r.AddressIDs = data[1:]
+	return nil
+}
+
+func (r *MPTCPRemoveAddr) encode() []byte {
+	return append([]byte{byte(MPTCPSubtypeRemoveAddr) << 4}, r.AddressIDs...)
+}
+
+// MPTCPPrio is a MP_PRIO option, changing the priority of a subflow.
+type MPTCPPrio struct {
+	Backup bool
+	// AddressID is the address of the subflow, present if HasAddressID, as
+	// in version 0 only.
+	HasAddressID bool
+	AddressID    uint8
+}
+
+// Subtype returns MPTCPSubtypePrio.
+func (p *MPTCPPrio) Subtype() MPTCPSubtype { return MPTCPSubtypePrio }
+
+func (p *MPTCPPrio) decode(data []byte) error {
+	if len(data) > 2 {
+		return errMPTCPLength(MPTCPSubtypePrio, data)
+	}
+	*p = MPTCPPrio{Backup: data[0]&0x01 != 0, HasAddressID: len(data) == 2}
+	if p.HasAddressID {
+		p.AddressID = data[1]
+	}
+	return nil
+}
+
+func (p *MPTCPPrio) encode() []byte {
+	data := []byte{byte(MPTCPSubtypePrio)<<4 | byte(b2i(p.Backup))}
+	if p.HasAddressID {
+		data = append(data, p.AddressID)
+	}
+	return data
+}
+
+// MPTCPFail is a MP_FAIL option, reporting a checksum failure.
+type MPTCPFail struct {
+	DataSeq uint64
+}
+
+// Subtype returns MPTCPSubtypeFail.
+func (f *MPTCPFail) Subtype() MPTCPSubtype { return MPTCPSubtypeFail }
+
+func (f *MPTCPFail) decode(data []byte) error {
+	if len(data) != 10 {
+		return errMPTCPLength(MPTCPSubtypeFail, data)
+	}
+	f.DataSeq = binary.BigEndian.Uint64(data[2:])
+	return nil
+}
+
+func (f *MPTCPFail) encode() []byte {
+	return appendUint64([]byte{byte(MPTCPSubtypeFail) << 4, 0}, f.DataSeq)
+}
+
+// MPTCPFastClose is a MP_FASTCLOSE option, abruptly closing a connection.
+type MPTCPFastClose struct {
+	ReceiverKey uint64
+}
+
+// Subtype returns MPTCPSubtypeFastClose.
+func (f *MPTCPFastClose) Subtype() MPTCPSubtype { return MPTCPSubtypeFastClose }
+
+func (f *MPTCPFastClose) decode(data []byte) error {
+	if len(data) != 10 {
+		return errMPTCPLength(MPTCPSubtypeFastClose, data)
+	}
+	f.ReceiverKey = binary.BigEndian.Uint64(data[2:])
+	return nil
+}
+
+func (f *MPTCPFastClose) encode() []byte {
+	return appendUint64([]byte{byte(MPTCPSubtypeFastClose) << 4, 0}, f.ReceiverKey)
+}
+
+// MPTCPTCPRSTFlagTransient is the T flag of MPTCPTCPRST, telling the error
+// is transient.
+const MPTCPTCPRSTFlagTransient uint8 = 0x01
+
+// MPTCPTCPRST is a MP_TCPRST option, telling why a subflow was reset.
+type MPTCPTCPRST struct {
+	Flags  uint8 // U, V, W and T
+	Reason uint8
+}
+
+// Subtype returns MPTCPSubtypeTCPRST.
+func (r *MPTCPTCPRST) Subtype() MPTCPSubtype { return MPTCPSubtypeTCPRST }
+
+func (r *MPTCPTCPRST) decode(data []byte) error {
+	if len(data) != 2 {
+		return errMPTCPLength(MPTCPSubtypeTCPRST, data)
+	}
+	r.Flags, r.Reason = data[0]&0x0f, data[1]
+	return nil
+}
+
+func (r *MPTCPTCPRST) encode() []byte {
+	return []byte{byte(MPTCPSubtypeTCPRST)<<4 | r.Flags&0x0f, r.Reason}
+}
+
+// MPTCPUnknown is a MPTCP option of an unknown or experimental subtype.
+type MPTCPUnknown struct {
+	// Data is the data of the option, whose first 4 bits are the subtype.
+	Data []byte
+}
+
+// Subtype returns the subtype of the option.
+func (u *MPTCPUnknown) Subtype() MPTCPSubtype { return MPTCPSubtype(u.Data[0] >> 4) }
+
+func (u *MPTCPUnknown) decode(data []byte) error {
+	u.Data = data
+	return nil
+}
+
+func (u *MPTCPUnknown) encode() []byte { return u.Data }
+
+func appendUint16(data []byte, v uint16) []byte {
+	return append(data, byte(v>>8), byte(v))
+}
+
+func appendUint32(data []byte, v uint32) []byte {
+	return append(data, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(data []byte, v uint64) []byte {
+	return appendUint32(appendUint32(data, uint32(v>>32)), uint32(v))
+}
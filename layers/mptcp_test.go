@@ -0,0 +1,129 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestMPTCPOptions(t *testing.T) {
+	key1 := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+	key2 := []byte{0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18}
+	hmac := []byte{0xa0, 0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7}
+	hmac20 := bytes.Repeat([]byte{0xb0}, 20)
+	cat := func(parts ...[]byte) (data []byte) {
+		for _, p := range parts {
+			data = append(data, p...)
+		}
+		return
+	}
+	for _, tc := range []struct {
+		data []byte
+		want MPTCPOption
+	}{
+		{
+			[]byte{0x1e, 0x04, 0x01, 0x81},
+			&MPTCPCapable{Version: 1, Flags: MPTCPCapableFlagChecksum | MPTCPCapableFlagHMACSHA256},
+		},
+		{
+			cat([]byte{0x1e, 0x16, 0x01, 0x01}, key1, key2, []byte{0x00, 0x0a}),
+			&MPTCPCapable{
+				Version: 1, Flags: MPTCPCapableFlagHMACSHA256,
+				SenderKey: 0x0102030405060708, ReceiverKey: 0x1112131415161718, DataLength: 10,
+				HasSenderKey: true, HasReceiverKey: true, HasDataLength: true,
+			},
+		},
+		{
+			[]byte{0x1e, 0x0c, 0x11, 0x02, 0xde, 0xad, 0xbe, 0xef, 0x00, 0x00, 0x00, 0x2a},
+			&MPTCPJoin{Backup: true, AddressID: 2, ReceiverToken: 0xdeadbeef, SenderRandom: 42},
+		},
+		{
+			cat([]byte{0x1e, 0x10, 0x10, 0x02}, hmac, []byte{0x00, 0x00, 0x00, 0x2b}),
+			&MPTCPJoin{AddressID: 2, HMAC: hmac, SenderRandom: 43},
+		},
+		{
+			cat([]byte{0x1e, 0x18, 0x10, 0x00}, hmac20),
+			&MPTCPJoin{HMAC: hmac20},
+		},
+		{
+			[]byte{0x1e, 0x14, 0x20, 0x05,
+				0x00, 0x00, 0x10, 0x00, // data ACK
+				0x00, 0x00, 0x20, 0x00, // data sequence number
+				0x00, 0x00, 0x00, 0x01, // subflow sequence number
+				0x05, 0xb4, 0x12, 0x34},
+			&MPTCPDSS{
+				HasDataAck: true, DataAck: 0x1000,
+				HasMapping: true, HasChecksum: true, DataSeq: 0x2000, SubflowSeq: 1, DataLength: 1460, Checksum: 0x1234,
+			},
+		},
+		{
+			[]byte{0x1e, 0x0c, 0x20, 0x13, 0, 0, 0, 0, 0, 0, 0x30, 0x00},
+			&MPTCPDSS{DataFIN: true, HasDataAck: true, DataAck64: true, DataAck: 0x3000},
+		},
+		{
+			cat([]byte{0x1e, 0x12, 0x30, 0x05, 192, 0, 2, 1, 0x1f, 0x90}, hmac),
+			&MPTCPAddAddr{AddressID: 5, IP: net.IP{192, 0, 2, 1}, Port: 8080, HMAC: hmac},
+		},
+		{
+			cat([]byte{0x1e, 0x14, 0x31, 0x06}, net.ParseIP("2001:db8::1")),
+			&MPTCPAddAddr{Echo: true, AddressID: 6, IP: net.ParseIP("2001:db8::1")},
+		},
+		{
+			[]byte{0x1e, 0x05, 0x40, 0x05, 0x06},
+			&MPTCPRemoveAddr{AddressIDs: []uint8{5, 6}},
+		},
+		{
+			[]byte{0x1e, 0x03, 0x51},
+			&MPTCPPrio{Backup: true},
+		},
+		{
+			cat([]byte{0x1e, 0x0c, 0x60, 0x00}, key1),
+			&MPTCPFail{DataSeq: 0x0102030405060708},
+		},
+		{
+			cat([]byte{0x1e, 0x0c, 0x70, 0x00}, key2),
+			&MPTCPFastClose{ReceiverKey: 0x1112131415161718},
+		},
+		{
+			[]byte{0x1e, 0x04, 0x81, 0x03},
+			&MPTCPTCPRST{Flags: MPTCPTCPRSTFlagTransient, Reason: 3},
+		},
+		{
+			[]byte{0x1e, 0x04, 0xf0, 0x01},
+			&MPTCPUnknown{Data: []byte{0xf0, 0x01}},
+		},
+	} {
+		opt := TCPOption{OptionType: TCPOptionKind(tc.data[0]), OptionLength: tc.data[1], OptionData: tc.data[2:]}
+		got, err := opt.MPTCP()
+		if err != nil {
+			t.Errorf("%x: %v", tc.data, err)
+			continue
+		}
+		if got.Subtype() != tc.want.Subtype() || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%x: got %+v, want %+v", tc.data, got, tc.want)
+		}
+		if enc := NewTCPOptionMPTCP(tc.want); enc.OptionLength != tc.data[1] || !bytes.Equal(enc.OptionData, tc.data[2:]) {
+			t.Errorf("%x: encoded %d %x", tc.data, enc.OptionLength, enc.OptionData)
+		}
+	}
+
+	for _, data := range [][]byte{
+		{0x1e, 0x05, 0x01, 0x81, 0x00},
+		{0x1e, 0x08, 0x10, 0x02, 0, 0, 0, 0},
+		{0x1e, 0x0a, 0x20, 0x05, 0, 0, 0x10, 0x00, 0, 0},
+		{0x1e, 0x06, 0x30, 0x05, 192, 0},
+		{0x1e, 0x05, 0x60, 0x00, 0x00},
+	} {
+		opt := TCPOption{OptionType: TCPOptionKindMPTCP, OptionLength: data[1], OptionData: data[2:]}
+		if o, err := opt.MPTCP(); err == nil {
+			t.Errorf("%x: decoded invalid option as %+v", data, o)
+		}
+	}
+}
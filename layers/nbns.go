@@ -0,0 +1,731 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/google/gopacket"
+)
+
+// This file implements the NetBIOS over TCP/IP name service (NBNS, UDP port
+// 137) and datagram service (NBDS, UDP port 138), see RFC 1001 and 1002.
+
+// NetBIOSName is a NetBIOS name, with its scope, see RFC 1001, section 14.
+// On the wire, the 16 bytes of the name are encoded as 32 letters, making
+// the first label of a DNS name whose other labels are the scope.
+type NetBIOSName struct {
+	// Name is the name, without the spaces padding it to 15 bytes.  The
+	// wildcard name "*" is padded with zeros instead.
+	Name string
+	// Suffix is the 16th byte of the name, telling the service it names,
+	// such as 0x00 for workstations, 0x20 for file servers or 0x1c for
+	// domain controllers.
+	Suffix uint8
+	// Scope is the NetBIOS scope of the name, usually empty.
+	Scope string
+}
+
+func (n NetBIOSName) String() string {
+	s := fmt.Sprintf("%s<%02x>", n.Name, n.Suffix)
+	if n.Scope != "" {
+		s += "." + n.Scope
+	}
+	return s
+}
+
+var errNetBIOSName = errors.New("invalid encoded NetBIOS name")
+
+// decodeNetBIOSName decodes the name at offset of data, which may be
+// compressed like DNS names, returning the offset following it.
+func decodeNetBIOSName(data []byte, offset int, buffer *[]byte) (NetBIOSName, int, error) {
+	name, end, err := decodeName(data, offset, buffer, 1)
+	if err != nil {
+		return NetBIOSName{}, 0, err
+	}
+	var n NetBIOSName
+	label := name
+	if i := strings.IndexByte(string(name), '.'); i >= 0 {
+		label, n.Scope = name[:i], string(name[i+1:])
+	}
+	if len(label) != 32 {
+		return NetBIOSName{}, 0, errNetBIOSName
+	}
+	var raw [16]byte
+	for i := range raw {
+		hi, lo := label[2*i]-'A', label[2*i+1]-'A'
+		if hi > 15 || lo > 15 {
+			return NetBIOSName{}, 0, errNetBIOSName
+		}
+		raw[i] = hi<<4 | lo
+	}
+	n.Name = strings.TrimRight(string(raw[:15]), " \x00")
+	n.Suffix = raw[15]
+	return n, end, nil
+}
+
+// encodedSize returns the size of the encoded name.
+func (n NetBIOSName) encodedSize() int {
+	if n.Scope == "" {
+		return 34
+	}
+	return 35 + len(n.Scope)
+}
+
+// encode encodes the name at the start of data, returning its size.
+func (n NetBIOSName) encode(data []byte) (int, error) {
+	if len(n.Name) > 15 {
+		return 0, fmt.Errorf("NetBIOS name %q longer than 15 bytes", n.Name)
+	}
+	pad := byte(' ')
+	if n.Name == "*" {
+		pad = 0
+	}
+	var raw [16]byte
+	for i := range raw[:15] {
+		raw[i] = pad
+	}
+	copy(raw[:], n.Name)
+	raw[15] = n.Suffix
+	data[0] = 32
+	for i, b := range raw {
+		data[1+2*i] = 'A' + b>>4
+		data[2+2*i] = 'A' + b&0xf
+	}
+	if n.Scope == "" {
+		data[33] = 0
+		return 34, nil
+	}
+	return encodeName([]byte(n.Scope), data, 33), nil
+}
+
+// NBNSOpCode is the operation of a NBNS message.
+type NBNSOpCode uint8
+
+// NBNSOpCode known values.
+const (
+	NBNSOpCodeQuery        NBNSOpCode = 0
+	NBNSOpCodeRegistration NBNSOpCode = 5
+	NBNSOpCodeRelease      NBNSOpCode = 6
+	NBNSOpCodeWACK         NBNSOpCode = 7
+	NBNSOpCodeRefresh      NBNSOpCode = 8
+	NBNSOpCodeRefreshAlt   NBNSOpCode = 9
+	NBNSOpCodeMultiHomed   NBNSOpCode = 15
+)
+
+func (op NBNSOpCode) String() string {
+	switch op {
+	case NBNSOpCodeQuery:
+		return "Query"
+	case NBNSOpCodeRegistration:
+		return "Registration"
+	case NBNSOpCodeRelease:
+		return "Release"
+	case NBNSOpCodeWACK:
+		return "WACK"
+	case NBNSOpCodeRefresh, NBNSOpCodeRefreshAlt:
+		return "Refresh"
+	case NBNSOpCodeMultiHomed:
+		return "Multi-Homed Registration"
+	}
+	return "Unknown"
+}
+
+// NBNSResponseCode is the result of a NBNS request.
+type NBNSResponseCode uint8
+
+// NBNSResponseCode known values.
+const (
+	NBNSResponseCodeNoError       NBNSResponseCode = 0
+	NBNSResponseCodeFormatError   NBNSResponseCode = 1
+	NBNSResponseCodeServerFailure NBNSResponseCode = 2
+	NBNSResponseCodeNameError     NBNSResponseCode = 3
+	NBNSResponseCodeNotImpl       NBNSResponseCode = 4
+	NBNSResponseCodeRefused       NBNSResponseCode = 5
+	NBNSResponseCodeActive        NBNSResponseCode = 6
+	NBNSResponseCodeConflict      NBNSResponseCode = 7
+)
+
+func (rc NBNSResponseCode) String() string {
+	switch rc {
+	case NBNSResponseCodeNoError:
+		return "No Error"
+	case NBNSResponseCodeFormatError:
+		return "Format Error"
+	case NBNSResponseCodeServerFailure:
+		return "Server Failure"
+	case NBNSResponseCodeNameError:
+		return "Name Error"
+	case NBNSResponseCodeNotImpl:
+		return "Not Implemented"
+	case NBNSResponseCodeRefused:
+		return "Refused"
+	case NBNSResponseCodeActive:
+		return "Active"
+	case NBNSResponseCodeConflict:
+		return "Conflict"
+	}
+	return "Unknown"
+}
+
+// NBNSType is the type of a NBNS question or resource record.
+type NBNSType uint16
+
+// NBNSType known values.
+const (
+	NBNSTypeA      NBNSType = 0x01
+	NBNSTypeNS     NBNSType = 0x02
+	NBNSTypeNULL   NBNSType = 0x0a
+	NBNSTypeNB     NBNSType = 0x20 // NetBIOS general name service
+	NBNSTypeNBSTAT NBNSType = 0x21 // NetBIOS node status
+)
+
+func (t NBNSType) String() string {
+	switch t {
+	case NBNSTypeA:
+		return "A"
+	case NBNSTypeNS:
+		return "NS"
+	case NBNSTypeNULL:
+		return "NULL"
+	case NBNSTypeNB:
+		return "NB"
+	case NBNSTypeNBSTAT:
+		return "NBSTAT"
+	}
+	return "Unknown"
+}
+
+// NetBIOSNodeType is the type of a NetBIOS node, telling how it resolves
+// names.
+type NetBIOSNodeType uint8
+
+// NetBIOSNodeType known values.
+const (
+	NetBIOSNodeB NetBIOSNodeType = 0 // Broadcast
+	NetBIOSNodeP NetBIOSNodeType = 1 // Point-to-point, with a name server
+	NetBIOSNodeM NetBIOSNodeType = 2 // Mixed
+	NetBIOSNodeH NetBIOSNodeType = 3 // Hybrid
+)
+
+func (t NetBIOSNodeType) String() string {
+	return [...]string{"B", "P", "M", "H"}[t&3]
+}
+
+// Flags of NBNSAddress and NBNSNodeName, which also hold the node type.
+const (
+	NBNSFlagGroup      uint16 = 0x8000 // G, the name is a group name
+	NBNSFlagDeregister uint16 = 0x1000 // DRG, the name is being deregistered
+	NBNSFlagConflict   uint16 = 0x0800 // CNF, the name is in conflict
+	NBNSFlagActive     uint16 = 0x0400 // ACT, the name is active
+	NBNSFlagPermanent  uint16 = 0x0200 // PRM, the name is the permanent node name
+
+	nbnsNodeTypeMask  uint16 = 0x6000
+	nbnsNodeTypeShift        = 13
+)
+
+const (
+	nbnsHeaderSize       = 12
+	nbnsRecordHeaderSize = 10
+	nbnsAddressSize      = 6
+	nbnsNodeNameSize     = 18
+	nbnsUnitIDSize       = 6
+)
+
+// NBNSAddress is an address of a NB resource record.
+type NBNSAddress struct {
+	Flags uint16 // NBNSFlagGroup and the node type
+	IP    net.IP
+}
+
+// Group returns whether the name is a group name.
+func (a NBNSAddress) Group() bool { return a.Flags&NBNSFlagGroup != 0 }
+
+// NodeType returns the type of the node owning the address.
+func (a NBNSAddress) NodeType() NetBIOSNodeType {
+	return NetBIOSNodeType(a.Flags & nbnsNodeTypeMask >> nbnsNodeTypeShift)
+}
+
+// NBNSNodeName is a name of a NBSTAT resource record.
+type NBNSNodeName struct {
+	// Name and Suffix are as in NetBIOSName.
+	Name   string
+	Suffix uint8
+	Flags  uint16 // NBNSFlag* and the node type
+}
+
+// NodeType returns the type of the node owning the name.
+func (n NBNSNodeName) NodeType() NetBIOSNodeType {
+	return NetBIOSNodeType(n.Flags & nbnsNodeTypeMask >> nbnsNodeTypeShift)
+}
+
+// NBNSQuestion is a question of a NBNS message.
+type NBNSQuestion struct {
+	Name  NetBIOSName
+	Type  NBNSType
+	Class DNSClass
+}
+
+// NBNSResourceRecord is a resource record of a NBNS message.
+type NBNSResourceRecord struct {
+	Name  NetBIOSName
+	Type  NBNSType
+	Class DNSClass
+	TTL   uint32
+
+	// Data is the raw RDATA.  Records of other types than NB and NBSTAT
+	// are encoded from it.
+	Data []byte
+
+	// Addresses are the addresses of NB records.
+	Addresses []NBNSAddress
+	// NodeNames, UnitID and Statistics are the names of the node, its MAC
+	// address, and the statistics following it, of NBSTAT records.
+	NodeNames  []NBNSNodeName
+	UnitID     net.HardwareAddr
+	Statistics []byte
+}
+
+// dataSize returns the size of the encoded RDATA.
+func (rr *NBNSResourceRecord) dataSize() int {
+	switch rr.Type {
+	case NBNSTypeNB:
+		return nbnsAddressSize * len(rr.Addresses)
+	case NBNSTypeNBSTAT:
+		return 1 + nbnsNodeNameSize*len(rr.NodeNames) + nbnsUnitIDSize + len(rr.Statistics)
+	}
+	return len(rr.Data)
+}
+
+func (rr *NBNSResourceRecord) decodeData() error {
+	data := rr.Data
+	switch rr.Type {
+	case NBNSTypeNB:
+		// Negative responses have no address.
+		if len(data)%nbnsAddressSize != 0 {
+			return fmt.Errorf("NBNS NB record of length %d", len(data))
+		}
+		rr.Addresses = make([]NBNSAddress, len(data)/nbnsAddressSize)
+		for i := range rr.Addresses {
+			a := data[i*nbnsAddressSize:]
+			rr.Addresses[i] = NBNSAddress{Flags: binary.BigEndian.Uint16(a), IP: net.IP(a[2:6])}
+		}
+	case NBNSTypeNBSTAT:
+		if len(data) < 1 || len(data) < 1+nbnsNodeNameSize*int(data[0])+nbnsUnitIDSize {
+			return fmt.Errorf("NBNS NBSTAT record of length %d", len(data))
+		}
+		rr.NodeNames = make([]NBNSNodeName, data[0])
+		for i := range rr.NodeNames {
+			n := data[1+i*nbnsNodeNameSize:]
+			rr.NodeNames[i] = NBNSNodeName{
+				Name:   strings.TrimRight(string(n[:15]), " \x00"),
+				Suffix: n[15],
+				Flags:  binary.BigEndian.Uint16(n[16:]),
+			}
+		}
+		data = data[1+nbnsNodeNameSize*len(rr.NodeNames):]
+		rr.UnitID = net.HardwareAddr(data[:nbnsUnitIDSize])
+		rr.Statistics = data[nbnsUnitIDSize:]
+	}
+	return nil
+}
+
+func (rr *NBNSResourceRecord) encodeData(data []byte) {
+	switch rr.Type {
+	case NBNSTypeNB:
+		for i, a := range rr.Addresses {
+			binary.BigEndian.PutUint16(data[i*nbnsAddressSize:], a.Flags)
+			copy(data[i*nbnsAddressSize+2:i*nbnsAddressSize+6], a.IP.To4())
+		}
+	case NBNSTypeNBSTAT:
+		data[0] = byte(len(rr.NodeNames))
+		for i, n := range rr.NodeNames {
+			b := data[1+i*nbnsNodeNameSize:]
+			for j := range b[:15] {
+				b[j] = ' '
+			}
+			copy(b[:15], n.Name)
+			b[15] = n.Suffix
+			binary.BigEndian.PutUint16(b[16:], n.Flags)
+		}
+		data = data[1+nbnsNodeNameSize*len(rr.NodeNames):]
+		copy(data[:nbnsUnitIDSize], rr.UnitID)
+		copy(data[nbnsUnitIDSize:], rr.Statistics)
+	default:
+		copy(data, rr.Data)
+	}
+}
+
+// NBNS is a NetBIOS name service message, see RFC 1002, section 4.2.  Its
+// format is the one of DNS messages, with other opcodes, flags, names and
+// record types.
+type NBNS struct {
+	BaseLayer
+
+	ID           uint16
+	Response     bool // R
+	OpCode       NBNSOpCode
+	AA           bool // Authoritative answer
+	TC           bool // Truncated
+	RD           bool // Recursion desired
+	RA           bool // Recursion available
+	Broadcast    bool // B
+	ResponseCode NBNSResponseCode
+
+	Questions   []NBNSQuestion
+	Answers     []NBNSResourceRecord
+	Authorities []NBNSResourceRecord
+	Additionals []NBNSResourceRecord
+
+	// buffer for decoding names.
+	buffer []byte
+}
+
+// LayerType returns LayerTypeNBNS.
+func (n *NBNS) LayerType() gopacket.LayerType { return LayerTypeNBNS }
+
+// CanDecode implements gopacket.DecodingLayer.
+func (n *NBNS) CanDecode() gopacket.LayerClass { return LayerTypeNBNS }
+
+// NextLayerType implements gopacket.DecodingLayer.
+func (n *NBNS) NextLayerType() gopacket.LayerType { return gopacket.LayerTypePayload }
+
+// Payload returns nil.
+func (n *NBNS) Payload() []byte { return nil }
+
+// DecodeFromBytes decodes the slice into the NBNS struct.
+func (n *NBNS) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < nbnsHeaderSize {
+		df.SetTruncated()
+		return errors.New("NBNS packet too short")
+	}
+	n.BaseLayer = BaseLayer{Contents: data}
+	n.buffer = n.buffer[:0]
+	n.ID = binary.BigEndian.Uint16(data)
+	n.Response = data[2]&0x80 != 0
+	n.OpCode = NBNSOpCode(data[2] >> 3 & 0xf)
+	n.AA = data[2]&0x04 != 0
+	n.TC = data[2]&0x02 != 0
+	n.RD = data[2]&0x01 != 0
+	n.RA = data[3]&0x80 != 0
+	n.Broadcast = data[3]&0x10 != 0
+	n.ResponseCode = NBNSResponseCode(data[3] & 0xf)
+	qdCount := int(binary.BigEndian.Uint16(data[4:]))
+	counts := [3]int{
+		int(binary.BigEndian.Uint16(data[6:])),
+		int(binary.BigEndian.Uint16(data[8:])),
+		int(binary.BigEndian.Uint16(data[10:])),
+	}
+
+	offset := nbnsHeaderSize
+	n.Questions = n.Questions[:0]
+	for i := 0; i < qdCount; i++ {
+		var q NBNSQuestion
+		var err error
+		if q.Name, offset, err = decodeNetBIOSName(data, offset, &n.buffer); err != nil {
+			return err
+		}
+		if offset+4 > len(data) {
+			return errors.New("NBNS question truncated")
+		}
+		q.Type = NBNSType(binary.BigEndian.Uint16(data[offset:]))
+		q.Class = DNSClass(binary.BigEndian.Uint16(data[offset+2:]))
+		offset += 4
+		n.Questions = append(n.Questions, q)
+	}
+	sections := [3]*[]NBNSResourceRecord{&n.Answers, &n.Authorities, &n.Additionals}
+	for s, records := range sections {
+		*records = (*records)[:0]
+		for i := 0; i < counts[s]; i++ {
+			var rr NBNSResourceRecord
+			var err error
+			if rr.Name, offset, err = decodeNetBIOSName(data, offset, &n.buffer); err != nil {
+				return err
+			}
+			if offset+nbnsRecordHeaderSize > len(data) {
+				return errors.New("NBNS resource record truncated")
+			}
+			rr.Type = NBNSType(binary.BigEndian.Uint16(data[offset:]))
+			rr.Class = DNSClass(binary.BigEndian.Uint16(data[offset+2:]))
+			rr.TTL = binary.BigEndian.Uint32(data[offset+4:])
+			end := offset + nbnsRecordHeaderSize + int(binary.BigEndian.Uint16(data[offset+8:]))
+			if end > len(data) {
+				return errors.New("NBNS resource record data truncated")
+			}
+			rr.Data = data[offset+nbnsRecordHeaderSize : end]
+			if err := rr.decodeData(); err != nil {
+				return err
+			}
+			offset = end
+			*records = append(*records, rr)
+		}
+	}
+	return nil
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.  Names
+// aren't compressed, and counts are always those of the entries.
+func (n *NBNS) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	size := nbnsHeaderSize
+	for _, q := range n.Questions {
+		size += q.Name.encodedSize() + 4
+	}
+	sections := [3][]NBNSResourceRecord{n.Answers, n.Authorities, n.Additionals}
+	for _, records := range sections {
+		for i := range records {
+			rr := &records[i]
+			size += rr.Name.encodedSize() + nbnsRecordHeaderSize + rr.dataSize()
+		}
+	}
+	data, err := b.PrependBytes(size)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint16(data, n.ID)
+	data[2] = byte(b2i(n.Response)<<7 | int(n.OpCode&0xf)<<3 | b2i(n.AA)<<2 | b2i(n.TC)<<1 | b2i(n.RD))
+	data[3] = byte(b2i(n.RA)<<7 | b2i(n.Broadcast)<<4 | int(n.ResponseCode&0xf))
+	binary.BigEndian.PutUint16(data[4:], uint16(len(n.Questions)))
+	for s, records := range sections {
+		binary.BigEndian.PutUint16(data[6+2*s:], uint16(len(records)))
+	}
+	offset := nbnsHeaderSize
+	for _, q := range n.Questions {
+		l, err := q.Name.encode(data[offset:])
+		if err != nil {
+			return err
+		}
+		offset += l
+		binary.BigEndian.PutUint16(data[offset:], uint16(q.Type))
+		binary.BigEndian.PutUint16(data[offset+2:], uint16(q.Class))
+		offset += 4
+	}
+	for _, records := range sections {
+		for i := range records {
+			rr := &records[i]
+			l, err := rr.Name.encode(data[offset:])
+			if err != nil {
+				return err
+			}
+			offset += l
+			dataSize := rr.dataSize()
+			if dataSize > 65535 {
+				return fmt.Errorf("NBNS %v record too long", rr.Type)
+			}
+			binary.BigEndian.PutUint16(data[offset:], uint16(rr.Type))
+			binary.BigEndian.PutUint16(data[offset+2:], uint16(rr.Class))
+			binary.BigEndian.PutUint32(data[offset+4:], rr.TTL)
+			binary.BigEndian.PutUint16(data[offset+8:], uint16(dataSize))
+			offset += nbnsRecordHeaderSize
+			rr.encodeData(data[offset : offset+dataSize])
+			offset += dataSize
+		}
+	}
+	return nil
+}
+
+func decodeNBNS(data []byte, p gopacket.PacketBuilder) error {
+	n := &NBNS{}
+	if err := n.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(n)
+	p.SetApplicationLayer(n)
+	return nil
+}
+
+// NBDSMessageType is the type of a NBDS message.
+type NBDSMessageType uint8
+
+// NBDSMessageType known values.
+const (
+	NBDSMessageTypeDirectUnique     NBDSMessageType = 0x10
+	NBDSMessageTypeDirectGroup      NBDSMessageType = 0x11
+	NBDSMessageTypeBroadcast        NBDSMessageType = 0x12
+	NBDSMessageTypeError            NBDSMessageType = 0x13
+	NBDSMessageTypeQueryRequest     NBDSMessageType = 0x14
+	NBDSMessageTypePositiveResponse NBDSMessageType = 0x15
+	NBDSMessageTypeNegativeResponse NBDSMessageType = 0x16
+)
+
+func (t NBDSMessageType) String() string {
+	switch t {
+	case NBDSMessageTypeDirectUnique:
+		return "Direct Unique"
+	case NBDSMessageTypeDirectGroup:
+		return "Direct Group"
+	case NBDSMessageTypeBroadcast:
+		return "Broadcast"
+	case NBDSMessageTypeError:
+		return "Error"
+	case NBDSMessageTypeQueryRequest:
+		return "Query Request"
+	case NBDSMessageTypePositiveResponse:
+		return "Positive Query Response"
+	case NBDSMessageTypeNegativeResponse:
+		return "Negative Query Response"
+	}
+	return "Unknown"
+}
+
+// hasData returns whether messages of the type carry a datagram.
+func (t NBDSMessageType) hasData() bool {
+	return t >= NBDSMessageTypeDirectUnique && t <= NBDSMessageTypeBroadcast
+}
+
+// NBDS is a NetBIOS datagram service message, see RFC 1002, section 4.4.
+// The user data of datagrams, usually SMB mailslot messages, is the
+// payload.
+type NBDS struct {
+	BaseLayer
+
+	Type NBDSMessageType
+	// NodeType is the SNT flags, the type of the source node, or 3 for a
+	// datagram distribution server.
+	NodeType NetBIOSNodeType
+	// First and More are the F and M flags of fragmented datagrams.
+	First, More bool
+	ID          uint16
+	SourceIP    net.IP
+	SourcePort  uint16
+
+	// Length and Offset are the DGM_LENGTH and PACKET_OFFSET of datagrams.
+	Length, Offset uint16
+	// SourceName is the source name of datagrams, and DestinationName the
+	// destination name of datagrams and queries.
+	SourceName, DestinationName NetBIOSName
+	// ErrorCode is the error of error messages.
+	ErrorCode uint8
+
+	// buffer for decoding names.
+	buffer []byte
+}
+
+// LayerType returns LayerTypeNBDS.
+func (n *NBDS) LayerType() gopacket.LayerType { return LayerTypeNBDS }
+
+// CanDecode implements gopacket.DecodingLayer.
+func (n *NBDS) CanDecode() gopacket.LayerClass { return LayerTypeNBDS }
+
+// NextLayerType returns gopacket.LayerTypePayload for datagrams, and
+// gopacket.LayerTypeZero otherwise.
+func (n *NBDS) NextLayerType() gopacket.LayerType {
+	if n.Type.hasData() {
+		return gopacket.LayerTypePayload
+	}
+	return gopacket.LayerTypeZero
+}
+
+// DecodeFromBytes decodes the slice into the NBDS struct.
+func (n *NBDS) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 10 {
+		df.SetTruncated()
+		return errors.New("NBDS packet too short")
+	}
+	n.buffer = n.buffer[:0]
+	n.Type = NBDSMessageType(data[0])
+	n.NodeType = NetBIOSNodeType(data[1] >> 2 & 3)
+	n.First = data[1]&0x02 != 0
+	n.More = data[1]&0x01 != 0
+	n.ID = binary.BigEndian.Uint16(data[2:])
+	n.SourceIP = net.IP(data[4:8])
+	n.SourcePort = binary.BigEndian.Uint16(data[8:])
+	n.Length, n.Offset, n.ErrorCode = 0, 0, 0
+	n.SourceName, n.DestinationName = NetBIOSName{}, NetBIOSName{}
+	offset := 10
+	var err error
+	switch {
+	case n.Type.hasData():
+		if len(data) < 14 {
+			df.SetTruncated()
+			return errors.New("NBDS datagram too short")
+		}
+		n.Length = binary.BigEndian.Uint16(data[10:])
+		n.Offset = binary.BigEndian.Uint16(data[12:])
+		if n.SourceName, offset, err = decodeNetBIOSName(data, 14, &n.buffer); err != nil {
+			return err
+		}
+		if n.DestinationName, offset, err = decodeNetBIOSName(data, offset, &n.buffer); err != nil {
+			return err
+		}
+	case n.Type == NBDSMessageTypeError:
+		if len(data) < 11 {
+			df.SetTruncated()
+			return errors.New("NBDS error too short")
+		}
+		n.ErrorCode = data[10]
+		offset = 11
+	case n.Type >= NBDSMessageTypeQueryRequest && n.Type <= NBDSMessageTypeNegativeResponse:
+		if n.DestinationName, offset, err = decodeNetBIOSName(data, 10, &n.buffer); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown NBDS message type %#x", uint8(n.Type))
+	}
+	n.BaseLayer = BaseLayer{Contents: data[:offset], Payload: data[offset:]}
+	return nil
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.  With
+// FixLengths, Length is set to the length of the names and the payload.
+func (n *NBDS) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	size := 10
+	switch {
+	case n.Type.hasData():
+		size += 4 + n.SourceName.encodedSize() + n.DestinationName.encodedSize()
+	case n.Type == NBDSMessageTypeError:
+		size++
+	default:
+		size += n.DestinationName.encodedSize()
+	}
+	payload := len(b.Bytes())
+	data, err := b.PrependBytes(size)
+	if err != nil {
+		return err
+	}
+	data[0] = byte(n.Type)
+	data[1] = byte(n.NodeType&3)<<2 | byte(b2i(n.First)<<1) | byte(b2i(n.More))
+	binary.BigEndian.PutUint16(data[2:], n.ID)
+	copy(data[4:8], n.SourceIP.To4())
+	binary.BigEndian.PutUint16(data[8:], n.SourcePort)
+	switch {
+	case n.Type.hasData():
+		if opts.FixLengths {
+			n.Length = uint16(size - 14 + payload)
+		}
+		binary.BigEndian.PutUint16(data[10:], n.Length)
+		binary.BigEndian.PutUint16(data[12:], n.Offset)
+		l, err := n.SourceName.encode(data[14:])
+		if err != nil {
+			return err
+		}
+		_, err = n.DestinationName.encode(data[14+l:])
+		return err
+	case n.Type == NBDSMessageTypeError:
+		data[10] = n.ErrorCode
+	default:
+		_, err = n.DestinationName.encode(data[10:])
+	}
+	return err
+}
+
+func decodeNBDS(data []byte, p gopacket.PacketBuilder) error {
+	n := &NBDS{}
+	if err := n.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(n)
+	if !n.Type.hasData() {
+		return nil
+	}
+	return p.NextDecoder(gopacket.LayerTypePayload)
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+// testNBNSQuery is a broadcast query for the master browser of WORKGROUP.
+var testNBNSQuery = []byte{
+	0x82, 0x28, 0x01, 0x10, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x20, 'F', 'H', 'E', 'P', 'F', 'C', 'E', 'L', 'E', 'H', 'F', 'C', 'E', 'P', 'F', 'F',
+	'F', 'A', 'C', 'A', 'C', 'A', 'C', 'A', 'C', 'A', 'C', 'A', 'C', 'A', 'B', 'N', 0x00,
+	0x00, 0x20, 0x00, 0x01,
+}
+
+func TestNBNSQuery(t *testing.T) {
+	ip := &IPv4{Version: 4, TTL: 64, Protocol: IPProtocolUDP, SrcIP: net.IP{192, 168, 1, 10}, DstIP: net.IP{192, 168, 1, 255}}
+	udp := &UDP{SrcPort: 137, DstPort: 137}
+	udp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip, udp, gopacket.Payload(testNBNSQuery)); err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), LayerTypeIPv4, testDecodeOptions)
+	if p.ErrorLayer() != nil {
+		t.Fatal("Failed to decode packet:", p.ErrorLayer().Error())
+	}
+	checkLayers(p, []gopacket.LayerType{LayerTypeIPv4, LayerTypeUDP, LayerTypeNBNS}, t)
+	n := p.Layer(LayerTypeNBNS).(*NBNS)
+	if n.ID != 0x8228 || n.Response || n.OpCode != NBNSOpCodeQuery || !n.RD || !n.Broadcast {
+		t.Errorf("got %+v", n)
+	}
+	want := NBNSQuestion{Name: NetBIOSName{Name: "WORKGROUP", Suffix: 0x1d}, Type: NBNSTypeNB, Class: DNSClassIN}
+	if len(n.Questions) != 1 || n.Questions[0] != want {
+		t.Errorf("got questions %+v", n.Questions)
+	}
+	if s := n.Questions[0].Name.String(); s != "WORKGROUP<1d>" {
+		t.Errorf("got name %q", s)
+	}
+
+	buf = gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, n); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), testNBNSQuery) {
+		t.Errorf("got %x, want %x", buf.Bytes(), testNBNSQuery)
+	}
+}
+
+func TestNBNSResponses(t *testing.T) {
+	for _, n := range []*NBNS{
+		{
+			ID: 0x8228, Response: true, AA: true, RD: true,
+			Answers: []NBNSResourceRecord{{
+				Name: NetBIOSName{Name: "FRED", Suffix: 0x20, Scope: "corp.example"},
+				Type: NBNSTypeNB, Class: DNSClassIN, TTL: 300000,
+				Addresses: []NBNSAddress{
+					{Flags: uint16(NetBIOSNodeH) << 13, IP: net.IP{192, 168, 1, 20}},
+					{Flags: NBNSFlagGroup, IP: net.IP{192, 168, 1, 21}},
+				},
+			}},
+		},
+		{
+			ID: 1, Response: true, AA: true,
+			Answers: []NBNSResourceRecord{{
+				Name: NetBIOSName{Name: "*"},
+				Type: NBNSTypeNBSTAT, Class: DNSClassIN,
+				NodeNames: []NBNSNodeName{
+					{Name: "FRED", Suffix: 0x00, Flags: NBNSFlagActive},
+					{Name: "WORKGROUP", Suffix: 0x00, Flags: NBNSFlagActive | NBNSFlagGroup},
+				},
+				UnitID:     net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+				Statistics: make([]byte, 46),
+			}},
+		},
+	} {
+		buf := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, n); err != nil {
+			t.Fatal(err)
+		}
+		p := gopacket.NewPacket(buf.Bytes(), LayerTypeNBNS, testDecodeOptions)
+		if p.ErrorLayer() != nil {
+			t.Fatal("Failed to decode packet:", p.ErrorLayer().Error())
+		}
+		got := p.Layer(LayerTypeNBNS).(*NBNS)
+		for i := range got.Answers {
+			got.Answers[i].Data = nil
+		}
+		if !reflect.DeepEqual(got.Answers, n.Answers) || got.ID != n.ID || !got.Response || !got.AA {
+			t.Errorf("got %+v, want %+v", got, n)
+		}
+	}
+
+	// FRED<20> and its scope are encoded as in RFC 1001, section 14.1.
+	buf := gopacket.NewSerializeBuffer()
+	n := &NBNS{Questions: []NBNSQuestion{{Name: NetBIOSName{Name: "FRED", Suffix: 0x20, Scope: "NETBIOS.COM"}, Type: NBNSTypeNB, Class: DNSClassIN}}}
+	if err := n.SerializeTo(buf, gopacket.SerializeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	want := "\x20EGFCEFEECACACACACACACACACACACACA\x07NETBIOS\x03COM\x00"
+	if got := string(buf.Bytes()[12 : 12+len(want)]); got != want {
+		t.Errorf("got encoded name %q, want %q", got, want)
+	}
+}
+
+func TestNBDS(t *testing.T) {
+	ip := &IPv4{Version: 4, TTL: 64, Protocol: IPProtocolUDP, SrcIP: net.IP{192, 168, 1, 20}, DstIP: net.IP{192, 168, 1, 255}}
+	udp := &UDP{SrcPort: 138, DstPort: 138}
+	udp.SetNetworkLayerForChecksum(ip)
+	nbds := &NBDS{
+		Type:            NBDSMessageTypeDirectGroup,
+		NodeType:        NetBIOSNodeB,
+		First:           true,
+		ID:              0x8001,
+		SourceIP:        net.IP{192, 168, 1, 20},
+		SourcePort:      138,
+		SourceName:      NetBIOSName{Name: "FRED", Suffix: 0x00},
+		DestinationName: NetBIOSName{Name: "WORKGROUP", Suffix: 0x1e},
+	}
+	payload := gopacket.Payload("\xffSMB%")
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip, udp, nbds, payload); err != nil {
+		t.Fatal(err)
+	}
+	if nbds.Length != 68+5 {
+		t.Errorf("got length %d", nbds.Length)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), LayerTypeIPv4, testDecodeOptions)
+	if p.ErrorLayer() != nil {
+		t.Fatal("Failed to decode packet:", p.ErrorLayer().Error())
+	}
+	checkLayers(p, []gopacket.LayerType{LayerTypeIPv4, LayerTypeUDP, LayerTypeNBDS, gopacket.LayerTypePayload}, t)
+	got := p.Layer(LayerTypeNBDS).(*NBDS)
+	if got.Type != nbds.Type || !got.First || got.More || got.ID != nbds.ID || !got.SourceIP.Equal(nbds.SourceIP) ||
+		got.Length != nbds.Length || got.SourceName != nbds.SourceName || got.DestinationName != nbds.DestinationName {
+		t.Errorf("got %+v, want %+v", got, nbds)
+	}
+	if a := p.ApplicationLayer(); a == nil || !bytes.Equal(a.Payload(), payload) {
+		t.Errorf("got application layer %v", a)
+	}
+}
@@ -0,0 +1,782 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// This file implements NetFlow v5 and v9 (RFC 3954) export packets, and what
+// they share with IPFIX (see ipfix.go): templates, data sets and records.
+//
+// NetFlow v9 and IPFIX data sets can only be decoded with the template they
+// refer to, which is usually sent in an earlier packet.  Templates are cached
+// per exporter and observation domain in a NetFlowTemplateCache, which evicts
+// the least recently used templates when full: NetFlowTemplates is used
+// automatically when decoding with
+// gopacket.NewPacket, and other caches can be used with the
+// DecodeWithTemplates methods (for example with a DecodingLayerParser).
+
+// NetFlowField is a field specifier of a NetFlow v9 or IPFIX template.
+type NetFlowField struct {
+	// ID is the information element identifier (the field type, in NetFlow
+	// v9 parlance).
+	ID uint16
+	// Length is the length of the field in data records, or
+	// IPFIXVariableLength for variable-length IPFIX fields.
+	Length uint16
+	// EnterpriseNumber is the IANA private enterprise number of
+	// enterprise-specific IPFIX information elements, or 0.
+	EnterpriseNumber uint32
+	// V9Scope is set for the scope fields of NetFlow v9 options templates,
+	// whose IDs are NetFlowV9ScopeTypes rather than information elements.
+	V9Scope bool
+}
+
+// IPFIXVariableLength is the field length of variable-length IPFIX fields.
+const IPFIXVariableLength = 0xffff
+
+// InformationElement returns the IANA information element of the field, or
+// false if it's not an IANA information element or isn't known.
+func (f NetFlowField) InformationElement() (IPFIXInformationElement, bool) {
+	if f.EnterpriseNumber != 0 || f.V9Scope {
+		return IPFIXInformationElement{}, false
+	}
+	ie, ok := ipfixInformationElements[f.ID]
+	return ie, ok
+}
+
+// Name returns the IANA name of the field's information element, such as
+// "sourceIPv4Address", or a name made of its IDs if it isn't known.
+func (f NetFlowField) Name() string {
+	if f.V9Scope {
+		return "scope" + NetFlowV9ScopeType(f.ID).String()
+	}
+	if ie, ok := f.InformationElement(); ok {
+		return ie.Name
+	}
+	if f.EnterpriseNumber != 0 {
+		return fmt.Sprintf("%d.%d", f.EnterpriseNumber, f.ID)
+	}
+	return fmt.Sprintf("ie%d", f.ID)
+}
+
+// NetFlowV9ScopeType is the type of a NetFlow v9 options template scope
+// field.
+type NetFlowV9ScopeType uint16
+
+// NetFlow v9 scope types.
+const (
+	NetFlowV9ScopeSystem    NetFlowV9ScopeType = 1
+	NetFlowV9ScopeInterface NetFlowV9ScopeType = 2
+	NetFlowV9ScopeLineCard  NetFlowV9ScopeType = 3
+	NetFlowV9ScopeCache     NetFlowV9ScopeType = 4
+	NetFlowV9ScopeTemplate  NetFlowV9ScopeType = 5
+)
+
+func (t NetFlowV9ScopeType) String() string {
+	switch t {
+	case NetFlowV9ScopeSystem:
+		return "System"
+	case NetFlowV9ScopeInterface:
+		return "Interface"
+	case NetFlowV9ScopeLineCard:
+		return "LineCard"
+	case NetFlowV9ScopeCache:
+		return "Cache"
+	case NetFlowV9ScopeTemplate:
+		return "Template"
+	}
+	return fmt.Sprintf("Unknown(%d)", uint16(t))
+}
+
+// NetFlowTemplate is a NetFlow v9 or IPFIX (options) template.
+type NetFlowTemplate struct {
+	ID uint16
+	// ScopeFieldCount is the number of scope fields of an options template,
+	// which are the first ScopeFieldCount fields.  It's 0 for non-options
+	// templates.
+	ScopeFieldCount int
+	// Options is set for options templates.
+	Options bool
+	// Fields is nil for template withdrawals.
+	Fields []NetFlowField
+}
+
+// minRecordLength returns the minimum length of a data record.  Fields of
+// IPFIX templates may be variable-length.
+func (t *NetFlowTemplate) minRecordLength(ipfix bool) (n int) {
+	for _, f := range t.Fields {
+		if ipfix && f.Length == IPFIXVariableLength {
+			n++
+		} else {
+			n += int(f.Length)
+		}
+	}
+	return
+}
+
+// NetFlowFieldValue is a field of a data record.
+type NetFlowFieldValue struct {
+	NetFlowField
+	Data []byte
+}
+
+// Uint returns the value of an unsigned integer field.  Reduced-size
+// encodings are supported.
+func (v NetFlowFieldValue) Uint() uint64 {
+	var u uint64
+	for _, b := range v.Data {
+		u = u<<8 | uint64(b)
+	}
+	return u
+}
+
+// Value returns the value of the field, typed according to its information
+// element: uint64, int64, float64, bool, net.IP, net.HardwareAddr, string or
+// time.Time.  Unknown information elements are returned as []byte.
+func (v NetFlowFieldValue) Value() interface{} {
+	ie, ok := v.InformationElement()
+	if !ok {
+		if v.V9Scope && len(v.Data) <= 8 {
+			return v.Uint()
+		}
+		return v.Data
+	}
+	d := v.Data
+	switch ie.Type {
+	case IPFIXTypeUnsigned:
+		if len(d) <= 8 {
+			return v.Uint()
+		}
+	case IPFIXTypeSigned:
+		if len(d) > 0 && len(d) <= 8 {
+			u := v.Uint()
+			shift := uint(64 - 8*len(d))
+			return int64(u<<shift) >> shift
+		}
+	case IPFIXTypeFloat:
+		switch len(d) {
+		case 4:
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(d)))
+		case 8:
+			return math.Float64frombits(binary.BigEndian.Uint64(d))
+		}
+	case IPFIXTypeBoolean:
+		if len(d) == 1 {
+			return d[0] == 1
+		}
+	case IPFIXTypeMACAddress:
+		return net.HardwareAddr(d)
+	case IPFIXTypeIPv4Address, IPFIXTypeIPv6Address:
+		return net.IP(d)
+	case IPFIXTypeString:
+		return string(d)
+	case IPFIXTypeDateTimeSeconds:
+		if len(d) == 4 {
+			return time.Unix(int64(binary.BigEndian.Uint32(d)), 0).UTC()
+		}
+	case IPFIXTypeDateTimeMilliseconds:
+		if len(d) == 8 {
+			ms := int64(binary.BigEndian.Uint64(d))
+			return time.Unix(ms/1000, ms%1000*int64(time.Millisecond)).UTC()
+		}
+	case IPFIXTypeDateTimeMicroseconds, IPFIXTypeDateTimeNanoseconds:
+		if len(d) == 8 {
+			// NTP timestamps, with the fraction's lower 11 bits ignored for
+			// microsecond precision.
+			secs, frac := binary.BigEndian.Uint32(d), binary.BigEndian.Uint32(d[4:])
+			if ie.Type == IPFIXTypeDateTimeMicroseconds {
+				frac &^= 0x7ff
+			}
+			nsec := int64(uint64(frac) * 1e9 >> 32)
+			return time.Unix(int64(secs)-ntpEpochOffset, nsec).UTC()
+		}
+	}
+	return d
+}
+
+func (v NetFlowFieldValue) String() string {
+	return fmt.Sprintf("%s=%v", v.Name(), v.Value())
+}
+
+// ntpEpochOffset is the number of seconds between the NTP epoch (1900) and the
+// Unix epoch.
+const ntpEpochOffset = 2208988800
+
+// NetFlowRecord is a data record of a NetFlow v9 or IPFIX data set.
+type NetFlowRecord struct {
+	Template *NetFlowTemplate
+	// Fields holds a value for each field of the template.  The scope fields
+	// of options records come first.
+	Fields []NetFlowFieldValue
+}
+
+// Field returns the first field with the given IANA information element
+// name, or false if the record doesn't have one.
+func (r *NetFlowRecord) Field(name string) (NetFlowFieldValue, bool) {
+	for _, f := range r.Fields {
+		if f.Name() == name {
+			return f, true
+		}
+	}
+	return NetFlowFieldValue{}, false
+}
+
+// NetFlowDataSet is a NetFlow v9 or IPFIX data set.
+type NetFlowDataSet struct {
+	TemplateID uint16
+	// Data holds the data records, including any padding.
+	Data []byte
+	// Records holds the decoded data records, or is nil if the template of
+	// the set wasn't known when decoding.
+	Records []NetFlowRecord
+}
+
+// decode decodes the records of the set with the given template, which is an
+// IPFIX template if ipfix is set and a NetFlow v9 one otherwise.
+func (s *NetFlowDataSet) decode(t *NetFlowTemplate, ipfix bool) error {
+	min := t.minRecordLength(ipfix)
+	if min == 0 {
+		return fmt.Errorf("template %d has no fields", t.ID)
+	}
+	if min < len(t.Fields) {
+		// Records would take less than a byte per field, each of them
+		// making a NetFlowFieldValue.
+		return fmt.Errorf("template %d has zero-length fields", t.ID)
+	}
+	records := make([]NetFlowRecord, 0, len(s.Data)/min)
+	data := s.Data
+	for len(data) >= min {
+		r := NetFlowRecord{Template: t, Fields: make([]NetFlowFieldValue, len(t.Fields))}
+		for i, f := range t.Fields {
+			l := int(f.Length)
+			if ipfix && f.Length == IPFIXVariableLength {
+				if len(data) < 1 {
+					return errors.New("IPFIX variable-length field truncated")
+				}
+				l = int(data[0])
+				data = data[1:]
+				if l == 255 {
+					if len(data) < 2 {
+						return errors.New("IPFIX variable-length field truncated")
+					}
+					l = int(binary.BigEndian.Uint16(data))
+					data = data[2:]
+				}
+			}
+			if len(data) < l {
+				return fmt.Errorf("data record of template %d truncated", t.ID)
+			}
+			r.Fields[i] = NetFlowFieldValue{NetFlowField: f, Data: data[:l:l]}
+			data = data[l:]
+		}
+		records = append(records, r)
+	}
+	// The remaining bytes are padding.
+	s.Records = records
+	return nil
+}
+
+// NetFlowTemplateKey identifies a template in a NetFlowTemplateCache.
+type NetFlowTemplateKey struct {
+	// Exporter is the network endpoint the template was received from.
+	Exporter gopacket.Endpoint
+	// Version is 9 for NetFlow v9 templates and 10 for IPFIX ones.
+	Version uint16
+	// ObservationDomainID is the NetFlow v9 source ID or IPFIX observation
+	// domain ID.
+	ObservationDomainID uint32
+	TemplateID          uint16
+}
+
+type netFlowTemplateEntry struct {
+	key      NetFlowTemplateKey
+	template *NetFlowTemplate
+}
+
+// NetFlowTemplateCache holds the NetFlow v9 and IPFIX templates received from
+// exporters, up to a maximum number of templates beyond which the least
+// recently used ones are evicted.  It's safe for concurrent use.
+type NetFlowTemplateCache struct {
+	mu   sync.Mutex
+	size int
+	// templates maps keys to their element in order, which is least
+	// recently used first.
+	templates map[NetFlowTemplateKey]*list.Element
+	order     *list.List
+}
+
+// DefaultNetFlowTemplateCacheSize is the number of templates NetFlowTemplates
+// holds.
+const DefaultNetFlowTemplateCacheSize = 4096
+
+// NewNetFlowTemplateCache creates an empty NetFlowTemplateCache holding at
+// most size templates.  If size isn't positive, the cache isn't bounded.
+func NewNetFlowTemplateCache(size int) *NetFlowTemplateCache {
+	return &NetFlowTemplateCache{
+		size:      size,
+		templates: make(map[NetFlowTemplateKey]*list.Element),
+		order:     list.New(),
+	}
+}
+
+// NetFlowTemplates is the NetFlowTemplateCache used when decoding NetFlow v9
+// and IPFIX packets with gopacket.NewPacket, keyed by the source address of
+// the network layer preceding them.  Set it to nil to disable template
+// caching across packets.
+var NetFlowTemplates = NewNetFlowTemplateCache(DefaultNetFlowTemplateCacheSize)
+
+// Template returns the template with the given key, or false if it's unknown.
+func (c *NetFlowTemplateCache) Template(k NetFlowTemplateKey) (*NetFlowTemplate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.templates[k]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToBack(e)
+	return e.Value.(netFlowTemplateEntry).template, true
+}
+
+// Add adds a template to the cache, replacing any template with the same key,
+// and evicts the least recently used template if the cache is full.  Template
+// withdrawals (templates without fields) remove it instead.
+func (c *NetFlowTemplateCache) Add(k NetFlowTemplateKey, t *NetFlowTemplate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.templates[k]
+	switch {
+	case len(t.Fields) == 0:
+		if ok {
+			c.remove(e)
+		}
+	case ok:
+		e.Value = netFlowTemplateEntry{k, t}
+		c.order.MoveToBack(e)
+	default:
+		c.templates[k] = c.order.PushBack(netFlowTemplateEntry{k, t})
+		if c.size > 0 && c.order.Len() > c.size {
+			c.remove(c.order.Front())
+		}
+	}
+}
+
+// Remove removes the template with the given key.
+func (c *NetFlowTemplateCache) Remove(k NetFlowTemplateKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.templates[k]; ok {
+		c.remove(e)
+	}
+}
+
+func (c *NetFlowTemplateCache) remove(e *list.Element) {
+	delete(c.templates, c.order.Remove(e).(netFlowTemplateEntry).key)
+}
+
+// Len returns the number of templates in the cache.
+func (c *NetFlowTemplateCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.templates)
+}
+
+// netFlowDecodeWithTemplates adds templates to the cache, then decodes the
+// data sets which weren't decoded yet with the cached templates, returning the
+// number of data sets left undecoded.
+func netFlowDecodeWithTemplates(c *NetFlowTemplateCache, k NetFlowTemplateKey, templates []*NetFlowTemplate, sets []NetFlowDataSet) (int, error) {
+	for _, t := range templates {
+		k.TemplateID = t.ID
+		c.Add(k, t)
+	}
+	undecoded := 0
+	for i := range sets {
+		s := &sets[i]
+		if s.Records != nil {
+			continue
+		}
+		k.TemplateID = s.TemplateID
+		t, ok := c.Template(k)
+		if !ok {
+			undecoded++
+			continue
+		}
+		if err := s.decode(t, k.Version == 10); err != nil {
+			return undecoded, err
+		}
+	}
+	return undecoded, nil
+}
+
+// netFlowDecodeLocal decodes the data sets whose template is defined in the
+// same packet, an IPFIX message if ipfix is set.
+func netFlowDecodeLocal(templates []*NetFlowTemplate, sets []NetFlowDataSet, ipfix bool) error {
+	for i := range sets {
+		for _, t := range templates {
+			if t.ID == sets[i].TemplateID && len(t.Fields) > 0 {
+				if err := sets[i].decode(t, ipfix); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// netFlowExporter returns the source endpoint of the packet's network layer,
+// if the decoder is decoding a gopacket.Packet.
+func netFlowExporter(p gopacket.PacketBuilder) (gopacket.Endpoint, bool) {
+	if pkt, ok := p.(gopacket.Packet); ok {
+		if n := pkt.NetworkLayer(); n != nil {
+			return n.NetworkFlow().Src(), true
+		}
+	}
+	return gopacket.Endpoint{}, false
+}
+
+// netFlowVersionLayerType returns the layer type of a NetFlow or IPFIX
+// packet according to its version field, or def if it's not one of them.
+func netFlowVersionLayerType(def gopacket.LayerType, data []byte) gopacket.LayerType {
+	if len(data) < 2 {
+		return def
+	}
+	switch binary.BigEndian.Uint16(data) {
+	case 5:
+		return LayerTypeNetFlowV5
+	case 9:
+		return LayerTypeNetFlowV9
+	case 10:
+		return LayerTypeIPFIX
+	}
+	return def
+}
+
+// netFlowVersionDecoder returns the decoder of a NetFlow or IPFIX packet whose
+// version field isn't the given one, and nil otherwise.  Collectors commonly
+// receive all versions on the same port, so the decoders of the NetFlow and
+// IPFIX layer types, which UDPPort maps the ports to, check it first, as
+// UDP.NextLayerType does for DecodingLayerParsers.
+func netFlowVersionDecoder(data []byte, version uint16) func([]byte, gopacket.PacketBuilder) error {
+	if len(data) < 2 {
+		return nil
+	}
+	switch v := binary.BigEndian.Uint16(data); {
+	case v == version:
+		return nil
+	case v == 5:
+		return decodeNetFlowV5
+	case v == 9:
+		return decodeNetFlowV9
+	case v == 10:
+		return decodeIPFIX
+	}
+	return nil
+}
+
+// NetFlowV5 is a NetFlow v5 export packet.
+type NetFlowV5 struct {
+	BaseLayer
+	Version          uint16
+	Count            uint16
+	SysUptime        uint32
+	UnixSecs         uint32
+	UnixNsecs        uint32
+	FlowSequence     uint32
+	EngineType       uint8
+	EngineID         uint8
+	SamplingMode     uint8
+	SamplingInterval uint16
+	Records          []NetFlowV5Record
+}
+
+// NetFlowV5Record is a NetFlow v5 flow record.
+type NetFlowV5Record struct {
+	SrcAddr, DstAddr, NextHop net.IP
+	Input, Output             uint16
+	Packets, Octets           uint32
+	// First and Last are the system uptime at the first and last packets of
+	// the flow, in milliseconds.
+	First, Last      uint32
+	SrcPort, DstPort uint16
+	TCPFlags         uint8
+	Protocol         IPProtocol
+	ToS              uint8
+	SrcAS, DstAS     uint16
+	SrcMask, DstMask uint8
+}
+
+const (
+	netFlowV5HeaderLen = 24
+	netFlowV5RecordLen = 48
+)
+
+// LayerType returns LayerTypeNetFlowV5.
+func (n *NetFlowV5) LayerType() gopacket.LayerType { return LayerTypeNetFlowV5 }
+
+// CanDecode returns LayerTypeNetFlowV5.
+func (n *NetFlowV5) CanDecode() gopacket.LayerClass { return LayerTypeNetFlowV5 }
+
+// NextLayerType returns gopacket.LayerTypeZero.
+func (n *NetFlowV5) NextLayerType() gopacket.LayerType { return gopacket.LayerTypeZero }
+
+// Payload returns nil.
+func (n *NetFlowV5) Payload() []byte { return nil }
+
+// ExportTime returns the time the packet was exported.
+func (n *NetFlowV5) ExportTime() time.Time {
+	return time.Unix(int64(n.UnixSecs), int64(n.UnixNsecs)).UTC()
+}
+
+// DecodeFromBytes decodes the given bytes into this layer.
+func (n *NetFlowV5) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < netFlowV5HeaderLen {
+		df.SetTruncated()
+		return errors.New("NetFlow v5 packet too short")
+	}
+	n.Version = binary.BigEndian.Uint16(data[0:2])
+	if n.Version != 5 {
+		return fmt.Errorf("invalid NetFlow v5 version %d", n.Version)
+	}
+	n.Count = binary.BigEndian.Uint16(data[2:4])
+	n.SysUptime = binary.BigEndian.Uint32(data[4:8])
+	n.UnixSecs = binary.BigEndian.Uint32(data[8:12])
+	n.UnixNsecs = binary.BigEndian.Uint32(data[12:16])
+	n.FlowSequence = binary.BigEndian.Uint32(data[16:20])
+	n.EngineType = data[20]
+	n.EngineID = data[21]
+	sampling := binary.BigEndian.Uint16(data[22:24])
+	n.SamplingMode, n.SamplingInterval = uint8(sampling>>14), sampling&0x3fff
+	end := netFlowV5HeaderLen + int(n.Count)*netFlowV5RecordLen
+	if len(data) < end {
+		df.SetTruncated()
+		return fmt.Errorf("NetFlow v5 packet too short for %d records", n.Count)
+	}
+	n.Records = n.Records[:0]
+	for r := data[netFlowV5HeaderLen:end]; len(r) > 0; r = r[netFlowV5RecordLen:] {
+		n.Records = append(n.Records, NetFlowV5Record{
+			SrcAddr:  net.IP(r[0:4]),
+			DstAddr:  net.IP(r[4:8]),
+			NextHop:  net.IP(r[8:12]),
+			Input:    binary.BigEndian.Uint16(r[12:14]),
+			Output:   binary.BigEndian.Uint16(r[14:16]),
+			Packets:  binary.BigEndian.Uint32(r[16:20]),
+			Octets:   binary.BigEndian.Uint32(r[20:24]),
+			First:    binary.BigEndian.Uint32(r[24:28]),
+			Last:     binary.BigEndian.Uint32(r[28:32]),
+			SrcPort:  binary.BigEndian.Uint16(r[32:34]),
+			DstPort:  binary.BigEndian.Uint16(r[34:36]),
+			TCPFlags: r[37],
+			Protocol: IPProtocol(r[38]),
+			ToS:      r[39],
+			SrcAS:    binary.BigEndian.Uint16(r[40:42]),
+			DstAS:    binary.BigEndian.Uint16(r[42:44]),
+			SrcMask:  r[44],
+			DstMask:  r[45],
+		})
+	}
+	n.BaseLayer = BaseLayer{Contents: data[:end], Payload: data[end:]}
+	return nil
+}
+
+func decodeNetFlowV5(data []byte, p gopacket.PacketBuilder) error {
+	if d := netFlowVersionDecoder(data, 5); d != nil {
+		return d(data, p)
+	}
+	n := &NetFlowV5{}
+	if err := n.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(n)
+	p.SetApplicationLayer(n)
+	return nil
+}
+
+// NetFlowV9 is a NetFlow v9 export packet.
+type NetFlowV9 struct {
+	BaseLayer
+	Version        uint16
+	Count          uint16
+	SysUptime      uint32
+	UnixSecs       uint32
+	SequenceNumber uint32
+	SourceID       uint32
+	// Templates holds the templates and options templates of the packet.
+	Templates []*NetFlowTemplate
+	// DataSets holds the data flowsets of the packet.  Those whose template
+	// is defined in the packet are decoded; see DecodeWithTemplates for the
+	// others.
+	DataSets []NetFlowDataSet
+}
+
+const (
+	netFlowV9HeaderLen          = 20
+	netFlowV9TemplateSetID      = 0
+	netFlowV9OptionsTemplateSet = 1
+)
+
+// LayerType returns LayerTypeNetFlowV9.
+func (n *NetFlowV9) LayerType() gopacket.LayerType { return LayerTypeNetFlowV9 }
+
+// CanDecode returns LayerTypeNetFlowV9.
+func (n *NetFlowV9) CanDecode() gopacket.LayerClass { return LayerTypeNetFlowV9 }
+
+// NextLayerType returns gopacket.LayerTypeZero.
+func (n *NetFlowV9) NextLayerType() gopacket.LayerType { return gopacket.LayerTypeZero }
+
+// Payload returns nil.
+func (n *NetFlowV9) Payload() []byte { return nil }
+
+// ExportTime returns the time the packet was exported.
+func (n *NetFlowV9) ExportTime() time.Time {
+	return time.Unix(int64(n.UnixSecs), 0).UTC()
+}
+
+// DecodeWithTemplates adds the templates of the packet to the cache, and
+// decodes the data sets whose templates weren't in the packet with the cached
+// ones.  The exporter is the network endpoint the packet was received from.
+// It returns the number of data sets whose template is still unknown.
+func (n *NetFlowV9) DecodeWithTemplates(c *NetFlowTemplateCache, exporter gopacket.Endpoint) (int, error) {
+	k := NetFlowTemplateKey{Exporter: exporter, Version: 9, ObservationDomainID: n.SourceID}
+	return netFlowDecodeWithTemplates(c, k, n.Templates, n.DataSets)
+}
+
+// DecodeFromBytes decodes the given bytes into this layer.
+func (n *NetFlowV9) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < netFlowV9HeaderLen {
+		df.SetTruncated()
+		return errors.New("NetFlow v9 packet too short")
+	}
+	n.Version = binary.BigEndian.Uint16(data[0:2])
+	if n.Version != 9 {
+		return fmt.Errorf("invalid NetFlow v9 version %d", n.Version)
+	}
+	n.Count = binary.BigEndian.Uint16(data[2:4])
+	n.SysUptime = binary.BigEndian.Uint32(data[4:8])
+	n.UnixSecs = binary.BigEndian.Uint32(data[8:12])
+	n.SequenceNumber = binary.BigEndian.Uint32(data[12:16])
+	n.SourceID = binary.BigEndian.Uint32(data[16:20])
+	n.Templates = n.Templates[:0]
+	n.DataSets = n.DataSets[:0]
+
+	for sets := data[netFlowV9HeaderLen:]; len(sets) > 0; {
+		if len(sets) < 4 {
+			df.SetTruncated()
+			return errors.New("NetFlow v9 flowset header truncated")
+		}
+		id, length := binary.BigEndian.Uint16(sets[0:2]), int(binary.BigEndian.Uint16(sets[2:4]))
+		if length < 4 || length > len(sets) {
+			df.SetTruncated()
+			return fmt.Errorf("invalid NetFlow v9 flowset length %d", length)
+		}
+		body := sets[4:length]
+		sets = sets[length:]
+		var err error
+		switch {
+		case id == netFlowV9TemplateSetID:
+			err = n.decodeTemplates(body)
+		case id == netFlowV9OptionsTemplateSet:
+			err = n.decodeOptionsTemplates(body)
+		case id >= 256:
+			n.DataSets = append(n.DataSets, NetFlowDataSet{TemplateID: id, Data: body})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	n.BaseLayer = BaseLayer{Contents: data}
+	return netFlowDecodeLocal(n.Templates, n.DataSets, false)
+}
+
+func (n *NetFlowV9) decodeTemplates(data []byte) error {
+	for len(data) >= 4 {
+		t := &NetFlowTemplate{ID: binary.BigEndian.Uint16(data[0:2])}
+		count := int(binary.BigEndian.Uint16(data[2:4]))
+		if t.ID < 256 {
+			// Padding.
+			break
+		}
+		data = data[4:]
+		if len(data) < count*4 {
+			return fmt.Errorf("NetFlow v9 template %d truncated", t.ID)
+		}
+		for i := 0; i < count; i++ {
+			f := NetFlowField{
+				ID:     binary.BigEndian.Uint16(data[0:2]),
+				Length: binary.BigEndian.Uint16(data[2:4]),
+			}
+			if f.Length == 0 {
+				return fmt.Errorf("NetFlow v9 template %d has a zero-length field", t.ID)
+			}
+			t.Fields = append(t.Fields, f)
+			data = data[4:]
+		}
+		n.Templates = append(n.Templates, t)
+	}
+	return nil
+}
+
+func (n *NetFlowV9) decodeOptionsTemplates(data []byte) error {
+	for len(data) >= 6 {
+		t := &NetFlowTemplate{ID: binary.BigEndian.Uint16(data[0:2]), Options: true}
+		scopeLen := int(binary.BigEndian.Uint16(data[2:4]))
+		optionLen := int(binary.BigEndian.Uint16(data[4:6]))
+		if t.ID < 256 {
+			// Padding.
+			break
+		}
+		data = data[6:]
+		if scopeLen%4 != 0 || optionLen%4 != 0 || len(data) < scopeLen+optionLen {
+			return fmt.Errorf("invalid NetFlow v9 options template %d", t.ID)
+		}
+		t.ScopeFieldCount = scopeLen / 4
+		for i := 0; i < scopeLen+optionLen; i += 4 {
+			f := NetFlowField{
+				ID:      binary.BigEndian.Uint16(data[i : i+2]),
+				Length:  binary.BigEndian.Uint16(data[i+2 : i+4]),
+				V9Scope: i < scopeLen,
+			}
+			if f.Length == 0 {
+				return fmt.Errorf("NetFlow v9 options template %d has a zero-length field", t.ID)
+			}
+			t.Fields = append(t.Fields, f)
+		}
+		data = data[scopeLen+optionLen:]
+		n.Templates = append(n.Templates, t)
+	}
+	return nil
+}
+
+func decodeNetFlowV9(data []byte, p gopacket.PacketBuilder) error {
+	if d := netFlowVersionDecoder(data, 9); d != nil {
+		return d(data, p)
+	}
+	n := &NetFlowV9{}
+	if err := n.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(n)
+	p.SetApplicationLayer(n)
+	if c := NetFlowTemplates; c != nil {
+		if exporter, ok := netFlowExporter(p); ok {
+			if _, err := n.DecodeWithTemplates(c, exporter); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
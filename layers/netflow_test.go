@@ -0,0 +1,381 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// netFlowPacket wraps a NetFlow/IPFIX payload in Ethernet/IPv4/UDP.
+func netFlowPacket(t *testing.T, exporter net.IP, port UDPPort, payload []byte) gopacket.Packet {
+	ip := &IPv4{Version: 4, TTL: 64, Protocol: IPProtocolUDP, SrcIP: exporter, DstIP: net.IP{192, 0, 2, 100}}
+	udp := &UDP{SrcPort: 40000, DstPort: port}
+	udp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		&Ethernet{SrcMAC: net.HardwareAddr{0, 0, 0, 0, 0, 1}, DstMAC: net.HardwareAddr{0, 0, 0, 0, 0, 2}, EthernetType: EthernetTypeIPv4},
+		ip, udp, gopacket.Payload(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gopacket.NewPacket(buf.Bytes(), LinkTypeEthernet, gopacket.Default)
+}
+
+func netflowBE16(vs ...uint16) (b []byte) {
+	for _, v := range vs {
+		b = append(b, byte(v>>8), byte(v))
+	}
+	return
+}
+
+func netflowBE32(vs ...uint32) (b []byte) {
+	for _, v := range vs {
+		b = append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+	return
+}
+
+func netflowBE64(v uint64) []byte {
+	return append(netflowBE32(uint32(v>>32)), netflowBE32(uint32(v))...)
+}
+
+// netflowSet returns a NetFlow v9 flowset or IPFIX set.
+func netflowSet(id uint16, body ...[]byte) []byte {
+	var b []byte
+	for _, p := range body {
+		b = append(b, p...)
+	}
+	return append(netflowBE16(id, uint16(len(b)+4)), b...)
+}
+
+func ipfixMessage(domain uint32, sets ...[]byte) []byte {
+	var b []byte
+	for _, s := range sets {
+		b = append(b, s...)
+	}
+	return append(append(netflowBE16(10, uint16(len(b)+16)), netflowBE32(1500000000, 7, domain)...), b...)
+}
+
+var (
+	exporterA = net.IP{192, 0, 2, 1}
+	exporterB = net.IP{192, 0, 2, 2}
+	// testIPFIXTemplate is template 256: source and destination addresses,
+	// octet count, a variable-length enterprise field and the flow start.
+	testIPFIXTemplate = netflowSet(2, netflowBE16(256, 5), netflowBE16(8, 4, 12, 4, 1, 8, 0x8000|100, IPFIXVariableLength), netflowBE32(9), netflowBE16(152, 8))
+)
+
+func testIPFIXRecord(src, dst byte, octets uint64, name string) []byte {
+	b := []byte{10, 0, 0, src, 10, 0, 0, dst}
+	b = append(b, netflowBE64(octets)...)
+	b = append(b, byte(len(name)))
+	b = append(b, name...)
+	return append(b, netflowBE64(1500000000123)...)
+}
+
+func withNetFlowTemplates(c *NetFlowTemplateCache) func() {
+	old := NetFlowTemplates
+	NetFlowTemplates = c
+	return func() { NetFlowTemplates = old }
+}
+
+func TestIPFIXTemplateCache(t *testing.T) {
+	defer withNetFlowTemplates(NewNetFlowTemplateCache(0))()
+
+	// The template and a data set in the same message.
+	p := netFlowPacket(t, exporterA, 4739, ipfixMessage(1, testIPFIXTemplate, netflowSet(256, testIPFIXRecord(1, 2, 1000, "a"))))
+	checkLayers(p, []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeUDP, LayerTypeIPFIX}, t)
+	ipfix := p.Layer(LayerTypeIPFIX).(*IPFIX)
+	if len(ipfix.Templates) != 1 || len(ipfix.DataSets) != 1 || len(ipfix.DataSets[0].Records) != 1 {
+		t.Fatalf("unexpected IPFIX message %+v", ipfix)
+	}
+	r := ipfix.DataSets[0].Records[0]
+	if f, ok := r.Field("sourceIPv4Address"); !ok || !f.Value().(net.IP).Equal(net.IP{10, 0, 0, 1}) {
+		t.Errorf("unexpected source address %v", f)
+	}
+	if f, _ := r.Field("octetDeltaCount"); f.Value() != uint64(1000) {
+		t.Errorf("unexpected octet count %v", f)
+	}
+	if f, _ := r.Field("9.100"); string(f.Data) != "a" {
+		t.Errorf("unexpected enterprise field %v", f)
+	}
+	want := time.Unix(1500000000, 123*int64(time.Millisecond)).UTC()
+	if f, _ := r.Field("flowStartMilliseconds"); f.Value() != want {
+		t.Errorf("unexpected flow start %v", f)
+	}
+
+	// Data sets in later messages use the cached template, but only for the
+	// same exporter and observation domain.
+	for _, c := range []struct {
+		exporter net.IP
+		domain   uint32
+		decoded  bool
+	}{
+		{exporterA, 1, true},
+		{exporterA, 2, false},
+		{exporterB, 1, false},
+	} {
+		p = netFlowPacket(t, c.exporter, 4739, ipfixMessage(c.domain, netflowSet(256, testIPFIXRecord(3, 4, 5, "long name"), testIPFIXRecord(5, 6, 7, ""), []byte{0, 0})))
+		ipfix = p.Layer(LayerTypeIPFIX).(*IPFIX)
+		if got := ipfix.DataSets[0].Records != nil; got != c.decoded {
+			t.Errorf("%v/%d: got decoded %v, want %v", c.exporter, c.domain, got, c.decoded)
+		}
+		if c.decoded {
+			if n := len(ipfix.DataSets[0].Records); n != 2 {
+				t.Errorf("expected 2 records, got %d", n)
+			} else if f, _ := ipfix.DataSets[0].Records[1].Field("destinationIPv4Address"); !f.Value().(net.IP).Equal(net.IP{10, 0, 0, 6}) {
+				t.Errorf("unexpected destination address %v", f)
+			}
+		}
+	}
+
+	// Template withdrawal.
+	netFlowPacket(t, exporterA, 4739, ipfixMessage(1, netflowSet(2, netflowBE16(256, 0))))
+	if NetFlowTemplates.Len() != 0 {
+		t.Errorf("expected template to be withdrawn, %d left", NetFlowTemplates.Len())
+	}
+}
+
+func TestIPFIXOptionsTemplate(t *testing.T) {
+	var ipfix IPFIX
+	msg := ipfixMessage(3,
+		netflowSet(3, netflowBE16(300, 2, 1), netflowBE16(149, 4, 41, 8)),
+		netflowSet(300, netflowBE32(3), netflowBE64(42)))
+	if err := ipfix.DecodeFromBytes(msg, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	tmpl := ipfix.Templates[0]
+	if !tmpl.Options || tmpl.ScopeFieldCount != 1 || len(tmpl.Fields) != 2 {
+		t.Fatalf("unexpected options template %+v", tmpl)
+	}
+	r := ipfix.DataSets[0].Records[0]
+	if r.Fields[0].Name() != "observationDomainId" || r.Fields[0].Value() != uint64(3) {
+		t.Errorf("unexpected scope field %v", r.Fields[0])
+	}
+	if f, _ := r.Field("exportedMessageTotalCount"); f.Value() != uint64(42) {
+		t.Errorf("unexpected option field %v", f)
+	}
+
+	// The same message decoded with a DecodingLayerParser, and the data set
+	// alone with the cached options template.
+	cache := NewNetFlowTemplateCache(0)
+	if n, err := ipfix.DecodeWithTemplates(cache, NewIPEndpoint(exporterA)); n != 0 || err != nil {
+		t.Fatal(n, err)
+	}
+	if err := ipfix.DecodeFromBytes(ipfixMessage(3, netflowSet(300, netflowBE32(3), netflowBE64(43))), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := ipfix.DecodeWithTemplates(cache, NewIPEndpoint(exporterA)); n != 0 || err != nil {
+		t.Fatal(n, err)
+	}
+	if f, _ := ipfix.DataSets[0].Records[0].Field("exportedMessageTotalCount"); f.Value() != uint64(43) {
+		t.Errorf("unexpected option field %v", f)
+	}
+}
+
+func netFlowV9Message(sourceID uint32, sets ...[]byte) []byte {
+	b := append(netflowBE16(9, uint16(len(sets))), netflowBE32(60000, 1500000000, 1, sourceID)...)
+	for _, s := range sets {
+		b = append(b, s...)
+	}
+	return b
+}
+
+func TestNetFlowV9(t *testing.T) {
+	defer withNetFlowTemplates(NewNetFlowTemplateCache(0))()
+
+	msg := netFlowV9Message(5,
+		netflowSet(0, netflowBE16(256, 3), netflowBE16(8, 4, 2, 4, 7, 2)),
+		netflowSet(1, netflowBE16(257, 4, 4), netflowBE16(1, 4, 36, 2)))
+	p := netFlowPacket(t, exporterA, 2055, msg)
+	checkLayers(p, []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeUDP, LayerTypeNetFlowV9}, t)
+	v9 := p.Layer(LayerTypeNetFlowV9).(*NetFlowV9)
+	if v9.SourceID != 5 || len(v9.Templates) != 2 || !v9.Templates[1].Options {
+		t.Fatalf("unexpected NetFlow v9 packet %+v", v9)
+	}
+
+	// Data sets are padded to 4 bytes.
+	p = netFlowPacket(t, exporterA, 2055, netFlowV9Message(5,
+		netflowSet(256, []byte{10, 0, 0, 1}, netflowBE32(3), netflowBE16(80), []byte{10, 0, 0, 2}, netflowBE32(4), netflowBE16(443), []byte{0, 0, 0, 0}),
+		netflowSet(257, netflowBE32(0), netflowBE16(1800), []byte{0, 0})))
+	v9 = p.Layer(LayerTypeNetFlowV9).(*NetFlowV9)
+	if n := len(v9.DataSets[0].Records); n != 2 {
+		t.Fatalf("expected 2 records, got %d", n)
+	}
+	if f, _ := v9.DataSets[0].Records[1].Field("sourceTransportPort"); f.Value() != uint64(443) {
+		t.Errorf("unexpected port %v", f)
+	}
+	opts := v9.DataSets[1].Records
+	if len(opts) != 1 || opts[0].Fields[0].Name() != "scopeSystem" {
+		t.Fatalf("unexpected options records %v", opts)
+	}
+	if f, _ := opts[0].Field("flowActiveTimeout"); f.Value() != uint64(1800) {
+		t.Errorf("unexpected option %v", f)
+	}
+}
+
+func TestNetFlowV5(t *testing.T) {
+	rec := make([]byte, netFlowV5RecordLen)
+	copy(rec[0:], []byte{10, 0, 0, 1, 10, 0, 0, 2})
+	binary.BigEndian.PutUint32(rec[16:], 3)
+	binary.BigEndian.PutUint32(rec[20:], 180)
+	binary.BigEndian.PutUint16(rec[32:], 1234)
+	binary.BigEndian.PutUint16(rec[34:], 53)
+	rec[38] = byte(IPProtocolUDP)
+	msg := append(netflowBE16(5, 1), netflowBE32(60000, 1500000000, 0, 99)...)
+	msg = append(msg, 0, 1, 0x40, 0x64)
+	msg = append(msg, rec...)
+
+	// Version 5 packets are recognized on the NetFlow v9 port.
+	p := netFlowPacket(t, exporterA, 2055, msg)
+	checkLayers(p, []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeUDP, LayerTypeNetFlowV5}, t)
+	v5 := p.Layer(LayerTypeNetFlowV5).(*NetFlowV5)
+	if v5.FlowSequence != 99 || v5.EngineID != 1 || v5.SamplingMode != 1 || v5.SamplingInterval != 100 {
+		t.Errorf("unexpected header %+v", v5)
+	}
+	if len(v5.Records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(v5.Records))
+	}
+	r := v5.Records[0]
+	if !r.DstAddr.Equal(net.IP{10, 0, 0, 2}) || r.Octets != 180 || r.DstPort != 53 || r.Protocol != IPProtocolUDP {
+		t.Errorf("unexpected record %+v", r)
+	}
+
+	var dec NetFlowV5
+	if err := dec.DecodeFromBytes(msg[:len(msg)-1], gopacket.NilDecodeFeedback); err == nil {
+		t.Error("expected error decoding truncated packet")
+	}
+}
+
+func TestNetFlowV9TemplatePadding(t *testing.T) {
+	var v9 NetFlowV9
+	msg := netFlowV9Message(5, netflowSet(0, netflowBE16(256, 1), netflowBE16(8, 4), []byte{0, 0, 0, 0}))
+	if err := v9.DecodeFromBytes(msg, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if len(v9.Templates) != 1 || v9.Templates[0].ID != 256 {
+		t.Errorf("padding decoded as a template: %+v", v9.Templates)
+	}
+}
+
+func TestNetFlowVersionPorts(t *testing.T) {
+	defer withNetFlowTemplates(NewNetFlowTemplateCache(0))()
+
+	// Each port decodes every version.
+	for _, port := range []UDPPort{2055, 4739} {
+		p := netFlowPacket(t, exporterA, port, ipfixMessage(1, testIPFIXTemplate))
+		checkLayers(p, []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeUDP, LayerTypeIPFIX}, t)
+		p = netFlowPacket(t, exporterA, port, netFlowV9Message(5, netflowSet(0, netflowBE16(256, 1), netflowBE16(8, 4))))
+		checkLayers(p, []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeUDP, LayerTypeNetFlowV9}, t)
+	}
+
+	// DecodingLayerParsers dispatch on the version too.
+	var (
+		udp   UDP
+		v5    NetFlowV5
+		v9    NetFlowV9
+		ipfix IPFIX
+	)
+	parser := gopacket.NewDecodingLayerParser(LayerTypeUDP, &udp, &v5, &v9, &ipfix)
+	for _, c := range []struct {
+		msg  []byte
+		want gopacket.LayerType
+	}{
+		{append(netflowBE16(5, 0), netflowBE32(60000, 1500000000, 0, 0, 0)...), LayerTypeNetFlowV5},
+		{netFlowV9Message(5), LayerTypeNetFlowV9},
+		{ipfixMessage(1, testIPFIXTemplate), LayerTypeIPFIX},
+	} {
+		data := append(netflowBE16(40000, 2055, uint16(8+len(c.msg)), 0), c.msg...)
+		var decoded []gopacket.LayerType
+		if err := parser.DecodeLayers(data, &decoded); err != nil {
+			t.Errorf("%v: %v", c.want, err)
+		} else if len(decoded) != 2 || decoded[1] != c.want {
+			t.Errorf("%v: decoded %v", c.want, decoded)
+		}
+	}
+}
+
+func TestNetFlowV9NoVariableLength(t *testing.T) {
+	// 0xffff is a fixed length in NetFlow v9.
+	var v9 NetFlowV9
+	msg := netFlowV9Message(5, netflowSet(0, netflowBE16(256, 1), netflowBE16(8, IPFIXVariableLength)), netflowSet(256, []byte{4, 10, 0, 0, 1}, []byte{0, 0, 0}))
+	if err := v9.DecodeFromBytes(msg, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if r := v9.DataSets[0].Records; r == nil || len(r) != 0 {
+		t.Errorf("got records %v", r)
+	}
+}
+
+func TestNetFlowTemplateCacheSize(t *testing.T) {
+	c := NewNetFlowTemplateCache(2)
+	exporter := NewIPEndpoint(exporterA)
+	key := func(id uint16) NetFlowTemplateKey {
+		return NetFlowTemplateKey{Exporter: exporter, Version: 10, ObservationDomainID: 1, TemplateID: id}
+	}
+	tmpl := func(id uint16) *NetFlowTemplate {
+		return &NetFlowTemplate{ID: id, Fields: []NetFlowField{{ID: 8, Length: 4}}}
+	}
+	c.Add(key(256), tmpl(256))
+	c.Add(key(257), tmpl(257))
+	// Using 256 makes 257 the least recently used template.
+	if _, ok := c.Template(key(256)); !ok {
+		t.Fatal("template 256 not found")
+	}
+	c.Add(key(258), tmpl(258))
+	if c.Len() != 2 {
+		t.Errorf("cache holds %d templates", c.Len())
+	}
+	for id, want := range map[uint16]bool{256: true, 257: false, 258: true} {
+		if _, ok := c.Template(key(id)); ok != want {
+			t.Errorf("template %d cached: %v, want %v", id, ok, want)
+		}
+	}
+
+	// Replacing and withdrawing templates doesn't evict others.
+	c.Add(key(258), tmpl(258))
+	c.Add(key(256), &NetFlowTemplate{ID: 256})
+	if _, ok := c.Template(key(258)); !ok || c.Len() != 1 {
+		t.Errorf("cache holds %d templates after withdrawal", c.Len())
+	}
+}
+
+func TestNetFlowTemplateLimits(t *testing.T) {
+	var ipfix IPFIX
+	var v9 NetFlowV9
+	for _, c := range []struct {
+		name string
+		err  error
+	}{
+		// 65535 fields without their specifiers.
+		{"IPFIX field count", ipfix.DecodeFromBytes(ipfixMessage(1, netflowSet(2, netflowBE16(256, 0xffff))), gopacket.NilDecodeFeedback)},
+		{"IPFIX zero-length field", ipfix.DecodeFromBytes(ipfixMessage(1, netflowSet(2, netflowBE16(256, 2), netflowBE16(8, 0, 12, 4))), gopacket.NilDecodeFeedback)},
+		{"v9 zero-length field", v9.DecodeFromBytes(netFlowV9Message(5, netflowSet(0, netflowBE16(256, 2), netflowBE16(8, 0, 12, 4))), gopacket.NilDecodeFeedback)},
+		{"v9 zero-length option", v9.DecodeFromBytes(netFlowV9Message(5, netflowSet(1, netflowBE16(257, 4, 4), netflowBE16(1, 4, 36, 0))), gopacket.NilDecodeFeedback)},
+	} {
+		if c.err == nil {
+			t.Errorf("%s: expected error", c.name)
+		}
+	}
+
+	// Templates added to the cache directly aren't checked until used.
+	cache := NewNetFlowTemplateCache(0)
+	exporter := NewIPEndpoint(exporterA)
+	fields := make([]NetFlowField, 350)
+	fields[349].Length = 1
+	cache.Add(NetFlowTemplateKey{Exporter: exporter, Version: 10, ObservationDomainID: 1, TemplateID: 256}, &NetFlowTemplate{ID: 256, Fields: fields})
+	if err := ipfix.DecodeFromBytes(ipfixMessage(1, netflowSet(256, make([]byte, 1000))), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ipfix.DecodeWithTemplates(cache, exporter); err == nil || ipfix.DataSets[0].Records != nil {
+		t.Error("decoded records with zero-length fields")
+	}
+}
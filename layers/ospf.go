@@ -42,6 +42,19 @@ const (
 	NSSALSAtypeV2           = 0x7
 	LinkLSAtype             = 0x0008
 	IntraAreaPrefixLSAtype  = 0x2009
+	OpaqueLinkLSAtypeV2     = 0x9
+	OpaqueAreaLSAtypeV2     = 0xa
+	OpaqueASLSAtypeV2       = 0xb
+
+	// OSPFv3 extended LSAs, RFC 8362.
+	ExtendedRouterLSAtype          = 0xa021
+	ExtendedNetworkLSAtype         = 0xa022
+	ExtendedInterAreaPrefixLSAtype = 0xa023
+	ExtendedInterAreaRouterLSAtype = 0xa024
+	ExtendedASExternalLSAtype      = 0xc025
+	ExtendedNSSALSAtype            = 0xa027
+	ExtendedLinkLSAtype            = 0x8028
+	ExtendedIntraAreaPrefixLSAtype = 0xa029
 )
 
 // String conversions for OSPFType
@@ -251,6 +264,8 @@ type OSPFv3 struct {
 	OSPF
 	Instance uint8
 	Reserved uint8
+
+	tcpipchecksum
 }
 
 // getLSAsv2 parses the LSA information from the packet for OSPFv2
@@ -259,6 +274,9 @@ func getLSAsv2(num uint32, data []byte) ([]LSA, error) {
 	var i uint32 = 0
 	var offset uint32 = 0
 	for ; i < num; i++ {
+		if len(data) < int(offset+20) {
+			return nil, errors.New("Link State header too short")
+		}
 		lstype := uint16(data[offset+3])
 		lsalength := binary.BigEndian.Uint16(data[offset+18 : offset+20])
 		content, err := extractLSAInformation(lstype, lsalength, data[offset:])
@@ -328,6 +346,28 @@ func extractLSAInformation(lstype, lsalength uint16, data []byte) (interface{},
 			ForwardingAddress: binary.BigEndian.Uint32(data[28:32]),
 			ExternalRouteTag:  binary.BigEndian.Uint32(data[32:36]),
 		}
+	case SummaryLSANetworktypeV2, SummaryLSAASBRtypeV2:
+		if lsalength < 28 {
+			return nil, errors.New("Summary LSA too small")
+		}
+		content = SummaryLSAV2{
+			NetworkMask: binary.BigEndian.Uint32(data[20:24]),
+			Metric:      binary.BigEndian.Uint32(data[24:28]) & 0x00FFFFFF,
+		}
+	case OpaqueLinkLSAtypeV2, OpaqueAreaLSAtypeV2, OpaqueASLSAtypeV2:
+		tlvs, err := decodeOSPFTLVs(data[20:lsalength])
+		if err != nil {
+			return nil, err
+		}
+		content = OpaqueLSA{TLVs: tlvs}
+	case ExtendedRouterLSAtype, ExtendedNetworkLSAtype, ExtendedInterAreaPrefixLSAtype,
+		ExtendedInterAreaRouterLSAtype, ExtendedASExternalLSAtype, ExtendedNSSALSAtype,
+		ExtendedLinkLSAtype, ExtendedIntraAreaPrefixLSAtype:
+		lsa, err := decodeExtendedLSA(lstype, data[20:lsalength])
+		if err != nil {
+			return nil, err
+		}
+		content = lsa
 	case NetworkLSAtypeV2:
 		var routers []uint32
 		var j uint32
@@ -382,35 +422,58 @@ func extractLSAInformation(lstype, lsalength uint16, data []byte) (interface{},
 	case ASExternalLSAtype:
 		fallthrough
 	case NSSALSAtype:
+		if lsalength < 24 {
+			return nil, errors.New("AS-External LSA too small")
+		}
 		flags := uint8(data[20])
-		prefixLen := uint8(data[24]) / 8
-		var forwardingAddress []byte
+		// The prefix's metric field holds the referenced LS type.
+		prefix, n, err := decodeOSPFv3Prefix(data[24:lsalength])
+		if err != nil {
+			return nil, err
+		}
+		lsa := ASExternalLSA{
+			Flags:         flags,
+			Metric:        binary.BigEndian.Uint32(data[20:24]) & 0x00FFFFFF,
+			PrefixLength:  prefix.PrefixLength,
+			PrefixOptions: prefix.PrefixOptions,
+			RefLSType:     prefix.Metric,
+			AddressPrefix: prefix.AddressPrefix,
+		}
+		rest := data[24+n : lsalength]
 		if (flags & 0x02) == 0x02 {
-			forwardingAddress = data[28+uint32(prefixLen) : 28+uint32(prefixLen)+16]
+			if len(rest) < 16 {
+				return nil, errors.New("AS-External LSA forwarding address missing")
+			}
+			lsa.ForwardingAddress, rest = rest[:16], rest[16:]
+		}
+		if (flags & 0x01) == 0x01 {
+			if len(rest) < 4 {
+				return nil, errors.New("AS-External LSA route tag missing")
+			}
+			lsa.ExternalRouteTag, rest = binary.BigEndian.Uint32(rest), rest[4:]
 		}
-		content = ASExternalLSA{
-			Flags:             flags,
-			Metric:            binary.BigEndian.Uint32(data[20:24]) & 0x00FFFFFF,
-			PrefixLength:      prefixLen,
-			PrefixOptions:     uint8(data[25]),
-			RefLSType:         binary.BigEndian.Uint16(data[26:28]),
-			AddressPrefix:     data[28 : 28+uint32(prefixLen)],
-			ForwardingAddress: forwardingAddress,
+		if lsa.RefLSType != 0 {
+			if len(rest) < 4 {
+				return nil, errors.New("AS-External LSA referenced link state ID missing")
+			}
+			lsa.RefLinkStateID = binary.BigEndian.Uint32(rest)
 		}
+		content = lsa
 	case LinkLSAtype:
+		if lsalength < 44 {
+			return nil, errors.New("Link LSA too small")
+		}
 		var prefixes []Prefix
 		var prefixOffset uint32 = 44
 		var j uint32
 		numOfPrefixes := binary.BigEndian.Uint32(data[40:44])
 		for j = 0; j < numOfPrefixes; j++ {
-			prefixLen := uint8(data[prefixOffset])
-			prefix := Prefix{
-				PrefixLength:  prefixLen,
-				PrefixOptions: uint8(data[prefixOffset+1]),
-				AddressPrefix: data[prefixOffset+4 : prefixOffset+4+uint32(prefixLen)/8],
+			prefix, n, err := decodeOSPFv3Prefix(data[prefixOffset:lsalength])
+			if err != nil {
+				return nil, err
 			}
 			prefixes = append(prefixes, prefix)
-			prefixOffset = prefixOffset + 4 + uint32(prefixLen)/8
+			prefixOffset += uint32(n)
 		}
 		content = LinkLSA{
 			RtrPriority:      uint8(data[20]),
@@ -420,20 +483,20 @@ func extractLSAInformation(lstype, lsalength uint16, data []byte) (interface{},
 			Prefixes:         prefixes,
 		}
 	case IntraAreaPrefixLSAtype:
+		if lsalength < 32 {
+			return nil, errors.New("Intra-Area-Prefix LSA too small")
+		}
 		var prefixes []Prefix
 		var prefixOffset uint32 = 32
 		var j uint16
 		numOfPrefixes := binary.BigEndian.Uint16(data[20:22])
 		for j = 0; j < numOfPrefixes; j++ {
-			prefixLen := uint8(data[prefixOffset])
-			prefix := Prefix{
-				PrefixLength:  prefixLen,
-				PrefixOptions: uint8(data[prefixOffset+1]),
-				Metric:        binary.BigEndian.Uint16(data[prefixOffset+2 : prefixOffset+4]),
-				AddressPrefix: data[prefixOffset+4 : prefixOffset+4+uint32(prefixLen)/8],
+			prefix, n, err := decodeOSPFv3Prefix(data[prefixOffset:lsalength])
+			if err != nil {
+				return nil, err
 			}
 			prefixes = append(prefixes, prefix)
-			prefixOffset = prefixOffset + 4 + uint32(prefixLen)
+			prefixOffset += uint32(n)
 		}
 		content = IntraAreaPrefixLSA{
 			NumOfPrefixes:  numOfPrefixes,
@@ -454,7 +517,9 @@ func getLSAs(num uint32, data []byte) ([]LSA, error) {
 	var i uint32 = 0
 	var offset uint32 = 0
 	for ; i < num; i++ {
-		var content interface{}
+		if len(data) < int(offset+20) {
+			return nil, errors.New("Link State header too short")
+		}
 		lstype := binary.BigEndian.Uint16(data[offset+2 : offset+4])
 		lsalength := binary.BigEndian.Uint16(data[offset+18 : offset+20])
 
@@ -518,7 +583,8 @@ func (ospf *OSPFv2) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) err
 		for i := 32; uint16(i+20) <= ospf.PacketLength; i += 20 {
 			lsa := LSAheader{
 				LSAge:       binary.BigEndian.Uint16(data[i : i+2]),
-				LSType:      binary.BigEndian.Uint16(data[i+2 : i+4]),
+				LSOptions:   data[i+2],
+				LSType:      uint16(data[i+3]),
 				LinkStateID: binary.BigEndian.Uint32(data[i+4 : i+8]),
 				AdvRouter:   binary.BigEndian.Uint32(data[i+8 : i+12]),
 				LSSeqNumber: binary.BigEndian.Uint32(data[i+12 : i+16]),
@@ -672,6 +738,271 @@ func (ospf *OSPFv3) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) err
 	return nil
 }
 
+// appendLSAheader appends an LSA header to data, whose LS type is one byte
+// long and preceded by the options with OSPFv2.
+func appendLSAheader(data []byte, h LSAheader, v2 bool) []byte {
+	data = appendUint16(data, h.LSAge)
+	if v2 {
+		data = append(data, h.LSOptions, uint8(h.LSType))
+	} else {
+		data = appendUint16(data, h.LSType)
+	}
+	data = appendUint32(appendUint32(appendUint32(data, h.LinkStateID), h.AdvRouter), h.LSSeqNumber)
+	return appendUint16(appendUint16(data, h.LSChecksum), h.Length)
+}
+
+// appendLSA appends an LSA to data.  With FixLengths, the counts of its
+// content and its length are written as required by its encoding, and
+// with ComputeChecksums its checksum, but lsa isn't updated.
+func appendLSA(data []byte, lsa LSA, v2 bool, opts gopacket.SerializeOptions) ([]byte, error) {
+	start := len(data)
+	data = appendLSAheader(data, lsa.LSAheader, v2)
+	switch c := lsa.Content.(type) {
+	case RouterLSAV2:
+		links := c.Links
+		if opts.FixLengths {
+			links = uint16(len(c.Routers))
+		}
+		data = appendUint16(append(data, c.Flags, 0), links)
+		for _, r := range c.Routers {
+			data = appendUint32(appendUint32(data, r.LinkID), r.LinkData)
+			data = appendUint16(append(data, r.Type, 0), r.Metric)
+		}
+	case NetworkLSAV2:
+		data = appendUint32(data, c.NetworkMask)
+		for _, r := range c.AttachedRouter {
+			data = appendUint32(data, r)
+		}
+	case SummaryLSAV2:
+		data = appendUint32(appendUint32(data, c.NetworkMask), c.Metric&0x00FFFFFF)
+	case ASExternalLSAV2:
+		data = appendUint32(data, c.NetworkMask)
+		data = appendUint32(data, uint32(c.ExternalBit&0x80)<<24|c.Metric&0x00FFFFFF)
+		data = appendUint32(appendUint32(data, c.ForwardingAddress), c.ExternalRouteTag)
+	case OpaqueLSA:
+		data = appendOSPFTLVs(data, c.TLVs)
+	case RouterLSA:
+		data = appendUint32(data, uint32(c.Flags)<<24|c.Options&0x00FFFFFF)
+		for _, r := range c.Routers {
+			data = appendUint16(append(data, r.Type, 0), r.Metric)
+			data = appendUint32(appendUint32(appendUint32(data, r.InterfaceID), r.NeighborInterfaceID), r.NeighborRouterID)
+		}
+	case NetworkLSA:
+		data = appendUint32(data, c.Options&0x00FFFFFF)
+		for _, r := range c.AttachedRouter {
+			data = appendUint32(data, r)
+		}
+	case InterAreaPrefixLSA:
+		data = appendUint32(data, c.Metric&0x00FFFFFF)
+		data = append(data, c.PrefixLength, c.PrefixOptions, 0, 0)
+		data = appendOSPFPrefixAddress(data, c.PrefixLength, c.AddressPrefix)
+	case InterAreaRouterLSA:
+		data = appendUint32(appendUint32(data, c.Options&0x00FFFFFF), c.Metric&0x00FFFFFF)
+		data = appendUint32(data, c.DestinationRouterID)
+	case ASExternalLSA:
+		data = appendUint32(data, uint32(c.Flags)<<24|c.Metric&0x00FFFFFF)
+		data = appendUint16(append(data, c.PrefixLength, c.PrefixOptions), c.RefLSType)
+		data = appendOSPFPrefixAddress(data, c.PrefixLength, c.AddressPrefix)
+		if (c.Flags & 0x02) == 0x02 {
+			data = appendOSPFPrefixAddress(data, 128, c.ForwardingAddress)
+		}
+		if (c.Flags & 0x01) == 0x01 {
+			data = appendUint32(data, c.ExternalRouteTag)
+		}
+		if c.RefLSType != 0 {
+			data = appendUint32(data, c.RefLinkStateID)
+		}
+	case LinkLSA:
+		num := c.NumOfPrefixes
+		if opts.FixLengths {
+			num = uint32(len(c.Prefixes))
+		}
+		data = appendUint32(data, uint32(c.RtrPriority)<<24|c.Options&0x00FFFFFF)
+		data = appendOSPFPrefixAddress(data, 128, c.LinkLocalAddress)
+		data = appendUint32(data, num)
+		for _, p := range c.Prefixes {
+			data = appendOSPFv3Prefix(data, p)
+		}
+	case IntraAreaPrefixLSA:
+		num := c.NumOfPrefixes
+		if opts.FixLengths {
+			num = uint16(len(c.Prefixes))
+		}
+		data = appendUint16(appendUint16(data, num), c.RefLSType)
+		data = appendUint32(appendUint32(data, c.RefLinkStateID), c.RefAdvRouter)
+		for _, p := range c.Prefixes {
+			data = appendOSPFv3Prefix(data, p)
+		}
+	case ExtendedLSA:
+		data = appendExtendedLSA(data, lsa.LSType, c)
+	default:
+		return nil, fmt.Errorf("Unsupported Link State content %T", lsa.Content)
+	}
+	b := data[start:]
+	if opts.FixLengths {
+		binary.BigEndian.PutUint16(b[18:20], uint16(len(b)))
+	}
+	if opts.ComputeChecksums {
+		binary.BigEndian.PutUint16(b[16:18], lsaChecksum(b))
+	}
+	return data, nil
+}
+
+// lsaChecksum returns the Fletcher checksum of an LSA, RFC 2328  12.1.7,
+// which covers all of it but the LS age.
+func lsaChecksum(lsa []byte) uint16 {
+	data := lsa[2:]
+	var c0, c1 int
+	for i, v := range data {
+		// The checksum itself is zeroed.
+		if i == 14 || i == 15 {
+			v = 0
+		}
+		c0 = (c0 + int(v)) % 255
+		c1 = (c1 + c0) % 255
+	}
+	x := ((len(data)-15)*c0 - c1) % 255
+	if x <= 0 {
+		x += 255
+	}
+	y := 510 - c0 - x
+	if y > 255 {
+		y -= 255
+	}
+	return uint16(x<<8 | y)
+}
+
+// appendContent appends the body of an OSPF packet, following its header,
+// to data.
+func (ospf *OSPF) appendContent(data []byte, v2 bool, opts gopacket.SerializeOptions) ([]byte, error) {
+	switch c := ospf.Content.(type) {
+	case HelloPkgV2:
+		data = appendUint16(appendUint32(data, c.NetworkMask), c.HelloInterval)
+		data = appendUint32(append(data, uint8(c.Options), c.RtrPriority), c.RouterDeadInterval)
+		data = appendUint32(appendUint32(data, c.DesignatedRouterID), c.BackupDesignatedRouterID)
+		for _, n := range c.NeighborID {
+			data = appendUint32(data, n)
+		}
+	case HelloPkg:
+		data = appendUint32(appendUint32(data, c.InterfaceID), uint32(c.RtrPriority)<<24|c.Options&0x00FFFFFF)
+		data = appendUint16(appendUint16(data, c.HelloInterval), uint16(c.RouterDeadInterval))
+		data = appendUint32(appendUint32(data, c.DesignatedRouterID), c.BackupDesignatedRouterID)
+		for _, n := range c.NeighborID {
+			data = appendUint32(data, n)
+		}
+	case DbDescPkg:
+		if v2 {
+			data = append(appendUint16(data, c.InterfaceMTU), uint8(c.Options), uint8(c.Flags))
+		} else {
+			data = appendUint32(data, c.Options&0x00FFFFFF)
+			data = appendUint16(appendUint16(data, c.InterfaceMTU), c.Flags)
+		}
+		data = appendUint32(data, c.DDSeqNumber)
+		for _, h := range c.LSAinfo {
+			data = appendLSAheader(data, h, v2)
+		}
+	case []LSReq:
+		for _, r := range c {
+			data = appendUint32(appendUint32(appendUint32(data, uint32(r.LSType)), r.LSID), r.AdvRouter)
+		}
+	case LSUpdate:
+		num := c.NumOfLSAs
+		if opts.FixLengths {
+			num = uint32(len(c.LSAs))
+		}
+		data = appendUint32(data, num)
+		for _, lsa := range c.LSAs {
+			var err error
+			if data, err = appendLSA(data, lsa, v2, opts); err != nil {
+				return nil, err
+			}
+		}
+	case []LSAheader:
+		for _, h := range c {
+			data = appendLSAheader(data, h, v2)
+		}
+	case nil:
+	default:
+		return nil, fmt.Errorf("Unsupported OSPF content %T", ospf.Content)
+	}
+	return data, nil
+}
+
+// appendHeader appends the fields of the header common to both versions to
+// data.
+func (ospf *OSPF) appendHeader(data []byte) []byte {
+	data = appendUint16(append(data, ospf.Version, uint8(ospf.Type)), ospf.PacketLength)
+	data = appendUint32(appendUint32(data, ospf.RouterID), ospf.AreaID)
+	return appendUint16(data, ospf.Checksum)
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+// See the docs for gopacket.SerializableLayer for more info.
+//
+// With FixLengths, PacketLength is set, and the LSA lengths and the counts
+// of LSUpdate, RouterLSAV2, LinkLSA and IntraAreaPrefixLSA contents are
+// written as required by their encoding, but Content isn't updated.  The
+// same goes for the checksums of LSAs with ComputeChecksums.  The packet's
+// checksum excludes the authentication field, and isn't computed with
+// cryptographic authentication, see RFC 2328  D.4.
+func (ospf *OSPFv2) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	data := ospf.appendHeader(nil)
+	data = appendUint64(appendUint16(data, ospf.AuType), ospf.Authentication)
+	data, err := ospf.appendContent(data, true, opts)
+	if err != nil {
+		return err
+	}
+	if opts.FixLengths {
+		ospf.PacketLength = uint16(len(data))
+		binary.BigEndian.PutUint16(data[2:4], ospf.PacketLength)
+	}
+	if opts.ComputeChecksums && ospf.AuType != 2 {
+		data[12], data[13] = 0, 0
+		ospf.Checksum = tcpipChecksum(data[24:], tcpipSum(data[:16], 0))
+		binary.BigEndian.PutUint16(data[12:14], ospf.Checksum)
+	}
+	bytes, err := b.PrependBytes(len(data))
+	if err != nil {
+		return err
+	}
+	copy(bytes, data)
+	return nil
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+// See the docs for gopacket.SerializableLayer for more info.
+//
+// Lengths are fixed as with OSPFv2.  Computing checksums requires
+// SetNetworkLayerForChecksum to have been called, as with TCP.
+func (ospf *OSPFv3) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	data := append(ospf.appendHeader(nil), ospf.Instance, ospf.Reserved)
+	data, err := ospf.appendContent(data, false, opts)
+	if err != nil {
+		return err
+	}
+	if opts.FixLengths {
+		ospf.PacketLength = uint16(len(data))
+		binary.BigEndian.PutUint16(data[2:4], ospf.PacketLength)
+	}
+	if opts.ComputeChecksums {
+		data[12], data[13] = 0, 0
+		csum, err := ospf.computeChecksum(data, IPProtocolOSPF)
+		if err != nil {
+			return err
+		}
+		ospf.Checksum = csum
+		binary.BigEndian.PutUint16(data[12:14], ospf.Checksum)
+	}
+	bytes, err := b.PrependBytes(len(data))
+	if err != nil {
+		return err
+	}
+	copy(bytes, data)
+	return nil
+}
+
 // LayerType returns LayerTypeOSPF
 func (ospf *OSPFv2) LayerType() gopacket.LayerType {
 	return LayerTypeOSPF
@@ -7,6 +7,8 @@
 package layers
 
 import (
+	"bytes"
+	"net"
 	"reflect"
 	"testing"
 
@@ -1215,3 +1217,277 @@ func BenchmarkDecodePacketPacket4(b *testing.B) {
 		gopacket.NewPacket(testPacketOSPF3LSAck, LinkTypeEthernet, gopacket.NoCopy)
 	}
 }
+
+func TestOSPFSerializeRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data []byte
+		// fix is whether the packet's length and checksum are valid, so
+		// fixing them doesn't change it.
+		fix bool
+	}{
+		{"OSPF2Hello", testPacketOSPF2Hello, true},
+		{"OSPF3Hello", testPacketOSPF3Hello, true},
+		{"OSPF2DBDesc", testPacketOSPF2DBDesc, true},
+		{"OSPF3DBDesc", testPacketOSPF3DBDesc, true},
+		{"OSPF2LSRequest", testPacketOSPF2LSRequest, true},
+		{"OSPF3LSRequest", testPacketOSPF3LSRequest, true},
+		{"OSPF2LSUpdate", testPacketOSPF2LSUpdate, true},
+		{"OSPF2LSUpdateLSA2", testPacketOSPF2LSUpdateLSA2, false},
+		{"OSPF2LSUpdateLSA7", testPacketOSPF2LSUpdateLSA7, false},
+		{"OSPF3LSUpdate", testPacketOSPF3LSUpdate, true},
+		{"OSPF2LSAck", testPacketOSPF2LSAck, true},
+		{"OSPF3LSAck", testPacketOSPF3LSAck, true},
+	} {
+		p := gopacket.NewPacket(test.data, LinkTypeEthernet, gopacket.Default)
+		if p.ErrorLayer() != nil {
+			t.Errorf("%s: failed to decode packet: %v", test.name, p.ErrorLayer().Error())
+			continue
+		}
+		want := p.NetworkLayer().LayerPayload()
+		layer := p.Layer(LayerTypeOSPF).(gopacket.SerializableLayer)
+		if ospf, ok := layer.(*OSPFv3); ok {
+			ospf.SetNetworkLayerForChecksum(p.NetworkLayer())
+		}
+		options := []gopacket.SerializeOptions{{}}
+		if test.fix {
+			options = append(options, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true})
+		}
+		for _, opts := range options {
+			buf := gopacket.NewSerializeBuffer()
+			if err := layer.SerializeTo(buf, opts); err != nil {
+				t.Errorf("%s: %+v: %v", test.name, opts, err)
+				continue
+			}
+			if got := buf.Bytes(); !bytes.Equal(got, want) {
+				t.Errorf("%s: %+v: serialized\n%x\nwant\n%x", test.name, opts, got, want)
+			}
+		}
+	}
+}
+
+// validLSAChecksum returns whether the Fletcher checksum of lsa is valid.
+func validLSAChecksum(lsa []byte) bool {
+	var c0, c1 int
+	for _, v := range lsa[2:] {
+		c0 = (c0 + int(v)) % 255
+		c1 = (c1 + c0) % 255
+	}
+	return c0 == 0 && c1 == 0
+}
+
+func TestOSPF2SerializeOpaqueLSAs(t *testing.T) {
+	teLink := OSPFTELink{
+		LinkType:                  1,
+		LinkID:                    net.IPv4(192, 0, 2, 2).To4(),
+		LocalAddresses:            []net.IP{net.IPv4(198, 51, 100, 1).To4()},
+		RemoteAddresses:           []net.IP{net.IPv4(198, 51, 100, 2).To4()},
+		HasMetric:                 true,
+		Metric:                    10,
+		HasMaxBandwidth:           true,
+		MaxBandwidth:              1.25e9,
+		HasMaxReservableBandwidth: true,
+		MaxReservableBandwidth:    1e9,
+		HasUnreservedBandwidth:    true,
+		UnreservedBandwidth:       [8]float32{1e9, 1e9, 1e9, 1e9, 5e8, 5e8, 5e8, 5e8},
+		HasAdminGroup:             true,
+		AdminGroup:                0x5,
+	}
+	lsas := []LSA{
+		{
+			LSAheader: LSAheader{LSAge: 1, LSOptions: 0x22, LSType: SummaryLSANetworktypeV2, LinkStateID: 0x0a010000, AdvRouter: 0xc0000201, LSSeqNumber: 0x80000001},
+			Content:   SummaryLSAV2{NetworkMask: 0xffff0000, Metric: 20},
+		},
+		{
+			LSAheader: LSAheader{LSAge: 1, LSOptions: 0x62, LSType: OpaqueAreaLSAtypeV2, LinkStateID: OSPFOpaqueTypeTE<<24 | 1, AdvRouter: 0xc0000201, LSSeqNumber: 0x80000001},
+			Content:   OpaqueLSA{TLVs: []OSPFTLV{NewOSPFTELinkTLV(teLink)}},
+		},
+		{
+			LSAheader: LSAheader{LSAge: 1, LSOptions: 0x62, LSType: OpaqueAreaLSAtypeV2, LinkStateID: OSPFOpaqueTypeTE << 24, AdvRouter: 0xc0000201, LSSeqNumber: 0x80000001},
+			Content:   OpaqueLSA{TLVs: []OSPFTLV{NewOSPFTERouterAddressTLV(net.IPv4(192, 0, 2, 1))}},
+		},
+	}
+	ospf := &OSPFv2{OSPF: OSPF{
+		Version:  2,
+		Type:     OSPFLinkStateUpdate,
+		RouterID: 0xc0000201,
+		Content:  LSUpdate{LSAs: lsas},
+	}}
+	buf := gopacket.NewSerializeBuffer()
+	if err := ospf.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if tcpipChecksum(data[24:], tcpipSum(data[:16], 0)) != 0 {
+		t.Error("invalid packet checksum")
+	}
+
+	got := &OSPFv2{}
+	if err := got.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if got.PacketLength != uint16(len(data)) || got.PacketLength != ospf.PacketLength {
+		t.Errorf("got packet length %d, want %d", got.PacketLength, len(data))
+	}
+	update := got.Content.(LSUpdate)
+	if update.NumOfLSAs != uint32(len(lsas)) || len(update.LSAs) != len(lsas) {
+		t.Fatalf("got %d LSAs, want %d", len(update.LSAs), len(lsas))
+	}
+	offset := 28
+	for i, lsa := range update.LSAs {
+		if !validLSAChecksum(data[offset : offset+int(lsa.Length)]) {
+			t.Errorf("LSA %d: invalid checksum %#x", i, lsa.LSChecksum)
+		}
+		offset += int(lsa.Length)
+		lsa.LSChecksum, lsa.Length = 0, 0
+		if !reflect.DeepEqual(lsa, lsas[i]) {
+			t.Errorf("LSA %d: got\n%#v\nwant\n%#v", i, lsa, lsas[i])
+		}
+	}
+
+	if l, ok := update.LSAs[1].Content.(OpaqueLSA).TLVs[0].TELink(); !ok || !reflect.DeepEqual(l, teLink) {
+		t.Errorf("got TE link %+v, %v", l, ok)
+	}
+	if ip, ok := update.LSAs[2].Content.(OpaqueLSA).TLVs[0].TERouterAddress(); !ok || !ip.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Errorf("got TE router address %v, %v", ip, ok)
+	}
+}
+
+func TestOSPF3SerializeLSAs(t *testing.T) {
+	routerLink := Router{Type: 1, Metric: 10, InterfaceID: 5, NeighborInterfaceID: 6, NeighborRouterID: 0x02020202}
+	prefix := OSPFExtendedPrefix{
+		Metric: 10,
+		Prefix: Prefix{PrefixLength: 56, AddressPrefix: []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0x12, 0x34}},
+	}
+	external := OSPFExtendedPrefix{
+		Flags:   0x01,
+		Metric:  100,
+		Prefix:  Prefix{PrefixLength: 48, AddressPrefix: []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0x99}},
+		SubTLVs: []OSPFTLV{{Type: OSPFv3SubTLVRouteTag, Value: []byte{0, 0, 0, 7}}},
+	}
+	header := func(lstype uint16, id uint32) LSAheader {
+		return LSAheader{LSAge: 1, LSType: lstype, LinkStateID: id, AdvRouter: 0x01010101, LSSeqNumber: 0x80000001}
+	}
+	lsas := []LSA{
+		{
+			LSAheader: header(ExtendedRouterLSAtype, 0),
+			Content:   ExtendedLSA{Flags: 0x01, Options: 0x133, TLVs: []OSPFTLV{NewOSPFRouterLinkTLV(routerLink)}},
+		},
+		{
+			LSAheader: header(ExtendedNetworkLSAtype, 5),
+			Content:   ExtendedLSA{Options: 0x33, TLVs: []OSPFTLV{NewOSPFAttachedRoutersTLV([]uint32{0x01010101, 0x02020202})}},
+		},
+		{
+			LSAheader: header(ExtendedIntraAreaPrefixLSAtype, 0),
+			Content: ExtendedLSA{
+				RefLSType:    ExtendedRouterLSAtype,
+				RefAdvRouter: 0x01010101,
+				TLVs:         []OSPFTLV{NewOSPFExtendedPrefixTLV(OSPFv3TLVIntraAreaPrefix, prefix)},
+			},
+		},
+		{
+			LSAheader: header(ExtendedASExternalLSAtype, 1),
+			Content:   ExtendedLSA{TLVs: []OSPFTLV{NewOSPFExtendedPrefixTLV(OSPFv3TLVExternalPrefix, external)}},
+		},
+		{
+			LSAheader: header(ASExternalLSAtype, 2),
+			Content: ASExternalLSA{
+				Flags:             0x03,
+				Metric:            20,
+				PrefixLength:      60,
+				RefLSType:         0x2001,
+				AddressPrefix:     []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0x10},
+				ForwardingAddress: net.ParseIP("2001:db8::1"),
+				ExternalRouteTag:  7,
+				RefLinkStateID:    9,
+			},
+		},
+		{
+			LSAheader: header(IntraAreaPrefixLSAtype, 0),
+			Content: IntraAreaPrefixLSA{
+				NumOfPrefixes: 2,
+				RefLSType:     RouterLSAtype,
+				RefAdvRouter:  0x01010101,
+				Prefixes: []Prefix{
+					{PrefixLength: 48, Metric: 10, AddressPrefix: []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0x01}},
+					{PrefixLength: 128, PrefixOptions: 0x02, AddressPrefix: net.ParseIP("2001:db8::2")},
+				},
+			},
+		},
+		{
+			LSAheader: header(LinkLSAtype, 5),
+			Content: LinkLSA{
+				RtrPriority:      1,
+				Options:          0x33,
+				LinkLocalAddress: net.ParseIP("fe80::1"),
+				NumOfPrefixes:    2,
+				Prefixes: []Prefix{
+					{PrefixLength: 64, AddressPrefix: []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0x12}},
+					{PrefixLength: 0, AddressPrefix: []byte{}},
+				},
+			},
+		},
+	}
+	ip6 := &IPv6{
+		Version:    6,
+		NextHeader: IPProtocolOSPF,
+		HopLimit:   1,
+		SrcIP:      net.ParseIP("fe80::1"),
+		DstIP:      net.ParseIP("ff02::5"),
+	}
+	ospf := &OSPFv3{OSPF: OSPF{
+		Version:  3,
+		Type:     OSPFLinkStateUpdate,
+		RouterID: 0x01010101,
+		Content:  LSUpdate{LSAs: lsas},
+	}}
+	ospf.SetNetworkLayerForChecksum(ip6)
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ip6, ospf); err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), LayerTypeIPv6, gopacket.Default)
+	if p.ErrorLayer() != nil {
+		t.Fatal("Failed to decode packet:", p.ErrorLayer().Error())
+	}
+	checkLayers(p, []gopacket.LayerType{LayerTypeIPv6, LayerTypeOSPF}, t)
+	got := p.Layer(LayerTypeOSPF).(*OSPFv3)
+	data := p.NetworkLayer().LayerPayload()
+	got.SetNetworkLayerForChecksum(p.NetworkLayer())
+	if csum, err := got.computeChecksum(data, IPProtocolOSPF); err != nil || csum != 0 {
+		t.Errorf("invalid packet checksum %#x: %v", got.Checksum, err)
+	}
+
+	update := got.Content.(LSUpdate)
+	if len(update.LSAs) != len(lsas) {
+		t.Fatalf("got %d LSAs, want %d", len(update.LSAs), len(lsas))
+	}
+	offset := 20
+	for i, lsa := range update.LSAs {
+		if !validLSAChecksum(data[offset : offset+int(lsa.Length)]) {
+			t.Errorf("LSA %d: invalid checksum %#x", i, lsa.LSChecksum)
+		}
+		offset += int(lsa.Length)
+		lsa.LSChecksum, lsa.Length = 0, 0
+		if !reflect.DeepEqual(lsa, lsas[i]) {
+			t.Errorf("LSA %d: got\n%#v\nwant\n%#v", i, lsa, lsas[i])
+		}
+	}
+
+	tlvs := func(i int) []OSPFTLV { return update.LSAs[i].Content.(ExtendedLSA).TLVs }
+	if r, ok := tlvs(0)[0].RouterLink(); !ok || r != routerLink {
+		t.Errorf("got router link %+v, %v", r, ok)
+	}
+	if ids, ok := tlvs(1)[0].AttachedRouters(); !ok || !reflect.DeepEqual(ids, []uint32{0x01010101, 0x02020202}) {
+		t.Errorf("got attached routers %v, %v", ids, ok)
+	}
+	if p, ok := tlvs(2)[0].ExtendedPrefix(); !ok || !reflect.DeepEqual(p, prefix) {
+		t.Errorf("got intra-area prefix %+v, %v", p, ok)
+	}
+	if p, ok := tlvs(3)[0].ExtendedPrefix(); !ok || !reflect.DeepEqual(p, external) {
+		t.Errorf("got external prefix %+v, %v", p, ok)
+	}
+	if _, ok := tlvs(3)[0].RouterLink(); ok {
+		t.Error("external prefix TLV decoded as router link")
+	}
+}
@@ -0,0 +1,466 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+)
+
+// SummaryLSAV2 is the struct from RFC 2328  A.4.4, of network and ASBR
+// summary LSAs.
+type SummaryLSAV2 struct {
+	NetworkMask uint32
+	Metric      uint32
+}
+
+// Opaque types, the most significant byte of the link state ID of opaque
+// LSAs.
+const (
+	OSPFOpaqueTypeTE                = 1 // RFC 3630
+	OSPFOpaqueTypeGrace             = 3 // RFC 3623
+	OSPFOpaqueTypeRouterInformation = 4 // RFC 7770
+	OSPFOpaqueTypeExtendedPrefix    = 7 // RFC 7684
+	OSPFOpaqueTypeExtendedLink      = 8 // RFC 7684
+)
+
+// OpaqueLSA is the struct from RFC 5250  A.2, of opaque LSAs whose
+// information is a list of TLVs, as with all the opaque types above.  The
+// opaque type and ID are in the link state ID of the LSA header.
+type OpaqueLSA struct {
+	TLVs []OSPFTLV
+}
+
+// ExtendedLSA is the struct from RFC 8362  4, of the OSPFv3 extended LSAs.
+// Flags and Options are set for E-Router-LSAs, Options for
+// E-Network-LSAs, RtrPriority and Options for E-Link-LSAs, and the
+// referenced LSA for E-Intra-Area-Prefix-LSAs.  Other extended LSAs only
+// hold TLVs.
+type ExtendedLSA struct {
+	Flags          uint8
+	RtrPriority    uint8
+	Options        uint32
+	RefLSType      uint16
+	RefLinkStateID uint32
+	RefAdvRouter   uint32
+	TLVs           []OSPFTLV
+}
+
+// decodeExtendedLSA decodes the body of an extended LSA of type lstype,
+// following its header.
+func decodeExtendedLSA(lstype uint16, body []byte) (ExtendedLSA, error) {
+	var lsa ExtendedLSA
+	n := 0
+	switch lstype {
+	case ExtendedRouterLSAtype, ExtendedNetworkLSAtype, ExtendedLinkLSAtype:
+		n = 4
+	case ExtendedIntraAreaPrefixLSAtype:
+		n = 12
+	}
+	if len(body) < n {
+		return ExtendedLSA{}, errors.New("extended LSA too short")
+	}
+	switch lstype {
+	case ExtendedRouterLSAtype:
+		lsa.Flags = body[0]
+		lsa.Options = binary.BigEndian.Uint32(body) & 0x00FFFFFF
+	case ExtendedNetworkLSAtype:
+		lsa.Options = binary.BigEndian.Uint32(body) & 0x00FFFFFF
+	case ExtendedLinkLSAtype:
+		lsa.RtrPriority = body[0]
+		lsa.Options = binary.BigEndian.Uint32(body) & 0x00FFFFFF
+	case ExtendedIntraAreaPrefixLSAtype:
+		lsa.RefLSType = binary.BigEndian.Uint16(body[2:4])
+		lsa.RefLinkStateID = binary.BigEndian.Uint32(body[4:8])
+		lsa.RefAdvRouter = binary.BigEndian.Uint32(body[8:12])
+	}
+	var err error
+	if lsa.TLVs, err = decodeOSPFTLVs(body[n:]); err != nil {
+		return ExtendedLSA{}, err
+	}
+	return lsa, nil
+}
+
+// appendExtendedLSA appends the body of an extended LSA of type lstype to
+// data.
+func appendExtendedLSA(data []byte, lstype uint16, lsa ExtendedLSA) []byte {
+	options := lsa.Options & 0x00FFFFFF
+	switch lstype {
+	case ExtendedRouterLSAtype:
+		data = appendUint32(data, uint32(lsa.Flags)<<24|options)
+	case ExtendedNetworkLSAtype:
+		data = appendUint32(data, options)
+	case ExtendedLinkLSAtype:
+		data = appendUint32(data, uint32(lsa.RtrPriority)<<24|options)
+	case ExtendedIntraAreaPrefixLSAtype:
+		data = appendUint16(append(data, 0, 0), lsa.RefLSType)
+		data = appendUint32(appendUint32(data, lsa.RefLinkStateID), lsa.RefAdvRouter)
+	}
+	return appendOSPFTLVs(data, lsa.TLVs)
+}
+
+// OSPFTLV is a TLV of opaque LSAs and OSPFv3 extended LSAs.  Its typed
+// accessors, which don't know the LSA holding it, return false if the TLV
+// isn't of their type or if its value is invalid for it.  The values they
+// return point into the TLV's value.
+type OSPFTLV struct {
+	Type  uint16
+	Value []byte
+}
+
+// decodeOSPFTLVs decodes TLVs, whose values are padded to 4 bytes.
+func decodeOSPFTLVs(data []byte) ([]OSPFTLV, error) {
+	var tlvs []OSPFTLV
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, errors.New("OSPF TLV too short")
+		}
+		n := int(binary.BigEndian.Uint16(data[2:4]))
+		if len(data) < 4+n {
+			return nil, errors.New("OSPF TLV length exceeds its data")
+		}
+		tlvs = append(tlvs, OSPFTLV{Type: binary.BigEndian.Uint16(data), Value: data[4 : 4+n]})
+		n = (n + 3) &^ 3
+		if n > len(data)-4 {
+			n = len(data) - 4
+		}
+		data = data[4+n:]
+	}
+	return tlvs, nil
+}
+
+// appendOSPFTLVs appends TLVs to data, padding their values.
+func appendOSPFTLVs(data []byte, tlvs []OSPFTLV) []byte {
+	for _, t := range tlvs {
+		data = appendUint16(appendUint16(data, t.Type), uint16(len(t.Value)))
+		data = append(data, t.Value...)
+		data = append(data, lotsOfZeros[:(4-len(t.Value)%4)%4]...)
+	}
+	return data
+}
+
+// TE TLV types and link sub-TLV types, see RFC 3630.
+const (
+	OSPFTETLVRouterAddress = 1
+	OSPFTETLVLink          = 2
+
+	OSPFTELinkType                   = 1
+	OSPFTELinkID                     = 2
+	OSPFTELinkLocalAddress           = 3
+	OSPFTELinkRemoteAddress          = 4
+	OSPFTELinkMetric                 = 5
+	OSPFTELinkMaxBandwidth           = 6
+	OSPFTELinkMaxReservableBandwidth = 7
+	OSPFTELinkUnreservedBandwidth    = 8
+	OSPFTELinkAdminGroup             = 9
+)
+
+// TERouterAddress returns the address of a TE router address TLV.
+func (t OSPFTLV) TERouterAddress() (net.IP, bool) {
+	if t.Type != OSPFTETLVRouterAddress || len(t.Value) != 4 {
+		return nil, false
+	}
+	return net.IP(t.Value), true
+}
+
+// NewOSPFTERouterAddressTLV returns a TE router address TLV.
+func NewOSPFTERouterAddressTLV(ip net.IP) OSPFTLV {
+	return OSPFTLV{Type: OSPFTETLVRouterAddress, Value: append([]byte(nil), ip.To4()...)}
+}
+
+// OSPFTELink is the content of a TE link TLV.  The link type and ID are
+// mandatory, and the other sub-TLVs are present if their Has* booleans
+// are set or their addresses not empty.  Bandwidths are in bytes per
+// second.
+type OSPFTELink struct {
+	LinkType        uint8
+	LinkID          net.IP
+	LocalAddresses  []net.IP
+	RemoteAddresses []net.IP
+
+	HasMetric                 bool
+	Metric                    uint32
+	HasMaxBandwidth           bool
+	MaxBandwidth              float32
+	HasMaxReservableBandwidth bool
+	MaxReservableBandwidth    float32
+	HasUnreservedBandwidth    bool
+	UnreservedBandwidth       [8]float32
+	HasAdminGroup             bool
+	AdminGroup                uint32
+}
+
+// TELink returns the content of a TE link TLV.  Unknown sub-TLVs are
+// ignored.
+func (t OSPFTLV) TELink() (OSPFTELink, bool) {
+	if t.Type != OSPFTETLVLink {
+		return OSPFTELink{}, false
+	}
+	subs, err := decodeOSPFTLVs(t.Value)
+	if err != nil {
+		return OSPFTELink{}, false
+	}
+	var l OSPFTELink
+	float := func(b []byte) float32 { return math.Float32frombits(binary.BigEndian.Uint32(b)) }
+	for _, s := range subs {
+		v := s.Value
+		ok := len(v) == 4
+		switch s.Type {
+		case OSPFTELinkType:
+			if ok = len(v) == 1; ok {
+				l.LinkType = v[0]
+			}
+		case OSPFTELinkID:
+			l.LinkID = net.IP(v)
+		case OSPFTELinkLocalAddress, OSPFTELinkRemoteAddress:
+			ok = len(v)%4 == 0
+			var ips []net.IP
+			for ; len(v) > 0; v = v[4:] {
+				ips = append(ips, net.IP(v[:4]))
+			}
+			if s.Type == OSPFTELinkLocalAddress {
+				l.LocalAddresses = ips
+			} else {
+				l.RemoteAddresses = ips
+			}
+		case OSPFTELinkMetric:
+			if ok {
+				l.HasMetric, l.Metric = true, binary.BigEndian.Uint32(v)
+			}
+		case OSPFTELinkMaxBandwidth:
+			if ok {
+				l.HasMaxBandwidth, l.MaxBandwidth = true, float(v)
+			}
+		case OSPFTELinkMaxReservableBandwidth:
+			if ok {
+				l.HasMaxReservableBandwidth, l.MaxReservableBandwidth = true, float(v)
+			}
+		case OSPFTELinkUnreservedBandwidth:
+			if ok = len(v) == 32; ok {
+				l.HasUnreservedBandwidth = true
+				for i := range l.UnreservedBandwidth {
+					l.UnreservedBandwidth[i] = float(v[4*i:])
+				}
+			}
+		case OSPFTELinkAdminGroup:
+			if ok {
+				l.HasAdminGroup, l.AdminGroup = true, binary.BigEndian.Uint32(v)
+			}
+		default:
+			ok = true
+		}
+		if !ok {
+			return OSPFTELink{}, false
+		}
+	}
+	if l.LinkID == nil {
+		return OSPFTELink{}, false
+	}
+	return l, true
+}
+
+// NewOSPFTELinkTLV returns a TE link TLV.
+func NewOSPFTELinkTLV(l OSPFTELink) OSPFTLV {
+	u32 := func(v uint32) []byte { return appendUint32(nil, v) }
+	float := func(v float32) []byte { return u32(math.Float32bits(v)) }
+	addresses := func(ips []net.IP) (v []byte) {
+		for _, ip := range ips {
+			v = append(v, ip.To4()...)
+		}
+		return
+	}
+	subs := []OSPFTLV{
+		{Type: OSPFTELinkType, Value: []byte{l.LinkType}},
+		{Type: OSPFTELinkID, Value: append([]byte(nil), l.LinkID.To4()...)},
+	}
+	if len(l.LocalAddresses) > 0 {
+		subs = append(subs, OSPFTLV{Type: OSPFTELinkLocalAddress, Value: addresses(l.LocalAddresses)})
+	}
+	if len(l.RemoteAddresses) > 0 {
+		subs = append(subs, OSPFTLV{Type: OSPFTELinkRemoteAddress, Value: addresses(l.RemoteAddresses)})
+	}
+	if l.HasMetric {
+		subs = append(subs, OSPFTLV{Type: OSPFTELinkMetric, Value: u32(l.Metric)})
+	}
+	if l.HasMaxBandwidth {
+		subs = append(subs, OSPFTLV{Type: OSPFTELinkMaxBandwidth, Value: float(l.MaxBandwidth)})
+	}
+	if l.HasMaxReservableBandwidth {
+		subs = append(subs, OSPFTLV{Type: OSPFTELinkMaxReservableBandwidth, Value: float(l.MaxReservableBandwidth)})
+	}
+	if l.HasUnreservedBandwidth {
+		var v []byte
+		for _, b := range l.UnreservedBandwidth {
+			v = append(v, float(b)...)
+		}
+		subs = append(subs, OSPFTLV{Type: OSPFTELinkUnreservedBandwidth, Value: v})
+	}
+	if l.HasAdminGroup {
+		subs = append(subs, OSPFTLV{Type: OSPFTELinkAdminGroup, Value: u32(l.AdminGroup)})
+	}
+	return OSPFTLV{Type: OSPFTETLVLink, Value: appendOSPFTLVs(nil, subs)}
+}
+
+// OSPFv3 extended LSA TLV types, see RFC 8362  3.
+const (
+	OSPFv3TLVRouterLink       = 1
+	OSPFv3TLVAttachedRouters  = 2
+	OSPFv3TLVInterAreaPrefix  = 3
+	OSPFv3TLVInterAreaRouter  = 4
+	OSPFv3TLVExternalPrefix   = 5
+	OSPFv3TLVIntraAreaPrefix  = 6
+	OSPFv3TLVIPv6LinkLocal    = 7
+	OSPFv3TLVIPv4LinkLocal    = 8
+	OSPFv3SubTLVIPv6Forward   = 1
+	OSPFv3SubTLVIPv4Forward   = 2
+	OSPFv3SubTLVRouteTag      = 3
+	ospfv3RouterLinkTLVLength = 16
+)
+
+// RouterLink returns the link of a router-link TLV.  Its sub-TLVs are
+// ignored.
+func (t OSPFTLV) RouterLink() (Router, bool) {
+	v := t.Value
+	if t.Type != OSPFv3TLVRouterLink || len(v) < ospfv3RouterLinkTLVLength {
+		return Router{}, false
+	}
+	return Router{
+		Type:                v[0],
+		Metric:              binary.BigEndian.Uint16(v[2:4]),
+		InterfaceID:         binary.BigEndian.Uint32(v[4:8]),
+		NeighborInterfaceID: binary.BigEndian.Uint32(v[8:12]),
+		NeighborRouterID:    binary.BigEndian.Uint32(v[12:16]),
+	}, true
+}
+
+// NewOSPFRouterLinkTLV returns a router-link TLV.
+func NewOSPFRouterLinkTLV(r Router) OSPFTLV {
+	v := appendUint16([]byte{r.Type, 0}, r.Metric)
+	v = appendUint32(appendUint32(appendUint32(v, r.InterfaceID), r.NeighborInterfaceID), r.NeighborRouterID)
+	return OSPFTLV{Type: OSPFv3TLVRouterLink, Value: v}
+}
+
+// AttachedRouters returns the router IDs of an attached-routers TLV.
+func (t OSPFTLV) AttachedRouters() ([]uint32, bool) {
+	if t.Type != OSPFv3TLVAttachedRouters || len(t.Value)%4 != 0 {
+		return nil, false
+	}
+	var ids []uint32
+	for v := t.Value; len(v) > 0; v = v[4:] {
+		ids = append(ids, binary.BigEndian.Uint32(v))
+	}
+	return ids, true
+}
+
+// NewOSPFAttachedRoutersTLV returns an attached-routers TLV.
+func NewOSPFAttachedRoutersTLV(ids []uint32) OSPFTLV {
+	var v []byte
+	for _, id := range ids {
+		v = appendUint32(v, id)
+	}
+	return OSPFTLV{Type: OSPFv3TLVAttachedRouters, Value: v}
+}
+
+// OSPFExtendedPrefix is the content of inter-area-prefix, external-prefix
+// and intra-area-prefix TLVs.  Flags are the ones of external prefixes,
+// and Metric is 16 bits long for intra-area prefixes, 24 bits otherwise.
+// SubTLVs follow the prefix, padded to 4 bytes.
+type OSPFExtendedPrefix struct {
+	Flags  uint8
+	Metric uint32
+	Prefix
+	SubTLVs []OSPFTLV
+}
+
+// ExtendedPrefix returns the content of an inter-area-prefix,
+// external-prefix or intra-area-prefix TLV.
+func (t OSPFTLV) ExtendedPrefix() (OSPFExtendedPrefix, bool) {
+	v := t.Value
+	switch t.Type {
+	case OSPFv3TLVInterAreaPrefix, OSPFv3TLVExternalPrefix, OSPFv3TLVIntraAreaPrefix:
+	default:
+		return OSPFExtendedPrefix{}, false
+	}
+	if len(v) < 4 {
+		return OSPFExtendedPrefix{}, false
+	}
+	var p OSPFExtendedPrefix
+	if t.Type == OSPFv3TLVIntraAreaPrefix {
+		p.Metric = uint32(binary.BigEndian.Uint16(v[2:4]))
+	} else {
+		p.Metric = binary.BigEndian.Uint32(v) & 0x00FFFFFF
+	}
+	if t.Type == OSPFv3TLVExternalPrefix {
+		p.Flags = v[0]
+	}
+	prefix, n, err := decodeOSPFv3Prefix(v[4:])
+	if err != nil {
+		return OSPFExtendedPrefix{}, false
+	}
+	// The prefix's metric field is reserved in these TLVs.
+	prefix.Metric = 0
+	p.Prefix = prefix
+	if p.SubTLVs, err = decodeOSPFTLVs(v[4+n:]); err != nil {
+		return OSPFExtendedPrefix{}, false
+	}
+	return p, true
+}
+
+// NewOSPFExtendedPrefixTLV returns an inter-area-prefix, external-prefix
+// or intra-area-prefix TLV, depending on tlvType.
+func NewOSPFExtendedPrefixTLV(tlvType uint16, p OSPFExtendedPrefix) OSPFTLV {
+	var v []byte
+	if tlvType == OSPFv3TLVIntraAreaPrefix {
+		v = appendUint16([]byte{0, 0}, uint16(p.Metric))
+	} else {
+		v = appendUint32(nil, p.Metric&0x00FFFFFF)
+		if tlvType == OSPFv3TLVExternalPrefix {
+			v[0] = p.Flags
+		}
+	}
+	prefix := p.Prefix
+	prefix.Metric = 0
+	v = appendOSPFv3Prefix(v, prefix)
+	return OSPFTLV{Type: tlvType, Value: appendOSPFTLVs(v, p.SubTLVs)}
+}
+
+// decodeOSPFv3Prefix decodes a prefix of OSPFv3 LSAs, and returns its
+// length, the address being padded to 4 bytes.
+func decodeOSPFv3Prefix(data []byte) (Prefix, int, error) {
+	if len(data) < 4 {
+		return Prefix{}, 0, errors.New("OSPFv3 prefix too short")
+	}
+	bits := int(data[0])
+	n := 4 + (bits+31)/32*4
+	if bits > 128 || len(data) < n {
+		return Prefix{}, 0, errors.New("invalid OSPFv3 prefix length")
+	}
+	return Prefix{
+		PrefixLength:  data[0],
+		PrefixOptions: data[1],
+		Metric:        binary.BigEndian.Uint16(data[2:4]),
+		AddressPrefix: data[4 : 4+(bits+7)/8],
+	}, n, nil
+}
+
+// appendOSPFv3Prefix appends a prefix of OSPFv3 LSAs to data.
+func appendOSPFv3Prefix(data []byte, p Prefix) []byte {
+	data = appendUint16(append(data, p.PrefixLength, p.PrefixOptions), p.Metric)
+	return appendOSPFPrefixAddress(data, p.PrefixLength, p.AddressPrefix)
+}
+
+// appendOSPFPrefixAddress appends the address of a prefix of length bits,
+// padded to 4 bytes.
+func appendOSPFPrefixAddress(data []byte, bits uint8, address []byte) []byte {
+	n := (int(bits) + 31) / 32 * 4
+	start := len(data)
+	data = append(data, lotsOfZeros[:n]...)
+	copy(data[start:], address)
+	return data
+}
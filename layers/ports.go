@@ -58,6 +58,8 @@ func (a TCPPort) LayerType() gopacket.LayerType {
 	switch a {
 	case 53:
 		return LayerTypeDNS
+	case 179:
+		return LayerTypeBGP
 	case 443: // https
 		return LayerTypeTLS
 	case 502: // modbustcp
@@ -121,6 +123,10 @@ func (a UDPPort) LayerType() gopacket.LayerType {
 		return LayerTypeDHCPv4
 	case 123:
 		return LayerTypeNTP
+	case 137:
+		return LayerTypeNBNS
+	case 138:
+		return LayerTypeNBDS
 	case 546:
 		return LayerTypeDHCPv6
 	case 547:
@@ -129,14 +135,22 @@ func (a UDPPort) LayerType() gopacket.LayerType {
 		return LayerTypeRMCP
 	case 1812:
 		return LayerTypeRADIUS
+	case 2055:
+		return LayerTypeNetFlowV9
 	case 2152:
 		return LayerTypeGTPv1U
 	case 3784:
 		return LayerTypeBFD
+	case 4739:
+		return LayerTypeIPFIX
 	case 4789:
 		return LayerTypeVXLAN
 	case 5060:
 		return LayerTypeSIP
+	case 5353:
+		return LayerTypeMDNS
+	case 5355:
+		return LayerTypeLLMNR
 	case 6081:
 		return LayerTypeGeneve
 	case 6343:
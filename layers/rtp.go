@@ -0,0 +1,107 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/google/gopacket"
+)
+
+// RTP is the header of a Real-time Transport Protocol packet, see RFC 3550.
+// Its payload is the media, padding excluded.  RTP has no well-known ports,
+// so UDP payloads are only decoded as RTP through RTPHeuristic, or with
+// RegisterUDPPortLayerType.
+type RTP struct {
+	BaseLayer
+	Version        uint8
+	Padding        bool
+	Extension      bool
+	Marker         bool
+	PayloadType    uint8
+	SequenceNumber uint16
+	Timestamp      uint32
+	SSRC           uint32
+	CSRCs          []uint32
+	// ExtensionProfile and ExtensionData are the header extension, if
+	// Extension is set.
+	ExtensionProfile uint16
+	ExtensionData    []byte
+}
+
+const rtpHeaderLength = 12
+
+// LayerType returns LayerTypeRTP.
+func (r *RTP) LayerType() gopacket.LayerType { return LayerTypeRTP }
+
+// CanDecode returns the set of layer types that this DecodingLayer can decode.
+func (r *RTP) CanDecode() gopacket.LayerClass { return LayerTypeRTP }
+
+// NextLayerType returns gopacket.LayerTypePayload.
+func (r *RTP) NextLayerType() gopacket.LayerType { return gopacket.LayerTypePayload }
+
+// DecodeFromBytes decodes the given bytes into this layer.
+func (r *RTP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < rtpHeaderLength {
+		df.SetTruncated()
+		return errors.New("RTP packet too short")
+	}
+	r.Version = data[0] >> 6
+	if r.Version != 2 {
+		return fmt.Errorf("invalid RTP version %d", r.Version)
+	}
+	r.Padding = data[0]&0x20 != 0
+	r.Extension = data[0]&0x10 != 0
+	r.Marker = data[1]&0x80 != 0
+	r.PayloadType = data[1] & 0x7f
+	r.SequenceNumber = binary.BigEndian.Uint16(data[2:4])
+	r.Timestamp = binary.BigEndian.Uint32(data[4:8])
+	r.SSRC = binary.BigEndian.Uint32(data[8:12])
+
+	n := rtpHeaderLength + 4*int(data[0]&0x0f)
+	if len(data) < n {
+		df.SetTruncated()
+		return errors.New("RTP CSRC list truncated")
+	}
+	r.CSRCs = r.CSRCs[:0]
+	for i := rtpHeaderLength; i < n; i += 4 {
+		r.CSRCs = append(r.CSRCs, binary.BigEndian.Uint32(data[i:]))
+	}
+	r.ExtensionProfile, r.ExtensionData = 0, nil
+	if r.Extension {
+		if len(data) < n+4 {
+			df.SetTruncated()
+			return errors.New("RTP header extension truncated")
+		}
+		r.ExtensionProfile = binary.BigEndian.Uint16(data[n:])
+		length := 4 * int(binary.BigEndian.Uint16(data[n+2:]))
+		n += 4
+		if len(data) < n+length {
+			df.SetTruncated()
+			return errors.New("RTP header extension truncated")
+		}
+		r.ExtensionData = data[n : n+length]
+		n += length
+	}
+	end := len(data)
+	if r.Padding {
+		// The last byte counts the padding bytes, itself included.
+		pad := int(data[end-1])
+		if pad == 0 || pad > end-n {
+			return fmt.Errorf("invalid RTP padding length %d", pad)
+		}
+		end -= pad
+	}
+	r.BaseLayer = BaseLayer{Contents: data[:n], Payload: data[n:end]}
+	return nil
+}
+
+func decodeRTP(data []byte, p gopacket.PacketBuilder) error {
+	return decodingLayerDecoder(&RTP{}, data, p)
+}
@@ -0,0 +1,86 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+// testRTP is a dynamic payload type RTP packet with a CSRC, a header
+// extension and 2 bytes of padding.
+var testRTP = []byte{
+	0xb1, 0xe0, 0x12, 0x34, // V=2, P, X, CC=1, M, PT=96, sequence number
+	0x00, 0x01, 0x00, 0x00, // timestamp
+	0xde, 0xad, 0xbe, 0xef, // SSRC
+	0x00, 0x00, 0x00, 0x2a, // CSRC
+	0xbe, 0xde, 0x00, 0x01, 0x10, 0xff, 0x00, 0x00, // header extension
+	'm', 'e', 'd', 'i', 'a', 0x00, 0x02,
+}
+
+func TestRTP(t *testing.T) {
+	p := gopacket.NewPacket(testRTP, LayerTypeRTP, testDecodeOptions)
+	checkLayers(p, []gopacket.LayerType{LayerTypeRTP, gopacket.LayerTypePayload}, t)
+	rtp := p.Layer(LayerTypeRTP).(*RTP)
+	want := &RTP{
+		BaseLayer:        BaseLayer{Contents: testRTP[:24], Payload: []byte("media")},
+		Version:          2,
+		Padding:          true,
+		Extension:        true,
+		Marker:           true,
+		PayloadType:      96,
+		SequenceNumber:   0x1234,
+		Timestamp:        0x10000,
+		SSRC:             0xdeadbeef,
+		CSRCs:            []uint32{42},
+		ExtensionProfile: 0xbede,
+		ExtensionData:    []byte{0x10, 0xff, 0x00, 0x00},
+	}
+	if !reflect.DeepEqual(rtp, want) {
+		t.Errorf("got %+v, want %+v", rtp, want)
+	}
+	if app := p.ApplicationLayer(); app == nil || !bytes.Equal(app.Payload(), []byte("media")) {
+		t.Errorf("got application layer %v", app)
+	}
+
+	var r RTP
+	for _, data := range [][]byte{
+		testRTP[:11],
+		testRTP[:14],
+		testRTP[:22],
+		append(append([]byte(nil), testRTP[:len(testRTP)-1]...), 9),
+	} {
+		if err := r.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err == nil {
+			t.Errorf("expected error decoding %x", data)
+		}
+	}
+}
+
+func TestHeuristicRTPDynamicPorts(t *testing.T) {
+	UDPHeuristics.Register(RTPHeuristic)
+	defer UDPHeuristics.Unregister(RTPHeuristic.Name)
+	ip := &IPv4{Version: 4, TTL: 64, Protocol: IPProtocolUDP, SrcIP: net.IP{192, 0, 2, 1}, DstIP: net.IP{192, 0, 2, 2}}
+	udp := &UDP{SrcPort: 40000, DstPort: 40002}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, ip, udp, gopacket.Payload(testRTP)); err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), LayerTypeIPv4, testDecodeOptions)
+	checkLayers(p, []gopacket.LayerType{LayerTypeIPv4, LayerTypeUDP, LayerTypeRTP, gopacket.LayerTypePayload}, t)
+
+	// An RTCP sender report.
+	rtcp := []byte{0x80, 200, 0x00, 0x06, 0xde, 0xad, 0xbe, 0xef, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	for _, data := range [][]byte{rtcp, testRTP[:24], make([]byte, 64), []byte("GET / HTTP/1.1\r\n\r\n")} {
+		if looksLikeRTP(data) {
+			t.Errorf("expected %x not to look like RTP", data)
+		}
+	}
+}
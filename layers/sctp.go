@@ -27,6 +27,24 @@ type SCTP struct {
 // LayerType returns gopacket.LayerTypeSCTP
 func (s *SCTP) LayerType() gopacket.LayerType { return LayerTypeSCTP }
 
+// Validate implements gopacket.ValidatingLayer, verifying the CRC32c
+// checksum.  Checksums are reported in the same byte order as s.Checksum.
+func (s *SCTP) Validate() (v gopacket.LayerValidation) {
+	if len(s.Contents) < 12 {
+		return
+	}
+	var zero [4]byte
+	table := crc32.MakeTable(crc32.Castagnoli)
+	crc := crc32.Update(0, table, s.Contents[:8])
+	crc = crc32.Update(crc, table, zero[:])
+	crc = crc32.Update(crc, table, s.Contents[12:])
+	crc = crc32.Update(crc, table, s.Payload)
+	var computed [4]byte
+	binary.LittleEndian.PutUint32(computed[:], crc)
+	v.SetChecksum(binary.BigEndian.Uint32(computed[:]), s.Checksum)
+	return
+}
+
 func decodeSCTP(data []byte, p gopacket.PacketBuilder) error {
 	sctp := &SCTP{}
 	err := sctp.DecodeFromBytes(data, p)
@@ -45,6 +63,12 @@ func (s *SCTP) TransportFlow() gopacket.Flow {
 	return gopacket.NewFlow(EndpointSCTPPort, s.sPort, s.dPort)
 }
 
+// FiveTupleProtocol implements gopacket.FiveTupleLayer.
+func (s *SCTP) FiveTupleProtocol() uint8 { return uint8(IPProtocolSCTP) }
+
+// FiveTupleFlow implements gopacket.FiveTupleLayer, returning TransportFlow.
+func (s *SCTP) FiveTupleFlow() (gopacket.Flow, bool) { return s.TransportFlow(), true }
+
 func decodeWithSCTPChunkTypePrefix(data []byte, p gopacket.PacketBuilder) error {
 	chunkType := SCTPChunkType(data[0])
 	return chunkType.Decode(data, p)
@@ -54,6 +54,10 @@ const (
 	TCPOptionKindCCEcho                          = 13 // obsolete
 	TCPOptionKindAltChecksum                     = 14 // len = 3, obsolete
 	TCPOptionKindAltChecksumData                 = 15 // len = n, obsolete
+	TCPOptionKindMD5                             = 19 // len = 18, obsoleted by TCP-AO
+	TCPOptionKindAuthentication                  = 29 // len = n, TCP-AO
+	TCPOptionKindMPTCP                           = 30 // len = n, Multipath TCP
+	TCPOptionKindFastOpen                        = 34 // len = 2-18, TCP Fast Open cookie
 )
 
 func (k TCPOptionKind) String() string {
@@ -90,6 +94,14 @@ func (k TCPOptionKind) String() string {
 		return "AltChecksum"
 	case TCPOptionKindAltChecksumData:
 		return "AltChecksumData"
+	case TCPOptionKindMD5:
+		return "MD5"
+	case TCPOptionKindAuthentication:
+		return "Authentication"
+	case TCPOptionKindMPTCP:
+		return "MPTCP"
+	case TCPOptionKindFastOpen:
+		return "FastOpen"
 	default:
 		return fmt.Sprintf("Unknown(%d)", k)
 	}
@@ -130,6 +142,13 @@ func (t TCPOption) String() string {
 // LayerType returns gopacket.LayerTypeTCP
 func (t *TCP) LayerType() gopacket.LayerType { return LayerTypeTCP }
 
+// Validate implements gopacket.ValidatingLayer, verifying the checksum.
+// SetNetworkLayerForChecksum must have been called for it to be verified.
+func (t *TCP) Validate() (v gopacket.LayerValidation) {
+	t.setChecksum(&v, t.Contents, t.Payload, len(t.Contents)+len(t.Payload), IPProtocolTCP, t.Checksum)
+	return
+}
+
 // SerializeTo writes the serialized form of this layer into the
 // SerializationBuffer, implementing gopacket.SerializableLayer.
 // See the docs for gopacket.SerializableLayer for more info.
@@ -306,11 +325,7 @@ func (t *TCP) CanDecode() gopacket.LayerClass {
 }
 
 func (t *TCP) NextLayerType() gopacket.LayerType {
-	lt := t.DstPort.LayerType()
-	if lt == gopacket.LayerTypePayload {
-		lt = t.SrcPort.LayerType()
-	}
-	return lt
+	return TCPHeuristics.nextLayerType(t.DstPort.LayerType(), t.SrcPort.LayerType(), t.Payload)
 }
 
 func decodeTCP(data []byte, p gopacket.PacketBuilder) error {
@@ -332,6 +347,12 @@ func (t *TCP) TransportFlow() gopacket.Flow {
 	return gopacket.NewFlow(EndpointTCPPort, t.sPort, t.dPort)
 }
 
+// FiveTupleProtocol implements gopacket.FiveTupleLayer.
+func (t *TCP) FiveTupleProtocol() uint8 { return uint8(IPProtocolTCP) }
+
+// FiveTupleFlow implements gopacket.FiveTupleLayer, returning TransportFlow.
+func (t *TCP) FiveTupleFlow() (gopacket.Flow, bool) { return t.TransportFlow(), true }
+
 // For testing only
 func (t *TCP) SetInternalPortsForTesting() {
 	t.sPort = make([]byte, 2)
@@ -101,3 +101,62 @@ func TestPacketTCPOptionDecode(t *testing.T) {
 		t.Errorf("expected options to be %#v, but got %#v", expected, tcp.Options)
 	}
 }
+
+func TestTCPTypedOptions(t *testing.T) {
+	tcp := &TCP{
+		SrcPort: 12345, DstPort: 80, SYN: true,
+		Options: []TCPOption{
+			NewTCPOptionMSS(1460),
+			NewTCPOptionSACKPermitted(),
+			NewTCPOptionTimestamps(2, 1),
+			NewTCPOptionWindowScale(7),
+			NewTCPOptionSACK(TCPSACKBlock{100, 200}),
+			NewTCPOptionFastOpen([]byte{1, 2, 3, 4}),
+		},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, tcp); err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), LayerTypeTCP, testDecodeOptions)
+	if p.ErrorLayer() != nil {
+		t.Fatal("Failed to decode packet:", p.ErrorLayer().Error())
+	}
+	opts := p.Layer(LayerTypeTCP).(*TCP).Options
+	if len(opts) < 6 {
+		t.Fatalf("got %d options", len(opts))
+	}
+	if mss, ok := opts[0].MSS(); !ok || mss != 1460 {
+		t.Errorf("got MSS %v, %v", mss, ok)
+	}
+	if opts[1].OptionType != TCPOptionKindSACKPermitted || opts[1].OptionLength != 2 {
+		t.Errorf("got %v", opts[1])
+	}
+	if v, e, ok := opts[2].Timestamps(); !ok || v != 2 || e != 1 {
+		t.Errorf("got timestamps %v/%v, %v", v, e, ok)
+	}
+	if s, ok := opts[3].WindowScale(); !ok || s != 7 {
+		t.Errorf("got window scale %v, %v", s, ok)
+	}
+	if b, ok := opts[4].SACKBlocks(); !ok || !reflect.DeepEqual(b, []TCPSACKBlock{{100, 200}}) {
+		t.Errorf("got SACK blocks %v, %v", b, ok)
+	}
+	if c, ok := opts[5].FastOpenCookie(); !ok || !reflect.DeepEqual(c, []byte{1, 2, 3, 4}) {
+		t.Errorf("got TFO cookie %v, %v", c, ok)
+	}
+	if _, ok := opts[0].WindowScale(); ok {
+		t.Error("MSS option decoded as a window scale")
+	}
+	if _, ok := NewTCPOptionFastOpen([]byte{1, 2, 3}).FastOpenCookie(); ok {
+		t.Error("decoded a TFO cookie of odd length")
+	}
+
+	a := TCPAuthentication{KeyID: 1, RNextKeyID: 2, MAC: []byte{0xaa, 0xbb, 0xcc, 0xdd}}
+	if got, ok := NewTCPOptionAuthentication(a).Authentication(); !ok || !reflect.DeepEqual(got, a) {
+		t.Errorf("got TCP-AO %+v, %v", got, ok)
+	}
+	digest := make([]byte, 16)
+	if got, ok := NewTCPOptionMD5(digest).MD5Signature(); !ok || len(got) != 16 {
+		t.Errorf("got MD5 signature %v, %v", got, ok)
+	}
+}
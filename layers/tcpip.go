@@ -69,6 +69,34 @@ func tcpipChecksum(data []byte, csum uint32) uint16 {
 	return ^uint16(csum)
 }
 
+// tcpipSum adds data, which must be of even length, to the running
+// rfc1071 sum csum, without folding or complementing it.
+func tcpipSum(data []byte, csum uint32) uint32 {
+	for i := 0; i < len(data)-1; i += 2 {
+		csum += uint32(data[i]) << 8
+		csum += uint32(data[i+1])
+	}
+	return csum
+}
+
+// setInternetChecksum verifies an rfc1071 checksum covering header (which must
+// be of even length) followed by payload, both of which include the received
+// checksum.  The passed-in csum is any initial checksum data that's already
+// been computed, such as a pseudo-header.
+func setInternetChecksum(v *gopacket.LayerValidation, header, payload []byte, csum uint32, received uint16) {
+	full := tcpipChecksum(payload, tcpipSum(header, csum))
+	if full == 0 {
+		v.SetChecksum(uint32(received), uint32(received))
+		return
+	}
+	// Subtract the received checksum back out of the sum to find what it
+	// should have been.
+	sum := uint32(^full) + uint32(^received)
+	sum = (sum >> 16) + (sum & 0xffff)
+	sum = (sum >> 16) + (sum & 0xffff)
+	v.SetChecksum(uint32(^uint16(sum)), uint32(received))
+}
+
 // computeChecksum computes a TCP or UDP checksum.  headerAndPayload is the
 // serialized TCP or UDP header plus its payload, with the checksum zero'd
 // out. headerProtocol is the IP protocol number of the upper-layer header.
@@ -87,6 +115,24 @@ func (c *tcpipchecksum) computeChecksum(headerAndPayload []byte, headerProtocol
 	return tcpipChecksum(headerAndPayload, csum), nil
 }
 
+// setChecksum verifies a TCP or UDP style checksum, which covers a
+// pseudo-header followed by header and payload.  length is the upper-layer
+// packet length to use in the pseudo-header.  If no network layer has been
+// set, the checksum is left unverified.
+func (c *tcpipchecksum) setChecksum(v *gopacket.LayerValidation, header, payload []byte, length int, headerProtocol IPProtocol, received uint16) {
+	if c.pseudoheader == nil {
+		return
+	}
+	csum, err := c.pseudoheader.pseudoheaderChecksum()
+	if err != nil {
+		return
+	}
+	csum += uint32(headerProtocol)
+	csum += uint32(length) & 0xffff
+	csum += uint32(length) >> 16
+	setInternetChecksum(v, header, payload, csum, received)
+}
+
 // SetNetworkLayerForChecksum tells this layer which network layer is wrapping it.
 // This is needed for computing the checksum when serializing, since TCP/IP transport
 // layer checksums depends on fields in the IPv4 or IPv6 layer that contains it.
@@ -98,6 +144,7 @@ func (i *tcpipchecksum) SetNetworkLayerForChecksum(l gopacket.NetworkLayer) erro
 	case *IPv6:
 		i.pseudoheader = v
 	default:
+		i.pseudoheader = nil
 		return fmt.Errorf("cannot use layer type %v for tcp checksum network layer", l.LayerType())
 	}
 	return nil
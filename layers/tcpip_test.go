@@ -7,6 +7,7 @@
 package layers
 
 import (
+	"encoding/binary"
 	"github.com/google/gopacket"
 	"net"
 	"testing"
@@ -183,3 +184,226 @@ func TestIPv6JumbogramUDPChecksum(t *testing.T) {
 		t.Errorf("Bad checksum:\ngot:\n%#v\n\nwant:\n%#v\n\n", got, want)
 	}
 }
+
+var testVerifyChecksumsOptions = gopacket.DecodeOptions{VerifyChecksums: true}
+
+func serializeForValidation(t *testing.T, ls ...gopacket.SerializableLayer) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ls...); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func checkValidations(t *testing.T, p gopacket.Packet, want map[gopacket.LayerType]gopacket.ChecksumStatus) {
+	t.Helper()
+	if p.ErrorLayer() != nil {
+		t.Fatal("Failed to decode packet:", p.ErrorLayer().Error())
+	}
+	got := map[gopacket.LayerType]gopacket.ChecksumStatus{}
+	for _, v := range p.Metadata().Validations {
+		got[v.Layer.LayerType()] = v.Checksum
+	}
+	for lt, status := range want {
+		if got[lt] != status {
+			t.Errorf("%v checksum: got %v, want %v", lt, got[lt], status)
+		}
+	}
+}
+
+func TestVerifyChecksumsIPv4TCP(t *testing.T) {
+	ip4 := createIPv4ChecksumTestLayer()
+	ip4.Protocol = IPProtocolTCP
+	tcp := &TCP{SrcPort: 1234, DstPort: 80, Seq: 1, ACK: true}
+	tcp.SetNetworkLayerForChecksum(ip4)
+	payload := gopacket.Payload("hello, world")
+	data := serializeForValidation(t, ip4, tcp, payload)
+
+	p := gopacket.NewPacket(data, LinkTypeRaw, testVerifyChecksumsOptions)
+	checkValidations(t, p, map[gopacket.LayerType]gopacket.ChecksumStatus{
+		LayerTypeIPv4: gopacket.ChecksumValid,
+		LayerTypeTCP:  gopacket.ChecksumValid,
+	})
+	if !p.Metadata().Valid() {
+		t.Error("expected packet to be valid")
+	}
+	v, ok := p.Metadata().Validation(p.Layer(LayerTypeIPv4))
+	if !ok || v.Length != gopacket.LengthValid || v.DeclaredLength != len(data) {
+		t.Errorf("unexpected IPv4 validation %+v", v)
+	}
+
+	// Corrupt the payload: only the TCP checksum breaks, and the computed
+	// checksum is the one which was originally sent.
+	bad := append([]byte(nil), data...)
+	bad[len(bad)-1] ^= 0xff
+	p = gopacket.NewPacket(bad, LinkTypeRaw, testVerifyChecksumsOptions)
+	checkValidations(t, p, map[gopacket.LayerType]gopacket.ChecksumStatus{
+		LayerTypeIPv4: gopacket.ChecksumValid,
+		LayerTypeTCP:  gopacket.ChecksumInvalid,
+	})
+	v, _ = p.Metadata().Validation(p.Layer(LayerTypeTCP))
+	if want := uint32(p.Layer(LayerTypeTCP).(*TCP).Checksum); v.ReceivedChecksum != want {
+		t.Errorf("received checksum: got %#x, want %#x", v.ReceivedChecksum, want)
+	}
+	p2 := gopacket.NewPacket(data, LinkTypeRaw, testVerifyChecksumsOptions)
+	if v2, _ := p2.Metadata().Validation(p2.Layer(LayerTypeTCP)); v.ComputedChecksum == v.ReceivedChecksum || v2.ReceivedChecksum != v.ReceivedChecksum {
+		t.Errorf("unexpected computed checksum %#x", v.ComputedChecksum)
+	}
+	if p.Metadata().Valid() {
+		t.Error("expected packet to be invalid")
+	}
+
+	// Truncate the packet: IPv4 reports the short length, and TCP's checksum
+	// can't be verified.
+	p = gopacket.NewPacket(bad[:len(bad)-4], LinkTypeRaw, testVerifyChecksumsOptions)
+	checkValidations(t, p, map[gopacket.LayerType]gopacket.ChecksumStatus{
+		LayerTypeIPv4: gopacket.ChecksumValid,
+		LayerTypeTCP:  gopacket.ChecksumNotVerified,
+	})
+	if v, _ := p.Metadata().Validation(p.Layer(LayerTypeIPv4)); v.Length != gopacket.LengthTruncated {
+		t.Errorf("expected truncated IPv4 length, got %v", v.Length)
+	}
+
+	// Without VerifyChecksums, nothing is reported.
+	p = gopacket.NewPacket(bad, LinkTypeRaw, gopacket.Default)
+	if len(p.Metadata().Validations) != 0 {
+		t.Errorf("unexpected validations %v", p.Metadata().Validations)
+	}
+}
+
+func TestVerifyChecksumsIPv6UDPAndICMPv6(t *testing.T) {
+	ip6 := createIPv6ChecksumTestLayer()
+	ip6.NextHeader = IPProtocolUDP
+	udp := createUDPChecksumTestLayer()
+	udp.SetNetworkLayerForChecksum(ip6)
+	data := serializeForValidation(t, ip6, udp, gopacket.Payload("abc"))
+	p := gopacket.NewPacket(data, LinkTypeRaw, testVerifyChecksumsOptions)
+	checkValidations(t, p, map[gopacket.LayerType]gopacket.ChecksumStatus{
+		LayerTypeUDP: gopacket.ChecksumValid,
+	})
+	data[len(data)-1]++
+	p = gopacket.NewPacket(data, LinkTypeRaw, testVerifyChecksumsOptions)
+	checkValidations(t, p, map[gopacket.LayerType]gopacket.ChecksumStatus{
+		LayerTypeUDP: gopacket.ChecksumInvalid,
+	})
+
+	ip6 = createIPv6ChecksumTestLayer()
+	ip6.NextHeader = IPProtocolICMPv6
+	icmp := &ICMPv6{TypeCode: CreateICMPv6TypeCode(ICMPv6TypeEchoRequest, 0)}
+	icmp.SetNetworkLayerForChecksum(ip6)
+	data = serializeForValidation(t, ip6, icmp, &ICMPv6Echo{Identifier: 1, SeqNumber: 2})
+	p = gopacket.NewPacket(data, LinkTypeRaw, testVerifyChecksumsOptions)
+	checkValidations(t, p, map[gopacket.LayerType]gopacket.ChecksumStatus{
+		LayerTypeICMPv6: gopacket.ChecksumValid,
+	})
+}
+
+func TestVerifyChecksumsGREAndICMPv4(t *testing.T) {
+	outer := createIPv4ChecksumTestLayer()
+	outer.Protocol = IPProtocolGRE
+	gre := &GRE{ChecksumPresent: true, Protocol: EthernetTypeIPv4}
+	inner := createIPv4ChecksumTestLayer()
+	inner.Protocol = IPProtocolICMPv4
+	icmp := &ICMPv4{TypeCode: CreateICMPv4TypeCode(ICMPv4TypeEchoRequest, 0), Id: 1, Seq: 1}
+	data := serializeForValidation(t, outer, gre, inner, icmp, gopacket.Payload("ping"))
+	p := gopacket.NewPacket(data, LinkTypeRaw, testVerifyChecksumsOptions)
+	checkValidations(t, p, map[gopacket.LayerType]gopacket.ChecksumStatus{
+		LayerTypeGRE:    gopacket.ChecksumValid,
+		LayerTypeICMPv4: gopacket.ChecksumValid,
+	})
+	if n := len(p.Metadata().Validations); n != 4 {
+		t.Errorf("expected 4 validations (2xIPv4, GRE, ICMPv4), got %d", n)
+	}
+	// Break the inner IPv4 header checksum.
+	data[len(data)-20-8-4+10]++
+	p = gopacket.NewPacket(data, LinkTypeRaw, testVerifyChecksumsOptions)
+	vs := p.Metadata().Validations
+	if len(vs) != 4 || vs[0].Checksum != gopacket.ChecksumValid || vs[2].Checksum != gopacket.ChecksumInvalid {
+		t.Errorf("expected only the inner IPv4 checksum to be invalid, got %+v", vs)
+	}
+}
+
+func TestVerifyChecksumsSCTP(t *testing.T) {
+	chunk := gopacket.Payload{byte(SCTPChunkTypeCookieAck), 0, 0, 4}
+	data := serializeForValidation(t, &SCTP{SrcPort: 1, DstPort: 2, VerificationTag: 3}, chunk)
+	var sctp SCTP
+	if err := sctp.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if v := sctp.Validate(); v.Checksum != gopacket.ChecksumValid {
+		t.Errorf("expected valid SCTP checksum, got %+v", v)
+	}
+	data[len(data)-1]++
+	if v := sctp.Validate(); v.Checksum != gopacket.ChecksumInvalid {
+		t.Errorf("expected invalid SCTP checksum, got %+v", v)
+	}
+}
+
+func TestVerifyChecksumsIGMP(t *testing.T) {
+	ip4 := createIPv4ChecksumTestLayer()
+	ip4.Protocol = IPProtocolIGMP
+	igmp := []byte{byte(IGMPMembershipReportV2), 0, 0, 0, 239, 1, 2, 3}
+	binary.BigEndian.PutUint16(igmp[2:], tcpipChecksum(igmp, 0))
+	data := serializeForValidation(t, ip4, gopacket.Payload(igmp))
+	p := gopacket.NewPacket(data, LinkTypeRaw, testVerifyChecksumsOptions)
+	checkValidations(t, p, map[gopacket.LayerType]gopacket.ChecksumStatus{
+		LayerTypeIPv4: gopacket.ChecksumValid,
+		LayerTypeIGMP: gopacket.ChecksumValid,
+	})
+
+	// Ethernet padding past the IPv4 length isn't covered by the checksum.
+	padded := append(append([]byte(nil), data...), make([]byte, 18)...)
+	p = gopacket.NewPacket(padded, LinkTypeRaw, testVerifyChecksumsOptions)
+	checkValidations(t, p, map[gopacket.LayerType]gopacket.ChecksumStatus{
+		LayerTypeIGMP: gopacket.ChecksumValid,
+	})
+	if v, _ := p.Metadata().Validation(p.Layer(LayerTypeIPv4)); v.Length != gopacket.LengthValid || v.ActualLength != len(data) {
+		t.Errorf("unexpected IPv4 validation of padded packet %+v", v)
+	}
+
+	data[len(data)-1]++
+	p = gopacket.NewPacket(data, LinkTypeRaw, testVerifyChecksumsOptions)
+	checkValidations(t, p, map[gopacket.LayerType]gopacket.ChecksumStatus{
+		LayerTypeIPv4: gopacket.ChecksumValid,
+		LayerTypeIGMP: gopacket.ChecksumInvalid,
+	})
+}
+
+func TestVerifyChecksumsUDPLite(t *testing.T) {
+	ip4 := createIPv4ChecksumTestLayer()
+	ip4.Protocol = IPProtocolUDPLite
+	udplite := []byte{0x04, 0xd2, 0x00, 0x35, 0, 0, 0, 0, 'l', 'i', 't', 'e'}
+	var c tcpipchecksum
+	if err := c.SetNetworkLayerForChecksum(ip4); err != nil {
+		t.Fatal(err)
+	}
+	// A checksum coverage of 0 covers the whole datagram.
+	csum, err := c.computeChecksum(udplite, IPProtocolUDPLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.BigEndian.PutUint16(udplite[6:], csum)
+	data := serializeForValidation(t, ip4, gopacket.Payload(udplite))
+	p := gopacket.NewPacket(data, LinkTypeRaw, testVerifyChecksumsOptions)
+	checkValidations(t, p, map[gopacket.LayerType]gopacket.ChecksumStatus{
+		LayerTypeUDPLite: gopacket.ChecksumValid,
+	})
+
+	bad := append([]byte(nil), data...)
+	bad[len(bad)-1]++
+	p = gopacket.NewPacket(bad, LinkTypeRaw, testVerifyChecksumsOptions)
+	checkValidations(t, p, map[gopacket.LayerType]gopacket.ChecksumStatus{
+		LayerTypeUDPLite: gopacket.ChecksumInvalid,
+	})
+
+	// A coverage longer than the datagram, or shorter than its header, is
+	// invalid and the checksum can't be verified.
+	for _, coverage := range []uint16{uint16(len(udplite) + 1), 4} {
+		binary.BigEndian.PutUint16(bad[20+4:], coverage)
+		p = gopacket.NewPacket(bad, LinkTypeRaw, testVerifyChecksumsOptions)
+		checkValidations(t, p, map[gopacket.LayerType]gopacket.ChecksumStatus{
+			LayerTypeUDPLite: gopacket.ChecksumNotVerified,
+		})
+	}
+}
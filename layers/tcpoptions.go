@@ -0,0 +1,158 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+)
+
+// Typed accessors and constructors of TCP options.  The accessors return
+// false if the option isn't of their kind or if its data doesn't have a
+// valid length for it.  The constructors return options ready to be
+// serialized, with their OptionLength set.
+
+// TCPSACKBlock is a block of data received out of order, acknowledged by a
+// SACK option, see RFC 2018.  Right is the sequence number following the
+// block.
+type TCPSACKBlock struct {
+	Left, Right uint32
+}
+
+// TCPAuthentication is the content of a TCP Authentication Option, see RFC
+// 5925.
+type TCPAuthentication struct {
+	// KeyID is the ID of the key used for the MAC, and RNextKeyID the one
+	// of the key the sender wants to receive.
+	KeyID, RNextKeyID uint8
+	MAC               []byte
+}
+
+func newTCPOption(kind TCPOptionKind, data []byte) TCPOption {
+	return TCPOption{OptionType: kind, OptionLength: uint8(len(data) + 2), OptionData: data}
+}
+
+// MSS returns the maximum segment size of a MSS option.
+func (t TCPOption) MSS() (uint16, bool) {
+	if t.OptionType != TCPOptionKindMSS || len(t.OptionData) != 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(t.OptionData), true
+}
+
+// NewTCPOptionMSS returns a MSS option.
+func NewTCPOptionMSS(mss uint16) TCPOption {
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, mss)
+	return newTCPOption(TCPOptionKindMSS, data)
+}
+
+// WindowScale returns the shift count of a window scale option, see RFC
+// 7323.
+func (t TCPOption) WindowScale() (uint8, bool) {
+	if t.OptionType != TCPOptionKindWindowScale || len(t.OptionData) != 1 {
+		return 0, false
+	}
+	return t.OptionData[0], true
+}
+
+// NewTCPOptionWindowScale returns a window scale option.
+func NewTCPOptionWindowScale(shift uint8) TCPOption {
+	return newTCPOption(TCPOptionKindWindowScale, []byte{shift})
+}
+
+// NewTCPOptionSACKPermitted returns a SACK permitted option.
+func NewTCPOptionSACKPermitted() TCPOption {
+	return newTCPOption(TCPOptionKindSACKPermitted, nil)
+}
+
+// SACKBlocks returns the blocks of a SACK option.
+func (t TCPOption) SACKBlocks() ([]TCPSACKBlock, bool) {
+	if t.OptionType != TCPOptionKindSACK || len(t.OptionData)%8 != 0 {
+		return nil, false
+	}
+	blocks := make([]TCPSACKBlock, len(t.OptionData)/8)
+	for i := range blocks {
+		data := t.OptionData[i*8:]
+		blocks[i] = TCPSACKBlock{binary.BigEndian.Uint32(data), binary.BigEndian.Uint32(data[4:])}
+	}
+	return blocks, true
+}
+
+// NewTCPOptionSACK returns a SACK option.  At most 4 blocks fit in the TCP
+// header.
+func NewTCPOptionSACK(blocks ...TCPSACKBlock) TCPOption {
+	data := make([]byte, 8*len(blocks))
+	for i, b := range blocks {
+		binary.BigEndian.PutUint32(data[i*8:], b.Left)
+		binary.BigEndian.PutUint32(data[i*8+4:], b.Right)
+	}
+	return newTCPOption(TCPOptionKindSACK, data)
+}
+
+// Timestamps returns the timestamp value and echo reply of a timestamps
+// option, see RFC 7323.
+func (t TCPOption) Timestamps() (value, echo uint32, ok bool) {
+	if t.OptionType != TCPOptionKindTimestamps || len(t.OptionData) != 8 {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint32(t.OptionData), binary.BigEndian.Uint32(t.OptionData[4:]), true
+}
+
+// NewTCPOptionTimestamps returns a timestamps option.
+func NewTCPOptionTimestamps(value, echo uint32) TCPOption {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint32(data, value)
+	binary.BigEndian.PutUint32(data[4:], echo)
+	return newTCPOption(TCPOptionKindTimestamps, data)
+}
+
+// FastOpenCookie returns the cookie of a TCP Fast Open option, see RFC 7413.
+// The cookie of a cookie request is empty.
+func (t TCPOption) FastOpenCookie() ([]byte, bool) {
+	n := len(t.OptionData)
+	if t.OptionType != TCPOptionKindFastOpen || n != 0 && (n < 4 || n > 16 || n%2 != 0) {
+		return nil, false
+	}
+	return t.OptionData, true
+}
+
+// NewTCPOptionFastOpen returns a TCP Fast Open option, a cookie request if
+// cookie is empty.
+func NewTCPOptionFastOpen(cookie []byte) TCPOption {
+	return newTCPOption(TCPOptionKindFastOpen, cookie)
+}
+
+// MD5Signature returns the digest of a TCP MD5 signature option, see RFC
+// 2385.
+func (t TCPOption) MD5Signature() ([]byte, bool) {
+	if t.OptionType != TCPOptionKindMD5 || len(t.OptionData) != 16 {
+		return nil, false
+	}
+	return t.OptionData, true
+}
+
+// NewTCPOptionMD5 returns a TCP MD5 signature option.
+func NewTCPOptionMD5(digest []byte) TCPOption {
+	return newTCPOption(TCPOptionKindMD5, digest)
+}
+
+// Authentication returns the content of a TCP Authentication Option.  The
+// MAC isn't copied.
+func (t TCPOption) Authentication() (TCPAuthentication, bool) {
+	if t.OptionType != TCPOptionKindAuthentication || len(t.OptionData) < 2 {
+		return TCPAuthentication{}, false
+	}
+	return TCPAuthentication{KeyID: t.OptionData[0], RNextKeyID: t.OptionData[1], MAC: t.OptionData[2:]}, true
+}
+
+// NewTCPOptionAuthentication returns a TCP Authentication Option.
+func NewTCPOptionAuthentication(a TCPAuthentication) TCPOption {
+	data := make([]byte, 2+len(a.MAC))
+	data[0], data[1] = a.KeyID, a.RNextKeyID
+	copy(data[2:], a.MAC)
+	return newTCPOption(TCPOptionKindAuthentication, data)
+}
@@ -27,6 +27,20 @@ type UDP struct {
 // LayerType returns gopacket.LayerTypeUDP
 func (u *UDP) LayerType() gopacket.LayerType { return LayerTypeUDP }
 
+// Validate implements gopacket.ValidatingLayer, verifying the length and,
+// unless it's zero (no checksum) or the datagram is truncated, the checksum.
+// SetNetworkLayerForChecksum must have been called for the checksum to be
+// verified.
+func (u *UDP) Validate() (v gopacket.LayerValidation) {
+	if u.Length != 0 {
+		v.SetLength(int(u.Length), len(u.Contents)+len(u.Payload))
+	}
+	if u.Checksum != 0 && v.Length != gopacket.LengthTruncated {
+		u.setChecksum(&v, u.Contents, u.Payload, len(u.Contents)+len(u.Payload), IPProtocolUDP, u.Checksum)
+	}
+	return
+}
+
 func (udp *UDP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
 	if len(data) < 8 {
 		df.SetTruncated()
@@ -101,12 +115,25 @@ func (u *UDP) CanDecode() gopacket.LayerClass {
 
 // NextLayerType use the destination port to select the
 // right next decoder. It tries first to decode via the
-// destination port, then the source port.
+// destination port, then the source port, then falls back to
+// UDPHeuristics if neither port is known or they disagree.  Protocols whose
+// versions share ports but are decoded by different layers are then told
+// apart by their version.
 func (u *UDP) NextLayerType() gopacket.LayerType {
-	if lt := u.DstPort.LayerType(); lt != gopacket.LayerTypePayload {
-		return lt
+	lt := UDPHeuristics.nextLayerType(u.DstPort.LayerType(), u.SrcPort.LayerType(), u.Payload)
+	if version, ok := udpVersionLayerTypes[lt]; ok {
+		return version(lt, u.Payload)
 	}
-	return u.SrcPort.LayerType()
+	return lt
+}
+
+// udpVersionLayerTypes maps the layer types of protocols whose versions share
+// UDP ports to a function returning the layer type of a payload's version, or
+// the given one if it's unknown.
+var udpVersionLayerTypes = map[gopacket.LayerType]func(gopacket.LayerType, []byte) gopacket.LayerType{
+	LayerTypeNetFlowV5: netFlowVersionLayerType,
+	LayerTypeNetFlowV9: netFlowVersionLayerType,
+	LayerTypeIPFIX:     netFlowVersionLayerType,
 }
 
 func decodeUDP(data []byte, p gopacket.PacketBuilder) error {
@@ -124,6 +151,12 @@ func (u *UDP) TransportFlow() gopacket.Flow {
 	return gopacket.NewFlow(EndpointUDPPort, u.sPort, u.dPort)
 }
 
+// FiveTupleProtocol implements gopacket.FiveTupleLayer.
+func (u *UDP) FiveTupleProtocol() uint8 { return uint8(IPProtocolUDP) }
+
+// FiveTupleFlow implements gopacket.FiveTupleLayer, returning TransportFlow.
+func (u *UDP) FiveTupleFlow() (gopacket.Flow, bool) { return u.TransportFlow(), true }
+
 // For testing only
 func (u *UDP) SetInternalPortsForTesting() {
 	u.sPort = make([]byte, 2)
@@ -19,11 +19,27 @@ type UDPLite struct {
 	ChecksumCoverage uint16
 	Checksum         uint16
 	sPort, dPort     []byte
+	tcpipchecksum
 }
 
 // LayerType returns gopacket.LayerTypeUDPLite
 func (u *UDPLite) LayerType() gopacket.LayerType { return LayerTypeUDPLite }
 
+// Validate implements gopacket.ValidatingLayer, verifying the checksum over
+// the checksum coverage.  SetNetworkLayerForChecksum must have been called
+// for it to be verified.
+func (u *UDPLite) Validate() (v gopacket.LayerValidation) {
+	coverage := int(u.ChecksumCoverage)
+	if coverage == 0 {
+		coverage = len(u.Contents) + len(u.Payload)
+	}
+	if coverage < len(u.Contents) || coverage > len(u.Contents)+len(u.Payload) {
+		return
+	}
+	u.setChecksum(&v, u.Contents, u.Payload[:coverage-len(u.Contents)], len(u.Contents)+len(u.Payload), IPProtocolUDPLite, u.Checksum)
+	return
+}
+
 func decodeUDPLite(data []byte, p gopacket.PacketBuilder) error {
 	udp := &UDPLite{
 		SrcPort:          UDPLitePort(binary.BigEndian.Uint16(data[0:2])),
@@ -42,3 +58,9 @@ func decodeUDPLite(data []byte, p gopacket.PacketBuilder) error {
 func (u *UDPLite) TransportFlow() gopacket.Flow {
 	return gopacket.NewFlow(EndpointUDPLitePort, u.sPort, u.dPort)
 }
+
+// FiveTupleProtocol implements gopacket.FiveTupleLayer.
+func (u *UDPLite) FiveTupleProtocol() uint8 { return uint8(IPProtocolUDPLite) }
+
+// FiveTupleFlow implements gopacket.FiveTupleLayer, returning TransportFlow.
+func (u *UDPLite) FiveTupleFlow() (gopacket.Flow, bool) { return u.TransportFlow(), true }
@@ -48,6 +48,58 @@ var testPacketVXLAN = []byte{
 	0x34, 0x35, 0x36, 0x37,
 }
 
+func TestDecodingLayerStackVXLAN(t *testing.T) {
+	var outerEth, innerEth Ethernet
+	var outerIP, innerIP IPv4
+	var udp UDP
+	var vxlan VXLAN
+	var icmp ICMPv4
+	var payload gopacket.Payload
+	dls := &gopacket.DecodingLayerStack{}
+	dlp := gopacket.NewDecodingLayerParser(LayerTypeEthernet)
+	dlp.SetDecodingLayerContainer(dls)
+	for _, d := range []gopacket.DecodingLayer{&outerEth, &outerIP, &udp, &vxlan, &innerEth, &innerIP, &icmp, &payload} {
+		dlp.AddDecodingLayer(d)
+	}
+	decoded := []gopacket.LayerType{}
+	// Decode twice, to make sure the stack is reset between packets.
+	for i := 0; i < 2; i++ {
+		if err := dlp.DecodeLayers(testPacketVXLAN, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		want := []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeUDP, LayerTypeVXLAN, LayerTypeEthernet, LayerTypeIPv4, LayerTypeICMPv4, gopacket.LayerTypePayload}
+		if !reflect.DeepEqual(decoded, want) {
+			t.Errorf("decoded layers mismatch:\n   got: %v\n  want: %v", decoded, want)
+		}
+		if got := dls.Depth(LayerTypeIPv4); got != 2 {
+			t.Errorf("expected IPv4 depth 2, got %d", got)
+		}
+		if got, want := outerIP.SrcIP.String(), "192.168.203.1"; got != want {
+			t.Errorf("outer IPv4 source mismatch: got %v, want %v", got, want)
+		}
+		if got, want := innerIP.SrcIP.String(), "192.168.203.3"; got != want {
+			t.Errorf("inner IPv4 source mismatch: got %v, want %v", got, want)
+		}
+		if d, ok := dls.DecoderAt(LayerTypeEthernet, 1); !ok || d != &innerEth {
+			t.Errorf("expected DecoderAt to return the inner Ethernet layer, got %v", d)
+		}
+	}
+
+	// Without an inner Ethernet decoder, decoding stops at the inner header.
+	dls = &gopacket.DecodingLayerStack{}
+	dlp.SetDecodingLayerContainer(dls)
+	for _, d := range []gopacket.DecodingLayer{&outerEth, &outerIP, &udp, &vxlan} {
+		dlp.AddDecodingLayer(d)
+	}
+	err := dlp.DecodeLayers(testPacketVXLAN, &decoded)
+	if _, ok := err.(gopacket.UnsupportedLayerType); !ok {
+		t.Errorf("expected UnsupportedLayerType, got %v", err)
+	}
+	if len(decoded) != 4 {
+		t.Errorf("expected 4 decoded layers, got %v", decoded)
+	}
+}
+
 func TestPacketVXLAN(t *testing.T) {
 	p := gopacket.NewPacket(testPacketVXLAN, LinkTypeEthernet, gopacket.Default)
 	if p.ErrorLayer() != nil {
@@ -48,6 +48,10 @@ type PacketMetadata struct {
 	// This is also set automatically for packets captured off the wire if
 	// CaptureInfo.CaptureLength < CaptureInfo.Length.
 	Truncated bool
+	// Validations holds the checksum and length validation report for each
+	// layer implementing ValidatingLayer, in packet order.  It's only filled
+	// in if the packet was decoded with DecodeOptions.VerifyChecksums.
+	Validations []LayerValidation
 }
 
 // Packet is the primary object used by gopacket.  Packets are created by a
@@ -628,6 +632,12 @@ type DecodeOptions struct {
 	// This is disabled by default because the reassembly package drives the decoding
 	// of TCP payload data after reassembly.
 	DecodeStreamsAsDatagrams bool
+	// VerifyChecksums verifies the checksums and declared lengths of each
+	// layer implementing ValidatingLayer once the packet is decoded, and
+	// reports the results in PacketMetadata.Validations.  Decoding itself is
+	// unaffected: layers with bad checksums are still decoded.  Since every
+	// layer must be available for validation, this disables Lazy decoding.
+	VerifyChecksums bool
 }
 
 // Default decoding provides the safest (but slowest) method for decoding
@@ -657,7 +667,7 @@ func NewPacket(data []byte, firstLayerDecoder Decoder, options DecodeOptions) Pa
 		copy(dataCopy, data)
 		data = dataCopy
 	}
-	if options.Lazy {
+	if options.Lazy && !options.VerifyChecksums {
 		p := &lazyPacket{
 			packet: packet{data: data, decodeOptions: options},
 			next:   firstLayerDecoder,
@@ -680,6 +690,9 @@ func NewPacket(data []byte, firstLayerDecoder Decoder, options DecodeOptions) Pa
 	}
 	p.layers = p.initialLayers[:0]
 	p.initialDecode(firstLayerDecoder)
+	if options.VerifyChecksums {
+		p.validateLayers()
+	}
 	return p
 }
 
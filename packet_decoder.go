@@ -0,0 +1,219 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package gopacket
+
+import (
+	"errors"
+)
+
+// PacketDecoder eagerly decodes packets into a single Packet object that is
+// reused across calls.  It combines the Packet interface (Layer, LayerClass,
+// NetworkLayer, ErrorLayer, etc.) with the allocation behavior of
+// DecodingLayerParser: layers are decoded in-place into a fixed set of
+// DecodingLayers, and the packet container itself is recycled.
+//
+// Each DecodingLayer added to a PacketDecoder must also implement Layer, so
+// that it can be returned from the packet's Layers.  If a DecodingLayer also
+// implements LinkLayer, NetworkLayer, TransportLayer or ApplicationLayer, the
+// packet's corresponding accessor will return it.
+//
+// Whenever the next layer type has no DecodingLayer, or its DecodingLayer has
+// already been used earlier in the same packet (for example, an IPv4-in-IPv4
+// tunnel), PacketDecoder falls back to the layer type's registered Decoder.
+// Those layers are allocated exactly as NewPacket would allocate them, so
+// for allocation-free decoding, make sure every expected layer type has a
+// DecodingLayer, and add a Payload to catch application data.
+//
+// Note that, as with DecodingLayerParser, the next layer type is always
+// chosen by each DecodingLayer's NextLayerType.  For example, the TCP
+// DecodingLayer picks the next layer by port, regardless of
+// DecodeStreamsAsDatagrams.
+//
+// The Packet returned by DecodeInto, and all of its layers, are only valid
+// until the next call to DecodeInto or Reset.  A PacketDecoder is not safe
+// for concurrent use.
+//
+// Example usage:
+//    var eth layers.Ethernet
+//    var ip4 layers.IPv4
+//    var tcp layers.TCP
+//    var payload gopacket.Payload
+//    dec := gopacket.NewPacketDecoder(layers.LayerTypeEthernet, &eth, &ip4, &tcp, &payload)
+//    for {
+//      data, ci, err := source.ZeroCopyReadPacketData()
+//      if err != nil {
+//        break
+//      }
+//      packet := dec.DecodeInto(data, ci)
+//      if tl := packet.TransportLayer(); tl != nil {
+//        fmt.Println(tl.TransportFlow())
+//      }
+//    }
+type PacketDecoder struct {
+	// DecodeOptions is the set of options used for decoding each packet.
+	// Lazy is ignored, packets are always decoded eagerly.  Unless NoCopy is
+	// set, packet data is copied into a buffer owned by the PacketDecoder
+	// (and reused on each call) before decoding.
+	DecodeOptions
+	dlc   DecodingLayerContainer
+	first LayerType
+	buf   []byte
+	p     reusablePacket
+}
+
+// NewPacketDecoder creates a new PacketDecoder and adds in all of the given
+// DecodingLayers with AddDecodingLayer.  Each packet is decoded starting with
+// the 'first' layer type.
+//
+// NewPacketDecoder uses DecodingLayerMap container by default.
+func NewPacketDecoder(first LayerType, decoders ...DecodingLayer) *PacketDecoder {
+	d := &PacketDecoder{first: first}
+	d.p.decoder = d
+	d.p.layers = d.p.initialLayers[:0]
+	dlc := DecodingLayerContainer(DecodingLayerMap(make(map[LayerType]DecodingLayer)))
+	for _, dl := range decoders {
+		dlc = dlc.Put(dl)
+	}
+	d.dlc = dlc
+	return d
+}
+
+// AddDecodingLayer adds a decoding layer to the decoder.  Should one of the
+// decoding layer's CanDecode layers be encountered, it will be decoded
+// in-place into the given DecodingLayer.
+func (d *PacketDecoder) AddDecodingLayer(dl DecodingLayer) {
+	d.dlc = d.dlc.Put(dl)
+}
+
+// SetDecodingLayerContainer specifies container with decoders. This
+// call replaces all decoders already registered in given instance of
+// PacketDecoder.
+func (d *PacketDecoder) SetDecodingLayerContainer(dlc DecodingLayerContainer) {
+	d.dlc = dlc
+}
+
+// Reset clears the reusable packet, dropping all references to previously
+// decoded layers and data.  DecodeInto calls Reset itself, so it's only
+// necessary to call Reset directly to release a packet's data early.
+func (d *PacketDecoder) Reset() {
+	p := &d.p
+	for i := range p.layers {
+		p.layers[i] = nil
+	}
+	p.layers = p.layers[:0]
+	p.data = nil
+	p.last = nil
+	p.metadata = PacketMetadata{Validations: p.metadata.Validations[:0]}
+	p.link = nil
+	p.network = nil
+	p.transport = nil
+	p.application = nil
+	p.failure = nil
+}
+
+// DecodeInto resets the decoder's reusable packet, decodes data into it, and
+// returns it.  The given CaptureInfo is stored in the packet's Metadata, and
+// Truncated is set if ci.CaptureLength < ci.Length, just as
+// PacketSource.NextPacket does.
+//
+// The returned Packet is the same object on every call; it and its layers
+// are only valid until the next call to DecodeInto or Reset.
+func (d *PacketDecoder) DecodeInto(data []byte, ci CaptureInfo) Packet {
+	d.Reset()
+	if !d.NoCopy {
+		d.buf = append(d.buf[:0], data...)
+		data = d.buf
+	}
+	p := &d.p
+	p.data = data
+	p.decodeOptions = d.DecodeOptions
+	p.initialDecode()
+	if d.VerifyChecksums {
+		p.validateLayers()
+	}
+	p.metadata.CaptureInfo = ci
+	p.metadata.Truncated = p.metadata.Truncated || ci.CaptureLength < ci.Length
+	return p
+}
+
+// decode decodes data as a layer of type typ, then continues decoding each
+// subsequent layer's payload until there's nothing left to decode.
+func (d *PacketDecoder) decode(typ LayerType, data []byte) error {
+	p := &d.p
+	for len(data) > 0 {
+		dec, ok := d.dlc.Decoder(typ)
+		if !ok {
+			return typ.Decode(data, p)
+		}
+		layer, ok := dec.(Layer)
+		if !ok || p.hasLayer(layer) {
+			return typ.Decode(data, p)
+		}
+		if err := dec.DecodeFromBytes(data, p); err != nil {
+			return err
+		}
+		p.AddLayer(layer)
+		if l, ok := layer.(LinkLayer); ok {
+			p.SetLinkLayer(l)
+		}
+		if l, ok := layer.(NetworkLayer); ok {
+			p.SetNetworkLayer(l)
+		}
+		if l, ok := layer.(TransportLayer); ok {
+			p.SetTransportLayer(l)
+		}
+		if l, ok := layer.(ApplicationLayer); ok {
+			p.SetApplicationLayer(l)
+		}
+		typ = dec.NextLayerType()
+		data = dec.LayerPayload()
+	}
+	return nil
+}
+
+// reusablePacket is the packet implementation used by PacketDecoder.  It
+// decodes eagerly, like eagerPacket, but routes layer types that have a
+// DecodingLayer back through the PacketDecoder, so that layers decoded by
+// fallback decoders may still be followed by in-place decoding.
+type reusablePacket struct {
+	eagerPacket
+	decoder *PacketDecoder
+}
+
+func (p *reusablePacket) NextDecoder(next Decoder) error {
+	if next == nil {
+		return errNilDecoder
+	}
+	if p.last == nil {
+		return errors.New("NextDecoder called, but no layers added yet")
+	}
+	data := p.last.LayerPayload()
+	if len(data) == 0 {
+		return nil
+	}
+	if typ, ok := next.(LayerType); ok {
+		return p.decoder.decode(typ, data)
+	}
+	return next.Decode(data, p)
+}
+
+func (p *reusablePacket) initialDecode() {
+	defer p.recoverDecodeError()
+	if err := p.decoder.decode(p.decoder.first, p.data); err != nil {
+		p.addFinalDecodeError(err, nil)
+	}
+}
+
+// hasLayer returns true if l has already been added to this packet.
+func (p *reusablePacket) hasLayer(l Layer) bool {
+	for _, pl := range p.layers {
+		if pl == l {
+			return true
+		}
+	}
+	return false
+}
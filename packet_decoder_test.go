@@ -0,0 +1,115 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package gopacket
+
+import (
+	"errors"
+	"testing"
+)
+
+// testDecoderLayer is a one-byte header.  A header byte of 0 is followed by a
+// Payload, 1 is followed by another testDecoderLayer, and anything else is a
+// decode error.
+type testDecoderLayer struct {
+	contents, payload []byte
+	Next              byte
+}
+
+var layerTypeTestDecoder = RegisterLayerType(1999, LayerTypeMetadata{Name: "TestDecoder", Decoder: DecodeFunc(decodeTestDecoderLayer)})
+
+func (t *testDecoderLayer) LayerType() LayerType  { return layerTypeTestDecoder }
+func (t *testDecoderLayer) LayerContents() []byte { return t.contents }
+func (t *testDecoderLayer) LayerPayload() []byte  { return t.payload }
+func (t *testDecoderLayer) CanDecode() LayerClass { return layerTypeTestDecoder }
+func (t *testDecoderLayer) NextLayerType() LayerType {
+	if t.Next == 1 {
+		return layerTypeTestDecoder
+	}
+	return LayerTypePayload
+}
+func (t *testDecoderLayer) DecodeFromBytes(data []byte, df DecodeFeedback) error {
+	if data[0] > 1 {
+		return errors.New("invalid test header")
+	}
+	t.Next = data[0]
+	t.contents, t.payload = data[:1], data[1:]
+	return nil
+}
+
+func decodeTestDecoderLayer(data []byte, p PacketBuilder) error {
+	t := &testDecoderLayer{}
+	if err := t.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(t)
+	if t.Next == 1 {
+		return p.NextDecoder(DecodeFunc(decodeTestDecoderLayer))
+	}
+	return p.NextDecoder(LayerTypePayload)
+}
+
+func TestPacketDecoder(t *testing.T) {
+	var tl testDecoderLayer
+	var payload Payload
+	dec := NewPacketDecoder(layerTypeTestDecoder, &tl, &payload)
+
+	p := dec.DecodeInto([]byte{0, 'a', 'b'}, CaptureInfo{CaptureLength: 3, Length: 4})
+	if got := len(p.Layers()); got != 2 {
+		t.Fatalf("expected 2 layers, got %d: %v", got, p)
+	}
+	if p.Layer(layerTypeTestDecoder) != &tl {
+		t.Error("expected first layer to be decoded in-place")
+	}
+	if p.ApplicationLayer() != &payload || string(payload) != "ab" {
+		t.Errorf("unexpected application layer %v", p.ApplicationLayer())
+	}
+	if !p.Metadata().Truncated {
+		t.Error("expected packet to be truncated")
+	}
+
+	// The second test header can't reuse tl, so it's allocated.
+	p = dec.DecodeInto([]byte{1, 0, 'x'}, CaptureInfo{})
+	if got := len(p.Layers()); got != 3 {
+		t.Fatalf("expected 3 layers, got %d: %v", got, p)
+	}
+	if l := p.Layers()[1]; l == &tl || l.LayerType() != layerTypeTestDecoder {
+		t.Errorf("expected fallback layer, got %v", l)
+	}
+	if p.ApplicationLayer() != &payload || string(payload) != "x" {
+		t.Errorf("unexpected application layer %v", p.ApplicationLayer())
+	}
+	if p.Metadata().Truncated {
+		t.Error("expected packet not to be truncated")
+	}
+
+	p = dec.DecodeInto([]byte{0, 2}, CaptureInfo{})
+	if p.ErrorLayer() != nil {
+		t.Errorf("unexpected error layer %v", p.ErrorLayer())
+	}
+	p = dec.DecodeInto([]byte{1, 2}, CaptureInfo{})
+	if p.ErrorLayer() == nil {
+		t.Error("expected error layer")
+	}
+
+	dec.Reset()
+	if len(p.Layers()) != 0 || p.ApplicationLayer() != nil || p.ErrorLayer() != nil {
+		t.Errorf("expected empty packet after Reset, got %v", p)
+	}
+}
+
+func TestPacketDecoderAllocs(t *testing.T) {
+	var tl testDecoderLayer
+	var payload Payload
+	dec := NewPacketDecoder(layerTypeTestDecoder, &tl, &payload)
+	data := []byte{0, 1, 2, 3, 4}
+	allocs := testing.AllocsPerRun(100, func() {
+		dec.DecodeInto(data, CaptureInfo{})
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations, got %v", allocs)
+	}
+}
@@ -0,0 +1,370 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package packetdiff compares decoded packets field by field, where bytediff
+// compares raw bytes.
+//
+// Packets' layers are matched up by type, so layers added or removed (say, a
+// VLAN tag) are reported as such, and the exported fields of matching layers
+// are compared recursively, reporting changed fields by name:
+//
+//  for _, c := range packetdiff.Packets(before, after) {
+//    fmt.Println(c)
+//  }
+//
+// might print:
+//
+//  Ethernet.EthernetType: Dot1Q -> IPv4
+//  - Dot1Q: {Contents=[0, 10, 8, 0] Payload=[..60..] Priority=0 DropEligible=false VLANIdentifier=10 Type=IPv4}
+//  IPv4.TTL: 64 -> 63
+//  IPv4.Checksum: 26302 -> 26558
+//  + TCP.Options[2]: TCPOption(MSS:1460 0x05b4)
+//  Payload: 12 -> 12 bytes, 5 bytes differ
+//
+// Byte slices without a more meaningful type, such as payloads, are diffed
+// with bytediff.
+package packetdiff
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/bytediff"
+)
+
+// Kind is the kind of a Change.
+type Kind int
+
+const (
+	// Changed means a field has a different value.
+	Changed Kind = iota
+	// Added means a layer or slice element is only in the second packet.
+	Added
+	// Removed means a layer or slice element is only in the first packet.
+	Removed
+)
+
+// Change is a single difference between two packets.
+type Change struct {
+	Kind Kind
+	// Path names what changed, starting with the layer, such as
+	// "IPv4.TTL" or "TCP.Options[2].OptionLength".  Layers appearing
+	// several times in a packet are numbered from the second one on, as in
+	// "IPv4#2.TTL".
+	Path string
+	// Layer is the type of the layer which changed.
+	Layer gopacket.LayerType
+	// From and To are the old and new values, or nil for added and
+	// removed values.  Added and removed layers are the gopacket.Layer
+	// values.
+	From, To interface{}
+	// Bytes holds the byte-level differences of changed byte slices.
+	Bytes bytediff.Differences
+}
+
+// String returns a one line description of the change.
+func (c Change) String() string {
+	switch c.Kind {
+	case Added:
+		return fmt.Sprintf("+ %s: %s", c.Path, formatValue(c.To))
+	case Removed:
+		return fmt.Sprintf("- %s: %s", c.Path, formatValue(c.From))
+	}
+	if c.Bytes != nil {
+		changed := 0
+		for _, d := range c.Bytes {
+			if d.Replace {
+				changed += len(d.To)
+				if len(d.From) > len(d.To) {
+					changed += len(d.From) - len(d.To)
+				}
+			}
+		}
+		return fmt.Sprintf("%s: %d -> %d bytes, %d bytes differ", c.Path, len(c.From.([]byte)), len(c.To.([]byte)), changed)
+	}
+	return fmt.Sprintf("%s: %s -> %s", c.Path, formatValue(c.From), formatValue(c.To))
+}
+
+// Diff is the list of changes between two packets.
+type Diff []Change
+
+// String returns a description of all changes, one per line.
+func (d Diff) String() string {
+	var b bytes.Buffer
+	for _, c := range d {
+		b.WriteString(c.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// formatValue formats values like gopacket.LayerString, briefly.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case gopacket.Layer:
+		return strings.TrimPrefix(gopacket.LayerString(v), v.LayerType().String()+"\t")
+	case fmt.Stringer:
+		return v.String()
+	case []byte:
+		if len(v) > 16 {
+			return fmt.Sprintf("%x... (%d bytes)", v[:16], len(v))
+		}
+		return fmt.Sprintf("%x", v)
+	}
+	return fmt.Sprintf("%+v", v)
+}
+
+// Data decodes two packets with the given decoder, and compares them.
+func Data(a, b []byte, decoder gopacket.Decoder) Diff {
+	return Packets(gopacket.NewPacket(a, decoder, gopacket.NoCopy), gopacket.NewPacket(b, decoder, gopacket.NoCopy))
+}
+
+// Packets compares the layers of two packets.
+func Packets(a, b gopacket.Packet) Diff {
+	return layerList(a.Layers(), b.Layers())
+}
+
+// Layers compares two layers of the same type.
+func Layers(a, b gopacket.Layer) Diff {
+	d := &differ{}
+	d.layer(a.LayerType().String(), a, b, true)
+	return d.changes
+}
+
+// step is a step of an alignment of two sequences: the indexes of a pair of
+// matching elements, or of an element only in one of them, with -1 for the
+// other.
+type step struct{ i, j int }
+
+// align aligns two sequences of lengths n and m with a longest common
+// subsequence of the elements for which same returns true.
+func align(n, m int, same func(i, j int) bool) []step {
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if same(i, j) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var steps []step
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && same(i, j) && lcs[i][j] == lcs[i+1][j+1]+1:
+			steps = append(steps, step{i, j})
+			i++
+			j++
+		case j == m || (i < n && lcs[i+1][j] >= lcs[i][j+1]):
+			steps = append(steps, step{i, -1})
+			i++
+		default:
+			steps = append(steps, step{-1, j})
+			j++
+		}
+	}
+	return steps
+}
+
+// layerList matches up layers by their types, and compares matching layers.
+func layerList(a, b []gopacket.Layer) Diff {
+	d := &differ{}
+	namesA, namesB := layerNames(a), layerNames(b)
+	for _, s := range align(len(a), len(b), func(i, j int) bool { return a[i].LayerType() == b[j].LayerType() }) {
+		switch {
+		case s.j < 0:
+			d.add(Change{Kind: Removed, Path: namesA[s.i], Layer: a[s.i].LayerType(), From: a[s.i]})
+		case s.i < 0:
+			d.add(Change{Kind: Added, Path: namesB[s.j], Layer: b[s.j].LayerType(), To: b[s.j]})
+		default:
+			last := s.i == len(a)-1 && s.j == len(b)-1
+			d.layer(namesA[s.i], a[s.i], b[s.j], last)
+		}
+	}
+	return d.changes
+}
+
+// layerNames names layers by their type, numbering repeated ones.
+func layerNames(ls []gopacket.Layer) []string {
+	names := make([]string, len(ls))
+	seen := map[gopacket.LayerType]int{}
+	for i, l := range ls {
+		seen[l.LayerType()]++
+		names[i] = l.LayerType().String()
+		if n := seen[l.LayerType()]; n > 1 {
+			names[i] += fmt.Sprintf("#%d", n)
+		}
+	}
+	return names
+}
+
+// maxDepth bounds the recursion into fields, in case of cycles.
+const maxDepth = 32
+
+var (
+	baseLayerType = reflect.TypeOf(struct{ Contents, Payload []byte }{})
+	stringerType  = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+)
+
+type differ struct {
+	layerType gopacket.LayerType
+	changes   Diff
+}
+
+func (d *differ) add(c Change) {
+	d.changes = append(d.changes, c)
+}
+
+// layer compares two layers of the same type.  If last is set, they're the
+// last layers of their packets, so their undecoded payloads are compared.
+func (d *differ) layer(path string, a, b gopacket.Layer, last bool) {
+	d.layerType = a.LayerType()
+	switch a.(type) {
+	case gopacket.Payload, *gopacket.Payload, *gopacket.Fragment, *gopacket.DecodeFailure:
+		d.bytes(path, a.LayerContents(), b.LayerContents())
+		return
+	}
+	d.value(path, reflect.ValueOf(a), reflect.ValueOf(b), 0)
+	if last {
+		d.bytes(path+".Payload", a.LayerPayload(), b.LayerPayload())
+	}
+}
+
+func (d *differ) bytes(path string, a, b []byte) {
+	if !bytes.Equal(a, b) {
+		d.add(Change{Kind: Changed, Path: path, Layer: d.layerType, From: a, To: b, Bytes: bytediff.Diff(a, b)})
+	}
+}
+
+func (d *differ) leaf(path string, a, b reflect.Value) {
+	av, bv := a.Interface(), b.Interface()
+	if !reflect.DeepEqual(av, bv) {
+		d.add(Change{Kind: Changed, Path: path, Layer: d.layerType, From: av, To: bv})
+	}
+}
+
+// isBaseLayer returns whether a field is the embedded gopacket BaseLayer,
+// whose contents and payload are compared as layers instead.
+func isBaseLayer(f reflect.StructField) bool {
+	return f.Anonymous && f.Name == "BaseLayer" && f.Type.ConvertibleTo(baseLayerType)
+}
+
+// value compares two values of the same type recursively.
+func (d *differ) value(path string, a, b reflect.Value, depth int) {
+	if depth > maxDepth {
+		return
+	}
+	switch a.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		switch {
+		case a.IsNil() && b.IsNil():
+		case a.IsNil():
+			d.add(Change{Kind: Added, Path: path, Layer: d.layerType, To: b.Interface()})
+		case b.IsNil():
+			d.add(Change{Kind: Removed, Path: path, Layer: d.layerType, From: a.Interface()})
+		case a.Elem().Type() != b.Elem().Type():
+			d.leaf(path, a, b)
+		default:
+			d.value(path, a.Elem(), b.Elem(), depth+1)
+		}
+	case reflect.Struct:
+		t := a.Type()
+		exported := false
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" || isBaseLayer(f) {
+				continue
+			}
+			exported = true
+			fpath := path + "." + f.Name
+			if f.Anonymous {
+				fpath = path
+			}
+			d.value(fpath, a.Field(i), b.Field(i), depth+1)
+		}
+		if !exported && a.CanInterface() {
+			// Opaque structs, such as time.Time.
+			d.leaf(path, a, b)
+		}
+	case reflect.Slice, reflect.Array:
+		if a.Type().Elem().Kind() == reflect.Uint8 && a.CanInterface() {
+			// Byte slices with a String method, such as net.IP, are
+			// values; others are opaque data.
+			if a.Kind() == reflect.Slice && !a.Type().Implements(stringerType) {
+				d.bytes(path, a.Bytes(), b.Bytes())
+			} else {
+				d.leaf(path, a, b)
+			}
+			return
+		}
+		// Elements, such as options, are matched up by their type if
+		// they have one, so added and removed ones are found.
+		same := func(i, j int) bool {
+			ka, oka := elementKey(a.Index(i))
+			kb, okb := elementKey(b.Index(j))
+			return oka == okb && (!oka || ka == kb)
+		}
+		for _, s := range align(a.Len(), b.Len(), same) {
+			switch {
+			case s.j < 0:
+				d.add(Change{Kind: Removed, Path: fmt.Sprintf("%s[%d]", path, s.i), Layer: d.layerType, From: a.Index(s.i).Interface()})
+			case s.i < 0:
+				d.add(Change{Kind: Added, Path: fmt.Sprintf("%s[%d]", path, s.j), Layer: d.layerType, To: b.Index(s.j).Interface()})
+			default:
+				d.value(fmt.Sprintf("%s[%d]", path, s.i), a.Index(s.i), b.Index(s.j), depth+1)
+			}
+		}
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+	default:
+		if a.CanInterface() {
+			d.leaf(path, a, b)
+		}
+	}
+}
+
+// elementKey returns the type of a slice element, which is the first exported
+// field named Type or ending with Type, if any.
+func elementKey(v reflect.Value) (interface{}, bool) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, false
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath == "" && strings.HasSuffix(f.Name, "Type") && f.Type.Comparable() {
+			return v.Field(i).Interface(), true
+		}
+	}
+	return nil, false
+}
+
+// Fields returns the paths of the changes in a diff, which is handy for
+// tests.
+func (d Diff) Fields() []string {
+	paths := make([]string, len(d))
+	for i, c := range d {
+		paths[i] = c.Path
+	}
+	return paths
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package packetdiff
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+type testPacket struct {
+	vlan    uint16
+	ttl     uint8
+	srcIP   net.IP
+	mss     bool
+	payload string
+}
+
+func (p testPacket) serialize(t *testing.T) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{Version: 4, TTL: p.ttl, Protocol: layers.IPProtocolTCP, SrcIP: p.srcIP, DstIP: net.IP{10, 0, 0, 2}}
+	tcp := &layers.TCP{SrcPort: 1000, DstPort: 80, Seq: 1, SYN: true, Window: 1024}
+	tcp.Options = []layers.TCPOption{{OptionType: layers.TCPOptionKindNop, OptionLength: 1}, {OptionType: layers.TCPOptionKindNop, OptionLength: 1}}
+	if p.mss {
+		tcp.Options = append(tcp.Options, layers.TCPOption{OptionType: layers.TCPOptionKindMSS, OptionLength: 4, OptionData: []byte{0x05, 0xb4}})
+	}
+	tcp.SetNetworkLayerForChecksum(ip)
+	ls := []gopacket.SerializableLayer{eth, ip, tcp, gopacket.Payload(p.payload)}
+	if p.vlan != 0 {
+		eth.EthernetType = layers.EthernetTypeDot1Q
+		ls = append([]gopacket.SerializableLayer{eth, &layers.Dot1Q{VLANIdentifier: p.vlan, Type: layers.EthernetTypeIPv4}}, ls[1:]...)
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ls...); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+var base = testPacket{ttl: 64, srcIP: net.IP{10, 0, 0, 1}, payload: "hello, world"}
+
+func TestSamePacket(t *testing.T) {
+	if d := Data(base.serialize(t), base.serialize(t), layers.LinkTypeEthernet); len(d) != 0 {
+		t.Errorf("expected no changes, got:\n%v", d)
+	}
+}
+
+func TestFieldChanges(t *testing.T) {
+	changed := base
+	changed.ttl = 63
+	changed.srcIP = net.IP{10, 0, 0, 3}
+	d := Data(base.serialize(t), changed.serialize(t), layers.LinkTypeEthernet)
+	want := []string{"IPv4.TTL", "IPv4.Checksum", "IPv4.SrcIP", "TCP.Checksum"}
+	if !reflect.DeepEqual(d.Fields(), want) {
+		t.Errorf("got changes %v, want %v", d.Fields(), want)
+	}
+	if c := d[0]; c.Kind != Changed || c.Layer != layers.LayerTypeIPv4 || c.From != uint8(64) || c.To != uint8(63) {
+		t.Errorf("got change %+v", c)
+	}
+	if s := d[0].String(); s != "IPv4.TTL: 64 -> 63" {
+		t.Errorf("got %q", s)
+	}
+	if s := d[2].String(); s != "IPv4.SrcIP: 10.0.0.1 -> 10.0.0.3" {
+		t.Errorf("got %q", s)
+	}
+}
+
+func TestLayerAndOptionChanges(t *testing.T) {
+	changed := base
+	changed.vlan = 10
+	changed.mss = true
+	changed.payload = "hello, there"
+	d := Data(base.serialize(t), changed.serialize(t), layers.LinkTypeEthernet)
+	want := []string{
+		"Ethernet.EthernetType",
+		"Dot1Q",
+		"IPv4.Length",
+		"IPv4.Checksum",
+		"TCP.DataOffset",
+		"TCP.Checksum",
+		"TCP.Options[2]",
+		"Payload",
+	}
+	if !reflect.DeepEqual(d.Fields(), want) {
+		t.Errorf("got changes %v, want %v", d.Fields(), want)
+	}
+	for _, c := range d {
+		switch c.Path {
+		case "Dot1Q":
+			if c.Kind != Added || c.To.(*layers.Dot1Q).VLANIdentifier != 10 {
+				t.Errorf("got change %+v", c)
+			}
+		case "TCP.Options[2]":
+			if c.Kind != Added || c.To.(layers.TCPOption).OptionType != layers.TCPOptionKindMSS {
+				t.Errorf("got change %+v", c)
+			}
+		case "Payload":
+			if c.Bytes == nil || !strings.Contains(c.String(), "12 -> 12 bytes") {
+				t.Errorf("got change %v", c)
+			}
+		}
+	}
+
+	// And back.
+	d = Data(changed.serialize(t), base.serialize(t), layers.LinkTypeEthernet)
+	if d[1].Kind != Removed || d[1].Path != "Dot1Q" || d[6].Kind != Removed || d[6].Path != "TCP.Options[2]" {
+		t.Errorf("got reversed changes:\n%v", d)
+	}
+}
+
+func TestRepeatedLayers(t *testing.T) {
+	outer := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolIPv4, SrcIP: net.IP{1, 1, 1, 1}, DstIP: net.IP{2, 2, 2, 2}}
+	inner := &layers.IPv4{Version: 4, TTL: 10, Protocol: layers.IPProtocolNoNextHeader, SrcIP: net.IP{3, 3, 3, 3}, DstIP: net.IP{4, 4, 4, 4}}
+	serialize := func() []byte {
+		buf := gopacket.NewSerializeBuffer()
+		gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, outer, inner)
+		return buf.Bytes()
+	}
+	a := serialize()
+	inner.TTL = 9
+	b := serialize()
+	d := Data(a, b, layers.LayerTypeIPv4)
+	if !reflect.DeepEqual(d.Fields(), []string{"IPv4#2.TTL"}) {
+		t.Errorf("got changes %v", d.Fields())
+	}
+}
@@ -168,12 +168,151 @@ func (dl DecodingLayerMap) LayersDecoder(first LayerType, df DecodeFeedback) Dec
 	return LayersDecoder(dl, first, df)
 }
 
+// A container for LayerType->[]DecodingLayer mapping, along with the
+// number of DecodingLayers already used for the current packet.
+type decodingLayerStackElem struct {
+	typ  LayerType
+	decs []DecodingLayer
+	used int
+}
+
+// DecodingLayerStack is an implementation of DecodingLayerContainer which
+// holds multiple DecodingLayers per LayerType, to support packets where the
+// same layer type occurs more than once, such as tunnels (VXLAN, Geneve, GRE,
+// GTP, IP-in-IP...) carrying inner Ethernet or IP headers.
+//
+// Unlike the other containers, Put does not replace an existing
+// DecodingLayer for a given LayerType; it adds another one.  The first
+// DecodingLayer put for a LayerType is used for the outermost occurrence of
+// that type in a packet, the second for the next occurrence, and so on.  If a
+// packet contains more occurrences of a type than DecodingLayers have been
+// put for it, decoding stops at that layer as if it had no decoder.
+//
+// The 'decoded' slice filled in by the DecodingLayerFunc reports the full
+// stack, so it may contain the same LayerType more than once.  After
+// decoding, DecoderAt(typ, n) returns the DecodingLayer holding the n-th
+// (zero-based, outermost first) occurrence of typ, and Depth(typ) returns
+// how many occurrences were decoded.
+//
+// A DecodingLayer which can decode several LayerTypes is tracked separately
+// for each of them, so it shouldn't be used for more than one of its types in
+// the same packet.
+//
+// A DecodingLayerStack must be used through a pointer, and is not safe for
+// concurrent use.  Example:
+//
+//    var outerEth, innerEth layers.Ethernet
+//    var outerIP, innerIP layers.IPv4
+//    var udp layers.UDP
+//    var vxlan layers.VXLAN
+//    var tcp layers.TCP
+//    dls := &gopacket.DecodingLayerStack{}
+//    dlp := gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet)
+//    dlp.SetDecodingLayerContainer(dls)
+//    for _, d := range []gopacket.DecodingLayer{&outerEth, &outerIP, &udp, &vxlan, &innerEth, &innerIP, &tcp} {
+//      dlp.AddDecodingLayer(d)
+//    }
+//    err := dlp.DecodeLayers(data, &decoded)
+//    // decoded: [Ethernet IPv4 UDP VXLAN Ethernet IPv4 TCP]
+//    // dls.Depth(layers.LayerTypeIPv4) == 2, and innerIP holds the inner header.
+type DecodingLayerStack struct {
+	elems []decodingLayerStackElem
+}
+
+func (dl *DecodingLayerStack) elem(typ LayerType) *decodingLayerStackElem {
+	for i := range dl.elems {
+		if dl.elems[i].typ == typ {
+			return &dl.elems[i]
+		}
+	}
+	return nil
+}
+
+// Put implements DecodingLayerContainer interface.
+func (dl *DecodingLayerStack) Put(d DecodingLayer) DecodingLayerContainer {
+	for _, typ := range d.CanDecode().LayerTypes() {
+		if e := dl.elem(typ); e != nil {
+			e.decs = append(e.decs, d)
+			continue
+		}
+		dl.elems = append(dl.elems, decodingLayerStackElem{typ: typ, decs: []DecodingLayer{d}})
+	}
+	return dl
+}
+
+// Decoder implements DecodingLayerContainer interface.  It returns the
+// DecodingLayer used for the outermost occurrence of the given LayerType.
+func (dl *DecodingLayerStack) Decoder(typ LayerType) (DecodingLayer, bool) {
+	return dl.DecoderAt(typ, 0)
+}
+
+// DecoderAt returns the DecodingLayer used for the n-th occurrence of the
+// given LayerType, counting from zero for the outermost.
+func (dl *DecodingLayerStack) DecoderAt(typ LayerType, n int) (DecodingLayer, bool) {
+	if e := dl.elem(typ); e != nil && n >= 0 && n < len(e.decs) {
+		return e.decs[n], true
+	}
+	return nil, false
+}
+
+// Depth returns the number of times the given LayerType was successfully
+// decoded in the last packet.
+func (dl *DecodingLayerStack) Depth(typ LayerType) int {
+	if e := dl.elem(typ); e != nil {
+		return e.used
+	}
+	return 0
+}
+
+// next returns the stack element for the given LayerType if it still has an
+// unused DecodingLayer.
+func (dl *DecodingLayerStack) next(typ LayerType) *decodingLayerStackElem {
+	if e := dl.elem(typ); e != nil && e.used < len(e.decs) {
+		return e
+	}
+	return nil
+}
+
+// reset marks all DecodingLayers as unused.
+func (dl *DecodingLayerStack) reset() {
+	for i := range dl.elems {
+		dl.elems[i].used = 0
+	}
+}
+
+// LayersDecoder implements DecodingLayerContainer interface.
+func (dl *DecodingLayerStack) LayersDecoder(first LayerType, df DecodeFeedback) DecodingLayerFunc {
+	return func(data []byte, decoded *[]LayerType) (LayerType, error) {
+		*decoded = (*decoded)[:0] // Truncated decoded layers.
+		dl.reset()
+		typ := first
+		for {
+			e := dl.next(typ)
+			if e == nil {
+				return typ, nil
+			}
+			decoder := e.decs[e.used]
+			if err := decoder.DecodeFromBytes(data, df); err != nil {
+				return LayerTypeZero, err
+			}
+			e.used++
+			*decoded = append(*decoded, typ)
+			typ = decoder.NextLayerType()
+			if data = decoder.LayerPayload(); len(data) == 0 {
+				break
+			}
+		}
+		return LayerTypeZero, nil
+	}
+}
+
 // Static code check.
 var (
 	_ = []DecodingLayerContainer{
 		DecodingLayerSparse(nil),
 		DecodingLayerMap(nil),
 		DecodingLayerArray(nil),
+		(*DecodingLayerStack)(nil),
 	}
 )
 
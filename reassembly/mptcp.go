@@ -0,0 +1,428 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package reassembly
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// MPTCPStream receives the data of a Multipath TCP connection, stitched
+// together from the data of its subflows.
+type MPTCPStream interface {
+	// ReassembledMPTCP is called with the connection-level data sent in a
+	// direction, in order.  skip is the number of bytes missing before
+	// data, which were never seen on any subflow.  On connections without
+	// MPTCP, or falling back to plain TCP, it's the skip of
+	// ScatterGather.Info, -1 if unknown, and data may then be empty.  ci
+	// is the capture info of the packet carrying the first byte.  data is
+	// only valid during the call.
+	ReassembledMPTCP(dir TCPFlowDirection, data []byte, skip int, ci gopacket.CaptureInfo)
+	// MPTCPComplete is called once all the subflows of the connection are
+	// complete.
+	MPTCPComplete()
+}
+
+// MPTCPStreamFactory creates an MPTCPStream for each new connection.
+type MPTCPStreamFactory interface {
+	// New is called with the first packet of the connection's first
+	// subflow.
+	New(netFlow, tcpFlow gopacket.Flow, tcp *layers.TCP, ac AssemblerContext) MPTCPStream
+}
+
+// MPTCPFactory is a StreamFactory reassembling Multipath TCP connections,
+// see RFC 8684.  Subflows joining a connection are matched to it with the
+// token of their MP_JOIN option, and their data is put back in
+// connection-level order using the mappings of DSS options.  Directions are
+// the ones of the connection's first subflow.
+//
+// TCP connections that don't use MPTCP, or fall back to plain TCP, are
+// passed through in subflow order.  Subflows whose handshake wasn't seen
+// can't be mapped, and their data is dropped.  Data received ahead of a gap
+// is buffered until the gap is filled by another subflow, the connection
+// completes, or MaxBufferedBytesPerConnection is reached.
+type MPTCPFactory struct {
+	// MaxBufferedBytesPerConnection is an upper limit on the number of bytes
+	// buffered ahead of gaps for a single connection.  Should this limit be
+	// exceeded, the data with the smallest data sequence number will be
+	// delivered, skipping the gap before it, along with any contiguous data.
+	// It's DefaultMPTCPMaxBufferedBytes when created with NewMPTCPFactory.
+	// If <= 0, this is ignored.
+	MaxBufferedBytesPerConnection int
+
+	factory MPTCPStreamFactory
+
+	mu     sync.Mutex
+	tokens map[uint32]mptcpToken
+}
+
+type mptcpToken struct {
+	conn *mptcpConn
+	// dir is the direction in which the owner of the key sends.
+	dir TCPFlowDirection
+}
+
+// DefaultMPTCPMaxBufferedBytes is the default
+// MPTCPFactory.MaxBufferedBytesPerConnection.
+const DefaultMPTCPMaxBufferedBytes = 4 << 20
+
+// NewMPTCPFactory returns an MPTCPFactory creating MPTCPStreams with
+// factory.  Assemblers using it may run concurrently.
+func NewMPTCPFactory(factory MPTCPStreamFactory) *MPTCPFactory {
+	return &MPTCPFactory{
+		MaxBufferedBytesPerConnection: DefaultMPTCPMaxBufferedBytes,
+		factory:                       factory,
+		tokens:                        make(map[uint32]mptcpToken),
+	}
+}
+
+// New creates a Stream for a subflow, implementing StreamFactory.
+func (f *MPTCPFactory) New(netFlow, tcpFlow gopacket.Flow, tcp *layers.TCP, ac AssemblerContext) Stream {
+	if tcp.SYN && !tcp.ACK {
+		for _, opt := range tcp.Options {
+			if opt.OptionType != layers.TCPOptionKindMPTCP {
+				continue
+			}
+			o, err := opt.MPTCP()
+			join, ok := o.(*layers.MPTCPJoin)
+			if err != nil || !ok {
+				continue
+			}
+			f.mu.Lock()
+			t, ok := f.tokens[join.ReceiverToken]
+			f.mu.Unlock()
+			if !ok {
+				break
+			}
+			t.conn.mu.Lock()
+			t.conn.subflows++
+			t.conn.mu.Unlock()
+			// The SYN is sent to the owner of the key.
+			return &mptcpSubflow{conn: t.conn, reversed: t.dir == TCPDirClientToServer}
+		}
+	}
+	return &mptcpSubflow{conn: &mptcpConn{
+		factory:  f,
+		stream:   f.factory.New(netFlow, tcpFlow, tcp, ac),
+		subflows: 1,
+	}}
+}
+
+// mptcpConn is a connection, shared by its subflows.
+type mptcpConn struct {
+	factory *MPTCPFactory
+	stream  MPTCPStream
+
+	mu       sync.Mutex
+	version  uint8
+	hasKey   [2]bool
+	idsn     [2]uint64
+	tokens   []uint32
+	half     [2]mptcpHalf
+	subflows int
+	// buffered is the number of bytes pending in both halves.
+	buffered int
+}
+
+// mptcpHalf is a direction of a connection.
+type mptcpHalf struct {
+	// next is the data sequence number of the next byte to deliver, valid
+	// if started.
+	started bool
+	next    uint64
+	// mapped is set once a mapping was seen in this direction.
+	mapped  bool
+	pending []mptcpSegment
+}
+
+type mptcpSegment struct {
+	dsn  uint64
+	data []byte
+	ci   gopacket.CaptureInfo
+}
+
+func dirIndex(dir TCPFlowDirection) int {
+	if dir == TCPDirClientToServer {
+		return 0
+	}
+	return 1
+}
+
+// setKey records the key of the host sending in dir.
+func (c *mptcpConn) setKey(dir TCPFlowDirection, key uint64) {
+	i := dirIndex(dir)
+	if c.hasKey[i] {
+		return
+	}
+	c.hasKey[i] = true
+	token, idsn := layers.MPTCPToken(c.version, key)
+	c.idsn[i] = idsn
+	if h := &c.half[i]; !h.started {
+		h.started, h.next = true, idsn+1
+	}
+	c.tokens = append(c.tokens, token)
+	c.factory.mu.Lock()
+	c.factory.tokens[token] = mptcpToken{conn: c, dir: dir}
+	c.factory.mu.Unlock()
+}
+
+// expand returns the data sequence number whose lower 32 bits are dsn,
+// closest to the next one expected in dir.
+func (c *mptcpConn) expand(dir TCPFlowDirection, dsn uint32) uint64 {
+	h := &c.half[dirIndex(dir)]
+	if !h.started {
+		return uint64(dsn)
+	}
+	return h.next + uint64(int64(int32(dsn-uint32(h.next))))
+}
+
+// receive delivers the data starting at dsn in dir, or buffers it until
+// the data preceding it is received.
+func (c *mptcpConn) receive(dir TCPFlowDirection, dsn uint64, data []byte, ci gopacket.CaptureInfo) {
+	h := &c.half[dirIndex(dir)]
+	if !h.started {
+		h.started, h.next = true, dsn
+	}
+	if int64(dsn-h.next) > 0 {
+		i := sort.Search(len(h.pending), func(i int) bool { return int64(h.pending[i].dsn-dsn) > 0 })
+		h.pending = append(h.pending, mptcpSegment{})
+		copy(h.pending[i+1:], h.pending[i:])
+		h.pending[i] = mptcpSegment{dsn: dsn, data: append([]byte(nil), data...), ci: ci}
+		c.buffered += len(data)
+		max := c.factory.MaxBufferedBytesPerConnection
+		for max > 0 && c.buffered > max && len(h.pending) > 0 {
+			c.deliverPending(dir, h, true)
+		}
+		return
+	}
+	c.deliver(dir, h, dsn, data, ci)
+	c.deliverPending(dir, h, false)
+}
+
+// deliverPending delivers the buffered data following h.next, preceded by
+// the first buffered segment, skipping the gap before it, if force is set.
+func (c *mptcpConn) deliverPending(dir TCPFlowDirection, h *mptcpHalf, force bool) {
+	for len(h.pending) > 0 && (force || int64(h.pending[0].dsn-h.next) <= 0) {
+		s := h.pending[0]
+		h.pending = h.pending[1:]
+		c.buffered -= len(s.data)
+		c.deliver(dir, h, s.dsn, s.data, s.ci)
+		force = false
+	}
+}
+
+// deliver passes the part of data following h.next to the stream.
+func (c *mptcpConn) deliver(dir TCPFlowDirection, h *mptcpHalf, dsn uint64, data []byte, ci gopacket.CaptureInfo) {
+	skip := int(int64(dsn - h.next))
+	if skip < 0 {
+		if -skip >= len(data) {
+			return
+		}
+		data, skip = data[-skip:], 0
+	}
+	h.next = dsn + uint64(len(data))
+	c.stream.ReassembledMPTCP(dir, data, skip, ci)
+}
+
+// complete delivers the buffered data, skipping the gaps, and releases
+// the connection.
+func (c *mptcpConn) complete() {
+	for i := range c.half {
+		h := &c.half[i]
+		for _, s := range h.pending {
+			c.deliver(TCPFlowDirection(i == 1), h, s.dsn, s.data, s.ci)
+		}
+		h.pending = nil
+	}
+	c.buffered = 0
+	c.factory.mu.Lock()
+	for _, token := range c.tokens {
+		if c.factory.tokens[token].conn == c {
+			delete(c.factory.tokens, token)
+		}
+	}
+	c.factory.mu.Unlock()
+	c.stream.MPTCPComplete()
+}
+
+// mptcpMapping maps length bytes of a subflow from the relative subflow
+// sequence number ssn to the data sequence number dsn.  A zero length maps
+// all the following bytes.
+type mptcpMapping struct {
+	ssn    uint32
+	dsn    uint64
+	length uint16
+}
+
+// mptcpSubflow is the Stream of a subflow.
+type mptcpSubflow struct {
+	conn *mptcpConn
+	// reversed is set if the subflow's directions are the reverse of the
+	// connection's.
+	reversed bool
+	// For each direction of the subflow, isn is the initial sequence
+	// number, valid if synced, and offset the number of bytes following
+	// it which were reassembled.
+	synced   [2]bool
+	isn      [2]uint32
+	offset   [2]int
+	mappings [2][]mptcpMapping
+}
+
+func (s *mptcpSubflow) connDir(dir TCPFlowDirection) TCPFlowDirection {
+	if s.reversed {
+		return dir.Reverse()
+	}
+	return dir
+}
+
+func (s *mptcpSubflow) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir TCPFlowDirection, nextSeq Sequence, start *bool, ac AssemblerContext) bool {
+	i := dirIndex(dir)
+	if tcp.SYN && !s.synced[i] {
+		s.synced[i], s.isn[i], s.offset[i] = true, tcp.Seq, 0
+	}
+	cdir := s.connDir(dir)
+	c := s.conn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, opt := range tcp.Options {
+		if opt.OptionType != layers.TCPOptionKindMPTCP {
+			continue
+		}
+		o, err := opt.MPTCP()
+		if err != nil {
+			continue
+		}
+		switch o := o.(type) {
+		case *layers.MPTCPCapable:
+			c.version = o.Version
+			if o.HasSenderKey {
+				c.setKey(cdir, o.SenderKey)
+			}
+			if o.HasReceiverKey {
+				c.setKey(cdir.Reverse(), o.ReceiverKey)
+			}
+			if o.HasDataLength && c.hasKey[dirIndex(cdir)] {
+				// The data of the third ACK follows the IDSN.
+				s.addMapping(dir, cdir, mptcpMapping{ssn: tcp.Seq - s.isn[i], dsn: c.idsn[dirIndex(cdir)] + 1, length: o.DataLength})
+			}
+		case *layers.MPTCPDSS:
+			if !o.HasMapping {
+				continue
+			}
+			dsn := o.DataSeq
+			if !o.DataSeq64 {
+				dsn = c.expand(cdir, uint32(dsn))
+			}
+			s.addMapping(dir, cdir, mptcpMapping{ssn: o.SubflowSeq, dsn: dsn, length: o.DataLength})
+		}
+	}
+	return true
+}
+
+// addMapping adds a mapping to dir, replacing the one it repeats.
+func (s *mptcpSubflow) addMapping(dir, cdir TCPFlowDirection, m mptcpMapping) {
+	s.conn.half[dirIndex(cdir)].mapped = true
+	i := dirIndex(dir)
+	if !s.synced[i] {
+		return
+	}
+	for j := range s.mappings[i] {
+		if s.mappings[i][j].ssn == m.ssn {
+			s.mappings[i][j] = m
+			return
+		}
+	}
+	s.mappings[i] = append(s.mappings[i], m)
+}
+
+// mapping returns the mapping of direction i covering the relative
+// subflow sequence number ssn, or the number of bytes until the next one
+// if there's none, dropping the mappings preceding ssn.
+func (s *mptcpSubflow) mapping(i int, ssn uint32) (mptcpMapping, int, bool) {
+	next := -1
+	mappings := s.mappings[i][:0]
+	for _, m := range s.mappings[i] {
+		d := int32(ssn - m.ssn)
+		if d >= 0 && m.length != 0 && d >= int32(m.length) {
+			continue
+		}
+		mappings = append(mappings, m)
+		if d < 0 && (next < 0 || int(-d) < next) {
+			next = int(-d)
+		}
+	}
+	s.mappings[i] = mappings
+	for _, m := range mappings {
+		if int32(ssn-m.ssn) >= 0 {
+			return m, 0, true
+		}
+	}
+	return mptcpMapping{}, next, false
+}
+
+func (s *mptcpSubflow) ReassembledSG(sg ScatterGather, ac AssemblerContext) {
+	dir, _, _, skip := sg.Info()
+	i := dirIndex(dir)
+	if skip > 0 {
+		s.offset[i] += skip
+	} else if skip < 0 {
+		s.synced[i] = false
+	}
+	length, _ := sg.Lengths()
+	cdir := s.connDir(dir)
+	c := s.conn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.half[dirIndex(cdir)].mapped {
+		// The subflow's data is the connection's, gaps included.
+		if length > 0 || skip != 0 {
+			c.stream.ReassembledMPTCP(cdir, sg.Fetch(length), skip, sg.CaptureInfo(0))
+		}
+		s.offset[i] += length
+		return
+	}
+	if length == 0 {
+		return
+	}
+	data := sg.Fetch(length)
+	defer func() { s.offset[i] += length }()
+	if !s.synced[i] {
+		return
+	}
+	for pos := 0; pos < len(data); {
+		ssn := uint32(s.offset[i] + pos + 1)
+		m, next, ok := s.mapping(i, ssn)
+		if !ok {
+			if next < 0 || next > len(data)-pos {
+				return
+			}
+			pos += next
+			continue
+		}
+		d := ssn - m.ssn
+		n := len(data) - pos
+		if m.length != 0 && int(m.length)-int(d) < n {
+			n = int(m.length) - int(d)
+		}
+		c.receive(cdir, m.dsn+uint64(d), data[pos:pos+n], sg.CaptureInfo(pos))
+		pos += n
+	}
+}
+
+func (s *mptcpSubflow) ReassemblyComplete(ac AssemblerContext) bool {
+	c := s.conn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subflows--; c.subflows == 0 {
+		c.complete()
+	}
+	return true
+}
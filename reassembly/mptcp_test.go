@@ -0,0 +1,188 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package reassembly
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+type testMPTCPStream struct {
+	data     [2][]byte
+	skipped  int
+	complete int
+}
+
+func (s *testMPTCPStream) ReassembledMPTCP(dir TCPFlowDirection, data []byte, skip int, ci gopacket.CaptureInfo) {
+	s.data[dirIndex(dir)] = append(s.data[dirIndex(dir)], data...)
+	s.skipped += skip
+}
+
+func (s *testMPTCPStream) MPTCPComplete() { s.complete++ }
+
+type testMPTCPFactory []*testMPTCPStream
+
+func (f *testMPTCPFactory) New(netFlow, tcpFlow gopacket.Flow, tcp *layers.TCP, ac AssemblerContext) MPTCPStream {
+	s := &testMPTCPStream{}
+	*f = append(*f, s)
+	return s
+}
+
+// testSubflow sends the packets of a TCP connection to an assembler.
+type testSubflow struct {
+	t                 *testing.T
+	assembler         *Assembler
+	client, server    net.IP
+	cport, sport      uint16
+	clientSeq, srvSeq uint32
+}
+
+func (s *testSubflow) send(toServer bool, flags string, payload string, opts ...layers.MPTCPOption) {
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: s.client, DstIP: s.server}
+	tcp := &layers.TCP{SrcPort: layers.TCPPort(s.cport), DstPort: layers.TCPPort(s.sport), Seq: s.clientSeq, Ack: s.srvSeq, Window: 1024}
+	seq := &s.clientSeq
+	if !toServer {
+		ip.SrcIP, ip.DstIP = s.server, s.client
+		tcp.SrcPort, tcp.DstPort = tcp.DstPort, tcp.SrcPort
+		tcp.Seq, tcp.Ack = s.srvSeq, s.clientSeq
+		seq = &s.srvSeq
+	}
+	tcp.SYN = flags == "S" || flags == "SA"
+	tcp.ACK = flags != "S"
+	for _, o := range opts {
+		tcp.Options = append(tcp.Options, layers.NewTCPOptionMPTCP(o))
+	}
+	tcp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	sopts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, sopts, ip, tcp, gopacket.Payload(payload)); err != nil {
+		s.t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+	*seq += uint32(len(payload))
+	if tcp.SYN {
+		*seq++
+	}
+	ci := assemblerSimpleContext{Timestamp: time.Unix(1000, 0)}
+	s.assembler.AssembleWithContext(p.NetworkLayer().NetworkFlow(), p.TransportLayer().(*layers.TCP), &ci)
+}
+
+func TestMPTCPFactory(t *testing.T) {
+	var streams testMPTCPFactory
+	assembler := NewAssembler(NewStreamPool(NewMPTCPFactory(&streams)))
+	server := net.IP{10, 0, 0, 2}
+	sub1 := &testSubflow{t: t, assembler: assembler, client: net.IP{10, 0, 0, 1}, server: server, cport: 1000, sport: 80, clientSeq: 100, srvSeq: 500}
+	sub2 := &testSubflow{t: t, assembler: assembler, client: net.IP{10, 0, 0, 3}, server: server, cport: 2000, sport: 80, clientSeq: 1000, srvSeq: 9000}
+
+	clientKey, serverKey := uint64(0x1111111111111111), uint64(0x2222222222222222)
+	_, clientIDSN := layers.MPTCPToken(1, clientKey)
+	serverToken, serverIDSN := layers.MPTCPToken(1, serverKey)
+
+	sub1.send(true, "S", "", &layers.MPTCPCapable{Version: 1})
+	sub1.send(false, "SA", "", &layers.MPTCPCapable{Version: 1, SenderKey: serverKey, HasSenderKey: true})
+	sub1.send(true, "A", "hello ", &layers.MPTCPCapable{
+		Version: 1, SenderKey: clientKey, ReceiverKey: serverKey, DataLength: 6,
+		HasSenderKey: true, HasReceiverKey: true, HasDataLength: true,
+	})
+
+	sub2.send(true, "S", "", &layers.MPTCPJoin{ReceiverToken: serverToken, SenderRandom: 1})
+	sub2.send(false, "SA", "", &layers.MPTCPJoin{HMAC: make([]byte, 8), SenderRandom: 2})
+	sub2.send(true, "A", "", &layers.MPTCPJoin{HMAC: make([]byte, 20)})
+	// The end of the data, before its middle sent on the first subflow.
+	sub2.send(true, "A", "world", &layers.MPTCPDSS{HasMapping: true, DataSeq64: true, DataSeq: clientIDSN + 11, SubflowSeq: 1, DataLength: 5})
+	sub1.send(true, "A", "big ", &layers.MPTCPDSS{HasMapping: true, DataSeq: uint64(uint32(clientIDSN + 7)), SubflowSeq: 7, DataLength: 4})
+	sub2.send(false, "A", "ok", &layers.MPTCPDSS{HasMapping: true, DataSeq64: true, DataSeq: serverIDSN + 1, SubflowSeq: 1, DataLength: 2})
+	assembler.FlushAll()
+
+	if len(streams) != 1 {
+		t.Fatalf("got %d connections, want 1", len(streams))
+	}
+	s := streams[0]
+	if got := string(s.data[0]); got != "hello big world" {
+		t.Errorf("got client data %q", got)
+	}
+	if got := string(s.data[1]); got != "ok" {
+		t.Errorf("got server data %q", got)
+	}
+	if s.skipped != 0 || s.complete != 1 {
+		t.Errorf("got %d bytes skipped, %d completions", s.skipped, s.complete)
+	}
+}
+
+func TestMPTCPFactoryFallback(t *testing.T) {
+	var streams testMPTCPFactory
+	assembler := NewAssembler(NewStreamPool(NewMPTCPFactory(&streams)))
+	sub := &testSubflow{t: t, assembler: assembler, client: net.IP{10, 0, 0, 1}, server: net.IP{10, 0, 0, 2}, cport: 1000, sport: 80, clientSeq: 100, srvSeq: 500}
+	// The server doesn't support MPTCP.
+	sub.send(true, "S", "", &layers.MPTCPCapable{Version: 1})
+	sub.send(false, "SA", "")
+	sub.send(true, "A", "plain")
+	sub.send(false, "A", "tcp")
+	assembler.FlushAll()
+
+	if len(streams) != 1 {
+		t.Fatalf("got %d connections, want 1", len(streams))
+	}
+	if s := streams[0]; string(s.data[0]) != "plain" || string(s.data[1]) != "tcp" || s.complete != 1 {
+		t.Errorf("got %q, %q, %d completions", s.data[0], s.data[1], s.complete)
+	}
+}
+
+func TestMPTCPFactoryFallbackGap(t *testing.T) {
+	var streams testMPTCPFactory
+	assembler := NewAssembler(NewStreamPool(NewMPTCPFactory(&streams)))
+	sub := &testSubflow{t: t, assembler: assembler, client: net.IP{10, 0, 0, 1}, server: net.IP{10, 0, 0, 2}, cport: 1000, sport: 80, clientSeq: 100, srvSeq: 500}
+	sub.send(true, "S", "")
+	sub.send(false, "SA", "")
+	sub.send(true, "A", "plain")
+	// 4 bytes are lost.
+	sub.clientSeq += 4
+	sub.send(true, "A", "tcp")
+	assembler.FlushAll()
+
+	if len(streams) != 1 {
+		t.Fatalf("got %d connections, want 1", len(streams))
+	}
+	if s := streams[0]; string(s.data[0]) != "plaintcp" || s.skipped != 4 || s.complete != 1 {
+		t.Errorf("got %q, %d bytes skipped, %d completions", s.data[0], s.skipped, s.complete)
+	}
+}
+
+func TestMPTCPFactoryMaxBuffered(t *testing.T) {
+	var streams testMPTCPFactory
+	factory := NewMPTCPFactory(&streams)
+	factory.MaxBufferedBytesPerConnection = 8
+	assembler := NewAssembler(NewStreamPool(factory))
+	sub := &testSubflow{t: t, assembler: assembler, client: net.IP{10, 0, 0, 1}, server: net.IP{10, 0, 0, 2}, cport: 1000, sport: 80, clientSeq: 100, srvSeq: 500}
+
+	clientKey, serverKey := uint64(0x1111111111111111), uint64(0x2222222222222222)
+	_, clientIDSN := layers.MPTCPToken(1, clientKey)
+	sub.send(true, "S", "", &layers.MPTCPCapable{Version: 1})
+	sub.send(false, "SA", "", &layers.MPTCPCapable{Version: 1, SenderKey: serverKey, HasSenderKey: true})
+	sub.send(true, "A", "hello ", &layers.MPTCPCapable{
+		Version: 1, SenderKey: clientKey, ReceiverKey: serverKey, DataLength: 6,
+		HasSenderKey: true, HasReceiverKey: true, HasDataLength: true,
+	})
+	// Data far ahead of the data sequence, whose gap is never filled.
+	sub.send(true, "A", "abcd", &layers.MPTCPDSS{HasMapping: true, DataSeq64: true, DataSeq: clientIDSN + 101, SubflowSeq: 7, DataLength: 4})
+	s := streams[0]
+	if len(s.data[0]) != 6 || s.skipped != 0 {
+		t.Fatalf("got data %q, %d bytes skipped before reaching the limit", s.data[0], s.skipped)
+	}
+	sub.send(true, "A", "efghij", &layers.MPTCPDSS{HasMapping: true, DataSeq64: true, DataSeq: clientIDSN + 201, SubflowSeq: 11, DataLength: 6})
+	if got := string(s.data[0]); got != "hello abcd" || s.skipped != 94 {
+		t.Errorf("got data %q, %d bytes skipped after reaching the limit", got, s.skipped)
+	}
+	assembler.FlushAll()
+	if got := string(s.data[0]); got != "hello abcdefghij" || s.skipped != 94+96 {
+		t.Errorf("got data %q, %d bytes skipped after completion", got, s.skipped)
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package replay provides a gopacket.PacketDataSource which paces the packets
+// of another source, for replaying captures with their original timing (or a
+// multiple of it), or at a fixed packet or bit rate.
+//
+// For example, to send a pcap file at twice its original speed, three times
+// over:
+//
+//  r, _ := pcapgo.NewReader(f)
+//  src := replay.NewSource(r, replay.Options{Speed: 2, Loops: 3})
+//  for {
+//    data, ci, err := src.ReadPacketData()
+//    if err == io.EOF {
+//      break
+//    }
+//    handle.WritePacketData(data)
+//  }
+//
+// Pacing is done with a Clock, which tests can replace with a VirtualClock to
+// replay without actually sleeping.
+package replay
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// Clock tells and waits for the time.
+type Clock interface {
+	Now() time.Time
+	Sleep(time.Duration)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time        { return time.Now() }
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// RealClock is the Clock of the system.
+var RealClock Clock = realClock{}
+
+// VirtualClock is a Clock whose time only moves when it's slept on or
+// advanced.  It's safe for concurrent use.
+type VirtualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewVirtualClock creates a VirtualClock set to the given time.
+func NewVirtualClock(now time.Time) *VirtualClock {
+	return &VirtualClock{now: now}
+}
+
+// Now returns the clock's time.
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Sleep advances the clock by d, immediately.
+func (c *VirtualClock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+// Advance advances the clock by d.  Negative durations are ignored.
+func (c *VirtualClock) Advance(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// Options configures a Source.
+type Options struct {
+	// Speed multiplies the original replay speed: 2 replays twice as fast,
+	// 0.5 half as fast.  It defaults to 1.
+	Speed float64
+	// PacketsPerSecond, if set, replays packets at a fixed rate instead of
+	// their original timing.
+	PacketsPerSecond float64
+	// BitsPerSecond, if set, replays packets at a fixed bit rate instead of
+	// their original timing, based on the packets' CaptureInfo.Length (or
+	// their captured length if it isn't set).
+	BitsPerSecond float64
+	// NoPacing returns packets as fast as they're read, for example to only
+	// loop or rewrite timestamps.
+	NoPacing bool
+	// Loops is the number of times the packets are replayed.  0 or 1 replay
+	// them once, and negative values replay them forever.  Looping keeps
+	// the packets of the first pass in memory.
+	Loops int
+	// LoopGap is the time between the last packet of a pass and the first
+	// packet of the next one, in original time.
+	LoopGap time.Duration
+	// RewriteTimestamps sets the timestamp of each packet to the time it's
+	// returned at, according to the Clock.  Otherwise, timestamps are kept,
+	// and only shifted in later passes to follow on from the previous pass.
+	RewriteTimestamps bool
+	// Clock is used for pacing and rewriting timestamps.  It defaults to
+	// RealClock.
+	Clock Clock
+}
+
+type packet struct {
+	data []byte
+	ci   gopacket.CaptureInfo
+}
+
+// Source is a gopacket.PacketDataSource pacing the packets of another one.
+// Create one with NewSource.  It's not safe for concurrent use.
+type Source struct {
+	src  gopacket.PacketDataSource
+	opts Options
+
+	// buffered holds the packets of the first pass, when looping.
+	buffered []packet
+	pass     int
+	index    int
+	// start is the time the first packet was returned, and first the
+	// timestamp of the first packet.
+	start, first time.Time
+	// last is the original timestamp of the last packet read.
+	last time.Time
+	// offset shifts the timestamps of the current pass.
+	offset time.Duration
+	// count and bits count the packets and bits returned, for fixed rates.
+	count, bits float64
+}
+
+// NewSource creates a Source replaying the packets of src.
+func NewSource(src gopacket.PacketDataSource, opts Options) *Source {
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+	if opts.Clock == nil {
+		opts.Clock = RealClock
+	}
+	return &Source{src: src, opts: opts}
+}
+
+// ReadPacketData returns the next packet once it's due.  It returns io.EOF
+// once all passes are done, and errors from the underlying source as they
+// happen.
+func (s *Source) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	p, err := s.next()
+	if err != nil {
+		return nil, gopacket.CaptureInfo{}, err
+	}
+	orig := p.ci.Timestamp
+	if s.start.IsZero() {
+		s.start, s.first = s.opts.Clock.Now(), orig
+	}
+	s.wait(orig.Add(s.offset), p)
+	s.last = orig
+	if s.opts.RewriteTimestamps {
+		p.ci.Timestamp = s.opts.Clock.Now()
+	} else {
+		p.ci.Timestamp = orig.Add(s.offset)
+	}
+	return p.data, p.ci, nil
+}
+
+// next returns the next packet to replay, from the underlying source during
+// the first pass and from the buffer afterwards.
+func (s *Source) next() (packet, error) {
+	looping := s.opts.Loops < 0 || s.opts.Loops > 1
+	if s.pass == 0 {
+		data, ci, err := s.src.ReadPacketData()
+		if err == nil {
+			if looping {
+				// The buffer keeps its own copy, the caller being free to
+				// modify data.
+				s.buffered = append(s.buffered, packet{append([]byte(nil), data...), ci})
+			}
+			return packet{data, ci}, nil
+		}
+		if err != io.EOF || !looping || len(s.buffered) == 0 {
+			return packet{}, err
+		}
+		s.nextPass()
+	}
+	if s.index == len(s.buffered) {
+		if s.opts.Loops > 0 && s.pass+1 >= s.opts.Loops {
+			return packet{}, io.EOF
+		}
+		s.nextPass()
+	}
+	p := s.buffered[s.index]
+	s.index++
+	// Each caller gets its own copy, as with most PacketDataSources.
+	p.data = append([]byte(nil), p.data...)
+	return p, nil
+}
+
+// nextPass starts the next pass over the buffered packets.
+func (s *Source) nextPass() {
+	s.pass++
+	s.index = 0
+	s.offset += s.last.Sub(s.buffered[0].ci.Timestamp) + s.opts.LoopGap
+}
+
+// wait sleeps until the packet with the given (shifted) timestamp is due.
+func (s *Source) wait(ts time.Time, p packet) {
+	if s.opts.NoPacing {
+		return
+	}
+	var due time.Duration
+	switch {
+	case s.opts.PacketsPerSecond > 0:
+		due = time.Duration(s.count / s.opts.PacketsPerSecond * float64(time.Second))
+		s.count++
+	case s.opts.BitsPerSecond > 0:
+		due = time.Duration(s.bits / s.opts.BitsPerSecond * float64(time.Second))
+		length := p.ci.Length
+		if length == 0 {
+			length = len(p.data)
+		}
+		s.bits += float64(length) * 8
+	default:
+		due = time.Duration(float64(ts.Sub(s.first)) / s.opts.Speed)
+	}
+	// Sleeping until an absolute time keeps errors from accumulating.
+	if d := s.start.Add(due).Sub(s.opts.Clock.Now()); d > 0 {
+		s.opts.Clock.Sleep(d)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package replay
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+var t0 = time.Unix(1000, 0)
+
+// sliceSource returns packets of 100 bytes with the given offsets from t0.
+type sliceSource struct {
+	offsets []time.Duration
+	err     error
+}
+
+func (s *sliceSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if len(s.offsets) == 0 {
+		if s.err != nil {
+			return nil, gopacket.CaptureInfo{}, s.err
+		}
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+	ts := t0.Add(s.offsets[0])
+	s.offsets = s.offsets[1:]
+	return make([]byte, 100), gopacket.CaptureInfo{Timestamp: ts, CaptureLength: 100, Length: 100}, nil
+}
+
+// replayAll reads all packets, returning the clock time at which each packet
+// was returned (relative to the start) and their timestamps.
+func replayAll(t *testing.T, src *Source, clock *VirtualClock) (at []time.Duration, ts []time.Time) {
+	start := clock.Now()
+	for {
+		_, ci, err := src.ReadPacketData()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		at = append(at, clock.Now().Sub(start))
+		ts = append(ts, ci.Timestamp)
+		if len(at) > 100 {
+			t.Fatal("too many packets")
+		}
+	}
+}
+
+func durations(ms ...int) (d []time.Duration) {
+	for _, m := range ms {
+		d = append(d, time.Duration(m)*time.Millisecond)
+	}
+	return
+}
+
+func equalDurations(a, b []time.Duration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReplaySpeed(t *testing.T) {
+	for _, c := range []struct {
+		speed float64
+		want  []time.Duration
+	}{
+		{0, durations(0, 100, 300, 1000)},
+		{2, durations(0, 50, 150, 500)},
+		{0.5, durations(0, 200, 600, 2000)},
+	} {
+		clock := NewVirtualClock(time.Unix(5000, 0))
+		src := NewSource(&sliceSource{offsets: durations(0, 100, 300, 1000)}, Options{Speed: c.speed, Clock: clock})
+		at, ts := replayAll(t, src, clock)
+		if !equalDurations(at, c.want) {
+			t.Errorf("speed %v: got %v, want %v", c.speed, at, c.want)
+		}
+		if !ts[3].Equal(t0.Add(time.Second)) {
+			t.Errorf("speed %v: expected original timestamps, got %v", c.speed, ts)
+		}
+	}
+}
+
+func TestReplayFixedRates(t *testing.T) {
+	clock := NewVirtualClock(time.Unix(5000, 0))
+	src := NewSource(&sliceSource{offsets: durations(0, 0, 0, 5000)}, Options{PacketsPerSecond: 10, Clock: clock})
+	if at, _ := replayAll(t, src, clock); !equalDurations(at, durations(0, 100, 200, 300)) {
+		t.Errorf("pps: got %v", at)
+	}
+
+	// 100 byte packets at 8000 bits per second take 100ms each.
+	src = NewSource(&sliceSource{offsets: durations(0, 0, 0)}, Options{BitsPerSecond: 8000, Clock: clock})
+	if at, _ := replayAll(t, src, clock); !equalDurations(at, durations(0, 100, 200)) {
+		t.Errorf("bps: got %v", at)
+	}
+}
+
+func TestReplayLoop(t *testing.T) {
+	clock := NewVirtualClock(time.Unix(5000, 0))
+	src := NewSource(&sliceSource{offsets: durations(0, 100, 300)}, Options{Loops: 3, LoopGap: 50 * time.Millisecond, Clock: clock})
+	at, ts := replayAll(t, src, clock)
+	want := durations(0, 100, 300, 350, 450, 650, 700, 800, 1000)
+	if !equalDurations(at, want) {
+		t.Errorf("got %v, want %v", at, want)
+	}
+	for i := range ts {
+		if !ts[i].Equal(t0.Add(want[i])) {
+			t.Errorf("packet %d: got timestamp %v, want %v", i, ts[i], t0.Add(want[i]))
+		}
+	}
+}
+
+func TestReplayLoopCopies(t *testing.T) {
+	src := NewSource(&sliceSource{offsets: durations(0, 100)}, Options{Loops: 2, NoPacing: true})
+	for i := 0; i < 4; i++ {
+		data, _, err := src.ReadPacketData()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Packets of later passes aren't changed by callers modifying the
+		// data of earlier ones.
+		for j, b := range data {
+			if b != 0 {
+				t.Fatalf("packet %d: got byte %d = %d", i, j, b)
+			}
+		}
+		for j := range data {
+			data[j] = 0xff
+		}
+	}
+}
+
+func TestReplayRewriteTimestamps(t *testing.T) {
+	clock := NewVirtualClock(time.Unix(5000, 0))
+	src := NewSource(&sliceSource{offsets: durations(0, 100, 300)}, Options{Speed: 10, RewriteTimestamps: true, Clock: clock})
+	_, ts := replayAll(t, src, clock)
+	for i, want := range durations(0, 10, 30) {
+		if !ts[i].Equal(time.Unix(5000, 0).Add(want)) {
+			t.Errorf("packet %d: got timestamp %v", i, ts[i])
+		}
+	}
+}
+
+func TestReplayNoPacingForever(t *testing.T) {
+	clock := NewVirtualClock(time.Unix(5000, 0))
+	src := NewSource(&sliceSource{offsets: durations(0, 100)}, Options{Loops: -1, LoopGap: 100 * time.Millisecond, NoPacing: true, Clock: clock})
+	for i := 0; i < 10; i++ {
+		_, ci, err := src.ReadPacketData()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := t0.Add(time.Duration(i) * 100 * time.Millisecond); !ci.Timestamp.Equal(want) {
+			t.Errorf("packet %d: got timestamp %v, want %v", i, ci.Timestamp, want)
+		}
+	}
+	if clock.Now() != time.Unix(5000, 0) {
+		t.Error("expected no pacing")
+	}
+}
+
+func TestReplayError(t *testing.T) {
+	errTest := errors.New("test")
+	src := NewSource(&sliceSource{offsets: durations(0), err: errTest}, Options{Loops: 2, Clock: NewVirtualClock(t0)})
+	if _, _, err := src.ReadPacketData(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := src.ReadPacketData(); err != errTest {
+		t.Errorf("expected source error, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package rules
+
+// lower maps bytes to their ASCII lower case.
+var lower [256]byte
+
+func init() {
+	for i := range lower {
+		lower[i] = byte(i)
+		if i >= 'A' && i <= 'Z' {
+			lower[i] += 'a' - 'A'
+		}
+	}
+}
+
+type acNode struct {
+	next map[byte]int32
+	fail int32
+	// out holds the patterns ending at this node, including those found
+	// through its fail links.
+	out []int
+}
+
+// acMatcher is an Aho-Corasick automaton, finding ASCII case insensitive
+// occurrences of many patterns in a single pass over some data.
+type acMatcher struct {
+	nodes []acNode
+}
+
+func newACMatcher(patterns [][]byte) *acMatcher {
+	m := &acMatcher{nodes: []acNode{{next: map[byte]int32{}}}}
+	for id, p := range patterns {
+		n := int32(0)
+		for _, b := range p {
+			b = lower[b]
+			next, ok := m.nodes[n].next[b]
+			if !ok {
+				next = int32(len(m.nodes))
+				m.nodes = append(m.nodes, acNode{next: map[byte]int32{}})
+				m.nodes[n].next[b] = next
+			}
+			n = next
+		}
+		m.nodes[n].out = append(m.nodes[n].out, id)
+	}
+	// Breadth first, so fail links always point to finished nodes.
+	queue := make([]int32, 0, len(m.nodes))
+	for _, n := range m.nodes[0].next {
+		queue = append(queue, n)
+	}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for b, child := range m.nodes[n].next {
+			f := m.nodes[n].fail
+			for {
+				if next, ok := m.nodes[f].next[b]; ok {
+					m.nodes[child].fail = next
+					break
+				}
+				if f == 0 {
+					break
+				}
+				f = m.nodes[f].fail
+			}
+			if out := m.nodes[m.nodes[child].fail].out; len(out) > 0 {
+				m.nodes[child].out = append(m.nodes[child].out[:len(m.nodes[child].out):len(m.nodes[child].out)], out...)
+			}
+			queue = append(queue, child)
+		}
+	}
+	return m
+}
+
+// match calls found with the index of each pattern occurring in data, once
+// per occurrence.  It starts in the given state, 0 at the start of the data,
+// and returns the state after it, so patterns spanning the data of several
+// calls are found.
+func (m *acMatcher) match(n int32, data []byte, found func(id int)) int32 {
+	for _, b := range data {
+		b = lower[b]
+		for {
+			if next, ok := m.nodes[n].next[b]; ok {
+				n = next
+				break
+			}
+			if n == 0 {
+				break
+			}
+			n = m.nodes[n].fail
+		}
+		for _, id := range m.nodes[n].out {
+			found(id)
+		}
+	}
+	return n
+}
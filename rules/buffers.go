@@ -0,0 +1,167 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package rules
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+)
+
+// httpRequest holds the buffers of an HTTP request head.
+type httpRequest struct {
+	method, uri, host, userAgent, cookie, header []byte
+}
+
+// nextLine returns the line at the start of data, without its line ending,
+// and the rest of data.  ok is false if the line isn't complete.
+func nextLine(data []byte) (line, rest []byte, ok bool) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return data, nil, false
+	}
+	line, rest = data[:i], data[i+1:]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, rest, true
+}
+
+// isMethod returns whether b looks like an HTTP method.
+func isMethod(b []byte) bool {
+	if len(b) == 0 || len(b) > 16 {
+		return false
+	}
+	for _, c := range b {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseHTTPRequests parses the heads of the HTTP requests at the start of
+// data, skipping their bodies.  The head of the last request may be
+// incomplete.
+func parseHTTPRequests(data []byte) (requests []httpRequest) {
+	for len(data) > 0 {
+		line, rest, ok := nextLine(data)
+		fields := bytes.Fields(line)
+		if !ok || len(fields) != 3 || !isMethod(fields[0]) || !bytes.HasPrefix(fields[2], []byte("HTTP/")) {
+			return
+		}
+		r := httpRequest{method: fields[0], uri: fields[1]}
+		length, chunked, complete := 0, false, false
+		// The header buffer holds the header lines, without the request
+		// line and the empty line ending the head.
+		headers := rest
+		var headersEnd []byte
+		for {
+			headersEnd = rest
+			line, rest, ok = nextLine(rest)
+			if !ok {
+				headersEnd = nil
+				break
+			}
+			if len(line) == 0 {
+				complete = true
+				break
+			}
+			colon := bytes.IndexByte(line, ':')
+			if colon < 0 {
+				continue
+			}
+			name, value := bytes.TrimSpace(line[:colon]), bytes.TrimSpace(line[colon+1:])
+			switch string(bytes.ToLower(name)) {
+			case "host":
+				r.host = bytes.ToLower(value)
+			case "user-agent":
+				r.userAgent = value
+			case "cookie":
+				r.cookie = value
+			case "content-length":
+				length, _ = strconv.Atoi(string(value))
+			case "transfer-encoding":
+				chunked = true
+			}
+		}
+		r.header = headers[:len(headers)-len(headersEnd)]
+		requests = append(requests, r)
+		if !complete || chunked || length > len(rest) {
+			return
+		}
+		data = rest[length:]
+	}
+	return
+}
+
+// isHTTP returns whether data looks like the start of HTTP traffic.
+func isHTTP(data []byte, requests []httpRequest) bool {
+	return len(requests) > 0 || bytes.HasPrefix(data, []byte("HTTP/1."))
+}
+
+// isTLS returns whether data looks like the start of TLS traffic.
+func isTLS(data []byte) bool {
+	return len(data) >= 3 && data[0] >= 20 && data[0] <= 23 && data[1] == 3 && data[2] <= 4
+}
+
+// tlsSNI returns the server name of the TLS ClientHello at the start of
+// data, if any.
+func tlsSNI(data []byte) []byte {
+	// Record header, handshake header, version and random.
+	if len(data) < 43 || data[0] != 22 || data[5] != 1 {
+		return nil
+	}
+	data = data[43:]
+	skip := func(lengthBytes int) bool {
+		if len(data) < lengthBytes {
+			return false
+		}
+		n := 0
+		for _, b := range data[:lengthBytes] {
+			n = n<<8 | int(b)
+		}
+		if len(data) < lengthBytes+n {
+			return false
+		}
+		data = data[lengthBytes+n:]
+		return true
+	}
+	// Session ID, cipher suites and compression methods.
+	if !skip(1) || !skip(2) || !skip(1) || len(data) < 2 {
+		return nil
+	}
+	data = data[2:]
+	for len(data) >= 4 {
+		typ, n := binary.BigEndian.Uint16(data), int(binary.BigEndian.Uint16(data[2:]))
+		data = data[4:]
+		if n > len(data) {
+			return nil
+		}
+		if typ == 0 {
+			// server_name: a list of (type, name) with a 2 byte length.
+			ext := data[:n]
+			if len(ext) < 2 {
+				return nil
+			}
+			ext = ext[2:]
+			for len(ext) >= 3 {
+				l := int(binary.BigEndian.Uint16(ext[1:]))
+				if 3+l > len(ext) {
+					return nil
+				}
+				if ext[0] == 0 {
+					return ext[3 : 3+l]
+				}
+				ext = ext[3+l:]
+			}
+			return nil
+		}
+		data = data[n:]
+	}
+	return nil
+}
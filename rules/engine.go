@@ -0,0 +1,435 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package rules matches packets and TCP streams against signatures written
+// in a subset of the Snort and Suricata rule language, for lightweight
+// detection in Go programs.
+//
+// Rules are parsed with Parse or ParseRules, and compiled into an Engine,
+// which prefilters packets with an Aho-Corasick automaton over the fast
+// pattern of each rule, and only fully evaluates the rules whose pattern was
+// found:
+//
+//  rs, err := rules.ParseRules(f, rules.Vars{
+//    "HOME_NET":     "10.0.0.0/8",
+//    "EXTERNAL_NET": "!$HOME_NET",
+//  })
+//  engine := rules.NewEngine(rs, rules.Options{})
+//  for p := range packets {
+//    for _, a := range engine.MatchPacket(p) {
+//      log.Println(a.Rule, a.Network, a.Transport)
+//    }
+//  }
+//
+// Matching TCP payloads packet by packet misses patterns split between
+// segments, so the Engine can also match the streams reassembled by the
+// reassembly package:
+//
+//  factory := engine.NewStreamFactory(func(a rules.Alert) { ... })
+//  assembler := reassembly.NewAssembler(reassembly.NewStreamPool(factory))
+//
+// Rules can use flow:only_stream and flow:no_stream to select one of them
+// when both are used.
+package rules
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// DefaultStreamDepth is the default number of bytes inspected in each
+// direction of a stream.
+const DefaultStreamDepth = 1 << 20
+
+// DefaultMatchLimit is the default number of content and pcre matches tried
+// when evaluating a rule.
+const DefaultMatchLimit = 3000
+
+// Options configures an Engine.
+type Options struct {
+	// StreamDepth is the number of bytes inspected in each direction of a
+	// stream, DefaultStreamDepth if zero.  Streams are matched as a whole,
+	// so rules with patterns found early may be reevaluated against up to
+	// this many bytes for each new segment.
+	StreamDepth int
+	// MatchLimit is the number of content and pcre matches tried when
+	// evaluating a rule against a packet or stream, DefaultMatchLimit if
+	// zero.  Matches followed by relative ones are retried at each
+	// occurrence, which takes exponential time in the number of relative
+	// matches; rules reaching the limit don't match, like with Suricata's
+	// inspection-recursion-limit.
+	MatchLimit int
+}
+
+// Alert is a match of a rule.
+type Alert struct {
+	Rule *Rule
+	// Timestamp is the time of the matching packet, or of the last packet
+	// of the matching stream data.
+	Timestamp time.Time
+	// Network and Transport are the flows of the matching packet or stream
+	// data, from its sender to its receiver.
+	Network, Transport gopacket.Flow
+	// ToServer is set if the matching packet or data was sent by the client
+	// of its connection, as far as is known.
+	ToServer bool
+	// Packet is the matching packet, or nil for streams.
+	Packet gopacket.Packet
+}
+
+// inspection is what rules are matched against.
+type inspection struct {
+	proto        layers.IPProtocol
+	src, dst     net.IP
+	sport, dport int
+	tcpFlags     uint8
+	stream       bool
+	// flowKnown is set if the connection was seen from its start, so
+	// established and toServer are known.
+	flowKnown, established, toServer bool
+	// bufs holds the buffers, nil if they're not present.
+	bufs [numBuffers][]byte
+	app  string
+}
+
+// Engine matches packets and streams against rules.  Create one with
+// NewEngine.  It's safe for concurrent use.
+type Engine struct {
+	rules []*Rule
+	opts  Options
+	// matchers find the fast patterns of the rules in each buffer, and
+	// patterns maps the indexes of their patterns to rules.
+	matchers [numBuffers]*acMatcher
+	patterns [numBuffers][]int
+	// always marks the rules without a fast pattern, which are evaluated
+	// against everything.
+	always     []bool
+	trackFlows bool
+
+	mu    sync.Mutex
+	flows map[flowKey]*flowState
+}
+
+// NewEngine compiles rules into an Engine.
+func NewEngine(rules []*Rule, opts Options) *Engine {
+	if opts.StreamDepth <= 0 {
+		opts.StreamDepth = DefaultStreamDepth
+	}
+	if opts.MatchLimit <= 0 {
+		opts.MatchLimit = DefaultMatchLimit
+	}
+	e := &Engine{
+		rules:  rules,
+		opts:   opts,
+		always: make([]bool, len(rules)),
+		flows:  map[flowKey]*flowState{},
+	}
+	var patterns [numBuffers][][]byte
+	for i, r := range rules {
+		if r.fast < 0 {
+			e.always[i] = true
+		} else {
+			m := r.matches[r.fast]
+			patterns[m.buffer] = append(patterns[m.buffer], m.content)
+			e.patterns[m.buffer] = append(e.patterns[m.buffer], i)
+		}
+		f := r.flow
+		if f.established || f.notEstablished || f.toServer || f.toClient {
+			e.trackFlows = true
+		}
+	}
+	for b, p := range patterns {
+		if p != nil {
+			e.matchers[b] = newACMatcher(p)
+		}
+	}
+	return e
+}
+
+// Rules returns the rules of the Engine.
+func (e *Engine) Rules() []*Rule {
+	return e.rules
+}
+
+// candidates returns the rules which may match in, adding those whose fast
+// pattern is found in its buffers to the rules already in cand, if any.
+// The payload buffer is only searched if payload is set.
+func (e *Engine) candidates(in *inspection, cand []bool, payload bool) []bool {
+	if cand == nil {
+		cand = append([]bool(nil), e.always...)
+	}
+	for b, data := range in.bufs {
+		if data == nil || e.matchers[b] == nil || (b == int(bufPayload) && !payload) {
+			continue
+		}
+		rules := e.patterns[b]
+		e.matchers[b].match(0, data, func(id int) { cand[rules[id]] = true })
+	}
+	return cand
+}
+
+// detect evaluates the candidate rules against in, and in with the buffers
+// of each HTTP request in its payload, calling found with each matching rule
+// not alerted yet, and marking it as alerted.
+func (e *Engine) detect(in *inspection, cand, alerted []bool, found func(*Rule)) {
+	payload := in.bufs[bufPayload]
+	requests := parseHTTPRequests(payload)
+	switch {
+	case isHTTP(payload, requests):
+		in.app = "http"
+	case isTLS(payload):
+		in.app = "tls"
+		in.bufs[bufTLSSNI] = tlsSNI(payload)
+	}
+	run := func(cand []bool) {
+		for i, ok := range cand {
+			if ok && !alerted[i] && e.rules[i].matchesHeader(in) && e.rules[i].matchesBuffers(&in.bufs, e.opts.MatchLimit) {
+				alerted[i] = true
+				found(e.rules[i])
+			}
+		}
+	}
+	if len(requests) == 0 {
+		run(e.candidates(in, cand, false))
+		return
+	}
+	for _, r := range requests {
+		in.bufs[bufHTTPMethod], in.bufs[bufHTTPURI], in.bufs[bufHTTPHeader] = r.method, r.uri, r.header
+		in.bufs[bufHTTPHost], in.bufs[bufHTTPUserAgent], in.bufs[bufHTTPCookie] = r.host, r.userAgent, r.cookie
+		run(e.candidates(in, append([]bool(nil), cand...), false))
+	}
+}
+
+// MatchPacket matches a packet against the rules, returning an alert for
+// each matching rule.  Rules are matched against the payload of TCP, UDP
+// and ICMP packets, or of IP packets of other protocols.
+func (e *Engine) MatchPacket(p gopacket.Packet) []Alert {
+	n := p.NetworkLayer()
+	if n == nil {
+		return nil
+	}
+	in := inspection{proto: layers.IPProtocolNoNextHeader}
+	switch n := n.(type) {
+	case *layers.IPv4:
+		in.proto, in.src, in.dst = n.Protocol, n.SrcIP, n.DstIP
+	case *layers.IPv6:
+		in.proto, in.src, in.dst = n.NextHeader, n.SrcIP, n.DstIP
+		if ext := p.Layer(layers.LayerTypeIPv6Fragment); ext != nil {
+			in.proto = ext.(*layers.IPv6Fragment).NextHeader
+		}
+	default:
+		return nil
+	}
+	in.bufs[bufPayload] = n.LayerPayload()
+	var transport gopacket.Flow
+	switch t := p.TransportLayer().(type) {
+	case *layers.TCP:
+		in.proto, in.sport, in.dport = layers.IPProtocolTCP, int(t.SrcPort), int(t.DstPort)
+		in.tcpFlags = tcpFlags(t)
+		transport, in.bufs[bufPayload] = t.TransportFlow(), t.Payload
+	case *layers.UDP:
+		in.proto, in.sport, in.dport = layers.IPProtocolUDP, int(t.SrcPort), int(t.DstPort)
+		transport, in.bufs[bufPayload] = t.TransportFlow(), t.Payload
+	default:
+		if l := p.Layer(layers.LayerTypeICMPv4); l != nil {
+			in.proto, in.bufs[bufPayload] = layers.IPProtocolICMPv4, l.LayerPayload()
+		} else if l := p.Layer(layers.LayerTypeICMPv6); l != nil {
+			in.proto, in.bufs[bufPayload] = layers.IPProtocolICMPv6, l.LayerPayload()
+		}
+	}
+	if in.bufs[bufPayload] == nil {
+		in.bufs[bufPayload] = []byte{}
+	}
+	ts := p.Metadata().Timestamp
+	if e.trackFlows {
+		e.trackFlow(&in, flowKey{n.NetworkFlow(), transport}, ts)
+	}
+
+	var alerts []Alert
+	cand := e.candidates(&in, nil, true)
+	e.detect(&in, cand, make([]bool, len(e.rules)), func(r *Rule) {
+		alerts = append(alerts, Alert{
+			Rule:      r,
+			Timestamp: ts,
+			Network:   n.NetworkFlow(),
+			Transport: transport,
+			ToServer:  in.flowKnown && in.toServer,
+			Packet:    p,
+		})
+	})
+	return alerts
+}
+
+func tcpFlags(t *layers.TCP) (flags uint8) {
+	for i, f := range []bool{t.FIN, t.SYN, t.RST, t.PSH, t.ACK, t.URG, t.ECE, t.CWR} {
+		if f {
+			flags |= 1 << uint(i)
+		}
+	}
+	return
+}
+
+// matchesHeader returns whether the rule header and non payload options
+// match in.
+func (r *Rule) matchesHeader(in *inspection) bool {
+	switch r.Protocol {
+	case "tcp":
+		if in.proto != layers.IPProtocolTCP {
+			return false
+		}
+	case "udp":
+		if in.proto != layers.IPProtocolUDP {
+			return false
+		}
+	case "icmp":
+		if in.proto != layers.IPProtocolICMPv4 && in.proto != layers.IPProtocolICMPv6 {
+			return false
+		}
+	case "http", "tls":
+		if in.proto != layers.IPProtocolTCP || in.app != r.Protocol {
+			return false
+		}
+	}
+	if in.stream && (r.flow.noStream || r.dsize != nil || r.flags != nil) || !in.stream && r.flow.onlyStream {
+		return false
+	}
+	if (r.flow.established && !in.established) || (r.flow.notEstablished && in.established) ||
+		(r.flow.toServer && !(in.flowKnown && in.toServer)) || (r.flow.toClient && !(in.flowKnown && !in.toServer)) {
+		return false
+	}
+	if r.dsize != nil {
+		if n := len(in.bufs[bufPayload]); n < r.dsize.min || n > r.dsize.max {
+			return false
+		}
+	}
+	if r.flags != nil && (in.proto != layers.IPProtocolTCP || !r.flags.matches(in.tcpFlags)) {
+		return false
+	}
+	ports := in.proto == layers.IPProtocolTCP || in.proto == layers.IPProtocolUDP
+	if r.src.matches(in.src) && r.dst.matches(in.dst) &&
+		(!ports || r.srcPorts.matches(in.sport) && r.dstPorts.matches(in.dport)) {
+		return true
+	}
+	return r.bidirectional && r.src.matches(in.dst) && r.dst.matches(in.src) &&
+		(!ports || r.srcPorts.matches(in.dport) && r.dstPorts.matches(in.sport))
+}
+
+// matchesBuffers returns whether the contents and pcres of the rule match
+// the buffers, trying at most limit matches.
+func (r *Rule) matchesBuffers(bufs *[numBuffers][]byte, limit int) bool {
+	var pos [numBuffers]int
+	return matchFrom(r.matches, bufs, pos, &limit)
+}
+
+// matchFrom returns whether matches match the buffers, given the end of the
+// previous match in each buffer.  It returns false once it has tried limit
+// matches, decrementing it for each one.
+func matchFrom(matches []match, bufs *[numBuffers][]byte, pos [numBuffers]int, limit *int) bool {
+	if len(matches) == 0 {
+		return true
+	}
+	m := &matches[0]
+	data := bufs[m.buffer]
+	if data == nil {
+		return false
+	}
+	start, end := m.window(pos[m.buffer], len(data))
+	if *limit <= 0 {
+		return false
+	}
+	*limit--
+	if m.negated {
+		if at, _ := m.find(data, start, end); at >= 0 {
+			return false
+		}
+		return matchFrom(matches[1:], bufs, pos, limit)
+	}
+	for {
+		at, matchEnd := m.find(data, start, end)
+		if at < 0 {
+			return false
+		}
+		next := pos
+		next[m.buffer] = matchEnd
+		if matchFrom(matches[1:], bufs, next, limit) {
+			return true
+		}
+		if !m.backtrack || *limit <= 0 {
+			return false
+		}
+		*limit--
+		start = at + 1
+	}
+}
+
+// flowKey is a connection, oriented from its client to its server.
+type flowKey struct {
+	network, transport gopacket.Flow
+}
+
+type flowState struct {
+	synAck, established bool
+	lastSeen            time.Time
+}
+
+// trackFlow updates the state of the connection of a packet, and sets the
+// flow fields of its inspection.
+func (e *Engine) trackFlow(in *inspection, k flowKey, ts time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	toServer := true
+	s := e.flows[k]
+	if s == nil {
+		if s = e.flows[flowKey{k.network.Reverse(), k.transport.Reverse()}]; s != nil {
+			toServer = false
+		}
+	}
+	if in.proto == layers.IPProtocolTCP {
+		syn, ack := in.tcpFlags&flagSYN != 0, in.tcpFlags&flagACK != 0
+		switch {
+		case syn && !ack:
+			// A new connection, or a retransmitted SYN.
+			if s == nil || !toServer {
+				s, toServer = &flowState{}, true
+				e.flows[k] = s
+			}
+		case s == nil:
+			// Connections seen from the middle aren't tracked.
+			return
+		case syn && !toServer:
+			s.synAck = true
+		case ack && toServer && s.synAck:
+			s.established = true
+		}
+	} else if s == nil {
+		s = &flowState{}
+		e.flows[k] = s
+	} else if !toServer {
+		s.established = true
+	}
+	s.lastSeen = ts
+	in.flowKnown, in.toServer, in.established = true, toServer, s.established
+}
+
+// FlushOlderThan forgets the state of the connections without packets since
+// t, returning how many were forgotten.  It should be called regularly by
+// users of MatchPacket, if rules use the flow keyword with anything but
+// stateless, only_stream or no_stream.
+func (e *Engine) FlushOlderThan(t time.Time) (flushed int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for k, s := range e.flows {
+		if s.lastSeen.Before(t) {
+			delete(e.flows, k)
+			flushed++
+		}
+	}
+	return
+}
@@ -0,0 +1,861 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package rules
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Vars holds the values of the variables used in rule headers, such as
+// HOME_NET or HTTP_PORTS, keyed by name without the leading $.
+type Vars map[string]string
+
+// buffer is a part of a packet or stream that contents are matched against.
+type buffer int
+
+const (
+	bufPayload buffer = iota
+	bufHTTPMethod
+	bufHTTPURI
+	bufHTTPHost
+	bufHTTPUserAgent
+	bufHTTPCookie
+	bufHTTPHeader
+	bufTLSSNI
+	numBuffers
+)
+
+// stickyBuffers are the keywords setting the buffer of the following
+// contents and pcres.
+var stickyBuffers = map[string]buffer{
+	"pkt_data":        bufPayload,
+	"http.method":     bufHTTPMethod,
+	"http.uri":        bufHTTPURI,
+	"http.uri.raw":    bufHTTPURI,
+	"http.host":       bufHTTPHost,
+	"http.user_agent": bufHTTPUserAgent,
+	"http.cookie":     bufHTTPCookie,
+	"http.header":     bufHTTPHeader,
+	"tls.sni":         bufTLSSNI,
+}
+
+// contentModifiers are the older keywords setting the buffer of the
+// preceding content.
+var contentModifiers = map[string]buffer{
+	"http_method":     bufHTTPMethod,
+	"http_uri":        bufHTTPURI,
+	"http_raw_uri":    bufHTTPURI,
+	"http_host":       bufHTTPHost,
+	"http_user_agent": bufHTTPUserAgent,
+	"http_cookie":     bufHTTPCookie,
+	"http_header":     bufHTTPHeader,
+}
+
+// informational are the keywords which don't affect matching.
+var informational = map[string]bool{
+	"gid":       true,
+	"reference": true,
+	"metadata":  true,
+	"priority":  true,
+	"target":    true,
+}
+
+// match is a content or pcre keyword.
+type match struct {
+	buffer  buffer
+	content []byte
+	re      *regexp.Regexp
+	negated bool
+	nocase  bool
+	// offset and depth set the window of absolute matches, distance and
+	// within that of relative ones, from the end of the previous match in
+	// the same buffer.
+	offset, depth, distance, within int
+	hasDepth, hasWithin, relative   bool
+	hasOffset, fastPattern          bool
+	// backtrack is set if a later match is relative to this one, so
+	// further occurrences must be tried if it fails.
+	backtrack bool
+}
+
+// window returns the part of data a match may occur in, given the end of
+// the previous match in its buffer.
+func (m *match) window(prev, length int) (start, end int) {
+	start, end = 0, length
+	if m.relative {
+		start = prev + m.distance
+		if m.hasWithin {
+			end = start + m.within
+		}
+	} else {
+		start = m.offset
+		if m.hasDepth {
+			end = start + m.depth
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end > length {
+		end = length
+	}
+	return
+}
+
+// find returns the first occurrence of the match in data[start:end].
+func (m *match) find(data []byte, start, end int) (at, matchEnd int) {
+	if start > end {
+		return -1, -1
+	}
+	if m.re != nil {
+		loc := m.re.FindIndex(data[start:end])
+		if loc == nil {
+			return -1, -1
+		}
+		return start + loc[0], start + loc[1]
+	}
+	n := len(m.content)
+search:
+	for i := start; i+n <= end; i++ {
+		for j, b := range m.content {
+			c := data[i+j]
+			if c != b && (!m.nocase || lower[c] != lower[b]) {
+				continue search
+			}
+		}
+		return i, i + n
+	}
+	return -1, -1
+}
+
+// flowSpec holds the flow keyword.
+type flowSpec struct {
+	established, notEstablished, stateless bool
+	toServer, toClient                     bool
+	onlyStream, noStream                   bool
+}
+
+// dsizeSpec holds the dsize keyword.
+type dsizeSpec struct {
+	min, max int
+}
+
+const (
+	flagFIN = 1 << iota
+	flagSYN
+	flagRST
+	flagPSH
+	flagACK
+	flagURG
+	flagECE
+	flagCWR
+)
+
+var flagLetters = map[byte]uint8{
+	'F': flagFIN, 'S': flagSYN, 'R': flagRST, 'P': flagPSH,
+	'A': flagACK, 'U': flagURG, 'E': flagECE, 'C': flagCWR,
+	'2': flagECE, '1': flagCWR,
+}
+
+// flagsSpec holds the flags keyword.
+type flagsSpec struct {
+	flags, ignore uint8
+	// mode is 0 for an exact match, or the modifier '+', '*' or '!'.
+	mode byte
+}
+
+func (f *flagsSpec) matches(flags uint8) bool {
+	flags &^= f.ignore
+	switch f.mode {
+	case '+':
+		return flags&f.flags == f.flags
+	case '*':
+		return flags&f.flags != 0
+	case '!':
+		return flags&f.flags == 0
+	}
+	return flags == f.flags
+}
+
+// Rule is a parsed rule.
+type Rule struct {
+	// Action is the rule's action, such as "alert" or "drop".  Matches of
+	// all rules are reported alike: acting on them is up to the caller.
+	Action string
+	// Protocol is the protocol of the rule header: "ip", "tcp", "udp",
+	// "icmp", or the application protocols "http" and "tls", which are
+	// detected in TCP payloads.
+	Protocol string
+	// Msg, SID, Rev and Classtype are the values of the keywords of the
+	// same names.
+	Msg       string
+	SID, Rev  int
+	Classtype string
+	// Text is the text the rule was parsed from.
+	Text string
+
+	src, dst           addrSpec
+	srcPorts, dstPorts portSpec
+	bidirectional      bool
+	matches            []match
+	flow               flowSpec
+	dsize              *dsizeSpec
+	flags              *flagsSpec
+	// fast is the index in matches of the fast pattern, or -1.
+	fast int
+}
+
+func (r *Rule) String() string {
+	return fmt.Sprintf("[%d:%d] %s", r.SID, r.Rev, r.Msg)
+}
+
+// Parse parses a rule, resolving the variables in its header from vars.
+//
+// The header is "action protocol source ports direction destination ports",
+// where the direction is "->" or "<>", addresses and ports may be "any",
+// lists, negations or variables, and port ranges are written "lo:hi".  The
+// supported options are:
+//
+//  msg, sid, rev, classtype, and gid, reference, metadata, priority and
+//    target, which are ignored
+//  content (negated with !) and its modifiers nocase, offset, depth,
+//    distance, within, fast_pattern, and http_uri, http_raw_uri,
+//    http_method, http_host, http_user_agent, http_cookie and http_header
+//  pcre, with the flags i, s, m and R, as far as Go's regexp syntax allows
+//  the sticky buffers pkt_data, http.method, http.uri, http.uri.raw,
+//    http.host, http.user_agent, http.cookie, http.header and tls.sni
+//  flow, with established, not_established, stateless, to_server,
+//    from_client, to_client, from_server, only_stream and no_stream
+//  dsize, as "n", "<n", ">n" or "min<>max" (exclusive)
+//  flags, with the modifiers +, * and !, and a mask of flags to ignore
+//
+// Rules using any other option fail to parse, rather than match more than
+// they should.
+func Parse(text string, vars Vars) (*Rule, error) {
+	r := &Rule{Text: text, fast: -1}
+	open := strings.IndexByte(text, '(')
+	close := strings.LastIndexByte(text, ')')
+	if open < 0 || close < open {
+		return nil, errors.New("rule options not found")
+	}
+	header := strings.Fields(text[:open])
+	if len(header) != 7 {
+		return nil, fmt.Errorf("invalid rule header %q", strings.TrimSpace(text[:open]))
+	}
+	r.Action, r.Protocol = header[0], header[1]
+	switch r.Protocol {
+	case "ip", "tcp", "udp", "icmp", "http", "tls":
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q", r.Protocol)
+	}
+	switch header[4] {
+	case "->":
+	case "<>":
+		r.bidirectional = true
+	default:
+		return nil, fmt.Errorf("invalid direction %q", header[4])
+	}
+	if err := r.src.parse(header[2], vars); err != nil {
+		return nil, err
+	}
+	if err := r.dst.parse(header[5], vars); err != nil {
+		return nil, err
+	}
+	if err := r.srcPorts.parse(header[3], vars); err != nil {
+		return nil, err
+	}
+	if err := r.dstPorts.parse(header[6], vars); err != nil {
+		return nil, err
+	}
+	if (r.Protocol == "ip" || r.Protocol == "icmp") && !(r.srcPorts.any() && r.dstPorts.any()) {
+		return nil, fmt.Errorf("ports given for protocol %q", r.Protocol)
+	}
+
+	options, err := splitOptions(text[open+1 : close])
+	if err != nil {
+		return nil, err
+	}
+	cur := bufPayload
+	for _, o := range options {
+		if err := r.option(o[0], o[1], &cur); err != nil {
+			return nil, fmt.Errorf("%s: %v", o[0], err)
+		}
+	}
+	if r.SID == 0 {
+		return nil, errors.New("rule has no sid")
+	}
+	r.finish()
+	return r, nil
+}
+
+// splitOptions splits rule options into keywords and values.
+func splitOptions(s string) ([][2]string, error) {
+	var options [][2]string
+	quoted, escaped := false, false
+	start := 0
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) {
+			c := s[i]
+			switch {
+			case escaped:
+				escaped = false
+				continue
+			case c == '\\':
+				escaped = true
+				continue
+			case c == '"':
+				quoted = !quoted
+				continue
+			case c != ';' || quoted:
+				continue
+			}
+		} else if strings.TrimSpace(s[start:]) == "" {
+			break
+		} else {
+			return nil, errors.New("rule options must end with ;")
+		}
+		o := strings.TrimSpace(s[start:i])
+		start = i + 1
+		if o == "" {
+			continue
+		}
+		key, value := o, ""
+		if colon := strings.IndexByte(o, ':'); colon >= 0 {
+			key, value = strings.TrimSpace(o[:colon]), strings.TrimSpace(o[colon+1:])
+		}
+		options = append(options, [2]string{key, value})
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote in rule options")
+	}
+	return options, nil
+}
+
+// unquote removes the quotes around an option value, and the escapes of
+// quotes, backslashes, semicolons and colons in it.  It also returns whether
+// the value was negated with a leading !.
+func unquote(s string) (string, bool, error) {
+	negated := strings.HasPrefix(s, "!")
+	if negated {
+		s = strings.TrimSpace(s[1:])
+	}
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", false, fmt.Errorf("expected quoted string, got %q", s)
+	}
+	s = s[1 : len(s)-1]
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`"\;:`, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String(), negated, nil
+}
+
+// parseContent decodes the |hex| sections of a content.
+func parseContent(s string) ([]byte, error) {
+	var out []byte
+	for {
+		pipe := strings.IndexByte(s, '|')
+		if pipe < 0 {
+			out = append(out, s...)
+			break
+		}
+		out = append(out, s[:pipe]...)
+		s = s[pipe+1:]
+		end := strings.IndexByte(s, '|')
+		if end < 0 {
+			return nil, errors.New("unterminated hex section")
+		}
+		for _, h := range strings.Fields(s[:end]) {
+			for len(h) > 0 {
+				if len(h) == 1 {
+					return nil, fmt.Errorf("odd number of hex digits in %q", s[:end])
+				}
+				b, err := strconv.ParseUint(h[:2], 16, 8)
+				if err != nil {
+					return nil, fmt.Errorf("invalid hex byte %q", h[:2])
+				}
+				out = append(out, byte(b))
+				h = h[2:]
+			}
+		}
+		s = s[end+1:]
+	}
+	if len(out) == 0 {
+		return nil, errors.New("empty content")
+	}
+	return out, nil
+}
+
+func (r *Rule) lastContent() (*match, error) {
+	if len(r.matches) == 0 || r.matches[len(r.matches)-1].content == nil {
+		return nil, errors.New("no preceding content")
+	}
+	return &r.matches[len(r.matches)-1], nil
+}
+
+func (r *Rule) option(key, value string, cur *buffer) error {
+	if b, ok := stickyBuffers[key]; ok {
+		*cur = b
+		return nil
+	}
+	if b, ok := contentModifiers[key]; ok {
+		m, err := r.lastContent()
+		if err == nil {
+			m.buffer = b
+		}
+		return err
+	}
+	if informational[key] {
+		return nil
+	}
+	switch key {
+	case "msg":
+		msg, _, err := unquote(value)
+		r.Msg = msg
+		return err
+	case "sid", "rev":
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid value %q", value)
+		}
+		if key == "sid" {
+			r.SID = n
+		} else {
+			r.Rev = n
+		}
+	case "classtype":
+		r.Classtype = value
+	case "content":
+		s, negated, err := unquote(value)
+		if err != nil {
+			return err
+		}
+		content, err := parseContent(s)
+		if err != nil {
+			return err
+		}
+		r.matches = append(r.matches, match{buffer: *cur, content: content, negated: negated})
+	case "nocase", "fast_pattern":
+		m, err := r.lastContent()
+		if err != nil {
+			return err
+		}
+		if key == "nocase" {
+			m.nocase = true
+		} else if m.negated {
+			return errors.New("negated content can't be the fast pattern")
+		} else {
+			m.fastPattern = true
+		}
+	case "offset", "depth", "distance", "within":
+		m, err := r.lastContent()
+		if err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || (n < 0 && key != "distance") {
+			return fmt.Errorf("invalid value %q", value)
+		}
+		switch key {
+		case "offset":
+			m.offset, m.hasOffset = n, true
+		case "depth":
+			if n < len(m.content) {
+				return errors.New("depth is shorter than the content")
+			}
+			m.depth, m.hasDepth = n, true
+		case "distance":
+			m.distance, m.relative = n, true
+		case "within":
+			if n < len(m.content) {
+				return errors.New("within is shorter than the content")
+			}
+			m.within, m.hasWithin, m.relative = n, true, true
+		}
+		if m.relative && (m.hasOffset || m.hasDepth) {
+			return errors.New("can't mix offset or depth with distance or within")
+		}
+	case "pcre":
+		s, negated, err := unquote(value)
+		if err != nil {
+			return err
+		}
+		slash := strings.LastIndexByte(s, '/')
+		if len(s) < 2 || s[0] != '/' || slash == 0 {
+			return fmt.Errorf("invalid pcre %q", s)
+		}
+		m := match{buffer: *cur, negated: negated}
+		expr, flags := s[1:slash], ""
+		for _, f := range s[slash+1:] {
+			switch f {
+			case 'i', 's', 'm':
+				flags += string(f)
+			case 'R':
+				m.relative = true
+			default:
+				return fmt.Errorf("unsupported pcre flag %q", f)
+			}
+		}
+		if flags != "" {
+			expr = "(?" + flags + ")" + expr
+		}
+		if m.re, err = regexp.Compile(expr); err != nil {
+			return err
+		}
+		r.matches = append(r.matches, m)
+	case "flow":
+		for _, f := range strings.Split(value, ",") {
+			switch strings.TrimSpace(f) {
+			case "established":
+				r.flow.established = true
+			case "not_established":
+				r.flow.notEstablished = true
+			case "stateless":
+				r.flow.stateless = true
+			case "to_server", "from_client":
+				r.flow.toServer = true
+			case "to_client", "from_server":
+				r.flow.toClient = true
+			case "only_stream":
+				r.flow.onlyStream = true
+			case "no_stream":
+				r.flow.noStream = true
+			default:
+				return fmt.Errorf("unsupported flow option %q", f)
+			}
+		}
+		if (r.flow.established && r.flow.notEstablished) || (r.flow.toServer && r.flow.toClient) || (r.flow.onlyStream && r.flow.noStream) {
+			return fmt.Errorf("conflicting flow options %q", value)
+		}
+	case "dsize":
+		d, err := parseDsize(value)
+		if err != nil {
+			return err
+		}
+		r.dsize = d
+	case "flags":
+		f, err := parseFlags(value)
+		if err != nil {
+			return err
+		}
+		r.flags = f
+	default:
+		return errors.New("unsupported rule option")
+	}
+	return nil
+}
+
+func parseDsize(s string) (*dsizeSpec, error) {
+	atoi := func(s string) (int, error) {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid dsize %q", s)
+		}
+		return n, nil
+	}
+	d := &dsizeSpec{min: 0, max: math.MaxInt32}
+	var err error
+	switch i := strings.Index(s, "<>"); {
+	case i >= 0:
+		if d.min, err = atoi(s[:i]); err == nil {
+			d.max, err = atoi(s[i+2:])
+			d.min++
+			d.max--
+		}
+	case strings.HasPrefix(s, "<"):
+		d.max, err = atoi(s[1:])
+		d.max--
+	case strings.HasPrefix(s, ">"):
+		d.min, err = atoi(s[1:])
+		d.min++
+	default:
+		d.min, err = atoi(s)
+		d.max = d.min
+	}
+	return d, err
+}
+
+func parseFlags(s string) (*flagsSpec, error) {
+	f := &flagsSpec{}
+	mask := ""
+	if comma := strings.IndexByte(s, ','); comma >= 0 {
+		s, mask = s[:comma], s[comma+1:]
+	}
+	s = strings.TrimSpace(s)
+	for _, c := range []byte(s) {
+		switch {
+		case c == '+' || c == '*' || c == '!':
+			f.mode = c
+		case c == '0':
+		case flagLetters[c] != 0:
+			f.flags |= flagLetters[c]
+		default:
+			return nil, fmt.Errorf("invalid flag %q", c)
+		}
+	}
+	for _, c := range []byte(strings.TrimSpace(mask)) {
+		if flagLetters[c] == 0 {
+			return nil, fmt.Errorf("invalid flag %q", c)
+		}
+		f.ignore |= flagLetters[c]
+	}
+	return f, nil
+}
+
+// finish picks the fast pattern, and marks the matches later ones are
+// relative to.
+func (r *Rule) finish() {
+	longest := -1
+	for i := range r.matches {
+		m := &r.matches[i]
+		if m.fastPattern {
+			r.fast = i
+		}
+		if m.content != nil && !m.negated && (longest < 0 || len(m.content) > len(r.matches[longest].content)) {
+			longest = i
+		}
+		for j := i + 1; j < len(r.matches); j++ {
+			if r.matches[j].buffer == m.buffer {
+				m.backtrack = r.matches[j].relative
+				break
+			}
+		}
+	}
+	if r.fast < 0 {
+		r.fast = longest
+	}
+}
+
+// ParseError is a rule which failed to parse.
+type ParseError struct {
+	Line int
+	Text string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// ParseErrors lists the rules which failed to parse in ParseRules.
+type ParseErrors []*ParseError
+
+func (e ParseErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%v (and %d more errors)", e[0], len(e)-1)
+}
+
+// ParseRules parses a rules file, with one rule per line, continued on the
+// next lines if it ends with a backslash.  Empty lines and comments starting
+// with # are skipped.
+//
+// Rules which fail to parse are skipped, and reported in a ParseErrors
+// error, so that a rule set can be loaded even if some of its rules use
+// unsupported options.  Other errors are returned as is.
+func ParseRules(r io.Reader, vars Vars) ([]*Rule, error) {
+	var rules []*Rule
+	var errs ParseErrors
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	var text string
+	line, start := 0, 0
+	for scanner.Scan() {
+		line++
+		s := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			start = line
+			if s == "" || s[0] == '#' {
+				continue
+			}
+		}
+		if strings.HasSuffix(s, "\\") {
+			text += s[:len(s)-1]
+			continue
+		}
+		text += s
+		rule, err := Parse(text, vars)
+		if err != nil {
+			errs = append(errs, &ParseError{Line: start, Text: text, Err: err})
+		} else {
+			rules = append(rules, rule)
+		}
+		text = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return rules, err
+	}
+	if errs != nil {
+		return rules, errs
+	}
+	return rules, nil
+}
+
+// expand resolves the variables, lists and negations of an address or port
+// specification, calling leaf with each element.
+func expand(s string, vars Vars, negated bool, depth int, leaf func(s string, negated bool) error) error {
+	if depth > 16 {
+		return errors.New("rule variables nested too deep")
+	}
+	s = strings.TrimSpace(s)
+	for strings.HasPrefix(s, "!") {
+		negated = !negated
+		s = strings.TrimSpace(s[1:])
+	}
+	switch {
+	case strings.HasPrefix(s, "$"):
+		v, ok := vars[s[1:]]
+		if !ok {
+			return fmt.Errorf("undefined variable %s", s)
+		}
+		return expand(v, vars, negated, depth+1, leaf)
+	case strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]"):
+		s = s[1 : len(s)-1]
+		nesting, start := 0, 0
+		for i := 0; i <= len(s); i++ {
+			if i < len(s) {
+				switch s[i] {
+				case '[':
+					nesting++
+				case ']':
+					nesting--
+				}
+				if s[i] != ',' || nesting > 0 {
+					continue
+				}
+			}
+			if err := expand(s[start:i], vars, negated, depth+1, leaf); err != nil {
+				return err
+			}
+			start = i + 1
+		}
+		return nil
+	case s == "any":
+		if negated {
+			return errors.New("!any matches nothing")
+		}
+	}
+	return leaf(s, negated)
+}
+
+// addrSpec is an address specification, matching addresses in one of nets
+// (or any address, if there are none) and in none of not.
+type addrSpec struct {
+	nets, not []*net.IPNet
+}
+
+func (a *addrSpec) parse(s string, vars Vars) error {
+	return expand(s, vars, false, 0, func(s string, negated bool) error {
+		if s == "any" {
+			a.nets = append(a.nets, nil)
+			return nil
+		}
+		if !strings.Contains(s, "/") {
+			if strings.Contains(s, ":") {
+				s += "/128"
+			} else {
+				s += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return fmt.Errorf("invalid address %q", s)
+		}
+		if negated {
+			a.not = append(a.not, n)
+		} else {
+			a.nets = append(a.nets, n)
+		}
+		return nil
+	})
+}
+
+func (a *addrSpec) matches(ip net.IP) bool {
+	for _, n := range a.not {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	for _, n := range a.nets {
+		if n == nil || n.Contains(ip) {
+			return true
+		}
+	}
+	return len(a.nets) == 0
+}
+
+// portSpec is a port specification, matching ports in one of ranges (or
+// any port, if there are none) and in none of not.
+type portSpec struct {
+	ranges, not [][2]int
+}
+
+func (p *portSpec) parse(s string, vars Vars) error {
+	return expand(s, vars, false, 0, func(s string, negated bool) error {
+		r := [2]int{0, 65535}
+		if s != "any" {
+			lo, hi := s, s
+			if colon := strings.IndexByte(s, ':'); colon >= 0 {
+				lo, hi = s[:colon], s[colon+1:]
+			}
+			for i, v := range []string{lo, hi} {
+				if v == "" {
+					continue
+				}
+				n, err := strconv.ParseUint(v, 10, 16)
+				if err != nil {
+					return fmt.Errorf("invalid port %q", s)
+				}
+				r[i] = int(n)
+			}
+		}
+		if negated {
+			p.not = append(p.not, r)
+		} else {
+			p.ranges = append(p.ranges, r)
+		}
+		return nil
+	})
+}
+
+func (p *portSpec) any() bool {
+	if len(p.not) > 0 {
+		return false
+	}
+	for _, r := range p.ranges {
+		if r == [2]int{0, 65535} {
+			return true
+		}
+	}
+	return len(p.ranges) == 0
+}
+
+func (p *portSpec) matches(port int) bool {
+	for _, r := range p.not {
+		if port >= r[0] && port <= r[1] {
+			return false
+		}
+	}
+	for _, r := range p.ranges {
+		if port >= r[0] && port <= r[1] {
+			return true
+		}
+	}
+	return len(p.ranges) == 0
+}
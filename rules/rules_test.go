@@ -0,0 +1,348 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package rules
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+var (
+	client = net.IP{10, 0, 0, 1}
+	server = net.IP{192, 168, 1, 1}
+)
+
+type testPacket struct {
+	src, dst     net.IP
+	sport, dport uint16
+	udp          bool
+	flags        string
+	payload      string
+}
+
+func (tp testPacket) packet(t *testing.T) gopacket.Packet {
+	if tp.src == nil {
+		tp.src, tp.dst = client, server
+	}
+	if tp.sport == 0 {
+		tp.sport, tp.dport = 40000, 80
+	}
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: tp.src, DstIP: tp.dst}
+	var transport gopacket.SerializableLayer
+	if tp.udp {
+		ip.Protocol = layers.IPProtocolUDP
+		udp := &layers.UDP{SrcPort: layers.UDPPort(tp.sport), DstPort: layers.UDPPort(tp.dport)}
+		udp.SetNetworkLayerForChecksum(ip)
+		transport = udp
+	} else {
+		tcp := &layers.TCP{SrcPort: layers.TCPPort(tp.sport), DstPort: layers.TCPPort(tp.dport), Window: 1024}
+		if tp.flags == "" {
+			tp.flags = "PA"
+		}
+		tcp.SYN = strings.Contains(tp.flags, "S")
+		tcp.ACK = strings.Contains(tp.flags, "A")
+		tcp.PSH = strings.Contains(tp.flags, "P")
+		tcp.FIN = strings.Contains(tp.flags, "F")
+		tcp.SetNetworkLayerForChecksum(ip)
+		transport = tcp
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, transport, gopacket.Payload(tp.payload)); err != nil {
+		t.Fatal(err)
+	}
+	return gopacket.NewPacket(buf.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+}
+
+var vars = Vars{
+	"HOME_NET":     "[192.168.0.0/16,172.16.0.0/12]",
+	"EXTERNAL_NET": "!$HOME_NET",
+	"HTTP_PORTS":   "[80,8000:8080]",
+}
+
+func mustParse(t *testing.T, text string) *Rule {
+	r, err := Parse(text, vars)
+	if err != nil {
+		t.Fatalf("%s: %v", text, err)
+	}
+	return r
+}
+
+// matches returns whether a rule, given its options, matches a packet.
+func matches(t *testing.T, options string, tp testPacket) bool {
+	e := NewEngine([]*Rule{mustParse(t, "alert tcp any any -> any any ("+options+" sid:1;)")}, Options{})
+	return len(e.MatchPacket(tp.packet(t))) > 0
+}
+
+func TestParse(t *testing.T) {
+	r := mustParse(t, `alert http $EXTERNAL_NET any -> $HOME_NET $HTTP_PORTS (msg:"test \"quoted\"; rule"; `+
+		`flow:established,to_server; http.uri; content:"/admin"; classtype:web-application-attack; sid:1000; rev:2;)`)
+	if r.Action != "alert" || r.Protocol != "http" || r.Msg != `test "quoted"; rule` || r.SID != 1000 || r.Rev != 2 || r.Classtype != "web-application-attack" {
+		t.Errorf("got %+v", r)
+	}
+	if r.String() != `[1000:2] test "quoted"; rule` {
+		t.Errorf("got %q", r.String())
+	}
+	if len(r.matches) != 1 || r.matches[0].buffer != bufHTTPURI || r.fast != 0 {
+		t.Errorf("got matches %+v", r.matches)
+	}
+	if !r.dst.matches(net.IP{192, 168, 1, 1}) || r.dst.matches(net.IP{10, 0, 0, 1}) || r.src.matches(net.IP{172, 16, 0, 1}) || !r.src.matches(net.IP{8, 8, 8, 8}) {
+		t.Error("wrong address matches")
+	}
+	if !r.dstPorts.matches(80) || !r.dstPorts.matches(8008) || r.dstPorts.matches(443) {
+		t.Error("wrong port matches")
+	}
+
+	for _, text := range []string{
+		`alert tcp any any -> any any (content:"x";)`,
+		`alert tcp any any -> any any (content:"x"; sid:1; byte_test:1,>,1,0;)`,
+		`alert tcp any any any any (sid:1;)`,
+		`alert sctp any any -> any any (sid:1;)`,
+		`alert tcp !any any -> any any (sid:1;)`,
+		`alert tcp $UNDEFINED any -> any any (sid:1;)`,
+		`alert tcp any any -> any 70000 (sid:1;)`,
+		`alert icmp any 80 -> any any (sid:1;)`,
+		`alert tcp any any -> any any (nocase; sid:1;)`,
+		`alert tcp any any -> any any (content:"x"; offset:1; distance:2; sid:1;)`,
+		`alert tcp any any -> any any (content:"xyz"; depth:2; sid:1;)`,
+		`alert tcp any any -> any any (content:"|4"; sid:1;)`,
+		`alert tcp any any -> any any (pcre:"/a(?=b)/"; sid:1;)`,
+		`alert tcp any any -> any any (flow:to_server,to_client; sid:1;)`,
+		`alert tcp any any -> any any (content:"x"; sid:1)`,
+	} {
+		if _, err := Parse(text, vars); err == nil {
+			t.Errorf("%s: expected error", text)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	text := `# A comment.
+
+alert tcp any any -> any any (msg:"one"; \
+  content:"a"; sid:1;)
+alert tcp any any -> any any (msg:"bad"; unknown; sid:2;)
+alert udp any any -> any any (msg:"three"; sid:3;)
+`
+	rules, err := ParseRules(strings.NewReader(text), nil)
+	if len(rules) != 2 || rules[0].Msg != "one" || rules[1].Msg != "three" {
+		t.Errorf("got rules %v", rules)
+	}
+	errs, ok := err.(ParseErrors)
+	if !ok || len(errs) != 1 || errs[0].Line != 5 || !strings.Contains(errs[0].Error(), "unknown") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestContent(t *testing.T) {
+	for _, c := range []struct {
+		options, payload string
+		want             bool
+	}{
+		{`content:"GET";`, "GET / HTTP/1.0", true},
+		{`content:"get";`, "GET / HTTP/1.0", false},
+		{`content:"get"; nocase;`, "GET / HTTP/1.0", true},
+		{`content:"|47 45|T";`, "GET / HTTP/1.0", true},
+		{`content:"|474554|";`, "GET", true},
+		{`content:"/"; offset:4; depth:1;`, "GET / HTTP/1.0", true},
+		{`content:"/"; offset:5; depth:4;`, "GET / HTTP/1.0", false},
+		{`content:"GET"; depth:3;`, "xGET", false},
+		{`content:"a"; content:"b"; distance:0; within:1;`, "a-a b ab", true},
+		{`content:"a"; content:"b"; distance:0; within:1;`, "a-a b a", false},
+		{`content:"a"; content:"b"; distance:2;`, "abba", false},
+		{`content:"b"; content:"a"; distance:-2; within:1;`, "ab", true},
+		{`content:"secret"; content:!"ok";`, "secret", true},
+		{`content:"secret"; content:!"ok";`, "secret ok", false},
+		{`content:"user="; pcre:"/^[a-z]{3,}\d/R";`, "user=abc1", true},
+		{`content:"user="; pcre:"/^[a-z]{3,}\d/R";`, "user=ab1", false},
+		{`pcre:"/passw(or)?d/i";`, "PASSWORD", true},
+		{`pcre:!"/passw(or)?d/i";`, "PASSWORD", false},
+		{`content:"abc";`, "", false},
+		{`content:"x"; fast_pattern; content:"longer pattern";`, "longer pattern x", true},
+	} {
+		if got := matches(t, c.options, testPacket{payload: c.payload}); got != c.want {
+			t.Errorf("%s on %q: got %v, want %v", c.options, c.payload, got, c.want)
+		}
+	}
+}
+
+func TestMatchLimit(t *testing.T) {
+	const options = `content:"a"; content:"a"; distance:0; content:"a"; distance:0; content:"a"; distance:0; content:"b"; distance:0;`
+	r := mustParse(t, "alert tcp any any -> any any ("+options+" sid:1;)")
+	payload := strings.Repeat("a", 400)
+	var bufs [numBuffers][]byte
+	bufs[bufPayload] = []byte(payload)
+	// Matching stops after trying limit matches.
+	limit := 100
+	if matchFrom(r.matches, &bufs, [numBuffers]int{}, &limit) || limit != 0 {
+		t.Fatalf("got a match or %d matches left", limit)
+	}
+	// Without a limit, this takes minutes.
+	start := time.Now()
+	if matches(t, options, testPacket{payload: payload}) {
+		t.Error("matched without a b")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("matching took %v", d)
+	}
+	if !matches(t, options, testPacket{payload: payload + "b"}) {
+		t.Error("no match with a b")
+	}
+}
+
+func TestHeader(t *testing.T) {
+	for _, c := range []struct {
+		header, options string
+		tp              testPacket
+		want            bool
+	}{
+		{"tcp any any -> any 80", "", testPacket{}, true},
+		{"tcp any any -> any !80", "", testPacket{}, false},
+		{"tcp any any -> any :1024", "", testPacket{}, true},
+		{"tcp any 1024: -> any any", "", testPacket{}, true},
+		{"udp any any -> any any", "", testPacket{}, false},
+		{"udp any any -> any 53", "", testPacket{udp: true, dport: 53, sport: 5353}, true},
+		{"ip any any -> any any", "", testPacket{udp: true}, true},
+		{"tcp $HOME_NET any -> any any", "", testPacket{}, false},
+		{"tcp $HOME_NET any <> any any", "", testPacket{}, true},
+		{"tcp $HOME_NET 80 <> any any", "", testPacket{}, true},
+		{"tcp $HOME_NET 40000 <> any any", "", testPacket{}, false},
+		{"tcp 10.0.0.0/24 any -> [192.168.1.1,!192.168.1.2] any", "", testPacket{}, true},
+		{"tcp any any -> any any", "dsize:5;", testPacket{payload: "hello"}, true},
+		{"tcp any any -> any any", "dsize:>5;", testPacket{payload: "hello"}, false},
+		{"tcp any any -> any any", "dsize:<6;", testPacket{payload: "hello"}, true},
+		{"tcp any any -> any any", "dsize:4<>6;", testPacket{payload: "hello"}, true},
+		{"tcp any any -> any any", "dsize:5<>6;", testPacket{payload: "hello"}, false},
+		{"tcp any any -> any any", "flags:S;", testPacket{flags: "S"}, true},
+		{"tcp any any -> any any", "flags:S;", testPacket{flags: "SA"}, false},
+		{"tcp any any -> any any", "flags:S+;", testPacket{flags: "SA"}, true},
+		{"tcp any any -> any any", "flags:*SF;", testPacket{flags: "FA"}, true},
+		{"tcp any any -> any any", "flags:!S;", testPacket{flags: "FA"}, true},
+		{"tcp any any -> any any", "flags:SA,P;", testPacket{flags: "SAP"}, true},
+		{"udp any any -> any any", "flags:0;", testPacket{udp: true}, false},
+	} {
+		r := mustParse(t, "alert "+c.header+" ("+c.options+" sid:1;)")
+		got := len(NewEngine([]*Rule{r}, Options{}).MatchPacket(c.tp.packet(t))) > 0
+		if got != c.want {
+			t.Errorf("%s (%s): got %v, want %v", c.header, c.options, got, c.want)
+		}
+	}
+}
+
+// clientHello returns a TLS ClientHello with the given server name.
+func clientHello(name string) string {
+	// Extension type and length, list length, name type and length.
+	sni := make([]byte, 9, 9+len(name))
+	binary.BigEndian.PutUint16(sni[2:], uint16(5+len(name)))
+	binary.BigEndian.PutUint16(sni[4:], uint16(3+len(name)))
+	binary.BigEndian.PutUint16(sni[7:], uint16(len(name)))
+	sni = append(sni, name...)
+	exts := append([]byte{0xff, 0x01, 0, 1, 0}, sni...)
+	hello := make([]byte, 34, 128)
+	hello[0], hello[1] = 3, 3
+	hello = append(hello, 0, 0, 2, 0x13, 0x01, 1, 0, byte(len(exts)>>8), byte(len(exts)))
+	hello = append(hello, exts...)
+	hs := append([]byte{1, 0, byte(len(hello) >> 8), byte(len(hello))}, hello...)
+	return string(append([]byte{22, 3, 1, byte(len(hs) >> 8), byte(len(hs))}, hs...))
+}
+
+func TestApplicationBuffers(t *testing.T) {
+	request := "POST /login.php?user=admin HTTP/1.1\r\nHost: Example.COM\r\nUser-Agent: sqlmap/1.0\r\n" +
+		"Cookie: session=1\r\nContent-Length: 3\r\n\r\nabc" +
+		"GET /second HTTP/1.1\r\nHost: other.org\r\n\r\n"
+	for _, c := range []struct {
+		rule, payload string
+		want          bool
+	}{
+		{`alert http any any -> any any (http.uri; content:"/login.php"; depth:10; sid:1;)`, request, true},
+		{`alert http any any -> any any (http.method; content:"POST"; http.uri; content:"user=admin"; sid:1;)`, request, true},
+		{`alert http any any -> any any (http.method; content:"GET"; http.uri; content:"user=admin"; sid:1;)`, request, false},
+		{`alert http any any -> any any (http.uri; content:"/second"; http.host; content:"other.org"; sid:1;)`, request, true},
+		{`alert http any any -> any any (http.host; content:"example.com"; sid:1;)`, request, true},
+		{`alert http any any -> any any (content:"sqlmap"; http_user_agent; sid:1;)`, request, true},
+		{`alert http any any -> any any (content:"session="; http_cookie; sid:1;)`, request, true},
+		{`alert http any any -> any any (http.header; content:"Content-Length|3a| 3|0d 0a|"; sid:1;)`, request, true},
+		{`alert http any any -> any any (http.header; content:"POST"; sid:1;)`, request, false},
+		{`alert tcp any any -> any any (content:"/login.php"; http_uri; sid:1;)`, "not http", false},
+		{`alert http any any -> any any (content:"abc"; sid:1;)`, "abc", false},
+		{`alert tls any any -> any any (tls.sni; content:"evil.example"; sid:1;)`, clientHello("www.evil.example"), true},
+		{`alert tls any any -> any any (tls.sni; content:"www"; depth:3; sid:1;)`, clientHello("www.evil.example"), true},
+		{`alert tls any any -> any any (tls.sni; content:"evil"; depth:4; sid:1;)`, clientHello("www.evil.example"), false},
+		{`alert tcp any any -> any any (tls.sni; content:"evil"; sid:1;)`, "evil", false},
+	} {
+		e := NewEngine([]*Rule{mustParse(t, c.rule)}, Options{})
+		alerts := e.MatchPacket(testPacket{payload: c.payload}.packet(t))
+		if got := len(alerts) > 0; got != c.want {
+			t.Errorf("%s: got %v, want %v", c.rule, got, c.want)
+		}
+		if len(alerts) > 1 {
+			t.Errorf("%s: got %d alerts", c.rule, len(alerts))
+		}
+	}
+}
+
+func TestFlow(t *testing.T) {
+	established := mustParse(t, `alert tcp any any -> any any (msg:"established"; flow:established,to_server; content:"x"; sid:1;)`)
+	notEstablished := mustParse(t, `alert tcp any any -> any any (msg:"not established"; flow:not_established; sid:2;)`)
+	toClient := mustParse(t, `alert tcp any any -> any any (msg:"to client"; flow:to_client; content:"x"; sid:3;)`)
+	e := NewEngine([]*Rule{established, notEstablished, toClient}, Options{})
+	reply := func(tp testPacket) testPacket {
+		tp.src, tp.dst, tp.sport, tp.dport = server, client, 80, 40000
+		return tp
+	}
+	now := time.Now()
+	for i, c := range []struct {
+		tp   testPacket
+		want []*Rule
+	}{
+		// A connection seen from the middle.
+		{testPacket{sport: 1, dport: 2, payload: "x"}, []*Rule{notEstablished}},
+		{testPacket{flags: "S"}, []*Rule{notEstablished}},
+		{reply(testPacket{flags: "SA"}), []*Rule{notEstablished}},
+		{testPacket{flags: "A"}, nil},
+		{testPacket{payload: "x"}, []*Rule{established}},
+		{reply(testPacket{payload: "x"}), []*Rule{toClient}},
+	} {
+		p := c.tp.packet(t)
+		p.Metadata().Timestamp = now
+		alerts := e.MatchPacket(p)
+		var got []*Rule
+		for _, a := range alerts {
+			got = append(got, a.Rule)
+			if a.Packet != p || !a.Timestamp.Equal(now) || a.Network != p.NetworkLayer().NetworkFlow() || a.Transport != p.TransportLayer().TransportFlow() {
+				t.Errorf("packet %d: got alert %+v", i, a)
+			}
+		}
+		if len(got) != len(c.want) || (len(got) > 0 && got[0] != c.want[0]) {
+			t.Errorf("packet %d: got alerts %v, want %v", i, got, c.want)
+		}
+	}
+	if n := e.FlushOlderThan(now.Add(time.Second)); n != 1 {
+		t.Errorf("flushed %d flows", n)
+	}
+}
+
+func TestAhoCorasick(t *testing.T) {
+	m := newACMatcher([][]byte{[]byte("he"), []byte("She"), []byte("his"), []byte("hers")})
+	found := map[int]int{}
+	state := m.match(0, []byte("ushers and hi"), func(id int) { found[id]++ })
+	m.match(state, []byte("s"), func(id int) { found[id]++ })
+	if found[0] != 1 || found[1] != 1 || found[2] != 1 || found[3] != 1 {
+		t.Errorf("got %v", found)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package rules
+
+import (
+	"net"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+// StreamFactory is a reassembly.StreamFactory matching the data of TCP
+// streams against the rules of an Engine.  Create one with
+// Engine.NewStreamFactory.
+//
+// Each direction of a stream is matched as a whole, up to the engine's
+// StreamDepth, and each rule alerts at most once for it.  Rules using dsize,
+// flags or flow:no_stream don't match streams, and the flow of streams is
+// always established.  Inspection of a direction stops at the first gap in
+// its data.
+type StreamFactory struct {
+	engine  *Engine
+	handler func(Alert)
+}
+
+// NewStreamFactory returns a StreamFactory calling handler with the alerts
+// of the streams it creates.  handler is called from the goroutines
+// assembling streams.
+func (e *Engine) NewStreamFactory(handler func(Alert)) *StreamFactory {
+	return &StreamFactory{engine: e, handler: handler}
+}
+
+// New creates a Stream, implementing reassembly.StreamFactory.
+func (f *StreamFactory) New(netFlow, tcpFlow gopacket.Flow, tcp *layers.TCP, ac reassembly.AssemblerContext) reassembly.Stream {
+	s := &stream{factory: f, network: netFlow, transport: tcpFlow}
+	for i := range s.halves {
+		s.halves[i].alerted = make([]bool, len(f.engine.rules))
+	}
+	return s
+}
+
+// halfStream is one direction of a stream.
+type halfStream struct {
+	data []byte
+	// state is the state of the payload matcher at the end of data, and
+	// cand the rules whose fast pattern it found.
+	state   int32
+	cand    []bool
+	alerted []bool
+	done    bool
+}
+
+type stream struct {
+	factory            *StreamFactory
+	network, transport gopacket.Flow
+	halves             [2]halfStream
+}
+
+func (s *stream) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir reassembly.TCPFlowDirection, nextSeq reassembly.Sequence, start *bool, ac reassembly.AssemblerContext) bool {
+	return true
+}
+
+func (s *stream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.AssemblerContext) {
+	e := s.factory.engine
+	dir, _, _, skip := sg.Info()
+	half := &s.halves[0]
+	if dir == reassembly.TCPDirServerToClient {
+		half = &s.halves[1]
+	}
+	if half.done {
+		return
+	}
+	if skip > 0 {
+		half.done = true
+		return
+	}
+	length, _ := sg.Lengths()
+	if length == 0 {
+		return
+	}
+	if room := e.opts.StreamDepth - len(half.data); length >= room {
+		length, half.done = room, true
+	}
+	data := sg.Fetch(length)
+	half.data = append(half.data, data...)
+	if half.cand == nil {
+		half.cand = append([]bool(nil), e.always...)
+	}
+	if m := e.matchers[bufPayload]; m != nil {
+		rules := e.patterns[bufPayload]
+		half.state = m.match(half.state, data, func(id int) { half.cand[rules[id]] = true })
+	}
+
+	network, transport := s.network, s.transport
+	if dir == reassembly.TCPDirServerToClient {
+		network, transport = network.Reverse(), transport.Reverse()
+	}
+	src, dst := network.Endpoints()
+	sport, dport := transport.Endpoints()
+	in := inspection{
+		proto:       layers.IPProtocolTCP,
+		src:         net.IP(src.Raw()),
+		dst:         net.IP(dst.Raw()),
+		sport:       portOf(sport),
+		dport:       portOf(dport),
+		stream:      true,
+		flowKnown:   true,
+		established: true,
+		toServer:    dir == reassembly.TCPDirClientToServer,
+	}
+	in.bufs[bufPayload] = half.data
+	var ci gopacket.CaptureInfo
+	if ac != nil {
+		ci = ac.GetCaptureInfo()
+	}
+	e.detect(&in, append([]bool(nil), half.cand...), half.alerted, func(r *Rule) {
+		s.factory.handler(Alert{
+			Rule:      r,
+			Timestamp: ci.Timestamp,
+			Network:   network,
+			Transport: transport,
+			ToServer:  in.toServer,
+		})
+	})
+}
+
+func portOf(e gopacket.Endpoint) int {
+	raw := e.Raw()
+	if len(raw) != 2 {
+		return 0
+	}
+	return int(raw[0])<<8 | int(raw[1])
+}
+
+func (s *stream) ReassemblyComplete(ac reassembly.AssemblerContext) bool {
+	return true
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package rules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+type testContext gopacket.CaptureInfo
+
+func (c *testContext) GetCaptureInfo() gopacket.CaptureInfo {
+	return gopacket.CaptureInfo(*c)
+}
+
+func TestStream(t *testing.T) {
+	rules := []*Rule{
+		mustParse(t, `alert tcp any any -> any 80 (msg:"split"; flow:to_server; content:"attack"; content:"payload"; distance:1; sid:1;)`),
+		mustParse(t, `alert http any any -> any any (msg:"uri"; http.uri; content:"/evil"; sid:2;)`),
+		mustParse(t, `alert tcp any 80 -> any any (msg:"response"; flow:to_client,only_stream; content:"200 OK"; sid:3;)`),
+		mustParse(t, `alert tcp any any -> any any (msg:"packets only"; flow:no_stream; content:"attack"; sid:4;)`),
+		mustParse(t, `alert tcp any any -> any any (msg:"dsize"; dsize:>0; sid:5;)`),
+		mustParse(t, `alert tcp any any -> any any (msg:"too deep"; content:"beyond"; sid:6;)`),
+	}
+	e := NewEngine(rules, Options{StreamDepth: 64})
+	var alerts []Alert
+	assembler := reassembly.NewAssembler(reassembly.NewStreamPool(e.NewStreamFactory(func(a Alert) {
+		alerts = append(alerts, a)
+	})))
+
+	start := time.Unix(1000, 0)
+	seq := map[bool]uint32{true: 100, false: 500}
+	send := func(toServer bool, flags string, payload string) {
+		tp := testPacket{flags: flags, payload: payload}
+		if !toServer {
+			tp.src, tp.dst, tp.sport, tp.dport = server, client, 80, 40000
+		}
+		p := tp.packet(t)
+		tcp := p.TransportLayer().(*layers.TCP)
+		tcp.Seq = seq[toServer]
+		tcp.Ack = seq[!toServer]
+		seq[toServer] += uint32(len(payload))
+		if tcp.SYN {
+			seq[toServer]++
+		}
+		start = start.Add(time.Millisecond)
+		ci := testContext{Timestamp: start}
+		assembler.AssembleWithContext(p.NetworkLayer().NetworkFlow(), tcp, &ci)
+	}
+	send(true, "S", "")
+	send(false, "SA", "")
+	send(true, "A", "")
+	send(true, "PA", "GET /evil HTTP/1.1\r\nHost: x\r\n\r\nsome att")
+	send(true, "PA", "ack and payload, and then something beyond")
+	send(false, "PA", "HTTP/1.1 200 OK\r\n\r\n")
+	assembler.FlushAll()
+
+	want := map[int]bool{1: true, 2: true, 3: true}
+	if len(alerts) != len(want) {
+		t.Errorf("got %d alerts, want %d", len(alerts), len(want))
+	}
+	for _, a := range alerts {
+		if !want[a.Rule.SID] {
+			t.Errorf("unexpected alert %v", a.Rule)
+			continue
+		}
+		if a.Packet != nil || a.Timestamp.IsZero() {
+			t.Errorf("got alert %+v", a)
+		}
+		wantNet := gopacket.NewFlow(layers.EndpointIPv4, client.To4(), server.To4())
+		if a.ToServer != (a.Rule.SID != 3) || (a.ToServer && a.Network != wantNet) || (!a.ToServer && a.Network != wantNet.Reverse()) {
+			t.Errorf("alert %v: got flow %v, to server %v", a.Rule, a.Network, a.ToServer)
+		}
+	}
+}
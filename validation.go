@@ -0,0 +1,179 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package gopacket
+
+// ChecksumStatus is the result of verifying a layer's checksum.
+type ChecksumStatus uint8
+
+const (
+	// ChecksumNotVerified means the checksum wasn't checked, either because
+	// the layer has no checksum (or it's optional and absent), the data
+	// needed to compute it isn't available, or the packet was truncated.
+	ChecksumNotVerified ChecksumStatus = iota
+	// ChecksumValid means the checksum in the packet matches the computed one.
+	ChecksumValid
+	// ChecksumInvalid means the checksum in the packet doesn't match the
+	// computed one.
+	ChecksumInvalid
+)
+
+func (c ChecksumStatus) String() string {
+	switch c {
+	case ChecksumNotVerified:
+		return "NotVerified"
+	case ChecksumValid:
+		return "Valid"
+	case ChecksumInvalid:
+		return "Invalid"
+	}
+	return "Unknown"
+}
+
+// LengthStatus is the result of comparing a layer's declared length with the
+// number of bytes actually available to it.
+type LengthStatus uint8
+
+const (
+	// LengthNotVerified means the layer has no length field to verify.
+	LengthNotVerified LengthStatus = iota
+	// LengthValid means the declared length matches the actual length.
+	LengthValid
+	// LengthTruncated means fewer bytes are available than declared.
+	LengthTruncated
+	// LengthExceeded means more bytes are available than declared.
+	LengthExceeded
+)
+
+func (l LengthStatus) String() string {
+	switch l {
+	case LengthNotVerified:
+		return "NotVerified"
+	case LengthValid:
+		return "Valid"
+	case LengthTruncated:
+		return "Truncated"
+	case LengthExceeded:
+		return "Exceeded"
+	}
+	return "Unknown"
+}
+
+// LayerValidation is the checksum and length validation report for a single
+// layer of a packet.
+type LayerValidation struct {
+	// Layer is the layer which was validated.
+	Layer Layer
+	// Checksum is the result of verifying the layer's checksum.
+	Checksum ChecksumStatus
+	// ComputedChecksum and ReceivedChecksum are the checksum computed over
+	// the layer's data and the checksum carried in the packet.  They're only
+	// meaningful if Checksum isn't ChecksumNotVerified.
+	ComputedChecksum, ReceivedChecksum uint32
+	// Length is the result of comparing the layer's declared length with the
+	// actual number of bytes available to it.
+	Length LengthStatus
+	// DeclaredLength and ActualLength are the length declared in the layer's
+	// header and the actual length of the layer's data, in bytes.  They're
+	// only meaningful if Length isn't LengthNotVerified.
+	DeclaredLength, ActualLength int
+}
+
+// SetChecksum sets Checksum, ComputedChecksum and ReceivedChecksum by
+// comparing a computed checksum with the received one.
+func (v *LayerValidation) SetChecksum(computed, received uint32) {
+	v.ComputedChecksum, v.ReceivedChecksum = computed, received
+	if computed == received {
+		v.Checksum = ChecksumValid
+	} else {
+		v.Checksum = ChecksumInvalid
+	}
+}
+
+// SetLength sets Length, DeclaredLength and ActualLength by comparing the
+// declared length with the actual one.
+func (v *LayerValidation) SetLength(declared, actual int) {
+	v.DeclaredLength, v.ActualLength = declared, actual
+	switch {
+	case declared == actual:
+		v.Length = LengthValid
+	case declared > actual:
+		v.Length = LengthTruncated
+	default:
+		v.Length = LengthExceeded
+	}
+}
+
+// Valid returns false if the layer's checksum or length is known to be bad.
+func (v LayerValidation) Valid() bool {
+	return v.Checksum != ChecksumInvalid && (v.Length == LengthNotVerified || v.Length == LengthValid)
+}
+
+// ValidatingLayer is implemented by layers which can verify their own
+// checksums and declared lengths.  It's used to fill in
+// PacketMetadata.Validations when DecodeOptions.VerifyChecksums is set.
+//
+// Layers whose checksum covers a pseudo-header (TCP, UDP, ICMPv6...) also
+// implement SetNetworkLayerForChecksum; it's called with the closest preceding
+// network layer in the packet before Validate.
+type ValidatingLayer interface {
+	Layer
+	// Validate verifies the layer's checksum and length.  The returned
+	// LayerValidation's Layer field needn't be set.
+	Validate() LayerValidation
+}
+
+type networkLayerForChecksumSetter interface {
+	SetNetworkLayerForChecksum(NetworkLayer) error
+}
+
+// Validation returns the validation report for the given layer of the packet,
+// or false if it has none.
+func (m *PacketMetadata) Validation(l Layer) (LayerValidation, bool) {
+	for _, v := range m.Validations {
+		if v.Layer == l {
+			return v, true
+		}
+	}
+	return LayerValidation{}, false
+}
+
+// Valid returns false if any layer of the packet had a bad checksum or length.
+func (m *PacketMetadata) Valid() bool {
+	for _, v := range m.Validations {
+		if !v.Valid() {
+			return false
+		}
+	}
+	return true
+}
+
+// validateLayers fills in metadata.Validations for every ValidatingLayer in the
+// packet.
+func (p *packet) validateLayers() {
+	p.metadata.Validations = p.metadata.Validations[:0]
+	var network NetworkLayer
+	truncated := false
+	for _, l := range p.layers {
+		if s, ok := l.(networkLayerForChecksumSetter); ok && network != nil {
+			s.SetNetworkLayerForChecksum(network)
+		}
+		if vl, ok := l.(ValidatingLayer); ok {
+			v := vl.Validate()
+			v.Layer = l
+			// A checksum covering data missing from a truncated packet can't
+			// be expected to match.
+			if truncated && v.Checksum == ChecksumInvalid {
+				v.Checksum = ChecksumNotVerified
+			}
+			truncated = truncated || v.Length == LengthTruncated
+			p.metadata.Validations = append(p.metadata.Validations, v)
+		}
+		if n, ok := l.(NetworkLayer); ok {
+			network = n
+		}
+	}
+}
@@ -0,0 +1,347 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package wire provides an in-memory network segment, for testing code which
+// captures and injects packets without real interfaces or root privileges.
+//
+// A Segment behaves like a hub: every packet written to one of its Handles is
+// received by all the others.  Handles implement the same reading and writing
+// methods as pcap.Handle, so they can be used in place of one:
+//
+//  seg := wire.NewSegment(layers.LinkTypeEthernet)
+//  a, b := seg.NewHandle(), seg.NewHandle()
+//  go func() {
+//    a.WritePacketData(frame)
+//  }()
+//  src := gopacket.NewPacketSource(b, b.LinkType())
+//  packet := <-src.Packets()
+//
+// Handles can be given a BPF filter with SetBPFFilter, which takes compiled
+// instructions (such as from golang.org/x/net/bpf.Assemble) and runs them in a
+// pure Go virtual machine.
+package wire
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/bpf"
+)
+
+// ErrTimeout is returned by ReadPacketData when no packet arrives before the
+// handle's read timeout expires.
+var ErrTimeout = errors.New("wire: read timeout expired")
+
+// ErrClosed is returned when writing to a closed handle or segment.
+var ErrClosed = errors.New("wire: closed")
+
+// DefaultBufferSize is the number of packets a handle buffers before
+// dropping them.
+const DefaultBufferSize = 1024
+
+// Segment is a simulated link, such as an Ethernet segment, connecting any
+// number of Handles.  It's safe for concurrent use.
+type Segment struct {
+	linkType layers.LinkType
+
+	mu      sync.Mutex
+	handles []*Handle
+	now     func() time.Time
+	closed  bool
+}
+
+// NewSegment creates a segment carrying packets of the given link type.
+func NewSegment(linkType layers.LinkType) *Segment {
+	return &Segment{linkType: linkType, now: time.Now}
+}
+
+// LinkType returns the segment's link type.
+func (s *Segment) LinkType() layers.LinkType {
+	return s.linkType
+}
+
+// SetClock sets the function used to timestamp packets, which defaults to
+// time.Now.
+func (s *Segment) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	s.now = now
+	s.mu.Unlock()
+}
+
+// NewHandle attaches a new handle to the segment.  Handles of a closed
+// segment are closed.
+func (s *Segment) NewHandle() *Handle {
+	h := &Handle{
+		segment:    s,
+		bufferSize: DefaultBufferSize,
+		notify:     make(chan struct{}, 1),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h.index = len(s.handles)
+	h.closed = s.closed
+	s.handles = append(s.handles, h)
+	return h
+}
+
+// Close closes the segment and all its handles.
+func (s *Segment) Close() {
+	s.mu.Lock()
+	s.closed = true
+	handles := s.handles
+	s.mu.Unlock()
+	for _, h := range handles {
+		h.Close()
+	}
+}
+
+// send delivers a packet written by a handle to all handles receiving it.
+func (s *Segment) send(from *Handle, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	// The copy is shared by all receivers, which only hand it out
+	// through ZeroCopyReadPacketData.
+	data = append([]byte(nil), data...)
+	ts := s.now()
+	for _, h := range s.handles {
+		if h != from || from.Loopback() {
+			h.receive(data, ts, from.index)
+		}
+	}
+	return nil
+}
+
+type packet struct {
+	data []byte
+	ci   gopacket.CaptureInfo
+}
+
+// Stats counts the packets seen by a handle.
+type Stats struct {
+	// PacketsReceived counts packets passing the handle's filter, and
+	// PacketsDropped those which didn't fit in its buffer.
+	PacketsReceived, PacketsDropped int
+}
+
+// Handle is an endpoint attached to a Segment, which reads the packets written
+// by the segment's other handles.  It's safe for concurrent use.
+type Handle struct {
+	segment *Segment
+	// index is the handle's position in the segment, used as the
+	// CaptureInfo.InterfaceIndex of the packets it writes.
+	index int
+	// notify is signalled when a packet is queued or the handle is
+	// closed.
+	notify chan struct{}
+
+	mu         sync.Mutex
+	queue      []packet
+	bufferSize int
+	snapLen    int
+	timeout    time.Duration
+	filter     *bpf.VM
+	loopback   bool
+	closed     bool
+	stats      Stats
+}
+
+// LinkType returns the link type of the handle's segment.
+func (h *Handle) LinkType() layers.LinkType {
+	return h.segment.linkType
+}
+
+// SetBPFFilter sets a BPF program filtering the packets the handle receives,
+// which are kept if it returns a non-zero value, and truncated to that length.
+// An empty program removes the filter.
+func (h *Handle) SetBPFFilter(filter []bpf.RawInstruction) error {
+	var vm *bpf.VM
+	if len(filter) > 0 {
+		insts, ok := bpf.Disassemble(filter)
+		if !ok {
+			return errors.New("wire: BPF filter has invalid instructions")
+		}
+		var err error
+		if vm, err = bpf.NewVM(insts); err != nil {
+			return err
+		}
+	}
+	h.mu.Lock()
+	h.filter = vm
+	h.mu.Unlock()
+	return nil
+}
+
+// SetSnapLen sets the maximum number of bytes captured of each packet.  Zero
+// or negative values capture whole packets.
+func (h *Handle) SetSnapLen(snapLen int) {
+	h.mu.Lock()
+	h.snapLen = snapLen
+	h.mu.Unlock()
+}
+
+// SetTimeout sets how long reads wait for a packet before returning
+// ErrTimeout.  Zero or negative values wait forever.
+func (h *Handle) SetTimeout(timeout time.Duration) {
+	h.mu.Lock()
+	h.timeout = timeout
+	h.mu.Unlock()
+}
+
+// SetBufferSize sets how many packets the handle buffers before dropping
+// packets.
+func (h *Handle) SetBufferSize(size int) {
+	h.mu.Lock()
+	h.bufferSize = size
+	h.mu.Unlock()
+}
+
+// SetLoopback sets whether the handle receives the packets it writes itself.
+func (h *Handle) SetLoopback(loopback bool) {
+	h.mu.Lock()
+	h.loopback = loopback
+	h.mu.Unlock()
+}
+
+// Loopback returns whether the handle receives the packets it writes itself.
+func (h *Handle) Loopback() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.loopback
+}
+
+// Stats returns the handle's packet counters.
+func (h *Handle) Stats() Stats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.stats
+}
+
+// receive filters and queues a packet.  data is shared, and must not be
+// modified.
+func (h *Handle) receive(data []byte, ts time.Time, from int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	ci := gopacket.CaptureInfo{
+		Timestamp:      ts,
+		CaptureLength:  len(data),
+		Length:         len(data),
+		InterfaceIndex: from,
+	}
+	if h.filter != nil {
+		n, err := h.filter.Run(data)
+		if err != nil || n == 0 {
+			return
+		}
+		if n < ci.CaptureLength {
+			ci.CaptureLength = n
+		}
+	}
+	if h.snapLen > 0 && h.snapLen < ci.CaptureLength {
+		ci.CaptureLength = h.snapLen
+	}
+	h.stats.PacketsReceived++
+	if len(h.queue) >= h.bufferSize {
+		h.stats.PacketsDropped++
+		return
+	}
+	h.queue = append(h.queue, packet{data[:ci.CaptureLength], ci})
+	h.signal()
+}
+
+func (h *Handle) signal() {
+	select {
+	case h.notify <- struct{}{}:
+	default:
+	}
+}
+
+// next waits for the next packet.
+func (h *Handle) next() (packet, error) {
+	var timeout <-chan time.Time
+	for {
+		h.mu.Lock()
+		if len(h.queue) > 0 {
+			p := h.queue[0]
+			h.queue[0] = packet{}
+			h.queue = h.queue[1:]
+			if len(h.queue) > 0 {
+				// Wake up any other reader.
+				h.signal()
+			}
+			h.mu.Unlock()
+			return p, nil
+		}
+		if h.closed {
+			h.signal()
+			h.mu.Unlock()
+			return packet{}, io.EOF
+		}
+		if timeout == nil && h.timeout > 0 {
+			timer := time.NewTimer(h.timeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		h.mu.Unlock()
+		select {
+		case <-h.notify:
+		case <-timeout:
+			return packet{}, ErrTimeout
+		}
+	}
+}
+
+// ReadPacketData returns the next packet received by the handle, waiting for
+// one if necessary.  It returns ErrTimeout if the handle's timeout expires,
+// and io.EOF once the handle is closed and all buffered packets have been
+// read.
+func (h *Handle) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	p, err := h.next()
+	if err != nil {
+		return nil, gopacket.CaptureInfo{}, err
+	}
+	return append([]byte(nil), p.data...), p.ci, nil
+}
+
+// ZeroCopyReadPacketData is like ReadPacketData, but the returned data is
+// only valid until the next call, and must not be modified.
+func (h *Handle) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	p, err := h.next()
+	if err != nil {
+		return nil, gopacket.CaptureInfo{}, err
+	}
+	return p.data, p.ci, nil
+}
+
+// WritePacketData sends a packet to the segment.  The data is copied, so it
+// may be reused once WritePacketData returns.
+func (h *Handle) WritePacketData(data []byte) error {
+	h.mu.Lock()
+	closed := h.closed
+	h.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	return h.segment.send(h, data)
+}
+
+// Close stops the handle from sending and receiving packets.  Pending reads return the
+// packets still buffered, then io.EOF.
+func (h *Handle) Close() {
+	h.mu.Lock()
+	h.closed = true
+	h.mu.Unlock()
+	h.signal()
+}
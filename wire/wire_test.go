@@ -0,0 +1,189 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package wire
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/bpf"
+)
+
+var (
+	macA = net.HardwareAddr{0, 0, 0, 0, 0, 0xa}
+	macB = net.HardwareAddr{0, 0, 0, 0, 0, 0xb}
+)
+
+func arpRequest(t *testing.T) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       macA,
+		DstMAC:       layers.EthernetBroadcast,
+		EthernetType: layers.EthernetTypeARP,
+	}
+	arp := &layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         layers.ARPRequest,
+		SourceHwAddress:   macA,
+		SourceProtAddress: []byte{10, 0, 0, 1},
+		DstHwAddress:      []byte{0, 0, 0, 0, 0, 0},
+		DstProtAddress:    []byte{10, 0, 0, 2},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, eth, arp); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func ipv4Frame() []byte {
+	frame := make([]byte, 60)
+	copy(frame, macA)
+	copy(frame[6:], macB)
+	frame[12], frame[13] = 0x08, 0x00
+	return frame
+}
+
+func TestSegment(t *testing.T) {
+	seg := NewSegment(layers.LinkTypeEthernet)
+	ts := time.Unix(1000, 0)
+	seg.SetClock(func() time.Time { return ts })
+	a, b, c := seg.NewHandle(), seg.NewHandle(), seg.NewHandle()
+	a.SetTimeout(time.Millisecond)
+
+	frame := arpRequest(t)
+	if err := a.WritePacketData(frame); err != nil {
+		t.Fatal(err)
+	}
+	frame[0] = 0xff // The data was copied.
+	for _, h := range []*Handle{b, c} {
+		data, ci, err := h.ReadPacketData()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, arpRequest(t)) {
+			t.Errorf("got %x", data)
+		}
+		want := gopacket.CaptureInfo{Timestamp: ts, CaptureLength: len(data), Length: len(data), InterfaceIndex: 0}
+		if ci.Timestamp != want.Timestamp || ci.CaptureLength != want.CaptureLength || ci.Length != want.Length || ci.InterfaceIndex != want.InterfaceIndex {
+			t.Errorf("got capture info %+v, want %+v", ci, want)
+		}
+	}
+	// The writer doesn't see its own packets, unless asked to.
+	if _, _, err := a.ReadPacketData(); err != ErrTimeout {
+		t.Errorf("expected timeout, got %v", err)
+	}
+	a.SetLoopback(true)
+	a.WritePacketData(frame)
+	if data, _, err := a.ZeroCopyReadPacketData(); err != nil || !bytes.Equal(data, frame) {
+		t.Errorf("loopback: got %x, %v", data, err)
+	}
+
+	// Packets decode like a real capture, and closing drains the buffers.
+	a.WritePacketData(frame)
+	seg.Close()
+	src := gopacket.NewPacketSource(b, b.LinkType())
+	var n int
+	for p := range src.Packets() {
+		n++
+		if p.Layer(layers.LayerTypeARP) == nil {
+			t.Errorf("expected ARP packet, got %v", p)
+		}
+	}
+	if n != 2 {
+		t.Errorf("got %d packets", n)
+	}
+	if err := a.WritePacketData(frame); err != ErrClosed {
+		t.Errorf("expected closed error, got %v", err)
+	}
+}
+
+func TestBPFAndSnapLen(t *testing.T) {
+	seg := NewSegment(layers.LinkTypeEthernet)
+	a, b := seg.NewHandle(), seg.NewHandle()
+	b.SetTimeout(time.Millisecond)
+	// Accept ARP frames only.
+	filter, err := bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 12, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x0806, SkipFalse: 1},
+		bpf.RetConstant{Val: 0xffff},
+		bpf.RetConstant{Val: 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetBPFFilter(filter); err != nil {
+		t.Fatal(err)
+	}
+	b.SetSnapLen(20)
+	a.WritePacketData(ipv4Frame())
+	a.WritePacketData(arpRequest(t))
+	data, ci, err := b.ReadPacketData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, arpRequest(t)[:20]) || ci.CaptureLength != 20 || ci.Length != len(arpRequest(t)) {
+		t.Errorf("got %x, capture info %+v", data, ci)
+	}
+	if _, _, err := b.ReadPacketData(); err != ErrTimeout {
+		t.Errorf("expected timeout, got %v", err)
+	}
+	if s := b.Stats(); s.PacketsReceived != 1 {
+		t.Errorf("got stats %+v", s)
+	}
+
+	if err := b.SetBPFFilter(nil); err != nil {
+		t.Fatal(err)
+	}
+	a.WritePacketData(ipv4Frame())
+	if _, _, err := b.ReadPacketData(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBufferSize(t *testing.T) {
+	seg := NewSegment(layers.LinkTypeEthernet)
+	a, b := seg.NewHandle(), seg.NewHandle()
+	b.SetBufferSize(2)
+	for i := 0; i < 5; i++ {
+		a.WritePacketData(ipv4Frame())
+	}
+	b.Close()
+	for i := 0; i < 2; i++ {
+		if _, _, err := b.ReadPacketData(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := b.ReadPacketData(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+	if s := b.Stats(); s.PacketsReceived != 5 || s.PacketsDropped != 3 {
+		t.Errorf("got stats %+v", s)
+	}
+}
+
+func TestBlockingRead(t *testing.T) {
+	seg := NewSegment(layers.LinkTypeEthernet)
+	a, b := seg.NewHandle(), seg.NewHandle()
+	done := make(chan error)
+	go func() {
+		_, _, err := b.ReadPacketData()
+		done <- err
+	}()
+	time.Sleep(time.Millisecond)
+	a.WritePacketData(ipv4Frame())
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}